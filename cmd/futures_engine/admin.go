@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"frizo/futures_engine/internal/admin"
+	"frizo/futures_engine/internal/config"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"io"
+	"net"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// adminTimeout deadline of one admin API call
+const adminTimeout = 30 * time.Second
+
+// adminCommand one subcommand of the admin API: flags adds its flags and returns the call, whose result
+// is printed as JSON or by table
+type adminCommand struct {
+	usage     string
+	dangerous bool // changes the state of the engine, refused without --confirm
+	flags     func(flags *flag.FlagSet) func(ctx context.Context, client *admin.Client) (any, error)
+	table     func(w io.Writer, result any)
+}
+
+// adminCommands subcommands talking to the admin API of a running engine
+var adminCommands = map[string]adminCommand{
+	"positions": {
+		usage: "positions --user ID\tOpen positions of a user",
+		flags: func(flags *flag.FlagSet) func(context.Context, *admin.Client) (any, error) {
+			user := flags.String("user", "", "User id")
+			return func(ctx context.Context, client *admin.Client) (any, error) {
+				if *user == "" {
+					return nil, fmt.Errorf("--user is required")
+				}
+				return client.Positions(ctx, *user)
+			}
+		},
+		table: positionsTable,
+	},
+	"accounts": {
+		usage: "accounts --user ID\tAccount of a user",
+		flags: func(flags *flag.FlagSet) func(context.Context, *admin.Client) (any, error) {
+			user := flags.String("user", "", "User id")
+			return func(ctx context.Context, client *admin.Client) (any, error) {
+				if *user == "" {
+					return nil, fmt.Errorf("--user is required")
+				}
+				return client.Account(ctx, *user)
+			}
+		},
+		table: accountTable,
+	},
+	"deposit": {
+		usage:     "deposit --user ID --amount N --confirm\tCredit an account",
+		dangerous: true,
+		flags: func(flags *flag.FlagSet) func(context.Context, *admin.Client) (any, error) {
+			user := flags.String("user", "", "User id")
+			amount := flags.Float64("amount", 0, "Amount to credit")
+			return func(ctx context.Context, client *admin.Client) (any, error) {
+				if *user == "" || *amount <= 0 {
+					return nil, fmt.Errorf("--user and a positive --amount are required")
+				}
+				return client.Deposit(ctx, *user, *amount)
+			}
+		},
+		table: accountTable,
+	},
+	"force-liquidate": {
+		usage:     "force-liquidate --position ID --confirm\tLiquidate a position now",
+		dangerous: true,
+		flags: func(flags *flag.FlagSet) func(context.Context, *admin.Client) (any, error) {
+			positionID := flags.String("position", "", "Position id")
+			return func(ctx context.Context, client *admin.Client) (any, error) {
+				if *positionID == "" {
+					return nil, fmt.Errorf("--position is required")
+				}
+				return client.ForceLiquidate(ctx, *positionID)
+			}
+		},
+		table: liquidationTable,
+	},
+	"kill-switch": {
+		usage:     "kill-switch --symbol S --mode off|close-only|halt --confirm\tSet the kill switch of a symbol",
+		dangerous: true,
+		flags: func(flags *flag.FlagSet) func(context.Context, *admin.Client) (any, error) {
+			symbol := flags.String("symbol", "", "Symbol")
+			mode := flags.String("mode", "", "off, close-only or halt")
+			reason := flags.String("reason", "", "Reason recorded with the change")
+			return func(ctx context.Context, client *admin.Client) (any, error) {
+				if *symbol == "" {
+					return nil, fmt.Errorf("--symbol is required")
+				}
+				if _, err := admin.ParseKillSwitchMode(*mode); err != nil {
+					return nil, err
+				}
+				return client.KillSwitch(ctx, *symbol, *mode, *reason)
+			}
+		},
+		table: killSwitchTable,
+	},
+	"snapshot-now": {
+		usage: "snapshot-now\tWrite a snapshot of the engine state",
+		flags: func(*flag.FlagSet) func(context.Context, *admin.Client) (any, error) {
+			return func(ctx context.Context, client *admin.Client) (any, error) {
+				return client.Snapshot(ctx)
+			}
+		},
+		table: snapshotTable,
+	},
+}
+
+// adminUsage subcommand lines of -help, in a fixed order
+var adminUsage = []string{"positions", "accounts", "deposit", "force-liquidate", "kill-switch", "snapshot-now"}
+
+// runAdmin `futures_engine <command> [flags]` call the admin API of the engine at --addr, by default the
+// one serving the configuration, and print the result as a table or JSON. return the exit code
+func runAdmin(name string, args []string, stdout, stderr io.Writer) int {
+	command := adminCommands[name]
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		addr       = flags.String("addr", "", "Engine URL, e.g. http://localhost:8080 (default: HOST and PORT of the configuration)")
+		token      = flags.String("token", "", "Admin token (default: ADMIN_TOKEN of the configuration)")
+		configFile = flags.String("config", defaultConfigFile, "Path to configuration file (.env or .yaml)")
+		format     = flags.String("format", "table", "Output format: table or json")
+		confirm    = flags.Bool("confirm", false, "Confirm a command changing the engine state")
+	)
+	call := command.flags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown format %q, expected table or json\n", *format)
+		return 2
+	}
+	if command.dangerous && !*confirm {
+		fmt.Fprintf(stderr, "%s changes the state of the running engine, run it again with --confirm\n", name)
+		return 2
+	}
+
+	if *addr == "" || *token == "" {
+		cfg, _, err := config.LoadFromFile(configPath(*configFile))
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid configuration:\n%s\n", err)
+			return 1
+		}
+		if *addr == "" {
+			*addr = "http://" + net.JoinHostPort(localHost(cfg.API.Host), strconv.Itoa(cfg.API.Port))
+		}
+		if *token == "" {
+			*token = cfg.API.AdminToken
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+	result, err := call(ctx, admin.NewClient(*addr, *token))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(result); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	command.table(w, result)
+	if err = w.Flush(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// localHost host to reach a server listening on host, localhost for the wildcard addresses
+func localHost(host string) string {
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		return "localhost"
+	}
+	return host
+}
+
+func positionsTable(w io.Writer, result any) {
+	fmt.Fprintln(w, "ID\tSYMBOL\tSIDE\tMODE\tSIZE\tENTRY\tMARK\tLIQ PRICE\tMARGIN\tLEVERAGE\tUNREALIZED PNL\tSTATUS")
+	for _, p := range result.([]position.PositionSnapshot) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%g\t%g\t%g\t%g\t%g\t%dx\t%g\t%s\n", p.ID, p.Symbol, p.Side, p.MarginMode,
+			p.Size, p.EntryPrice, p.MarkPrice, p.LiquidationPrice, p.InitialMargin, p.Leverage, p.UnrealizedPnL, p.Status)
+	}
+}
+
+func accountTable(w io.Writer, result any) {
+	a := result.(margin.AccountSnapshot)
+	fmt.Fprintln(w, "USER\tSTATUS\tBALANCE\tAVAILABLE\tPOSITION MARGIN\tORDER MARGIN\tUNREALIZED PNL\tREALIZED PNL")
+	fmt.Fprintf(w, "%s\t%s\t%g\t%g\t%g\t%g\t%g\t%g\n", a.UserID, a.Status, a.Balance, a.AvailableBalance,
+		a.PositionMargin, a.OrderMargin, a.UnrealizedPnL, a.RealizedPnL)
+}
+
+func liquidationTable(w io.Writer, result any) {
+	r := result.(liquidation.LiquidationResult)
+	fmt.Fprintln(w, "POSITION\tUSER\tSYMBOL\tSIDE\tSIZE\tCLOSE PRICE\tPNL\tFUND SIZE\tREQUEUED\tERROR")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%g\t%g\t%g\t%g\t%t\t%s\n", r.PositionID, r.UserID, r.Symbol, r.Side, r.Size,
+		r.ClosePrice, r.PnL, r.FundSize, r.Requeued, r.Error)
+}
+
+func killSwitchTable(w io.Writer, result any) {
+	e := result.(risk.KillSwitchEvent)
+	fmt.Fprintln(w, "SYMBOL\tFROM\tTO\tCANCELLED ORDERS\tREASON")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", e.Symbol, e.From, e.To, len(e.Cancelled), e.Reason)
+}
+
+func snapshotTable(w io.Writer, result any) {
+	fmt.Fprintln(w, "PATH")
+	fmt.Fprintln(w, result.(admin.SnapshotResponse).Path)
+}
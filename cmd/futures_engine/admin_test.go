@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"frizo/futures_engine/internal/admin"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"frizo/futures_engine/internal/snapshot"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminCommands(t *testing.T) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT"}})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, e.Start(ctx))
+	defer e.Close()
+	ms := e.MarginSystem()
+	_, err = ms.CreateAccount("alice")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("alice", 10_000))
+	pos, err := e.OpenPosition(ctx, common.ISOLATED, "alice", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+
+	server := api.NewServer(e, "", nil, nil)
+	killSwitch := risk.NewSymbolKillSwitch(nil)
+	killSwitch.SetOrderBook("BTCUSDT", server.OrderBook("BTCUSDT"))
+	dir := t.TempDir()
+	snapshots, err := snapshot.NewSnapshotManager(e, server, &snapshot.Config{Dir: dir})
+	require.NoError(t, err)
+	handler := admin.NewHandler(e, "secret")
+	handler.SetKillSwitch(killSwitch)
+	handler.SetSnapshots(snapshots)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// command run the subcommand against the test server, return the exit code, stdout and stderr
+	command := func(name string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runAdmin(name, append([]string{"--addr", ts.URL, "--token", "secret"}, args...), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, _ := command("positions", "--user", "alice")
+	require.Zero(t, code)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "ID "))
+	assert.Equal(t, []string{pos.ID, "BTCUSDT", "long", "ISOLATED", "0.1", "50000"}, strings.Fields(lines[1])[:6])
+
+	code, out, _ = command("positions", "--user", "alice", "--format", "json")
+	require.Zero(t, code)
+	var positions []position.PositionSnapshot
+	require.NoError(t, json.Unmarshal([]byte(out), &positions))
+	require.Len(t, positions, 1)
+	assert.Equal(t, pos.ID, positions[0].ID)
+
+	code, _, errOut := command("accounts", "--user", "nobody")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "404 Not Found")
+
+	// dangerous commands do nothing without --confirm
+	balance := func() float64 {
+		account, err := ms.GetAccount("alice")
+		require.NoError(t, err)
+		return account.Snapshot().Balance
+	}
+	before := balance()
+	code, _, errOut = command("deposit", "--user", "alice", "--amount", "1000")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut, "--confirm")
+	assert.Equal(t, before, balance())
+
+	code, out, _ = command("deposit", "--user", "alice", "--amount", "1000", "--confirm", "--format", "json")
+	require.Zero(t, code)
+	var account margin.AccountSnapshot
+	require.NoError(t, json.Unmarshal([]byte(out), &account))
+	assert.Equal(t, before+1000, account.Balance)
+
+	code, out, _ = command("accounts", "--user", "alice")
+	require.Zero(t, code)
+	assert.Contains(t, out, "alice")
+	assert.Contains(t, out, "active")
+
+	code, _, errOut = command("kill-switch", "--symbol", "BTCUSDT", "--mode", "pause", "--confirm")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "unknown kill switch mode")
+	code, out, _ = command("kill-switch", "--symbol", "BTCUSDT", "--mode", "close-only", "--reason", "drill", "--confirm")
+	require.Zero(t, code)
+	assert.Equal(t, []string{"BTCUSDT", "OFF", "CLOSE_ONLY", "0", "drill"}, strings.Fields(strings.Split(out, "\n")[1]))
+	assert.Equal(t, risk.KillSwitchCloseOnly, killSwitch.Mode("BTCUSDT"))
+
+	code, _, errOut = command("force-liquidate", "--position", pos.ID)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut, "--confirm")
+	assert.Equal(t, position.PositionNormal, pos.Snapshot().Status)
+	code, out, _ = command("force-liquidate", "--position", pos.ID, "--confirm")
+	require.Zero(t, code)
+	assert.Contains(t, out, pos.ID)
+	assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+	code, _, errOut = command("force-liquidate", "--position", pos.ID, "--confirm")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "409 Conflict")
+
+	code, out, _ = command("snapshot-now")
+	require.Zero(t, code)
+	path := strings.Fields(strings.Split(out, "\n")[1])[0]
+	assert.True(t, strings.HasPrefix(path, dir))
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+	code = runAdmin("positions", []string{"--addr", ts.URL, "--token", "wrong", "--user", "alice"}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "401 Unauthorized")
+}
@@ -1,24 +1,72 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"frizo/futures_engine/internal/admin"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/audit"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/events"
+	"frizo/futures_engine/internal/funding"
+	"frizo/futures_engine/internal/health"
+	"frizo/futures_engine/internal/history"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"frizo/futures_engine/internal/rpc"
+	"frizo/futures_engine/internal/shutdown"
+	"frizo/futures_engine/internal/snapshot"
 	"frizo/futures_engine/internal/version"
+	"frizo/futures_engine/internal/wal"
+	"maps"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"frizo/futures_engine/internal/config"
 	"frizo/futures_engine/internal/logger"
+
+	_ "github.com/lib/pq"
 )
 
+// startupTimeout deadline of the storage connections checked on startup
+const startupTimeout = 10 * time.Second
+
+// defaultConfigFile read when present, -config names a file that must exist
+const defaultConfigFile = ".env.local"
+
+// probeTimeout deadline of the -health-check probe, the timeout of the Dockerfile HEALTHCHECK
+const probeTimeout = 3 * time.Second
+
+// breakerPollInterval time between two resumption checks of the halted symbols without trades
+const breakerPollInterval = time.Second
+
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "scenario" {
+		os.Exit(runScenario(os.Args[2:]))
+	}
+	if len(os.Args) > 1 {
+		if _, ok := adminCommands[os.Args[1]]; ok {
+			os.Exit(runAdmin(os.Args[1], os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	// Command line flags
 	var (
 		showVersion = flag.Bool("version", false, "Show version information")
 		showHelp    = flag.Bool("help", false, "Show help information")
 		healthCheck = flag.Bool("health-check", false, "Perform health check")
-		configFile  = flag.String("config", ".env.local", "Path to configuration file")
+		configFile  = flag.String("config", defaultConfigFile, "Path to configuration file (.env or .yaml)")
 		logLevel    = flag.String("log-level", "", "Log level (debug, info, warn, error)")
 	)
 	flag.Parse()
@@ -34,17 +82,27 @@ func main() {
 		fmt.Printf("Futures Engine %s\n\n", version.Short())
 		fmt.Println("Usage:")
 		flag.PrintDefaults()
+		fmt.Println("\nSubcommands:")
+		fmt.Println("  scenario [flags]\tRun a stress scenario and print its report (scenario -help)")
+		for _, name := range adminUsage {
+			fmt.Println("  " + adminCommands[name].usage)
+		}
+		fmt.Println("\nAdmin subcommands call the admin API (ADMIN_TOKEN) of a running engine, flags: --addr, --token,")
+		fmt.Println("--config, --format table|json; deposit, force-liquidate and kill-switch need --confirm")
 		os.Exit(0)
 	}
 
-	// Handle health check
-	if *healthCheck {
-		fmt.Println("OK")
-		os.Exit(0)
+	// Load configuration: environment, then the config file, then the defaults
+	cfg, warnings, err := config.LoadFromFile(configPath(*configFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(1)
 	}
 
-	// Load configuration
-	cfg := config.Load()
+	// Handle health check: probe the running engine
+	if *healthCheck {
+		os.Exit(probeHealth(cfg))
+	}
 
 	// Override log level from command line
 	if *logLevel != "" {
@@ -59,13 +117,12 @@ func main() {
 	log.Info("Starting Futures Engine",
 		"version", version.Short(),
 		"environment", cfg.Environment,
-		"host", cfg.Host,
-		"port", cfg.Port,
+		"host", cfg.API.Host,
+		"port", cfg.API.Port,
 	)
 
-	// Handle unused config file flag
-	if *configFile != "" {
-		log.Warn("Configuration file support not implemented yet", "file", *configFile)
+	for _, warning := range warnings {
+		log.Warn(warning)
 	}
 
 	// Setup graceful shutdown
@@ -73,33 +130,721 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	// Start your application here
-	log.Info("Futures Engine is running", "address", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
+	log.Info("Futures Engine is running", "address", cfg.API.Addr())
 
-	// Example of your main application logic
-	if err := run(cfg, log); err != nil {
+	app, err := run(cfg, log)
+	if err != nil {
 		log.Error("Application error", "error", err)
 		os.Exit(1)
 	}
 
-	// Wait for shutdown signal
-	<-quit
+	// Reload the hot settings on SIGHUP
+	reloader := newReloader(app, configPath(*configFile), cfg, log)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	// Wait for shutdown signal, or the API server failing
+	for running := true; running; {
+		select {
+		case <-reload:
+			if _, err = reloader.Reload(); err != nil {
+				log.Error("Configuration reload failed", "error", err)
+			}
+		case <-quit:
+			running = false
+		case err = <-app.serveErr:
+			log.Error("Server failed", "error", err)
+			running = false
+		}
+	}
 	log.Info("Shutting down Futures Engine...")
 
-	// Perform cleanup here
-	cleanup(log)
+	// Ordered shutdown, forced exit when it overruns its deadline
+	if !cleanup(app, cfg, log) {
+		log.Error("Shutdown deadline passed, forcing exit")
+		os.Exit(1)
+	}
 
 	log.Info("Futures Engine stopped")
 }
 
-// run contains your main application logic
-func run(cfg *config.Config, log *logger.Logger) error {
-	// TODO: Implement your application logic here
-	log.Info("Application started successfully")
+// configPath file for config.LoadFromFile: path, "" when it is the default and does not exist
+func configPath(path string) string {
+	if path != defaultConfigFile {
+		return path
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// application running components, released by cleanup
+type application struct {
+	engine   *engine.FuturesEngine
+	server   *api.Server
+	addr     string      // address the REST API listens on
+	grpc     *rpc.Server // nil when GRPCPort is 0
+	serveErr chan error  // the API or gRPC server stopped on its own
+
+	ingestion     *position.PriceIngestion // mark prices from the trades of the books
+	stopIngestion context.CancelFunc
+	funding       *funding.FundingScheduler
+	fundingConfig *funding.ConfigRegistry // funding settings of the symbols, changed by a reload
+
+	risk        *risk.RiskPipeline       // checks every order placed
+	rateLimiter *risk.RateLimiter        // of the placements and the cancels, default tier changed by a reload
+	exposure    *risk.ExposureLimitStore // default tier changed by a reload
+	breaker     *risk.CircuitBreaker     // config changed by a reload
+	stopRisk    context.CancelFunc       // ends the breaker polling
+
+	snapshots     *snapshot.SnapshotManager // nil when SnapshotDir is ""
+	stopSnapshots context.CancelFunc
+	wal           *wal.Log                  // nil when WALDir is ""
+	recorder      *wal.Recorder             // commands through wal, nil when WALDir is ""
+	accounts      *margin.RedisAccountStore // nil when RedisAddr is ""
+	history       *history.Recorder         // nil when HistoryDatabaseURL is ""
+	historyDB     *sql.DB
+	stopHistory   func()                 // ends the position event subscription of history
+	events        *events.Forwarder      // nil when KafkaBrokers is empty
+	stopEvents    func()                 // ends the position event subscription of events
+	killSwitch    *risk.SymbolKillSwitch // nil when AdminToken is ""
+	auditLog      *audit.FileSink        // nil when AuditLogPath is ""
+	auditor       *audit.Auditor         // writes to auditLog
+}
+
+// tickBuffer trades waiting for the mark price ingestion, a full buffer drops the tick: the ingestion
+// only applies the latest one of a symbol anyway
+const tickBuffer = 1024
+
+// run compose the engine and its subsystems, start them in dependency order and serve the REST API,
+// and the gRPC API when enabled, in the background. a failing step stops what was started before it
+func run(cfg *config.Config, log *logger.Logger) (*application, error) {
+	e, err := engine.NewFuturesEngine(engineConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	// undo of every started component, run in reverse order when a later step fails
+	var started []func()
+	fail := func(err error) (*application, error) {
+		for i := len(started) - 1; i >= 0; i-- {
+			started[i]()
+		}
+		return nil, err
+	}
+	started = append(started, func() { _ = e.Close() })
+
+	// the REST and gRPC APIs act for the users of the same API keys
+	keys := api.NewMemoryAPIKeyStore()
+	for key, userID := range cfg.API.APIKeys {
+		if err = keys.Add(key, userID); err != nil {
+			return fail(err)
+		}
+	}
+	app := &application{
+		engine:   e,
+		server:   api.NewServer(e, "", &cfg.API.Stream, keys),
+		serveErr: make(chan error, 2),
+	}
+	started = append(started, func() { _ = app.server.Shutdown(context.Background()) })
+
+	// the state of the last snapshot is restored before the engine verifies it and starts,
+	// the write-ahead log entries after it are replayed once started
+	var sequence uint64
+	if cfg.SnapshotDir != "" {
+		app.snapshots, err = snapshot.NewSnapshotManager(e, app.server, &snapshot.Config{
+			Dir:      cfg.SnapshotDir,
+			Interval: cfg.SnapshotInterval,
+			Keep:     cfg.SnapshotKeep,
+		})
+		if err == nil {
+			sequence, err = restoreSnapshot(app.snapshots, cfg.SnapshotDir, log)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	// accounts of the shared store on top of the snapshot, before the engine verifies them
+	if cfg.RedisAddr != "" {
+		err = loadAccounts(app, cfg, log)
+		if app.accounts != nil {
+			started = append(started, func() { _ = app.accounts.Close() })
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err = e.Start(context.Background()); err != nil {
+		return fail(err)
+	}
+	if cfg.WALDir != "" {
+		err = replayWAL(app, cfg, sequence, log)
+		if app.wal != nil {
+			started = append(started, func() { _ = app.wal.Close() })
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	// after the replay, the replayed trades are in the history already
+	if cfg.HistoryDatabaseURL != "" {
+		err = startHistory(app, cfg, log)
+		if app.historyDB != nil {
+			started = append(started, func() { _ = app.historyDB.Close() })
+		}
+		if err != nil {
+			return fail(err)
+		}
+		started = append(started, func() {
+			app.stopHistory()
+			_ = app.history.Close(context.Background())
+		})
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		startEvents(app, cfg, log)
+		started = append(started, func() {
+			app.stopEvents()
+			_ = app.events.Close(context.Background())
+		})
+	}
+
+	// after the replay, the replayed commands are in the audit log already
+	if cfg.AuditLogPath != "" {
+		if err = startAudit(app, cfg, log); err != nil {
+			return fail(err)
+		}
+		started = append(started, func() { _ = app.auditLog.Close() })
+	}
+
+	// background loops: pre-trade risk, liquidations, mark prices, funding settlements, snapshots
+	startRisk(app, cfg)
+	started = append(started, app.stopRisk)
+	liquidations := e.LiquidationEngine()
+	if err = liquidations.Start(); err != nil {
+		return fail(err)
+	}
+	started = append(started, func() { _ = liquidations.Stop() })
+	if err = startPriceIngestion(app); err != nil {
+		return fail(err)
+	}
+	started = append(started, app.stopIngestion)
+	if err = startFunding(app, cfg); err != nil {
+		return fail(err)
+	}
+	started = append(started, func() { _ = app.funding.Stop() })
+	if app.snapshots != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		app.stopSnapshots = cancel
+		go func() { _ = app.snapshots.Run(ctx) }()
+		started = append(started, cancel)
+	}
+
+	registerHealth(app, cfg)
+	if cfg.API.AdminToken != "" {
+		startAdmin(app, cfg, log)
+	}
+
+	if cfg.MetricsEnabled {
+		registry := metrics.NewPrometheus(nil)
+		e.SetMetrics(registry)
+		app.server.SetMetrics(registry)
+		app.funding.SetMetrics(registry)
+		if app.events != nil {
+			app.events.SetMetrics(registry)
+		}
+		if app.auditor != nil {
+			app.auditor.SetMetrics(registry)
+		}
+		registry.OnScrape(e.ReportMetrics)
+		registry.OnScrape(app.server.ReportMetrics)
+		app.server.Handle("GET /metrics", registry.Handler())
+		log.Info("Prometheus metrics enabled", "path", "/metrics")
+	}
+
+	// listeners last: no traffic before every subsystem runs
+	lis, err := net.Listen("tcp", cfg.API.Addr())
+	if err != nil {
+		return fail(err)
+	}
+	app.addr = lis.Addr().String()
+	if cfg.API.GRPCPort != 0 {
+		grpcLis, err := net.Listen("tcp", net.JoinHostPort(cfg.API.Host, strconv.Itoa(cfg.API.GRPCPort)))
+		if err != nil {
+			_ = lis.Close()
+			return fail(err)
+		}
+		app.grpc = rpc.NewServer(e, app.server, keys)
+		go func() {
+			if err := app.grpc.Serve(grpcLis); err != nil {
+				app.serveErr <- err
+			}
+		}()
+		log.Info("gRPC server listening", "address", grpcLis.Addr().String())
+	}
+
+	go func() {
+		if err := app.server.Serve(lis); err != nil {
+			app.serveErr <- err
+		}
+	}()
+
+	log.Info("Application started successfully", "symbols", cfg.Symbols, "address", app.addr,
+		"api_keys", len(cfg.API.APIKeys))
+	return app, nil
+}
+
+// engineConfig symbols, precision and margin settings of cfg
+func engineConfig(cfg *config.Config) *engine.Config {
+	precision := make(map[string]*position.PrecisionSetting)
+	for _, symbol := range cfg.Symbols {
+		price, hasPrice := cfg.PricePrecision[symbol]
+		size, hasSize := cfg.SizePrecision[symbol]
+		if !hasPrice && !hasSize {
+			continue
+		}
+		setting := *position.DefaultPrecisionSetting
+		if hasPrice {
+			setting.PricePrecision = int8(price)
+		}
+		if hasSize {
+			setting.SizePrecision = int8(size)
+		}
+		precision[symbol] = &setting
+	}
+	margins := cfg.Margin
+	return &engine.Config{Symbols: cfg.Symbols, Precision: precision, Margin: &margins}
+}
+
+// startPriceIngestion mark the symbols at the price of their trades: every trade of the books is a tick
+// of the conflating ingestion, the liquidation loop picks up the positions it makes liquidatable
+func startPriceIngestion(app *application) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := make(chan position.PriceTick, tickBuffer)
+	ingestion, err := app.engine.PositionManager().StartPriceIngestion(ctx, ticks)
+	if err != nil {
+		cancel()
+		return err
+	}
+	app.ingestion = ingestion
+	app.stopIngestion = func() {
+		cancel()
+		<-ingestion.Done()
+	}
+
+	liquidations := app.engine.LiquidationEngine()
+	app.server.OnTrade(func(trade orderbook.Trade) {
+		select {
+		case ticks <- position.PriceTick{Symbol: trade.Symbol, Price: trade.Price, Timestamp: time.Now()}:
+		default:
+		}
+		// the cross monitor may run before the tick is applied, the next trade of the symbol wakes it again
+		liquidations.NotifyMarkPrice(trade.Symbol)
+	})
+	return nil
+}
+
+// startFunding settle funding at every interval boundary of each symbol, at the premium of its book over
+// the mark price. settlements are published with the other events when Kafka is enabled
+func startFunding(app *application, cfg *config.Config) error {
+	defaults := cfg.Funding
+	registry, err := funding.NewConfigRegistry(nil, &defaults)
+	if err != nil {
+		return err
+	}
+	app.fundingConfig = registry
+	var settler funding.Settler = app.engine.MarginSystem()
+	if app.events != nil {
+		settler = app.events.Settler(settler)
+	}
+	pm := app.engine.PositionManager()
+	app.funding = funding.NewFundingScheduler(nil, registry, funding.NewCalculator(registry, app.server.PremiumIndex),
+		settler, pm.GetAllSymbols, nil, nil)
+	pm.SetFundingRateSchedule(app.funding)
+	return app.funding.Start()
+}
+
+// startRisk check every order placed against the rate limits, the circuit breaker and the exposure limits
+// of cfg.Risk before its margin is reserved, and cancels against the cancel rate limit. the breaker watches
+// the trade prices the symbols are marked at and rejects new orders while halted: the books keep matching
+// continuously, an auction uncross on resumption would bypass the settlement of the API server
+func startRisk(app *application, cfg *config.Config) {
+	breakerConfig := cfg.Risk.CircuitBreaker
+	app.rateLimiter = risk.NewRateLimiter(nil, maps.Clone(cfg.Risk.RateLimits))
+	app.breaker = risk.NewCircuitBreaker(nil, &breakerConfig)
+	app.exposure = risk.NewExposureLimitStore(cfg.Risk.Exposure)
+	app.risk = risk.NewRiskPipeline(app.rateLimiter, app.breaker, &risk.ExposureChecker{
+		PositionMgr: app.engine.PositionManager(),
+		Orders:      app.server,
+		Limits:      app.exposure,
+	})
+	app.server.SetRisk(app.risk, app.rateLimiter)
+
+	app.server.OnTrade(func(trade orderbook.Trade) { _ = app.breaker.OnMarkPrice(trade.Symbol, trade.Price) })
+	app.breaker.OnEvent(func(event risk.BreakerEvent) {
+		logger.Default().Warn("Circuit breaker", "symbol", event.Symbol, "state", event.To.String(), "reason", event.Reason)
+		if app.events != nil {
+			app.events.PublishAudit(events.TypeCircuitBreaker, event)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	app.stopRisk = cancel
+	go func() {
+		ticker := time.NewTicker(breakerPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				app.breaker.Poll()
+			}
+		}
+	}()
+}
+
+// newReloader reload the configuration from path: the margin settings go to the margin system, the risk
+// settings to the default tiers of the rate and exposure limits and to the circuit breaker, the funding
+// settings to every symbol from its next interval boundary. every reload is logged, and published on the
+// audit topic when Kafka is enabled
+func newReloader(app *application, path string, cfg *config.Config, log *logger.Logger) *config.Reloader {
+	reloader := config.NewReloader(path, cfg)
+	reloader.Register(config.SectionMargin, func(next *config.Config) error {
+		app.engine.MarginSystem().SetConfig(next.Margin)
+		return nil
+	})
+	reloader.Register(config.SectionRisk, func(next *config.Config) error {
+		breakerConfig := next.Risk.CircuitBreaker
+		app.rateLimiter.SetTierLimits(risk.DefaultTierName, maps.Clone(next.Risk.RateLimits))
+		app.exposure.SetTierLimit(risk.DefaultTierName, next.Risk.Exposure)
+		app.breaker.SetConfig(&breakerConfig)
+		return nil
+	})
+	reloader.Register(config.SectionFunding, func(next *config.Config) error {
+		for _, symbol := range app.engine.PositionManager().GetAllSymbols() {
+			if _, err := app.fundingConfig.Set(symbol, next.Funding); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	reloader.OnReload(func(event config.ReloadEvent) {
+		log.Info("Configuration reloaded", "path", event.Path, "applied", len(event.Applied), "failed", len(event.Failed))
+		for _, change := range event.Applied {
+			log.Info("Configuration changed", "key", change.Key, "from", change.From, "to", change.To)
+		}
+		if len(event.Ignored) > 0 {
+			log.Warn("Configuration changes ignored until restart", "keys", event.IgnoredKeys())
+		}
+		if app.events != nil {
+			app.events.PublishAudit(events.TypeConfigReload, event)
+		}
+	})
+	return reloader
+}
+
+// registerHealth serve GET /healthz: the engine checks, the storage connections, the snapshot age and the
+// audit log. the history database, the snapshots and the audit log degrade the report without failing it
+func registerHealth(app *application, cfg *config.Config) {
+	checker := health.NewChecker(0)
+	app.engine.RegisterHealth(checker, cfg.HealthMaxPriceAge)
+	if app.accounts != nil {
+		checker.Register("account_store", true, health.ContributorFunc(app.accounts.Ping))
+	}
+	if app.historyDB != nil {
+		checker.Register("history_database", false, health.ContributorFunc(app.historyDB.PingContext))
+	}
+	if app.snapshots != nil {
+		checker.Register("snapshots", false, app.snapshots)
+	}
+	if app.auditor != nil {
+		checker.Register("audit_log", false, app.auditor)
+	}
+	app.server.Handle("GET "+health.Path, checker)
+}
+
+// startAdmin serve the admin API under /admin/ and the symbol kill switch it controls. admin deposits go
+// through the write-ahead log when enabled, the resting orders a HALT cancels release their margin.
+// every kill switch change is published on the audit topic when Kafka is enabled
+func startAdmin(app *application, cfg *config.Config, log *logger.Logger) {
+	pm := app.engine.PositionManager()
+	ms := app.engine.MarginSystem()
+	app.killSwitch = risk.NewSymbolKillSwitch(nil)
+	for _, symbol := range pm.GetAllSymbols() {
+		app.killSwitch.SetOrderBook(symbol, app.server.OrderBook(symbol))
+	}
+	app.killSwitch.SetPositionManager(pm)
+	app.killSwitch.OnEvent(func(event risk.KillSwitchEvent) {
+		for _, order := range event.Cancelled {
+			_ = ms.ReleaseMarginReservation(order.UserID, order.ID) // reduce-only: none reserved
+		}
+		if app.events != nil {
+			app.events.PublishAudit(events.TypeKillSwitch, event)
+		}
+	})
+
+	// an order the kill switch stops is rejected before its margin is reserved
+	_ = app.risk.Register(app.killSwitch)
+
+	handler := admin.NewHandler(app.engine, cfg.API.AdminToken)
+	handler.SetKillSwitch(app.killSwitch)
+	if app.recorder != nil {
+		handler.SetDepositor(app.recorder)
+	}
+	if app.snapshots != nil {
+		handler.SetSnapshots(app.snapshots)
+	}
+	if app.auditor != nil {
+		handler.SetAudit(app.auditor)
+	}
+	app.server.Handle(admin.Path, handler)
+	log.Info("Admin API enabled", "path", admin.Path)
+}
+
+// probeHealth GET /healthz of the engine serving cfg, print OK or the failed checks. return the exit code
+func probeHealth(cfg *config.Config) int {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(localHost(cfg.API.Host), strconv.Itoa(cfg.API.Port)), health.Path)
+	if _, err := health.Probe(ctx, url); err != nil {
+		fmt.Fprintln(os.Stderr, "UNHEALTHY:", err)
+		return 1
+	}
+	fmt.Println("OK")
+	return 0
+}
+
+// cleanup ordered shutdown under cfg.ShutdownTimeout: stop taking requests and drain the in-flight ones,
+// stop the background loops, flush the account store, write the shutdown snapshot, then close the log,
+// the engine and the event and history buffers. false when the deadline cut it short
+func cleanup(app *application, cfg *config.Config, log *logger.Logger) bool {
+	sequence := shutdown.NewSequence(cfg.ShutdownTimeout)
+
+	// the REST shutdown closes the event subscriptions, which ends the gRPC event streams too
+	sequence.Add("api server", app.server.Shutdown)
+	if app.grpc != nil {
+		sequence.Add("grpc server", func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				app.grpc.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				app.grpc.Stop()
+				return ctx.Err()
+			}
+		})
+	}
+	// no price, liquidation or settlement after the last request
+	sequence.Add("price ingestion", func(context.Context) error {
+		app.stopIngestion()
+		return nil
+	})
+	sequence.Add("circuit breaker", func(context.Context) error {
+		app.stopRisk()
+		return nil
+	})
+	sequence.Add("liquidation engine", func(context.Context) error { return app.engine.LiquidationEngine().Stop() })
+	sequence.Add("funding scheduler", func(context.Context) error { return app.funding.Stop() })
+	if app.snapshots != nil {
+		sequence.Add("snapshot schedule", func(context.Context) error {
+			app.stopSnapshots()
+			return nil
+		})
+	}
+	if app.accounts != nil {
+		sequence.Add("account store", func(ctx context.Context) error {
+			defer app.accounts.Close()
+			return app.engine.MarginSystem().FlushAccounts(ctx)
+		})
+	}
+	// the shutdown snapshot once no request changes the state anymore
+	if app.snapshots != nil {
+		sequence.Add("shutdown snapshot", func(context.Context) error {
+			path, err := app.snapshots.Save()
+			if err == nil {
+				log.Info("Shutdown snapshot written", "path", path)
+			}
+			return err
+		})
+	}
+	if app.wal != nil {
+		sequence.Add("write-ahead log", func(context.Context) error { return app.wal.Close() })
+	}
+	sequence.Add("engine", func(context.Context) error { return app.engine.Close() })
+	if app.auditLog != nil {
+		sequence.Add("audit log", func(context.Context) error { return app.auditLog.Close() })
+	}
+	// events and history last, they take the records of everything above
+	if app.events != nil {
+		sequence.Add("event publishing", func(ctx context.Context) error {
+			app.stopEvents()
+			if err := app.events.Close(ctx); err != nil {
+				return fmt.Errorf("%w, %d events dropped", err, app.events.Dropped())
+			}
+			return nil
+		})
+	}
+	if app.history != nil {
+		sequence.Add("history", func(ctx context.Context) error {
+			app.stopHistory()
+			defer app.historyDB.Close()
+			if err := app.history.Close(ctx); err != nil {
+				return fmt.Errorf("%w, %d records dropped", err, app.history.Dropped())
+			}
+			return nil
+		})
+	}
+
+	report := sequence.Run(context.Background())
+	log.Info("Shutdown finished", "report", report.String())
+	return report.Complete()
+}
+
+// restoreSnapshot restore the newest usable snapshot of dir, a first start without any is fine.
+// return the write-ahead log sequence the snapshot covers
+func restoreSnapshot(snapshots *snapshot.SnapshotManager, dir string, log *logger.Logger) (uint64, error) {
+	state, path, err := snapshot.Load(dir)
+	if errors.Is(err, snapshot.ErrNoSnapshot) {
+		log.Info("No snapshot to restore, starting empty", "dir", dir)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err = snapshots.Restore(state); err != nil {
+		return 0, fmt.Errorf("restore %s: %w", path, err)
+	}
+	if build := version.Get(); !build.IsCompatibleWith(state.Build) {
+		log.Warn("Snapshot written by an incompatible build", "path", path, "snapshot_version", state.Build.Version,
+			"version", build.Version)
+	}
+	log.Info("Snapshot restored", "path", path, "taken_at", state.TakenAt, "sequence", state.Sequence,
+		"positions", len(state.Positions.Positions), "accounts", len(state.Margin.Accounts))
+	return state.Sequence, nil
+}
+
+// loadAccounts persist the accounts to the Redis store shared with the other instances and load the
+// stored ones
+func loadAccounts(app *application, cfg *config.Config, log *logger.Logger) error {
+	app.accounts = margin.NewRedisAccountStore(&margin.RedisStoreConfig{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	if err := app.accounts.Ping(ctx); err != nil {
+		return fmt.Errorf("account store %s: %w", cfg.RedisAddr, err)
+	}
+
+	ms := app.engine.MarginSystem()
+	ms.SetAccountStore(app.accounts)
+	loaded, err := ms.LoadAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info("Accounts loaded from Redis", "address", cfg.RedisAddr, "loaded", loaded)
 	return nil
 }
 
-// cleanup performs cleanup operations
-func cleanup(log *logger.Logger) {
-	// TODO: Implement cleanup logic here
-	log.Debug("Cleanup completed")
+// replayWAL open the write-ahead log, record every order through it from now on and replay the entries
+// after the restored snapshot
+func replayWAL(app *application, cfg *config.Config, after uint64, log *logger.Logger) error {
+	var err error
+	app.wal, err = wal.Open(cfg.WALDir, &wal.Config{SyncInterval: cfg.WALSyncInterval})
+	if err != nil {
+		return err
+	}
+	app.recorder = wal.NewRecorder(app.wal, app.engine, app.server)
+	if app.snapshots != nil {
+		app.snapshots.SetCommandLog(app.recorder)
+	}
+	replayed, err := app.recorder.Replay(context.Background(), after)
+	if err != nil {
+		return err
+	}
+	log.Info("Write-ahead log replayed", "dir", cfg.WALDir, "after", after, "entries", replayed,
+		"sequence", app.wal.LastSequence())
+	return nil
+}
+
+// startHistory migrate the history database and record the closed positions, trades and ledger entries
+// to it from now on, continuing its sequences
+func startHistory(app *application, cfg *config.Config, log *logger.Logger) error {
+	var err error
+	app.historyDB, err = sql.Open("postgres", cfg.HistoryDatabaseURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	if err = app.historyDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("history database: %w", err)
+	}
+	store := history.NewPostgresStore(app.historyDB)
+	if err = store.Migrate(ctx); err != nil {
+		return err
+	}
+	start, err := store.LastSequences(ctx)
+	if err != nil {
+		return err
+	}
+
+	app.history = history.NewRecorder(store, store, store, &history.Config{Start: start})
+	app.server.SetHistory(app.history, store)
+	app.engine.MarginSystem().OnAuditEntry(func(entry margin.MarginAuditEntry) { app.history.RecordLedgerEntry(entry) })
+	pm := app.engine.PositionManager()
+	positionEvents, unsubscribe := pm.SubscribeEvents(history.DefaultBufferSize)
+	done := pm.SubscribeHandler(positionEvents, app.history)
+	app.stopHistory = func() {
+		unsubscribe()
+		<-done
+	}
+	log.Info("History recording enabled", "sequences", start)
+	return nil
+}
+
+// startAudit append every balance, order, liquidation and position change to the audit log from now on,
+// written by the goroutine committing it
+func startAudit(app *application, cfg *config.Config, log *logger.Logger) error {
+	sink, err := audit.OpenFileSink(cfg.AuditLogPath)
+	if err != nil {
+		return err
+	}
+	app.auditLog = sink
+	app.auditor = audit.NewAuditor(sink)
+	app.engine.MarginSystem().OnAuditEntry(app.auditor.MarginEntry)
+	app.server.OnOrderEvent(app.auditor.OrderEvent)
+	app.engine.LiquidationEngine().OnResult(app.auditor.Liquidation)
+	app.engine.PositionManager().SetJournal(app.auditor)
+	log.Info("Audit log enabled", "path", cfg.AuditLogPath)
+	return nil
+}
+
+// startEvents publish the trades, order events, liquidations and margin calls to Kafka from now on
+func startEvents(app *application, cfg *config.Config, log *logger.Logger) {
+	app.events = events.NewForwarder(events.NewKafkaPublisher(&events.KafkaConfig{Brokers: cfg.KafkaBrokers}), &events.Config{
+		Topics: events.Topics{
+			Trades:       cfg.KafkaTopics["trades"],
+			Orders:       cfg.KafkaTopics["orders"],
+			Liquidations: cfg.KafkaTopics["liquidations"],
+			MarginCalls:  cfg.KafkaTopics["margin_calls"],
+			Funding:      cfg.KafkaTopics["funding"],
+			Audit:        cfg.KafkaTopics["audit"],
+		},
+	})
+	app.server.OnTrade(func(trade orderbook.Trade) { app.events.PublishTrade(trade) })
+	app.server.OnOrderEvent(func(event orderbook.OrderEvent) { app.events.PublishOrderEvent(event) })
+	pm := app.engine.PositionManager()
+	positionEvents, unsubscribe := pm.SubscribeEvents(events.DefaultBufferSize)
+	done := pm.SubscribeHandler(positionEvents, app.events)
+	app.stopEvents = func() {
+		unsubscribe()
+		<-done
+	}
+	log.Info("Kafka event publishing enabled", "brokers", cfg.KafkaBrokers)
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/config"
+	"frizo/futures_engine/internal/health"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"frizo/futures_engine/internal/version"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig the defaults, serving BTCUSDT on port of 127.0.0.1 without gRPC
+func testConfig(t *testing.T, port int) *config.Config {
+	cfg := config.Default()
+	cfg.API.Host, cfg.API.Port, cfg.API.GRPCPort = "127.0.0.1", port, 0
+	cfg.Symbols = []string{"BTCUSDT"}
+	cfg.SnapshotDir = t.TempDir()
+	require.NoError(t, cfg.Validate())
+	return cfg
+}
+
+func TestRunServesOrders(t *testing.T) {
+	cfg := testConfig(t, 0)
+	cfg.API.APIKeys = map[string]string{"alice-key": "alice", "bob-key": "bob"}
+	log := logger.New("error")
+	app, err := run(cfg, log)
+	require.NoError(t, err)
+	stopped := false
+	defer func() {
+		if !stopped {
+			cleanup(app, cfg, log)
+		}
+	}()
+	url := "http://" + app.addr
+
+	ms := app.engine.MarginSystem()
+	for _, userID := range []string{"alice", "bob"} {
+		_, err = ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 10_000))
+	}
+	// place post req with the API key of userID
+	place := func(userID string, req api.PlaceOrderRequest) api.PlaceOrderResponse {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		httpReq, err := http.NewRequest(http.MethodPost, url+"/orders", bytes.NewReader(body))
+		require.NoError(t, err)
+		httpReq.Header.Set(api.AuthorizationHeader, "Bearer "+userID+"-key")
+		resp, err := http.DefaultClient.Do(httpReq)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var placed api.PlaceOrderResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&placed))
+		return placed
+	}
+
+	bid := place("alice", api.PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10})
+	assert.Equal(t, 0.1, bid.Order.Size, "resting")
+	filled := place("bob", api.PlaceOrderRequest{Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.1, Leverage: 10})
+	require.Len(t, filled.Trades, 1)
+
+	// the trade settles into both positions: the reservation of the bid becomes position margin
+	pm := app.engine.PositionManager()
+	for userID, side := range map[string]position.PositionSide{"alice": position.LONG, "bob": position.SHORT} {
+		pos, err := pm.GetPosition(userID, "BTCUSDT", side)
+		require.NoError(t, err, userID)
+		snapshot := pos.Snapshot()
+		assert.Equal(t, 0.1, snapshot.Size, userID)
+		assert.Equal(t, 50000.0, snapshot.EntryPrice, userID)
+		account, err := ms.GetAccount(userID)
+		require.NoError(t, err)
+		balances := account.Snapshot()
+		assert.Equal(t, 10_000.0, balances.Balance, userID)
+		assert.InDelta(t, 500, balances.PositionMargin, 1e-9, userID)
+		assert.Zero(t, balances.OrderMargin, userID)
+		assert.Zero(t, balances.FrozenBalance, userID)
+	}
+
+	// the trade marks the symbol through the price ingestion
+	require.Eventually(t, func() bool { return pm.GetSymbolMarkPrice("BTCUSDT") == 50000 }, time.Second, 5*time.Millisecond)
+	report, err := health.Probe(context.Background(), url+health.Path)
+	require.NoError(t, err)
+	assert.Equal(t, health.StatusOK, report.Status)
+	assert.Equal(t, version.Version, report.Build.Version)
+	assert.Equal(t, version.GitCommit, report.Build.GitCommit)
+
+	stopped = true
+	assert.True(t, cleanup(app, cfg, log))
+	_, err = http.Get(url + health.Path)
+	assert.Error(t, err, "server stopped")
+}
+
+func TestRunTearsDownOnFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	// the listener comes last: every subsystem was started when it fails
+	baseline := runtime.NumGoroutine()
+	cfg := testConfig(t, port)
+	_, err = run(cfg, logger.New("error"))
+	assert.ErrorContains(t, err, "address already in use")
+	// polled by hand, Eventually runs goroutines of its own
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > baseline && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline, "background loops stopped")
+}
+
+func TestReloadFeeRateMidRun(t *testing.T) {
+	cfg := testConfig(t, 0)
+	cfg.Margin.LiquidationFeeRate = 0.01
+	log := logger.New("error")
+	app, err := run(cfg, log)
+	require.NoError(t, err)
+	defer cleanup(app, cfg, log)
+
+	ms := app.engine.MarginSystem()
+	_, err = ms.CreateAccount("alice")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("alice", 10_000))
+	before, err := ms.SettleLiquidation("alice", "pos_1", "BTCUSDT", common.CROSS, 10_000, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, before.Fee)
+	account, err := ms.GetAccount("alice")
+	require.NoError(t, err)
+	balance := account.Balance
+
+	path := filepath.Join(t.TempDir(), "engine.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("host: 127.0.0.1\nport: 9999\nliquidation_fee_rate: 0.02\nfunding_rate_cap: 0.01\n"), 0o644))
+	event, err := newReloader(app, path, cfg, log).Reload()
+	require.NoError(t, err)
+	var applied []string
+	for _, change := range event.Applied {
+		applied = append(applied, change.Key)
+	}
+	assert.Equal(t, []string{"LIQUIDATION_FEE_RATE", "FUNDING_RATE_CAP"}, applied)
+	assert.Contains(t, event.IgnoredKeys(), "PORT")
+	assert.Contains(t, event.IgnoredKeys(), "SYMBOLS")
+
+	// settled state is untouched, the next liquidation pays the new rate
+	assert.Equal(t, balance, account.Balance)
+	assert.Equal(t, 100.0, ms.InsuranceFund().Balance())
+	after, err := ms.SettleLiquidation("alice", "pos_2", "BTCUSDT", common.CROSS, 10_000, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0.02, after.FeeRate)
+	assert.Equal(t, 200.0, after.Fee)
+	assert.Equal(t, 300.0, ms.InsuranceFund().Balance())
+
+	// the funding cap changes at the next boundary, the running interval keeps its cap
+	now := time.Now()
+	assert.Equal(t, 0.0075, app.fundingConfig.Get("BTCUSDT", now).RateCap)
+	assert.Equal(t, 0.01, app.fundingConfig.Get("BTCUSDT", app.funding.NextSettlement("BTCUSDT")).RateCap)
+
+	// the API keeps its address
+	_, err = health.Probe(context.Background(), "http://"+app.addr+health.Path)
+	assert.NoError(t, err)
+}
+
+func TestReloadRiskLimits(t *testing.T) {
+	cfg := testConfig(t, 0)
+	log := logger.New("error")
+	app, err := run(cfg, log)
+	require.NoError(t, err)
+	defer cleanup(app, cfg, log)
+
+	ms := app.engine.MarginSystem()
+	_, err = ms.CreateAccount("alice")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("alice", 10_000))
+
+	path := filepath.Join(t.TempDir(), "engine.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`symbols: [BTCUSDT]
+circuit_breaker:
+  max_move: 0.05
+rate_limit:
+  place: {rate: 0.001, burst: 2}
+exposure:
+  max_gross_notional: 6000
+`), 0o644))
+	event, err := newReloader(app, path, cfg, log).Reload()
+	require.NoError(t, err)
+	assert.Empty(t, event.Failed)
+	var applied []string
+	for _, change := range event.Applied {
+		applied = append(applied, change.Key)
+	}
+	assert.Equal(t, []string{"CIRCUIT_BREAKER_MAX_MOVE", "RATE_LIMIT_PLACE_RATE", "RATE_LIMIT_PLACE_BURST",
+		"EXPOSURE_MAX_GROSS_NOTIONAL"}, applied)
+
+	// 5000 + 1500 > 6000, then the placement budget of 2 is spent
+	ctx := context.Background()
+	_, err = app.server.PlaceOrder(ctx, api.PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10})
+	require.NoError(t, err)
+	small := api.PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.03, Leverage: 10}
+	_, err = app.server.PlaceOrder(ctx, small)
+	assert.ErrorContains(t, err, "exposure check")
+	_, err = app.server.PlaceOrder(ctx, small)
+	assert.ErrorIs(t, err, risk.ErrRateLimited)
+
+	// a 6% move halts under the new 5%
+	require.NoError(t, app.breaker.OnMarkPrice("BTCUSDT", 100))
+	require.NoError(t, app.breaker.OnMarkPrice("BTCUSDT", 106))
+	assert.Equal(t, risk.BreakerHalted, app.breaker.State("BTCUSDT"))
+}
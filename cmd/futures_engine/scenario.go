@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"frizo/futures_engine/internal/scenario"
+	"os"
+	"strings"
+)
+
+// runScenario `futures_engine scenario [flags]` run a canned stress scenario and print its report as JSON
+func runScenario(args []string) int {
+	flags := flag.NewFlagSet("scenario", flag.ContinueOnError)
+	var (
+		name  = flags.String("name", "crash-30pct-5m-20x", "Canned scenario: "+strings.Join(scenario.CannedNames(), ", "))
+		users = flags.Int("users", 0, "Override the number of users")
+		seed  = flags.Int64("seed", 0, "Override the RNG seed")
+		steps = flags.Bool("steps", false, "Include the per step report")
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	s, err := scenario.Canned(*name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *users > 0 {
+		s.Users = *users
+	}
+	if *seed != 0 {
+		s.Seed = *seed
+	}
+
+	runner, err := scenario.NewScenarioRunner(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	report, err := runner.Run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*steps {
+		report.Steps = nil
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
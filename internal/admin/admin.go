@@ -0,0 +1,300 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/audit"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Path prefix of the admin routes, mounted next to the REST API
+const Path = "/admin/"
+
+// kill switch modes as given to POST /admin/kill-switch and the kill-switch subcommand
+var killSwitchModes = map[string]risk.KillSwitchMode{
+	"off":        risk.KillSwitchOff,
+	"close-only": risk.KillSwitchCloseOnly,
+	"halt":       risk.KillSwitchHalt,
+}
+
+// ParseKillSwitchMode off, close-only or halt
+func ParseKillSwitchMode(mode string) (risk.KillSwitchMode, error) {
+	if m, ok := killSwitchModes[strings.ToLower(mode)]; ok {
+		return m, nil
+	}
+	return 0, fmt.Errorf("unknown kill switch mode %q, expected off, close-only or halt", mode)
+}
+
+// DepositRequest body of POST /admin/deposit
+type DepositRequest struct {
+	UserID string  `json:"user_id"`
+	Amount float64 `json:"amount"`
+}
+
+// ForceLiquidateRequest body of POST /admin/force-liquidate
+type ForceLiquidateRequest struct {
+	PositionID string `json:"position_id"`
+}
+
+// KillSwitchRequest body of POST /admin/kill-switch
+type KillSwitchRequest struct {
+	Symbol string `json:"symbol"`
+	Mode   string `json:"mode"` // off, close-only or halt
+	Reason string `json:"reason"`
+}
+
+// SnapshotResponse response of POST /admin/snapshot
+type SnapshotResponse struct {
+	Path string `json:"path"`
+}
+
+// Depositor credits an account, e.g. the margin system, or a wal.Recorder logging the deposit first
+type Depositor interface {
+	Deposit(userID string, amount float64) error
+}
+
+// Snapshotter writes a snapshot now, e.g. snapshot.SnapshotManager
+type Snapshotter interface {
+	Save() (string, error)
+}
+
+// ========================================================
+
+// Handler (管理 API) operator endpoints of a running engine under Path, every request authenticated by
+// "Authorization: Bearer <token>". the kill switch and the snapshots answer 503 until they are set
+type Handler struct {
+	engine     *engine.FuturesEngine
+	token      string
+	depositor  Depositor
+	killSwitch *risk.SymbolKillSwitch
+	snapshots  Snapshotter
+	auditor    *audit.Auditor
+	mux        *http.ServeMux
+}
+
+// NewHandler admin API of e, token "" refuses every request
+func NewHandler(e *engine.FuturesEngine, token string) *Handler {
+	h := &Handler{engine: e, token: token, depositor: e.MarginSystem(), mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /admin/positions", h.handleGetPositions)
+	h.mux.HandleFunc("GET /admin/accounts", h.handleGetAccount)
+	h.mux.HandleFunc("POST /admin/deposit", h.handleDeposit)
+	h.mux.HandleFunc("POST /admin/force-liquidate", h.handleForceLiquidate)
+	h.mux.HandleFunc("POST /admin/kill-switch", h.handleKillSwitch)
+	h.mux.HandleFunc("POST /admin/snapshot", h.handleSnapshot)
+	return h
+}
+
+// SetDepositor credit deposits through d instead of the margin system. call before serving
+func (h *Handler) SetDepositor(d Depositor) {
+	h.depositor = d
+}
+
+// SetKillSwitch switch of POST /admin/kill-switch. call before serving
+func (h *Handler) SetKillSwitch(killSwitch *risk.SymbolKillSwitch) {
+	h.killSwitch = killSwitch
+}
+
+// SetSnapshots writer of POST /admin/snapshot. call before serving
+func (h *Handler) SetSnapshots(snapshots Snapshotter) {
+	h.snapshots = snapshots
+}
+
+// SetAudit record every deposit, force liquidation and kill switch change with actor audit.ActorAdmin.
+// call before serving
+func (h *Handler) SetAudit(auditor *audit.Auditor) {
+	h.auditor = auditor
+}
+
+// record write the override to the audit log, if set, with the request id of ctx
+func (h *Handler) record(ctx context.Context, action, subject string, before, after map[string]any) {
+	if h.auditor != nil {
+		_ = h.auditor.Record(audit.Record{Actor: audit.ActorAdmin, Action: action, Subject: subject, Before: before,
+			After: after, RequestID: common.RequestIDFrom(ctx)})
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// handleGetPositions GET /admin/positions?user_id= open positions of the user, by symbol then side
+func (h *Handler) handleGetPositions(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	if _, err := h.engine.MarginSystem().GetAccount(userID); err != nil {
+		writeError(w, api.StatusCode(err), err)
+		return
+	}
+
+	positions, _ := h.engine.PositionManager().GetUserPositions(userID) // error only for a user without positions yet
+	snapshots := make([]position.PositionSnapshot, 0, len(positions))
+	for _, pos := range positions {
+		if snapshot := pos.Snapshot(); snapshot.Status != position.PositionClosed && snapshot.Size > 0 {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Symbol != snapshots[j].Symbol {
+			return snapshots[i].Symbol < snapshots[j].Symbol
+		}
+		return snapshots[i].Side > snapshots[j].Side
+	})
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+// handleGetAccount GET /admin/accounts?user_id=
+func (h *Handler) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("user_id is required"))
+		return
+	}
+	account, err := h.engine.MarginSystem().GetAccount(userID)
+	if err != nil {
+		writeError(w, api.StatusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, account.Snapshot())
+}
+
+// handleDeposit POST /admin/deposit, the account after the deposit
+func (h *Handler) handleDeposit(w http.ResponseWriter, r *http.Request) {
+	var req DepositRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid deposit: %w", err))
+		return
+	}
+	if req.UserID == "" || req.Amount <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("user_id and a positive amount are required"))
+		return
+	}
+	ms := h.engine.MarginSystem()
+	before, err := ms.GetAccount(req.UserID)
+	if err != nil {
+		writeError(w, api.StatusCode(err), err)
+		return
+	}
+	balanceBefore := before.Snapshot().Balance
+	if err := h.depositor.Deposit(req.UserID, req.Amount); err != nil {
+		writeError(w, api.StatusCode(err), err)
+		return
+	}
+	account, err := ms.GetAccount(req.UserID)
+	if err != nil {
+		writeError(w, api.StatusCode(err), err)
+		return
+	}
+	snapshot := account.Snapshot()
+	logger.FromContext(r.Context()).Warn("Admin deposit", "user_id", req.UserID, "amount", req.Amount)
+	h.record(r.Context(), audit.ActionAdminDeposit, req.UserID, map[string]any{"balance": balanceBefore},
+		map[string]any{"balance": snapshot.Balance, "amount": req.Amount})
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleForceLiquidate POST /admin/force-liquidate, the result of the liquidation
+func (h *Handler) handleForceLiquidate(w http.ResponseWriter, r *http.Request) {
+	var req ForceLiquidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid force liquidation: %w", err))
+		return
+	}
+	if req.PositionID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("position_id is required"))
+		return
+	}
+	before, err := h.engine.PositionManager().GetPositionSnapshot(req.PositionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	result, err := h.engine.LiquidationEngine().ForceLiquidate(req.PositionID)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	logger.FromContext(r.Context()).Warn("Admin force liquidation", "position_id", req.PositionID, "user_id", result.UserID,
+		"symbol", result.Symbol, "size", result.Size, "error", result.Error)
+	h.record(r.Context(), audit.ActionAdminForceLiquidate, req.PositionID, map[string]any{"size": before.Size, "status": before.Status.String()},
+		map[string]any{"remaining_size": result.RemainingSize, "close_price": result.ClosePrice, "pnl": result.PnL})
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleKillSwitch POST /admin/kill-switch, the mode change
+func (h *Handler) handleKillSwitch(w http.ResponseWriter, r *http.Request) {
+	if h.killSwitch == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("kill switch not enabled"))
+		return
+	}
+	var req KillSwitchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid kill switch: %w", err))
+		return
+	}
+	mode, err := ParseKillSwitchMode(req.Mode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !slices.Contains(h.engine.PositionManager().GetAllSymbols(), req.Symbol) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("symbol %q not found", req.Symbol))
+		return
+	}
+	event, err := h.killSwitch.KillSwitch(req.Symbol, mode, req.Reason)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	logger.FromContext(r.Context()).Warn("Admin kill switch", "symbol", event.Symbol, "from", event.From, "to", event.To,
+		"reason", event.Reason, "cancelled", len(event.Cancelled))
+	h.record(r.Context(), audit.ActionAdminKillSwitch, event.Symbol, map[string]any{"mode": event.From.String()},
+		map[string]any{"mode": event.To.String(), "reason": event.Reason, "cancelled": len(event.Cancelled)})
+	writeJSON(w, http.StatusOK, event)
+}
+
+// handleSnapshot POST /admin/snapshot, write a snapshot now
+func (h *Handler) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if h.snapshots == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("snapshots not enabled"))
+		return
+	}
+	path, err := h.snapshots.Save()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logger.FromContext(r.Context()).Info("Admin snapshot written", "path", path)
+	writeJSON(w, http.StatusOK, SnapshotResponse{Path: path})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Default().Warn("write admin response failed", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, api.ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"context"
+	"frizo/futures_engine/internal/audit"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/risk"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEngine(t *testing.T) *engine.FuturesEngine {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT"}})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(context.Background()))
+	t.Cleanup(func() { _ = e.Close() })
+	return e
+}
+
+func TestHandlerAuth(t *testing.T) {
+	e := newEngine(t)
+	_, err := e.MarginSystem().CreateAccount("alice")
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name, token, header string
+		status              int
+	}{
+		{"no token configured", "", "Bearer ", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer secreT", http.StatusUnauthorized},
+		{"bearer token", "secret", "Bearer secret", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/accounts?user_id=alice", nil)
+			req.Header.Set("Authorization", tc.header)
+			rec := httptest.NewRecorder()
+			NewHandler(e, tc.token).ServeHTTP(rec, req)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	e := newEngine(t)
+	handler := NewHandler(e, "secret")
+	records := audit.NewRecorder()
+	handler.SetAudit(audit.NewAuditor(records))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	client := NewClient(ts.URL+"/", "secret")
+	ctx := context.Background()
+
+	_, err := client.KillSwitch(ctx, "BTCUSDT", "halt", "")
+	assert.EqualError(t, err, "admin API answered 503 Service Unavailable: kill switch not enabled")
+	_, err = client.Snapshot(ctx)
+	assert.EqualError(t, err, "admin API answered 503 Service Unavailable: snapshots not enabled")
+
+	handler.SetKillSwitch(risk.NewSymbolKillSwitch(nil))
+	_, err = client.KillSwitch(ctx, "DOGEUSDT", "halt", "")
+	assert.EqualError(t, err, `admin API answered 404 Not Found: symbol "DOGEUSDT" not found`)
+	_, err = client.KillSwitch(ctx, "BTCUSDT", "pause", "")
+	assert.ErrorContains(t, err, "400 Bad Request")
+	event, err := client.KillSwitch(ctx, "BTCUSDT", "HALT", "incident")
+	require.NoError(t, err)
+	assert.Equal(t, risk.KillSwitchHalt, event.To)
+
+	_, err = client.Deposit(ctx, "nobody", 10)
+	assert.ErrorContains(t, err, "404 Not Found")
+	_, err = client.Deposit(ctx, "nobody", -10)
+	assert.ErrorContains(t, err, "400 Bad Request")
+	_, err = client.ForceLiquidate(ctx, "pos_x")
+	assert.EqualError(t, err, "admin API answered 404 Not Found: position pos_x does not exist")
+	_, err = client.Positions(ctx, "")
+	assert.EqualError(t, err, "admin API answered 400 Bad Request: user_id is required")
+
+	// only the override that went through is audited
+	require.Equal(t, []string{audit.ActionAdminKillSwitch}, records.Actions())
+	record := records.Records()[0]
+	assert.Equal(t, audit.ActorAdmin, record.Actor)
+	assert.Equal(t, "BTCUSDT", record.Subject)
+	assert.Equal(t, "incident", record.After["reason"])
+}
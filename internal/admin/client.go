@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client of the admin API of a running engine, e.g. http://localhost:8080
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient client of the engine at baseURL, authenticated by token
+func NewClient(baseURL, token string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), token: token, http: http.DefaultClient}
+}
+
+// Positions open positions of userID
+func (c *Client) Positions(ctx context.Context, userID string) ([]position.PositionSnapshot, error) {
+	var positions []position.PositionSnapshot
+	err := c.do(ctx, http.MethodGet, "positions?user_id="+url.QueryEscape(userID), nil, &positions)
+	return positions, err
+}
+
+// Account account of userID
+func (c *Client) Account(ctx context.Context, userID string) (margin.AccountSnapshot, error) {
+	var account margin.AccountSnapshot
+	err := c.do(ctx, http.MethodGet, "accounts?user_id="+url.QueryEscape(userID), nil, &account)
+	return account, err
+}
+
+// Deposit credit amount to userID, the account after the deposit
+func (c *Client) Deposit(ctx context.Context, userID string, amount float64) (margin.AccountSnapshot, error) {
+	var account margin.AccountSnapshot
+	err := c.do(ctx, http.MethodPost, "deposit", DepositRequest{UserID: userID, Amount: amount}, &account)
+	return account, err
+}
+
+// ForceLiquidate liquidate positionID whether or not it is liquidatable
+func (c *Client) ForceLiquidate(ctx context.Context, positionID string) (liquidation.LiquidationResult, error) {
+	var result liquidation.LiquidationResult
+	err := c.do(ctx, http.MethodPost, "force-liquidate", ForceLiquidateRequest{PositionID: positionID}, &result)
+	return result, err
+}
+
+// KillSwitch set the kill switch mode (off, close-only, halt) of symbol
+func (c *Client) KillSwitch(ctx context.Context, symbol, mode, reason string) (risk.KillSwitchEvent, error) {
+	var event risk.KillSwitchEvent
+	err := c.do(ctx, http.MethodPost, "kill-switch", KillSwitchRequest{Symbol: symbol, Mode: mode, Reason: reason}, &event)
+	return event, err
+}
+
+// Snapshot write a snapshot now, the path of the file
+func (c *Client) Snapshot(ctx context.Context) (SnapshotResponse, error) {
+	var snapshot SnapshotResponse
+	err := c.do(ctx, http.MethodPost, "snapshot", nil, &snapshot)
+	return snapshot, err
+}
+
+// do send body as JSON to the admin route, decode the response into out. an error with the message of
+// the engine for anything but 200
+func (c *Client) do(ctx context.Context, method, route string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+Path+route, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin API unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure api.ErrorResponse
+		if err = json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("admin API answered %s", resp.Status)
+		}
+		return fmt.Errorf("admin API answered %s: %s", resp.Status, failure.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// AuthorizationHeader header of the API key of a request, "Bearer <key>" or the bare key
+const AuthorizationHeader = "Authorization"
+
+// APIKeyQuery query parameter of the API key on GET /ws, websocket clients cannot always set headers
+const APIKeyQuery = "api_key"
+
+// APIKeyStore (API 金鑰) resolves the user an API key acts for
+type APIKeyStore interface {
+	LookupAPIKey(key string) (userID string, ok bool)
+}
+
+// MemoryAPIKeyStore in process APIKeyStore
+type MemoryAPIKeyStore struct {
+	keys map[string]string // key -> userID
+	mu   sync.RWMutex
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[string]string)}
+}
+
+// Add issue key to userID, a key belongs to one user
+func (s *MemoryAPIKeyStore) Add(key, userID string) error {
+	if key == "" || userID == "" {
+		return fmt.Errorf("api key and user id must not be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, exists := s.keys[key]; exists && owner != userID {
+		return fmt.Errorf("api key already issued to another user")
+	}
+	s.keys[key] = userID
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) Revoke(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+}
+
+func (s *MemoryAPIKeyStore) LookupAPIKey(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	userID, ok := s.keys[key]
+	return userID, ok
+}
+
+// ========================================================
+
+// apiKeyUser user of the request's API key, "" when the request has none. an unknown key is a 401
+func (s *Server) apiKeyUser(r *http.Request) (string, error) {
+	key := strings.TrimSpace(strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer "))
+	if key == "" {
+		key = r.URL.Query().Get(APIKeyQuery)
+	}
+	if key == "" {
+		return "", nil
+	}
+	if s.keys != nil {
+		if userID, ok := s.keys.LookupAPIKey(key); ok {
+			return userID, nil
+		}
+	}
+	return "", unauthorized(errors.New("invalid api key"))
+}
+
+// withAPIKey serve the requests of the user of their API key, carried by the request context
+// (common.WithUserID). 401 without a known key
+func (s *Server) withAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := s.apiKeyUser(r)
+		if err == nil && userID == "" {
+			err = unauthorized(errors.New("missing api key"))
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		next(w, r.WithContext(common.WithUserID(r.Context(), userID)))
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"frizo/futures_engine/internal/orderbook"
+	"time"
+)
+
+// command types of the orders recorded in a CommandLog
+const (
+	CommandPlaceOrder  = "place_order"
+	CommandCancelOrder = "cancel_order"
+)
+
+// CommandLog (指令日誌) write-ahead log of the state-changing calls, e.g. wal.Recorder. Record logs the
+// command, then runs apply, in the same order for every caller: replaying the log applies the commands
+// in the order they changed the state
+type CommandLog interface {
+	Record(command string, payload any, apply func()) error
+}
+
+// PlaceOrderCommand a validated order as logged: the request with the id and time it was given,
+// replayed by ApplyPlaceOrder
+type PlaceOrderCommand struct {
+	PlaceOrderRequest
+	OrderID   string    `json:"order_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CancelOrderCommand replayed by ApplyCancelOrder
+type CancelOrderCommand struct {
+	UserID  string `json:"user_id"`
+	OrderID string `json:"order_id"`
+}
+
+// SetCommandLog log every order placed or cancelled from now on. call before the server takes traffic
+func (s *Server) SetCommandLog(log CommandLog) {
+	s.commandLog = log
+}
+
+// ApplyPlaceOrder place a logged order again, with its original id and time, without logging it
+func (s *Server) ApplyPlaceOrder(ctx context.Context, command PlaceOrderCommand) (PlaceOrderResponse, error) {
+	order, err := s.validateOrder(command.PlaceOrderRequest)
+	if err != nil {
+		return PlaceOrderResponse{}, err
+	}
+	order.ID, order.Timestamp = command.OrderID, command.Timestamp
+	return s.placeOrder(ctx, command.PlaceOrderRequest, order)
+}
+
+// ApplyCancelOrder cancel a logged cancellation again, without logging it
+func (s *Server) ApplyCancelOrder(command CancelOrderCommand) (*orderbook.Order, error) {
+	return s.cancelOrder(context.Background(), command.UserID, command.OrderID)
+}
@@ -0,0 +1,396 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// PlaceOrderRequest body of POST /orders. UserID is ignored there, the order is the API key's user's
+type PlaceOrderRequest struct {
+	UserID     string  `json:"user_id"`
+	Symbol     string  `json:"symbol"`
+	Side       string  `json:"side"` // buy or sell
+	Price      float64 `json:"price"`
+	Size       float64 `json:"size"`
+	Leverage   int16   `json:"leverage"`
+	ReduceOnly bool    `json:"reduce_only"`
+}
+
+// PlaceOrderResponse the order as it stands after matching (Size is the resting size) and its trades
+type PlaceOrderResponse struct {
+	Order  orderbook.Order   `json:"order"`
+	Trades []orderbook.Trade `json:"trades"`
+}
+
+// Ticker response of GET /ticker/{symbol}, BestBid / BestAsk are 0 for an empty side
+type Ticker struct {
+	Symbol            string    `json:"symbol"`
+	MarkPrice         float64   `json:"mark_price"`
+	BestBid           float64   `json:"best_bid"`
+	BestAsk           float64   `json:"best_ask"`
+	OpenInterestLong  float64   `json:"open_interest_long"`
+	OpenInterestShort float64   `json:"open_interest_short"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// handleGetPositions GET /positions open positions of the user, by symbol then side
+func (s *Server) handleGetPositions(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.requireAccount(common.UserIDFrom(r.Context()))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	positions, _ := s.engine.PositionManager().GetUserPositions(userID) // error only for a user without positions yet
+	snapshots := make([]position.PositionSnapshot, 0, len(positions))
+	for _, pos := range positions {
+		if snapshot := pos.Snapshot(); snapshot.Status != position.PositionClosed && snapshot.Size > 0 {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Symbol != snapshots[j].Symbol {
+			return snapshots[i].Symbol < snapshots[j].Symbol
+		}
+		return snapshots[i].Side > snapshots[j].Side
+	})
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+// handleGetAccount GET /account
+func (s *Server) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := s.engine.MarginSystem().GetAccount(common.UserIDFrom(r.Context()))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, account.Snapshot())
+}
+
+// handlePlaceOrder POST /orders
+func (s *Server) handlePlaceOrder(w http.ResponseWriter, r *http.Request) {
+	var req PlaceOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, badRequest(fmt.Errorf("invalid order: %w", err)))
+		return
+	}
+	req.UserID = common.UserIDFrom(r.Context())
+	resp, err := s.PlaceOrder(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// handleCancelOrder DELETE /orders/{id}
+func (s *Server) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := s.CancelOrder(r.Context(), common.UserIDFrom(r.Context()), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// PlaceOrder limit order of req.UserID: reserve its initial margin, match, settle the fills into the
+// positions of both counterparties, rest the remaining size. reduce-only orders reserve nothing. shared
+// by the REST and gRPC layers. the order events and trades carry the request id of ctx
+func (s *Server) PlaceOrder(ctx context.Context, req PlaceOrderRequest) (PlaceOrderResponse, error) {
+	ctx = common.WithUserID(ctx, req.UserID)
+	order, err := s.validateOrder(req)
+	if err != nil {
+		return PlaceOrderResponse{}, err
+	}
+	if err = s.engine.Accepting(); err != nil {
+		return PlaceOrderResponse{}, err
+	}
+	if err = s.checkRisk(req, order); err != nil {
+		logger.FromContext(ctx).Info("Order rejected", "symbol", req.Symbol, "error", err)
+		return PlaceOrderResponse{}, err
+	}
+	if s.commandLog == nil {
+		return s.placeOrder(ctx, req, order)
+	}
+
+	var resp PlaceOrderResponse
+	var placeErr error
+	command := PlaceOrderCommand{PlaceOrderRequest: req, OrderID: order.ID, Timestamp: order.Timestamp}
+	if err = s.commandLog.Record(CommandPlaceOrder, command, func() {
+		resp, placeErr = s.placeOrder(ctx, req, order)
+	}); err != nil {
+		return PlaceOrderResponse{}, err
+	}
+	return resp, placeErr
+}
+
+// placeOrder PlaceOrder of a validated order
+func (s *Server) placeOrder(ctx context.Context, req PlaceOrderRequest, order *orderbook.Order) (PlaceOrderResponse, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	err := s.engine.Accepting()
+	if err != nil {
+		return PlaceOrderResponse{}, err
+	}
+
+	ms := s.engine.MarginSystem()
+	reserved := false
+	if !req.ReduceOnly {
+		side := position.LONG
+		if order.Side == orderbook.SELL {
+			side = position.SHORT
+		}
+		if err = ms.CheckOrderMarginForSide(req.UserID, req.Symbol, side, req.Size, req.Price, req.Leverage); err != nil {
+			return PlaceOrderResponse{}, err
+		}
+		initialMargin, err := ms.CalculateInitialMargin(req.Symbol, req.Size, req.Price, req.Leverage)
+		if err != nil {
+			return PlaceOrderResponse{}, err
+		}
+		if err = ms.ReserveMarginForOrder(ctx, req.UserID, order.ID, initialMargin); err != nil {
+			return PlaceOrderResponse{}, err
+		}
+		reserved = true
+	}
+
+	// the resting order belongs to the book once placed, report a copy
+	placed := *order
+	matchStart := time.Now()
+	trades, err := s.books[req.Symbol].PlaceOrder(order)
+	s.observeOrder(req.Symbol, placed.Side.String(), time.Since(matchStart), len(trades), err != nil)
+	for _, trade := range trades {
+		placed.Size -= trade.Size
+	}
+	taker := orderRef{userID: req.UserID, symbol: req.Symbol, side: order.Side, price: req.Price, remaining: placed.Size,
+		leverage: req.Leverage, reduceOnly: req.ReduceOnly}
+	if err == nil && placed.Size > 0 {
+		s.mu.Lock()
+		s.orders[order.ID] = taker
+		s.mu.Unlock()
+	}
+	requestID := common.RequestIDFrom(ctx)
+	if err != nil {
+		s.settleTrades(ctx, order.ID, taker, trades) // matched before the rest failed to rest
+		if reserved {
+			_ = ms.ReleaseMarginReservation(req.UserID, order.ID) // nothing rests
+		}
+		s.publishOrderEvent(orderbook.OrderEvent{Type: orderbook.OrderRejected, UserID: req.UserID, OrderID: order.ID,
+			Symbol: req.Symbol, Side: order.Side, Price: req.Price, Reason: err.Error(), RequestID: requestID})
+		logger.FromContext(ctx).Info("Order rejected", "order_id", order.ID, "symbol", req.Symbol, "error", err)
+		return PlaceOrderResponse{}, badRequest(err)
+	}
+	for i := range trades {
+		trades[i].RequestID = requestID
+	}
+	accepted := placed
+	accepted.Size = req.Size
+	s.publishOrderUpdates(accepted, req.Symbol, trades, requestID)
+	s.settleTrades(ctx, order.ID, taker, trades)
+	s.publishAccount(req.UserID)
+	s.publishBook(req.Symbol)
+	logger.FromContext(ctx).Debug("Order placed", "order_id", order.ID, "symbol", req.Symbol, "side", order.Side.String(),
+		"price", req.Price, "size", req.Size, "trades", len(trades))
+	s.recordTrades(trades)
+	if trades == nil {
+		trades = []orderbook.Trade{}
+	}
+	return PlaceOrderResponse{Order: placed, Trades: trades}, nil
+}
+
+// CancelOrder cancel a resting order of the user and release its margin. the order event carries the
+// request id of ctx
+func (s *Server) CancelOrder(ctx context.Context, userID, orderID string) (*orderbook.Order, error) {
+	ctx = common.WithUserID(ctx, userID)
+	if err := s.checkCancel(userID); err != nil {
+		return nil, err
+	}
+	if s.commandLog == nil {
+		return s.cancelOrder(ctx, userID, orderID)
+	}
+
+	// unknown orders are not worth a log entry
+	s.mu.Lock()
+	ref, exists := s.orders[orderID]
+	s.mu.Unlock()
+	if !exists || ref.userID != userID {
+		return nil, notFound(fmt.Errorf("order %s not found", orderID))
+	}
+
+	var order *orderbook.Order
+	var cancelErr error
+	if err := s.commandLog.Record(CommandCancelOrder, CancelOrderCommand{UserID: userID, OrderID: orderID}, func() {
+		order, cancelErr = s.cancelOrder(ctx, userID, orderID)
+	}); err != nil {
+		return nil, err
+	}
+	return order, cancelErr
+}
+
+func (s *Server) cancelOrder(ctx context.Context, userID, orderID string) (*orderbook.Order, error) {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	s.mu.Lock()
+	ref, exists := s.orders[orderID]
+	if exists && ref.userID == userID {
+		delete(s.orders, orderID)
+	}
+	s.mu.Unlock()
+	if !exists || ref.userID != userID {
+		return nil, notFound(fmt.Errorf("order %s not found", orderID))
+	}
+
+	order, err := s.books[ref.symbol].CancelOrder(ref.side, orderID)
+	if err != nil {
+		return nil, notFound(err) // filled in the meantime
+	}
+	_ = s.engine.MarginSystem().ReleaseMarginReservation(userID, orderID) // reduce-only or expired: none left
+	s.metricsRegistry().AddCounter(MetricOrderCancels, 1, metrics.Labels{"symbol": ref.symbol})
+	s.publishOrderEvent(orderbook.OrderEvent{Type: orderbook.OrderCanceled, UserID: userID, OrderID: orderID,
+		Symbol: ref.symbol, Side: ref.side, Price: order.Price, RemainingSize: order.Size, Reason: "user",
+		RequestID: common.RequestIDFrom(ctx)})
+	logger.FromContext(ctx).Debug("Order canceled", "order_id", orderID, "symbol", ref.symbol)
+	s.publishAccount(userID)
+	s.publishBook(ref.symbol)
+	return order, nil
+}
+
+// handleGetTicker GET /ticker/{symbol}
+func (s *Server) handleGetTicker(w http.ResponseWriter, r *http.Request) {
+	ticker, err := s.ticker(r.PathValue("symbol"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ticker)
+}
+
+// handleGetInsuranceFundHistory GET /insurance-fund/history?from=&to= insurance fund events within [from, to],
+// RFC 3339 times, from defaults to the start of the history and to to now
+func (s *Server) handleGetInsuranceFundHistory(w http.ResponseWriter, r *http.Request) {
+	from, err := timeParam(r, "from", time.Time{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	to, err := timeParam(r, "to", time.Now())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if to.Before(from) {
+		writeError(w, badRequest(errors.New("to must not be before from")))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.MarginSystem().GetInsuranceFundHistory(from, to))
+}
+
+// timeParam RFC 3339 query parameter name, fallback when absent
+func timeParam(r *http.Request, name string, fallback time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, badRequest(fmt.Errorf("%s must be an RFC 3339 time", name))
+	}
+	return parsed, nil
+}
+
+func (s *Server) ticker(symbol string) (Ticker, error) {
+	book, exists := s.books[symbol]
+	if !exists {
+		return Ticker{}, fmt.Errorf("%w: %s", position.ErrSymbolNotFound, symbol)
+	}
+
+	pm := s.engine.PositionManager()
+	long, short, err := pm.GetOpenInterestBySide(symbol)
+	if err != nil {
+		return Ticker{}, err
+	}
+	ticker := Ticker{
+		Symbol:            symbol,
+		MarkPrice:         pm.GetSymbolMarkPrice(symbol),
+		OpenInterestLong:  long,
+		OpenInterestShort: short,
+		Timestamp:         time.Now(),
+	}
+	if bids := book.Depth(orderbook.BUY, 1); len(bids) > 0 {
+		ticker.BestBid = bids[0][0]
+	}
+	if asks := book.Depth(orderbook.SELL, 1); len(asks) > 0 {
+		ticker.BestAsk = asks[0][0]
+	}
+	return ticker, nil
+}
+
+// PremiumIndex (溢價指數) (book mid - mark price) / mark price of symbol, 0 while a side of the book is
+// empty or the symbol has no mark price yet. fits funding.PremiumIndexFunc
+func (s *Server) PremiumIndex(symbol string) (float64, error) {
+	ticker, err := s.ticker(symbol)
+	if err != nil {
+		return 0, err
+	}
+	if ticker.BestBid <= 0 || ticker.BestAsk <= 0 || ticker.MarkPrice <= 0 {
+		return 0, nil
+	}
+	return ((ticker.BestBid+ticker.BestAsk)/2 - ticker.MarkPrice) / ticker.MarkPrice, nil
+}
+
+// requireAccount the user id if it names an account
+func (s *Server) requireAccount(userID string) (string, error) {
+	if userID == "" {
+		return "", badRequest(errors.New("user_id is required"))
+	}
+	if _, err := s.engine.MarginSystem().GetAccount(userID); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// validateOrder order of the request, checks everything but margin
+func (s *Server) validateOrder(req PlaceOrderRequest) (*orderbook.Order, error) {
+	if _, err := s.requireAccount(req.UserID); err != nil {
+		return nil, err
+	}
+	if _, exists := s.books[req.Symbol]; !exists {
+		return nil, fmt.Errorf("%w: %s", position.ErrSymbolNotFound, req.Symbol)
+	}
+
+	order := &orderbook.Order{
+		ID:         common.GenerateShortUUID("ord"),
+		UserID:     req.UserID,
+		Price:      req.Price,
+		Size:       req.Size,
+		Timestamp:  time.Now(),
+		ReduceOnly: req.ReduceOnly,
+		Leverage:   req.Leverage,
+	}
+	switch req.Side {
+	case "buy":
+		order.Side = orderbook.BUY
+	case "sell":
+		order.Side = orderbook.SELL
+	default:
+		return nil, badRequest(fmt.Errorf("side must be buy or sell, got %q", req.Side))
+	}
+	if req.Price <= 0 || req.Size <= 0 {
+		return nil, badRequest(errors.New("order price and size must be greater than zero"))
+	}
+	if !req.ReduceOnly && req.Leverage <= 0 {
+		return nil, badRequest(errors.New("leverage must be greater than zero"))
+	}
+	return order, nil
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/history"
+	"frizo/futures_engine/internal/orderbook"
+	"net/http"
+	"strconv"
+)
+
+// TradeRecorder durable trade history fed by the matching, e.g. history.Recorder. RecordTrade must not
+// block: it runs on the order path
+type TradeRecorder interface {
+	RecordTrade(trade orderbook.Trade) bool
+}
+
+// SetHistory record every trade matched from now on to recorder and serve the /history routes from
+// reader. either may be nil. call before the server takes traffic, after a command log replay so
+// replayed trades are not recorded twice
+func (s *Server) SetHistory(recorder TradeRecorder, reader history.Reader) {
+	s.tradeRecorder = recorder
+	s.historyReader = reader
+}
+
+// OnTrade call fn with every trade matched from now on, e.g. to publish it. fn runs on the order path
+// and must not block. call before the server takes traffic
+func (s *Server) OnTrade(fn func(orderbook.Trade)) {
+	s.onTrade = append(s.onTrade, fn)
+}
+
+func (s *Server) recordTrades(trades []orderbook.Trade) {
+	for _, trade := range trades {
+		if s.tradeRecorder != nil {
+			s.tradeRecorder.RecordTrade(trade)
+		}
+		for _, fn := range s.onTrade {
+			fn(trade)
+		}
+	}
+}
+
+// handleGetClosedPositions GET /history/positions?limit= closed positions of the user, newest first
+func (s *Server) handleGetClosedPositions(w http.ResponseWriter, r *http.Request) {
+	userID, limit, err := s.historyQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	positions, err := s.historyReader.ClosedPositions(r.Context(), userID, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, positions)
+}
+
+// handleGetTradeHistory GET /history/trades?limit= trades of the user on either side, newest first
+func (s *Server) handleGetTradeHistory(w http.ResponseWriter, r *http.Request) {
+	userID, limit, err := s.historyQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	trades, err := s.historyReader.Trades(r.Context(), userID, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trades)
+}
+
+// handleGetLedger GET /history/ledger?limit= balance operations of the user, newest first
+func (s *Server) handleGetLedger(w http.ResponseWriter, r *http.Request) {
+	userID, limit, err := s.historyQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	entries, err := s.historyReader.LedgerEntries(r.Context(), userID, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// historyQuery user id and limit of a /history request. users no longer served keep their history
+func (s *Server) historyQuery(r *http.Request) (string, int, error) {
+	if s.historyReader == nil {
+		return "", 0, notFound(errors.New("history not enabled"))
+	}
+	query := r.URL.Query()
+	userID := common.UserIDFrom(r.Context())
+	limit := history.DefaultQueryLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > history.MaxQueryLimit {
+			return "", 0, badRequest(fmt.Errorf("limit must be between 1 and %d", history.MaxQueryLimit))
+		}
+		limit = parsed
+	}
+	return userID, limit, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"frizo/futures_engine/internal/history"
+	"frizo/futures_engine/internal/orderbook"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHistory keeps the recorded trades in memory, newest first
+type fakeHistory struct {
+	mu     sync.Mutex
+	trades []history.Trade
+}
+
+func (h *fakeHistory) RecordTrade(trade orderbook.Trade) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trades = append([]history.Trade{{Sequence: uint64(len(h.trades) + 1), Trade: trade}}, h.trades...)
+	return true
+}
+
+func (h *fakeHistory) ClosedPositions(ctx context.Context, userID string, limit int) ([]history.ClosedPosition, error) {
+	return []history.ClosedPosition{}, nil
+}
+
+func (h *fakeHistory) Trades(ctx context.Context, userID string, limit int) ([]history.Trade, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	trades := []history.Trade{}
+	for _, trade := range h.trades {
+		if len(trades) < limit && (trade.BuyUserID == userID || trade.SellUserID == userID) {
+			trades = append(trades, trade)
+		}
+	}
+	return trades, nil
+}
+
+func (h *fakeHistory) LedgerEntries(ctx context.Context, userID string, limit int) ([]history.LedgerEntry, error) {
+	return []history.LedgerEntry{}, nil
+}
+
+func TestHistoryRoutes(t *testing.T) {
+	_, server, ts := newStreamTestServer(t, nil)
+
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusNotFound, doAs(t, "alice", http.MethodGet, ts.URL+"/history/trades", nil, &errResp))
+	assert.Equal(t, "history not enabled", errResp.Error)
+
+	store := &fakeHistory{}
+	server.SetHistory(store, store)
+	for _, order := range []struct {
+		userID string
+		req    PlaceOrderRequest
+	}{
+		{"alice", PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10}},
+		{"bob", PlaceOrderRequest{Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.04, Leverage: 10}},
+		{"bob", PlaceOrderRequest{Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.06, Leverage: 10}},
+	} {
+		require.Equal(t, http.StatusCreated, doAs(t, order.userID, http.MethodPost, ts.URL+"/orders", order.req, nil))
+	}
+
+	var trades []history.Trade
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodGet, ts.URL+"/history/trades", nil, &trades))
+	require.Len(t, trades, 2)
+	assert.Equal(t, uint64(2), trades[0].Sequence, "newest first")
+	assert.Equal(t, 0.06, trades[0].Size)
+	assert.Equal(t, "bob", trades[0].SellUserID)
+
+	trades = nil
+	assert.Equal(t, http.StatusOK, doAs(t, "bob", http.MethodGet, ts.URL+"/history/trades?limit=1", nil, &trades))
+	assert.Len(t, trades, 1)
+
+	var positions []history.ClosedPosition
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodGet, ts.URL+"/history/positions", nil, &positions))
+	assert.Empty(t, positions)
+	var entries []history.LedgerEntry
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodGet, ts.URL+"/history/ledger", nil, &entries))
+	assert.Empty(t, entries)
+
+	assert.Equal(t, http.StatusUnauthorized, do(t, http.MethodGet, ts.URL+"/history/trades", nil, &errResp))
+	assert.Equal(t, http.StatusBadRequest, doAs(t, "alice", http.MethodGet, ts.URL+"/history/ledger?limit=5000", nil, &errResp))
+}
+
+func TestTradeAndOrderEventHooks(t *testing.T) {
+	_, server, ts := newStreamTestServer(t, nil)
+	var mu sync.Mutex
+	var trades []orderbook.Trade
+	var orderEvents []orderbook.OrderEvent
+	server.OnTrade(func(trade orderbook.Trade) {
+		mu.Lock()
+		defer mu.Unlock()
+		trades = append(trades, trade)
+	})
+	server.OnOrderEvent(func(event orderbook.OrderEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		orderEvents = append(orderEvents, event)
+	})
+
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders",
+		PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10}, nil))
+	require.Equal(t, http.StatusCreated, doAs(t, "bob", http.MethodPost, ts.URL+"/orders",
+		PlaceOrderRequest{Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.1, Leverage: 10}, nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, trades, 1)
+	assert.Equal(t, "alice", trades[0].BuyUserID)
+	require.Len(t, orderEvents, 4, "two accepted, two filled")
+	assert.Equal(t, orderbook.OrderFilled, orderEvents[3].Type)
+	assert.NotZero(t, orderEvents[3].Sequence, "user sequence assigned")
+}
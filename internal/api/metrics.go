@@ -0,0 +1,59 @@
+package api
+
+import (
+	"frizo/futures_engine/internal/metrics"
+	"time"
+)
+
+// metrics of the API server, labelled by symbol except the stream ones. order rates are the rates of the counters
+const (
+	MetricOrders           = "orders_total" // placed orders, also labelled by side
+	MetricOrderRejections  = "order_rejections_total"
+	MetricOrderCancels     = "order_cancels_total"
+	MetricTrades           = "trades_total"
+	MetricMatchLatency     = "match_latency_seconds" // order book matching of one order
+	MetricStreamClients    = "stream_clients"        // websocket and gRPC subscribers
+	MetricStreamQueueDepth = "stream_send_queue_max" // messages waiting in the fullest send buffer
+	MetricStreamDropped    = "stream_dropped_clients_total"
+)
+
+// SetMetrics registry of the server's order metrics, nil means metrics.Nop
+func (s *Server) SetMetrics(registry metrics.Registry) {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	s.metrics = metrics.OrNop(registry)
+}
+
+func (s *Server) metricsRegistry() metrics.Registry {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	return s.metrics
+}
+
+// ReportMetrics set the stream gauges and count the slow subscribers dropped since the last report,
+// meant for a scrape hook
+func (s *Server) ReportMetrics(registry metrics.Registry) {
+	registry.SetGauge(MetricStreamClients, float64(s.stream.clientCount()), nil)
+	registry.SetGauge(MetricStreamQueueDepth, float64(s.stream.maxQueueDepth()), nil)
+
+	dropped := s.stream.dropped.Load()
+	s.metricsMu.Lock()
+	delta := dropped - s.reportedDrops
+	s.reportedDrops = dropped
+	s.metricsMu.Unlock()
+	registry.AddCounter(MetricStreamDropped, float64(delta), nil)
+}
+
+// observeOrder a placed order: matched in latency with trades, or rejected by the book
+func (s *Server) observeOrder(symbol, side string, latency time.Duration, trades int, rejected bool) {
+	registry := s.metricsRegistry()
+	labels := metrics.Labels{"symbol": symbol}
+	registry.AddCounter(MetricOrders, 1, metrics.Labels{"symbol": symbol, "side": side})
+	registry.Observe(MetricMatchLatency, latency.Seconds(), labels)
+	if rejected {
+		registry.AddCounter(MetricOrderRejections, 1, labels)
+	}
+	if trades > 0 {
+		registry.AddCounter(MetricTrades, float64(trades), labels)
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/position"
+	"net/http"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// value of the series of family with labels, false when absent
+func seriesValue(families map[string]*dto.MetricFamily, family string, labels map[string]string) (float64, bool) {
+	mf, ok := families[family]
+	if !ok {
+		return 0, false
+	}
+next:
+	for _, m := range mf.GetMetric() {
+		got := make(map[string]string)
+		for _, pair := range m.GetLabel() {
+			got[pair.GetName()] = pair.GetValue()
+		}
+		for key, value := range labels {
+			if got[key] != value {
+				continue next
+			}
+		}
+		switch {
+		case m.Counter != nil:
+			return m.GetCounter().GetValue(), true
+		case m.Gauge != nil:
+			return m.GetGauge().GetValue(), true
+		case m.Histogram != nil:
+			return float64(m.GetHistogram().GetSampleCount()), true
+		}
+	}
+	return 0, false
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	e, server, ts := newStreamTestServer(t, &StreamConfig{TickerInterval: time.Hour})
+	registry := metrics.NewPrometheus(nil)
+	e.SetMetrics(registry)
+	server.SetMetrics(registry)
+	registry.OnScrape(e.ReportMetrics)
+	registry.OnScrape(server.ReportMetrics)
+	server.Handle("GET /metrics", registry.Handler())
+	e.PositionManager().EnablePreLiquidationWarnings(nil)
+
+	// order flow: a resting bid, a partial cross, a cancel, a rejected order
+	bid := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.2, Leverage: 10}
+	var placed PlaceOrderResponse
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, &placed))
+	ask := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.1, Leverage: 10}
+	require.Equal(t, http.StatusCreated, doAs(t, "bob", http.MethodPost, ts.URL+"/orders", ask, nil))
+	require.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, nil))
+	subscriber := dialStream(t, ts, "")
+	subscribe(t, subscriber, StreamRequest{Channel: ChannelTicker, Symbol: "BTCUSDT"})
+
+	// positions: bob's stays, alice's gets a margin call then is liquidated
+	ctx := context.Background()
+	_, err := e.OpenPosition(ctx, common.ISOLATED, "alice", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	_, err = e.OpenPosition(ctx, common.ISOLATED, "bob", "BTCUSDT", position.SHORT, 50000, 0.1, 2)
+	require.NoError(t, err)
+	_, err = e.OpenPosition(ctx, common.ISOLATED, "bob", "ETHUSDT", position.LONG, 3000, 1, 5)
+	require.NoError(t, err)
+	_, err = e.UpdateMarkPrice(ctx, "BTCUSDT", 45250) // below 1.5x the maintenance ratio
+	require.NoError(t, err)
+	results, err := e.UpdateMarkPrice(ctx, "BTCUSDT", 45150) // below liquidation, above bankruptcy: no ADL of bob
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Nil(t, results[0].ADL)
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	require.NoError(t, err)
+
+	btc := map[string]string{"symbol": "BTCUSDT"}
+	eth := map[string]string{"symbol": "ETHUSDT"}
+	for _, tt := range []struct {
+		family string
+		labels map[string]string
+		want   float64
+	}{
+		{"futures_engine_orders_total", map[string]string{"symbol": "BTCUSDT", "side": "buy"}, 1},
+		{"futures_engine_orders_total", map[string]string{"symbol": "BTCUSDT", "side": "sell"}, 1},
+		{"futures_engine_trades_total", btc, 1},
+		{"futures_engine_order_cancels_total", btc, 1},
+		{"futures_engine_match_latency_seconds", btc, 2},
+		{"futures_engine_open_positions", btc, 1}, // alice's was liquidated
+		{"futures_engine_open_positions", eth, 1},
+		{"futures_engine_open_interest", eth, 1},
+		{"futures_engine_margin_calls_total", btc, 1},
+		{"futures_engine_liquidations_total", btc, 1},
+		{"futures_engine_stream_clients", nil, 1},
+	} {
+		value, ok := seriesValue(families, tt.family, tt.labels)
+		if assert.True(t, ok, "%s%v missing", tt.family, tt.labels) {
+			assert.Equal(t, tt.want, value, "%s%v", tt.family, tt.labels)
+		}
+	}
+
+	age, ok := seriesValue(families, "futures_engine_mark_price_age_seconds", btc)
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, age, 0.0)
+	assert.Less(t, age, 60.0)
+	_, ok = seriesValue(families, "futures_engine_mark_price_age_seconds", eth)
+	assert.False(t, ok, "no mark price yet")
+
+	fund, ok := seriesValue(families, "futures_engine_insurance_fund_balance", nil)
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, fund, 0.0)
+	pending, ok := seriesValue(families, "futures_engine_pending_liquidations", nil)
+	require.True(t, ok)
+	assert.Zero(t, pending)
+	goroutines, ok := seriesValue(families, "go_goroutines", nil)
+	require.True(t, ok)
+	assert.Greater(t, goroutines, 0.0)
+	dropped, ok := seriesValue(families, "futures_engine_metrics_registry_errors_total", nil)
+	require.True(t, ok)
+	assert.Zero(t, dropped)
+}
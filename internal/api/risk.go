@@ -0,0 +1,51 @@
+package api
+
+import (
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+)
+
+// SetRisk run pipeline on every order placed from now on, before its margin is reserved, and take a
+// cancel token of limiter for every cancellation. either may be nil. logged orders replay without the
+// checks: they passed them when placed. call before the server takes traffic
+func (s *Server) SetRisk(pipeline *risk.RiskPipeline, limiter *risk.RateLimiter) {
+	s.riskPipeline = pipeline
+	s.cancelLimiter = limiter
+}
+
+// OpenOrderNotional implements risk.OpenOrderNotionalProvider: price times remaining size of the user's
+// resting orders per symbol. reduce-only orders add no exposure
+func (s *Server) OpenOrderNotional(userID string) map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notional := make(map[string]float64)
+	for _, ref := range s.orders {
+		if ref.userID == userID && !ref.reduceOnly {
+			notional[ref.symbol] += ref.price * ref.remaining
+		}
+	}
+	return notional
+}
+
+// checkRisk the pre-trade checks of a validated order, a *risk.Rejection when one fails
+func (s *Server) checkRisk(req PlaceOrderRequest, order *orderbook.Order) error {
+	if s.riskPipeline == nil {
+		return nil
+	}
+	side := position.LONG
+	if order.Side == orderbook.SELL {
+		side = position.SHORT
+	}
+	return s.riskPipeline.Check(&risk.OrderRequest{UserID: req.UserID, Symbol: req.Symbol, Side: side,
+		Price: req.Price, Size: req.Size, Leverage: req.Leverage, ReduceOnly: req.ReduceOnly})
+}
+
+// checkCancel the cancel rate limit of the user
+func (s *Server) checkCancel(userID string) error {
+	if s.cancelLimiter == nil {
+		return nil
+	}
+	return s.cancelLimiter.Allow(userID, risk.ActionCancel, risk.SourceUser)
+}
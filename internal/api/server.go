@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/history"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout deadline of the in-flight requests on Shutdown when the context has none
+const DefaultShutdownTimeout = 10 * time.Second
+
+// RequestIDHeader response header of the id given to every request, see common.WithRequestID
+const RequestIDHeader = "X-Request-ID"
+
+// orderRef where a resting order lives, for DELETE /orders/{id}, how its fills settle and what it adds
+// to the exposure of its user
+type orderRef struct {
+	userID     string
+	symbol     string
+	side       orderbook.Side
+	price      float64
+	remaining  float64 // size left on the book
+	leverage   int16
+	reduceOnly bool
+}
+
+// Server (REST API) HTTP facade of the engine: positions, accounts, orders and tickers, plus the
+// websocket stream of their updates. one order book per engine symbol, order margin is reserved
+// through the margin system
+type Server struct {
+	engine *engine.FuturesEngine
+	keys   APIKeyStore // users of the API keys, nil refuses every private route
+	books  map[string]*orderbook.OrderBook
+	orders map[string]orderRef // resting order id -> location
+	mu     sync.Mutex          // guards orders
+	writes sync.RWMutex        // held shared by PlaceOrder / CancelOrder, exclusively by Quiesce
+
+	commandLog    CommandLog         // nil: orders are not logged
+	tradeRecorder TradeRecorder      // nil: trades are not recorded
+	historyReader history.Reader     // nil: no /history routes
+	riskPipeline  *risk.RiskPipeline // nil: orders are not risk checked
+	cancelLimiter *risk.RateLimiter  // nil: cancels are not rate limited
+	onTrade       []func(orderbook.Trade)
+	onOrderEvent  []func(orderbook.OrderEvent)
+
+	stream       *streamHub
+	orderEvents  *orderbook.OrderEventHub // per user order sequences
+	stopStream   func()
+	stopStreamMu sync.Once
+
+	metrics       metrics.Registry // metrics.Nop by default
+	reportedDrops int64            // stream drops counted by ReportMetrics
+	metricsMu     sync.Mutex
+
+	mux  *http.ServeMux
+	http *http.Server
+}
+
+// NewServer server listening on addr (host:port) once ListenAndServe is called. the routes of a user
+// act for the user of the API key of the request, resolved by keys. the stream starts forwarding engine
+// events right away, until Shutdown. stream nil means the defaults
+func NewServer(e *engine.FuturesEngine, addr string, stream *StreamConfig, keys APIKeyStore) *Server {
+	s := &Server{
+		engine:      e,
+		keys:        keys,
+		books:       make(map[string]*orderbook.OrderBook),
+		orders:      make(map[string]orderRef),
+		stream:      newStreamHub(stream.withDefaults()),
+		orderEvents: orderbook.NewOrderEventHub(0),
+		metrics:     metrics.Nop{},
+	}
+	for _, symbol := range e.PositionManager().GetAllSymbols() {
+		s.books[symbol] = orderbook.NewOrderBook(symbol)
+	}
+
+	events, cancel := e.PositionManager().SubscribeEvents(s.stream.config.SendBuffer)
+	stop := make(chan struct{})
+	go s.forwardPositionEvents(events)
+	go s.pushTickers(stop)
+	s.stopStream = func() {
+		cancel()
+		close(stop)
+		s.stream.closeAll()
+	}
+
+	mux := http.NewServeMux()
+	s.mux = mux
+	mux.HandleFunc("GET /positions", s.withAPIKey(s.handleGetPositions))
+	mux.HandleFunc("GET /account", s.withAPIKey(s.handleGetAccount))
+	mux.HandleFunc("POST /orders", s.withAPIKey(s.handlePlaceOrder))
+	mux.HandleFunc("DELETE /orders/{id}", s.withAPIKey(s.handleCancelOrder))
+	mux.HandleFunc("GET /ticker/{symbol}", s.handleGetTicker)
+	mux.HandleFunc("GET /insurance-fund/history", s.handleGetInsuranceFundHistory)
+	mux.HandleFunc("GET /history/positions", s.withAPIKey(s.handleGetClosedPositions))
+	mux.HandleFunc("GET /history/trades", s.withAPIKey(s.handleGetTradeHistory))
+	mux.HandleFunc("GET /history/ledger", s.withAPIKey(s.handleGetLedger))
+	mux.HandleFunc("GET /ws", s.handleStream) // the private channels need an API key
+
+	s.http = &http.Server{Addr: addr, Handler: withRequestID(s.withSequence(mux)), ReadHeaderTimeout: 5 * time.Second}
+	return s
+}
+
+// Handler routes of the server, for tests and embedding
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// Handle serve handler next to the API routes, e.g. "GET /metrics". call before ListenAndServe
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// OrderBook book of symbol, nil for unknown symbols
+func (s *Server) OrderBook(symbol string) *orderbook.OrderBook {
+	return s.books[symbol]
+}
+
+// ListenAndServe serve until Shutdown, return nil after a graceful shutdown
+func (s *Server) ListenAndServe() error {
+	if err := s.http.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Serve serve on lis until Shutdown, e.g. a listener on port 0. return nil after a graceful shutdown
+func (s *Server) Serve(lis net.Listener) error {
+	if err := s.http.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stop accepting connections, disconnect the stream and wait for the in-flight requests,
+// DefaultShutdownTimeout when ctx has no deadline
+func (s *Server) Shutdown(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultShutdownTimeout)
+		defer cancel()
+	}
+	s.stopStreamMu.Do(s.stopStream)
+	return s.http.Shutdown(ctx)
+}
+
+// ========================================================
+
+// ErrorResponse body of every non 2xx response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError error carrying its status code
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+func (e *httpError) Unwrap() error { return e.err }
+
+func badRequest(err error) error { return &httpError{status: http.StatusBadRequest, err: err} }
+func notFound(err error) error   { return &httpError{status: http.StatusNotFound, err: err} }
+func unauthorized(err error) error {
+	return &httpError{status: http.StatusUnauthorized, err: err}
+}
+
+// StatusCode HTTP status of err: typed engine errors get their own, 500 for the rest
+func StatusCode(err error) int {
+	var httpErr *httpError
+	var rejection *risk.Rejection
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.status
+	case errors.Is(err, risk.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, position.ErrSymbolNotFound), errors.Is(err, margin.ErrAccountNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, margin.ErrInsufficientMargin), errors.Is(err, margin.ErrAccountMerged),
+		errors.As(err, &rejection):
+		return http.StatusBadRequest
+	case errors.Is(err, engine.ErrEngineNotStarted), errors.Is(err, engine.ErrEngineClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// withRequestID give every request a new id: carried by its context into the logs, order events and
+// audit records it causes, and answered in RequestIDHeader
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := common.GenerateShortUUID("req")
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(common.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Default().Warn("write response failed", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, StatusCode(err), ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,495 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/risk"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) (*engine.FuturesEngine, *httptest.Server) {
+	e, _, ts := newStreamTestServer(t, nil)
+	return e, ts
+}
+
+func newStreamTestServer(t *testing.T, stream *StreamConfig) (*engine.FuturesEngine, *Server, *httptest.Server) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT", "ETHUSDT"}})
+	require.NoError(t, err)
+	for _, userID := range []string{"alice", "bob"} {
+		_, err = e.MarginSystem().CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, e.MarginSystem().Deposit(userID, 10_000))
+	}
+	require.NoError(t, e.Start(context.Background()))
+
+	keys := NewMemoryAPIKeyStore()
+	for _, userID := range []string{"alice", "bob", "nobody"} {
+		require.NoError(t, keys.Add(userID+"-key", userID))
+	}
+	server := NewServer(e, "", stream, keys)
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(func() {
+		_ = server.Shutdown(context.Background())
+		ts.Close()
+		_ = e.Close()
+	})
+	return e, server, ts
+}
+
+func do(t *testing.T, method, url string, body any, out any) int {
+	t.Helper()
+	return doAs(t, "", method, url, body, out)
+}
+
+// doAs do the request with the API key of userID, the test servers' userID+"-key". "" sends none
+func doAs(t *testing.T, userID, method, url string, body any, out any) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&payload).Encode(body))
+	}
+	req, err := http.NewRequest(method, url, &payload)
+	require.NoError(t, err)
+	if userID != "" {
+		req.Header.Set(AuthorizationHeader, "Bearer "+userID+"-key")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestPositionsAndAccount(t *testing.T) {
+	e, ts := newTestServer(t)
+	_, err := e.OpenPosition(context.Background(), common.ISOLATED, "alice", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+
+	var positions []position.PositionSnapshot
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodGet, ts.URL+"/positions", nil, &positions))
+	require.Len(t, positions, 1)
+	assert.Equal(t, "BTCUSDT", positions[0].Symbol)
+	assert.Equal(t, 0.1, positions[0].Size)
+
+	positions = nil
+	assert.Equal(t, http.StatusOK, doAs(t, "bob", http.MethodGet, ts.URL+"/positions", nil, &positions))
+	assert.Empty(t, positions)
+
+	var account margin.AccountSnapshot
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodGet, ts.URL+"/account", nil, &account))
+	assert.Equal(t, "alice", account.UserID)
+	assert.Equal(t, 10_000.0, account.Balance)
+	assert.InDelta(t, 500, account.PositionMargin, 1e-9)
+
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusNotFound, doAs(t, "nobody", http.MethodGet, ts.URL+"/account", nil, &errResp))
+	assert.NotEmpty(t, errResp.Error)
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	e, ts := newTestServer(t)
+
+	// every route of a user needs a known key, the ticker is public
+	var errResp ErrorResponse
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/positions"}, {http.MethodGet, "/account"}, {http.MethodPost, "/orders"},
+		{http.MethodDelete, "/orders/ord_1"}, {http.MethodGet, "/history/trades"},
+	} {
+		assert.Equal(t, http.StatusUnauthorized, do(t, route.method, ts.URL+route.path, nil, &errResp), route.path)
+		assert.Equal(t, "missing api key", errResp.Error)
+		assert.Equal(t, http.StatusUnauthorized, doAs(t, "mallory", route.method, ts.URL+route.path, nil, &errResp), route.path)
+		assert.Equal(t, "invalid api key", errResp.Error)
+	}
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/ticker/BTCUSDT", nil, &Ticker{}))
+
+	// the user of the key, never the one of the body or the query
+	var placed PlaceOrderResponse
+	bid := PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10}
+	require.Equal(t, http.StatusCreated, doAs(t, "bob", http.MethodPost, ts.URL+"/orders", bid, &placed))
+	assert.Equal(t, "bob", placed.Order.UserID)
+	assert.Empty(t, e.MarginSystem().GetPendingReservations("alice"))
+	assert.Len(t, e.MarginSystem().GetPendingReservations("bob"), 1)
+	var account margin.AccountSnapshot
+	require.Equal(t, http.StatusOK, doAs(t, "bob", http.MethodGet, ts.URL+"/account?user_id=alice", nil, &account))
+	assert.Equal(t, "bob", account.UserID)
+	assert.Equal(t, http.StatusNotFound, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID+"?user_id=bob", nil, &errResp))
+
+	// the bare key is accepted too
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/account", nil)
+	require.NoError(t, err)
+	req.Header.Set(AuthorizationHeader, "alice-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPlaceAndCancelOrders(t *testing.T) {
+	e, ts := newTestServer(t)
+
+	// resting bid reserves its initial margin
+	var placed PlaceOrderResponse
+	bid := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10}
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, &placed))
+	assert.Equal(t, 0.1, placed.Order.Size)
+	assert.Empty(t, placed.Trades)
+	assert.Len(t, e.MarginSystem().GetPendingReservations("alice"), 1)
+
+	// crossing ask fills against it, nothing rests
+	var filled PlaceOrderResponse
+	ask := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "sell", Price: 49000, Size: 0.04, Leverage: 10}
+	require.Equal(t, http.StatusCreated, doAs(t, "bob", http.MethodPost, ts.URL+"/orders", ask, &filled))
+	require.Len(t, filled.Trades, 1)
+	assert.Equal(t, 50000.0, filled.Trades[0].Price)
+	assert.InDelta(t, 0, filled.Order.Size, 1e-12)
+	assert.Empty(t, e.MarginSystem().GetPendingReservations("bob"))
+
+	var ticker Ticker
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/ticker/BTCUSDT", nil, &ticker))
+	assert.Equal(t, 50000.0, ticker.BestBid)
+	assert.Equal(t, 0.0, ticker.BestAsk)
+
+	// only the owner cancels
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusNotFound, doAs(t, "bob", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, &errResp))
+	var cancelled orderbook.Order
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, &cancelled))
+	assert.InDelta(t, 0.06, cancelled.Size, 1e-12)
+	assert.Empty(t, e.MarginSystem().GetPendingReservations("alice"))
+	assert.Equal(t, http.StatusNotFound, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, &errResp))
+
+	// typed errors
+	tooBig := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 10, Leverage: 10}
+	assert.Equal(t, http.StatusBadRequest, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", tooBig, &errResp))
+	assert.Contains(t, errResp.Error, "insufficient margin")
+	unknown := PlaceOrderRequest{Symbol: "DOGEUSDT", Side: "buy", Price: 1, Size: 1, Leverage: 10}
+	assert.Equal(t, http.StatusNotFound, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", unknown, &errResp))
+	badSide := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "long", Price: 1, Size: 1, Leverage: 10}
+	assert.Equal(t, http.StatusBadRequest, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", badSide, &errResp))
+	assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, ts.URL+"/ticker/DOGEUSDT", nil, &errResp))
+
+	// closed engine refuses new orders
+	require.NoError(t, e.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, &errResp))
+}
+
+// TestTradesSettle a cross opens both counterparties' positions, the next one closes them
+func TestTradesSettle(t *testing.T) {
+	e, server, _ := newStreamTestServer(t, nil)
+	ctx := context.Background()
+	pm, ms := e.PositionManager(), e.MarginSystem()
+	account := func(userID string) margin.AccountSnapshot {
+		account, err := ms.GetAccount(userID)
+		require.NoError(t, err)
+		return account.Snapshot()
+	}
+	size := func(userID string, side position.PositionSide) float64 {
+		pos, err := pm.GetPosition(userID, "BTCUSDT", side)
+		if err != nil {
+			return 0
+		}
+		return pos.Snapshot().Size
+	}
+
+	// alice's bid reserves 0.1 * 50000 / 10 = 500, bob's ask fills 0.04 of it at 50000
+	bid, err := server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10})
+	require.NoError(t, err)
+	_, err = server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "bob", Symbol: "BTCUSDT", Side: "sell", Price: 49000, Size: 0.04, Leverage: 10})
+	require.NoError(t, err)
+
+	assert.InDelta(t, 0.04, size("alice", position.LONG), 1e-12)
+	assert.InDelta(t, 0.04, size("bob", position.SHORT), 1e-12)
+	alice, bob := account("alice"), account("bob")
+	assert.Equal(t, 10_000.0, alice.Balance)
+	assert.InDelta(t, 200, alice.PositionMargin, 1e-9)
+	assert.InDelta(t, 300, alice.OrderMargin, 1e-9, "the unfilled 0.06 stays reserved")
+	assert.InDelta(t, 300, alice.FrozenBalance, 1e-9)
+	assert.InDelta(t, 9500, alice.AvailableBalance, 1e-9)
+	assert.Equal(t, 10_000.0, bob.Balance)
+	assert.InDelta(t, 200, bob.PositionMargin, 1e-9)
+	assert.Zero(t, bob.OrderMargin)
+	assert.Zero(t, bob.FrozenBalance)
+	assert.InDelta(t, 9800, bob.AvailableBalance, 1e-9)
+
+	// one-way: bob's bid closes his short against alice's reduce-only ask at 51000
+	ask, err := server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "sell", Price: 51000, Size: 0.04, ReduceOnly: true})
+	require.NoError(t, err)
+	closing, err := server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "bob", Symbol: "BTCUSDT", Side: "buy", Price: 51000, Size: 0.04, Leverage: 10})
+	require.NoError(t, err)
+	for userID, orderID := range map[string]string{"alice": ask.Order.ID, "bob": closing.Order.ID} {
+		reduced, err := ms.GetTradeHistory(userID, 0)
+		require.NoError(t, err)
+		require.Len(t, reduced, 1, "the reducing fill of %s", userID)
+		assert.Equal(t, orderID, reduced[0].OrderID)
+		assert.InDelta(t, 0.04, reduced[0].Size, 1e-12)
+	}
+
+	assert.Zero(t, size("alice", position.LONG))
+	assert.Zero(t, size("bob", position.SHORT))
+	assert.Zero(t, size("bob", position.LONG))
+	alice, bob = account("alice"), account("bob")
+	assert.InDelta(t, 10_040, alice.Balance, 1e-9)
+	assert.InDelta(t, 40, alice.RealizedPnL, 1e-9)
+	assert.InDelta(t, 0, alice.PositionMargin, 1e-9)
+	assert.InDelta(t, 300, alice.OrderMargin, 1e-9)
+	assert.InDelta(t, 9_960, bob.Balance, 1e-9)
+	assert.InDelta(t, 0, bob.PositionMargin, 1e-9)
+	assert.Zero(t, bob.OrderMargin)
+	assert.InDelta(t, 9_960, bob.AvailableBalance, 1e-9)
+
+	_, err = server.CancelOrder(ctx, "alice", ask.Order.ID)
+	assert.Error(t, err, "filled")
+	_, err = server.CancelOrder(ctx, "alice", bid.Order.ID)
+	require.NoError(t, err)
+	assert.Zero(t, account("alice").FrozenBalance)
+}
+
+func TestInsuranceFundHistory(t *testing.T) {
+	e, server, ts := newStreamTestServer(t, nil)
+	ctx := context.Background()
+	pm, liquidator := e.PositionManager(), e.LiquidationEngine()
+	var events []margin.InsuranceFundEvent
+	historyURL := ts.URL + "/insurance-fund/history"
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, historyURL, nil, &events))
+	assert.Empty(t, events)
+
+	// alice long and bob short 0.1 at 50000, 500 isolated margin each
+	_, err := server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10})
+	require.NoError(t, err)
+	_, err = server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "bob", Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.1, Leverage: 10})
+	require.NoError(t, err)
+	long, err := pm.GetPosition("alice", "BTCUSDT", position.LONG)
+	require.NoError(t, err)
+	short, err := pm.GetPosition("bob", "BTCUSDT", position.SHORT)
+	require.NoError(t, err)
+
+	// 45100: the long keeps 10 of its margin, the fund receives it
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+	results := liquidator.RunOnce()
+	require.Len(t, results, 1)
+	deposit := results[0].Settlement.FundDeposit
+	require.Positive(t, deposit)
+	between := time.Now()
+
+	// 56000: the short loses 600 on 500 margin, the fund pays what it holds of the shortfall
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 56000)
+	require.NoError(t, err)
+	results = liquidator.RunOnce()
+	require.Len(t, results, 1)
+	assert.Equal(t, deposit, results[0].Settlement.FundCovered)
+
+	events = nil
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, historyURL, nil, &events))
+	require.Len(t, events, 2)
+	assert.Equal(t, margin.InsuranceFundDeposit, events[0].Type)
+	assert.Equal(t, long.ID, events[0].PositionID)
+	assert.Equal(t, "BTCUSDT", events[0].Symbol)
+	assert.InDelta(t, deposit, events[0].Amount, 1e-9)
+	assert.Equal(t, margin.InsuranceFundWithdraw, events[1].Type)
+	assert.Equal(t, short.ID, events[1].PositionID)
+	assert.InDelta(t, -deposit, events[1].Amount, 1e-9)
+	assert.Zero(t, events[1].BalanceAfter)
+
+	events = nil
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, historyURL+"?from="+between.UTC().Format(time.RFC3339Nano), nil, &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, short.ID, events[0].PositionID)
+	events = nil
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, historyURL+"?to="+between.UTC().Format(time.RFC3339Nano), nil, &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, long.ID, events[0].PositionID)
+
+	var errResp ErrorResponse
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodGet, historyURL+"?from=yesterday", nil, &errResp))
+	assert.Equal(t, "from must be an RFC 3339 time", errResp.Error)
+	assert.Equal(t, http.StatusBadRequest, do(t, http.MethodGet, historyURL+"?from="+between.UTC().Format(time.RFC3339Nano)+
+		"&to="+between.Add(-time.Hour).UTC().Format(time.RFC3339Nano), nil, &errResp))
+}
+
+// TestRiskChecks the pipeline rejects before any margin is reserved, resting orders count as exposure
+func TestRiskChecks(t *testing.T) {
+	e, server, ts := newStreamTestServer(t, nil)
+	clock := common.NewFakeClock(time.Now())
+	limiter := risk.NewRateLimiter(clock, risk.TierLimits{
+		risk.ActionPlace:  {Rate: 1, Burst: 4},
+		risk.ActionCancel: {Rate: 1, Burst: 1},
+	})
+	breaker := risk.NewCircuitBreaker(clock, nil)
+	server.SetRisk(risk.NewRiskPipeline(limiter, breaker, &risk.ExposureChecker{
+		PositionMgr: e.PositionManager(),
+		Orders:      server,
+		Limits:      risk.NewExposureLimitStore(risk.ExposureLimit{MaxGrossNotional: 8000}),
+	}), limiter)
+	orderMargin := func() float64 {
+		account, err := e.MarginSystem().GetAccount("alice")
+		require.NoError(t, err)
+		return account.Snapshot().OrderMargin
+	}
+
+	var placed PlaceOrderResponse
+	var errResp ErrorResponse
+	bid := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10}
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, &placed))
+	assert.Equal(t, map[string]float64{"BTCUSDT": 5000}, server.OpenOrderNotional("alice"))
+
+	// 5000 resting + 5000 > 8000
+	assert.Equal(t, http.StatusBadRequest, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, &errResp))
+	assert.Contains(t, errResp.Error, "exposure check")
+	assert.InDelta(t, 500, orderMargin(), 1e-9, "nothing reserved")
+
+	small := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.01, Leverage: 10}
+	breaker.ForceHalt("BTCUSDT", "test")
+	assert.Equal(t, http.StatusBadRequest, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", small, &errResp))
+	assert.Contains(t, errResp.Error, "trading halted")
+	breaker.ForceResume("BTCUSDT", "test")
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", small, &PlaceOrderResponse{}))
+	assert.InDelta(t, 550, orderMargin(), 1e-9)
+
+	// every check took a placement token, the cancels have their own budget
+	assert.Equal(t, http.StatusTooManyRequests, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", small, &errResp))
+	assert.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, &orderbook.Order{}))
+	assert.Equal(t, http.StatusTooManyRequests, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, &errResp))
+	clock.Advance(time.Second)
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", small, &PlaceOrderResponse{}))
+}
+
+// TestRequestID one request's id in its response header, its log lines, its order events and trades
+func TestRequestID(t *testing.T) {
+	_, server, _ := newStreamTestServer(t, nil)
+	var logs bytes.Buffer
+	previous := logger.Default()
+	logger.SetDefault(&logger.Logger{Logger: slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))})
+	t.Cleanup(func() { logger.SetDefault(previous) })
+	var events []orderbook.OrderEvent
+	server.OnOrderEvent(func(event orderbook.OrderEvent) { events = append(events, event) })
+	var trades []orderbook.Trade
+	server.OnTrade(func(trade orderbook.Trade) { trades = append(trades, trade) })
+
+	// place the order through the handler in this goroutine, return its request id
+	place := func(req PlaceOrderRequest) string {
+		var body bytes.Buffer
+		require.NoError(t, json.NewEncoder(&body).Encode(req))
+		rec := httptest.NewRecorder()
+		httpReq := httptest.NewRequest(http.MethodPost, "/orders", &body)
+		httpReq.Header.Set(AuthorizationHeader, "Bearer "+req.UserID+"-key")
+		server.Handler().ServeHTTP(rec, httpReq)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		requestID := rec.Header().Get(RequestIDHeader)
+		require.True(t, strings.HasPrefix(requestID, "req_"), requestID)
+		return requestID
+	}
+	bidRequest := place(PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10})
+	events, trades = nil, nil
+	logs.Reset()
+	askRequest := place(PlaceOrderRequest{UserID: "bob", Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.04, Leverage: 10})
+	assert.NotEqual(t, bidRequest, askRequest)
+
+	// the ask, its fill and the fill of alice's resting bid
+	require.Len(t, events, 3)
+	for _, event := range events {
+		assert.Equal(t, askRequest, event.RequestID, event.Type)
+	}
+	assert.Equal(t, "alice", events[2].UserID)
+	require.Len(t, trades, 1)
+	assert.Equal(t, askRequest, trades[0].RequestID)
+	assert.Contains(t, logs.String(), `msg="Order placed" request_id=`+askRequest+" user_id=bob")
+	assert.NotContains(t, logs.String(), bidRequest)
+}
+
+func TestPremiumIndex(t *testing.T) {
+	e, server, _ := newStreamTestServer(t, nil)
+	ctx := context.Background()
+
+	premium, err := server.PremiumIndex("BTCUSDT")
+	require.NoError(t, err)
+	assert.Zero(t, premium, "no mark price, empty book")
+
+	_, err = e.UpdateMarkPrice(ctx, "BTCUSDT", 50000)
+	require.NoError(t, err)
+	_, err = server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50100, Size: 0.01, Leverage: 10})
+	require.NoError(t, err)
+	premium, err = server.PremiumIndex("BTCUSDT")
+	require.NoError(t, err)
+	assert.Zero(t, premium, "one sided book")
+
+	_, err = server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "bob", Symbol: "BTCUSDT", Side: "sell", Price: 50300, Size: 0.01, Leverage: 10})
+	require.NoError(t, err)
+	premium, err = server.PremiumIndex("BTCUSDT")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.004, premium, 1e-12, "mid 50200 over mark 50000")
+
+	_, err = server.PremiumIndex("DOGEUSDT")
+	assert.ErrorIs(t, err, position.ErrSymbolNotFound)
+}
+
+func TestQuiesceAndRestoreState(t *testing.T) {
+	ctx := context.Background()
+	e, server, _ := newStreamTestServer(t, nil)
+	_, err := server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 49000, Size: 0.1, Leverage: 10})
+	require.NoError(t, err)
+	ask, err := server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "bob", Symbol: "ETHUSDT", Side: "sell", Price: 3100, Size: 1, Leverage: 10})
+	require.NoError(t, err)
+
+	// orders wait for the quiesced section
+	placed := make(chan struct{})
+	var state ServerState
+	server.Quiesce(func() {
+		go func() {
+			_, _ = server.PlaceOrder(ctx, PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 48000, Size: 0.1, Leverage: 10})
+			close(placed)
+		}()
+		select {
+		case <-placed:
+			t.Error("order placed while quiesced")
+		case <-time.After(20 * time.Millisecond):
+		}
+		state = server.ExportState()
+	})
+	<-placed
+
+	require.Len(t, state.Books, 2)
+	assert.Equal(t, "BTCUSDT", state.Books[0].Symbol)
+	require.Len(t, state.Books[0].Bids, 1)
+	require.Len(t, state.Books[1].Asks, 1)
+	assert.Equal(t, map[string]uint64{"alice": 1, "bob": 1}, state.OrderSequences)
+
+	restoredEngine, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT", "ETHUSDT"}})
+	require.NoError(t, err)
+	require.NoError(t, restoredEngine.MarginSystem().RestoreState(e.MarginSystem().ExportState()))
+	require.NoError(t, restoredEngine.Start(ctx))
+	restored := NewServer(restoredEngine, "", nil, nil)
+	t.Cleanup(func() {
+		_ = restored.Shutdown(ctx)
+		_ = restoredEngine.Close()
+	})
+	require.NoError(t, restored.RestoreState(state))
+	assert.Equal(t, state.Books, restored.ExportState().Books)
+	assert.GreaterOrEqual(t, restored.stream.lastSequence(), state.StreamSequence)
+	assert.Error(t, restored.RestoreState(state), "books not empty")
+
+	canceled, err := restored.CancelOrder(ctx, "bob", ask.Order.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, canceled.Size)
+	event := restored.orderEvents.Publish(orderbook.OrderEvent{Type: orderbook.OrderAccepted, UserID: "alice"})
+	assert.Equal(t, uint64(2), event.Sequence, "order sequence continues")
+}
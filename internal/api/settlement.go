@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+)
+
+// settleTrades (成交結算) move every trade of the taker order into the positions of both counterparties
+// and release the filled share of both orders' margin reservations. fully filled makers are forgotten.
+// a fill that fails to settle is logged, the trade stands
+func (s *Server) settleTrades(ctx context.Context, takerID string, taker orderRef, trades []orderbook.Trade) {
+	for _, trade := range trades {
+		makerID, takerRemaining, makerRemaining := trade.SellOrderID, trade.BuyRemaining, trade.SellRemaining
+		if taker.side == orderbook.SELL {
+			makerID, takerRemaining, makerRemaining = trade.BuyOrderID, trade.SellRemaining, trade.BuyRemaining
+		}
+
+		s.mu.Lock()
+		maker, exists := s.orders[makerID]
+		if exists && makerRemaining <= 0 {
+			delete(s.orders, makerID)
+		} else if exists {
+			maker.remaining = makerRemaining
+			s.orders[makerID] = maker
+		}
+		s.mu.Unlock()
+
+		s.settleFill(ctx, takerID, taker, trade, takerRemaining)
+		if !exists {
+			logger.FromContext(ctx).Error("Fill not settled", "order_id", makerID, "symbol", trade.Symbol,
+				"error", "maker order unknown")
+			continue
+		}
+		s.settleFill(ctx, makerID, maker, trade, makerRemaining)
+		s.publishAccount(maker.userID)
+	}
+}
+
+// settleFill settle one side of a trade: the fill reduces the user's opposite position first (one-way mode,
+// or a reduce-only order), the rest opens or adds to an isolated position on the order's side
+func (s *Server) settleFill(ctx context.Context, orderID string, ref orderRef, trade orderbook.Trade, remaining float64) {
+	ms, pm := s.engine.MarginSystem(), s.engine.PositionManager()
+	side := position.LONG
+	if ref.side == orderbook.SELL {
+		side = position.SHORT
+	}
+	opposite := -side
+
+	reduceSize := 0.0
+	if pos, err := pm.GetPosition(ref.userID, ref.symbol, opposite); err == nil {
+		snapshot := pos.Snapshot()
+		if snapshot.Side == opposite && snapshot.Status != position.PositionClosed &&
+			(ref.reduceOnly || pm.GetPositionMode(ref.userID) == position.OneWayMode) {
+			reduceSize = min(trade.Size, snapshot.Size)
+		}
+	}
+
+	err := s.settleFillSize(orderID, ref, side, trade, reduceSize)
+	if !ref.reduceOnly {
+		if releaseErr := ms.ReleaseFilledReservation(ref.userID, orderID, trade.Size, remaining); err == nil {
+			err = releaseErr
+		}
+	}
+	if err != nil {
+		logger.FromContext(common.WithUserID(ctx, ref.userID)).Error("Fill not settled", "order_id", orderID,
+			"symbol", ref.symbol, "price", trade.Price, "size", trade.Size, "error", err)
+	}
+}
+
+// settleFillSize reduce the opposite position by reduceSize, open the rest of the fill on side
+func (s *Server) settleFillSize(orderID string, ref orderRef, side position.PositionSide, trade orderbook.Trade, reduceSize float64) error {
+	ms, pm := s.engine.MarginSystem(), s.engine.PositionManager()
+	if reduceSize > 0 {
+		if _, _, err := ms.SettleReduceOrderFill(ref.userID, orderID, ref.symbol, -side, trade.Price, reduceSize, 0); err != nil {
+			return err
+		}
+	}
+
+	openSize := trade.Size - reduceSize
+	if openSize <= 0 {
+		return nil
+	}
+	if ref.reduceOnly {
+		return fmt.Errorf("reduce-only fill exceeds the position by %g", openSize)
+	}
+	if _, err := pm.OpenPosition(common.ISOLATED, ref.userID, ref.symbol, side, trade.Price, openSize, uint(ref.leverage)); err != nil {
+		return err
+	}
+	return ms.UpdatePositionMargin(ref.userID)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/orderbook"
+	"sort"
+)
+
+// ServerState (交易層狀態) what a restart needs to rebuild the server: the resting orders of every book
+// and the stream sequences, so clients never see a sequence move back
+type ServerState struct {
+	Books          []orderbook.BookSnapshot `json:"books"` // sorted by symbol
+	StreamSequence uint64                   `json:"stream_sequence"`
+	OrderSequences map[string]uint64        `json:"order_sequences"` // userID -> last order event sequence
+}
+
+// Quiesce run fn once no order is being placed or cancelled and the engine is quiesced, see
+// engine.FuturesEngine.Quiesce. fn must not place or cancel orders
+func (s *Server) Quiesce(fn func()) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.engine.Quiesce(fn)
+}
+
+// ExportState books and sequences, call inside Quiesce for a state consistent with the margin reservations
+func (s *Server) ExportState() ServerState {
+	state := ServerState{
+		Books:          make([]orderbook.BookSnapshot, 0, len(s.books)),
+		StreamSequence: s.stream.lastSequence(),
+		OrderSequences: s.orderEvents.Sequences(),
+	}
+	for _, book := range s.books {
+		state.Books = append(state.Books, book.Snapshot())
+	}
+	sort.Slice(state.Books, func(i, j int) bool { return state.Books[i].Symbol < state.Books[j].Symbol })
+	return state
+}
+
+// RestoreState rest the orders of the state into the empty books and continue the sequences after it,
+// before the server takes traffic
+func (s *Server) RestoreState(state ServerState) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	orders := make(map[string]orderRef)
+	for _, snapshot := range state.Books {
+		book, exists := s.books[snapshot.Symbol]
+		if !exists {
+			return fmt.Errorf("order book %s not served by this engine", snapshot.Symbol)
+		}
+		if err := book.Restore(snapshot); err != nil {
+			return err
+		}
+		for _, side := range [][]orderbook.Order{snapshot.Bids, snapshot.Asks} {
+			for _, order := range side {
+				orders[order.ID] = orderRef{userID: order.UserID, symbol: snapshot.Symbol, side: order.Side,
+					price: order.Price, remaining: order.Size, leverage: order.Leverage, reduceOnly: order.ReduceOnly}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	for orderID, ref := range orders {
+		s.orders[orderID] = ref
+	}
+	s.mu.Unlock()
+	s.stream.restoreSequence(state.StreamSequence)
+	s.orderEvents.RestoreSequences(state.OrderSequences)
+	return nil
+}
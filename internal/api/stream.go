@@ -0,0 +1,539 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/pkg/websocket"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stream channels, positions / orders / account are per user, ticker / depth per symbol
+const (
+	ChannelPositions = "positions"
+	ChannelOrders    = "orders"
+	ChannelAccount   = "account"
+	ChannelTicker    = "ticker"
+	ChannelDepth     = "depth"
+)
+
+// stream ops, requests subscribe / unsubscribe, the rest are sent by the server
+const (
+	OpSubscribe    = "subscribe"
+	OpUnsubscribe  = "unsubscribe"
+	OpSubscribed   = "subscribed"
+	OpUnsubscribed = "unsubscribed"
+	OpUpdate       = "update"
+	OpError        = "error"
+)
+
+const (
+	DefaultSendBuffer     = 256
+	DefaultPingInterval   = 30 * time.Second
+	DefaultTickerInterval = time.Second
+	DefaultDepthLevels    = 20
+
+	// SequenceHeader stream sequence at the time of a REST response, see StreamMessage.Sequence
+	SequenceHeader = "X-Stream-Sequence"
+
+	streamWriteWait = 10 * time.Second
+	streamReadLimit = 4096
+)
+
+// StreamConfig websocket stream settings, 0 means the default of each field
+type StreamConfig struct {
+	SendBuffer     int           `json:"send_buffer"`     // messages queued per connection before it is dropped as slow
+	PingInterval   time.Duration `json:"ping_interval"`   // a connection silent for 2 intervals is dropped
+	TickerInterval time.Duration `json:"ticker_interval"` // ticker push period, mark price moves have no event
+}
+
+func (c *StreamConfig) withDefaults() StreamConfig {
+	config := StreamConfig{}
+	if c != nil {
+		config = *c
+	}
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = DefaultSendBuffer
+	}
+	if config.PingInterval <= 0 {
+		config.PingInterval = DefaultPingInterval
+	}
+	if config.TickerInterval <= 0 {
+		config.TickerInterval = DefaultTickerInterval
+	}
+	return config
+}
+
+// StreamRequest client message: {"op":"subscribe","channel":"positions"} or
+// {"op":"subscribe","channel":"depth","symbol":"BTCUSDT"}. private channels are the ones of the user of the
+// connection's API key, a UserID sent by the client is ignored
+type StreamRequest struct {
+	Op      string `json:"op"`
+	Channel string `json:"channel"`
+	UserID  string `json:"user_id,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+}
+
+// StreamMessage server message. Sequence increases by one per update over every channel; a client resyncs
+// by subscribing, fetching the REST snapshot and dropping updates not above its SequenceHeader.
+// replies carry the sequence of the last update sent before them
+type StreamMessage struct {
+	Op       string `json:"op"`
+	Channel  string `json:"channel,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	Symbol   string `json:"symbol,omitempty"`
+	Sequence uint64 `json:"sequence"`
+	Data     any    `json:"data,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Depth payload of the depth channel, the top DefaultDepthLevels [price, size] levels of each side
+type Depth struct {
+	Symbol    string       `json:"symbol"`
+	Bids      [][2]float64 `json:"bids"`
+	Asks      [][2]float64 `json:"asks"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// topic channel and its user id or symbol
+type topic struct {
+	channel string
+	key     string
+}
+
+// streamClient one websocket connection, or a Subscription without one. send is never closed,
+// closed signals the writer to hang up
+type streamClient struct {
+	conn   *websocket.Conn
+	send   chan []byte
+	topics map[topic]struct{} // guarded by streamHub.mu
+	closed chan struct{}
+	once   sync.Once
+}
+
+// close never blocks, safe under the hub lock: a write in flight fails at once and the writer closes the
+// connection, which ends the read loop
+func (c *streamClient) close() {
+	c.once.Do(func() {
+		close(c.closed)
+		if c.conn != nil {
+			_ = c.conn.SetWriteDeadline(time.Now())
+		}
+	})
+}
+
+// streamHub (推送) fan-out of updates to the subscribed connections. delivery never waits:
+// a connection whose send buffer is full is disconnected
+type streamHub struct {
+	config   StreamConfig
+	sequence uint64
+	clients  map[*streamClient]struct{}
+	dropped  atomic.Int64 // slow connections disconnected
+	mu       sync.RWMutex
+}
+
+func newStreamHub(config StreamConfig) *streamHub {
+	return &streamHub{config: config, clients: make(map[*streamClient]struct{})}
+}
+
+func (h *streamHub) register(conn *websocket.Conn) *streamClient {
+	client := &streamClient{
+		conn:   conn,
+		send:   make(chan []byte, h.config.SendBuffer),
+		topics: make(map[topic]struct{}),
+		closed: make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	return client
+}
+
+func (h *streamHub) remove(client *streamClient) {
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+	client.close()
+}
+
+// closeAll disconnect every client, on shutdown
+func (h *streamHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		delete(h.clients, client)
+		client.close()
+	}
+}
+
+func (h *streamHub) lastSequence() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.sequence
+}
+
+// restoreSequence continue after sequence, never moves back
+func (h *streamHub) restoreSequence(sequence uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sequence = max(h.sequence, sequence)
+}
+
+func (h *streamHub) subscribed(t topic) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if _, ok := client.topics[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// publish send the data of build to every subscriber of t. build runs outside the hub lock and only when
+// someone is subscribed
+func (h *streamHub) publish(t topic, message StreamMessage, build func() any) {
+	if !h.subscribed(t) {
+		return
+	}
+	message.Op = OpUpdate
+	message.Channel = t.channel
+	message.Data = build()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sequence++
+	message.Sequence = h.sequence
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	for client := range h.clients {
+		if _, ok := client.topics[t]; ok {
+			h.deliver(client, payload)
+		}
+	}
+}
+
+// reply answer a request of client
+func (h *streamHub) reply(client *streamClient, message StreamMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	message.Sequence = h.sequence
+	if payload, err := json.Marshal(message); err == nil {
+		h.deliver(client, payload)
+	}
+}
+
+// deliver h.mu held
+func (h *streamHub) deliver(client *streamClient, payload []byte) {
+	select {
+	case client.send <- payload:
+	default:
+		// slow client: drop it rather than block every publisher
+		delete(h.clients, client)
+		client.close()
+		h.dropped.Add(1)
+	}
+}
+
+func (h *streamHub) setSubscribed(client *streamClient, t topic, subscribed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if subscribed {
+		client.topics[t] = struct{}{}
+	} else {
+		delete(client.topics, t)
+	}
+}
+
+func (h *streamHub) clientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// maxQueueDepth messages waiting in the fullest send buffer
+func (h *streamHub) maxQueueDepth() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	depth := 0
+	for client := range h.clients {
+		depth = max(depth, len(client.send))
+	}
+	return depth
+}
+
+// Subscription (推送訂閱) stream subscriber outside the websocket, e.g. the gRPC feed. C delivers the JSON
+// encoded StreamMessage updates; a subscriber SendBuffer messages behind is dropped like a slow websocket
+type Subscription struct {
+	hub    *streamHub
+	client *streamClient
+}
+
+// Subscribe the topics of requests (their Op is ignored), validated as websocket subscriptions
+func (s *Server) Subscribe(requests ...StreamRequest) (*Subscription, error) {
+	topics := make([]topic, 0, len(requests))
+	for _, req := range requests {
+		t, err := s.streamTopic(req)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, t)
+	}
+
+	client := s.stream.register(nil)
+	for _, t := range topics {
+		s.stream.setSubscribed(client, t, true)
+	}
+	return &Subscription{hub: s.stream, client: client}, nil
+}
+
+func (sub *Subscription) C() <-chan []byte {
+	return sub.client.send
+}
+
+// Done closed once the subscription is closed, dropped as slow or the server shut down
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.client.closed
+}
+
+func (sub *Subscription) Close() {
+	sub.hub.remove(sub.client)
+}
+
+// ========================================================
+
+// handleStream GET /ws websocket of subscribe / unsubscribe requests and pushed updates. the API key, in
+// the Authorization header or the api_key query parameter, is only needed for the private channels
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.apiKeyUser(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return // handshake error already answered
+	}
+	client := s.stream.register(conn)
+	defer s.stream.remove(client)
+	go s.writeStream(client)
+
+	pongWait := 2 * s.stream.config.PingInterval
+	conn.SetReadLimit(streamReadLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func([]byte) { _ = conn.SetReadDeadline(time.Now().Add(pongWait)) })
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return // closed, timed out or dropped as slow
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var req StreamRequest
+		if err = json.Unmarshal(data, &req); err != nil {
+			s.stream.reply(client, StreamMessage{Op: OpError, Error: fmt.Sprintf("invalid request: %v", err)})
+			continue
+		}
+		s.handleStreamRequest(client, userID, req)
+	}
+}
+
+// writeStream the only writer of client's data frames, pings every PingInterval
+func (s *Server) writeStream(client *streamClient) {
+	ticker := time.NewTicker(s.stream.config.PingInterval)
+	defer ticker.Stop()
+	defer client.conn.Close()
+
+	for {
+		select {
+		case <-client.closed:
+			return // before any queued payload
+		default:
+		}
+
+		var err error
+		select {
+		case payload := <-client.send:
+			_ = client.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			err = client.conn.WriteMessage(websocket.TextMessage, payload)
+		case <-ticker.C:
+			_ = client.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			err = client.conn.WriteMessage(websocket.PingMessage, nil)
+		case <-client.closed:
+			return
+		}
+		if err != nil {
+			client.close()
+			return
+		}
+	}
+}
+
+// handleStreamRequest (un)subscribe client, connected with the API key of userID ("" without one)
+func (s *Server) handleStreamRequest(client *streamClient, userID string, req StreamRequest) {
+	req.UserID = ""
+	if req.Channel == ChannelPositions || req.Channel == ChannelOrders || req.Channel == ChannelAccount {
+		req.UserID = userID
+	}
+	reply := StreamMessage{Channel: req.Channel, UserID: req.UserID, Symbol: req.Symbol}
+	t, err := s.streamTopic(req)
+	if err == nil && req.Op != OpSubscribe && req.Op != OpUnsubscribe {
+		err = fmt.Errorf("op must be %s or %s, got %q", OpSubscribe, OpUnsubscribe, req.Op)
+	}
+	if err != nil {
+		reply.Op, reply.Error = OpError, err.Error()
+		s.stream.reply(client, reply)
+		return
+	}
+
+	subscribe := req.Op == OpSubscribe
+	s.stream.setSubscribed(client, t, subscribe)
+	reply.Op = OpSubscribed
+	if !subscribe {
+		reply.Op = OpUnsubscribed
+	}
+	s.stream.reply(client, reply)
+}
+
+// streamTopic topic of the request, private channels need an account, public ones a symbol
+func (s *Server) streamTopic(req StreamRequest) (topic, error) {
+	switch req.Channel {
+	case ChannelPositions, ChannelOrders, ChannelAccount:
+		if req.UserID == "" {
+			return topic{}, errors.New("api key required")
+		}
+		if _, err := s.requireAccount(req.UserID); err != nil {
+			return topic{}, err
+		}
+		return topic{channel: req.Channel, key: req.UserID}, nil
+	case ChannelTicker, ChannelDepth:
+		if _, exists := s.books[req.Symbol]; !exists {
+			return topic{}, fmt.Errorf("%w: %q", position.ErrSymbolNotFound, req.Symbol)
+		}
+		return topic{channel: req.Channel, key: req.Symbol}, nil
+	default:
+		return topic{}, fmt.Errorf("unknown channel %q", req.Channel)
+	}
+}
+
+// withSequence set SequenceHeader before the handler reads any state
+func (s *Server) withSequence(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(SequenceHeader, strconv.FormatUint(s.stream.lastSequence(), 10))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ========================================================
+
+// forwardPositionEvents position events to the positions channel of the owner, with the account and
+// ticker they change. returns once the subscription is cancelled
+func (s *Server) forwardPositionEvents(events <-chan position.PositionEvent) {
+	for event := range events {
+		s.stream.publish(topic{ChannelPositions, event.UserID}, StreamMessage{UserID: event.UserID, Symbol: event.Symbol},
+			func() any { return event })
+		s.publishAccount(event.UserID)
+		s.publishTicker(event.Symbol)
+	}
+}
+
+// pushTickers ticker of every symbol each TickerInterval until stop is closed
+func (s *Server) pushTickers(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.stream.config.TickerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for symbol := range s.books {
+				s.publishTicker(symbol)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// OnOrderEvent call fn with every order event from now on, its user sequence assigned. fn runs on the
+// order path and must not block. call before the server takes traffic
+func (s *Server) OnOrderEvent(fn func(orderbook.OrderEvent)) {
+	s.onOrderEvent = append(s.onOrderEvent, fn)
+}
+
+// publishOrderEvent assign the user's order sequence and push the event to the orders channel
+func (s *Server) publishOrderEvent(event orderbook.OrderEvent) {
+	event = s.orderEvents.Publish(event)
+	for _, fn := range s.onOrderEvent {
+		fn(event)
+	}
+	s.stream.publish(topic{ChannelOrders, event.UserID}, StreamMessage{UserID: event.UserID, Symbol: event.Symbol},
+		func() any { return event })
+}
+
+// publishOrderUpdates order events of a placed order and its trades. every event carries the request id
+// of the placement
+func (s *Server) publishOrderUpdates(order orderbook.Order, symbol string, trades []orderbook.Trade, requestID string) {
+	s.publishOrderEvent(orderbook.OrderEvent{
+		Type:          orderbook.OrderAccepted,
+		UserID:        order.UserID,
+		OrderID:       order.ID,
+		Symbol:        symbol,
+		Side:          order.Side,
+		Price:         order.Price,
+		RemainingSize: order.Size,
+		RequestID:     requestID,
+	})
+	for _, trade := range trades {
+		buy := orderbook.OrderEvent{UserID: trade.BuyUserID, OrderID: trade.BuyOrderID, Side: orderbook.BUY, RemainingSize: trade.BuyRemaining}
+		sell := orderbook.OrderEvent{UserID: trade.SellUserID, OrderID: trade.SellOrderID, Side: orderbook.SELL, RemainingSize: trade.SellRemaining}
+		taker, maker := buy, sell
+		if order.Side == orderbook.SELL {
+			taker, maker = sell, buy
+		}
+		for _, event := range []orderbook.OrderEvent{taker, maker} {
+			event.Symbol, event.FillPrice, event.FillSize, event.RequestID = symbol, trade.Price, trade.Size, requestID
+			event.Type = orderbook.OrderPartiallyFilled
+			if event.RemainingSize <= 0 {
+				event.Type = orderbook.OrderFilled
+			}
+			s.publishOrderEvent(event)
+		}
+	}
+}
+
+func (s *Server) publishAccount(userID string) {
+	account, err := s.engine.MarginSystem().GetAccount(userID)
+	if err != nil {
+		return
+	}
+	s.stream.publish(topic{ChannelAccount, userID}, StreamMessage{UserID: userID},
+		func() any { return account.Snapshot() })
+}
+
+func (s *Server) publishTicker(symbol string) {
+	s.stream.publish(topic{ChannelTicker, symbol}, StreamMessage{Symbol: symbol}, func() any {
+		ticker, _ := s.ticker(symbol)
+		return ticker
+	})
+}
+
+// publishBook depth and ticker of symbol after its book changed
+func (s *Server) publishBook(symbol string) {
+	s.stream.publish(topic{ChannelDepth, symbol}, StreamMessage{Symbol: symbol}, func() any {
+		book := s.books[symbol]
+		return Depth{
+			Symbol:    symbol,
+			Bids:      book.Depth(orderbook.BUY, DefaultDepthLevels),
+			Asks:      book.Depth(orderbook.SELL, DefaultDepthLevels),
+			Timestamp: time.Now(),
+		}
+	})
+	s.publishTicker(symbol)
+}
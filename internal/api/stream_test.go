@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/pkg/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received StreamMessage with the payload left raw
+type received struct {
+	Op       string          `json:"op"`
+	Channel  string          `json:"channel"`
+	UserID   string          `json:"user_id"`
+	Symbol   string          `json:"symbol"`
+	Sequence uint64          `json:"sequence"`
+	Data     json.RawMessage `json:"data"`
+	Error    string          `json:"error"`
+}
+
+// dialStream connect with the API key of userID, "" connects without one
+func dialStream(t *testing.T, ts *httptest.Server, userID string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	if userID != "" {
+		url += "?" + APIKeyQuery + "=" + userID + "-key"
+	}
+	conn, err := websocket.Dial(ctx, url)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, req StreamRequest) {
+	t.Helper()
+	payload, err := json.Marshal(req)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, payload))
+}
+
+func next(t *testing.T, conn *websocket.Conn) received {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var message received
+	require.NoError(t, json.Unmarshal(data, &message))
+	return message
+}
+
+func subscribe(t *testing.T, conn *websocket.Conn, req StreamRequest) received {
+	t.Helper()
+	req.Op = OpSubscribe
+	send(t, conn, req)
+	reply := next(t, conn)
+	require.Equal(t, OpSubscribed, reply.Op, reply.Error)
+	return reply
+}
+
+func TestStreamSubscriptionFiltering(t *testing.T) {
+	e, _, ts := newStreamTestServer(t, &StreamConfig{TickerInterval: time.Hour})
+
+	alice := dialStream(t, ts, "alice")
+	subscribe(t, alice, StreamRequest{Channel: ChannelPositions})
+	subscribe(t, alice, StreamRequest{Channel: ChannelOrders})
+	subscribe(t, alice, StreamRequest{Channel: ChannelDepth, Symbol: "BTCUSDT"})
+	bob := dialStream(t, ts, "bob")
+	subscribe(t, bob, StreamRequest{Channel: ChannelPositions})
+
+	// bob's position reaches bob only
+	_, err := e.OpenPosition(context.Background(), common.ISOLATED, "bob", "ETHUSDT", position.SHORT, 3000, 1, 10)
+	require.NoError(t, err)
+	message := next(t, bob)
+	assert.Equal(t, OpUpdate, message.Op)
+	assert.Equal(t, ChannelPositions, message.Channel)
+	var event position.PositionEvent
+	require.NoError(t, json.Unmarshal(message.Data, &event))
+	assert.Equal(t, position.EventPositionOpened, event.Type)
+	assert.Equal(t, "bob", event.UserID)
+	bobSequence := message.Sequence
+
+	// alice's order: her order and depth updates, nothing of bob's before them
+	bid := PlaceOrderRequest{Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.1, Leverage: 10}
+	var placed PlaceOrderResponse
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, &placed))
+
+	message = next(t, alice)
+	assert.Equal(t, ChannelOrders, message.Channel)
+	assert.Greater(t, message.Sequence, bobSequence)
+	var orderEvent orderbook.OrderEvent
+	require.NoError(t, json.Unmarshal(message.Data, &orderEvent))
+	assert.Equal(t, orderbook.OrderAccepted, orderEvent.Type)
+	assert.Equal(t, placed.Order.ID, orderEvent.OrderID)
+	assert.Equal(t, uint64(1), orderEvent.Sequence) // alice's first order event
+
+	depthMessage := next(t, alice)
+	assert.Equal(t, ChannelDepth, depthMessage.Channel)
+	assert.Equal(t, message.Sequence+1, depthMessage.Sequence)
+	var depth Depth
+	require.NoError(t, json.Unmarshal(depthMessage.Data, &depth))
+	assert.Equal(t, [][2]float64{{50000, 0.1}}, depth.Bids)
+
+	// REST responses carry the sequence to resync from
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/positions", nil)
+	require.NoError(t, err)
+	req.Header.Set(AuthorizationHeader, "Bearer alice-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	sequence, err := strconv.ParseUint(resp.Header.Get(SequenceHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, depthMessage.Sequence, sequence)
+
+	// unsubscribed from depth: the cancel reaches the orders channel only
+	send(t, alice, StreamRequest{Op: OpUnsubscribe, Channel: ChannelDepth, Symbol: "BTCUSDT"})
+	assert.Equal(t, OpUnsubscribed, next(t, alice).Op)
+	require.Equal(t, http.StatusOK, doAs(t, "alice", http.MethodDelete, ts.URL+"/orders/"+placed.Order.ID, nil, nil))
+	require.Equal(t, http.StatusCreated, doAs(t, "alice", http.MethodPost, ts.URL+"/orders", bid, nil))
+
+	for _, want := range []orderbook.OrderEventType{orderbook.OrderCanceled, orderbook.OrderAccepted} {
+		message = next(t, alice)
+		assert.Equal(t, ChannelOrders, message.Channel)
+		require.NoError(t, json.Unmarshal(message.Data, &orderEvent))
+		assert.Equal(t, want, orderEvent.Type)
+	}
+
+	// bad requests are answered, the connection stays. private channels need the key of the connection
+	anonymous := dialStream(t, ts, "")
+	for _, req := range []StreamRequest{
+		{Op: OpSubscribe, Channel: "trades", Symbol: "BTCUSDT"},
+		{Op: OpSubscribe, Channel: ChannelAccount, UserID: "bob"},
+		{Op: OpSubscribe, Channel: ChannelTicker, Symbol: "DOGEUSDT"},
+		{Op: "publish", Channel: ChannelTicker, Symbol: "BTCUSDT"},
+	} {
+		send(t, anonymous, req)
+		reply := next(t, anonymous)
+		assert.Equal(t, OpError, reply.Op)
+		assert.NotEmpty(t, reply.Error)
+	}
+	subscribe(t, anonymous, StreamRequest{Channel: ChannelTicker, Symbol: "BTCUSDT"})
+	assert.Equal(t, "bob", subscribe(t, bob, StreamRequest{Channel: ChannelAccount, UserID: "alice"}).UserID)
+
+	// an unknown key is refused before the upgrade
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?"+APIKeyQuery+"=mallory-key")
+	assert.Error(t, err)
+}
+
+func TestStreamDropsSlowClient(t *testing.T) {
+	_, server, ts := newStreamTestServer(t, &StreamConfig{SendBuffer: 4, TickerInterval: time.Hour})
+
+	slow := dialStream(t, ts, "")
+	subscribe(t, slow, StreamRequest{Channel: ChannelTicker, Symbol: "BTCUSDT"})
+	require.Equal(t, 1, server.stream.clientCount())
+
+	// never read: once the socket buffers are full the send buffer fills and the client is dropped
+	payload := strings.Repeat("x", 64<<10)
+	deadline := time.Now().Add(5 * time.Second)
+	for server.stream.clientCount() > 0 && time.Now().Before(deadline) {
+		server.stream.publish(topic{ChannelTicker, "BTCUSDT"}, StreamMessage{Symbol: "BTCUSDT"}, func() any { return payload })
+	}
+	assert.Equal(t, 0, server.stream.clientCount())
+	assert.Equal(t, int64(1), server.stream.dropped.Load())
+
+	// the client sees the connection end after whatever was already sent
+	require.NoError(t, slow.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		if _, _, err := slow.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestStreamKeepalive(t *testing.T) {
+	_, server, ts := newStreamTestServer(t, &StreamConfig{PingInterval: 20 * time.Millisecond, TickerInterval: time.Hour})
+
+	// a reading client answers the pings and stays
+	alive := dialStream(t, ts, "")
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	// a client that never reads never answers: dropped after two intervals
+	_ = dialStream(t, ts, "")
+
+	assert.Eventually(t, func() bool { return server.stream.clientCount() == 1 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, server.stream.clientCount())
+}
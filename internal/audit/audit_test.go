@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/position"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAudited engine and API server of BTCUSDT with every hook wired to an auditor writing to sink
+func newAudited(t *testing.T, sink AuditSink) (*engine.FuturesEngine, *api.Server, *Auditor) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT"}})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(context.Background()))
+	t.Cleanup(func() { _ = e.Close() })
+	server := api.NewServer(e, "", nil, nil)
+
+	auditor := NewAuditor(sink)
+	e.MarginSystem().OnAuditEntry(auditor.MarginEntry)
+	server.OnOrderEvent(auditor.OrderEvent)
+	e.LiquidationEngine().OnResult(auditor.Liquidation)
+	e.PositionManager().SetJournal(auditor)
+	return e, server, auditor
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	recorder := NewRecorder()
+	e, server, _ := newAudited(t, recorder)
+	ms := e.MarginSystem()
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob"} {
+		_, err := ms.CreateAccount(user)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(user, 10_000))
+	}
+
+	bid, err := server.PlaceOrder(ctx, api.PlaceOrderRequest{UserID: "alice", Symbol: "BTCUSDT", Side: "buy", Price: 50000, Size: 0.2, Leverage: 10})
+	require.NoError(t, err)
+	_, err = server.PlaceOrder(ctx, api.PlaceOrderRequest{UserID: "bob", Symbol: "BTCUSDT", Side: "sell", Price: 50000, Size: 0.1, Leverage: 10})
+	require.NoError(t, err)
+	_, err = server.CancelOrder(common.WithRequestID(ctx, "req_cancel"), "alice", bid.Order.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		margin.AuditDeposit, margin.AuditDeposit,
+		ActionOrderPlaced,                         // alice's bid rests
+		ActionOrderPlaced, ActionFill, ActionFill, // bob's ask fills half of it
+		ActionPositionPrefix + position.AuditOpen, // settled: bob's short
+		ActionPositionPrefix + position.AuditOpen, // and alice's long
+		ActionOrderCanceled,
+	}, recorder.Actions())
+	records := recorder.Records()
+	bidFill, canceled := records[5], records[8]
+	assert.Equal(t, "bob", records[6].Actor)
+	assert.Equal(t, "alice", records[7].Actor)
+	assert.Equal(t, "alice", bidFill.Actor)
+	assert.Equal(t, bid.Order.ID, bidFill.Subject)
+	assert.Equal(t, map[string]any{"remaining_size": 0.2}, bidFill.Before)
+	assert.Equal(t, 0.1, bidFill.After["remaining_size"])
+	assert.Equal(t, bid.Order.ID, canceled.Subject)
+	assert.Equal(t, "alice", canceled.Actor)
+	assert.Equal(t, 0.1, canceled.Before["remaining_size"])
+	assert.Equal(t, "req_cancel", canceled.RequestID)
+	for _, record := range records {
+		assert.False(t, record.Time.IsZero())
+	}
+}
+
+func TestPositionLifecycle(t *testing.T) {
+	recorder := NewRecorder()
+	e, _, _ := newAudited(t, recorder)
+	ms := e.MarginSystem()
+	ctx := context.Background()
+	_, err := ms.CreateAccount("alice")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("alice", 10_000))
+	pos, err := e.OpenPosition(ctx, common.ISOLATED, "alice", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	_, err = e.LiquidationEngine().ForceLiquidate(pos.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		margin.AuditDeposit,
+		ActionPositionPrefix + position.AuditOpen,
+		ActionPositionPrefix + position.AuditLiquidate,
+		margin.AuditSettleLiquidation,
+		ActionLiquidation,
+	}, recorder.Actions())
+	records := recorder.Records()
+	assert.Equal(t, "alice", records[1].Actor)
+	assert.Equal(t, int16(10), records[1].After["leverage"])
+	for _, record := range records[2:] {
+		assert.Equal(t, ActorEngine, record.Actor)
+	}
+	assert.Equal(t, pos.ID, records[4].Subject)
+	assert.Equal(t, 0.1, records[4].Before["size"])
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for i := range 2 { // reopened: appended, not truncated
+		sink, err := OpenFileSink(path)
+		require.NoError(t, err)
+		auditor := NewAuditor(sink)
+		require.NoError(t, auditor.Record(Record{Actor: "alice", Action: margin.AuditDeposit, Subject: "alice",
+			After: map[string]any{"balance": float64(i)}}))
+		require.NoError(t, sink.Close())
+	}
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	info, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	for i, record := range records {
+		assert.Equal(t, float64(i), record.After["balance"])
+		assert.False(t, record.Time.IsZero())
+	}
+}
+
+// failingSink refuses every record
+type failingSink struct{}
+
+func (failingSink) Write(Record) error { return errors.New("disk full") }
+
+func TestSinkFailure(t *testing.T) {
+	e, _, auditor := newAudited(t, failingSink{})
+	registry := metrics.NewRecorder()
+	auditor.SetMetrics(registry)
+	require.NoError(t, auditor.CheckHealth(context.Background()))
+
+	// the change is committed whatever the sink answers
+	ms := e.MarginSystem()
+	_, err := ms.CreateAccount("alice")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("alice", 100))
+	account, err := ms.GetAccount("alice")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, account.Snapshot().Balance)
+
+	assert.Equal(t, uint64(1), auditor.Failures())
+	assert.Equal(t, 1.0, registry.Counter(MetricAuditFailures, metrics.Labels{"action": margin.AuditDeposit}))
+	assert.EqualError(t, auditor.CheckHealth(context.Background()), "last audit write failed: disk full")
+}
@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics of the auditor, labelled by action
+const (
+	MetricAuditRecords  = "audit_records_total"
+	MetricAuditFailures = "audit_failures_total" // records the sink failed to write
+)
+
+// Auditor (稽核) turns the committed changes of the engine into records written to an AuditSink. its
+// methods fit the hooks called on the path of each change once it is done: MarginSystem.OnAuditEntry,
+// api.Server.OnOrderEvent, LiquidationEngine.OnResult and PositionManager.SetJournal. a record the sink
+// fails to write is logged, counted in Failures and MetricAuditFailures, and fails CheckHealth
+type Auditor struct {
+	sink AuditSink
+
+	failures  atomic.Uint64
+	lastErr   error // of the last write, guarded by errMu
+	errMu     sync.Mutex
+	metrics   metrics.Registry
+	metricsMu sync.Mutex
+}
+
+func NewAuditor(sink AuditSink) *Auditor {
+	return &Auditor{sink: sink, metrics: metrics.Nop{}}
+}
+
+// SetMetrics registry of the auditor metrics, nil means metrics.Nop
+func (a *Auditor) SetMetrics(registry metrics.Registry) {
+	a.metricsMu.Lock()
+	defer a.metricsMu.Unlock()
+	a.metrics = metrics.OrNop(registry)
+}
+
+func (a *Auditor) metricsRegistry() metrics.Registry {
+	a.metricsMu.Lock()
+	defer a.metricsMu.Unlock()
+	return a.metrics
+}
+
+// Record write record, timed now when its Time is zero
+func (a *Auditor) Record(record Record) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	err := a.sink.Write(record)
+
+	a.errMu.Lock()
+	a.lastErr = err
+	a.errMu.Unlock()
+	labels := metrics.Labels{"action": record.Action}
+	if err != nil {
+		a.failures.Add(1)
+		a.metricsRegistry().AddCounter(MetricAuditFailures, 1, labels)
+		logger.Default().Error("audit record not written", "action", record.Action, "subject", record.Subject,
+			"actor", record.Actor, "error", err)
+		return err
+	}
+	a.metricsRegistry().AddCounter(MetricAuditRecords, 1, labels)
+	return nil
+}
+
+// Failures records the sink failed to write
+func (a *Auditor) Failures() uint64 {
+	return a.failures.Load()
+}
+
+// CheckHealth implements health.Contributor: the error of the last write, if it failed
+func (a *Auditor) CheckHealth(context.Context) error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	if a.lastErr != nil {
+		return fmt.Errorf("last audit write failed: %w", a.lastErr)
+	}
+	return nil
+}
+
+// ========================================================
+
+// MarginEntry record a balance operation of the margin system, fits MarginSystem.OnAuditEntry. failed
+// operations changed nothing and are not recorded
+func (a *Auditor) MarginEntry(entry margin.MarginAuditEntry) {
+	if entry.Error != "" {
+		return
+	}
+	actor := entry.UserID
+	if entry.Operation == margin.AuditSettleLiquidation || entry.Operation == margin.AuditMerge {
+		actor = ActorEngine
+	}
+	_ = a.Record(Record{
+		Actor:   actor,
+		Action:  entry.Operation,
+		Subject: entry.UserID,
+		Before:  map[string]any{"balance": entry.BalanceBefore},
+		After:   map[string]any{"balance": entry.BalanceAfter, "amount": entry.Amount},
+		Time:    entry.Timestamp,
+	})
+}
+
+// OrderEvent record an order placed, filled, canceled or expired, fits api.Server.OnOrderEvent. rejected
+// orders changed nothing and are not recorded. the record keeps the request id of the event
+func (a *Auditor) OrderEvent(event orderbook.OrderEvent) {
+	record := Record{Actor: event.UserID, Subject: event.OrderID, RequestID: event.RequestID, Time: event.Timestamp}
+	switch event.Type {
+	case orderbook.OrderAccepted:
+		record.Action = ActionOrderPlaced
+		record.After = map[string]any{"symbol": event.Symbol, "side": event.Side.String(), "price": event.Price,
+			"remaining_size": event.RemainingSize}
+	case orderbook.OrderPartiallyFilled, orderbook.OrderFilled:
+		record.Action = ActionFill
+		record.Before = map[string]any{"remaining_size": event.RemainingSize + event.FillSize}
+		record.After = map[string]any{"remaining_size": event.RemainingSize, "fill_price": event.FillPrice,
+			"fill_size": event.FillSize}
+	case orderbook.OrderCanceled:
+		record.Action = ActionOrderCanceled
+		if event.Reason != "user" {
+			record.Actor = ActorEngine
+		}
+		record.Before = map[string]any{"remaining_size": event.RemainingSize}
+		record.After = map[string]any{"remaining_size": 0.0, "reason": event.Reason}
+	case orderbook.OrderExpired:
+		record.Action, record.Actor = ActionOrderExpired, ActorEngine
+		record.Before = map[string]any{"remaining_size": event.RemainingSize}
+		record.After = map[string]any{"remaining_size": 0.0}
+	default:
+		return
+	}
+	_ = a.Record(record)
+}
+
+// Liquidation record a liquidation pass, fits LiquidationEngine.OnResult
+func (a *Auditor) Liquidation(result liquidation.LiquidationResult) {
+	after := map[string]any{"remaining_size": result.RemainingSize, "close_price": result.ClosePrice,
+		"pnl": result.PnL, "fund_size": result.FundSize}
+	if result.Error != "" {
+		after["error"] = result.Error
+	}
+	_ = a.Record(Record{
+		Actor:   ActorEngine,
+		Action:  ActionLiquidation,
+		Subject: result.PositionID,
+		Before:  map[string]any{"size": result.Size + result.RemainingSize, "mark_price": result.MarkPrice},
+		After:   after,
+		Time:    result.Timestamp,
+	})
+}
+
+// Append implements position.PositionJournal: every open, add, reduce, close and liquidation fill of a
+// position, with its leverage afterwards. recovered positions are restored state, not recorded
+func (a *Auditor) Append(entry position.PositionAuditEntry) error {
+	if entry.Operation == position.AuditRecover {
+		return nil
+	}
+	actor := entry.UserID
+	if entry.Operation == position.AuditLiquidate {
+		actor = ActorEngine
+	}
+	return a.Record(Record{
+		Actor:   actor,
+		Action:  ActionPositionPrefix + entry.Operation,
+		Subject: entry.PositionID,
+		After: map[string]any{"side": entry.Side.String(), "size": entry.Size, "price": entry.Price,
+			"leverage": entry.Leverage, "status": entry.Status},
+		Time: entry.Timestamp,
+	})
+}
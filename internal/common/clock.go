@@ -0,0 +1,50 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock time source, injectable for tests
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ============================================================
+
+// FakeClock manually advanced clock for tests
+type FakeClock struct {
+	now time.Time
+	mu  sync.RWMutex
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.now
+}
+
+// Advance move the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set move the clock to t
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
@@ -0,0 +1,30 @@
+package common
+
+import "context"
+
+type requestIDKey struct{}
+
+type userIDKey struct{}
+
+// WithRequestID ctx carrying the id of the request it serves, e.g. set per call by the REST and gRPC
+// layers. logger.FromContext, the order events and the audit records pick it up
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFrom request id carried by ctx, "" when none
+func RequestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// WithUserID ctx carrying the user the request acts for
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFrom user id carried by ctx, "" when none
+func UserIDFrom(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
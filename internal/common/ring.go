@@ -0,0 +1,44 @@
+package common
+
+// RingBuffer keeps the last capacity items, the oldest is overwritten when full.
+// not safe for concurrent use, the owner guards it
+type RingBuffer[T any] struct {
+	items    []T
+	head     int // oldest item once full
+	capacity int
+}
+
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	return &RingBuffer[T]{capacity: max(capacity, 1)}
+}
+
+func (r *RingBuffer[T]) Add(item T) {
+	if len(r.items) < r.capacity {
+		r.items = append(r.items, item)
+		return
+	}
+	r.items[r.head] = item
+	r.head = (r.head + 1) % r.capacity
+}
+
+func (r *RingBuffer[T]) Len() int { return len(r.items) }
+
+// Items copy of the kept items, oldest first
+func (r *RingBuffer[T]) Items() []T {
+	items := make([]T, 0, len(r.items))
+	items = append(items, r.items[r.head:]...)
+	return append(items, r.items[:r.head]...)
+}
+
+// Recent up to limit items, newest first. limit <= 0 returns every kept item
+func (r *RingBuffer[T]) Recent(limit int) []T {
+	n := len(r.items)
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+	recent := make([]T, 0, limit)
+	for i := 0; i < limit; i++ {
+		recent = append(recent, r.items[(r.head+n-1-i)%n])
+	}
+	return recent
+}
@@ -1,49 +1,428 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/funding"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/risk"
+	"maps"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Config holds the application configuration.
+// Config holds the application configuration. Default returns it with every setting at its default,
+// Load and LoadFromFile override them and Validate the result
 type Config struct {
-	// Server configuration
-	Host string
-	Port int
+	// API REST and gRPC servers
+	API APIConfig
+	// Margin margin system settings, the config of margin.NewMarginSystem
+	Margin margin.MarginConfig
+	// Risk pre-trade risk settings
+	Risk RiskConfig
+	// Funding funding settings of every symbol, the defaults of funding.NewConfigRegistry
+	Funding funding.FundingConfig
+
+	// MetricsEnabled serve Prometheus metrics on GET /metrics of the API server
+	MetricsEnabled bool
+	// ShutdownTimeout deadline of the whole shutdown before the process exits anyway, 0 means the shutdown default
+	ShutdownTimeout time.Duration
+	// HealthMaxPriceAge mark price age GET /healthz reports stale, 0 means the engine default
+	HealthMaxPriceAge time.Duration
+
+	// SnapshotDir directory of the engine state snapshots, "" disables them
+	SnapshotDir string
+	// SnapshotInterval time between two snapshots, 0 means the snapshot default
+	SnapshotInterval time.Duration
+	// SnapshotKeep snapshot files kept, 0 means the snapshot default
+	SnapshotKeep int
+	// WALDir directory of the write-ahead log replayed after the last snapshot on startup, "" disables it
+	WALDir string
+	// WALSyncInterval longest wait of a command for the fsync of its batch, 0 means the wal default
+	WALSyncInterval time.Duration
+	// AuditLogPath file every balance and position change is appended to as a JSON line, "" disables it
+	AuditLogPath string
+	// RedisAddr Redis (host:port) of the shared account store, "" keeps the accounts in memory
+	RedisAddr string
+	// RedisPassword password of RedisAddr
+	RedisPassword string
+	// RedisDB database of RedisAddr
+	RedisDB int
+	// HistoryDatabaseURL PostgreSQL URL of the closed position, trade and ledger history, "" disables it
+	HistoryDatabaseURL string
+	// KafkaBrokers Kafka brokers (host:port) the engine events are published to, empty disables it
+	KafkaBrokers []string
+	// KafkaTopics topic of each kind of event (trades, orders, liquidations, margin_calls, funding), the
+	// events default for the missing ones
+	KafkaTopics map[string]string
 
 	// Logging configuration
 	LogLevel string
 
 	// Application configuration
 	Environment string
+
+	// Engine configuration
+	Symbols []string
+	// PricePrecision / SizePrecision decimals of the prices and sizes of each symbol (SYMBOL:decimals),
+	// the position defaults for the others
+	PricePrecision map[string]int
+	SizePrecision  map[string]int
+}
+
+// APIConfig (API 設定) the REST API listens on Host:Port, port 0 picks a free one
+type APIConfig struct {
+	Host string
+	Port int
+	// GRPCPort port of the gRPC server, 0 disables it
+	GRPCPort int
+	// APIKeys API key -> user id of the REST and gRPC clients
+	APIKeys map[string]string
+	// AdminToken bearer token of the admin API under /admin/, "" disables it
+	AdminToken string
+	// Stream websocket stream settings, the config of api.NewServer
+	Stream api.StreamConfig
+}
+
+// Addr host:port of the REST API
+func (c APIConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// RiskConfig (風控設定) the configs of risk.NewCircuitBreaker, risk.NewRateLimiter and
+// risk.NewExposureLimitStore
+type RiskConfig struct {
+	CircuitBreaker risk.CircuitBreakerConfig
+	RateLimits     risk.TierLimits    // limits of the default tier
+	Exposure       risk.ExposureLimit // limit of the default tier, 0 means unlimited
+}
+
+// Default configuration with every setting at its default
+func Default() *Config {
+	return &Config{
+		API: APIConfig{
+			Host:     "localhost",
+			Port:     8080,
+			GRPCPort: 9090,
+			APIKeys:  make(map[string]string),
+			Stream: api.StreamConfig{
+				SendBuffer:     api.DefaultSendBuffer,
+				PingInterval:   api.DefaultPingInterval,
+				TickerInterval: api.DefaultTickerInterval,
+			},
+		},
+		Margin: margin.DefaultMarginConfig,
+		Risk: RiskConfig{
+			CircuitBreaker: *risk.DefaultCircuitBreakerConfig,
+			RateLimits:     maps.Clone(risk.DefaultTierLimits),
+			Exposure:       risk.ExposureLimit{SymbolLimits: make(map[string]float64)},
+		},
+		Funding:        funding.DefaultFundingConfig,
+		LogLevel:       "info",
+		Environment:    "development",
+		Symbols:        []string{"BTCUSDT", "ETHUSDT"},
+		PricePrecision: make(map[string]int),
+		SizePrecision:  make(map[string]int),
+		KafkaTopics:    make(map[string]string),
+	}
+}
+
+// Load loads the configuration from environment variables. the error lists every malformed value and
+// every failed Validate rule
+func Load() (*Config, error) {
+	return newLoader(nil).load()
+}
+
+// LoadFromFile loads the configuration from the environment and the .env or YAML (.yaml, .yml) file at
+// path, "" for none: an environment variable wins over the file, the file over the default. returns
+// a warning per file key no setting reads, and an error naming the key and line of every malformed value,
+// then every failed Validate rule
+func LoadFromFile(path string) (*Config, []string, error) {
+	var file *file
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	l := newLoader(file)
+	config, err := l.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, l.unknownKeys(), nil
 }
 
-// Load loads the configuration from environment variables.
-func Load() *Config {
-	config := &Config{
-		Host:        getEnv("HOST", "localhost"),
-		Port:        getEnvAsInt("PORT", 8080),
-		LogLevel:    getEnv("LOG_LEVEL", "info"),
-		Environment: getEnv("ENVIRONMENT", "development"),
+// load override the defaults by the settings found, then validate
+func (l *loader) load() (*Config, error) {
+	config := Default()
+
+	config.API.Host = l.getString("HOST", config.API.Host)
+	config.API.Port = l.getInt("PORT", config.API.Port)
+	config.API.GRPCPort = l.getInt("GRPC_PORT", config.API.GRPCPort)
+	config.API.APIKeys = l.getMap("API_KEYS")
+	config.API.AdminToken = l.getString("ADMIN_TOKEN", config.API.AdminToken)
+	config.API.Stream.SendBuffer = l.getInt("STREAM_SEND_BUFFER", config.API.Stream.SendBuffer)
+	config.API.Stream.PingInterval = l.getDuration("STREAM_PING_INTERVAL", config.API.Stream.PingInterval)
+	config.API.Stream.TickerInterval = l.getDuration("STREAM_TICKER_INTERVAL", config.API.Stream.TickerInterval)
+
+	config.Margin.DefaultInitialMarginRate = l.getFloat("INITIAL_MARGIN_RATE", config.Margin.DefaultInitialMarginRate)
+	config.Margin.DefaultMaintenanceMarginRate = l.getFloat("MAINTENANCE_MARGIN_RATE", config.Margin.DefaultMaintenanceMarginRate)
+	config.Margin.MaxNotional = l.getFloat("MAX_NOTIONAL", config.Margin.MaxNotional)
+	config.Margin.LiquidationFeeRate = l.getFloat("LIQUIDATION_FEE_RATE", config.Margin.LiquidationFeeRate)
+	config.Margin.ReservationTTL = l.getDuration("RESERVATION_TTL", config.Margin.ReservationTTL)
+
+	breaker := &config.Risk.CircuitBreaker
+	breaker.MaxMove = l.getFloat("CIRCUIT_BREAKER_MAX_MOVE", breaker.MaxMove)
+	breaker.Window = l.getDuration("CIRCUIT_BREAKER_WINDOW", breaker.Window)
+	breaker.CoolOff = l.getDuration("CIRCUIT_BREAKER_COOL_OFF", breaker.CoolOff)
+	for _, action := range rateLimitActions {
+		key := "RATE_LIMIT_" + strings.ToUpper(action.String())
+		limit := config.Risk.RateLimits[action]
+		limit.Rate = l.getFloat(key+"_RATE", limit.Rate)
+		limit.Burst = l.getFloat(key+"_BURST", limit.Burst)
+		config.Risk.RateLimits[action] = limit
 	}
+	config.Risk.Exposure.MaxGrossNotional = l.getFloat("EXPOSURE_MAX_GROSS_NOTIONAL", config.Risk.Exposure.MaxGrossNotional)
+	config.Risk.Exposure.SymbolLimits = l.getFloatMap("EXPOSURE_SYMBOL_LIMITS")
+
+	config.Funding.Interval = l.getDuration("FUNDING_INTERVAL", config.Funding.Interval)
+	config.Funding.RateCap = l.getFloat("FUNDING_RATE_CAP", config.Funding.RateCap)
+	config.Funding.RateFloor = l.getFloat("FUNDING_RATE_FLOOR", config.Funding.RateFloor)
+	config.Funding.InterestRate = l.getFloat("FUNDING_INTEREST_RATE", config.Funding.InterestRate)
+
+	config.LogLevel = l.getString("LOG_LEVEL", config.LogLevel)
+	config.Environment = l.getString("ENVIRONMENT", config.Environment)
+	config.Symbols = l.getSymbols("SYMBOLS", config.Symbols)
+	config.PricePrecision = l.getIntMap("PRICE_PRECISION")
+	config.SizePrecision = l.getIntMap("SIZE_PRECISION")
+
+	config.MetricsEnabled = l.getBool("METRICS_ENABLED", config.MetricsEnabled)
+	config.HealthMaxPriceAge = l.getDuration("HEALTH_MAX_PRICE_AGE", config.HealthMaxPriceAge)
+	config.ShutdownTimeout = l.getDuration("SHUTDOWN_TIMEOUT", config.ShutdownTimeout)
 
-	return config
+	config.SnapshotDir = l.getString("SNAPSHOT_DIR", config.SnapshotDir)
+	config.SnapshotInterval = l.getDuration("SNAPSHOT_INTERVAL", config.SnapshotInterval)
+	config.SnapshotKeep = l.getInt("SNAPSHOT_KEEP", config.SnapshotKeep)
+
+	config.WALDir = l.getString("WAL_DIR", config.WALDir)
+	config.WALSyncInterval = l.getDuration("WAL_SYNC_INTERVAL", config.WALSyncInterval)
+	config.AuditLogPath = l.getString("AUDIT_LOG_PATH", config.AuditLogPath)
+
+	config.RedisAddr = l.getString("REDIS_ADDR", config.RedisAddr)
+	config.RedisPassword = l.getString("REDIS_PASSWORD", config.RedisPassword)
+	config.RedisDB = l.getInt("REDIS_DB", config.RedisDB)
+
+	config.HistoryDatabaseURL = l.getString("HISTORY_DATABASE_URL", config.HistoryDatabaseURL)
+
+	config.KafkaBrokers = l.getList("KAFKA_BROKERS", config.KafkaBrokers)
+	config.KafkaTopics = l.getMap("KAFKA_TOPICS")
+
+	// a malformed value kept its default, the other settings are still validated
+	if err := errors.Join(append(l.errs, config.Validate())...); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
-// getEnv gets an environment variable with a default value.
-func getEnv(key, defaultVal string) string {
-	if value := os.Getenv(key); value != "" {
+// ========================================================
+
+// loader reads the settings by key: the environment first, then the file
+type loader struct {
+	file *file // nil: environment only
+	read map[string]bool
+	errs []error
+}
+
+func newLoader(file *file) *loader {
+	return &loader{file: file, read: make(map[string]bool)}
+}
+
+// lookup value of key and where it comes from, "" when unset
+func (l *loader) lookup(key string) (value, origin string) {
+	l.read[key] = true
+	if value = os.Getenv(key); value != "" {
+		return value, "environment"
+	}
+	if l.file != nil {
+		if entry, ok := l.file.values[key]; ok {
+			return entry.value, fmt.Sprintf("%s:%d", l.file.path, entry.line)
+		}
+	}
+	return "", ""
+}
+
+// invalid record a malformed value, the setting keeps its default
+func (l *loader) invalid(key, origin, value, want string) {
+	l.errs = append(l.errs, fmt.Errorf("%s (%s): invalid %s %q", key, origin, want, value))
+}
+
+// unknownKeys warning per file key no setting read, nested YAML keys count as read with their parent
+func (l *loader) unknownKeys() []string {
+	if l.file == nil {
+		return nil
+	}
+	var warnings []string
+	for _, key := range l.file.keys {
+		entry := l.file.values[key]
+		if l.read[key] || entry.parent != "" && l.read[entry.parent] || entry.mapping {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("unknown configuration key %s (%s:%d)", key, l.file.path, entry.line))
+	}
+	return warnings
+}
+
+// getString gets a setting with a default value.
+func (l *loader) getString(key, defaultVal string) string {
+	if value, _ := l.lookup(key); value != "" {
 		return value
 	}
 	return defaultVal
 }
 
-// getEnvAsInt gets an environment variable as integer with a default value.
-func getEnvAsInt(key string, defaultVal int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+// getInt gets a setting as integer with a default value.
+func (l *loader) getInt(key string, defaultVal int) int {
+	value, origin := l.lookup(key)
+	if value == "" {
+		return defaultVal
+	}
+	intVal, err := strconv.Atoi(value)
+	if err != nil {
+		l.invalid(key, origin, value, "integer")
+		return defaultVal
+	}
+	return intVal
+}
+
+// getFloat gets a setting as float with a default value.
+func (l *loader) getFloat(key string, defaultVal float64) float64 {
+	value, origin := l.lookup(key)
+	if value == "" {
+		return defaultVal
+	}
+	floatVal, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.invalid(key, origin, value, "number")
+		return defaultVal
+	}
+	return floatVal
+}
+
+// getBool gets a setting as bool with a default value.
+func (l *loader) getBool(key string, defaultVal bool) bool {
+	value, origin := l.lookup(key)
+	if value == "" {
+		return defaultVal
+	}
+	boolVal, err := strconv.ParseBool(value)
+	if err != nil {
+		l.invalid(key, origin, value, "boolean")
+		return defaultVal
+	}
+	return boolVal
+}
+
+// getDuration gets a setting as duration (e.g. "30s") with a default value.
+func (l *loader) getDuration(key string, defaultVal time.Duration) time.Duration {
+	value, origin := l.lookup(key)
+	if value == "" {
+		return defaultVal
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		l.invalid(key, origin, value, "duration")
+		return defaultVal
+	}
+	return duration
+}
+
+// getList gets a comma separated setting with a default value.
+func (l *loader) getList(key string, defaultVal []string) []string {
+	value, _ := l.lookup(key)
+	if value == "" {
+		return defaultVal
+	}
+	return splitList(value)
+}
+
+// getSymbols gets a comma separated list of symbols with a default value, a symbol listed twice is malformed.
+func (l *loader) getSymbols(key string, defaultVal []string) []string {
+	value, origin := l.lookup(key)
+	if value == "" {
+		return defaultVal
+	}
+	symbols := splitList(value)
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		if seen[symbol] {
+			l.errs = append(l.errs, fmt.Errorf("%s (%s): duplicate symbol %s", key, origin, symbol))
+			return defaultVal
 		}
+		seen[symbol] = true
 	}
-	return defaultVal
-}
\ No newline at end of file
+	if len(symbols) == 0 {
+		l.invalid(key, origin, value, "symbol list")
+		return defaultVal
+	}
+	return symbols
+}
+
+// getMap gets a comma separated list of key:value pairs.
+func (l *loader) getMap(key string) map[string]string {
+	values := make(map[string]string)
+	value, origin := l.lookup(key)
+	for _, item := range splitList(value) {
+		k, v, ok := strings.Cut(item, ":")
+		if k, v = strings.TrimSpace(k), strings.TrimSpace(v); !ok || k == "" || v == "" {
+			l.invalid(key, origin, item, "key:value entry")
+			continue
+		}
+		values[k] = v
+	}
+	return values
+}
+
+// getIntMap gets a comma separated list of key:integer pairs.
+func (l *loader) getIntMap(key string) map[string]int {
+	values := make(map[string]int)
+	for k, v := range l.getMap(key) {
+		intVal, err := strconv.Atoi(v)
+		if err != nil {
+			_, origin := l.lookup(key)
+			l.invalid(key, origin, k+":"+v, "key:integer entry")
+			continue
+		}
+		values[k] = intVal
+	}
+	return values
+}
+
+// getFloatMap gets a comma separated list of key:number pairs.
+func (l *loader) getFloatMap(key string) map[string]float64 {
+	values := make(map[string]float64)
+	for k, v := range l.getMap(key) {
+		floatVal, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			_, origin := l.lookup(key)
+			l.invalid(key, origin, k+":"+v, "key:number entry")
+			continue
+		}
+		values[k] = floatVal
+	}
+	return values
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"frizo/futures_engine/internal/risk"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadFromFilePrecedence(t *testing.T) {
+	path := writeFile(t, "engine.env", `# engine settings
+export PORT=9000
+GRPC_PORT=0
+LOG_LEVEL="debug"
+SNAPSHOT_INTERVAL=30s # every half minute
+FUNDING_INTERVAL=4h
+`)
+	t.Setenv("PORT", "9100")
+
+	cfg, warnings, err := LoadFromFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, 9100, cfg.API.Port, "environment over file")
+	assert.Equal(t, 0, cfg.API.GRPCPort, "file over default")
+	assert.Equal(t, "debug", cfg.LogLevel, "quotes stripped")
+	assert.Equal(t, 30*time.Second, cfg.SnapshotInterval, "comment stripped")
+	assert.Equal(t, 4*time.Hour, cfg.Funding.Interval)
+	assert.Equal(t, 0.0075, cfg.Funding.RateCap, "funding default")
+	assert.Equal(t, "localhost", cfg.API.Host, "default")
+
+	cfg, _, err = LoadFromFile("")
+	require.NoError(t, err)
+	assert.Equal(t, 9100, cfg.API.Port, "environment only")
+	assert.Equal(t, 9090, cfg.API.GRPCPort)
+}
+
+func TestLoadFromFileYAML(t *testing.T) {
+	path := writeFile(t, "engine.yaml", `host: 0.0.0.0
+port: 8081
+symbols: [BTCUSDT, ETHUSDT, SOLUSDT]
+price_precision:
+  BTCUSDT: 1
+  SOLUSDT: 3
+initial_margin_rate: 0.2
+snapshot:
+  dir: /var/lib/futures/snapshots
+  keep: 5
+kafka_topics:
+  trades: prod.trades
+exposure:
+  max_gross_notional: 2000000
+  symbol_limits:
+    BTCUSDT: 500000
+max_open_orders: 100
+`)
+	cfg, warnings, err := LoadFromFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0", cfg.API.Host)
+	assert.Equal(t, 8081, cfg.API.Port)
+	assert.Equal(t, []string{"BTCUSDT", "ETHUSDT", "SOLUSDT"}, cfg.Symbols)
+	assert.Equal(t, map[string]int{"BTCUSDT": 1, "SOLUSDT": 3}, cfg.PricePrecision)
+	assert.Equal(t, 0.2, cfg.Margin.DefaultInitialMarginRate)
+	assert.Equal(t, 0.05, cfg.Margin.DefaultMaintenanceMarginRate, "margin default")
+	assert.Equal(t, "/var/lib/futures/snapshots", cfg.SnapshotDir, "nested keys joined")
+	assert.Equal(t, 5, cfg.SnapshotKeep)
+	assert.Equal(t, map[string]string{"trades": "prod.trades"}, cfg.KafkaTopics)
+	assert.Equal(t, risk.ExposureLimit{MaxGrossNotional: 2_000_000, SymbolLimits: map[string]float64{"BTCUSDT": 500_000}},
+		cfg.Risk.Exposure)
+	assert.Equal(t, []string{"unknown configuration key MAX_OPEN_ORDERS (" + path + ":17)"}, warnings)
+}
+
+func TestLoadFromFileSymbols(t *testing.T) {
+	cfg, _, err := LoadFromFile(writeFile(t, "symbols.env", "SYMBOLS= BTCUSDT ,ETHUSDT,, SOLUSDT\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"BTCUSDT", "ETHUSDT", "SOLUSDT"}, cfg.Symbols, "trimmed, empty entries skipped")
+
+	_, _, err = LoadFromFile(writeFile(t, "duplicate.env", "PORT=8080\nSYMBOLS=BTCUSDT,ETHUSDT,BTCUSDT\n"))
+	assert.ErrorContains(t, err, "SYMBOLS (")
+	assert.ErrorContains(t, err, "duplicate.env:2): duplicate symbol BTCUSDT")
+
+	_, _, err = LoadFromFile(writeFile(t, "empty.env", "SYMBOLS=,\n"))
+	assert.ErrorContains(t, err, "invalid symbol list")
+}
+
+func TestLoadFromFileMalformed(t *testing.T) {
+	path := writeFile(t, "bad.env", "PORT=80a\nHOST=localhost\nMETRICS_ENABLED=maybe\nAPI_KEYS=k1:alice,k2\n")
+	_, _, err := LoadFromFile(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `PORT (`+path+`:1): invalid integer "80a"`)
+	assert.Contains(t, err.Error(), `METRICS_ENABLED (`+path+`:3): invalid boolean "maybe"`, "every error listed")
+	assert.Contains(t, err.Error(), `API_KEYS (`+path+`:4): invalid key:value entry "k2"`)
+
+	t.Setenv("SNAPSHOT_KEEP", "three")
+	_, _, err = LoadFromFile("")
+	assert.ErrorContains(t, err, `SNAPSHOT_KEEP (environment): invalid integer "three"`)
+	_, err = Load()
+	assert.ErrorContains(t, err, `SNAPSHOT_KEEP (environment): invalid integer "three"`)
+
+	_, _, err = LoadFromFile(writeFile(t, "syntax.env", "PORT=8080\nnot a setting\n"))
+	assert.ErrorContains(t, err, `syntax.env:2: expected KEY=VALUE, got "not a setting"`)
+
+	_, _, err = LoadFromFile(writeFile(t, "syntax.yaml", "port: 8080\nsymbols: [[BTCUSDT]]\n"))
+	assert.ErrorContains(t, err, "syntax.yaml:2: SYMBOLS: expected a list of values")
+
+	_, _, err = LoadFromFile(filepath.Join(t.TempDir(), "missing.env"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Default().Validate())
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(c *Config)
+		want   string
+	}{
+		{"port range", func(c *Config) { c.API.Port = 70000 }, "PORT 70000 out of range 0-65535"},
+		{"grpc port range", func(c *Config) { c.API.GRPCPort = -1 }, "GRPC_PORT -1 out of range 0-65535"},
+		{"grpc port taken", func(c *Config) { c.API.GRPCPort = c.API.Port }, "GRPC_PORT 8080 is the PORT of the REST API"},
+		{"stream buffer", func(c *Config) { c.API.Stream.SendBuffer = -1 }, "STREAM_SEND_BUFFER -1 must not be negative"},
+		{"stream ping", func(c *Config) { c.API.Stream.PingInterval = -time.Second }, "STREAM_PING_INTERVAL -1s must not be negative"},
+		{"stream ticker", func(c *Config) { c.API.Stream.TickerInterval = -time.Second }, "STREAM_TICKER_INTERVAL -1s must not be negative"},
+		{"no symbols", func(c *Config) { c.Symbols = nil }, "SYMBOLS is empty"},
+		{"empty symbol", func(c *Config) { c.Symbols = []string{"BTCUSDT", ""} }, "SYMBOLS lists an empty symbol"},
+		{"duplicate symbol", func(c *Config) { c.Symbols = []string{"BTCUSDT", "BTCUSDT"} }, "SYMBOLS lists BTCUSDT twice"},
+		{"precision of unlisted symbol", func(c *Config) { c.PricePrecision["SOLUSDT"] = 2 }, "PRICE_PRECISION names SOLUSDT, not in SYMBOLS"},
+		{"precision range", func(c *Config) { c.SizePrecision["BTCUSDT"] = 16 }, "SIZE_PRECISION of BTCUSDT 16 out of range 0-15"},
+		{"funding interval", func(c *Config) { c.Funding.Interval = 5 * time.Hour }, "FUNDING_INTERVAL 5h0m0s must divide 24h"},
+		{"funding cap below floor", func(c *Config) { c.Funding.RateCap = -0.01 }, "FUNDING_RATE_CAP -0.01 below FUNDING_RATE_FLOOR -0.0075"},
+		{"initial margin rate", func(c *Config) { c.Margin.DefaultInitialMarginRate = 1.5 }, "INITIAL_MARGIN_RATE 1.5 out of range (0, 1]"},
+		{"maintenance margin rate", func(c *Config) { c.Margin.DefaultMaintenanceMarginRate = 0 }, "MAINTENANCE_MARGIN_RATE 0 out of range (0, 1]"},
+		{"maintenance below initial", func(c *Config) { c.Margin.DefaultMaintenanceMarginRate = 0.1 },
+			"MAINTENANCE_MARGIN_RATE 0.1 must be below INITIAL_MARGIN_RATE 0.1"},
+		{"liquidation fee rate", func(c *Config) { c.Margin.LiquidationFeeRate = 1 }, "LIQUIDATION_FEE_RATE 1 out of range [0, 1)"},
+		{"max notional", func(c *Config) { c.Margin.MaxNotional = -1 }, "MAX_NOTIONAL -1 must not be negative"},
+		{"reservation ttl", func(c *Config) { c.Margin.ReservationTTL = -time.Second }, "RESERVATION_TTL -1s must not be negative"},
+		{"circuit breaker move", func(c *Config) { c.Risk.CircuitBreaker.MaxMove = 0 }, "CIRCUIT_BREAKER_MAX_MOVE 0 out of range (0, 1]"},
+		{"circuit breaker window", func(c *Config) { c.Risk.CircuitBreaker.Window = 0 }, "CIRCUIT_BREAKER_WINDOW 0s must be positive"},
+		{"circuit breaker cool off", func(c *Config) { c.Risk.CircuitBreaker.CoolOff = -time.Second }, "CIRCUIT_BREAKER_COOL_OFF -1s must not be negative"},
+		{"rate limit missing", func(c *Config) { delete(c.Risk.RateLimits, risk.ActionAmend) }, "RATE_LIMIT_AMEND is not set"},
+		{"rate limit rate", func(c *Config) { c.Risk.RateLimits[risk.ActionPlace] = risk.RateLimit{Rate: 0, Burst: 5} },
+			"RATE_LIMIT_PLACE_RATE 0 must be positive"},
+		{"rate limit burst", func(c *Config) { c.Risk.RateLimits[risk.ActionCancel] = risk.RateLimit{Rate: 5, Burst: 0.5} },
+			"RATE_LIMIT_CANCEL_BURST 0.5 must be at least 1"},
+		{"exposure gross", func(c *Config) { c.Risk.Exposure.MaxGrossNotional = -1 }, "EXPOSURE_MAX_GROSS_NOTIONAL -1 must not be negative"},
+		{"exposure symbol", func(c *Config) { c.Risk.Exposure.SymbolLimits["SOLUSDT"] = 1000 },
+			"EXPOSURE_SYMBOL_LIMITS names SOLUSDT, not in SYMBOLS"},
+		{"log level", func(c *Config) { c.LogLevel = "verbose" }, `LOG_LEVEL "verbose" is not one of debug, info, warn, error`},
+		{"shutdown timeout", func(c *Config) { c.ShutdownTimeout = -time.Second }, "SHUTDOWN_TIMEOUT -1s must not be negative"},
+		{"health price age", func(c *Config) { c.HealthMaxPriceAge = -time.Second }, "HEALTH_MAX_PRICE_AGE -1s must not be negative"},
+		{"snapshot interval", func(c *Config) { c.SnapshotInterval = -time.Second }, "SNAPSHOT_INTERVAL -1s must not be negative"},
+		{"snapshot keep", func(c *Config) { c.SnapshotKeep = -1 }, "SNAPSHOT_KEEP -1 must not be negative"},
+		{"wal sync interval", func(c *Config) { c.WALSyncInterval = -time.Second }, "WAL_SYNC_INTERVAL -1s must not be negative"},
+		{"redis db", func(c *Config) { c.RedisDB = -1 }, "REDIS_DB -1 must not be negative"},
+		{"kafka topic kind", func(c *Config) { c.KafkaTopics["fills"] = "prod.fills" }, "KAFKA_TOPICS names unknown event kind fills"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Default()
+			tc.mutate(c)
+			err := c.Validate()
+			require.Error(t, err)
+			assert.Equal(t, tc.want, err.Error(), "only the rule of the setting fails")
+		})
+	}
+}
+
+func TestLoadListsEveryError(t *testing.T) {
+	path := writeFile(t, "engine.yaml", `port: 70000
+symbols: [BTCUSDT]
+initial_margin_rate: 0.04
+maintenance_margin_rate: five
+rate_limit:
+  place:
+    rate: 100
+    burst: 0
+`)
+	_, _, err := LoadFromFile(path)
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		`MAINTENANCE_MARGIN_RATE (` + path + `:4): invalid number "five"`,
+		"PORT 70000 out of range 0-65535",
+		"MAINTENANCE_MARGIN_RATE 0.05 must be below INITIAL_MARGIN_RATE 0.04",
+		"RATE_LIMIT_PLACE_BURST 0 must be at least 1",
+	}, strings.Split(err.Error(), "\n"))
+
+	cfg, _, err := LoadFromFile(writeFile(t, "risk.yaml", "rate_limit:\n  place:\n    rate: 100\n    burst: 200\n"))
+	require.NoError(t, err)
+	assert.Equal(t, risk.RateLimit{Rate: 100, Burst: 200}, cfg.Risk.RateLimits[risk.ActionPlace])
+	assert.Equal(t, risk.DefaultTierLimits[risk.ActionCancel], cfg.Risk.RateLimits[risk.ActionCancel])
+	assert.Equal(t, risk.RateLimit{Rate: 10, Burst: 20}, risk.DefaultTierLimits[risk.ActionPlace], "defaults untouched")
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// file settings of a configuration file, by the key of their environment variable
+type file struct {
+	path   string
+	keys   []string // in file order
+	values map[string]fileEntry
+}
+
+type fileEntry struct {
+	value   string
+	line    int
+	parent  string // key of the YAML mapping holding it, "" at the top level
+	mapping bool   // a YAML mapping, its entries joined as key:value pairs
+}
+
+func (f *file) set(key string, entry fileEntry) {
+	if _, exists := f.values[key]; !exists {
+		f.keys = append(f.keys, key)
+	}
+	f.values[key] = entry
+}
+
+// readFile parse path as YAML (.yaml, .yml) or .env
+func readFile(path string) (*file, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	f := &file{path: path, values: make(map[string]fileEntry)}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = f.parseYAML(data)
+	default:
+		err = f.parseEnv(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseEnv KEY=VALUE lines, optionally prefixed by "export". blank lines and lines starting with # are
+// skipped, a value may be quoted, a " #" after an unquoted value starts a comment
+func (f *file) parseEnv(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE, got %q", f.path, line, text)
+		}
+		value, err := unquote(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", f.path, line, key, err)
+		}
+		f.set(key, fileEntry{value: value, line: line})
+	}
+	return scanner.Err()
+}
+
+func unquote(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if quote := value[0]; quote == '"' || quote == '\'' {
+		end := strings.LastIndexByte(value, quote)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return value[1:end], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// parseYAML a mapping of settings. nested keys join their parents with "_" (snapshot: {dir: x} is
+// SNAPSHOT_DIR), keys are upper-cased. a list is read as a comma separated value, a nested mapping also
+// as key:value pairs under its own key (kafka_topics: {trades: t} is KAFKA_TOPICS=trades:t)
+func (f *file) parseYAML(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of settings", f.path, root.Line)
+	}
+	return f.walkYAML(root, "")
+}
+
+func (f *file) walkYAML(node *yaml.Node, prefix string) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := strings.ToUpper(keyNode.Value)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch valueNode.Kind {
+		case yaml.ScalarNode:
+			f.set(key, fileEntry{value: valueNode.Value, line: keyNode.Line, parent: prefix})
+		case yaml.SequenceNode:
+			items := make([]string, len(valueNode.Content))
+			for j, item := range valueNode.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("%s:%d: %s: expected a list of values", f.path, item.Line, key)
+				}
+				items[j] = item.Value
+			}
+			f.set(key, fileEntry{value: strings.Join(items, ","), line: keyNode.Line, parent: prefix})
+		case yaml.MappingNode:
+			var pairs []string
+			for j := 0; j+1 < len(valueNode.Content); j += 2 {
+				if valueNode.Content[j+1].Kind == yaml.ScalarNode {
+					pairs = append(pairs, valueNode.Content[j].Value+":"+valueNode.Content[j+1].Value)
+				}
+			}
+			f.set(key, fileEntry{value: strings.Join(pairs, ","), line: keyNode.Line, parent: prefix, mapping: true})
+			if err := f.walkYAML(valueNode, key); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s:%d: %s: unsupported value", f.path, keyNode.Line, key)
+		}
+	}
+	return nil
+}
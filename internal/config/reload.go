@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sections of the hot settings, the ones a Reloader applies to the running subsystems. every other
+// setting is read on startup only
+const (
+	SectionMargin  = "margin"  // Margin
+	SectionRisk    = "risk"    // Risk
+	SectionFunding = "funding" // Funding
+)
+
+// sections in the order Reload applies them
+var sections = []string{SectionMargin, SectionRisk, SectionFunding}
+
+// setting one key of Diff
+type setting struct {
+	key     string
+	section string // "" when read on startup only
+	secret  bool   // Diff hides the values
+	value   func(c *Config) any
+}
+
+// settings every key Load reads
+var settings = func() []setting {
+	list := []setting{
+		{key: "HOST", value: func(c *Config) any { return c.API.Host }},
+		{key: "PORT", value: func(c *Config) any { return c.API.Port }},
+		{key: "GRPC_PORT", value: func(c *Config) any { return c.API.GRPCPort }},
+		{key: "API_KEYS", secret: true, value: func(c *Config) any { return c.API.APIKeys }},
+		{key: "ADMIN_TOKEN", secret: true, value: func(c *Config) any { return c.API.AdminToken }},
+		{key: "STREAM_SEND_BUFFER", value: func(c *Config) any { return c.API.Stream.SendBuffer }},
+		{key: "STREAM_PING_INTERVAL", value: func(c *Config) any { return c.API.Stream.PingInterval }},
+		{key: "STREAM_TICKER_INTERVAL", value: func(c *Config) any { return c.API.Stream.TickerInterval }},
+
+		{key: "INITIAL_MARGIN_RATE", section: SectionMargin, value: func(c *Config) any { return c.Margin.DefaultInitialMarginRate }},
+		{key: "MAINTENANCE_MARGIN_RATE", section: SectionMargin, value: func(c *Config) any { return c.Margin.DefaultMaintenanceMarginRate }},
+		{key: "MAX_NOTIONAL", section: SectionMargin, value: func(c *Config) any { return c.Margin.MaxNotional }},
+		{key: "LIQUIDATION_FEE_RATE", section: SectionMargin, value: func(c *Config) any { return c.Margin.LiquidationFeeRate }},
+		{key: "RESERVATION_TTL", section: SectionMargin, value: func(c *Config) any { return c.Margin.ReservationTTL }},
+
+		{key: "CIRCUIT_BREAKER_MAX_MOVE", section: SectionRisk, value: func(c *Config) any { return c.Risk.CircuitBreaker.MaxMove }},
+		{key: "CIRCUIT_BREAKER_WINDOW", section: SectionRisk, value: func(c *Config) any { return c.Risk.CircuitBreaker.Window }},
+		{key: "CIRCUIT_BREAKER_COOL_OFF", section: SectionRisk, value: func(c *Config) any { return c.Risk.CircuitBreaker.CoolOff }},
+	}
+	for _, action := range rateLimitActions {
+		key := "RATE_LIMIT_" + strings.ToUpper(action.String())
+		list = append(list,
+			setting{key: key + "_RATE", section: SectionRisk, value: func(c *Config) any { return c.Risk.RateLimits[action].Rate }},
+			setting{key: key + "_BURST", section: SectionRisk, value: func(c *Config) any { return c.Risk.RateLimits[action].Burst }})
+	}
+	return append(list,
+		setting{key: "EXPOSURE_MAX_GROSS_NOTIONAL", section: SectionRisk, value: func(c *Config) any { return c.Risk.Exposure.MaxGrossNotional }},
+		setting{key: "EXPOSURE_SYMBOL_LIMITS", section: SectionRisk, value: func(c *Config) any { return c.Risk.Exposure.SymbolLimits }},
+
+		setting{key: "FUNDING_INTERVAL", section: SectionFunding, value: func(c *Config) any { return c.Funding.Interval }},
+		setting{key: "FUNDING_RATE_CAP", section: SectionFunding, value: func(c *Config) any { return c.Funding.RateCap }},
+		setting{key: "FUNDING_RATE_FLOOR", section: SectionFunding, value: func(c *Config) any { return c.Funding.RateFloor }},
+		setting{key: "FUNDING_INTEREST_RATE", section: SectionFunding, value: func(c *Config) any { return c.Funding.InterestRate }},
+
+		setting{key: "LOG_LEVEL", value: func(c *Config) any { return c.LogLevel }},
+		setting{key: "ENVIRONMENT", value: func(c *Config) any { return c.Environment }},
+		setting{key: "SYMBOLS", value: func(c *Config) any { return c.Symbols }},
+		setting{key: "PRICE_PRECISION", value: func(c *Config) any { return c.PricePrecision }},
+		setting{key: "SIZE_PRECISION", value: func(c *Config) any { return c.SizePrecision }},
+		setting{key: "METRICS_ENABLED", value: func(c *Config) any { return c.MetricsEnabled }},
+		setting{key: "HEALTH_MAX_PRICE_AGE", value: func(c *Config) any { return c.HealthMaxPriceAge }},
+		setting{key: "SHUTDOWN_TIMEOUT", value: func(c *Config) any { return c.ShutdownTimeout }},
+		setting{key: "SNAPSHOT_DIR", value: func(c *Config) any { return c.SnapshotDir }},
+		setting{key: "SNAPSHOT_INTERVAL", value: func(c *Config) any { return c.SnapshotInterval }},
+		setting{key: "SNAPSHOT_KEEP", value: func(c *Config) any { return c.SnapshotKeep }},
+		setting{key: "WAL_DIR", value: func(c *Config) any { return c.WALDir }},
+		setting{key: "WAL_SYNC_INTERVAL", value: func(c *Config) any { return c.WALSyncInterval }},
+		setting{key: "AUDIT_LOG_PATH", value: func(c *Config) any { return c.AuditLogPath }},
+		setting{key: "REDIS_ADDR", value: func(c *Config) any { return c.RedisAddr }},
+		setting{key: "REDIS_PASSWORD", secret: true, value: func(c *Config) any { return c.RedisPassword }},
+		setting{key: "REDIS_DB", value: func(c *Config) any { return c.RedisDB }},
+		setting{key: "HISTORY_DATABASE_URL", secret: true, value: func(c *Config) any { return c.HistoryDatabaseURL }},
+		setting{key: "KAFKA_BROKERS", value: func(c *Config) any { return c.KafkaBrokers }},
+		setting{key: "KAFKA_TOPICS", value: func(c *Config) any { return c.KafkaTopics }},
+	)
+}()
+
+// Change one changed setting
+type Change struct {
+	Key     string `json:"key"`
+	Section string `json:"section,omitempty"` // "" for a setting read on startup only
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// Diff the settings changed from one config to the other. secret values (API keys, passwords) are hidden
+func Diff(from, to *Config) []Change {
+	var changes []Change
+	for _, s := range settings {
+		before, after := fmt.Sprint(s.value(from)), fmt.Sprint(s.value(to))
+		if before == after {
+			continue
+		}
+		if s.secret {
+			before, after = "(hidden)", "(hidden)"
+		}
+		changes = append(changes, Change{Key: s.key, Section: s.section, From: before, To: after})
+	}
+	return changes
+}
+
+// ========================================================
+
+// Applier apply the settings of a section of the validated next config to a running subsystem
+type Applier func(next *Config) error
+
+// ReloadEvent audit record of a reload
+type ReloadEvent struct {
+	Path    string    `json:"path"`
+	Applied []Change  `json:"applied"`
+	Ignored []Change  `json:"ignored"` // read on startup only, or no applier runs for the section: take effect on restart
+	Failed  []Change  `json:"failed"`  // the applier of the section failed, the running value is kept
+	Time    time.Time `json:"time"`
+}
+
+// IgnoredKeys keys of the Ignored changes
+func (e ReloadEvent) IgnoredKeys() []string {
+	keys := make([]string, len(e.Ignored))
+	for i, change := range e.Ignored {
+		keys[i] = change.Key
+	}
+	return keys
+}
+
+// Reloader (設定重載) reads the configuration again, e.g. on SIGHUP, and applies the changed hot
+// settings through the appliers registered for their section: margin rates, risk limits, funding caps.
+// changes to the other settings, e.g. the port or the symbols, are ignored until the next restart
+type Reloader struct {
+	path     string
+	current  *Config
+	appliers map[string][]Applier
+	onReload []func(ReloadEvent)
+	mu       sync.Mutex
+}
+
+// NewReloader reload from the environment and the file at path ("" for none), current is the running config
+func NewReloader(path string, current *Config) *Reloader {
+	return &Reloader{path: path, current: current, appliers: make(map[string][]Applier)}
+}
+
+// Register apply the changes of section with apply, after the appliers registered before it
+func (r *Reloader) Register(section string, apply Applier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers[section] = append(r.appliers[section], apply)
+}
+
+// OnReload call fn with the record of every reload that read a valid config
+func (r *Reloader) OnReload(fn func(ReloadEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReload = append(r.onReload, fn)
+}
+
+// Current the running config: the startup config with the applied sections of the reloads
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload read the configuration and apply its changed sections. an invalid configuration changes
+// nothing. a section with a failing applier is reported failed and keeps its running values in Current
+func (r *Reloader) Reload() (ReloadEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, _, err := LoadFromFile(r.path)
+	if err != nil {
+		return ReloadEvent{}, fmt.Errorf("reload configuration: %w", err)
+	}
+
+	changes := Diff(r.current, next)
+	changed := make(map[string]bool)
+	for _, change := range changes {
+		changed[change.Section] = true
+	}
+	applied := make(map[string]bool)
+	failed := make(map[string]bool)
+	var errs []error
+	running := *r.current
+	for _, section := range sections {
+		if !changed[section] || len(r.appliers[section]) == 0 {
+			continue
+		}
+		for _, apply := range r.appliers[section] {
+			if err = apply(next); err != nil {
+				errs = append(errs, fmt.Errorf("apply %s settings: %w", section, err))
+				failed[section] = true
+			}
+		}
+		if !failed[section] {
+			running.setSection(section, next)
+			applied[section] = true
+		}
+	}
+	r.current = &running
+
+	event := ReloadEvent{Path: r.path, Time: time.Now()}
+	for _, change := range changes {
+		switch {
+		case applied[change.Section]:
+			event.Applied = append(event.Applied, change)
+		case failed[change.Section]:
+			event.Failed = append(event.Failed, change)
+		default:
+			event.Ignored = append(event.Ignored, change)
+		}
+	}
+	for _, fn := range r.onReload {
+		fn(event)
+	}
+	return event, errors.Join(errs...)
+}
+
+// setSection take the settings of section from next
+func (c *Config) setSection(section string, next *Config) {
+	switch section {
+	case SectionMargin:
+		c.Margin = next.Margin
+	case SectionRisk:
+		c.Risk = next.Risk
+	case SectionFunding:
+		c.Funding = next.Funding
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsCoverEveryKey(t *testing.T) {
+	l := newLoader(nil)
+	_, err := l.load()
+	require.NoError(t, err)
+
+	var keys []string
+	for _, s := range settings {
+		keys = append(keys, s.key)
+	}
+	var read []string
+	for key := range l.read {
+		read = append(read, key)
+	}
+	assert.ElementsMatch(t, read, keys, "every setting Load reads shows in Diff")
+}
+
+func TestDiff(t *testing.T) {
+	from, to := Default(), Default()
+	assert.Empty(t, Diff(from, to))
+
+	to.API.Port = 9000
+	to.Margin.LiquidationFeeRate = 0.02
+	to.RedisPassword = "secret"
+	to.Symbols = []string{"BTCUSDT"}
+	assert.Equal(t, []Change{
+		{Key: "PORT", From: "8080", To: "9000"},
+		{Key: "LIQUIDATION_FEE_RATE", Section: SectionMargin, From: "0", To: "0.02"},
+		{Key: "SYMBOLS", From: "[BTCUSDT ETHUSDT]", To: "[BTCUSDT]"},
+		{Key: "REDIS_PASSWORD", From: "(hidden)", To: "(hidden)"},
+	}, Diff(from, to))
+}
+
+func TestReloader(t *testing.T) {
+	path := writeFile(t, "engine.yaml", "port: 8080\nliquidation_fee_rate: 0.01\n")
+	running, _, err := LoadFromFile(path)
+	require.NoError(t, err)
+
+	var marginApplied []float64
+	fundingErr := errors.New("registry closed")
+	reloader := NewReloader(path, running)
+	reloader.Register(SectionMargin, func(next *Config) error {
+		marginApplied = append(marginApplied, next.Margin.LiquidationFeeRate)
+		return nil
+	})
+	reloader.Register(SectionFunding, func(*Config) error { return fundingErr })
+	var audited []ReloadEvent
+	reloader.OnReload(func(event ReloadEvent) { audited = append(audited, event) })
+
+	require.NoError(t, os.WriteFile(path, []byte(`port: 9000
+symbols: [BTCUSDT]
+liquidation_fee_rate: 0.02
+rate_limit:
+  place:
+    rate: 5
+funding_rate_cap: 0.01
+`), 0o644))
+	event, err := reloader.Reload()
+	assert.ErrorIs(t, err, fundingErr)
+	assert.Equal(t, []float64{0.02}, marginApplied)
+	assert.Equal(t, []Change{{Key: "LIQUIDATION_FEE_RATE", Section: SectionMargin, From: "0.01", To: "0.02"}}, event.Applied)
+	assert.Equal(t, []string{"PORT", "RATE_LIMIT_PLACE_RATE", "SYMBOLS"}, event.IgnoredKeys(), "cold, or no risk applier")
+	assert.Equal(t, []Change{{Key: "FUNDING_RATE_CAP", Section: SectionFunding, From: "0.0075", To: "0.01"}}, event.Failed)
+	require.Len(t, audited, 1)
+	assert.Equal(t, event, audited[0])
+
+	current := reloader.Current()
+	assert.Equal(t, 0.02, current.Margin.LiquidationFeeRate, "applied")
+	assert.Equal(t, 8080, current.API.Port, "ignored")
+	assert.Equal(t, []string{"BTCUSDT", "ETHUSDT"}, current.Symbols)
+	assert.Equal(t, 0.0075, current.Funding.RateCap, "failed")
+	assert.Equal(t, 0.01, running.Margin.LiquidationFeeRate, "the startup config is not modified")
+
+	// an invalid file changes nothing
+	require.NoError(t, os.WriteFile(path, []byte("liquidation_fee_rate: 2\n"), 0o644))
+	_, err = reloader.Reload()
+	assert.ErrorContains(t, err, "LIQUIDATION_FEE_RATE 2 out of range [0, 1)")
+	assert.Same(t, current, reloader.Current())
+	assert.Len(t, audited, 1)
+	assert.False(t, slices.Contains(marginApplied, 2))
+}
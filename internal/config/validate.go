@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/risk"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxPrecision most decimals of a price or size
+const MaxPrecision = 15
+
+// rateLimitActions order actions with a RATE_LIMIT_<ACTION>_RATE / _BURST setting
+var rateLimitActions = []risk.OrderAction{risk.ActionPlace, risk.ActionCancel, risk.ActionAmend}
+
+// logLevels levels logger.New knows
+var logLevels = []string{"debug", "info", "warn", "warning", "error"}
+
+// kafkaTopicKinds keys of KafkaTopics
+var kafkaTopicKinds = []string{"trades", "orders", "liquidations", "margin_calls", "funding", "audit"}
+
+// Validate check the ranges of the settings and their consistency, e.g. a maintenance margin rate below
+// the initial one. the error lists every failed rule, by the key of the setting
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.port("PORT", c.API.Port)
+	v.port("GRPC_PORT", c.API.GRPCPort)
+	v.check(c.API.GRPCPort == 0 || c.API.GRPCPort != c.API.Port, "GRPC_PORT %d is the PORT of the REST API", c.API.GRPCPort)
+	v.nonNegative("STREAM_SEND_BUFFER", float64(c.API.Stream.SendBuffer))
+	v.nonNegativeDuration("STREAM_PING_INTERVAL", c.API.Stream.PingInterval)
+	v.nonNegativeDuration("STREAM_TICKER_INTERVAL", c.API.Stream.TickerInterval)
+
+	v.check(len(c.Symbols) > 0, "SYMBOLS is empty")
+	seen := make(map[string]bool)
+	for _, symbol := range c.Symbols {
+		v.check(symbol != "", "SYMBOLS lists an empty symbol")
+		v.check(symbol == "" || !seen[symbol], "SYMBOLS lists %s twice", symbol)
+		seen[symbol] = true
+	}
+	v.precision("PRICE_PRECISION", c.PricePrecision, seen)
+	v.precision("SIZE_PRECISION", c.SizePrecision, seen)
+	v.check(c.Funding.Interval > 0 && (24*time.Hour)%c.Funding.Interval == 0, "FUNDING_INTERVAL %s must divide 24h", c.Funding.Interval)
+	v.check(c.Funding.RateCap >= c.Funding.RateFloor, "FUNDING_RATE_CAP %g below FUNDING_RATE_FLOOR %g",
+		c.Funding.RateCap, c.Funding.RateFloor)
+
+	initial, maintenance := c.Margin.DefaultInitialMarginRate, c.Margin.DefaultMaintenanceMarginRate
+	v.check(initial > 0 && initial <= 1, "INITIAL_MARGIN_RATE %g out of range (0, 1]", initial)
+	v.check(maintenance > 0 && maintenance <= 1, "MAINTENANCE_MARGIN_RATE %g out of range (0, 1]", maintenance)
+	v.check(maintenance < initial, "MAINTENANCE_MARGIN_RATE %g must be below INITIAL_MARGIN_RATE %g", maintenance, initial)
+	v.check(c.Margin.LiquidationFeeRate >= 0 && c.Margin.LiquidationFeeRate < 1,
+		"LIQUIDATION_FEE_RATE %g out of range [0, 1)", c.Margin.LiquidationFeeRate)
+	v.nonNegative("MAX_NOTIONAL", c.Margin.MaxNotional)
+	v.nonNegativeDuration("RESERVATION_TTL", c.Margin.ReservationTTL)
+
+	breaker := c.Risk.CircuitBreaker
+	v.check(breaker.MaxMove > 0 && breaker.MaxMove <= 1, "CIRCUIT_BREAKER_MAX_MOVE %g out of range (0, 1]", breaker.MaxMove)
+	v.check(breaker.Window > 0, "CIRCUIT_BREAKER_WINDOW %s must be positive", breaker.Window)
+	v.nonNegativeDuration("CIRCUIT_BREAKER_COOL_OFF", breaker.CoolOff)
+	for _, action := range rateLimitActions {
+		key := "RATE_LIMIT_" + strings.ToUpper(action.String())
+		limit, ok := c.Risk.RateLimits[action]
+		v.check(ok, "%s is not set", key)
+		v.check(!ok || limit.Rate > 0, "%s_RATE %g must be positive", key, limit.Rate)
+		v.check(!ok || limit.Burst >= 1, "%s_BURST %g must be at least 1", key, limit.Burst)
+	}
+	v.nonNegative("EXPOSURE_MAX_GROSS_NOTIONAL", c.Risk.Exposure.MaxGrossNotional)
+	for _, symbol := range slices.Sorted(maps.Keys(c.Risk.Exposure.SymbolLimits)) {
+		v.check(seen[symbol], "EXPOSURE_SYMBOL_LIMITS names %s, not in SYMBOLS", symbol)
+		v.check(c.Risk.Exposure.SymbolLimits[symbol] >= 0, "EXPOSURE_SYMBOL_LIMITS of %s %g must not be negative",
+			symbol, c.Risk.Exposure.SymbolLimits[symbol])
+	}
+
+	v.check(slices.Contains(logLevels, strings.ToLower(c.LogLevel)), "LOG_LEVEL %q is not one of debug, info, warn, error", c.LogLevel)
+	v.nonNegativeDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	v.nonNegativeDuration("HEALTH_MAX_PRICE_AGE", c.HealthMaxPriceAge)
+	v.nonNegativeDuration("SNAPSHOT_INTERVAL", c.SnapshotInterval)
+	v.nonNegative("SNAPSHOT_KEEP", float64(c.SnapshotKeep))
+	v.nonNegativeDuration("WAL_SYNC_INTERVAL", c.WALSyncInterval)
+	v.nonNegative("REDIS_DB", float64(c.RedisDB))
+	for _, kind := range slices.Sorted(maps.Keys(c.KafkaTopics)) {
+		v.check(slices.Contains(kafkaTopicKinds, kind), "KAFKA_TOPICS names unknown event kind %s", kind)
+	}
+
+	return errors.Join(v.errs...)
+}
+
+// validator collects the failed rules
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf(format, args...))
+	}
+}
+
+func (v *validator) port(key string, port int) {
+	v.check(port >= 0 && port <= 65535, "%s %d out of range 0-65535", key, port)
+}
+
+func (v *validator) nonNegative(key string, value float64) {
+	v.check(value >= 0, "%s %g must not be negative", key, value)
+}
+
+func (v *validator) nonNegativeDuration(key string, value time.Duration) {
+	v.check(value >= 0, "%s %s must not be negative", key, value)
+}
+
+// precision decimals of listed symbols, within 0 and MaxPrecision
+func (v *validator) precision(key string, decimals map[string]int, symbols map[string]bool) {
+	for _, symbol := range slices.Sorted(maps.Keys(decimals)) {
+		v.check(symbols[symbol], "%s names %s, not in SYMBOLS", key, symbol)
+		v.check(decimals[symbol] >= 0 && decimals[symbol] <= MaxPrecision, "%s of %s %d out of range 0-%d",
+			key, symbol, decimals[symbol], MaxPrecision)
+	}
+}
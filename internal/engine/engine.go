@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrEngineClosed = errors.New("futures engine closed")
+
+// Config
+type Config struct {
+	Symbols     []string
+	Margin      *margin.MarginConfig                  // nil means the margin system defaults
+	Liquidation *liquidation.Config                   // nil means liquidation.DefaultConfig
+	Precision   map[string]*position.PrecisionSetting // per symbol, position.DefaultPrecisionSetting for the others
+
+	PluginTimeout time.Duration // deadline of one plugin hook call, 0 means DefaultPluginTimeout
+}
+
+// FuturesEngine (合約引擎) composes the position manager, margin system and liquidation engine,
+// and runs the registered plugins at every lifecycle event that goes through it.
+// traffic (open, close, mark price) is refused until Start verified the state
+type FuturesEngine struct {
+	config      Config
+	positionMgr *position.PositionManager
+	margin      *margin.MarginSystem
+	liquidation *liquidation.LiquidationEngine
+	started     atomic.Bool  // Start passed the integrity check
+	ops         sync.RWMutex // held shared by every state changing call, exclusively by Quiesce
+
+	plugins       []EnginePlugin
+	onPluginError PluginErrorHandler
+	hooks         sync.WaitGroup // in-flight hook calls
+	closed        bool
+	pluginMu      sync.RWMutex
+}
+
+func NewFuturesEngine(config *Config) (*FuturesEngine, error) {
+	if config == nil || len(config.Symbols) == 0 {
+		return nil, fmt.Errorf("futures engine needs at least one symbol")
+	}
+
+	pm := position.NewWithOptions(position.WithSymbols(config.Symbols...), position.WithSymbolPrecision(config.Precision))
+	ms := margin.NewMarginSystem(pm, config.Margin)
+	return &FuturesEngine{
+		config:      *config,
+		positionMgr: pm,
+		margin:      ms,
+		liquidation: liquidation.NewLiquidationEngine(pm, ms, config.Liquidation),
+	}, nil
+}
+
+func (e *FuturesEngine) PositionManager() *position.PositionManager { return e.positionMgr }
+
+func (e *FuturesEngine) MarginSystem() *margin.MarginSystem { return e.margin }
+
+func (e *FuturesEngine) LiquidationEngine() *liquidation.LiquidationEngine { return e.liquidation }
+
+// OpenPosition check the margin, open (or add to) the position and refresh the account's position margin.
+// the log and the plugin hooks carry the request id of ctx
+func (e *FuturesEngine) OpenPosition(ctx context.Context, marginMode common.MarginMode, userID, symbol string, side position.PositionSide, price, size float64, leverage uint) (*position.Position, error) {
+	ctx = common.WithUserID(ctx, userID)
+	e.ops.RLock()
+	defer e.ops.RUnlock()
+
+	if err := e.accepting(); err != nil {
+		return nil, err
+	}
+	if err := e.margin.CheckOrderMarginForSide(userID, symbol, side, size, price, int16(leverage)); err != nil {
+		return nil, err
+	}
+	pos, err := e.positionMgr.OpenPosition(marginMode, userID, symbol, side, price, size, leverage)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.margin.UpdatePositionMargin(userID); err != nil {
+		return nil, err
+	}
+	logger.FromContext(ctx).Debug("Position opened", "position_id", pos.ID, "symbol", symbol, "side", side.String(),
+		"price", price, "size", size, "leverage", leverage)
+
+	e.notify(ctx, HookPositionOpen, func(ctx context.Context, p EnginePlugin) error {
+		return p.OnPositionOpen(ctx, pos)
+	})
+	return pos, nil
+}
+
+// ClosePosition close the whole position at price and settle it, return the realized PnL
+func (e *FuturesEngine) ClosePosition(ctx context.Context, userID, symbol string, side position.PositionSide, price float64) (*position.Position, float64, error) {
+	ctx = common.WithUserID(ctx, userID)
+	e.ops.RLock()
+	defer e.ops.RUnlock()
+
+	if err := e.accepting(); err != nil {
+		return nil, 0, err
+	}
+	pos, err := e.positionMgr.GetPosition(userID, symbol, side)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, pnl, err := e.margin.SettleReduceFill(userID, symbol, side, price, pos.Snapshot().Size, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	logger.FromContext(ctx).Debug("Position closed", "position_id", pos.ID, "symbol", symbol, "side", side.String(),
+		"price", price, "pnl", pnl)
+
+	e.notify(ctx, HookPositionClose, func(ctx context.Context, p EnginePlugin) error {
+		p.OnPositionClose(ctx, pos, pnl)
+		return nil
+	})
+	return pos, pnl, nil
+}
+
+// UpdateMarkPrice apply a mark price, then liquidate the positions of the symbol it made liquidatable
+// and the cross accounts holding the symbol that fell below their maintenance margin
+func (e *FuturesEngine) UpdateMarkPrice(ctx context.Context, symbol string, price float64) ([]liquidation.LiquidationResult, error) {
+	e.ops.RLock()
+	defer e.ops.RUnlock()
+
+	if err := e.accepting(); err != nil {
+		return nil, err
+	}
+	if _, err := e.positionMgr.UpdateMarkPrices(symbol, price); err != nil {
+		return nil, err
+	}
+	e.notify(ctx, HookMarkPrice, func(ctx context.Context, p EnginePlugin) error {
+		p.OnMarkPrice(ctx, symbol, price)
+		return nil
+	})
+
+	var results []liquidation.LiquidationResult
+	if candidates := e.positionMgr.GetAllLiquidatablePositions()[symbol]; len(candidates) > 0 {
+		for _, candidate := range candidates {
+			e.notify(ctx, HookLiquidation, func(ctx context.Context, p EnginePlugin) error {
+				p.OnLiquidation(ctx, *candidate)
+				return nil
+			})
+		}
+		// re-queued positions of other symbols may be due in the same pass
+		for _, symbolResults := range e.liquidation.ProcessAllLiquidations(map[string][]*position.LiquidationCandidate{symbol: candidates}) {
+			results = append(results, symbolResults...)
+		}
+	}
+
+	// cross accounts holding the symbol may breach as a whole
+	for _, check := range e.liquidation.CheckCrossAccounts(symbol) {
+		results = append(results, check.Results...)
+	}
+	return results, nil
+}
+
+// Close stop dispatching hooks, wait for the in-flight ones and release the position manager
+func (e *FuturesEngine) Close() error {
+	e.pluginMu.Lock()
+	if e.closed {
+		e.pluginMu.Unlock()
+		return ErrEngineClosed
+	}
+	e.closed = true
+	e.pluginMu.Unlock()
+
+	e.hooks.Wait()
+	return e.positionMgr.Close()
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingPlugin struct {
+	name                               string
+	opens, closes, liquidations, marks atomic.Int32
+	openErr                            error
+}
+
+func (p *countingPlugin) Name() string { return p.name }
+
+func (p *countingPlugin) OnPositionOpen(ctx context.Context, pos *position.Position) error {
+	p.opens.Add(1)
+	return p.openErr
+}
+
+func (p *countingPlugin) OnPositionClose(ctx context.Context, pos *position.Position, pnl float64) {
+	p.closes.Add(1)
+}
+
+func (p *countingPlugin) OnLiquidation(ctx context.Context, candidate position.LiquidationCandidate) {
+	p.liquidations.Add(1)
+}
+
+func (p *countingPlugin) OnMarkPrice(ctx context.Context, symbol string, price float64) {
+	p.marks.Add(1)
+}
+
+func newTestEngine(t *testing.T, users ...string) *FuturesEngine {
+	engine, err := NewFuturesEngine(&Config{Symbols: []string{"BTCUSDT", "ETHUSDT"}, PluginTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	for _, userID := range users {
+		_, err = engine.MarginSystem().CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, engine.MarginSystem().Deposit(userID, 100_000))
+	}
+	require.NoError(t, engine.Start(context.Background()))
+	return engine
+}
+
+func TestRegisterPluginLifecycleCounts(t *testing.T) {
+	engine := newTestEngine(t, "user1", "user2", "user3")
+	ctx := context.Background()
+	// covers the liquidation shortfall, no ADL against user2
+	require.NoError(t, engine.MarginSystem().InsuranceFund().Deposit("seed", 100_000))
+
+	plugin := &countingPlugin{name: "counter"}
+	require.NoError(t, engine.RegisterPlugin(plugin))
+	assert.Error(t, engine.RegisterPlugin(&countingPlugin{name: "counter"}), "duplicate name")
+	assert.Error(t, engine.RegisterPlugin(&countingPlugin{}), "empty name")
+	assert.Error(t, engine.RegisterPlugin(nil))
+	assert.Equal(t, []string{"counter"}, engine.Plugins())
+
+	_, err := engine.OpenPosition(ctx, common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 1, 2)
+	require.NoError(t, err)
+	_, err = engine.OpenPosition(ctx, common.ISOLATED, "user2", "BTCUSDT", position.SHORT, 50000, 1, 5)
+	require.NoError(t, err)
+	_, err = engine.OpenPosition(ctx, common.ISOLATED, "user3", "BTCUSDT", position.LONG, 50000, 1, 20)
+	require.NoError(t, err)
+	// rejected opens are not events
+	_, err = engine.OpenPosition(ctx, common.ISOLATED, "nobody", "BTCUSDT", position.LONG, 50000, 1, 20)
+	require.Error(t, err)
+
+	_, err = engine.UpdateMarkPrice(ctx, "BTCUSDT", 49000)
+	require.NoError(t, err)
+	results, err := engine.UpdateMarkPrice(ctx, "BTCUSDT", 45000) // 20x long liquidated
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "user3", results[0].UserID)
+
+	_, pnl, err := engine.ClosePosition(ctx, "user2", "BTCUSDT", position.SHORT, 45000)
+	require.NoError(t, err)
+	assert.InDelta(t, 5000, pnl, 1e-6)
+
+	require.NoError(t, engine.Close())
+	assert.Equal(t, int32(3), plugin.opens.Load())
+	assert.Equal(t, int32(1), plugin.closes.Load())
+	assert.Equal(t, int32(1), plugin.liquidations.Load())
+	assert.Equal(t, int32(2), plugin.marks.Load())
+
+	// nothing is dispatched once closed
+	assert.ErrorIs(t, engine.RegisterPlugin(&countingPlugin{name: "late"}), ErrEngineClosed)
+	assert.ErrorIs(t, engine.Close(), ErrEngineClosed)
+}
+
+type slowPlugin struct {
+	countingPlugin
+}
+
+func (p *slowPlugin) OnMarkPrice(ctx context.Context, symbol string, price float64) {
+	<-ctx.Done()
+}
+
+func (p *slowPlugin) OnPositionClose(ctx context.Context, pos *position.Position, pnl float64) {
+	panic("close hook")
+}
+
+func TestPluginErrorsAndTimeout(t *testing.T) {
+	engine := newTestEngine(t, "user1")
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var pluginErrors []PluginError
+	engine.OnPluginError(func(err PluginError) {
+		mu.Lock()
+		defer mu.Unlock()
+		pluginErrors = append(pluginErrors, err)
+	})
+
+	rejecting := &countingPlugin{name: "rejecting", openErr: errors.New("custom risk check")}
+	slow := &slowPlugin{countingPlugin: countingPlugin{name: "slow"}}
+	require.NoError(t, engine.RegisterPlugin(rejecting))
+	require.NoError(t, engine.RegisterPlugin(slow))
+
+	// hooks are asynchronous, the engine call returns before the slow hook's deadline
+	start := time.Now()
+	_, err := engine.OpenPosition(ctx, common.ISOLATED, "user1", "ETHUSDT", position.LONG, 2000, 1, 10)
+	require.NoError(t, err, "hook errors do not fail the event")
+	_, err = engine.UpdateMarkPrice(ctx, "ETHUSDT", 2100)
+	require.NoError(t, err)
+	_, _, err = engine.ClosePosition(ctx, "user1", "ETHUSDT", position.LONG, 2100)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	require.NoError(t, engine.Close())
+
+	byHook := make(map[string]PluginError)
+	for _, pluginErr := range pluginErrors {
+		byHook[pluginErr.Plugin+"."+pluginErr.Hook] = pluginErr
+	}
+	require.Len(t, byHook, 3)
+	assert.EqualError(t, byHook["rejecting."+HookPositionOpen].Err, "custom risk check")
+	assert.ErrorIs(t, byHook["slow."+HookMarkPrice], context.DeadlineExceeded)
+	assert.ErrorContains(t, byHook["slow."+HookPositionClose], "panic: close hook")
+
+	// the other hooks still ran
+	assert.Equal(t, int32(1), rejecting.marks.Load())
+	assert.Equal(t, int32(1), rejecting.closes.Load())
+	assert.Equal(t, int32(1), slow.opens.Load())
+}
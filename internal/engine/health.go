@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/health"
+	"time"
+)
+
+// DefaultMaxMarkPriceAge mark price age reported stale when RegisterHealth is given 0
+const DefaultMaxMarkPriceAge = 30 * time.Second
+
+// CheckHealth implements health.Contributor: ErrEngineNotStarted or ErrEngineClosed while the engine
+// refuses traffic
+func (e *FuturesEngine) CheckHealth(context.Context) error {
+	return e.accepting()
+}
+
+// RegisterHealth (健康檢查) register the critical checks of the engine on checker: "engine" (accepting
+// traffic), "liquidation_loop" and one "mark_price:<symbol>" per symbol, failing once the last mark
+// price is older than maxPriceAge (0 means DefaultMaxMarkPriceAge)
+func (e *FuturesEngine) RegisterHealth(checker *health.Checker, maxPriceAge time.Duration) {
+	if maxPriceAge <= 0 {
+		maxPriceAge = DefaultMaxMarkPriceAge
+	}
+	checker.Register("engine", true, e)
+	checker.Register("liquidation_loop", true, e.liquidation)
+	for _, symbol := range e.config.Symbols {
+		checker.Register("mark_price:"+symbol, true, e.MarkPriceHealth(symbol, maxPriceAge))
+	}
+}
+
+// MarkPriceHealth contributor failing when the last mark price of symbol is older than maxAge.
+// a symbol without a mark price yet is healthy: the feed may not have ticked since the start
+func (e *FuturesEngine) MarkPriceHealth(symbol string, maxAge time.Duration) health.Contributor {
+	return health.ContributorFunc(func(context.Context) error {
+		last := e.positionMgr.GetMarkPriceHistory(symbol, 1)
+		if len(last) == 0 {
+			return nil
+		}
+		if age := time.Since(last[0].Timestamp); age > maxAge {
+			return fmt.Errorf("mark price stale: last update %s ago", age.Round(time.Second))
+		}
+		return nil
+	})
+}
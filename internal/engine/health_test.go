@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"context"
+	"frizo/futures_engine/internal/health"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterHealth(t *testing.T) {
+	engine, err := NewFuturesEngine(&Config{Symbols: []string{"BTCUSDT", "ETHUSDT"}})
+	require.NoError(t, err)
+	defer engine.Close()
+	ctx := context.Background()
+
+	checker := health.NewChecker(0)
+	engine.RegisterHealth(checker, 50*time.Millisecond)
+	report := checker.Check(ctx)
+	assert.Equal(t, health.StatusDown, report.Status)
+	assert.Equal(t, "engine: "+ErrEngineNotStarted.Error(), report.Reason())
+
+	require.NoError(t, engine.Start(ctx))
+	require.NoError(t, engine.LiquidationEngine().Start())
+	defer engine.LiquidationEngine().Stop()
+	_, err = engine.UpdateMarkPrice(ctx, "BTCUSDT", 50000)
+	require.NoError(t, err)
+	report = checker.Check(ctx)
+	assert.Equal(t, health.StatusOK, report.Status, "ETHUSDT without a mark price yet is fine: %s", report.Reason())
+	var names []string
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+	}
+	assert.Equal(t, []string{"engine", "liquidation_loop", "mark_price:BTCUSDT", "mark_price:ETHUSDT"}, names)
+
+	// the feed stops ticking BTCUSDT
+	time.Sleep(80 * time.Millisecond)
+	report = checker.Check(ctx)
+	assert.Equal(t, health.StatusDown, report.Status)
+	assert.Contains(t, report.Reason(), "mark_price:BTCUSDT: mark price stale")
+	assert.NotContains(t, report.Reason(), "ETHUSDT")
+
+	_, err = engine.UpdateMarkPrice(ctx, "BTCUSDT", 50100)
+	require.NoError(t, err)
+	assert.Equal(t, health.StatusOK, checker.Check(ctx).Status, "fresh again")
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+)
+
+var ErrEngineNotStarted = errors.New("futures engine not started")
+
+// IntegrityError Start refused to serve traffic, Report lists the violations
+type IntegrityError struct {
+	Report position.IntegrityReport
+}
+
+func (e *IntegrityError) Error() string {
+	if len(e.Report.Violations) == 0 {
+		return "integrity check failed"
+	}
+	first := e.Report.Violations[0]
+	subject := first.PositionID
+	if subject == "" {
+		subject = first.UserID
+	}
+	return fmt.Sprintf("integrity check failed: %d violations, first %s %s: %s",
+		len(e.Report.Violations), first.Type, subject, first.Detail)
+}
+
+// WarmUp recompute derived state after a recovery: mark price dependent fields of every position
+// from the last mark price, then every account's position margin and unrealized PnL
+func (e *FuturesEngine) WarmUp(ctx context.Context) error {
+	for _, symbol := range e.positionMgr.GetAllSymbols() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := e.positionMgr.RefreshMarkPrices(symbol); err != nil && !errors.Is(err, position.ErrSymbolNotFound) {
+			return fmt.Errorf("refresh mark prices of %s: %w", symbol, err)
+		}
+	}
+
+	var err error
+	e.positionMgr.ForEachUser(func(userID string, _ []*position.Position) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		if updateErr := e.margin.UpdatePositionMargin(userID); updateErr != nil && !errors.Is(updateErr, margin.ErrAccountNotFound) {
+			err = fmt.Errorf("update position margin of %s: %w", userID, updateErr)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// Start (啟動) warm up, verify the integrity of positions and accounts, then accept traffic.
+// an unhealthy state returns an *IntegrityError and the engine keeps refusing traffic
+func (e *FuturesEngine) Start(ctx context.Context) error {
+	if e.isClosed() {
+		return ErrEngineClosed
+	}
+	if err := e.WarmUp(ctx); err != nil {
+		return fmt.Errorf("warm up: %w", err)
+	}
+	report := e.positionMgr.VerifyIntegrity(ctx)
+	if !report.IsHealthy {
+		return &IntegrityError{Report: report}
+	}
+
+	e.started.Store(true)
+	return nil
+}
+
+// Quiesce run fn once no OpenPosition, ClosePosition or UpdateMarkPrice is in flight, new calls wait
+// until fn returns: a consistent point of the position manager and the margin system, e.g. for snapshots.
+// fn must not call those methods
+func (e *FuturesEngine) Quiesce(fn func()) {
+	e.ops.Lock()
+	defer e.ops.Unlock()
+	fn()
+}
+
+// Accepting nil while the engine serves traffic, ErrEngineNotStarted or ErrEngineClosed otherwise
+func (e *FuturesEngine) Accepting() error {
+	return e.accepting()
+}
+
+// accepting error unless Start succeeded and Close was not called
+func (e *FuturesEngine) accepting() error {
+	if e.isClosed() {
+		return ErrEngineClosed
+	}
+	if !e.started.Load() {
+		return ErrEngineNotStarted
+	}
+	return nil
+}
+
+func (e *FuturesEngine) isClosed() bool {
+	e.pluginMu.RLock()
+	defer e.pluginMu.RUnlock()
+	return e.closed
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/version"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartVerifiesIntegrity(t *testing.T) {
+	ctx := context.Background()
+	engine, err := NewFuturesEngine(&Config{Symbols: []string{"BTCUSDT"}})
+	require.NoError(t, err)
+	defer engine.Close()
+
+	account, err := engine.MarginSystem().CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, engine.MarginSystem().Deposit("user1", 10_000))
+
+	_, err = engine.OpenPosition(ctx, common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	assert.ErrorIs(t, err, ErrEngineNotStarted)
+	_, err = engine.UpdateMarkPrice(ctx, "BTCUSDT", 50000)
+	assert.ErrorIs(t, err, ErrEngineNotStarted)
+
+	// ledger broken by a missed update: refused
+	account.AvailableBalance += 500
+	account.FrozenBalance += 500
+	err = engine.Start(ctx)
+	var integrityErr *IntegrityError
+	require.True(t, errors.As(err, &integrityErr))
+	require.Len(t, integrityErr.Report.Violations, 1)
+	assert.Equal(t, position.ViolationBalanceLeak, integrityErr.Report.Violations[0].Type)
+	assert.Equal(t, "user1", integrityErr.Report.Violations[0].UserID)
+	_, err = engine.OpenPosition(ctx, common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	assert.ErrorIs(t, err, ErrEngineNotStarted)
+
+	account.AvailableBalance -= 500
+	account.FrozenBalance -= 500
+	require.NoError(t, engine.Start(ctx))
+
+	// trading keeps the state consistent
+	_, err = engine.OpenPosition(ctx, common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	_, err = engine.UpdateMarkPrice(ctx, "BTCUSDT", 48000)
+	require.NoError(t, err)
+	status := engine.Status()
+	assert.True(t, status.Started)
+	assert.Equal(t, map[string]float64{"BTCUSDT": 48000}, status.MarkPrices)
+	assert.Equal(t, version.Get(), status.Build)
+	require.NoError(t, engine.WarmUp(ctx))
+	report := engine.PositionManager().VerifyIntegrity(ctx)
+	assert.True(t, report.IsHealthy, "%+v", report.Violations)
+	assert.Equal(t, 1, report.Positions)
+
+	require.NoError(t, engine.Close())
+	assert.True(t, engine.Status().Closed)
+	assert.ErrorIs(t, engine.Start(ctx), ErrEngineClosed)
+}
+
+func TestQuiesceWaitsForInFlightCalls(t *testing.T) {
+	ctx := context.Background()
+	engine, err := NewFuturesEngine(&Config{Symbols: []string{"BTCUSDT"}})
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Start(ctx))
+
+	released := make(chan struct{})
+	done := make(chan struct{})
+	engine.Quiesce(func() {
+		go func() {
+			_, _ = engine.UpdateMarkPrice(ctx, "BTCUSDT", 50000)
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Error("mark price applied while quiesced")
+		case <-time.After(20 * time.Millisecond):
+		}
+		assert.Zero(t, engine.PositionManager().GetSymbolMarkPrice("BTCUSDT"))
+		close(released)
+	})
+	<-released
+	<-done
+	assert.Equal(t, 50000.0, engine.PositionManager().GetSymbolMarkPrice("BTCUSDT"))
+}
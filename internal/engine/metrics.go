@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/metrics"
+	"math"
+	"time"
+)
+
+// metrics sampled by ReportMetrics, labelled by symbol except the engine wide ones
+const (
+	MetricOpenPositions        = "open_positions"
+	MetricOpenInterest         = "open_interest"          // open long size
+	MetricOpenInterestNotional = "open_interest_notional" // long + short position value
+	MetricMarkPriceAge         = "mark_price_age_seconds" // since the last mark price, price feed staleness
+	MetricPendingLiquidations  = "pending_liquidations"   // re-queued after a failed pass
+	MetricInsuranceFundBalance = liquidation.MetricInsuranceFundBalance
+)
+
+// SetMetrics registry of the components' event metrics: liquidations (liquidation.LiquidationEngine)
+// and margin calls (position.PositionManager). nil means metrics.Nop
+func (e *FuturesEngine) SetMetrics(registry metrics.Registry) {
+	e.positionMgr.SetMetrics(registry)
+	e.liquidation.SetMetrics(registry)
+}
+
+// ReportMetrics set the gauges sampled from the engine state: open positions and open interest per symbol,
+// mark price age, pending liquidations, insurance fund. meant for a scrape hook, e.g. metrics.Prometheus.OnScrape
+func (e *FuturesEngine) ReportMetrics(registry metrics.Registry) {
+	now := time.Now()
+	for _, oi := range e.positionMgr.GetTopSymbolsByOpenInterest(math.MaxInt) {
+		labels := metrics.Labels{"symbol": oi.Symbol}
+		registry.SetGauge(MetricOpenPositions, float64(oi.PositionCount), labels)
+		registry.SetGauge(MetricOpenInterestNotional, oi.TotalNotional, labels)
+	}
+	for _, symbol := range e.positionMgr.GetAllSymbols() {
+		labels := metrics.Labels{"symbol": symbol}
+		if long, err := e.positionMgr.GetOpenInterest(symbol); err == nil {
+			registry.SetGauge(MetricOpenInterest, long, labels)
+		}
+		// no series before the first mark price
+		if last := e.positionMgr.GetMarkPriceHistory(symbol, 1); len(last) == 1 {
+			registry.SetGauge(MetricMarkPriceAge, now.Sub(last[0].Timestamp).Seconds(), labels)
+		}
+	}
+
+	registry.SetGauge(MetricPendingLiquidations, float64(e.liquidation.PendingLiquidations()), nil)
+	registry.SetGauge(MetricInsuranceFundBalance, e.margin.InsuranceFund().Balance(), nil)
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/position"
+	"time"
+)
+
+// DefaultPluginTimeout deadline of one hook call when Config.PluginTimeout is 0
+const DefaultPluginTimeout = time.Second
+
+// hook names, reported in PluginError
+const (
+	HookPositionOpen  = "OnPositionOpen"
+	HookPositionClose = "OnPositionClose"
+	HookLiquidation   = "OnLiquidation"
+	HookMarkPrice     = "OnMarkPrice"
+)
+
+// EnginePlugin (插件) operator logic run at engine lifecycle points without forking the engine,
+// e.g. custom fee accounting, risk checks or liquidation heuristics. hooks are called asynchronously,
+// each with its own deadline, and must not block the engine. positions are live, read them with Snapshot.
+type EnginePlugin interface {
+	Name() string
+	OnPositionOpen(ctx context.Context, pos *position.Position) error
+	OnPositionClose(ctx context.Context, pos *position.Position, pnl float64)
+	OnLiquidation(ctx context.Context, candidate position.LiquidationCandidate)
+	OnMarkPrice(ctx context.Context, symbol string, price float64)
+}
+
+// PluginError failed, timed out or panicking hook
+type PluginError struct {
+	Plugin    string
+	Hook      string
+	Err       error
+	RequestID string // of the call that ran the hook, "" when none
+}
+
+func (e PluginError) Error() string {
+	return fmt.Sprintf("plugin %s %s: %v", e.Plugin, e.Hook, e.Err)
+}
+
+func (e PluginError) Unwrap() error { return e.Err }
+
+// PluginErrorHandler called for every PluginError, from the hook's goroutine
+type PluginErrorHandler func(err PluginError)
+
+// RegisterPlugin add a plugin, its hooks run for every later event. names must be unique
+func (e *FuturesEngine) RegisterPlugin(p EnginePlugin) error {
+	if p == nil {
+		return fmt.Errorf("plugin must not be nil")
+	}
+	if p.Name() == "" {
+		return fmt.Errorf("plugin name is required")
+	}
+
+	e.pluginMu.Lock()
+	defer e.pluginMu.Unlock()
+
+	if e.closed {
+		return ErrEngineClosed
+	}
+	for _, registered := range e.plugins {
+		if registered.Name() == p.Name() {
+			return fmt.Errorf("plugin %s already registered", p.Name())
+		}
+	}
+	e.plugins = append(e.plugins, p)
+	return nil
+}
+
+// Plugins registered plugin names, in registration order
+func (e *FuturesEngine) Plugins() []string {
+	e.pluginMu.RLock()
+	defer e.pluginMu.RUnlock()
+
+	names := make([]string, len(e.plugins))
+	for i, p := range e.plugins {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// OnPluginError replace the default handler, which logs the error
+func (e *FuturesEngine) OnPluginError(handler PluginErrorHandler) {
+	e.pluginMu.Lock()
+	defer e.pluginMu.Unlock()
+	e.onPluginError = handler
+}
+
+// notify run hook for every plugin in its own goroutine. the hook context keeps the values of ctx
+// but not its cancellation, the caller usually returns before the hook is done
+func (e *FuturesEngine) notify(ctx context.Context, hook string, call func(ctx context.Context, p EnginePlugin) error) {
+	e.pluginMu.RLock()
+	defer e.pluginMu.RUnlock()
+
+	if e.closed || len(e.plugins) == 0 {
+		return
+	}
+	for _, p := range e.plugins {
+		e.hooks.Add(1)
+		go func(p EnginePlugin) {
+			defer e.hooks.Done()
+			if err := e.invoke(ctx, p, call); err != nil {
+				e.pluginError(ctx, PluginError{Plugin: p.Name(), Hook: hook, Err: err, RequestID: common.RequestIDFrom(ctx)})
+			}
+		}(p)
+	}
+}
+
+// invoke one hook under the plugin timeout, a hook that overruns keeps running but is reported
+func (e *FuturesEngine) invoke(ctx context.Context, p EnginePlugin, call func(ctx context.Context, p EnginePlugin) error) error {
+	hookCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), e.pluginTimeout())
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		result <- call(hookCtx, p)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-hookCtx.Done():
+		return hookCtx.Err()
+	}
+}
+
+func (e *FuturesEngine) pluginError(ctx context.Context, err PluginError) {
+	e.pluginMu.RLock()
+	handler := e.onPluginError
+	e.pluginMu.RUnlock()
+
+	if handler != nil {
+		handler(err)
+		return
+	}
+	logger.FromContext(ctx).Warn("plugin hook failed", "plugin", err.Plugin, "hook", err.Hook, "error", err.Err)
+}
+
+func (e *FuturesEngine) pluginTimeout() time.Duration {
+	if e.config.PluginTimeout > 0 {
+		return e.config.PluginTimeout
+	}
+	return DefaultPluginTimeout
+}
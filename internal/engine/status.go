@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"frizo/futures_engine/internal/version"
+	"time"
+)
+
+// EngineStatus (引擎狀態) point-in-time view of the engine for operators and health endpoints
+type EngineStatus struct {
+	Started             bool               `json:"started"`
+	Closed              bool               `json:"closed"`
+	Symbols             []string           `json:"symbols"`
+	Plugins             []string           `json:"plugins"`
+	MarkPrices          map[string]float64 `json:"mark_prices"` // symbols without a mark price yet are absent
+	PendingLiquidations int                `json:"pending_liquidations"`
+	InsuranceFund       float64            `json:"insurance_fund"`
+	Build               version.BuildInfo  `json:"build"` // of the running binary
+	Timestamp           time.Time          `json:"timestamp"`
+}
+
+// Status current engine status
+func (e *FuturesEngine) Status() EngineStatus {
+	return EngineStatus{
+		Started:             e.started.Load(),
+		Closed:              e.isClosed(),
+		Symbols:             e.positionMgr.GetAllSymbols(),
+		Plugins:             e.Plugins(),
+		MarkPrices:          e.positionMgr.GetSymbolMarkPrices(),
+		PendingLiquidations: e.liquidation.PendingLiquidations(),
+		InsuranceFund:       e.margin.InsuranceFund().Balance(),
+		Build:               version.Get(),
+		Timestamp:           time.Now(),
+	}
+}
@@ -0,0 +1,369 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"frizo/futures_engine/internal/funding"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBufferSize events waiting for the publisher when Config.BufferSize is 0
+	DefaultBufferSize = 8192
+	// DefaultBatchSize most messages of one Publish when Config.BatchSize is 0
+	DefaultBatchSize = 256
+	// DefaultRetryBackoff wait before the first retry of a failed Publish when Config.RetryBackoff is 0,
+	// doubled at every retry up to MaxRetryBackoff
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// MaxRetryBackoff longest wait between two attempts of a batch
+	MaxRetryBackoff = 5 * time.Second
+)
+
+// event types, the Type of a Message. order events keep their orderbook.OrderEventType
+const (
+	TypeTrade             = "trade"
+	TypeLiquidation       = "liquidation"
+	TypeMarginCall        = "margin_call"
+	TypeFundingSettlement = "funding_settlement"
+	TypeConfigReload      = "config_reload"
+	TypeKillSwitch        = "kill_switch"
+	TypeCircuitBreaker    = "circuit_breaker"
+)
+
+// metrics of the forwarder, labelled by topic except the errors
+const (
+	MetricEventsPublished     = "events_published_total"
+	MetricEventsDropped       = "events_dropped_total"        // full queue, or still queued when Close gave up
+	MetricEventsPublishErrors = "events_publish_errors_total" // failed attempts of a batch
+)
+
+// Message one event bound for a topic. Sequence increases by one per queued message of a Forwarder and
+// restarts with it, Epoch tells the runs apart: (Epoch, Sequence) identifies a message for deduplication
+type Message struct {
+	Topic    string
+	Key      string // symbol of market events, user id of account events, the type of audit records
+	Type     string
+	Sequence uint64
+	Epoch    int64 // start of the forwarder, unix nanoseconds
+	Time     time.Time
+	Value    []byte // JSON payload
+}
+
+// EventPublisher (事件發佈) delivers messages to a broker, e.g. KafkaPublisher. Publish returns nil once
+// the broker acknowledged every message, messages of one key in the order given. after an error the
+// same batch is published again, part of it may be delivered twice
+type EventPublisher interface {
+	Publish(ctx context.Context, messages []Message) error
+	Close() error
+}
+
+// Topics topic of each kind of event, "" means the default
+type Topics struct {
+	Trades       string // "futures.trades"
+	Orders       string // "futures.orders"
+	Liquidations string // "futures.liquidations"
+	MarginCalls  string // "futures.margin_calls"
+	Funding      string // "futures.funding"
+	Audit        string // "futures.audit"
+}
+
+func (t Topics) withDefaults() Topics {
+	defaults := Topics{
+		Trades:       "futures.trades",
+		Orders:       "futures.orders",
+		Liquidations: "futures.liquidations",
+		MarginCalls:  "futures.margin_calls",
+		Funding:      "futures.funding",
+		Audit:        "futures.audit",
+	}
+	for _, topic := range []struct{ value, fallback *string }{
+		{&t.Trades, &defaults.Trades},
+		{&t.Orders, &defaults.Orders},
+		{&t.Liquidations, &defaults.Liquidations},
+		{&t.MarginCalls, &defaults.MarginCalls},
+		{&t.Funding, &defaults.Funding},
+		{&t.Audit, &defaults.Audit},
+	} {
+		if *topic.value == "" {
+			*topic.value = *topic.fallback
+		}
+	}
+	return t
+}
+
+// Config nil means the defaults
+type Config struct {
+	Topics       Topics
+	BufferSize   int           // 0 means DefaultBufferSize
+	BatchSize    int           // 0 means DefaultBatchSize
+	RetryBackoff time.Duration // 0 means DefaultRetryBackoff
+}
+
+// ========================================================
+
+// queued a message waiting for its batch, the payload is encoded by the forwarding goroutine
+type queued struct {
+	message Message
+	payload any
+}
+
+// Forwarder (事件轉發) turns engine events into messages and hands them to an EventPublisher off the
+// matching path: events are queued without blocking and one goroutine publishes them in queue order,
+// retrying a failed batch until it is acknowledged. while the broker is slow or down the queue fills up
+// and further events are dropped, counted in Dropped and MetricEventsDropped
+type Forwarder struct {
+	position.NoopPositionEventHandler
+
+	publisher EventPublisher
+	config    Config
+	epoch     int64
+	queue     chan queued
+
+	sequence uint64 // last queued, guarded by mu
+	closing  bool
+	mu       sync.Mutex
+
+	dropped   atomic.Uint64
+	metrics   metrics.Registry
+	metricsMu sync.Mutex
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewForwarder start forwarding to publisher, until Close
+func NewForwarder(publisher EventPublisher, config *Config) *Forwarder {
+	if config == nil {
+		config = &Config{}
+	}
+	c := *config
+	c.Topics = c.Topics.withDefaults()
+	if c.BufferSize <= 0 {
+		c.BufferSize = DefaultBufferSize
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = DefaultRetryBackoff
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{
+		publisher: publisher,
+		config:    c,
+		epoch:     time.Now().UnixNano(),
+		queue:     make(chan queued, c.BufferSize),
+		metrics:   metrics.Nop{},
+		stop:      cancel,
+		done:      make(chan struct{}),
+	}
+	go f.run(ctx)
+	return f
+}
+
+// SetMetrics registry of the forwarder metrics, nil means metrics.Nop
+func (f *Forwarder) SetMetrics(registry metrics.Registry) {
+	f.metricsMu.Lock()
+	defer f.metricsMu.Unlock()
+	f.metrics = metrics.OrNop(registry)
+}
+
+func (f *Forwarder) metricsRegistry() metrics.Registry {
+	f.metricsMu.Lock()
+	defer f.metricsMu.Unlock()
+	return f.metrics
+}
+
+// PublishTrade queue a trade on the trades topic, keyed by symbol. fits api.Server.OnTrade
+func (f *Forwarder) PublishTrade(trade orderbook.Trade) bool {
+	return f.enqueue(f.config.Topics.Trades, trade.Symbol, TypeTrade, trade)
+}
+
+// PublishOrderEvent queue an order event on the orders topic, keyed by user. fits api.Server.OnOrderEvent
+func (f *Forwarder) PublishOrderEvent(event orderbook.OrderEvent) bool {
+	return f.enqueue(f.config.Topics.Orders, event.UserID, string(event.Type), event)
+}
+
+// PublishFundingSettlement queue a settlement round on the funding topic, keyed by symbol
+func (f *Forwarder) PublishFundingSettlement(settlement margin.FundingSettlement) bool {
+	return f.enqueue(f.config.Topics.Funding, settlement.Symbol, TypeFundingSettlement, settlement)
+}
+
+// PublishAudit queue an operator action record, e.g. a config.ReloadEvent of type TypeConfigReload, on
+// the audit topic, keyed by the kind of record
+func (f *Forwarder) PublishAudit(eventType string, record any) bool {
+	return f.enqueue(f.config.Topics.Audit, eventType, eventType, record)
+}
+
+// HandlePositionLiquidated implements position.PositionEventHandler: every liquidation fill on the
+// liquidations topic, keyed by user
+func (f *Forwarder) HandlePositionLiquidated(event *position.PositionLiquidatedEvent) error {
+	f.enqueue(f.config.Topics.Liquidations, event.UserID, TypeLiquidation, *event)
+	return nil
+}
+
+// HandlePreLiquidationWarning implements position.PositionEventHandler: margin calls on their topic,
+// keyed by user
+func (f *Forwarder) HandlePreLiquidationWarning(event *position.PreLiquidationWarning) error {
+	f.enqueue(f.config.Topics.MarginCalls, event.UserID, TypeMarginCall, *event)
+	return nil
+}
+
+// Settler settler publishing every settlement it makes, e.g. around the margin system given to a
+// funding.FundingScheduler
+func (f *Forwarder) Settler(settler funding.Settler) funding.Settler {
+	return fundingSettler{settler: settler, forwarder: f}
+}
+
+type fundingSettler struct {
+	settler   funding.Settler
+	forwarder *Forwarder
+}
+
+func (s fundingSettler) SettleFunding(symbol string, rate float64) (margin.FundingSettlement, error) {
+	settlement, err := s.settler.SettleFunding(symbol, rate)
+	if err == nil {
+		s.forwarder.PublishFundingSettlement(settlement)
+	}
+	return settlement, err
+}
+
+// Dropped events lost to a full queue or left queued by Close
+func (f *Forwarder) Dropped() uint64 {
+	return f.dropped.Load()
+}
+
+// Close stop taking events and publish the queued ones until ctx is done, then close the publisher
+func (f *Forwarder) Close(ctx context.Context) error {
+	f.mu.Lock()
+	if f.closing {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closing = true
+	close(f.queue)
+	f.mu.Unlock()
+
+	var err error
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	f.stop()
+	<-f.done
+	return errors.Join(err, f.publisher.Close())
+}
+
+// enqueue number the event and queue it, false when dropped. the sequence is taken with the queue slot,
+// so sequences follow the queue order
+func (f *Forwarder) enqueue(topic, key, eventType string, payload any) bool {
+	f.mu.Lock()
+	if !f.closing {
+		select {
+		case f.queue <- queued{message: Message{Topic: topic, Key: key, Type: eventType, Sequence: f.sequence + 1,
+			Epoch: f.epoch, Time: time.Now()}, payload: payload}:
+			f.sequence++
+			f.mu.Unlock()
+			return true
+		default:
+		}
+	}
+	f.mu.Unlock()
+
+	f.dropped.Add(1)
+	f.metricsRegistry().AddCounter(MetricEventsDropped, 1, metrics.Labels{"topic": topic})
+	return false
+}
+
+// run publish what is queued in batches of up to BatchSize, until the queue is closed and drained
+func (f *Forwarder) run(ctx context.Context) {
+	defer close(f.done)
+
+	batch := make([]Message, 0, f.config.BatchSize)
+	for {
+		item, ok := <-f.queue
+		if !ok {
+			return
+		}
+		batch = append(batch[:0], f.encode(item))
+	fill:
+		for len(batch) < f.config.BatchSize {
+			select {
+			case item, ok = <-f.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, f.encode(item))
+			default:
+				break fill
+			}
+		}
+		f.publish(ctx, batch)
+	}
+}
+
+func (f *Forwarder) encode(item queued) Message {
+	message := item.message
+	value, err := json.Marshal(item.payload)
+	if err != nil {
+		logger.Default().Error("event encoding failed", "topic", message.Topic, "type", message.Type, "error", err)
+	}
+	message.Value = value
+	return message
+}
+
+// publish batch until the publisher acknowledges it, or ctx is done (Close gave up)
+func (f *Forwarder) publish(ctx context.Context, batch []Message) {
+	backoff := f.config.RetryBackoff
+	for {
+		err := f.publisher.Publish(ctx, batch)
+		if err == nil {
+			f.count(MetricEventsPublished, batch)
+			return
+		}
+		f.metricsRegistry().AddCounter(MetricEventsPublishErrors, 1, nil)
+		logger.Default().Warn("event publish failed, retrying", "messages", len(batch), "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			f.dropFrom(batch)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, MaxRetryBackoff)
+	}
+}
+
+// dropFrom count the batch and everything still queued as dropped
+func (f *Forwarder) dropFrom(batch []Message) {
+	f.count(MetricEventsDropped, batch)
+	dropped := len(batch)
+	for item := range f.queue {
+		f.count(MetricEventsDropped, []Message{item.message})
+		dropped++
+	}
+	f.dropped.Add(uint64(dropped))
+	logger.Default().Error("event publishing stopped, queued events dropped", "dropped", dropped)
+}
+
+// count add the messages of batch to metric, per topic
+func (f *Forwarder) count(metric string, batch []Message) {
+	perTopic := make(map[string]int)
+	for _, message := range batch {
+		perTopic[message.Topic]++
+	}
+	registry := f.metricsRegistry()
+	for topic, n := range perTopic {
+		registry.AddCounter(metric, float64(n), metrics.Labels{"topic": topic})
+	}
+}
@@ -0,0 +1,221 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher in-memory broker: keeps every acknowledged message, fails the next `failures` calls after
+// delivering the first message of the batch, as a broker timing out mid-batch would
+type fakePublisher struct {
+	mu        sync.Mutex
+	failures  int
+	blocked   chan struct{} // Publish waits for it when set
+	delivered []Message
+	closed    bool
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, messages []Message) error {
+	if p.blocked != nil {
+		select {
+		case <-p.blocked:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		p.delivered = append(p.delivered, messages[0])
+		return errors.New("request timed out")
+	}
+	p.delivered = append(p.delivered, messages...)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// byKey delivered messages of topic grouped by key, duplicates included
+func (p *fakePublisher) byKey(topic string) map[string][]Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make(map[string][]Message)
+	for _, message := range p.delivered {
+		if message.Topic == topic {
+			keys[message.Key] = append(keys[message.Key], message)
+		}
+	}
+	return keys
+}
+
+// counter metrics.Registry summing the counters by name and topic
+type counter struct {
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func (c *counter) AddCounter(name string, delta float64, labels metrics.Labels) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name+"/"+labels["topic"]] += delta
+}
+func (c *counter) SetGauge(string, float64, metrics.Labels) {}
+func (c *counter) Observe(string, float64, metrics.Labels)  {}
+
+func (c *counter) get(name, topic string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[name+"/"+topic]
+}
+
+func TestForwarderKeysAndTopics(t *testing.T) {
+	publisher := &fakePublisher{}
+	f := NewForwarder(publisher, &Config{Topics: Topics{Trades: "trades"}})
+
+	f.PublishTrade(orderbook.Trade{Symbol: "BTCUSDT", Price: 50000, Size: 1, BuyUserID: "alice", SellUserID: "bob"})
+	f.PublishOrderEvent(orderbook.OrderEvent{Type: orderbook.OrderFilled, UserID: "alice", Symbol: "BTCUSDT"})
+	change := position.PositionChange{UserID: "carol", Symbol: "ETHUSDT", Size: 2}
+	require.NoError(t, f.HandlePositionLiquidated(&position.PositionLiquidatedEvent{PositionChange: change}))
+	require.NoError(t, f.HandlePreLiquidationWarning(&position.PreLiquidationWarning{UserID: "carol", Symbol: "ETHUSDT"}))
+	require.NoError(t, f.HandlePositionOpened(&position.PositionOpenedEvent{}), "not forwarded")
+	settler := f.Settler(settlerFunc(func(symbol string, rate float64) (margin.FundingSettlement, error) {
+		return margin.FundingSettlement{Symbol: symbol, Rate: rate, TotalPaid: 12}, nil
+	}))
+	_, err := settler.SettleFunding("BTCUSDT", 0.0001)
+	require.NoError(t, err)
+	require.NoError(t, f.Close(context.Background()))
+
+	require.Len(t, publisher.delivered, 5)
+	assert.True(t, publisher.closed)
+	for i, want := range []struct{ topic, key, eventType string }{
+		{"trades", "BTCUSDT", TypeTrade},
+		{"futures.orders", "alice", string(orderbook.OrderFilled)},
+		{"futures.liquidations", "carol", TypeLiquidation},
+		{"futures.margin_calls", "carol", TypeMarginCall},
+		{"futures.funding", "BTCUSDT", TypeFundingSettlement},
+	} {
+		message := publisher.delivered[i]
+		assert.Equal(t, want, struct{ topic, key, eventType string }{message.Topic, message.Key, message.Type})
+		assert.Equal(t, uint64(i+1), message.Sequence)
+		assert.Equal(t, f.epoch, message.Epoch)
+	}
+
+	var trade orderbook.Trade
+	require.NoError(t, json.Unmarshal(publisher.delivered[0].Value, &trade))
+	assert.Equal(t, "bob", trade.SellUserID)
+	var settlement margin.FundingSettlement
+	require.NoError(t, json.Unmarshal(publisher.delivered[4].Value, &settlement))
+	assert.Equal(t, 12.0, settlement.TotalPaid)
+}
+
+type settlerFunc func(symbol string, rate float64) (margin.FundingSettlement, error)
+
+func (f settlerFunc) SettleFunding(symbol string, rate float64) (margin.FundingSettlement, error) {
+	return f(symbol, rate)
+}
+
+func TestForwarderRetriesInOrderPerKey(t *testing.T) {
+	publisher := &fakePublisher{failures: 3}
+	registry := &counter{counts: make(map[string]float64)}
+	f := NewForwarder(publisher, &Config{BatchSize: 8, RetryBackoff: time.Millisecond})
+	f.SetMetrics(registry)
+
+	users := []string{"alice", "bob", "carol"}
+	for i := 0; i < 60; i++ {
+		f.PublishOrderEvent(orderbook.OrderEvent{UserID: users[i%len(users)], OrderID: strconv.Itoa(i)})
+	}
+	require.NoError(t, f.Close(context.Background()))
+	assert.Zero(t, f.Dropped())
+
+	for _, user := range users {
+		delivered := publisher.byKey("futures.orders")[user]
+		// at least once: a failed batch comes again, so a sequence may repeat but never goes back
+		var seen []uint64
+		for i, message := range delivered {
+			if i > 0 && message.Sequence == delivered[i-1].Sequence {
+				continue
+			}
+			if len(seen) > 0 {
+				assert.Greater(t, message.Sequence, seen[len(seen)-1], "user %s out of order", user)
+			}
+			seen = append(seen, message.Sequence)
+		}
+		assert.Len(t, seen, 20, "every event of %s delivered", user)
+	}
+	assert.Equal(t, 60.0, registry.get(MetricEventsPublished, "futures.orders"))
+	assert.Equal(t, 3.0, registry.get(MetricEventsPublishErrors, ""))
+}
+
+func TestForwarderDropsWhenBrokerStalls(t *testing.T) {
+	publisher := &fakePublisher{blocked: make(chan struct{})}
+	registry := &counter{counts: make(map[string]float64)}
+	f := NewForwarder(publisher, &Config{BufferSize: 4, BatchSize: 1})
+	f.SetMetrics(registry)
+
+	accepted := 0
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if f.PublishTrade(orderbook.Trade{Symbol: "BTCUSDT"}) {
+			accepted++
+		}
+	}
+	assert.Less(t, time.Since(start), time.Second, "publishing never waits for the broker")
+	assert.LessOrEqual(t, accepted, 5, "queue plus the batch in flight")
+	assert.Equal(t, uint64(100-accepted), f.Dropped())
+	assert.Equal(t, float64(100-accepted), registry.get(MetricEventsDropped, "futures.trades"))
+
+	close(publisher.blocked)
+	require.NoError(t, f.Close(context.Background()))
+	require.Len(t, publisher.delivered, accepted)
+	for i, message := range publisher.delivered {
+		assert.Equal(t, uint64(i+1), message.Sequence, "dropped events take no sequence")
+	}
+}
+
+func TestForwarderCloseGivesUp(t *testing.T) {
+	publisher := &fakePublisher{failures: 1 << 30}
+	f := NewForwarder(publisher, &Config{BatchSize: 2, RetryBackoff: time.Millisecond})
+	for i := 0; i < 5; i++ {
+		f.PublishTrade(orderbook.Trade{Symbol: "BTCUSDT"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, f.Close(ctx), context.DeadlineExceeded)
+	assert.Equal(t, uint64(5), f.Dropped(), "the batch retried and the rest queued")
+	assert.False(t, f.PublishTrade(orderbook.Trade{}), "closed")
+}
+
+func TestKafkaMessages(t *testing.T) {
+	now := time.Now()
+	converted := kafkaMessages([]Message{{Topic: "futures.trades", Key: "BTCUSDT", Type: TypeTrade, Sequence: 42, Epoch: 7, Time: now, Value: []byte(`{}`)}})
+	require.Len(t, converted, 1)
+	message := converted[0]
+	assert.Equal(t, "futures.trades", message.Topic)
+	assert.Equal(t, []byte("BTCUSDT"), message.Key)
+	assert.Equal(t, now, message.Time)
+
+	headers := make(map[string]string)
+	for _, header := range message.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	assert.Equal(t, map[string]string{HeaderSequence: "42", HeaderEpoch: "7", HeaderType: TypeTrade}, headers)
+}
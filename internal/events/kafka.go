@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// headers of every Kafka message
+const (
+	HeaderSequence = "sequence"
+	HeaderEpoch    = "epoch"
+	HeaderType     = "type"
+)
+
+// DefaultKafkaWriteTimeout deadline of one Publish when KafkaConfig.WriteTimeout is 0
+const DefaultKafkaWriteTimeout = 10 * time.Second
+
+// KafkaConfig brokers are host:port
+type KafkaConfig struct {
+	Brokers      []string
+	WriteTimeout time.Duration // 0 means DefaultKafkaWriteTimeout
+}
+
+// KafkaPublisher EventPublisher writing to Kafka: messages are hashed on their key to a partition, which
+// keeps the order of one key, and acknowledged by every in-sync replica. topics are not created
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaPublisher(config *KafkaConfig) *KafkaPublisher {
+	timeout := config.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultKafkaWriteTimeout
+	}
+	return &KafkaPublisher{writer: &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    DefaultBatchSize,
+		BatchTimeout: time.Millisecond, // Publish gets whole batches already
+		WriteTimeout: timeout,
+	}}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, messages []Message) error {
+	return p.writer.WriteMessages(ctx, kafkaMessages(messages)...)
+}
+
+// Close flush and close the connections
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+func kafkaMessages(messages []Message) []kafka.Message {
+	converted := make([]kafka.Message, len(messages))
+	for i, message := range messages {
+		converted[i] = kafka.Message{
+			Topic: message.Topic,
+			Key:   []byte(message.Key),
+			Value: message.Value,
+			Time:  message.Time,
+			Headers: []kafka.Header{
+				{Key: HeaderSequence, Value: []byte(strconv.FormatUint(message.Sequence, 10))},
+				{Key: HeaderEpoch, Value: []byte(strconv.FormatInt(message.Epoch, 10))},
+				{Key: HeaderType, Value: []byte(message.Type)},
+			},
+		}
+	}
+	return converted
+}
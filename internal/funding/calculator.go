@@ -0,0 +1,28 @@
+package funding
+
+import (
+	"time"
+)
+
+// PremiumIndexFunc premium index of a symbol, (impact mid - index) / index
+type PremiumIndexFunc func(symbol string) (float64, error)
+
+// Calculator (資金費率計算) rate = clamp(premium index + interest rate, floor, cap), parameters from the registry
+type Calculator struct {
+	registry *ConfigRegistry
+	premium  PremiumIndexFunc
+}
+
+func NewCalculator(registry *ConfigRegistry, premium PremiumIndexFunc) *Calculator {
+	return &Calculator{registry: registry, premium: premium}
+}
+
+// FundingRate rate of the interval starting at intervalStart, with the config in force for that interval
+func (c *Calculator) FundingRate(symbol string, intervalStart time.Time) (float64, error) {
+	premium, err := c.premium(symbol)
+	if err != nil {
+		return 0, err
+	}
+	config := c.registry.Get(symbol, intervalStart)
+	return min(max(premium+config.InterestRate, config.RateFloor), config.RateCap), nil
+}
@@ -0,0 +1,119 @@
+package funding
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"sync"
+	"time"
+)
+
+// FundingConfig funding parameters of one symbol
+type FundingConfig struct {
+	Interval     time.Duration `json:"interval"`      // must divide 24h, boundaries aligned to UTC midnight
+	RateCap      float64       `json:"rate_cap"`      // e.g. +0.75%
+	RateFloor    float64       `json:"rate_floor"`    // e.g. -0.75%
+	InterestRate float64       `json:"interest_rate"` // per interval, e.g. 0.01% per 8h
+}
+
+var DefaultFundingConfig = FundingConfig{
+	Interval:     8 * time.Hour,
+	RateCap:      0.0075,
+	RateFloor:    -0.0075,
+	InterestRate: 0.0001,
+}
+
+func (c FundingConfig) Validate() error {
+	if c.Interval <= 0 || (24*time.Hour)%c.Interval != 0 {
+		return fmt.Errorf("funding interval %s must divide 24h", c.Interval)
+	}
+	if c.RateCap < c.RateFloor {
+		return fmt.Errorf("funding rate cap %v below floor %v", c.RateCap, c.RateFloor)
+	}
+	return nil
+}
+
+// nextBoundary first interval boundary after t
+func (c FundingConfig) nextBoundary(t time.Time) time.Time {
+	return t.UTC().Truncate(c.Interval).Add(c.Interval)
+}
+
+// ========================================================
+
+// symbolConfig config in force and the change waiting for the next boundary
+type symbolConfig struct {
+	current     FundingConfig
+	pending     *FundingConfig
+	effectiveAt time.Time
+}
+
+// ConfigRegistry (資金費率參數) per-symbol funding config. a change never applies mid-interval,
+// it takes effect at the next boundary of the interval in force.
+type ConfigRegistry struct {
+	clock    common.Clock
+	defaults FundingConfig
+	symbols  map[string]*symbolConfig
+	mu       sync.RWMutex
+}
+
+// NewConfigRegistry defaults nil means DefaultFundingConfig, clock nil means system clock
+func NewConfigRegistry(clock common.Clock, defaults *FundingConfig) (*ConfigRegistry, error) {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	if defaults == nil {
+		defaults = &DefaultFundingConfig
+	}
+	if err := defaults.Validate(); err != nil {
+		return nil, err
+	}
+	return &ConfigRegistry{
+		clock:    clock,
+		defaults: *defaults,
+		symbols:  make(map[string]*symbolConfig),
+	}, nil
+}
+
+// Set change the config of a symbol from the next interval boundary on, return that boundary.
+// a second change before the boundary replaces the pending one.
+func (r *ConfigRegistry) Set(symbol string, config FundingConfig) (time.Time, error) {
+	if err := config.Validate(); err != nil {
+		return time.Time{}, fmt.Errorf("funding config of %s: %w", symbol, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	entry := r.entry(symbol, now)
+	entry.pending = &config
+	entry.effectiveAt = entry.current.nextBoundary(now)
+	return entry.effectiveAt, nil
+}
+
+// Get config of a symbol in force at t
+func (r *ConfigRegistry) Get(symbol string, t time.Time) FundingConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.symbols[symbol]
+	if !ok {
+		return r.defaults
+	}
+	if entry.pending != nil && !t.Before(entry.effectiveAt) {
+		return *entry.pending
+	}
+	return entry.current
+}
+
+// entry promote a pending config already in force at now, no lock
+func (r *ConfigRegistry) entry(symbol string, now time.Time) *symbolConfig {
+	entry, ok := r.symbols[symbol]
+	if !ok {
+		entry = &symbolConfig{current: r.defaults}
+		r.symbols[symbol] = entry
+	}
+	if entry.pending != nil && !now.Before(entry.effectiveAt) {
+		entry.current, entry.pending = *entry.pending, nil
+	}
+	return entry
+}
@@ -0,0 +1,294 @@
+package funding
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/position"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateCalculator funding rate of a symbol for the interval starting at intervalStart, implemented by Calculator
+type RateCalculator interface {
+	FundingRate(symbol string, intervalStart time.Time) (float64, error)
+}
+
+var _ position.FundingRateSchedule = (*FundingScheduler)(nil)
+
+// Settler settles one funding payment round of a symbol, implemented by margin.MarginSystem
+type Settler interface {
+	SettleFunding(symbol string, rate float64) (margin.FundingSettlement, error)
+}
+
+// metrics of the scheduler, labelled by symbol
+const (
+	MetricFundingSettlements = "funding_settlements_total"
+	MetricFundingSkipped     = "funding_skipped_total" // intervals missed during downtime
+	MetricFundingErrors      = "funding_errors_total"
+	MetricFundingPaid        = "funding_paid_total"
+	MetricFundingRate        = "funding_rate" // rate of the last settlement
+)
+
+// Config settlement intervals come from the ConfigRegistry
+type Config struct {
+	PollInterval time.Duration // clock check period of the background loop
+
+	// intervals missed during downtime are settled once each on startup (at the current rate).
+	// otherwise only the latest one is settled and the rest are recorded as skipped.
+	SettleMissedIntervals bool
+}
+
+var DefaultConfig = &Config{
+	PollInterval:          time.Second,
+	SettleMissedIntervals: true,
+}
+
+// FundingHistory one settled (or skipped) interval of one symbol
+type FundingHistory struct {
+	Symbol    string    `json:"symbol"`
+	Rate      float64   `json:"rate"`
+	Time      time.Time `json:"time"` // interval boundary
+	TotalPaid float64   `json:"total_paid"`
+	Skipped   bool      `json:"skipped,omitempty"` // missed during downtime, not settled
+	Error     string    `json:"error,omitempty"`
+}
+
+// SchedulerSnapshot persisted state, restores exactly-once settlement after a restart
+type SchedulerSnapshot struct {
+	LastSettled map[string]time.Time `json:"last_settled"` // symbol -> last interval boundary settled
+}
+
+// FundingScheduler (資金費率結算排程) settles every symbol once per boundary of its own interval
+type FundingScheduler struct {
+	clock      common.Clock
+	registry   *ConfigRegistry
+	calculator RateCalculator
+	settler    Settler
+	symbols    func() []string
+	config     *Config
+
+	lastSettled map[string]time.Time
+	history     []FundingHistory
+	metrics     metrics.Registry
+	mu          sync.Mutex
+
+	// background loop lifecycle
+	cancel context.CancelFunc
+	done   chan struct{}
+	runMu  sync.Mutex
+}
+
+// NewFundingScheduler symbols lists the symbols to settle (e.g. PositionManager.GetAllSymbols).
+// a symbol missing from snapshot starts fresh: nothing is due before its next boundary.
+// clock nil means system clock, registry nil means DefaultFundingConfig for every symbol,
+// config nil means DefaultConfig.
+func NewFundingScheduler(clock common.Clock, registry *ConfigRegistry, calculator RateCalculator, settler Settler,
+	symbols func() []string, config *Config, snapshot *SchedulerSnapshot) *FundingScheduler {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	if registry == nil {
+		registry, _ = NewConfigRegistry(clock, nil)
+	}
+	if config == nil {
+		config = DefaultConfig
+	}
+
+	s := &FundingScheduler{
+		clock:       clock,
+		registry:    registry,
+		calculator:  calculator,
+		settler:     settler,
+		symbols:     symbols,
+		config:      config,
+		lastSettled: make(map[string]time.Time),
+		metrics:     metrics.Nop{},
+	}
+	if snapshot != nil {
+		for symbol, at := range snapshot.LastSettled {
+			s.lastSettled[symbol] = at.UTC()
+		}
+	}
+	return s
+}
+
+// Snapshot state to persist after every settlement
+func (s *FundingScheduler) Snapshot() SchedulerSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lastSettled := make(map[string]time.Time, len(s.lastSettled))
+	for symbol, at := range s.lastSettled {
+		lastSettled[symbol] = at
+	}
+	return SchedulerSnapshot{LastSettled: lastSettled}
+}
+
+// History settled and skipped intervals, oldest first. symbol "" means all symbols
+func (s *FundingScheduler) History(symbol string) []FundingHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := make([]FundingHistory, 0, len(s.history))
+	for _, entry := range s.history {
+		if symbol == "" || entry.Symbol == symbol {
+			history = append(history, entry)
+		}
+	}
+	return history
+}
+
+// NextSettlement next settlement boundary of a symbol
+func (s *FundingScheduler) NextSettlement(symbol string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last, ok := s.lastSettled[symbol]
+	if !ok {
+		last = s.clock.Now()
+	}
+	return s.registry.Get(symbol, last).nextBoundary(last)
+}
+
+// CurrentFundingRate rate of the running interval of a symbol, what the next settlement charges
+// if the inputs stay as they are
+func (s *FundingScheduler) CurrentFundingRate(symbol string) (float64, error) {
+	s.mu.Lock()
+	start, ok := s.lastSettled[symbol]
+	if !ok {
+		now := s.clock.Now()
+		start = now.UTC().Truncate(s.registry.Get(symbol, now).Interval)
+	}
+	s.mu.Unlock()
+
+	return s.calculator.FundingRate(symbol, start)
+}
+
+// RunDue settle every boundary each symbol passed since its last settlement, return the new history entries.
+// each boundary is settled at most once.
+func (s *FundingScheduler) RunDue() []FundingHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	symbols := s.symbols()
+	sort.Strings(symbols)
+
+	var entries []FundingHistory
+	for _, symbol := range symbols {
+		last, ok := s.lastSettled[symbol]
+		if !ok {
+			s.lastSettled[symbol] = now.UTC().Truncate(s.registry.Get(symbol, now).Interval)
+			continue
+		}
+
+		// the interval starting at last runs with the config in force at last
+		var due, starts []time.Time
+		for {
+			next := s.registry.Get(symbol, last).nextBoundary(last)
+			if next.After(now) {
+				break
+			}
+			due, starts = append(due, next), append(starts, last)
+			last = next
+		}
+
+		for i, at := range due {
+			skip := i < len(due)-1 && !s.config.SettleMissedIntervals
+			entries = append(entries, s.settle(symbol, starts[i], at, skip))
+			s.lastSettled[symbol] = at
+		}
+	}
+	s.history = append(s.history, entries...)
+
+	return entries
+}
+
+// Start settle missed intervals now, then check the clock every PollInterval until Stop
+func (s *FundingScheduler) Start() error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.cancel != nil {
+		return fmt.Errorf("funding scheduler already started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	s.RunDue()
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.RunDue()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop the background loop
+func (s *FundingScheduler) Stop() error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if s.cancel == nil {
+		return fmt.Errorf("funding scheduler not started")
+	}
+	s.cancel()
+	<-s.done
+	s.cancel, s.done = nil, nil
+
+	return nil
+}
+
+// SetMetrics registry of the scheduler's metrics, nil means metrics.Nop
+func (s *FundingScheduler) SetMetrics(registry metrics.Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = metrics.OrNop(registry)
+}
+
+// settle one symbol for the interval [start, at], no lock
+func (s *FundingScheduler) settle(symbol string, start, at time.Time, skip bool) FundingHistory {
+	entry := FundingHistory{Symbol: symbol, Time: at, Skipped: skip}
+	labels := metrics.Labels{"symbol": symbol}
+	if skip {
+		s.metrics.AddCounter(MetricFundingSkipped, 1, labels)
+		return entry
+	}
+
+	rate, err := s.calculator.FundingRate(symbol, start)
+	if err != nil {
+		entry.Error = err.Error()
+		s.metrics.AddCounter(MetricFundingErrors, 1, labels)
+		return entry
+	}
+	entry.Rate = rate
+
+	settlement, err := s.settler.SettleFunding(symbol, rate)
+	if err != nil {
+		entry.Error = err.Error()
+		s.metrics.AddCounter(MetricFundingErrors, 1, labels)
+		return entry
+	}
+	entry.TotalPaid = settlement.TotalPaid
+
+	s.metrics.AddCounter(MetricFundingSettlements, 1, labels)
+	s.metrics.AddCounter(MetricFundingPaid, settlement.TotalPaid, labels)
+	s.metrics.SetGauge(MetricFundingRate, rate, labels)
+	return entry
+}
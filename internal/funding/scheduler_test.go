@@ -0,0 +1,214 @@
+package funding
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedRates map[string]float64
+
+func (r fixedRates) FundingRate(symbol string, _ time.Time) (float64, error) {
+	rate, ok := r[symbol]
+	if !ok {
+		return 0, fmt.Errorf("no funding rate for %s", symbol)
+	}
+	return rate, nil
+}
+
+func newMarket(t *testing.T) (*position.PositionManager, *margin.MarginSystem) {
+	pm := position.NewPositionManager([]string{"BTCUSDT"})
+	t.Cleanup(func() { _ = pm.Close() })
+	ms := margin.NewMarginSystem(pm, nil)
+
+	for _, userID := range []string{"long", "short"} {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 10000))
+	}
+	_, err := pm.OpenPosition(common.ISOLATED, "long", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "short", "BTCUSDT", position.SHORT, 50000, 1, 10)
+	require.NoError(t, err)
+	return pm, ms
+}
+
+func balance(t *testing.T, ms *margin.MarginSystem, userID string) float64 {
+	account, err := ms.GetAccount(userID)
+	require.NoError(t, err)
+	return account.Balance
+}
+
+func TestFundingSchedulerExactlyOnceAcrossRestart(t *testing.T) {
+	pm, ms := newMarket(t)
+	rates := fixedRates{"BTCUSDT": 0.0001} // 5 per interval on 50000 notional
+	clock := common.NewFakeClock(time.Date(2026, 1, 1, 7, 59, 0, 0, time.UTC))
+	config := &Config{PollInterval: time.Millisecond, SettleMissedIntervals: true}
+
+	scheduler := NewFundingScheduler(clock, nil, rates, ms, pm.GetAllSymbols, config, nil)
+	assert.Equal(t, time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC), scheduler.NextSettlement("BTCUSDT"))
+	assert.Empty(t, scheduler.RunDue())
+
+	clock.Advance(2 * time.Minute)
+	entries := scheduler.RunDue()
+	assert.Equal(t, []FundingHistory{
+		{Symbol: "BTCUSDT", Rate: 0.0001, Time: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC), TotalPaid: 5},
+	}, entries)
+	assert.Empty(t, scheduler.RunDue(), "settled once per interval")
+	assert.Equal(t, 9995.0, balance(t, ms, "long"))
+	assert.Equal(t, 10005.0, balance(t, ms, "short"))
+
+	snapshot := scheduler.Snapshot()
+	assert.Equal(t, time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC), snapshot.LastSettled["BTCUSDT"])
+
+	// restart within the same interval: nothing settled twice
+	clock.Advance(30 * time.Minute)
+	restarted := NewFundingScheduler(clock, nil, rates, ms, pm.GetAllSymbols, config, &snapshot)
+	require.NoError(t, restarted.Start())
+	require.NoError(t, restarted.Stop())
+	assert.Empty(t, restarted.History(""))
+	assert.Equal(t, 9995.0, balance(t, ms, "long"))
+
+	// down over 16:00 and 00:00, back at 01:00: both settled once on startup
+	clock.Set(time.Date(2026, 1, 2, 1, 0, 0, 0, time.UTC))
+	restarted = NewFundingScheduler(clock, nil, rates, ms, pm.GetAllSymbols, config, &snapshot)
+	require.NoError(t, restarted.Start())
+	require.NoError(t, restarted.Stop())
+
+	history := restarted.History("BTCUSDT")
+	require.Len(t, history, 2)
+	assert.Equal(t, time.Date(2026, 1, 1, 16, 0, 0, 0, time.UTC), history[0].Time)
+	assert.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), history[1].Time)
+	for _, entry := range history {
+		assert.False(t, entry.Skipped)
+		assert.Equal(t, 5.0, entry.TotalPaid)
+	}
+	assert.Empty(t, restarted.RunDue())
+	assert.Equal(t, 9985.0, balance(t, ms, "long"))
+	assert.Equal(t, 10015.0, balance(t, ms, "short"))
+}
+
+func TestFundingSchedulerSkipsMissedIntervalsWhenDisabled(t *testing.T) {
+	pm, ms := newMarket(t)
+	rates := fixedRates{"BTCUSDT": -0.0002} // shorts pay longs
+	clock := common.NewFakeClock(time.Date(2026, 1, 2, 1, 0, 0, 0, time.UTC))
+	config := &Config{PollInterval: time.Millisecond}
+
+	snapshot := &SchedulerSnapshot{LastSettled: map[string]time.Time{"BTCUSDT": time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)}}
+	scheduler := NewFundingScheduler(clock, nil, rates, ms, pm.GetAllSymbols, config, snapshot)
+
+	entries := scheduler.RunDue()
+	require.Len(t, entries, 2)
+	// recorded, not silently dropped
+	assert.True(t, entries[0].Skipped)
+	assert.Equal(t, time.Date(2026, 1, 1, 16, 0, 0, 0, time.UTC), entries[0].Time)
+	assert.False(t, entries[1].Skipped)
+	assert.Equal(t, 10.0, entries[1].TotalPaid)
+
+	assert.Equal(t, 10010.0, balance(t, ms, "long"))
+	assert.Equal(t, 9990.0, balance(t, ms, "short"))
+	assert.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), scheduler.Snapshot().LastSettled["BTCUSDT"])
+}
+
+func TestFundingSchedulerRateError(t *testing.T) {
+	pm, ms := newMarket(t)
+	clock := common.NewFakeClock(time.Date(2026, 1, 1, 7, 0, 0, 0, time.UTC))
+	scheduler := NewFundingScheduler(clock, nil, fixedRates{}, ms, pm.GetAllSymbols, nil, nil)
+	assert.Empty(t, scheduler.RunDue())
+
+	clock.Advance(time.Hour)
+	entries := scheduler.RunDue()
+	require.Len(t, entries, 1)
+	assert.Contains(t, entries[0].Error, "no funding rate")
+	assert.Equal(t, 10000.0, balance(t, ms, "long"))
+}
+
+func TestFundingSchedulerPerSymbolIntervals(t *testing.T) {
+	pm := position.NewPositionManager([]string{"BTCUSDT", "ALTUSDT"})
+	t.Cleanup(func() { _ = pm.Close() })
+	ms := margin.NewMarginSystem(pm, nil)
+	for _, userID := range []string{"long", "short"} {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 10000))
+	}
+	for _, symbol := range []string{"BTCUSDT", "ALTUSDT"} {
+		_, err := pm.OpenPosition(common.ISOLATED, "long", symbol, position.LONG, 100, 1, 10)
+		require.NoError(t, err)
+	}
+
+	clock := common.NewFakeClock(time.Date(2026, 1, 1, 7, 30, 0, 0, time.UTC))
+	registry, err := NewConfigRegistry(clock, nil)
+	require.NoError(t, err)
+	// premium 1%: the default cap clamps it to 0.75%
+	calculator := NewCalculator(registry, func(symbol string) (float64, error) { return 0.01, nil })
+
+	// volatile alt: hourly funding with a wider cap, not before the current 8h interval ends
+	effective, err := registry.Set("ALTUSDT", FundingConfig{Interval: time.Hour, RateCap: 0.02, RateFloor: -0.02})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC), effective)
+
+	scheduler := NewFundingScheduler(clock, registry, calculator, ms, pm.GetAllSymbols, nil, nil)
+	assert.Empty(t, scheduler.RunDue())
+
+	type settled struct {
+		symbol string
+		hour   int
+		rate   float64
+	}
+	collect := func(entries []FundingHistory) []settled {
+		var result []settled
+		for _, entry := range entries {
+			require.Empty(t, entry.Error)
+			result = append(result, settled{entry.Symbol, entry.Time.Hour(), entry.Rate})
+		}
+		return result
+	}
+
+	// 08:00 closes the 8h interval of both, ALT switches to hourly afterwards
+	clock.Set(time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC))
+	assert.Equal(t, []settled{{"ALTUSDT", 8, 0.0075}, {"BTCUSDT", 8, 0.0075}}, collect(scheduler.RunDue()))
+	assert.Equal(t, time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC), scheduler.NextSettlement("ALTUSDT"))
+	assert.Equal(t, time.Date(2026, 1, 1, 16, 0, 0, 0, time.UTC), scheduler.NextSettlement("BTCUSDT"))
+
+	clock.Set(time.Date(2026, 1, 1, 11, 30, 0, 0, time.UTC))
+	assert.Equal(t, []settled{{"ALTUSDT", 9, 0.01}, {"ALTUSDT", 10, 0.01}, {"ALTUSDT", 11, 0.01}}, collect(scheduler.RunDue()))
+
+	// changed mid-interval: the 11:00-12:00 interval keeps the old cap
+	effective, err = registry.Set("ALTUSDT", FundingConfig{Interval: time.Hour, RateCap: 0.005, RateFloor: -0.005})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), effective)
+
+	clock.Set(time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC))
+	assert.Equal(t, []settled{{"ALTUSDT", 12, 0.01}, {"ALTUSDT", 13, 0.005}}, collect(scheduler.RunDue()))
+
+	clock.Set(time.Date(2026, 1, 1, 16, 0, 0, 0, time.UTC))
+	assert.Equal(t, []settled{
+		{"ALTUSDT", 14, 0.005}, {"ALTUSDT", 15, 0.005}, {"ALTUSDT", 16, 0.005}, {"BTCUSDT", 16, 0.0075},
+	}, collect(scheduler.RunDue()))
+	assert.Len(t, scheduler.History("ALTUSDT"), 9)
+	assert.Len(t, scheduler.History("BTCUSDT"), 2)
+}
+
+func TestFundingConfigValidation(t *testing.T) {
+	registry, err := NewConfigRegistry(nil, nil)
+	require.NoError(t, err)
+
+	_, err = registry.Set("BTCUSDT", FundingConfig{Interval: 8 * time.Hour, RateCap: -0.01, RateFloor: 0.01})
+	assert.ErrorContains(t, err, "below floor")
+	_, err = registry.Set("BTCUSDT", FundingConfig{Interval: 7 * time.Hour, RateCap: 0.01, RateFloor: -0.01})
+	assert.ErrorContains(t, err, "must divide 24h")
+	_, err = registry.Set("BTCUSDT", FundingConfig{RateCap: 0.01, RateFloor: -0.01})
+	assert.Error(t, err)
+	_, err = NewConfigRegistry(nil, &FundingConfig{Interval: 5 * time.Hour})
+	assert.Error(t, err)
+
+	// rejected changes leave the defaults in place
+	assert.Equal(t, DefaultFundingConfig, registry.Get("BTCUSDT", time.Now()))
+}
@@ -0,0 +1,191 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/version"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCheckTimeout deadline of one contributor check when the checker timeout is 0
+const DefaultCheckTimeout = 2 * time.Second
+
+// Path where the API server serves the report
+const Path = "/healthz"
+
+// Status overall health of a Report
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded" // a non critical check failed
+	StatusDown     Status = "down"     // a critical check failed, served as 503
+)
+
+// Contributor (健康檢查) one subsystem reporting its health: nil when healthy, the reason otherwise.
+// CheckHealth must return by the ctx deadline
+type Contributor interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// ContributorFunc adapts a function, e.g. a Ping, to Contributor
+type ContributorFunc func(ctx context.Context) error
+
+func (f ContributorFunc) CheckHealth(ctx context.Context) error { return f(ctx) }
+
+// CheckResult outcome of one contributor
+type CheckResult struct {
+	Name     string `json:"name"`
+	Critical bool   `json:"critical"`
+	Healthy  bool   `json:"healthy"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report body of GET /healthz, checks in registration order. Build is the running binary's, for monitoring
+// version mismatches between nodes
+type Report struct {
+	Status    Status            `json:"status"`
+	Checks    []CheckResult     `json:"checks"`
+	Build     version.BuildInfo `json:"build"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Reason the failed critical checks, "" when none failed
+func (r Report) Reason() string {
+	var failed []string
+	for _, check := range r.Checks {
+		if check.Critical && !check.Healthy {
+			failed = append(failed, check.Name+": "+check.Error)
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+// ========================================================
+
+type registration struct {
+	name        string
+	critical    bool
+	contributor Contributor
+}
+
+// Checker runs the registered contributors, concurrently and each under its own deadline. it is the
+// http.Handler of GET /healthz
+type Checker struct {
+	timeout       time.Duration
+	registrations []registration
+	mu            sync.RWMutex
+}
+
+// NewChecker timeout 0 means DefaultCheckTimeout
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Register add contributor under name. a failing critical contributor takes the status down, a failing
+// non critical one degraded
+func (c *Checker) Register(name string, critical bool, contributor Contributor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registrations = append(c.registrations, registration{name: name, critical: critical, contributor: contributor})
+}
+
+// Check run every contributor. one not returning by the timeout fails
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.RLock()
+	registrations := c.registrations
+	c.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Checks: make([]CheckResult, len(registrations)), Build: version.Get(),
+		Timestamp: time.Now()}
+	var wg sync.WaitGroup
+	for i, r := range registrations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = CheckResult{Name: r.name, Critical: r.critical, Healthy: true}
+			if err := c.run(ctx, r.contributor); err != nil {
+				report.Checks[i].Healthy = false
+				report.Checks[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, check := range report.Checks {
+		switch {
+		case check.Healthy:
+		case check.Critical:
+			report.Status = StatusDown
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run the contributor under the check timeout, not waiting for one ignoring its deadline
+func (c *Checker) run(ctx context.Context, contributor Contributor) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() { result <- contributor.CheckHealth(ctx) }()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no answer within %s", c.timeout)
+	}
+}
+
+// ServeHTTP the report as JSON, 200 unless the status is down
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Check(r.Context())
+	status := http.StatusOK
+	if report.Status == StatusDown {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Default().Warn("write health report failed", "error", err)
+	}
+}
+
+// ========================================================
+
+// ErrUnhealthy the probed engine reported a failed critical check
+var ErrUnhealthy = errors.New("engine unhealthy")
+
+// Probe GET the report at url, e.g. http://localhost:8080/healthz. an error when the server cannot be
+// reached, answers something else than a report, or reports down (ErrUnhealthy, with the failed checks)
+func Probe(ctx context.Context, url string) (Report, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Report{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Report{}, fmt.Errorf("health endpoint unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var report Report
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil || report.Status == "" {
+		return Report{}, fmt.Errorf("health endpoint answered %s without a report", resp.Status)
+	}
+	if report.Status == StatusDown || resp.StatusCode != http.StatusOK {
+		return report, fmt.Errorf("%w: %s", ErrUnhealthy, report.Reason())
+	}
+	return report, nil
+}
@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"frizo/futures_engine/internal/version"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func healthy() Contributor { return ContributorFunc(func(context.Context) error { return nil }) }
+
+func failing(reason string) Contributor {
+	return ContributorFunc(func(context.Context) error { return errors.New(reason) })
+}
+
+func TestCheckerStatus(t *testing.T) {
+	checker := NewChecker(0)
+	checker.Register("engine", true, healthy())
+	report := checker.Check(context.Background())
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Empty(t, report.Reason())
+
+	checker.Register("snapshots", false, failing("last snapshot written 5m0s ago"))
+	report = checker.Check(context.Background())
+	assert.Equal(t, StatusDegraded, report.Status, "non critical failure")
+	assert.Empty(t, report.Reason())
+
+	checker.Register("mark_price:BTCUSDT", true, failing("mark price stale"))
+	report = checker.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, "mark_price:BTCUSDT: mark price stale", report.Reason())
+	require.Len(t, report.Checks, 3)
+	assert.Equal(t, CheckResult{Name: "engine", Critical: true, Healthy: true}, report.Checks[0], "registration order")
+}
+
+func TestCheckerTimesOutWedgedContributor(t *testing.T) {
+	wedged := make(chan struct{})
+	defer close(wedged)
+	checker := NewChecker(20 * time.Millisecond)
+	checker.Register("liquidation_loop", true, ContributorFunc(func(context.Context) error {
+		<-wedged // ignores its deadline
+		return nil
+	}))
+
+	start := time.Now()
+	report := checker.Check(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Contains(t, report.Reason(), "no answer within 20ms")
+}
+
+func TestProbe(t *testing.T) {
+	checker := NewChecker(0)
+	checker.Register("engine", true, healthy())
+	server := httptest.NewServer(checker)
+	defer server.Close()
+	ctx := context.Background()
+
+	report, err := Probe(ctx, server.URL+Path)
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, version.Get(), report.Build, "the build of the node answering")
+
+	checker.Register("mark_price:ETHUSDT", true, failing("mark price stale: last update 45s ago"))
+	resp, err := http.Get(server.URL + Path)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	report, err = Probe(ctx, server.URL+Path)
+	assert.ErrorIs(t, err, ErrUnhealthy)
+	assert.Contains(t, err.Error(), "mark_price:ETHUSDT: mark price stale")
+	assert.Equal(t, StatusDown, report.Status)
+}
+
+func TestProbeUnreachableServer(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL + Path
+	server.Close()
+
+	_, err := Probe(context.Background(), url)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "health endpoint unreachable")
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	_, err = Probe(context.Background(), notFound.URL+Path)
+	assert.ErrorContains(t, err, "answered 404 Not Found without a report")
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"context"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"time"
+)
+
+// ClosedPosition a position closed in full, by a close, a reduce of its whole size or a liquidation
+type ClosedPosition struct {
+	Sequence    uint64                `json:"sequence"`
+	PositionID  string                `json:"position_id"`
+	UserID      string                `json:"user_id"`
+	Symbol      string                `json:"symbol"`
+	Side        position.PositionSide `json:"side"`
+	ClosePrice  float64               `json:"close_price"`
+	Size        float64               `json:"size"`         // of the closing fill
+	RealizedPnL float64               `json:"realized_pnl"` // over the position's life, 0 for a liquidation
+	Liquidated  bool                  `json:"liquidated"`
+	ClosedAt    time.Time             `json:"closed_at"`
+}
+
+// Trade one match of the order books
+type Trade struct {
+	Sequence uint64 `json:"sequence"`
+	orderbook.Trade
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// LedgerEntry one balance operation of the margin system
+type LedgerEntry struct {
+	Sequence uint64 `json:"sequence"`
+	margin.MarginAuditEntry
+}
+
+// ClosedPositionWriter durable store of the closed positions. a batch may be written again after a
+// failure, rows already stored (same sequence) are skipped
+type ClosedPositionWriter interface {
+	WriteClosedPositions(ctx context.Context, positions []ClosedPosition) error
+}
+
+// TradeWriter durable store of the trades, see ClosedPositionWriter
+type TradeWriter interface {
+	WriteTrades(ctx context.Context, trades []Trade) error
+}
+
+// LedgerWriter durable store of the ledger entries, see ClosedPositionWriter
+type LedgerWriter interface {
+	WriteLedgerEntries(ctx context.Context, entries []LedgerEntry) error
+}
+
+// Reader query side of the history, newest first. limit <= 0 means DefaultQueryLimit
+type Reader interface {
+	ClosedPositions(ctx context.Context, userID string, limit int) ([]ClosedPosition, error)
+	Trades(ctx context.Context, userID string, limit int) ([]Trade, error) // as buyer or seller
+	LedgerEntries(ctx context.Context, userID string, limit int) ([]LedgerEntry, error)
+}
+
+// Sequences last sequence of every kind of record, where a restarted Recorder continues
+type Sequences struct {
+	ClosedPositions uint64 `json:"closed_positions"`
+	Trades          uint64 `json:"trades"`
+	LedgerEntries   uint64 `json:"ledger_entries"`
+}
+
+const (
+	// DefaultQueryLimit rows of a Reader query when limit is 0
+	DefaultQueryLimit = 100
+	// MaxQueryLimit most rows of a Reader query
+	MaxQueryLimit = 1000
+)
+
+// queryLimit limit clamped to (0, MaxQueryLimit]
+func queryLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultQueryLimit
+	}
+	return min(limit, MaxQueryLimit)
+}
@@ -0,0 +1,282 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"frizo/futures_engine/internal/position"
+	"strings"
+	"time"
+)
+
+// migrations schema of the history tables, in order. append only: the store records the versions applied
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS closed_positions (
+		sequence     BIGINT PRIMARY KEY,
+		position_id  TEXT NOT NULL,
+		user_id      TEXT NOT NULL,
+		symbol       TEXT NOT NULL,
+		side         SMALLINT NOT NULL, -- 1 long, -1 short
+		close_price  DOUBLE PRECISION NOT NULL,
+		size         DOUBLE PRECISION NOT NULL,
+		realized_pnl DOUBLE PRECISION NOT NULL,
+		liquidated   BOOLEAN NOT NULL,
+		closed_at    TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS closed_positions_user ON closed_positions (user_id, sequence)`,
+	`CREATE TABLE IF NOT EXISTS trades (
+		sequence       BIGINT PRIMARY KEY,
+		symbol         TEXT NOT NULL,
+		price          DOUBLE PRECISION NOT NULL,
+		size           DOUBLE PRECISION NOT NULL,
+		buy_order_id   TEXT NOT NULL,
+		buy_user_id    TEXT NOT NULL,
+		sell_order_id  TEXT NOT NULL,
+		sell_user_id   TEXT NOT NULL,
+		executed_at    TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS trades_buy_user ON trades (buy_user_id, sequence)`,
+	`CREATE INDEX IF NOT EXISTS trades_sell_user ON trades (sell_user_id, sequence)`,
+	`CREATE TABLE IF NOT EXISTS ledger_entries (
+		sequence       BIGINT PRIMARY KEY,
+		user_id        TEXT NOT NULL,
+		operation      TEXT NOT NULL,
+		amount         DOUBLE PRECISION NOT NULL,
+		balance_before DOUBLE PRECISION NOT NULL,
+		balance_after  DOUBLE PRECISION NOT NULL,
+		recorded_at    TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS ledger_entries_user ON ledger_entries (user_id, sequence)`,
+}
+
+// PostgresStore (歷史儲存) the history in PostgreSQL, through a database/sql handle opened by the caller,
+// e.g. sql.Open("postgres", url) with github.com/lib/pq. implements the three writers and Reader
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Migrate apply the migrations the database is missing, each in its own transaction. safe to run on
+// every startup
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS history_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+	var applied sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, `SELECT MAX(version) FROM history_migrations`).Scan(&applied); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	for version := int(applied.Int64) + 1; version <= len(migrations); version++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, migrations[version-1]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO history_migrations (version) VALUES ($1)`, version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) WriteClosedPositions(ctx context.Context, positions []ClosedPosition) error {
+	rows := make([][]any, len(positions))
+	for i, p := range positions {
+		rows[i] = []any{p.Sequence, p.PositionID, p.UserID, p.Symbol, int(p.Side), p.ClosePrice, p.Size, p.RealizedPnL, p.Liquidated, p.ClosedAt.UTC()}
+	}
+	return s.insert(ctx, "closed_positions",
+		"sequence, position_id, user_id, symbol, side, close_price, size, realized_pnl, liquidated, closed_at", rows)
+}
+
+func (s *PostgresStore) WriteTrades(ctx context.Context, trades []Trade) error {
+	rows := make([][]any, len(trades))
+	for i, t := range trades {
+		rows[i] = []any{t.Sequence, t.Symbol, t.Price, t.Size, t.BuyOrderID, t.BuyUserID, t.SellOrderID, t.SellUserID, t.ExecutedAt.UTC()}
+	}
+	return s.insert(ctx, "trades",
+		"sequence, symbol, price, size, buy_order_id, buy_user_id, sell_order_id, sell_user_id, executed_at", rows)
+}
+
+func (s *PostgresStore) WriteLedgerEntries(ctx context.Context, entries []LedgerEntry) error {
+	rows := make([][]any, len(entries))
+	for i, e := range entries {
+		rows[i] = []any{e.Sequence, e.UserID, e.Operation, e.Amount, e.BalanceBefore, e.BalanceAfter, e.Timestamp.UTC()}
+	}
+	return s.insert(ctx, "ledger_entries",
+		"sequence, user_id, operation, amount, balance_before, balance_after, recorded_at", rows)
+}
+
+// maxInsertParams bind parameters of one INSERT, below the limit of PostgreSQL (65535) and SQLite (32766)
+var maxInsertParams = 30000
+
+// insert write rows in multi-row INSERTs within one transaction. rows whose sequence is stored already are
+// skipped, so a batch retried after a lost commit acknowledgement is not duplicated
+func (s *PostgresStore) insert(ctx context.Context, table, columns string, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	width := len(rows[0])
+	perStatement := max(maxInsertParams/width, 1)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for start := 0; start < len(rows); start += perStatement {
+			chunk := rows[start:min(start+perStatement, len(rows))]
+
+			var query strings.Builder
+			fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", table, columns)
+			args := make([]any, 0, len(chunk)*width)
+			for i, row := range chunk {
+				if i > 0 {
+					query.WriteString(", ")
+				}
+				query.WriteString("(")
+				for j := range row {
+					if j > 0 {
+						query.WriteString(", ")
+					}
+					fmt.Fprintf(&query, "$%d", len(args)+j+1)
+				}
+				query.WriteString(")")
+				args = append(args, row...)
+			}
+			query.WriteString(" ON CONFLICT (sequence) DO NOTHING")
+
+			if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+				return fmt.Errorf("insert %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ========================================================
+
+func (s *PostgresStore) ClosedPositions(ctx context.Context, userID string, limit int) ([]ClosedPosition, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT sequence, position_id, user_id, symbol, side, close_price, size, realized_pnl, liquidated, closed_at
+		FROM closed_positions WHERE user_id = $1 ORDER BY sequence DESC LIMIT $2`, userID, queryLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("query closed positions: %w", err)
+	}
+	defer rows.Close()
+
+	positions := []ClosedPosition{}
+	for rows.Next() {
+		var p ClosedPosition
+		var side int
+		var closedAt timestamp
+		if err = rows.Scan(&p.Sequence, &p.PositionID, &p.UserID, &p.Symbol, &side, &p.ClosePrice, &p.Size, &p.RealizedPnL, &p.Liquidated, &closedAt); err != nil {
+			return nil, fmt.Errorf("scan closed position: %w", err)
+		}
+		p.Side = position.PositionSide(side)
+		p.ClosedAt = time.Time(closedAt)
+		positions = append(positions, p)
+	}
+	return positions, rows.Err()
+}
+
+func (s *PostgresStore) Trades(ctx context.Context, userID string, limit int) ([]Trade, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT sequence, symbol, price, size, buy_order_id, buy_user_id, sell_order_id, sell_user_id, executed_at
+		FROM trades WHERE buy_user_id = $1 OR sell_user_id = $1 ORDER BY sequence DESC LIMIT $2`, userID, queryLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("query trades: %w", err)
+	}
+	defer rows.Close()
+
+	trades := []Trade{}
+	for rows.Next() {
+		var t Trade
+		var executedAt timestamp
+		if err = rows.Scan(&t.Sequence, &t.Symbol, &t.Price, &t.Size, &t.BuyOrderID, &t.BuyUserID, &t.SellOrderID, &t.SellUserID, &executedAt); err != nil {
+			return nil, fmt.Errorf("scan trade: %w", err)
+		}
+		t.ExecutedAt = time.Time(executedAt)
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
+
+func (s *PostgresStore) LedgerEntries(ctx context.Context, userID string, limit int) ([]LedgerEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT sequence, user_id, operation, amount, balance_before, balance_after, recorded_at
+		FROM ledger_entries WHERE user_id = $1 ORDER BY sequence DESC LIMIT $2`, userID, queryLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("query ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		var recordedAt timestamp
+		if err = rows.Scan(&e.Sequence, &e.UserID, &e.Operation, &e.Amount, &e.BalanceBefore, &e.BalanceAfter, &recordedAt); err != nil {
+			return nil, fmt.Errorf("scan ledger entry: %w", err)
+		}
+		e.Timestamp = time.Time(recordedAt)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// LastSequences the highest stored sequence of every kind, the Config.Start of the next Recorder
+func (s *PostgresStore) LastSequences(ctx context.Context) (Sequences, error) {
+	var sequences Sequences
+	for _, last := range []struct {
+		table    string
+		sequence *uint64
+	}{
+		{"closed_positions", &sequences.ClosedPositions},
+		{"trades", &sequences.Trades},
+		{"ledger_entries", &sequences.LedgerEntries},
+	} {
+		var stored sql.NullInt64
+		if err := s.db.QueryRowContext(ctx, "SELECT MAX(sequence) FROM "+last.table).Scan(&stored); err != nil {
+			return Sequences{}, fmt.Errorf("last sequence of %s: %w", last.table, err)
+		}
+		*last.sequence = uint64(stored.Int64)
+	}
+	return sequences, nil
+}
+
+// timestamp scans a time column of drivers returning time.Time as well as of those returning text
+type timestamp time.Time
+
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999 -0700 MST"}
+
+func (t *timestamp) Scan(src any) error {
+	var text string
+	switch v := src.(type) {
+	case time.Time:
+		*t = timestamp(v)
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("unsupported time value %T", src)
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			*t = timestamp(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported time value %q", text)
+}
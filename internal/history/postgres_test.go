@@ -0,0 +1,148 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+// newTestStore the store over an in-memory SQLite database, which takes the same SQL
+func newTestStore(t *testing.T) *PostgresStore {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1) // one connection, one in-memory database
+	t.Cleanup(func() { _ = db.Close() })
+
+	store := NewPostgresStore(db)
+	require.NoError(t, store.Migrate(context.Background()))
+	return store
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	store := newTestStore(t)
+	require.NoError(t, store.Migrate(context.Background()))
+
+	var version int
+	require.NoError(t, store.db.QueryRow(`SELECT MAX(version) FROM history_migrations`).Scan(&version))
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestPostgresStoreWritesAndQueries(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	closedAt := time.Date(2024, 5, 1, 12, 0, 0, 123000, time.UTC)
+
+	var positions []ClosedPosition
+	for i := 1; i <= 5; i++ {
+		positions = append(positions, ClosedPosition{Sequence: uint64(i), PositionID: "p", UserID: "alice", Symbol: "BTCUSDT",
+			Side: position.SHORT, ClosePrice: 50000, Size: float64(i), RealizedPnL: -3, Liquidated: i == 5, ClosedAt: closedAt})
+	}
+	positions[2].UserID = "bob"
+	require.NoError(t, store.WriteClosedPositions(ctx, positions))
+	require.NoError(t, store.WriteClosedPositions(ctx, positions[3:]), "rewritten batch skipped")
+
+	got, err := store.ClosedPositions(ctx, "alice", 0)
+	require.NoError(t, err)
+	require.Len(t, got, 4)
+	assert.Equal(t, []uint64{5, 4, 2, 1}, []uint64{got[0].Sequence, got[1].Sequence, got[2].Sequence, got[3].Sequence}, "newest first")
+	assert.Equal(t, positions[4], ClosedPosition{Sequence: got[0].Sequence, PositionID: got[0].PositionID, UserID: got[0].UserID, Symbol: got[0].Symbol,
+		Side: got[0].Side, ClosePrice: got[0].ClosePrice, Size: got[0].Size, RealizedPnL: got[0].RealizedPnL, Liquidated: got[0].Liquidated, ClosedAt: got[0].ClosedAt.UTC()})
+
+	got, err = store.ClosedPositions(ctx, "alice", 2)
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+
+	require.NoError(t, store.WriteTrades(ctx, []Trade{
+		{Sequence: 1, Trade: orderbook.Trade{Symbol: "BTCUSDT", Price: 1, Size: 1, BuyUserID: "alice", SellUserID: "bob"}, ExecutedAt: closedAt},
+		{Sequence: 2, Trade: orderbook.Trade{Symbol: "BTCUSDT", Price: 2, Size: 1, BuyUserID: "bob", SellUserID: "carol"}, ExecutedAt: closedAt},
+		{Sequence: 3, Trade: orderbook.Trade{Symbol: "BTCUSDT", Price: 3, Size: 1, BuyUserID: "carol", SellUserID: "alice"}, ExecutedAt: closedAt},
+	}))
+	trades, err := store.Trades(ctx, "alice", 10)
+	require.NoError(t, err)
+	require.Len(t, trades, 2, "as buyer and as seller")
+	assert.Equal(t, 3.0, trades[0].Price)
+	assert.True(t, closedAt.Equal(trades[0].ExecutedAt))
+
+	require.NoError(t, store.WriteLedgerEntries(ctx, []LedgerEntry{
+		{Sequence: 7, MarginAuditEntry: margin.MarginAuditEntry{Timestamp: closedAt, UserID: "alice", Operation: margin.AuditDeposit, Amount: 10, BalanceAfter: 10}},
+	}))
+	entries, err := store.LedgerEntries(ctx, "alice", 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, margin.AuditDeposit, entries[0].Operation)
+	assert.Equal(t, 10.0, entries[0].BalanceAfter)
+
+	last, err := store.LastSequences(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Sequences{ClosedPositions: 5, Trades: 3, LedgerEntries: 7}, last)
+}
+
+func TestPostgresStoreBatchSpansStatements(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	defer func(limit int) { maxInsertParams = limit }(maxInsertParams)
+	maxInsertParams = 20 // two rows a statement
+
+	trades := make([]Trade, 5)
+	for i := range trades {
+		trades[i] = Trade{Sequence: uint64(i + 1), Trade: orderbook.Trade{BuyUserID: "alice"}, ExecutedAt: time.Now()}
+	}
+	require.NoError(t, store.WriteTrades(ctx, trades))
+	last, err := store.LastSequences(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), last.Trades)
+	got, err := store.Trades(ctx, "alice", 0)
+	require.NoError(t, err)
+	assert.Len(t, got, 5)
+}
+
+// flakyStore fails the first insert, as a dropped connection would
+type flakyStore struct {
+	*PostgresStore
+	failed bool
+}
+
+func (s *flakyStore) WriteTrades(ctx context.Context, trades []Trade) error {
+	if !s.failed {
+		s.failed = true
+		// the first row made it in before the failure, the retry must not duplicate it
+		if err := s.PostgresStore.WriteTrades(ctx, trades[:1]); err != nil {
+			return err
+		}
+		return assert.AnError
+	}
+	return s.PostgresStore.WriteTrades(ctx, trades)
+}
+
+func TestRecorderRetriesIntoStore(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	require.NoError(t, store.WriteTrades(ctx, []Trade{{Sequence: 1, Trade: orderbook.Trade{BuyUserID: "alice", Price: 99}, ExecutedAt: time.Now()}}))
+
+	start, err := store.LastSequences(ctx)
+	require.NoError(t, err)
+	flaky := &flakyStore{PostgresStore: store}
+	r := NewRecorder(store, flaky, store, &Config{BatchSize: 3, RetryBackoff: time.Millisecond, Start: start})
+	for i := 0; i < 3; i++ {
+		r.RecordTrade(orderbook.Trade{BuyUserID: "alice", Price: float64(100 + i)})
+	}
+	require.NoError(t, r.Close(ctx))
+	assert.Zero(t, r.Dropped())
+
+	trades, err := store.Trades(ctx, "alice", 0)
+	require.NoError(t, err)
+	require.Len(t, trades, 4)
+	for i, trade := range trades {
+		assert.Equal(t, uint64(4-i), trade.Sequence)
+		assert.Equal(t, float64(103-i)-1, trade.Price, "continued after the stored sequence, in recording order")
+	}
+}
@@ -0,0 +1,298 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultBufferSize records of one kind waiting to be written when Config.BufferSize is 0
+	DefaultBufferSize = 4096
+	// DefaultBatchSize most records of one write when Config.BatchSize is 0
+	DefaultBatchSize = 500
+	// DefaultFlushInterval longest wait of a record for its batch when Config.FlushInterval is 0
+	DefaultFlushInterval = time.Second
+	// DefaultMaxRetries attempts of a failed write when Config.MaxRetries is 0
+	DefaultMaxRetries = 5
+	// DefaultRetryBackoff wait before the first retry when Config.RetryBackoff is 0, doubled at every retry
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// ErrRecorderClosed the recorder was closed
+var ErrRecorderClosed = errors.New("history recorder closed")
+
+// Config nil means the defaults
+type Config struct {
+	BufferSize    int           // 0 means DefaultBufferSize
+	BatchSize     int           // 0 means DefaultBatchSize
+	FlushInterval time.Duration // 0 means DefaultFlushInterval
+	MaxRetries    int           // 0 means DefaultMaxRetries
+	RetryBackoff  time.Duration // 0 means DefaultRetryBackoff
+	Start         Sequences     // continue after these, e.g. the LastSequences of the store
+}
+
+// Recorder (歷史記錄器) hands closed positions, trades and ledger entries to their writers off the hot
+// path: Record* only queue the record, one goroutine per kind numbers the records in queue order and
+// writes them in batches, retrying a failed batch. a full queue drops the record, a batch still failing
+// after the retries is dropped too, both counted in Dropped
+type Recorder struct {
+	position.NoopPositionEventHandler
+
+	closed *queue[ClosedPosition]
+	trades *queue[Trade]
+	ledger *queue[LedgerEntry]
+}
+
+// NewRecorder start the writing goroutines, until Close. a nil writer discards its kind
+func NewRecorder(closed ClosedPositionWriter, trades TradeWriter, ledger LedgerWriter, config *Config) *Recorder {
+	if config == nil {
+		config = &Config{}
+	}
+	c := *config
+	if c.BufferSize <= 0 {
+		c.BufferSize = DefaultBufferSize
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = DefaultFlushInterval
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = DefaultRetryBackoff
+	}
+
+	r := &Recorder{}
+	var writeClosed func(context.Context, []ClosedPosition) error
+	if closed != nil {
+		writeClosed = closed.WriteClosedPositions
+	}
+	r.closed = newQueue("closed_positions", c, c.Start.ClosedPositions, writeClosed,
+		func(p *ClosedPosition, sequence uint64) { p.Sequence = sequence })
+	var writeTrades func(context.Context, []Trade) error
+	if trades != nil {
+		writeTrades = trades.WriteTrades
+	}
+	r.trades = newQueue("trades", c, c.Start.Trades, writeTrades,
+		func(t *Trade, sequence uint64) { t.Sequence = sequence })
+	var writeLedger func(context.Context, []LedgerEntry) error
+	if ledger != nil {
+		writeLedger = ledger.WriteLedgerEntries
+	}
+	r.ledger = newQueue("ledger_entries", c, c.Start.LedgerEntries, writeLedger,
+		func(e *LedgerEntry, sequence uint64) { e.Sequence = sequence })
+	return r
+}
+
+// RecordClosedPosition queue a closed position, false when dropped
+func (r *Recorder) RecordClosedPosition(p ClosedPosition) bool {
+	return r.closed.push(p)
+}
+
+// RecordTrade queue a trade executed now, false when dropped. implements api.TradeRecorder
+func (r *Recorder) RecordTrade(trade orderbook.Trade) bool {
+	return r.trades.push(Trade{Trade: trade, ExecutedAt: time.Now()})
+}
+
+// RecordLedgerEntry queue a balance operation, false when dropped. failed operations are not part
+// of the ledger and are skipped. fits margin.MarginSystem.OnAuditEntry
+func (r *Recorder) RecordLedgerEntry(entry margin.MarginAuditEntry) bool {
+	if entry.Error != "" {
+		return false
+	}
+	return r.ledger.push(LedgerEntry{MarginAuditEntry: entry})
+}
+
+// HandlePositionClosed implements position.PositionEventHandler, see position.SubscribeHandler
+func (r *Recorder) HandlePositionClosed(event *position.PositionClosedEvent) error {
+	r.RecordClosedPosition(closedPosition(event.PositionChange, event.RealizedPnL, false))
+	return nil
+}
+
+// HandlePositionLiquidated implements position.PositionEventHandler: the fill liquidating the rest of
+// the position closes it
+func (r *Recorder) HandlePositionLiquidated(event *position.PositionLiquidatedEvent) error {
+	if event.RemainingSize <= 0 {
+		r.RecordClosedPosition(closedPosition(event.PositionChange, 0, true))
+	}
+	return nil
+}
+
+func closedPosition(change position.PositionChange, realizedPnL float64, liquidated bool) ClosedPosition {
+	return ClosedPosition{
+		PositionID:  change.PositionID,
+		UserID:      change.UserID,
+		Symbol:      change.Symbol,
+		Side:        change.Side,
+		ClosePrice:  change.Price,
+		Size:        change.Size,
+		RealizedPnL: realizedPnL,
+		Liquidated:  liquidated,
+		ClosedAt:    change.Timestamp,
+	}
+}
+
+// Sequences last sequence given to every kind of record
+func (r *Recorder) Sequences() Sequences {
+	return Sequences{
+		ClosedPositions: r.closed.sequence.Load(),
+		Trades:          r.trades.sequence.Load(),
+		LedgerEntries:   r.ledger.sequence.Load(),
+	}
+}
+
+// Dropped records lost to a full queue or a write failing after the retries, of every kind
+func (r *Recorder) Dropped() uint64 {
+	return r.closed.dropped.Load() + r.trades.dropped.Load() + r.ledger.dropped.Load()
+}
+
+// Close stop taking records and write the queued ones, retries included, until ctx is done
+func (r *Recorder) Close(ctx context.Context) error {
+	return errors.Join(r.closed.close(ctx), r.trades.close(ctx), r.ledger.close(ctx))
+}
+
+// ========================================================
+
+// queue bounded buffer of one kind of record and its writing goroutine
+type queue[T any] struct {
+	name        string
+	config      Config
+	records     chan T
+	write       func(context.Context, []T) error // nil discards
+	setSequence func(*T, uint64)
+
+	sequence atomic.Uint64 // last given
+	dropped  atomic.Uint64
+
+	closing bool
+	mu      sync.RWMutex // guards closing against push
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+func newQueue[T any](name string, config Config, start uint64, write func(context.Context, []T) error, setSequence func(*T, uint64)) *queue[T] {
+	q := &queue[T]{
+		name:        name,
+		config:      config,
+		records:     make(chan T, config.BufferSize),
+		write:       write,
+		setSequence: setSequence,
+		done:        make(chan struct{}),
+	}
+	q.sequence.Store(start)
+	ctx, cancel := context.WithCancel(context.Background())
+	q.stop = cancel
+	go q.run(ctx)
+	return q
+}
+
+func (q *queue[T]) push(record T) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closing {
+		q.dropped.Add(1)
+		return false
+	}
+	select {
+	case q.records <- record:
+		return true
+	default:
+		q.dropped.Add(1)
+		return false
+	}
+}
+
+// run write batches of up to BatchSize records, a partial batch once its first record waited FlushInterval.
+// ends once the records channel is closed and drained
+func (q *queue[T]) run(ctx context.Context) {
+	defer close(q.done)
+
+	batch := make([]T, 0, q.config.BatchSize)
+	for {
+		record, ok := <-q.records
+		if !ok {
+			return
+		}
+		batch = append(batch[:0], record)
+		deadline := time.NewTimer(q.config.FlushInterval)
+	fill:
+		for len(batch) < q.config.BatchSize {
+			select {
+			case record, ok = <-q.records:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, record)
+			case <-deadline.C:
+				break fill
+			}
+		}
+		deadline.Stop()
+		q.flush(ctx, batch)
+		if !ok {
+			return
+		}
+	}
+}
+
+// flush number the batch and write it, retrying with a doubling backoff
+func (q *queue[T]) flush(ctx context.Context, batch []T) {
+	for i := range batch {
+		q.setSequence(&batch[i], q.sequence.Add(1))
+	}
+	if q.write == nil {
+		return
+	}
+
+	backoff := q.config.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= q.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				attempt = q.config.MaxRetries // give up, but count the drop
+				continue
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = q.write(ctx, batch); err == nil {
+			return
+		}
+	}
+	q.dropped.Add(uint64(len(batch)))
+	logger.Default().Error("history write failed, batch dropped", "kind", q.name, "records", len(batch), "error", err)
+}
+
+// close stop taking records, let run drain the buffer, give up the retries when ctx is done
+func (q *queue[T]) close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closing {
+		q.mu.Unlock()
+		return ErrRecorderClosed
+	}
+	q.closing = true
+	close(q.records)
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		q.stop()
+		return nil
+	case <-ctx.Done():
+		q.stop()
+		<-q.done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,180 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWriter keeps the written batches, failing the next `failures` writes
+type fakeWriter struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	closed   [][]ClosedPosition
+	trades   [][]Trade
+	ledger   [][]LedgerEntry
+}
+
+func (w *fakeWriter) fail() error {
+	w.attempts++
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (w *fakeWriter) WriteClosedPositions(ctx context.Context, positions []ClosedPosition) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.fail(); err != nil {
+		return err
+	}
+	w.closed = append(w.closed, append([]ClosedPosition(nil), positions...))
+	return nil
+}
+
+func (w *fakeWriter) WriteTrades(ctx context.Context, trades []Trade) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.fail(); err != nil {
+		return err
+	}
+	w.trades = append(w.trades, append([]Trade(nil), trades...))
+	return nil
+}
+
+func (w *fakeWriter) WriteLedgerEntries(ctx context.Context, entries []LedgerEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.fail(); err != nil {
+		return err
+	}
+	w.ledger = append(w.ledger, append([]LedgerEntry(nil), entries...))
+	return nil
+}
+
+func newTestRecorder(w *fakeWriter, config Config) *Recorder {
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = time.Millisecond
+	}
+	return NewRecorder(w, w, w, &config)
+}
+
+func TestRecorderBatchesInSequence(t *testing.T) {
+	w := &fakeWriter{}
+	r := newTestRecorder(w, Config{BatchSize: 4, FlushInterval: time.Hour, Start: Sequences{Trades: 10}})
+
+	for i := 0; i < 10; i++ {
+		require.True(t, r.RecordTrade(orderbook.Trade{Symbol: "BTCUSDT", Price: float64(100 + i), Size: 1}))
+	}
+	require.NoError(t, r.Close(context.Background()))
+
+	require.Len(t, w.trades, 3, "two full batches, the rest flushed on close")
+	assert.Len(t, w.trades[0], 4)
+	assert.Len(t, w.trades[2], 2)
+	var sequence uint64 = 10
+	for _, batch := range w.trades {
+		for _, trade := range batch {
+			sequence++
+			assert.Equal(t, sequence, trade.Sequence, "numbered after Start in recording order")
+			assert.Equal(t, float64(100+sequence-11), trade.Price)
+		}
+	}
+	assert.Equal(t, Sequences{Trades: 20}, r.Sequences())
+	assert.False(t, r.RecordTrade(orderbook.Trade{}), "closed")
+}
+
+func TestRecorderFlushesPartialBatch(t *testing.T) {
+	w := &fakeWriter{}
+	r := newTestRecorder(w, Config{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer r.Close(context.Background())
+
+	r.RecordLedgerEntry(margin.MarginAuditEntry{UserID: "alice", Operation: margin.AuditDeposit, Amount: 10})
+	assert.False(t, r.RecordLedgerEntry(margin.MarginAuditEntry{UserID: "alice", Error: "insufficient"}), "failed operations skipped")
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return len(w.ledger) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(1), w.ledger[0][0].Sequence)
+}
+
+func TestRecorderRetriesFailedWrite(t *testing.T) {
+	w := &fakeWriter{failures: 2}
+	r := newTestRecorder(w, Config{BatchSize: 2, MaxRetries: 3})
+
+	r.RecordClosedPosition(ClosedPosition{PositionID: "p1"})
+	r.RecordClosedPosition(ClosedPosition{PositionID: "p2"})
+	require.NoError(t, r.Close(context.Background()))
+
+	assert.Equal(t, 3, w.attempts)
+	require.Len(t, w.closed, 1)
+	assert.Equal(t, []uint64{1, 2}, []uint64{w.closed[0][0].Sequence, w.closed[0][1].Sequence}, "same sequences on retry")
+	assert.Zero(t, r.Dropped())
+}
+
+func TestRecorderDropsAfterRetries(t *testing.T) {
+	w := &fakeWriter{failures: 3}
+	r := newTestRecorder(w, Config{BatchSize: 1, MaxRetries: 2})
+
+	r.RecordClosedPosition(ClosedPosition{PositionID: "lost"})
+	r.RecordClosedPosition(ClosedPosition{PositionID: "kept"})
+	require.NoError(t, r.Close(context.Background()))
+
+	assert.Equal(t, uint64(1), r.Dropped())
+	require.Len(t, w.closed, 1)
+	assert.Equal(t, "kept", w.closed[0][0].PositionID)
+	assert.Equal(t, uint64(2), w.closed[0][0].Sequence, "the dropped record keeps its sequence")
+}
+
+func TestRecorderDropsWhenFull(t *testing.T) {
+	w := &fakeWriter{}
+	w.mu.Lock() // stall the writer
+	r := newTestRecorder(w, Config{BufferSize: 2, BatchSize: 1})
+
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if r.RecordTrade(orderbook.Trade{}) {
+			accepted++
+		}
+	}
+	assert.LessOrEqual(t, accepted, 3, "buffer plus the batch being written")
+	assert.Equal(t, uint64(10-accepted), r.Dropped())
+
+	w.mu.Unlock()
+	require.NoError(t, r.Close(context.Background()))
+	assert.Len(t, w.trades, accepted)
+}
+
+func TestRecorderPositionEvents(t *testing.T) {
+	w := &fakeWriter{}
+	r := newTestRecorder(w, Config{})
+	now := time.Now()
+	change := position.PositionChange{PositionID: "p1", UserID: "alice", Symbol: "BTCUSDT", Side: position.LONG, Price: 50000, Size: 1, Timestamp: now}
+
+	require.NoError(t, r.HandlePositionClosed(&position.PositionClosedEvent{PositionChange: change, RealizedPnL: 120}))
+	require.NoError(t, r.HandlePositionLiquidated(&position.PositionLiquidatedEvent{PositionChange: change, RemainingSize: 0.5}))
+	change.PositionID = "p2"
+	require.NoError(t, r.HandlePositionLiquidated(&position.PositionLiquidatedEvent{PositionChange: change}))
+	require.NoError(t, r.Close(context.Background()))
+
+	var closed []ClosedPosition
+	for _, batch := range w.closed {
+		closed = append(closed, batch...)
+	}
+	require.Len(t, closed, 2, "partial liquidation is not a close")
+	assert.Equal(t, ClosedPosition{Sequence: 1, PositionID: "p1", UserID: "alice", Symbol: "BTCUSDT", Side: position.LONG,
+		ClosePrice: 50000, Size: 1, RealizedPnL: 120, ClosedAt: now}, closed[0])
+	assert.True(t, closed[1].Liquidated)
+	assert.Equal(t, "p2", closed[1].PositionID)
+}
@@ -0,0 +1,181 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"time"
+)
+
+// ADLEntry one counterparty reduced by auto-deleveraging
+type ADLEntry struct {
+	PositionID     string                `json:"position_id"`
+	UserID         string                `json:"user_id"`
+	Side           position.PositionSide `json:"side"`
+	Score          float64               `json:"score"`
+	Size           float64               `json:"size"` // deleveraged size
+	Price          float64               `json:"price"`
+	PnL            float64               `json:"pnl"`
+	MarginReleased float64               `json:"margin_released"`
+	Covered        float64               `json:"covered"` // part of the shortfall absorbed by this counterparty
+	RemainingSize  float64               `json:"remaining_size"`
+	Error          string                `json:"error,omitempty"`
+}
+
+// ADLReport (自動減倉報告) deleveraging triggered by one liquidation
+type ADLReport struct {
+	ID                   string                `json:"id"`
+	LiquidatedPositionID string                `json:"liquidated_position_id"`
+	LiquidatedUserID     string                `json:"liquidated_user_id"`
+	Symbol               string                `json:"symbol"`
+	Side                 position.PositionSide `json:"side"` // side of the deleveraged counterparties
+	BankruptcyPrice      float64               `json:"bankruptcy_price"`
+	ClosePrice           float64               `json:"close_price"`
+	Shortfall            float64               `json:"shortfall"` // left after the insurance fund
+	Covered              float64               `json:"covered"`
+	Uncovered            float64               `json:"uncovered"` // ADL queue exhausted
+	Entries              []ADLEntry            `json:"entries"`
+	Timestamp            time.Time             `json:"timestamp"`
+}
+
+// ADLEvent pushed to every deleveraged user
+type ADLEvent struct {
+	ReportID      string                `json:"report_id"`
+	UserID        string                `json:"user_id"`
+	PositionID    string                `json:"position_id"`
+	Symbol        string                `json:"symbol"`
+	Side          position.PositionSide `json:"side"`
+	Size          float64               `json:"size"`
+	Price         float64               `json:"price"`
+	PnL           float64               `json:"pnl"`
+	RemainingSize float64               `json:"remaining_size"`
+	Timestamp     time.Time             `json:"timestamp"`
+}
+
+// ADLHandler called for every deleveraged counterparty, outside the engine lock
+type ADLHandler func(event ADLEvent)
+
+type deleverageFunc func(userID, symbol string, side position.PositionSide, price, size, fee float64) (float64, float64, error)
+
+func (e *LiquidationEngine) OnADL(handler ADLHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onADL = handler
+}
+
+// ADLReports the last MaxResults deleveraging reports, oldest first
+func (e *LiquidationEngine) ADLReports() []ADLReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.adlReports.Items()
+}
+
+// autoDeleverage (自動減倉) reduce opposite positions from the ADL queue at the bankruptcy price.
+// closing a counterparty there instead of at closePrice gives up |bankruptcy - close| per unit,
+// which pays the shortfall the insurance fund could not. the last counterparty is reduced by exactly
+// the size still needed.
+func (e *LiquidationEngine) autoDeleverage(liquidated position.PositionSnapshot, bankruptcyPrice, closePrice, shortfall float64) *ADLReport {
+	gap := bankruptcyPrice - closePrice
+	opposite := position.SHORT
+	if liquidated.Side == position.SHORT {
+		gap = -gap
+		opposite = position.LONG
+	}
+	if gap <= 0 || shortfall <= 0 {
+		return nil
+	}
+
+	report := ADLReport{
+		ID:                   common.GenerateShortUUID("adl"),
+		LiquidatedPositionID: liquidated.ID,
+		LiquidatedUserID:     liquidated.UserID,
+		Symbol:               liquidated.Symbol,
+		Side:                 opposite,
+		BankruptcyPrice:      bankruptcyPrice,
+		ClosePrice:           closePrice,
+		Shortfall:            shortfall,
+		Entries:              make([]ADLEntry, 0),
+	}
+
+	queue, _ := e.positionMgr.GetADLQueue(liquidated.Symbol, opposite)
+	remaining := shortfall
+	for _, candidate := range queue {
+		if remaining <= 0 {
+			break
+		}
+		if candidate.UserID == liquidated.UserID {
+			continue
+		}
+
+		entry := ADLEntry{
+			PositionID: candidate.PositionID,
+			UserID:     candidate.UserID,
+			Side:       candidate.Side,
+			Score:      candidate.Score,
+			Size:       candidate.Size,
+			Price:      bankruptcyPrice,
+			Covered:    candidate.Size * gap,
+		}
+		if entry.Covered >= remaining {
+			entry.Size = min(remaining/gap, candidate.Size)
+			entry.Covered = remaining
+		}
+
+		marginReleased, pnl, err := e.deleverage(candidate.UserID, liquidated.Symbol, opposite, bankruptcyPrice, entry.Size, 0)
+		if err != nil {
+			// skipped, the next counterparty takes over
+			entry.Size, entry.Covered, entry.Error = 0, 0, err.Error()
+			report.Entries = append(report.Entries, entry)
+			continue
+		}
+		entry.PnL, entry.MarginReleased = pnl, marginReleased
+		entry.RemainingSize = candidate.Position.Snapshot().Size
+		remaining -= entry.Covered
+		report.Covered += entry.Covered
+		report.Entries = append(report.Entries, entry)
+	}
+	report.Uncovered = max(remaining, 0)
+	report.Timestamp = time.Now()
+
+	e.mu.Lock()
+	e.adlReports.Add(report)
+	handler := e.onADL
+	e.mu.Unlock()
+
+	if handler != nil {
+		for _, entry := range report.Entries {
+			if entry.Error != "" {
+				continue
+			}
+			handler(ADLEvent{
+				ReportID:      report.ID,
+				UserID:        entry.UserID,
+				PositionID:    entry.PositionID,
+				Symbol:        report.Symbol,
+				Side:          entry.Side,
+				Size:          entry.Size,
+				Price:         entry.Price,
+				PnL:           entry.PnL,
+				RemainingSize: entry.RemainingSize,
+				Timestamp:     report.Timestamp,
+			})
+		}
+	}
+	return &report
+}
+
+// socializeLoss (穿倉分攤) ShortfallSocialized: haircut the profit of the opposite side pro-rata
+// instead of deleveraging it, charged at each position's next settlement
+func (e *LiquidationEngine) socializeLoss(liquidated position.PositionSnapshot, result *LiquidationResult) {
+	opposite := position.SHORT
+	if liquidated.Side == position.SHORT {
+		opposite = position.LONG
+	}
+
+	loss, err := e.socialize(liquidated.ID, liquidated.Symbol, opposite, result.Settlement.Uncovered)
+	if err != nil {
+		result.Error = fmt.Sprintf("socialize loss: %v", err)
+		return
+	}
+	result.SocializedLoss = &loss
+}
@@ -0,0 +1,176 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"time"
+)
+
+// AuditPhase write-ahead phase of an audit entry
+type AuditPhase string
+
+const (
+	AuditPrepared  AuditPhase = "PREPARED"  // closed, about to settle
+	AuditCommitted AuditPhase = "COMMITTED" // settled
+	AuditAborted   AuditPhase = "ABORTED"   // settlement gave up, nothing settled
+)
+
+// Fill sources of LiquidationFill
+const (
+	FillSourceBook          = "order_book"
+	FillSourceInsuranceFund = "insurance_fund" // takeover at the bankruptcy price
+	FillSourceMarkPrice     = "mark_price"     // takeover without book liquidity
+	FillSourceBackstop      = "backstop"       // takeover by a backstop provider
+)
+
+// LiquidationFill one execution of a liquidation
+type LiquidationFill struct {
+	Source       string  `json:"source"`
+	Price        float64 `json:"price"`
+	Size         float64 `json:"size"`
+	OrderID      string  `json:"order_id,omitempty"` // counterparty order in the book
+	Counterparty string  `json:"counterparty,omitempty"`
+}
+
+// AuditEntry (強平稽核) everything needed to reconstruct one liquidation
+type AuditEntry struct {
+	Sequence      uint64     `json:"sequence"` // assigned by the store
+	LiquidationID string     `json:"liquidation_id"`
+	Phase         AuditPhase `json:"phase"`
+
+	UserID         string                    `json:"user_id"`
+	Symbol         string                    `json:"symbol"`
+	PositionBefore position.PositionSnapshot `json:"position_before"`
+
+	Fills          []LiquidationFill `json:"fills"`
+	Size           float64           `json:"size"`
+	ClosePrice     float64           `json:"close_price"`
+	PnL            float64           `json:"pnl"`
+	MarginReleased float64           `json:"margin_released"`
+
+	// committed only
+	Settlement         margin.LiquidationSettlement `json:"settlement"`
+	InsuranceFundDelta float64                      `json:"insurance_fund_delta"` // deposit minus cover
+	AccountAfter       *margin.AccountSnapshot      `json:"account_after,omitempty"`
+	ADLReportID        string                       `json:"adl_report_id,omitempty"`
+
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AuditStore append-only persistence of audit entries. a liquidation is never settled
+// before its PREPARED entry was appended.
+type AuditStore interface {
+	Append(entry AuditEntry) error
+}
+
+// AuditQuery zero fields match everything, From inclusive and To exclusive
+type AuditQuery struct {
+	UserID string
+	Symbol string
+	From   time.Time
+	To     time.Time
+	Phase  AuditPhase
+}
+
+func (q AuditQuery) match(entry AuditEntry) bool {
+	return (q.UserID == "" || entry.UserID == q.UserID) &&
+		(q.Symbol == "" || entry.Symbol == q.Symbol) &&
+		(q.From.IsZero() || !entry.Timestamp.Before(q.From)) &&
+		(q.To.IsZero() || entry.Timestamp.Before(q.To)) &&
+		(q.Phase == "" || entry.Phase == q.Phase)
+}
+
+// MemoryAuditStore in-memory AuditStore
+type MemoryAuditStore struct {
+	entries []AuditEntry
+	latest  map[string]int // liquidation id -> index of its latest entry
+	order   []string       // liquidation ids, first appearance
+	mu      sync.RWMutex
+}
+
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{latest: make(map[string]int)}
+}
+
+func (s *MemoryAuditStore) Append(entry AuditEntry) error {
+	if entry.LiquidationID == "" {
+		return fmt.Errorf("audit entry without liquidation id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.latest[entry.LiquidationID]; !exists {
+		if entry.Phase != AuditPrepared {
+			return fmt.Errorf("liquidation %s not prepared", entry.LiquidationID)
+		}
+		s.order = append(s.order, entry.LiquidationID)
+	}
+	entry.Sequence = uint64(len(s.entries) + 1)
+	entry.Fills = append([]LiquidationFill(nil), entry.Fills...)
+	s.entries = append(s.entries, entry)
+	s.latest[entry.LiquidationID] = len(s.entries) - 1
+	return nil
+}
+
+// Query latest entry of every matching liquidation, oldest liquidation first
+func (s *MemoryAuditStore) Query(query AuditQuery) []AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []AuditEntry
+	for _, id := range s.order {
+		if entry := s.entries[s.latest[id]]; query.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// History every entry of one liquidation in append order
+func (s *MemoryAuditStore) History(liquidationID string) []AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []AuditEntry
+	for _, entry := range s.entries {
+		if entry.LiquidationID == liquidationID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// SetAuditStore replace the default MemoryAuditStore
+func (e *LiquidationEngine) SetAuditStore(store AuditStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.audit = store
+}
+
+func (e *LiquidationEngine) auditStore() AuditStore {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.audit
+}
+
+// auditEntry entry of the result in the given phase
+func auditEntry(liquidationID string, phase AuditPhase, before position.PositionSnapshot, result *LiquidationResult) AuditEntry {
+	return AuditEntry{
+		LiquidationID:  liquidationID,
+		Phase:          phase,
+		UserID:         before.UserID,
+		Symbol:         before.Symbol,
+		PositionBefore: before,
+		Fills:          result.Fills,
+		Size:           result.Size,
+		ClosePrice:     result.ClosePrice,
+		PnL:            result.PnL,
+		MarginReleased: result.MarginReleased,
+		Error:          result.Error,
+		Timestamp:      time.Now(),
+	}
+}
@@ -0,0 +1,222 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyAuditStore loses appends at random, like a crashed writer
+type flakyAuditStore struct {
+	*MemoryAuditStore
+	rng  *rand.Rand
+	rate float64
+	mu   sync.Mutex
+}
+
+func (s *flakyAuditStore) Append(entry AuditEntry) error {
+	s.mu.Lock()
+	fail := s.rng.Float64() < s.rate
+	s.mu.Unlock()
+
+	if fail {
+		return fmt.Errorf("audit log unavailable")
+	}
+	return s.MemoryAuditStore.Append(entry)
+}
+
+func TestAuditEntryReconstructsLiquidation(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, nil)
+	store := NewMemoryAuditStore()
+	engine.SetAuditStore(store)
+
+	book := orderbook.NewOrderBook("BTCUSDT")
+	_, err := book.PlaceOrder(&orderbook.Order{ID: "bid1", UserID: "mm", Side: orderbook.BUY, Price: 45150, Size: 0.3})
+	require.NoError(t, err)
+	engine.SetOrderBook("BTCUSDT", book)
+
+	before := time.Now()
+	pos := openLong(t, pm, ms, "user1", 1, 10)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	result := results[0]
+	require.NotEmpty(t, result.AuditID)
+
+	entries := store.Query(AuditQuery{UserID: "user1"})
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, result.AuditID, entry.LiquidationID)
+	assert.Equal(t, AuditCommitted, entry.Phase)
+
+	// before: the open position at the liquidation mark price
+	assert.Equal(t, pos.ID, entry.PositionBefore.ID)
+	assert.Equal(t, 1.0, entry.PositionBefore.Size)
+	assert.Equal(t, 45100.0, entry.PositionBefore.MarkPrice)
+
+	// executions: 0.3 in the book, 0.7 taken over by the fund at the bankruptcy price
+	assert.Equal(t, []LiquidationFill{
+		{Source: FillSourceBook, Price: 45150, Size: 0.3, OrderID: "bid1", Counterparty: "mm"},
+		{Source: FillSourceInsuranceFund, Price: 45000, Size: 0.7},
+	}, entry.Fills)
+	assert.InDelta(t, result.ClosePrice, entry.ClosePrice, 1e-9)
+	assert.InDelta(t, -4955.0, entry.PnL, 1e-9)
+
+	// settlement and state after
+	assert.Equal(t, result.Settlement, entry.Settlement)
+	assert.InDelta(t, 45.0, entry.InsuranceFundDelta, 1e-9)
+	account, err := ms.GetAccount("user1")
+	require.NoError(t, err)
+	require.NotNil(t, entry.AccountAfter)
+	assert.Equal(t, account.Snapshot(), *entry.AccountAfter)
+
+	// the close shows up in the trade history under the audit id
+	trades, err := ms.GetTradeHistory("user1", 0)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.Equal(t, result.AuditID, trades[0].TradeID)
+	assert.Equal(t, pos.ID, trades[0].PositionID)
+	assert.InDelta(t, -4955.0, trades[0].RealizedPnL, 1e-9)
+
+	// write-ahead: PREPARED then COMMITTED
+	history := store.History(result.AuditID)
+	require.Len(t, history, 2)
+	assert.Equal(t, AuditPrepared, history[0].Phase)
+	assert.Nil(t, history[0].AccountAfter)
+	assert.Less(t, history[0].Sequence, history[1].Sequence)
+
+	// symbol and time range
+	assert.Len(t, store.Query(AuditQuery{Symbol: "BTCUSDT", From: before}), 1)
+	assert.Empty(t, store.Query(AuditQuery{Symbol: "ETHUSDT"}))
+	assert.Empty(t, store.Query(AuditQuery{To: before}))
+	assert.Empty(t, store.Query(AuditQuery{From: time.Now().Add(time.Second)}))
+
+	// entries are append-only, nothing but PREPARED opens a liquidation
+	assert.Error(t, store.Append(AuditEntry{LiquidationID: "unknown", Phase: AuditCommitted}))
+}
+
+func TestAuditEntryForEverySettledLiquidation(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 8, MaxRetries: 2, RetryBackoff: time.Microsecond, MaxBackoff: time.Microsecond})
+	store := &flakyAuditStore{MemoryAuditStore: NewMemoryAuditStore(), rng: rand.New(rand.NewSource(7)), rate: 0.3}
+	engine.SetAuditStore(store)
+
+	// the ledger fails at random too, settled positions are what actually hit the margin system
+	rng := rand.New(rand.NewSource(11))
+	settled := make(map[string]bool)
+	var mu sync.Mutex
+	engine.settle = func(userID, positionID, symbol string, mode common.MarginMode, notional, marginReleased, pnl float64) (margin.LiquidationSettlement, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if rng.Float64() < 0.3 {
+			return margin.LiquidationSettlement{}, fmt.Errorf("ledger unavailable")
+		}
+		settled[positionID] = true
+		return ms.SettleLiquidation(userID, positionID, symbol, mode, notional, marginReleased, pnl)
+	}
+
+	users := make(map[string]string) // position id -> user
+	for i := 0; i < 200; i++ {
+		userID := fmt.Sprintf("user%03d", i)
+		users[openLong(t, pm, ms, userID, 0.01, 10).ID] = userID
+	}
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+
+	results := engine.RunOnce()
+	require.Len(t, results, len(users))
+	require.NotEmpty(t, settled)
+	require.Less(t, len(settled), len(users), "some settlements must have failed")
+
+	for _, result := range results {
+		entries := store.Query(AuditQuery{UserID: result.UserID})
+		if !settled[result.PositionID] {
+			// never settled: no entry, or one that did not commit
+			for _, entry := range entries {
+				assert.NotEqual(t, AuditCommitted, entry.Phase)
+			}
+			continue
+		}
+
+		require.Len(t, entries, 1, "settled liquidation %s without audit entry", result.PositionID)
+		entry := entries[0]
+		assert.Equal(t, result.AuditID, entry.LiquidationID)
+		assert.Equal(t, result.PositionID, entry.PositionBefore.ID)
+		assert.NotEmpty(t, entry.Fills)
+		if entry.Phase == AuditCommitted {
+			assert.Equal(t, result.Settlement, entry.Settlement)
+			assert.NotNil(t, entry.AccountAfter)
+		} else {
+			// commit lost after settling, the PREPARED entry still reconstructs the close
+			assert.Equal(t, AuditPrepared, entry.Phase)
+			assert.Contains(t, result.Error, "audit commit")
+		}
+	}
+
+	// only that user's events
+	for positionID, userID := range users {
+		for _, entry := range store.Query(AuditQuery{UserID: userID}) {
+			assert.Equal(t, userID, entry.UserID)
+			assert.Equal(t, positionID, entry.PositionBefore.ID)
+		}
+	}
+	assert.Empty(t, store.Query(AuditQuery{UserID: "nobody"}))
+}
+
+func TestLiquidationFeeToInsuranceFund(t *testing.T) {
+	tests := []struct {
+		name    string
+		feeRate float64
+		fee     float64
+	}{
+		// closed at 45100: 100 of the margin left, fee 45100 * 0.1% = 45.1, 54.9 returned
+		{"fee covered", 0.001, 45.1},
+		// fee 225.5 is more than the 100 left: capped, never a shortfall
+		{"fee above remaining margin", 0.005, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm, ms := newSystem(t)
+			require.NoError(t, ms.SetLiquidationFeeRate("BTCUSDT", tt.feeRate))
+			engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, MarkPriceFallback: true})
+			store := NewMemoryAuditStore()
+			engine.SetAuditStore(store)
+
+			openLong(t, pm, ms, "user1", 1, 10)
+			_, err := pm.UpdateMarkPrices("BTCUSDT", 45100)
+			require.NoError(t, err)
+
+			results := engine.RunOnce()
+			require.Len(t, results, 1)
+			settlement := results[0].Settlement
+			assert.Equal(t, tt.feeRate, settlement.FeeRate)
+			assert.InDelta(t, tt.fee, settlement.Fee, 1e-9)
+			assert.Zero(t, settlement.FundCovered)
+			assert.InDelta(t, tt.fee, ms.InsuranceFund().Balance(), 1e-9)
+
+			// margin 5000, loss 4900 plus the fee
+			assert.InDelta(t, 4900+tt.fee, settlement.AccountCharge, 1e-9)
+
+			entries := store.Query(AuditQuery{UserID: "user1"})
+			require.Len(t, entries, 1)
+			assert.InDelta(t, tt.fee, entries[0].Settlement.Fee, 1e-9)
+			assert.InDelta(t, tt.fee, entries[0].InsuranceFundDelta, 1e-9)
+
+			trades, err := ms.GetTradeHistory("user1", 0)
+			require.NoError(t, err)
+			require.Len(t, trades, 1)
+			assert.InDelta(t, tt.fee, trades[0].Fee, 1e-9)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"sort"
+	"time"
+)
+
+// DefaultBackstopTimeout time a provider has to answer an offer when Config.BackstopTimeout is 0
+const DefaultBackstopTimeout = 100 * time.Millisecond
+
+// BackstopProvider (後備流動性) designated market maker taking over what the order book did not fill,
+// before the insurance fund does. snapshot.Size is the offered size, price the takeover price
+type BackstopProvider interface {
+	Offer(snapshot position.PositionSnapshot, price float64) (accepted bool)
+}
+
+// BackstopFunc adapter of a plain function
+type BackstopFunc func(snapshot position.PositionSnapshot, price float64) bool
+
+func (f BackstopFunc) Offer(snapshot position.PositionSnapshot, price float64) bool {
+	return f(snapshot, price)
+}
+
+type backstop struct {
+	userID   string
+	priority int
+	provider BackstopProvider
+}
+
+type openFunc func(marginMode common.MarginMode, userID, symbol string, side position.PositionSide, price, size float64, leverage uint) (*position.Position, error)
+
+type marginCheckFunc func(userID, symbol string, side position.PositionSide, size, price float64, leverage int16) error
+
+// RegisterBackstopProvider providers are polled by ascending priority, registration order among equals.
+// an accepted offer opens (or adds to) a cross position of userID on the liquidated side at the offered price
+func (e *LiquidationEngine) RegisterBackstopProvider(userID string, priority int, provider BackstopProvider) error {
+	if userID == "" || provider == nil {
+		return fmt.Errorf("backstop provider needs a user id and a provider")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, registered := range e.backstops {
+		if registered.userID == userID {
+			return fmt.Errorf("backstop provider %s already registered", userID)
+		}
+	}
+	e.backstops = append(e.backstops, backstop{userID: userID, priority: priority, provider: provider})
+	sort.SliceStable(e.backstops, func(i, j int) bool {
+		return e.backstops[i].priority < e.backstops[j].priority
+	})
+	return nil
+}
+
+// backstopPrice mark price conceded by BackstopDiscount to the provider, no worse than the bankruptcy price
+func (e *LiquidationEngine) backstopPrice(snapshot position.PositionSnapshot, bankruptcyPrice float64) float64 {
+	if snapshot.Side == position.LONG {
+		price := snapshot.MarkPrice * (1 - e.config.BackstopDiscount)
+		return max(price, bankruptcyPrice)
+	}
+	price := snapshot.MarkPrice * (1 + e.config.BackstopDiscount)
+	if bankruptcyPrice > 0 {
+		price = min(price, bankruptcyPrice)
+	}
+	return price
+}
+
+// offerBackstop offer size to the providers in turn, the first one that accepts in time and can
+// carry the margin takes the position over. return the provider's user ID and the price
+func (e *LiquidationEngine) offerBackstop(snapshot position.PositionSnapshot, bankruptcyPrice, size float64) (string, float64, bool) {
+	e.mu.Lock()
+	backstops := e.backstops
+	e.mu.Unlock()
+	if len(backstops) == 0 || size <= 0 {
+		return "", 0, false
+	}
+
+	price := e.backstopPrice(snapshot, bankruptcyPrice)
+	offered := snapshot
+	offered.Size = size
+	leverage := max(snapshot.Leverage, 1)
+
+	for _, b := range backstops {
+		if b.userID == snapshot.UserID || !e.askBackstop(b.provider, offered, price) {
+			continue
+		}
+		// settle the provider side: margin first, then the position
+		if err := e.checkMargin(b.userID, snapshot.Symbol, snapshot.Side, size, price, leverage); err != nil {
+			continue
+		}
+		if _, err := e.open(common.CROSS, b.userID, snapshot.Symbol, snapshot.Side, price, size, uint(leverage)); err != nil {
+			continue
+		}
+		_ = e.updateMargin(b.userID)
+		return b.userID, price, true
+	}
+	return "", 0, false
+}
+
+// askBackstop one offer under the backstop timeout, an answer after it is ignored
+func (e *LiquidationEngine) askBackstop(provider BackstopProvider, snapshot position.PositionSnapshot, price float64) bool {
+	answer := make(chan bool, 1)
+	go func() {
+		defer func() {
+			if recover() != nil {
+				answer <- false
+			}
+		}()
+		answer <- provider.Offer(snapshot, price)
+	}()
+
+	timeout := e.config.BackstopTimeout
+	if timeout <= 0 {
+		timeout = DefaultBackstopTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case accepted := <-answer:
+		return accepted
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,117 @@
+package liquidation
+
+import (
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackstopProviders(t *testing.T) {
+	// 1 BTC 10x long, mark 45100, bankruptcy 45000. the empty book without fallback leaves
+	// everything to the fund unless a provider takes it
+	setup := func(t *testing.T) (*position.PositionManager, *LiquidationEngine) {
+		pm, ms := newSystem(t)
+		engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, BackstopDiscount: 0.001, BackstopTimeout: 20 * time.Millisecond})
+		engine.SetOrderBook("BTCUSDT", orderbook.NewOrderBook("BTCUSDT"))
+		for _, userID := range []string{"mm1", "mm2", "mm3"} {
+			_, err := ms.CreateAccount(userID)
+			require.NoError(t, err)
+			require.NoError(t, ms.Deposit(userID, 100_000))
+		}
+		openLong(t, pm, ms, "user1", 1, 10)
+		_, err := pm.UpdateMarkPrices("BTCUSDT", 45100)
+		require.NoError(t, err)
+		return pm, engine
+	}
+
+	var mu sync.Mutex
+	var asked []string
+	provider := func(name string, accept bool) BackstopFunc {
+		return func(snapshot position.PositionSnapshot, price float64) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			asked = append(asked, name)
+			return accept
+		}
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		asked = nil
+		pm, engine := setup(t)
+		var offered position.PositionSnapshot
+		require.NoError(t, engine.RegisterBackstopProvider("mm3", 3, provider("mm3", true)))
+		require.NoError(t, engine.RegisterBackstopProvider("mm2", 2, BackstopFunc(func(snapshot position.PositionSnapshot, price float64) bool {
+			offered = snapshot
+			return provider("mm2", true)(snapshot, price)
+		})))
+		require.NoError(t, engine.RegisterBackstopProvider("mm1", 1, provider("mm1", false)))
+		assert.Error(t, engine.RegisterBackstopProvider("mm1", 1, provider("mm1", false)))
+
+		results := engine.RunOnce()
+		require.Len(t, results, 1)
+		result := results[0]
+		assert.Equal(t, []string{"mm1", "mm2"}, asked, "polled by priority until accepted")
+		assert.Equal(t, "user1", offered.UserID)
+		assert.Equal(t, 1.0, offered.Size)
+
+		// 45100 * (1 - 0.1%)
+		price := 45054.9
+		assert.Equal(t, "mm2", result.BackstopProvider)
+		assert.Equal(t, 1.0, result.BackstopSize)
+		assert.Zero(t, result.FundSize)
+		assert.InDelta(t, price, result.ClosePrice, 1e-9)
+		require.Len(t, result.Fills, 1)
+		assert.Equal(t, FillSourceBackstop, result.Fills[0].Source)
+		assert.Equal(t, "mm2", result.Fills[0].Counterparty)
+		// the margin left above the bankruptcy price goes to the fund
+		assert.InDelta(t, 54.9, result.Settlement.FundDeposit, 1e-6)
+
+		taken, err := pm.GetPosition("mm2", "BTCUSDT", position.LONG)
+		require.NoError(t, err)
+		snapshot := taken.Snapshot()
+		assert.Equal(t, 1.0, snapshot.Size)
+		assert.InDelta(t, price, snapshot.EntryPrice, 1e-9)
+		assert.Equal(t, int16(10), snapshot.Leverage)
+		_, err = pm.GetPosition("mm1", "BTCUSDT", position.LONG)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejected falls through to the fund", func(t *testing.T) {
+		asked = nil
+		_, engine := setup(t)
+		require.NoError(t, engine.RegisterBackstopProvider("mm1", 1, provider("mm1", false)))
+
+		results := engine.RunOnce()
+		require.Len(t, results, 1)
+		assert.Equal(t, []string{"mm1"}, asked)
+		assert.Empty(t, results[0].BackstopProvider)
+		assert.Equal(t, 1.0, results[0].FundSize)
+		assert.Equal(t, 45000.0, results[0].ClosePrice)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		asked = nil
+		pm, engine := setup(t)
+		release := make(chan struct{})
+		defer close(release)
+		require.NoError(t, engine.RegisterBackstopProvider("mm1", 1, BackstopFunc(func(position.PositionSnapshot, float64) bool {
+			<-release
+			return true
+		})))
+		require.NoError(t, engine.RegisterBackstopProvider("mm2", 2, provider("mm2", false)))
+
+		start := time.Now()
+		results := engine.RunOnce()
+		require.Len(t, results, 1)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, []string{"mm2"}, asked, "the next provider is asked after the timeout")
+		assert.Equal(t, 1.0, results[0].FundSize)
+		_, err := pm.GetPosition("mm1", "BTCUSDT", position.LONG)
+		assert.Error(t, err, "a late answer is ignored")
+	})
+}
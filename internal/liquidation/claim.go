@@ -0,0 +1,206 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/position"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultLeaseDuration   = 30 * time.Second
+	DefaultDeadLetterAfter = 5
+)
+
+type jobState int
+
+const (
+	jobRunning jobState = iota
+	jobQueued
+	jobDead
+)
+
+// liquidationJob (強平任務) a claimed position until it is settled. a failed pass keeps the claim and is
+// re-queued with exponential backoff, the close is never repeated once done: only the settlement is retried.
+type liquidationJob struct {
+	position        *position.Position
+	owner           string // worker ID of the claim
+	snapshot        position.PositionSnapshot
+	bankruptcyPrice float64
+	result          LiquidationResult // close so far, carried across passes
+	closed          bool
+	detectedAt      time.Time // claimed
+
+	state       jobState
+	passes      int // failed
+	nextAttempt time.Time
+	lastError   string
+	deadAt      time.Time
+}
+
+// DeadLetter (死信) position whose liquidation failed DeadLetterAfter passes. it stays claimed, no worker
+// touches it until an operator retries it
+type DeadLetter struct {
+	PositionID     string                `json:"position_id"`
+	UserID         string                `json:"user_id"`
+	Symbol         string                `json:"symbol"`
+	Side           position.PositionSide `json:"side"`
+	Passes         int                   `json:"passes"`
+	LastError      string                `json:"last_error"`
+	Closed         bool                  `json:"closed"`      // only the settlement is left
+	ClosedSize     float64               `json:"closed_size"` // closed but not settled
+	DeadLetteredAt time.Time             `json:"dead_lettered_at"`
+}
+
+// DeadLetters positions waiting for an operator, oldest first
+func (e *LiquidationEngine) DeadLetters() []DeadLetter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var letters []DeadLetter
+	for _, job := range e.jobs {
+		if job.state != jobDead {
+			continue
+		}
+		letters = append(letters, DeadLetter{
+			PositionID:     job.snapshot.ID,
+			UserID:         job.snapshot.UserID,
+			Symbol:         job.snapshot.Symbol,
+			Side:           job.snapshot.Side,
+			Passes:         job.passes,
+			LastError:      job.lastError,
+			Closed:         job.closed,
+			ClosedSize:     job.result.Size,
+			DeadLetteredAt: job.deadAt,
+		})
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].DeadLetteredAt.Before(letters[j].DeadLetteredAt)
+	})
+	return letters
+}
+
+// RetryDeadLetter put a dead-lettered position back in the queue with a fresh retry budget,
+// it runs on the next pass
+func (e *LiquidationEngine) RetryDeadLetter(positionID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, exists := e.jobs[positionID]
+	if !exists || job.state != jobDead {
+		return fmt.Errorf("position %s is not dead-lettered", positionID)
+	}
+	if !job.position.RenewLiquidationLease(job.owner, e.leaseDuration(), time.Now()) {
+		delete(e.jobs, positionID)
+		return fmt.Errorf("position %s claimed by another worker", positionID)
+	}
+	job.state, job.passes, job.nextAttempt = jobQueued, 0, time.Time{}
+	return nil
+}
+
+// PendingLiquidations positions re-queued after a failed pass, not dead-lettered
+func (e *LiquidationEngine) PendingLiquidations() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pending := 0
+	for _, job := range e.jobs {
+		if job.state == jobQueued {
+			pending++
+		}
+	}
+	return pending
+}
+
+// fail end a pass: re-queue after a backoff, or dead-letter once the passes are exhausted.
+// the claim is kept either way, covering the backoff
+func (e *LiquidationEngine) fail(job *liquidationJob, result LiquidationResult, reason string) LiquidationResult {
+	if reason == "" {
+		reason = "nothing closed"
+	}
+	now := time.Now()
+
+	e.mu.Lock()
+	job.passes++
+	job.lastError = reason
+	if job.passes >= e.deadLetterAfter() {
+		job.state, job.deadAt = jobDead, now
+		job.position.RenewLiquidationLease(job.owner, 0, now)
+		result.DeadLettered = true
+	} else {
+		backoff := e.config.RetryBackoff << min(job.passes, 32)
+		if backoff <= 0 || (e.config.MaxBackoff > 0 && backoff > e.config.MaxBackoff) {
+			backoff = e.config.MaxBackoff
+		}
+		job.state, job.nextAttempt = jobQueued, now.Add(backoff)
+		job.position.RenewLiquidationLease(job.owner, backoff+e.leaseDuration(), now)
+		result.Requeued = true
+	}
+	e.mu.Unlock()
+
+	result.Pass = job.passes
+	result.Error = reason
+	e.metricsRegistry().AddCounter(MetricLiquidationFailures, 1, metrics.Labels{"symbol": result.Symbol})
+	return e.record(result)
+}
+
+// lost the lease expired and another worker took the position over, drop the job without settling
+func (e *LiquidationEngine) lost(job *liquidationJob) LiquidationResult {
+	e.mu.Lock()
+	if e.jobs[job.snapshot.ID] == job {
+		delete(e.jobs, job.snapshot.ID)
+	}
+	e.mu.Unlock()
+
+	result := job.result
+	result.Error = fmt.Sprintf("liquidation lease of %s lost", job.owner)
+	return e.record(result)
+}
+
+// dueJobs re-queued jobs whose backoff is over, marked running
+func (e *LiquidationEngine) dueJobs(now time.Time) []*liquidationJob {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var due []*liquidationJob
+	for _, job := range e.jobs {
+		if job.state == jobQueued && !now.Before(job.nextAttempt) {
+			job.state = jobRunning
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].nextAttempt.Before(due[j].nextAttempt)
+	})
+	return due
+}
+
+// requeue a due job that was not run
+func (e *LiquidationEngine) requeue(job *liquidationJob) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	job.state = jobQueued
+}
+
+// hasJob the position has a job of this engine, fresh candidates for it are skipped
+func (e *LiquidationEngine) hasJob(positionID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, exists := e.jobs[positionID]
+	return exists
+}
+
+func (e *LiquidationEngine) leaseDuration() time.Duration {
+	if e.config.LeaseDuration > 0 {
+		return e.config.LeaseDuration
+	}
+	return DefaultLeaseDuration
+}
+
+func (e *LiquidationEngine) deadLetterAfter() int {
+	if e.config.DeadLetterAfter > 0 {
+		return e.config.DeadLetterAfter
+	}
+	return DefaultDeadLetterAfter
+}
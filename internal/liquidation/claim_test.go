@@ -0,0 +1,166 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransientSettlementFailuresSettleExactlyOnce(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{
+		PollInterval:    time.Millisecond,
+		Workers:         8,
+		RetryBackoff:    time.Millisecond,
+		MaxBackoff:      4 * time.Millisecond,
+		DeadLetterAfter: 50,
+	})
+
+	// half the settlements fail, each position settles at most once in the margin system
+	rng := rand.New(rand.NewSource(3))
+	settled := make(map[string]int)
+	var mu sync.Mutex
+	engine.settle = func(userID, positionID, symbol string, mode common.MarginMode, notional, marginReleased, pnl float64) (margin.LiquidationSettlement, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if rng.Float64() < 0.5 {
+			return margin.LiquidationSettlement{}, fmt.Errorf("ledger unavailable")
+		}
+		settled[positionID]++
+		return ms.SettleLiquidation(userID, positionID, symbol, mode, notional, marginReleased, pnl)
+	}
+
+	var positions []*position.Position
+	for i := 0; i < 100; i++ {
+		positions = append(positions, openLong(t, pm, ms, fmt.Sprintf("user%03d", i), 0.01, 10))
+	}
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+
+	// background loop racing manual passes
+	require.NoError(t, engine.Start())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				engine.RunOnce()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(settled) == len(positions) && engine.PendingLiquidations() == 0
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, engine.Stop())
+
+	succeeded := make(map[string]int)
+	requeued := 0
+	for _, result := range engine.Results() {
+		if result.Error == "" {
+			succeeded[result.PositionID]++
+			assert.InDelta(t, 0.01, result.Size, 1e-12, "the close is never repeated")
+		} else {
+			assert.True(t, result.Requeued)
+			requeued++
+		}
+	}
+	require.Positive(t, requeued)
+	for _, pos := range positions {
+		assert.Equal(t, 1, settled[pos.ID])
+		assert.Equal(t, 1, succeeded[pos.ID])
+		assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+	}
+	assert.Empty(t, engine.DeadLetters())
+	assert.InDelta(t, float64(len(positions)), ms.InsuranceFund().Balance(), 1e-6)
+}
+
+func TestDeadLetterAfterExhaustedPasses(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, MaxRetries: 1, DeadLetterAfter: 3})
+
+	failing := true
+	engine.settle = func(userID, positionID, symbol string, mode common.MarginMode, notional, marginReleased, pnl float64) (margin.LiquidationSettlement, error) {
+		if failing {
+			return margin.LiquidationSettlement{}, fmt.Errorf("ledger unavailable")
+		}
+		return ms.SettleLiquidation(userID, positionID, symbol, mode, notional, marginReleased, pnl)
+	}
+
+	pos := openLong(t, pm, ms, "user1", 1, 10)
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45000)
+	require.NoError(t, err)
+
+	for pass := 1; pass <= 3; pass++ {
+		results := engine.RunOnce()
+		require.Len(t, results, 1)
+		assert.Equal(t, pass, results[0].Pass)
+		assert.Equal(t, 2, results[0].Attempts, "inline retries within a pass")
+		assert.Equal(t, "ledger unavailable", results[0].Error)
+		assert.Equal(t, pass < 3, results[0].Requeued)
+		assert.Equal(t, pass == 3, results[0].DeadLettered)
+	}
+	assert.Zero(t, engine.PendingLiquidations())
+
+	letters := engine.DeadLetters()
+	require.Len(t, letters, 1)
+	assert.Equal(t, pos.ID, letters[0].PositionID)
+	assert.Equal(t, 3, letters[0].Passes)
+	assert.Equal(t, "ledger unavailable", letters[0].LastError)
+	assert.True(t, letters[0].Closed)
+	assert.Equal(t, 1.0, letters[0].ClosedSize)
+
+	// held until an operator acts, never settled meanwhile
+	assert.Empty(t, engine.RunOnce())
+	owner, expiresAt, claimed := pos.LiquidationLease()
+	assert.True(t, claimed)
+	assert.NotEmpty(t, owner)
+	assert.True(t, expiresAt.IsZero(), "dead letters hold the claim without expiry")
+	assert.Zero(t, ms.InsuranceFund().Balance())
+
+	failing = false
+	require.NoError(t, engine.RetryDeadLetter(pos.ID))
+	assert.Error(t, engine.RetryDeadLetter(pos.ID))
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, 1, results[0].Pass)
+	assert.Empty(t, engine.DeadLetters())
+	assert.Empty(t, engine.RunOnce())
+	assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+}
+
+func TestExpiredLeaseIsTakenOver(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1})
+
+	pos := openLong(t, pm, ms, "user1", 1, 10)
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45000)
+	require.NoError(t, err)
+
+	// a worker claimed it and died
+	require.True(t, pos.ClaimLiquidationLease("crashed", 20*time.Millisecond, time.Now()))
+	assert.Empty(t, engine.RunOnce())
+
+	time.Sleep(30 * time.Millisecond)
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+
+	// the old owner is fenced off
+	_, _, err = pm.LiquidatePositionAs("crashed", pos, 45000, 1)
+	assert.Error(t, err)
+	assert.Empty(t, engine.RunOnce())
+}
@@ -0,0 +1,134 @@
+package liquidation
+
+import (
+	"context"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"sort"
+)
+
+// CrossAccountCheck (全倉帳戶檢查) one evaluation of a cross account after a mark price update
+type CrossAccountCheck struct {
+	UserID            string              `json:"user_id"`
+	Equity            float64             `json:"equity"`
+	MaintenanceMargin float64             `json:"maintenance_margin"` // of all cross positions
+	Breached          bool                `json:"breached"`           // equity <= maintenance margin
+	Results           []LiquidationResult `json:"results,omitempty"`  // LiquidateUser of a breached account
+}
+
+// NotifyMarkPrice queue a re-evaluation of the cross accounts holding symbol, run by the background
+// loop of Start. updates of the same symbol before the loop gets to it are merged
+func (e *LiquidationEngine) NotifyMarkPrice(symbol string) {
+	e.crossMu.Lock()
+	e.dirtySymbols[symbol] = struct{}{}
+	e.crossMu.Unlock()
+
+	select {
+	case e.crossWake <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// CheckCrossAccounts re-evaluate the cross accounts holding any of the symbols, each once, and hand the
+// breaching ones to LiquidateUser. isolated positions are left to the per symbol scan
+func (e *LiquidationEngine) CheckCrossAccounts(symbols ...string) []CrossAccountCheck {
+	return e.checkCrossAccounts(context.Background(), symbols)
+}
+
+func (e *LiquidationEngine) checkCrossAccounts(ctx context.Context, symbols []string) []CrossAccountCheck {
+	seen := make(map[string]struct{})
+	var users []string
+	for _, symbol := range symbols {
+		for _, userID := range e.positionMgr.CrossUsers(symbol) {
+			if _, ok := seen[userID]; !ok {
+				seen[userID] = struct{}{}
+				users = append(users, userID)
+			}
+		}
+	}
+	sort.Strings(users)
+
+	checks := make([]CrossAccountCheck, 0, len(users))
+	for _, userID := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		check := e.checkCrossAccount(userID)
+		if check.Breached {
+			check.Results = e.liquidateUser(ctx, userID)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func (e *LiquidationEngine) checkCrossAccount(userID string) CrossAccountCheck {
+	risk := e.crossRisk(userID)
+	return CrossAccountCheck{
+		UserID:            userID,
+		Equity:            risk.TotalEquity,
+		MaintenanceMargin: risk.TotalMaintenanceMargin,
+		Breached:          risk.TotalMaintenanceMargin > 0 && risk.TotalEquity <= risk.TotalMaintenanceMargin,
+	}
+}
+
+// LiquidateUser (帳戶強平) liquidate the cross positions of a breached account, biggest unrealized loss
+// first, until the account is above its maintenance margin again. positions are claimed whether or
+// not they are liquidatable on their own, each follows the liquidation policy
+func (e *LiquidationEngine) LiquidateUser(userID string) []LiquidationResult {
+	return e.liquidateUser(context.Background(), userID)
+}
+
+func (e *LiquidationEngine) liquidateUser(ctx context.Context, userID string) []LiquidationResult {
+	positions, err := e.positionMgr.GetUserPositions(userID)
+	if err != nil {
+		return nil
+	}
+
+	type crossPosition struct {
+		pos *position.Position
+		pnl float64
+	}
+	var cross []crossPosition
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.MarginMode == common.CROSS && snapshot.Status != position.PositionClosed && snapshot.Size > 0 {
+			cross = append(cross, crossPosition{pos: pos, pnl: snapshot.UnrealizedPnL})
+		}
+	}
+	sort.Slice(cross, func(i, j int) bool {
+		return cross[i].pnl < cross[j].pnl
+	})
+
+	var results []LiquidationResult
+	for _, c := range cross {
+		if ctx.Err() != nil || !e.checkCrossAccount(userID).Breached {
+			break
+		}
+		if result, ok := e.claimAndRun(ctx, c.pos, (*position.Position).ClaimAccountLiquidationLease); ok {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// runCrossMonitor check the accounts of the notified symbols until ctx is done
+func (e *LiquidationEngine) runCrossMonitor(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.crossWake:
+		}
+
+		e.crossMu.Lock()
+		symbols := make([]string, 0, len(e.dirtySymbols))
+		for symbol := range e.dirtySymbols {
+			symbols = append(symbols, symbol)
+		}
+		clear(e.dirtySymbols)
+		e.crossMu.Unlock()
+
+		e.checkCrossAccounts(ctx, symbols)
+	}
+}
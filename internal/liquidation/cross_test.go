@@ -0,0 +1,75 @@
+package liquidation
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrossAccountMonitor(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{PollInterval: time.Hour, Workers: 1, MarkPriceFallback: true})
+
+	open := func(userID string, deposit float64, mode common.MarginMode, legs map[string][2]float64) {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, deposit))
+		for symbol, leg := range legs {
+			_, err = pm.OpenPosition(mode, userID, symbol, position.LONG, leg[0], leg[1], 10)
+			require.NoError(t, err)
+		}
+		require.NoError(t, ms.UpdatePositionMargin(userID))
+	}
+	btc := map[string][2]float64{"BTCUSDT": {50000, 1}}
+	eth := map[string][2]float64{"ETHUSDT": {3000, 10}}
+	open("btc_only", 6000, common.CROSS, btc)
+	open("eth_only", 4000, common.CROSS, eth)
+	open("both", 100_000, common.CROSS, map[string][2]float64{"BTCUSDT": {50000, 1}, "ETHUSDT": {3000, 10}})
+	open("isolated", 6000, common.ISOLATED, btc)
+
+	assert.Equal(t, []string{"both", "btc_only"}, pm.CrossUsers("BTCUSDT"))
+	assert.Equal(t, []string{"both", "eth_only"}, pm.CrossUsers("ETHUSDT"))
+
+	// BTC 45300: btc_only equity 6000 - 4700 = 1300 <= maintenance 5% of 45300, no position breaches on its own
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45300)
+	require.NoError(t, err)
+	assert.Empty(t, pm.GetAllLiquidatablePositions()["BTCUSDT"])
+
+	checks := engine.CheckCrossAccounts("BTCUSDT")
+	require.Len(t, checks, 2, "only BTC holders are re-evaluated")
+	assert.Equal(t, "both", checks[0].UserID)
+	assert.False(t, checks[0].Breached)
+	assert.Empty(t, checks[0].Results)
+
+	assert.Equal(t, "btc_only", checks[1].UserID)
+	assert.True(t, checks[1].Breached)
+	assert.InDelta(t, 1300, checks[1].Equity, 1e-9)
+	assert.InDelta(t, 2265, checks[1].MaintenanceMargin, 1e-9)
+	require.Len(t, checks[1].Results, 1)
+	assert.Equal(t, "BTCUSDT", checks[1].Results[0].Symbol)
+	assert.Equal(t, 1.0, checks[1].Results[0].Size)
+
+	// closed positions leave the index, the isolated user was never part of it
+	assert.Equal(t, []string{"both"}, pm.CrossUsers("BTCUSDT"))
+	_, err = pm.GetPosition("isolated", "BTCUSDT", position.LONG)
+	assert.NoError(t, err)
+
+	// background loop: an ETH tick is caught by the next round
+	require.NoError(t, engine.Start())
+	defer engine.Stop()
+	_, err = pm.UpdateMarkPrices("ETHUSDT", 2700)
+	require.NoError(t, err)
+	engine.NotifyMarkPrice("ETHUSDT")
+
+	assert.Eventually(t, func() bool {
+		return len(pm.CrossUsers("ETHUSDT")) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"both"}, pm.CrossUsers("ETHUSDT"))
+	results := engine.Results()
+	require.Len(t, results, 2)
+	assert.Equal(t, "eth_only", results[1].UserID)
+}
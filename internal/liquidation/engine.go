@@ -0,0 +1,656 @@
+package liquidation
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LiquidationUserID taker user of liquidation orders sent into the book
+const LiquidationUserID = "liquidation_engine"
+
+// Config
+type Config struct {
+	PollInterval time.Duration // scan period of the background loop
+	Workers      int           // positions liquidated concurrently
+	MaxRetries   int           // settlement retries after the first attempt
+	RetryBackoff time.Duration // delay before the first retry, doubled every retry
+	MaxBackoff   time.Duration
+
+	LeaseDuration   time.Duration // claim lease of a worker, renewed at every step, 0 means DefaultLeaseDuration
+	DeadLetterAfter int           // failed passes before a position is dead-lettered, 0 means DefaultDeadLetterAfter
+
+	// the book has no liquidity on the opposite side: take over at mark price
+	// instead of letting the insurance fund absorb the whole size at the bankruptcy price
+	MarkPriceFallback bool
+
+	Policy LiquidationPolicy // zero value: full close
+
+	// shortfall beyond the insurance fund: auto-deleveraging (default) or socialized loss, never both
+	Shortfall ShortfallMode
+
+	BackstopDiscount float64       // mark price concession to backstop providers, e.g. 0.001 for 0.1%
+	BackstopTimeout  time.Duration // time a provider has to answer, 0 means DefaultBackstopTimeout
+}
+
+// MaxResults liquidation results and ADL reports kept in memory each, the oldest are dropped beyond it.
+// OnResult and OnADL see every one
+const MaxResults = 1000
+
+var DefaultConfig = &Config{
+	PollInterval: 100 * time.Millisecond,
+	Workers:      8,
+	MaxRetries:   3,
+	RetryBackoff: 10 * time.Millisecond,
+	MaxBackoff:   time.Second,
+
+	MarkPriceFallback: true,
+}
+
+// LiquidationResult (強平結果) one liquidated position
+type LiquidationResult struct {
+	PositionID       string                       `json:"position_id"`
+	UserID           string                       `json:"user_id"`
+	Symbol           string                       `json:"symbol"`
+	Side             position.PositionSide        `json:"side"`
+	MarginMode       common.MarginMode            `json:"margin_mode"`
+	Size             float64                      `json:"size"` // liquidated size, over all tranches
+	MarkPrice        float64                      `json:"mark_price"`
+	ClosePrice       float64                      `json:"close_price"`                 // average over book fills and takeover
+	BookFilledSize   float64                      `json:"book_filled_size"`            // part closed in the order book
+	BackstopSize     float64                      `json:"backstop_size"`               // part taken over by a backstop provider
+	BackstopProvider string                       `json:"backstop_provider,omitempty"` // user ID of the provider
+	FundSize         float64                      `json:"fund_size"`                   // remainder taken over by the insurance fund
+	TakeoverPrice    float64                      `json:"takeover_price"`              // price of the remainder, bankruptcy, mark or backstop
+	PnL              float64                      `json:"pnl"`
+	MarginReleased   float64                      `json:"margin_released"`
+	Settlement       margin.LiquidationSettlement `json:"settlement"`
+	ADL              *ADLReport                   `json:"adl,omitempty"`             // shortfall beyond the insurance fund
+	SocializedLoss   *margin.SocializedLoss       `json:"socialized_loss,omitempty"` // same, with ShortfallSocialized
+	Trades           []orderbook.Trade            `json:"trades,omitempty"`
+	Fills            []LiquidationFill            `json:"fills,omitempty"` // book trades and takeover
+	Tranches         int                          `json:"tranches"`
+	Recovered        bool                         `json:"recovered"` // partial: healthy again, back to normal
+	Escalated        bool                         `json:"escalated"` // partial: MaxTranches exhausted, remainder closed
+	RemainingSize    float64                      `json:"remaining_size"`
+	Attempts         int                          `json:"attempts"` // settlement attempts of this pass
+	Pass             int                          `json:"pass"`     // 1 for the first pass, higher when re-queued
+	Requeued         bool                         `json:"requeued"` // failed, retried after a backoff
+	DeadLettered     bool                         `json:"dead_lettered"`
+	AuditID          string                       `json:"audit_id,omitempty"`
+	Error            string                       `json:"error,omitempty"`
+	Timestamp        time.Time                    `json:"timestamp"`
+}
+
+// ResultHandler called for every liquidation, outside the engine lock
+type ResultHandler func(result LiquidationResult)
+
+type socializeFunc func(liquidatedPositionID, symbol string, side position.PositionSide, shortfall float64) (margin.SocializedLoss, error)
+
+type claimFunc func(pos *position.Position, owner string, lease time.Duration, now time.Time) bool
+
+type settleFunc func(userID, positionID, symbol string, mode common.MarginMode, notional, marginReleased, pnl float64) (margin.LiquidationSettlement, error)
+
+// LiquidationEngine (強平引擎) claims liquidatable positions, closes them and settles through the margin system
+type LiquidationEngine struct {
+	positionMgr *position.PositionManager
+	config      *Config
+	settle      settleFunc
+	deleverage  deleverageFunc
+	socialize   socializeFunc
+	account     func(userID string) (*margin.MarginAccount, error)
+	crossRisk   func(userID string) margin.CrossSymbolRisk
+	audit       AuditStore
+
+	// backstop takeover: provider side margin check, position and account update
+	open         openFunc
+	checkMargin  marginCheckFunc
+	updateMargin func(userID string) error
+
+	fundBalance func() float64
+	metrics     metrics.Registry
+
+	books      map[string]*orderbook.OrderBook // symbol -> book
+	onResult   ResultHandler
+	onADL      ADLHandler
+	results    *common.RingBuffer[LiquidationResult]
+	adlReports *common.RingBuffer[ADLReport]
+	backstops  []backstop                 // by priority
+	jobs       map[string]*liquidationJob // claimed positions by ID: running, re-queued or dead-lettered
+	mu         sync.Mutex
+
+	// symbols with a mark price update not yet checked by the cross account monitor
+	dirtySymbols map[string]struct{}
+	crossWake    chan struct{}
+	crossMu      sync.Mutex
+
+	// worker IDs of claims: engine ID + sequence
+	id        string
+	workerSeq atomic.Int64
+
+	// background loop lifecycle
+	cancel   context.CancelFunc
+	done     chan struct{}
+	lastPass atomic.Int64 // unix nanoseconds of the last completed poll, or of Start
+	runMu    sync.Mutex
+}
+
+// NewLiquidationEngine config nil means DefaultConfig
+func NewLiquidationEngine(positionMgr *position.PositionManager, marginSystem *margin.MarginSystem, config *Config) *LiquidationEngine {
+	if config == nil {
+		config = DefaultConfig
+	}
+	return &LiquidationEngine{
+		positionMgr: positionMgr,
+		config:      config,
+		settle:      marginSystem.SettleLiquidation,
+		deleverage:  marginSystem.SettleReduceFill,
+		socialize:   marginSystem.SocializeLoss,
+		account:     marginSystem.GetAccount,
+		crossRisk:   marginSystem.GetCrossSymbolRisk,
+		audit:       NewMemoryAuditStore(),
+
+		open:         positionMgr.OpenPosition,
+		checkMargin:  marginSystem.CheckOrderMarginForSide,
+		updateMargin: marginSystem.UpdatePositionMargin,
+		fundBalance:  marginSystem.InsuranceFund().Balance,
+		metrics:      metrics.Nop{},
+
+		books:      make(map[string]*orderbook.OrderBook),
+		results:    common.NewRingBuffer[LiquidationResult](MaxResults),
+		adlReports: common.NewRingBuffer[ADLReport](MaxResults),
+		jobs:       make(map[string]*liquidationJob),
+
+		dirtySymbols: make(map[string]struct{}),
+		crossWake:    make(chan struct{}, 1),
+		id:           common.GenerateShortUUID("liq"),
+	}
+}
+
+// SetOrderBook liquidations of the symbol are sent into the book first
+func (e *LiquidationEngine) SetOrderBook(symbol string, book *orderbook.OrderBook) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.books[symbol] = book
+}
+
+func (e *LiquidationEngine) OnResult(handler ResultHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onResult = handler
+}
+
+// Results the last MaxResults liquidations, oldest first
+func (e *LiquidationEngine) Results() []LiquidationResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.results.Items()
+}
+
+// Start poll the position manager every PollInterval, and check the cross accounts of every
+// NotifyMarkPrice, until Stop
+func (e *LiquidationEngine) Start() error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	if e.cancel != nil {
+		return fmt.Errorf("liquidation engine already started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	e.lastPass.Store(time.Now().UnixNano())
+
+	var loops sync.WaitGroup
+	loops.Add(2)
+	go func() {
+		loops.Wait()
+		close(e.done)
+	}()
+	go func() {
+		defer loops.Done()
+		e.runCrossMonitor(ctx)
+	}()
+	go func() {
+		defer loops.Done()
+
+		ticker := time.NewTicker(e.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.processAll(ctx, e.positionMgr.GetAllLiquidatablePositions())
+				e.lastPass.Store(time.Now().UnixNano())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop the background loop and wait for in-flight liquidations
+func (e *LiquidationEngine) Stop() error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	if e.cancel == nil {
+		return fmt.Errorf("liquidation engine not started")
+	}
+	e.cancel()
+	<-e.done
+	e.cancel, e.done = nil, nil
+
+	return nil
+}
+
+// RunOnce liquidate everything liquidatable right now
+func (e *LiquidationEngine) RunOnce() []LiquidationResult {
+	return e.processAll(context.Background(), e.positionMgr.GetAllLiquidatablePositions())
+}
+
+// ForceLiquidate (手動強平) operator override: claim the open position whether or not it is liquidatable
+// and liquidate it under the policy, as the monitor would. an error when the position does not exist, is
+// closed or is already being liquidated
+func (e *LiquidationEngine) ForceLiquidate(positionID string) (LiquidationResult, error) {
+	pos, err := e.positionMgr.GetPositionByID(positionID)
+	if err != nil {
+		return LiquidationResult{}, err
+	}
+	result, ok := e.claimAndRun(context.Background(), pos, (*position.Position).ClaimAccountLiquidationLease)
+	if !ok {
+		return LiquidationResult{}, fmt.Errorf("position %s is closed or already being liquidated", positionID)
+	}
+	return result, nil
+}
+
+// ProcessAllLiquidations liquidate candidates of every symbol (PositionManager.GetAllLiquidatablePositions),
+// worst margin ratio first, after the re-queued positions that are due. candidates claimed by someone
+// else are skipped. the results are grouped by symbol
+func (e *LiquidationEngine) ProcessAllLiquidations(candidates map[string][]*position.LiquidationCandidate) map[string][]LiquidationResult {
+	bySymbol := make(map[string][]LiquidationResult)
+	for _, result := range e.processAll(context.Background(), candidates) {
+		bySymbol[result.Symbol] = append(bySymbol[result.Symbol], result)
+	}
+	return bySymbol
+}
+
+func (e *LiquidationEngine) processAll(ctx context.Context, candidates map[string][]*position.LiquidationCandidate) []LiquidationResult {
+	var queue []position.LiquidationCandidate
+	for _, symbolCandidates := range candidates {
+		for _, candidate := range symbolCandidates {
+			queue = append(queue, *candidate)
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		return queue[i].MarginRatio < queue[j].MarginRatio
+	})
+
+	var (
+		results []LiquidationResult
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	sem := make(chan struct{}, max(e.config.Workers, 1))
+
+	for _, job := range e.dueJobs(time.Now()) {
+		if ctx.Err() != nil {
+			e.requeue(job)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(job *liquidationJob) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			result := e.run(ctx, job)
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(job)
+	}
+
+	for _, candidate := range queue {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(candidate position.LiquidationCandidate) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if result, ok := e.liquidate(ctx, candidate); ok {
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}
+		}(candidate)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// liquidate claim, close and settle one position, false if it was not claimed
+func (e *LiquidationEngine) liquidate(ctx context.Context, candidate position.LiquidationCandidate) (LiquidationResult, bool) {
+	if candidate.Position == nil {
+		return LiquidationResult{}, false
+	}
+	return e.claimAndRun(ctx, candidate.Position, (*position.Position).ClaimLiquidationLease)
+}
+
+// claimAndRun claim pos with claim under a new worker ID and run the first pass, false if not claimed
+func (e *LiquidationEngine) claimAndRun(ctx context.Context, pos *position.Position, claim claimFunc) (LiquidationResult, bool) {
+	if e.hasJob(pos.ID) {
+		return LiquidationResult{}, false
+	}
+	owner := fmt.Sprintf("%s-%d", e.id, e.workerSeq.Add(1))
+	if !claim(pos, owner, e.leaseDuration(), time.Now()) {
+		return LiquidationResult{}, false
+	}
+
+	snapshot := pos.Snapshot()
+	job := &liquidationJob{
+		position:        pos,
+		owner:           owner,
+		snapshot:        snapshot,
+		bankruptcyPrice: pos.BankruptcyPrice(),
+		result: LiquidationResult{
+			PositionID: snapshot.ID,
+			UserID:     snapshot.UserID,
+			Symbol:     snapshot.Symbol,
+			Side:       snapshot.Side,
+			MarginMode: snapshot.MarginMode,
+			MarkPrice:  snapshot.MarkPrice,
+		},
+		state:      jobRunning,
+		detectedAt: time.Now(),
+	}
+	e.mu.Lock()
+	e.jobs[snapshot.ID] = job
+	e.mu.Unlock()
+
+	return e.run(ctx, job), true
+}
+
+// run one pass of a claimed job: close what is left to close, then settle. a failed pass is re-queued
+func (e *LiquidationEngine) run(ctx context.Context, job *liquidationJob) LiquidationResult {
+	pos, snapshot := job.position, job.snapshot
+
+	// the lease fences the close, the settlement of what this job closed is its own either way
+	if !job.closed {
+		if !pos.RenewLiquidationLease(job.owner, e.leaseDuration(), time.Now()) {
+			return e.lost(job)
+		}
+		e.closePosition(job)
+		if job.result.Size == 0 {
+			return e.fail(job, job.result, job.result.Error)
+		}
+		job.closed = true
+	}
+
+	// every pass settles under its own audit ID
+	result := job.result
+	result.Pass = job.passes + 1
+
+	// 3. settlement, retried with backoff. the PREPARED audit entry goes first,
+	// nothing settles without it.
+	store := e.auditStore()
+	result.AuditID = common.GenerateShortUUID("audit")
+	prepared := false
+	backoff := e.config.RetryBackoff
+	var err error
+	for {
+		result.Attempts++
+		if !prepared {
+			if err = store.Append(auditEntry(result.AuditID, AuditPrepared, snapshot, &result)); err == nil {
+				prepared = true
+			} else {
+				err = fmt.Errorf("audit: %w", err)
+			}
+		}
+		if prepared {
+			result.Settlement, err = e.settle(snapshot.UserID, snapshot.ID, snapshot.Symbol, snapshot.MarginMode, result.ClosePrice*result.Size, result.MarginReleased, result.PnL)
+		}
+		if err == nil || result.Attempts > e.config.MaxRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("settlement aborted: %w", ctx.Err())
+		case <-time.After(backoff):
+			backoff = min(backoff*2, e.config.MaxBackoff)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		if prepared {
+			_ = store.Append(auditEntry(result.AuditID, AuditAborted, snapshot, &result))
+		} else {
+			result.AuditID = ""
+		}
+		return e.fail(job, result, err.Error())
+	}
+	if account, err := e.account(snapshot.UserID); err == nil {
+		account.RecordTrade(margin.TradeRecord{
+			TradeID:     result.AuditID,
+			PositionID:  snapshot.ID,
+			Symbol:      snapshot.Symbol,
+			Side:        snapshot.Side,
+			Price:       result.ClosePrice,
+			Size:        result.Size,
+			Fee:         result.Settlement.Fee,
+			RealizedPnL: result.PnL,
+		})
+	}
+
+	// 4. shortfall beyond the insurance fund, auto-deleveraging or socialized loss
+	if result.Settlement.Uncovered > 0 {
+		if e.config.Shortfall == ShortfallSocialized {
+			e.socializeLoss(snapshot, &result)
+		} else {
+			result.ADL = e.autoDeleverage(snapshot, job.bankruptcyPrice, result.ClosePrice, result.Settlement.Uncovered)
+		}
+	}
+
+	// 5. commit the audit entry with the state after settlement
+	if err = e.commitAudit(store, snapshot, &result); err != nil {
+		result.Error = fmt.Sprintf("audit commit: %v", err)
+	}
+
+	// a close that failed half way leaves the rest to the next claimer
+	if result.RemainingSize > 0 && !result.Recovered {
+		pos.AbandonLiquidation(job.owner)
+	}
+	e.mu.Lock()
+	delete(e.jobs, snapshot.ID)
+	e.mu.Unlock()
+	e.observeSettled(job, result)
+	return e.record(result)
+}
+
+// closePosition close the claimed position into job.result, by tranches with PARTIAL
+func (e *LiquidationEngine) closePosition(job *liquidationJob) {
+	pos, snapshot, result := job.position, job.snapshot, &job.result
+	result.Error = ""
+	policy := e.config.Policy
+
+	// FULL closes everything in one tranche. PARTIAL re-checks the health after every tranche,
+	// stops once the position recovered, and closes the remainder after MaxTranches.
+	value, remaining := 0.0, pos.Snapshot().Size
+	for remaining > 0 {
+		size := remaining
+		if result.Tranches < policy.MaxTranches {
+			size = policy.trancheSize(snapshot.Size, remaining, snapshot.MarkPrice)
+		} else if policy.Mode == LiquidationPartial {
+			result.Escalated = true
+		}
+
+		closePrice := e.closeTranche(pos, snapshot, job.bankruptcyPrice, size, result)
+		pnl, marginReleased, err := e.positionMgr.LiquidatePositionAs(job.owner, pos, closePrice, size)
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		result.Tranches++
+		result.Size += size
+		result.PnL += pnl
+		result.MarginReleased += marginReleased
+		value += closePrice * size
+
+		remaining = pos.Snapshot().Size
+		if remaining > 0 && pos.ReleaseLiquidation(policy.TargetMarginRatioBuffer) {
+			result.Recovered = true
+			break
+		}
+	}
+	result.RemainingSize = remaining
+	if result.Size > 0 {
+		result.ClosePrice = value / result.Size
+	}
+}
+
+// commitAudit append the COMMITTED entry, retried without backoff: the settlement already happened
+// and the PREPARED entry stays behind if every attempt fails
+func (e *LiquidationEngine) commitAudit(store AuditStore, snapshot position.PositionSnapshot, result *LiquidationResult) error {
+	entry := auditEntry(result.AuditID, AuditCommitted, snapshot, result)
+	entry.Settlement = result.Settlement
+	entry.InsuranceFundDelta = result.Settlement.FundDeposit - result.Settlement.FundCovered
+	if account, err := e.account(snapshot.UserID); err == nil {
+		after := account.Snapshot()
+		entry.AccountAfter = &after
+	}
+	if result.ADL != nil {
+		entry.ADLReportID = result.ADL.ID
+	}
+
+	var err error
+	for attempt := 0; attempt <= e.config.MaxRetries; attempt++ {
+		if err = store.Append(entry); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// closeTranche price size of the position is closed at.
+//  1. order book first, at no worse than the bankruptcy price.
+//  2. backstop providers, by priority, take over the unfilled remainder at the discounted mark price.
+//  3. the insurance fund takes over what is left at the bankruptcy price,
+//     fills above it leave a surplus for the fund. without a book (or an empty one
+//     with MarkPriceFallback) the whole size is taken over at mark price.
+func (e *LiquidationEngine) closeTranche(pos *position.Position, snapshot position.PositionSnapshot, bankruptcyPrice, size float64, result *LiquidationResult) float64 {
+	value, filled := 0.0, 0.0
+	takeoverPrice, takeoverSource := snapshot.MarkPrice, FillSourceMarkPrice
+
+	book, empty := e.book(snapshot.Symbol), true
+	if book != nil {
+		opposite := orderbook.BUY
+		if snapshot.Side == position.SHORT {
+			opposite = orderbook.SELL
+		}
+		empty = len(book.Depth(opposite, 1)) == 0
+
+		if !empty {
+			trades := e.sendToBook(book, bankruptcyPrice, size, snapshot)
+			for _, trade := range trades {
+				filled += trade.Size
+				value += trade.Price * trade.Size
+
+				fill := LiquidationFill{Source: FillSourceBook, Price: trade.Price, Size: trade.Size, OrderID: trade.BuyOrderID, Counterparty: trade.BuyUserID}
+				if snapshot.Side == position.SHORT {
+					fill.OrderID, fill.Counterparty = trade.SellOrderID, trade.SellUserID
+				}
+				result.Fills = append(result.Fills, fill)
+			}
+			result.Trades = append(result.Trades, trades...)
+		}
+	}
+	result.BookFilledSize += filled
+	if size-filled <= 0 {
+		return value / size
+	}
+
+	if provider, price, ok := e.offerBackstop(snapshot, bankruptcyPrice, size-filled); ok {
+		result.BackstopSize += size - filled
+		result.BackstopProvider = provider
+		result.TakeoverPrice = price
+		result.Fills = append(result.Fills, LiquidationFill{Source: FillSourceBackstop, Price: price, Size: size - filled, Counterparty: provider})
+		value += (size - filled) * price
+		return value / size
+	}
+
+	if book != nil && bankruptcyPrice > 0 && (!empty || !e.config.MarkPriceFallback) {
+		takeoverPrice, takeoverSource = bankruptcyPrice, FillSourceInsuranceFund
+		result.FundSize += size - filled
+	}
+	result.TakeoverPrice = takeoverPrice
+	result.Fills = append(result.Fills, LiquidationFill{Source: takeoverSource, Price: takeoverPrice, Size: size - filled})
+
+	value += (size - filled) * takeoverPrice
+	return value / size
+}
+
+// sendToBook IOC liquidation order bounded by the bankruptcy price
+func (e *LiquidationEngine) sendToBook(book *orderbook.OrderBook, limitPrice, size float64, snapshot position.PositionSnapshot) []orderbook.Trade {
+	if limitPrice <= 0 {
+		return nil
+	}
+
+	side := orderbook.SELL
+	if snapshot.Side == position.SHORT {
+		side = orderbook.BUY
+	}
+	trades, err := book.PlaceIOC(&orderbook.Order{
+		ID:        common.GenerateShortUUID("liq"),
+		UserID:    LiquidationUserID,
+		Side:      side,
+		Price:     limitPrice,
+		Size:      size,
+		Timestamp: time.Now(),
+		Simulated: snapshot.Simulated,
+	})
+	if err != nil {
+		return nil // e.g. auction, taken over at mark price
+	}
+	return trades
+}
+
+func (e *LiquidationEngine) book(symbol string) *orderbook.OrderBook {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.books[symbol]
+}
+
+func (e *LiquidationEngine) record(result LiquidationResult) LiquidationResult {
+	result.Timestamp = time.Now()
+
+	e.mu.Lock()
+	e.results.Add(result)
+	handler := e.onResult
+	e.mu.Unlock()
+
+	if handler != nil {
+		handler(result)
+	}
+	return result
+}
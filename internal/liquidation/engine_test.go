@@ -0,0 +1,480 @@
+package liquidation
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var symbols = []string{"BTCUSDT", "ETHUSDT"}
+
+func newSystem(t *testing.T) (*position.PositionManager, *margin.MarginSystem) {
+	pm := position.NewPositionManager(symbols)
+	t.Cleanup(func() { _ = pm.Close() })
+	return pm, margin.NewMarginSystem(pm, nil)
+}
+
+func openLong(t *testing.T, pm *position.PositionManager, ms *margin.MarginSystem, userID string, size float64, leverage uint) *position.Position {
+	_, err := ms.CreateAccount(userID)
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit(userID, 1000))
+	pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", position.LONG, 50000, size, leverage)
+	require.NoError(t, err)
+	return pos
+}
+
+func TestPriceCrashLiquidatesEachPositionOnce(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{PollInterval: time.Millisecond, Workers: 16, MaxRetries: 1})
+
+	var weak, strong []*position.Position
+	for i := 0; i < 1000; i++ {
+		userID := fmt.Sprintf("user%04d", i)
+		if i%10 < 7 {
+			// 10x: 強平價 = 50000 - (5000 - 200) = 45200
+			weak = append(weak, openLong(t, pm, ms, userID, 0.01, 10))
+		} else {
+			strong = append(strong, openLong(t, pm, ms, userID, 0.01, 2))
+		}
+	}
+
+	require.NoError(t, engine.Start())
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+
+	// race the background loop with manual scans
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.RunOnce()
+		}()
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		return len(engine.Results()) == len(weak)
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, engine.Stop())
+	assert.Error(t, engine.Stop())
+
+	perPosition := make(map[string]int)
+	for _, result := range engine.Results() {
+		perPosition[result.PositionID]++
+		assert.Empty(t, result.Error)
+		assert.Equal(t, 1, result.Attempts)
+	}
+	for _, pos := range weak {
+		assert.Equal(t, 1, perPosition[pos.ID], "liquidated exactly once")
+		assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+	}
+	for _, pos := range strong {
+		assert.Zero(t, perPosition[pos.ID])
+		assert.Equal(t, position.PositionNormal, pos.Snapshot().Status)
+	}
+
+	// 每個倉位: IM 50, 虧損 49, 剩餘 1 進保險基金
+	assert.InDelta(t, float64(len(weak)), ms.InsuranceFund().Balance(), 1e-6)
+	oi, err := pm.GetOpenInterest("BTCUSDT")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.01*float64(len(strong)), oi, 1e-9)
+
+	account, err := ms.GetAccount(weak[0].UserID)
+	require.NoError(t, err)
+	assert.InDelta(t, 950.0, account.Balance, 1e-9)
+}
+
+func TestProcessAllLiquidationsBySymbol(t *testing.T) {
+	pm := position.NewPositionManager([]string{"BTCUSDT", "ETHUSDT", "SOLUSDT"})
+	t.Cleanup(func() { _ = pm.Close() })
+	ms := margin.NewMarginSystem(pm, nil)
+	engine := NewLiquidationEngine(pm, ms, nil)
+	prices := map[string]float64{"BTCUSDT": 50000, "ETHUSDT": 3000, "SOLUSDT": 100}
+	for i, symbol := range []string{"BTCUSDT", "BTCUSDT", "ETHUSDT", "SOLUSDT", "SOLUSDT", "SOLUSDT"} {
+		userID := fmt.Sprintf("user%d", i)
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 1000))
+		_, err = pm.OpenPosition(common.ISOLATED, userID, symbol, position.LONG, prices[symbol], 1000/prices[symbol], 10)
+		require.NoError(t, err)
+	}
+	for symbol, price := range prices {
+		_, err := pm.UpdateMarkPrices(symbol, price*0.9)
+		require.NoError(t, err)
+	}
+
+	candidates := pm.GetAllLiquidatablePositions()
+	results := engine.ProcessAllLiquidations(candidates)
+	require.Len(t, results, 3)
+	for symbol, symbolCandidates := range candidates {
+		require.Len(t, results[symbol], len(symbolCandidates), symbol)
+		for _, result := range results[symbol] {
+			assert.Equal(t, symbol, result.Symbol)
+			assert.Empty(t, result.Error)
+		}
+	}
+	assert.Len(t, results["SOLUSDT"], 3)
+}
+
+func TestSettlementRetryWithBackoff(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, MaxRetries: 3, RetryBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+
+	failures := 2
+	engine.settle = func(userID, positionID, symbol string, mode common.MarginMode, notional, marginReleased, pnl float64) (margin.LiquidationSettlement, error) {
+		if failures > 0 {
+			failures--
+			return margin.LiquidationSettlement{}, fmt.Errorf("ledger unavailable")
+		}
+		return ms.SettleLiquidation(userID, positionID, symbol, mode, notional, marginReleased, pnl)
+	}
+
+	pos := openLong(t, pm, ms, "user1", 1, 10)
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 45000)
+	require.NoError(t, err)
+
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	assert.Equal(t, 3, results[0].Attempts)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+
+	// nothing left to do
+	assert.Empty(t, engine.RunOnce())
+}
+
+func TestLiquidationThinBookSplit(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, nil)
+
+	book := orderbook.NewOrderBook("BTCUSDT")
+	_, err := book.PlaceOrder(&orderbook.Order{ID: "bid1", UserID: "mm", Side: orderbook.BUY, Price: 45150, Size: 0.3})
+	require.NoError(t, err)
+	// below the bankruptcy price 45000, never hit
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "bid2", UserID: "mm", Side: orderbook.BUY, Price: 44000, Size: 5})
+	require.NoError(t, err)
+	engine.SetOrderBook("BTCUSDT", book)
+
+	openLong(t, pm, ms, "user1", 1, 10)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	result := results[0]
+
+	// market absorbs 0.3, the fund takes over 0.7 at the bankruptcy price
+	assert.Equal(t, 0.3, result.BookFilledSize)
+	assert.InDelta(t, 0.7, result.FundSize, 1e-12)
+	assert.Equal(t, 45000.0, result.TakeoverPrice)
+	require.Len(t, result.Trades, 1)
+	assert.Equal(t, LiquidationUserID, result.Trades[0].SellUserID)
+	assert.InDelta(t, 0.3*45150+0.7*45000, result.ClosePrice, 1e-9)
+
+	// surplus of the book fills over the bankruptcy price: 0.3 * 150
+	assert.InDelta(t, -4955.0, result.PnL, 1e-9)
+	assert.InDelta(t, 45.0, result.Settlement.FundDeposit, 1e-9)
+	assert.Zero(t, result.Settlement.FundCovered)
+	assert.InDelta(t, 45.0, ms.InsuranceFund().Balance(), 1e-9)
+	assert.Equal(t, [][2]float64{{44000, 5}}, book.Depth(orderbook.BUY, 10))
+}
+
+func TestLiquidationEmptyBookFallback(t *testing.T) {
+	for _, fallback := range []bool{true, false} {
+		t.Run(fmt.Sprintf("fallback=%v", fallback), func(t *testing.T) {
+			pm, ms := newSystem(t)
+			engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, MarkPriceFallback: fallback})
+			engine.SetOrderBook("BTCUSDT", orderbook.NewOrderBook("BTCUSDT"))
+
+			openLong(t, pm, ms, "user1", 1, 10)
+			_, err := pm.UpdateMarkPrices("BTCUSDT", 45100)
+			require.NoError(t, err)
+
+			results := engine.RunOnce()
+			require.Len(t, results, 1)
+			result := results[0]
+			assert.Zero(t, result.BookFilledSize)
+
+			if fallback {
+				// taken over at mark price, 100 left for the fund
+				assert.Zero(t, result.FundSize)
+				assert.Equal(t, 45100.0, result.ClosePrice)
+				assert.InDelta(t, 100.0, result.Settlement.FundDeposit, 1e-9)
+			} else {
+				assert.Equal(t, 1.0, result.FundSize)
+				assert.Equal(t, 45000.0, result.ClosePrice)
+				assert.Zero(t, result.Settlement.FundDeposit)
+			}
+		})
+	}
+}
+
+func TestForceLiquidate(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1})
+	pos := openLong(t, pm, ms, "user1", 0.01, 2) // healthy, nowhere near its liquidation price
+
+	_, err := engine.ForceLiquidate("missing")
+	assert.ErrorContains(t, err, "does not exist")
+
+	result, err := engine.ForceLiquidate(pos.ID)
+	require.NoError(t, err)
+	assert.Empty(t, result.Error)
+	assert.Equal(t, "user1", result.UserID)
+	assert.Equal(t, 0.01, result.Size)
+	assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+	assert.Empty(t, engine.RunOnce(), "nothing liquidatable on its own")
+
+	_, err = engine.ForceLiquidate(pos.ID)
+	assert.ErrorContains(t, err, "closed or already being liquidated")
+}
+
+func TestResultsBounded(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, nil)
+	handled := 0
+	engine.OnResult(func(LiquidationResult) { handled++ })
+	for i := range MaxResults + 10 {
+		engine.record(LiquidationResult{PositionID: fmt.Sprint(i)})
+	}
+
+	assert.Equal(t, MaxResults+10, handled, "the handler sees every result")
+	results := engine.Results()
+	require.Len(t, results, MaxResults)
+	assert.Equal(t, "10", results[0].PositionID)
+	assert.Equal(t, fmt.Sprint(MaxResults+9), results[MaxResults-1].PositionID)
+}
+
+func TestAutoDeleveragingCoversFundShortfall(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1})
+	require.NoError(t, ms.InsuranceFund().Deposit("seed", 500))
+
+	var events []ADLEvent
+	engine.OnADL(func(event ADLEvent) {
+		events = append(events, event)
+	})
+
+	liquidated := openLong(t, pm, ms, "user1", 1, 10) // bankruptcy price 45000
+	openShort := func(userID string, size float64, leverage uint) *position.Position {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 10000))
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", position.SHORT, 50000, size, leverage)
+		require.NoError(t, err)
+		return pos
+	}
+	// ADL score: 12, 3, 0.48, 0.12
+	third := openShort("short3", 0.3, 2)
+	first := openShort("short1", 0.2, 10)
+	untouched := openShort("short4", 1, 1)
+	second := openShort("short2", 0.2, 5)
+
+	// closed at mark 44000: loss 6000 on 5000 margin, the fund pays 500 of the 1000 shortfall
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 44000)
+	require.NoError(t, err)
+
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	result := results[0]
+	assert.Equal(t, liquidated.ID, result.PositionID)
+	assert.Equal(t, 500.0, result.Settlement.FundCovered)
+	assert.Equal(t, 500.0, result.Settlement.Uncovered)
+	assert.Zero(t, ms.InsuranceFund().Balance())
+
+	// the rest: 1000 per unit deleveraged at 45000 instead of 44000 -> 0.5 across three shorts
+	report := result.ADL
+	require.NotNil(t, report)
+	assert.Equal(t, []ADLReport{*report}, engine.ADLReports())
+	assert.Equal(t, position.SHORT, report.Side)
+	assert.Equal(t, 45000.0, report.BankruptcyPrice)
+	assert.Equal(t, 500.0, report.Shortfall)
+	assert.Equal(t, 500.0, report.Covered)
+	assert.Zero(t, report.Uncovered)
+
+	require.Len(t, report.Entries, 3)
+	expected := []struct {
+		pos       *position.Position
+		size      float64
+		covered   float64
+		remaining float64
+	}{
+		{first, 0.2, 200, 0},
+		{second, 0.2, 200, 0},
+		{third, 0.1, 100, 0.2},
+	}
+	for i, want := range expected {
+		entry := report.Entries[i]
+		assert.Equal(t, want.pos.ID, entry.PositionID)
+		assert.Equal(t, want.size, entry.Size)
+		assert.Equal(t, want.covered, entry.Covered)
+		assert.Equal(t, 45000.0, entry.Price)
+		assert.InDelta(t, 5000*want.size, entry.PnL, 1e-9)
+		assert.InDelta(t, want.remaining, entry.RemainingSize, 1e-12)
+	}
+
+	assert.Equal(t, position.PositionClosed, first.Snapshot().Status)
+	assert.Equal(t, position.PositionClosed, second.Snapshot().Status)
+	assert.InDelta(t, 0.2, third.Snapshot().Size, 1e-12)
+	assert.Equal(t, 1.0, untouched.Snapshot().Size)
+
+	// realized at the bankruptcy price
+	account, err := ms.GetAccount("short1")
+	require.NoError(t, err)
+	assert.InDelta(t, 11000.0, account.Balance, 1e-9)
+
+	require.Len(t, events, 3)
+	for i, event := range events {
+		assert.Equal(t, report.ID, event.ReportID)
+		assert.Equal(t, report.Entries[i].UserID, event.UserID)
+		assert.Equal(t, report.Entries[i].Size, event.Size)
+	}
+
+	_, short, err := pm.GetOpenInterestBySide("BTCUSDT")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.2, short, 1e-9)
+}
+
+func TestSocializedLossInsteadOfADL(t *testing.T) {
+	pm, ms := newSystem(t)
+	engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, Shortfall: ShortfallSocialized})
+	require.NoError(t, ms.InsuranceFund().Deposit("seed", 500))
+
+	liquidated := openLong(t, pm, ms, "user1", 1, 10) // bankruptcy price 45000
+	openShort := func(userID string, price, size float64) *position.Position {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 10000))
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", position.SHORT, price, size, 5)
+		require.NoError(t, err)
+		return pos
+	}
+	// profit at 44000: 1200, 3800, losing -400
+	first := openShort("short1", 50000, 0.2)
+	second := openShort("short2", 47800, 1)
+	losing := openShort("short3", 40000, 0.1)
+
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 44000)
+	require.NoError(t, err)
+
+	results := engine.RunOnce()
+	require.Len(t, results, 1)
+	result := results[0]
+	assert.Equal(t, liquidated.ID, result.PositionID)
+	assert.Equal(t, 500.0, result.Settlement.Uncovered)
+	assert.Nil(t, result.ADL)
+	assert.Empty(t, engine.ADLReports())
+
+	loss := result.SocializedLoss
+	require.NotNil(t, loss)
+	assert.Equal(t, liquidated.ID, loss.LiquidatedPositionID)
+	assert.Equal(t, position.SHORT, loss.Side)
+	assert.InDelta(t, 5000.0, loss.TotalProfit, 1e-9)
+	assert.InDelta(t, 0.1, loss.HaircutRatio, 1e-12)
+	require.Len(t, loss.Haircuts, 2)
+	sum := 0.0
+	for _, haircut := range loss.Haircuts {
+		assert.NotEqual(t, losing.ID, haircut.PositionID)
+		sum += haircut.Amount
+	}
+	assert.InDelta(t, 500.0, sum, 1e-9)
+
+	// nobody is deleveraged
+	assert.Equal(t, 0.2, first.Snapshot().Size)
+	assert.Equal(t, 1.0, second.Snapshot().Size)
+
+	// charged at settlement, the losing short keeps its balance
+	charged := ms.ApplyPendingHaircuts()
+	assert.InDelta(t, 120.0, charged["short1"], 1e-9)
+	assert.InDelta(t, 380.0, charged["short2"], 1e-9)
+	assert.NotContains(t, charged, "short3")
+	account, err := ms.GetAccount("short3")
+	require.NoError(t, err)
+	assert.Equal(t, 10000.0, account.Balance)
+}
+
+func TestPartialLiquidationPolicy(t *testing.T) {
+	// 240 @ 50000 5x: notional 12M sits in the 10% maintenance tier, liquidatable below 45000.
+	// at 44900 equity/notional stays 4900/44900 = 10.9%, so only leaving the 10% tier restores the
+	// position: below 10M notional (≈ 222.7) the tier drops to 5%.
+	run := func(t *testing.T, policy LiquidationPolicy) (LiquidationResult, *position.Position, *margin.MarginSystem) {
+		pm, ms := newSystem(t)
+		engine := NewLiquidationEngine(pm, ms, &Config{Workers: 1, Policy: policy})
+
+		_, err := ms.CreateAccount("whale")
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit("whale", 3_000_000))
+		pos, err := pm.OpenPosition(common.ISOLATED, "whale", "BTCUSDT", position.LONG, 50000, 240, 5)
+		require.NoError(t, err)
+
+		_, err = pm.UpdateMarkPrices("BTCUSDT", 44900)
+		require.NoError(t, err)
+
+		results := engine.RunOnce()
+		require.Len(t, results, 1)
+		assert.Empty(t, results[0].Error)
+		return results[0], pos, ms
+	}
+	trancheSize := 500_000 / 44900.0
+
+	full, fullPos, fullMs := run(t, LiquidationPolicy{Mode: LiquidationFull})
+	assert.Equal(t, 1, full.Tranches)
+	assert.Equal(t, 240.0, full.Size)
+	assert.Zero(t, full.RemainingSize)
+	assert.Equal(t, position.PositionClosed, fullPos.Snapshot().Status)
+
+	partial, partialPos, partialMs := run(t, LiquidationPolicy{
+		Mode:                    LiquidationPartial,
+		TargetMarginRatioBuffer: 1,
+		MaxTranches:             5,
+		MinTrancheNotional:      500_000,
+	})
+	// 1st tranche: 228.9 left, still 10% tier. 2nd tranche: 217.7 left, 5% tier -> recovered
+	assert.Equal(t, 2, partial.Tranches)
+	assert.True(t, partial.Recovered)
+	assert.False(t, partial.Escalated)
+	assert.InDelta(t, 2*trancheSize, partial.Size, 1e-9)
+	assert.InDelta(t, 240-2*trancheSize, partial.RemainingSize, 1e-9)
+
+	snapshot := partialPos.Snapshot()
+	assert.Equal(t, position.PositionNormal, snapshot.Status)
+	assert.InDelta(t, partial.RemainingSize, snapshot.Size, 1e-9)
+	assert.False(t, partialPos.IsLiquidatable())
+
+	// the same underwater position loses 2.4M margin in full mode, only the closed part in partial mode
+	assert.InDelta(t, -5100*240.0, full.PnL, 1e-6)
+	assert.InDelta(t, -5100*partial.Size, partial.PnL, 1e-6)
+	assert.InDelta(t, 2_400_000.0, full.Settlement.AccountCharge, 1e-6)
+	assert.InDelta(t, 10000*partial.Size, partial.Settlement.AccountCharge, 1e-6)
+	assert.Less(t, partialMs.InsuranceFund().Balance(), fullMs.InsuranceFund().Balance())
+
+	fullAccount, err := fullMs.GetAccount("whale")
+	require.NoError(t, err)
+	partialAccount, err := partialMs.GetAccount("whale")
+	require.NoError(t, err)
+	assert.Greater(t, partialAccount.Balance, fullAccount.Balance)
+
+	t.Run("escalates after MaxTranches", func(t *testing.T) {
+		escalated, pos, _ := run(t, LiquidationPolicy{
+			Mode:                    LiquidationPartial,
+			TargetMarginRatioBuffer: 1,
+			MaxTranches:             1,
+			MinTrancheNotional:      500_000,
+		})
+		assert.Equal(t, 2, escalated.Tranches)
+		assert.True(t, escalated.Escalated)
+		assert.False(t, escalated.Recovered)
+		assert.InDelta(t, 240.0, escalated.Size, 1e-9)
+		assert.Zero(t, escalated.RemainingSize)
+		assert.Equal(t, position.PositionClosed, pos.Snapshot().Status)
+	})
+}
@@ -0,0 +1,28 @@
+package liquidation
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MinStallTimeout shortest silence of the poll loop reported as stalled, whatever the PollInterval
+const MinStallTimeout = 5 * time.Second
+
+// CheckHealth implements health.Contributor: an error when the background loop runs but has not
+// completed a poll in 10 PollIntervals (at least MinStallTimeout), e.g. wedged on a settlement.
+// a stopped engine is healthy, the liquidations then run with the mark price updates
+func (e *LiquidationEngine) CheckHealth(context.Context) error {
+	e.runMu.Lock()
+	running := e.cancel != nil
+	e.runMu.Unlock()
+	if !running {
+		return nil
+	}
+
+	stallAfter := max(10*e.config.PollInterval, MinStallTimeout)
+	if since := time.Since(time.Unix(0, e.lastPass.Load())); since > stallAfter {
+		return fmt.Errorf("liquidation loop stalled: no poll for %s", since.Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package liquidation
+
+import (
+	"frizo/futures_engine/internal/metrics"
+	"time"
+)
+
+// metrics of the liquidation engine, labelled by symbol except the fund balance.
+// liquidations per minute is the rate of MetricLiquidations
+const (
+	MetricLiquidations         = "liquidations_total"
+	MetricLiquidatedNotional   = "liquidated_notional_total"
+	MetricLiquidationLatency   = "liquidation_settlement_seconds" // claim of a liquidatable position to its settlement
+	MetricLiquidationFailures  = "liquidation_failures_total"     // failed passes, re-queued or dead-lettered
+	MetricADLEvents            = "adl_events_total"               // deleveraged counterparties
+	MetricInsuranceFundBalance = "insurance_fund_balance"
+)
+
+// SetMetrics registry of the engine's metrics, nil means metrics.Nop
+func (e *LiquidationEngine) SetMetrics(registry metrics.Registry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metrics = metrics.OrNop(registry)
+}
+
+func (e *LiquidationEngine) metricsRegistry() metrics.Registry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.metrics
+}
+
+// observeSettled a pass that settled
+func (e *LiquidationEngine) observeSettled(job *liquidationJob, result LiquidationResult) {
+	registry := e.metricsRegistry()
+	labels := metrics.Labels{"symbol": result.Symbol}
+
+	registry.AddCounter(MetricLiquidations, 1, labels)
+	registry.AddCounter(MetricLiquidatedNotional, result.ClosePrice*result.Size, labels)
+	registry.Observe(MetricLiquidationLatency, time.Since(job.detectedAt).Seconds(), labels)
+	if result.ADL != nil {
+		registry.AddCounter(MetricADLEvents, float64(len(result.ADL.Entries)), labels)
+	}
+	if e.fundBalance != nil {
+		registry.SetGauge(MetricInsuranceFundBalance, e.fundBalance(), nil)
+	}
+}
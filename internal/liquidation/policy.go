@@ -0,0 +1,68 @@
+package liquidation
+
+// LiquidationMode full close or step-wise tranches
+type LiquidationMode int
+
+const (
+	LiquidationFull    LiquidationMode = iota // close the whole position in one shot
+	LiquidationPartial                        // close in tranches, stop once the position is healthy again
+)
+
+func (m LiquidationMode) String() string {
+	switch m {
+	case LiquidationFull:
+		return "FULL"
+	case LiquidationPartial:
+		return "PARTIAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ShortfallMode how a loss the insurance fund cannot cover is absorbed
+type ShortfallMode int
+
+const (
+	ShortfallADL        ShortfallMode = iota // deleverage the opposite side at the bankruptcy price
+	ShortfallSocialized                      // haircut the opposite side's profit pro-rata
+)
+
+func (m ShortfallMode) String() string {
+	switch m {
+	case ShortfallADL:
+		return "ADL"
+	case ShortfallSocialized:
+		return "SOCIALIZED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// LiquidationPolicy (部分強平策略)
+type LiquidationPolicy struct {
+	Mode LiquidationMode
+
+	// healthy again once margin ratio >= maintenance ratio + buffer (percentage points)
+	TargetMarginRatioBuffer float64
+	// partial tranches before escalating to a full close of the remainder
+	MaxTranches int
+	// notional at mark price closed per tranche, Size/MaxTranches when unset.
+	// a remainder smaller than this is closed together with the tranche.
+	MinTrancheNotional float64
+}
+
+// trancheSize size of the next tranche for a position of remaining size, originally size
+func (p LiquidationPolicy) trancheSize(size, remaining, markPrice float64) float64 {
+	if p.Mode != LiquidationPartial {
+		return remaining
+	}
+
+	tranche := size / float64(max(p.MaxTranches, 1))
+	if p.MinTrancheNotional > 0 && markPrice > 0 {
+		tranche = p.MinTrancheNotional / markPrice
+	}
+	if tranche >= remaining || (remaining-tranche)*markPrice < p.MinTrancheNotional {
+		return remaining
+	}
+	return tranche
+}
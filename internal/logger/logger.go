@@ -1,9 +1,12 @@
 package logger
 
 import (
+	"context"
+	"frizo/futures_engine/internal/common"
 	"log/slog"
 	"os"
 	"strings"
+	"sync/atomic"
 )
 
 // Logger wraps slog.Logger with additional functionality.
@@ -46,15 +49,39 @@ func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 	return &Logger{Logger: l.Logger.With(args...)}
 }
 
+// WithContext returns a new logger with the request ID and user ID carried by ctx, if any.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	var args []any
+	if requestID := common.RequestIDFrom(ctx); requestID != "" {
+		args = append(args, "request_id", requestID)
+	}
+	if userID := common.UserIDFrom(ctx); userID != "" {
+		args = append(args, "user_id", userID)
+	}
+	if len(args) == 0 {
+		return l
+	}
+	return &Logger{Logger: l.Logger.With(args...)}
+}
+
+// FromContext returns the default logger bound to the request ID and user ID of ctx.
+func FromContext(ctx context.Context) *Logger {
+	return Default().WithContext(ctx)
+}
+
 // GetDefault returns a default logger instance.
-var defaultLogger = New("info")
+var defaultLogger atomic.Pointer[Logger]
+
+func init() {
+	defaultLogger.Store(New("info"))
+}
 
 // Default returns the default logger.
 func Default() *Logger {
-	return defaultLogger
+	return defaultLogger.Load()
 }
 
 // SetDefault sets the default logger.
 func SetDefault(logger *Logger) {
-	defaultLogger = logger
-}
\ No newline at end of file
+	defaultLogger.Store(logger)
+}
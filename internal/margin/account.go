@@ -1,7 +1,9 @@
 package margin
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 )
@@ -9,6 +11,7 @@ import (
 // MarginAccount (保證金帳戶)
 type MarginAccount struct {
 	UserID string
+	Status AccountStatus // merged accounts refuse new operations
 
 	// Balance etc.
 	Balance          float64 // total balance
@@ -28,9 +31,52 @@ type MarginAccount struct {
 
 	UpdatedAt time.Time
 
+	balanceCap float64 // 餘額上限 (e.g. demo account), 0 means unlimited
+
+	AccruedRebates float64 // maker 返佣 accrued but not yet paid to AvailableBalance
+
+	tradeHistory []TradeRecord // ring of the last MaxTradeHistory trades
+	tradeHead    int           // oldest trade once the ring is full
+
 	mu sync.RWMutex
 }
 
+var (
+	ErrBalanceCapExceeded   = errors.New("balance cap exceeded")
+	ErrAccountNotFound      = errors.New("account not found")
+	ErrAccountAlreadyExists = errors.New("account already exists")
+	ErrAccountInUse         = errors.New("account in use")
+	ErrAccountMerged        = errors.New("account merged")
+	ErrInsufficientMargin   = errors.New("insufficient margin")
+)
+
+// AccountStatus active or merged into another account
+type AccountStatus int
+
+const (
+	AccountActive AccountStatus = iota
+	AccountMerged
+)
+
+func (s AccountStatus) String() string {
+	switch s {
+	case AccountActive:
+		return "active"
+	case AccountMerged:
+		return "merged"
+	default:
+		return "unknown"
+	}
+}
+
+// checkActive no lock
+func (ma *MarginAccount) checkActive() error {
+	if ma.Status == AccountMerged {
+		return fmt.Errorf("%w: %s", ErrAccountMerged, ma.UserID)
+	}
+	return nil
+}
+
 func NewMarginAccount(userID string) *MarginAccount {
 	return &MarginAccount{
 		UserID:    userID,
@@ -38,16 +84,22 @@ func NewMarginAccount(userID string) *MarginAccount {
 	}
 }
 
+// frozenDust rounding error tolerated when the last of the frozen balance is unfrozen
+const frozenDust = 1e-9
+
 func (a *MarginAccount) FreezeOrderMargin(amount float64) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if err := a.checkActive(); err != nil {
+		return err
+	}
 	if amount <= 0 {
 		return fmt.Errorf("amount must be greater than zero")
 	}
 
 	if amount > a.AvailableBalance {
-		return fmt.Errorf("available balance not enough")
+		return fmt.Errorf("%w: available balance not enough", ErrInsufficientMargin)
 	}
 
 	a.AvailableBalance -= amount
@@ -70,7 +122,10 @@ func (a *MarginAccount) UnFreezeOrderMargin(amount float64) error {
 	}
 
 	if amount > a.FrozenBalance {
-		return fmt.Errorf("frozen balance not enough to unfreeze")
+		if amount-a.FrozenBalance > frozenDust {
+			return fmt.Errorf("frozen balance not enough to unfreeze")
+		}
+		amount = a.FrozenBalance // rounding left by partial releases of reservations
 	}
 
 	a.AvailableBalance += amount
@@ -102,6 +157,7 @@ func (ma *MarginAccount) UpdateMarginAndPnl(margin float64, pnl float64) {
 	if availableBalance < 0 {
 		availableBalance = 0
 	}
+	ma.AvailableBalance = availableBalance
 
 	// 計算 margin ratio
 	if margin > 0 {
@@ -121,6 +177,12 @@ func (ma *MarginAccount) GetAccountEquity() float64 {
 	return ma.Balance + ma.UnrealizedPnL
 }
 
+func (ma *MarginAccount) getBalance() float64 {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	return ma.Balance
+}
+
 func (ma *MarginAccount) GetUsedMargin() float64 {
 	ma.mu.RLock()
 	defer ma.mu.RUnlock()
@@ -128,14 +190,43 @@ func (ma *MarginAccount) GetUsedMargin() float64 {
 	return ma.PositionMargin + ma.OrderMargin
 }
 
-// Deposit
-func (ma *MarginAccount) Deposit(amount float64) {
+// Deposit return ErrBalanceCapExceeded if the deposit would push Balance over the cap
+func (ma *MarginAccount) Deposit(amount float64) error {
 	ma.mu.Lock()
 	defer ma.mu.Unlock()
 
+	if err := ma.checkActive(); err != nil {
+		return err
+	}
+	if ma.balanceCap > 0 && ma.Balance+amount > ma.balanceCap {
+		return fmt.Errorf("%w: balance %.2f + deposit %.2f > cap %.2f",
+			ErrBalanceCapExceeded, ma.Balance, amount, ma.balanceCap)
+	}
+
 	ma.Balance += amount
 	ma.AvailableBalance += amount
 	ma.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// SetBalanceCap set max balance of the account, 0 means unlimited
+func (ma *MarginAccount) SetBalanceCap(limit float64) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.balanceCap = limit
+}
+
+// GetAvailableForDeposit max amount that can still be deposited, +Inf when unlimited
+func (ma *MarginAccount) GetAvailableForDeposit() float64 {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	if ma.balanceCap <= 0 {
+		return math.Inf(1)
+	}
+	return max(0, ma.balanceCap-ma.Balance)
 }
 
 // Withdraw
@@ -143,6 +234,9 @@ func (ma *MarginAccount) Withdraw(amount float64) error {
 	ma.mu.Lock()
 	defer ma.mu.Unlock()
 
+	if err := ma.checkActive(); err != nil {
+		return err
+	}
 	if ma.AvailableBalance < amount {
 		return fmt.Errorf("insufficient available balance: %.2f < %.2f",
 			ma.AvailableBalance, amount)
@@ -161,6 +255,114 @@ func (ma *MarginAccount) Withdraw(amount float64) error {
 	return nil
 }
 
+// SettleReduce credit a reducing fill in one step: released margin and realized PnL go back to
+// AvailableBalance immediately, fee is charged in the same update
+func (ma *MarginAccount) SettleReduce(marginReleased, pnl, fee float64) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.Balance += pnl - fee
+	ma.RealizedPnL += pnl
+	ma.AvailableBalance = max(ma.AvailableBalance+marginReleased+pnl-fee, 0)
+	ma.PositionMargin = max(ma.PositionMargin-marginReleased, 0)
+	ma.UpdatedAt = time.Now()
+}
+
+// ApplyHaircut (分攤扣減) charge a socialized loss share against realized PnL
+func (ma *MarginAccount) ApplyHaircut(amount float64) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.Balance -= amount
+	ma.RealizedPnL -= amount
+	ma.AvailableBalance = max(ma.AvailableBalance-amount, 0)
+	ma.UpdatedAt = time.Now()
+}
+
+// AccrueRebate add a maker rebate to the ledger, return the accrued total
+func (ma *MarginAccount) AccrueRebate(amount float64) (float64, error) {
+	if amount <= 0 {
+		return 0, fmt.Errorf("rebate must be greater than zero")
+	}
+
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	if err := ma.checkActive(); err != nil {
+		return 0, err
+	}
+	ma.AccruedRebates += amount
+	ma.UpdatedAt = time.Now()
+	return ma.AccruedRebates, nil
+}
+
+// GetAccruedRebates
+func (ma *MarginAccount) GetAccruedRebates() float64 {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	return ma.AccruedRebates
+}
+
+// PayoutRebates move all accrued rebates into Balance and AvailableBalance, return the amount paid
+func (ma *MarginAccount) PayoutRebates() float64 {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	paid := ma.AccruedRebates
+	if paid <= 0 {
+		return 0
+	}
+	ma.Balance += paid
+	ma.AvailableBalance += paid
+	ma.AccruedRebates = 0
+	ma.UpdatedAt = time.Now()
+
+	return paid
+}
+
+// SettleLiquidation charge the liquidation loss, return the part of the loss the account could not pay
+func (ma *MarginAccount) SettleLiquidation(marginReleased, charge float64) float64 {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.Balance -= charge
+	ma.RealizedPnL -= charge
+	ma.PositionMargin = max(ma.PositionMargin-marginReleased, 0)
+
+	shortfall := 0.0
+	if ma.Balance < 0 {
+		shortfall = -ma.Balance
+		ma.Balance = 0
+	}
+	ma.AvailableBalance = max(ma.Balance-ma.PositionMargin-ma.OrderMargin, 0)
+	ma.UpdatedAt = time.Now()
+
+	return shortfall
+}
+
+// ChargeLiquidationFee charge up to amount from the balance, return the charged part
+func (ma *MarginAccount) ChargeLiquidationFee(amount float64) float64 {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	charged := max(min(amount, ma.Balance), 0)
+	ma.Balance -= charged
+	ma.RealizedPnL -= charged
+	ma.AvailableBalance = max(ma.Balance-ma.PositionMargin-ma.OrderMargin, 0)
+	ma.UpdatedAt = time.Now()
+	return charged
+}
+
+// SettleFunding apply a signed funding payment, positive is received
+func (ma *MarginAccount) SettleFunding(amount float64) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.Balance += amount
+	ma.AvailableBalance = max(ma.AvailableBalance+amount, 0)
+	ma.UpdatedAt = time.Now()
+}
+
 func (ma *MarginAccount) GetSummary() (map[string]interface{}, error) {
 	ma.mu.RLock()
 	defer ma.mu.RUnlock()
@@ -169,12 +371,14 @@ func (ma *MarginAccount) GetSummary() (map[string]interface{}, error) {
 
 	summary := map[string]interface{}{
 		"user_id":           ma.UserID,
+		"status":            ma.Status.String(),
 		"balance":           ma.Balance,
 		"available_balance": ma.AvailableBalance,
 		"position_margin":   ma.PositionMargin,
 		"order_margin":      ma.OrderMargin,
 		"unrealized_pnl":    ma.UnrealizedPnL,
 		"realized_pnl":      ma.RealizedPnL,
+		"accrued_rebates":   ma.AccruedRebates,
 		"account_equity":    accountEquity,
 		"margin_ratio":      ma.MarginRatio,
 		"margin_level":      ma.MarginLevel,
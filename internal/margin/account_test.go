@@ -0,0 +1,82 @@
+package margin
+
+import (
+	"context"
+	"frizo/futures_engine/internal/position"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalanceCap(t *testing.T) {
+	account := NewMarginAccount("demo")
+	assert.True(t, math.IsInf(account.GetAvailableForDeposit(), 1), "no cap by default")
+
+	account.SetBalanceCap(100000)
+	require.NoError(t, account.Deposit(80000))
+	assert.Equal(t, 20000.0, account.GetAvailableForDeposit())
+
+	// 超過上限整筆拒絕, 不入帳
+	err := account.Deposit(30000)
+	assert.ErrorIs(t, err, ErrBalanceCapExceeded)
+	assert.Equal(t, 80000.0, account.Balance)
+	assert.Equal(t, 80000.0, account.AvailableBalance)
+
+	// 只接受剩餘額度
+	require.NoError(t, account.Deposit(account.GetAvailableForDeposit()))
+	assert.Equal(t, 100000.0, account.Balance)
+	assert.Equal(t, 0.0, account.GetAvailableForDeposit())
+
+	// 取消上限
+	account.SetBalanceCap(0)
+	assert.NoError(t, account.Deposit(1))
+}
+
+func TestMergeAccounts(t *testing.T) {
+	ms := NewMarginSystem(position.NewPositionManager(symbols), nil)
+
+	for userID, deposit := range map[string]float64{"live": 5000, "demo": 3000} {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, deposit))
+	}
+	ctx := context.Background()
+	require.NoError(t, ms.ReserveMarginForOrder(ctx, "demo", "order1", 200))
+	require.NoError(t, ms.ReserveMarginForOrder(ctx, "demo", "order2", 300))
+	require.NoError(t, ms.ReserveMarginForOrder(ctx, "live", "order3", 100))
+	demo, _ := ms.GetAccount("demo")
+	live, _ := ms.GetAccount("live")
+	demo.RecordTrade(TradeRecord{TradeID: "demo-trade", Symbol: "BTCUSDT", RealizedPnL: 50})
+	live.RecordTrade(TradeRecord{TradeID: "live-trade", Symbol: "BTCUSDT"})
+
+	require.NoError(t, ms.MergeAccounts("live", "demo"))
+
+	merged := live.Snapshot()
+	assert.Equal(t, 8000.0, merged.Balance)
+	assert.Equal(t, 7400.0, merged.AvailableBalance)
+	assert.Equal(t, 600.0, merged.FrozenBalance)
+	assert.Equal(t, 600.0, merged.OrderMargin)
+	reservations := ms.GetPendingReservations("live")
+	require.Len(t, reservations, 3, "every frozen entry moved")
+	for _, reservation := range reservations {
+		assert.Equal(t, "live", reservation.UserID)
+	}
+	assert.Empty(t, ms.GetPendingReservations("demo"))
+	history := live.TradeHistory()
+	require.Len(t, history, 2)
+	assert.Equal(t, "demo-trade", history[1].TradeID)
+
+	// the source is done
+	assert.Equal(t, AccountMerged, demo.Snapshot().Status)
+	assert.Zero(t, demo.Snapshot().Balance)
+	assert.ErrorIs(t, ms.Deposit("demo", 100), ErrAccountMerged)
+	assert.ErrorIs(t, ms.Withdraw("demo", 1), ErrAccountMerged)
+	assert.ErrorIs(t, ms.FreezeOrderMargin("demo", 1), ErrAccountMerged)
+	assert.ErrorIs(t, ms.MergeAccounts("live", "demo"), ErrAccountMerged)
+
+	// merged reservations release against the target
+	require.NoError(t, ms.ReleaseMarginReservation("live", "order1"))
+	assert.Equal(t, 400.0, live.Snapshot().FrozenBalance)
+}
@@ -0,0 +1,78 @@
+package margin
+
+import "time"
+
+// MaxAuditLogEntries margin audit entries kept in memory, the oldest are dropped beyond it
+const MaxAuditLogEntries = 1000
+
+// margin audit operations
+const (
+	AuditDeposit           = "deposit"
+	AuditWithdraw          = "withdraw"
+	AuditFreezeOrderMargin = "freeze_order_margin"
+	AuditUnfreezeMargin    = "unfreeze_order_margin"
+	AuditSettleReduce      = "settle_reduce"
+	AuditSettleLiquidation = "settle_liquidation"
+	AuditMerge             = "merge"
+)
+
+// MarginAuditEntry (保證金稽核) one balance operation, kept in process for debugging
+type MarginAuditEntry struct {
+	Timestamp     time.Time `json:"timestamp"`
+	UserID        string    `json:"user_id"`
+	Operation     string    `json:"operation"`
+	Amount        float64   `json:"amount"`
+	BalanceBefore float64   `json:"balance_before"`
+	BalanceAfter  float64   `json:"balance_after"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// AuditLog the last MaxAuditLogEntries operations, oldest first
+func (ms *MarginSystem) AuditLog() []MarginAuditEntry {
+	ms.auditMu.Lock()
+	defer ms.auditMu.Unlock()
+	return ms.auditLog.Items()
+}
+
+// GetRecentAuditLog up to limit operations, newest first. limit <= 0 returns every kept entry
+func (ms *MarginSystem) GetRecentAuditLog(limit int) []MarginAuditEntry {
+	ms.auditMu.Lock()
+	defer ms.auditMu.Unlock()
+	return ms.auditLog.Recent(limit)
+}
+
+// audit record an operation of the account, balanceBefore read before it ran. account may be nil
+func (ms *MarginSystem) audit(account *MarginAccount, userID, operation string, amount, balanceBefore float64, err error) {
+	entry := MarginAuditEntry{
+		Timestamp:     time.Now(),
+		UserID:        userID,
+		Operation:     operation,
+		Amount:        amount,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  balanceBefore,
+	}
+	if account != nil {
+		entry.BalanceAfter = account.getBalance()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	ms.auditMu.Lock()
+	ms.auditLog.Add(entry)
+	handlers := ms.onAudit
+	ms.auditMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(entry)
+	}
+}
+
+// OnAuditEntry call fn with every audit entry from now on, failed operations included. fn runs on the
+// path of the operation, possibly under the account locks: it must be quick and must not call back
+// into the margin system
+func (ms *MarginSystem) OnAuditEntry(fn func(MarginAuditEntry)) {
+	ms.auditMu.Lock()
+	defer ms.auditMu.Unlock()
+	ms.onAudit = append(ms.onAudit[:len(ms.onAudit):len(ms.onAudit)], fn)
+}
@@ -1,5 +1,7 @@
 package margin
 
+import "time"
+
 // MarginConfig
 type MarginConfig struct {
 	DefaultInitialMarginRate     float64 // 默認初始保證金率
@@ -7,4 +9,46 @@ type MarginConfig struct {
 	MinTransferAmount            float64 // 最小劃轉金額
 	AutoBorrowEnabled            bool    // 是否自動借貸
 	NegativeBalanceProtection    bool    // 負餘額保護
+	MaxNotional                  float64 // 單一倉位最大名義價值, 0 means unlimited
+	CrossRiskThreshold           float64 // 全倉跨品種風險門檻 (equity / maintenance margin), 0 means 1.1
+	LiquidationFeeRate           float64 // 強平費率 of the closed notional, 0 means the whole remaining isolated margin goes to the insurance fund
+
+	// maker rebate (返佣) batching, both 0 means pay out on every accrual
+	RebatePayoutThreshold float64       // pay out once accrued rebates reach this amount
+	RebatePayoutInterval  time.Duration // period of RunRebatePayout
+
+	DailyLossLimit     float64 // net realized loss per UTC day reported by RiskReport and CheckDailyLossLimit, 0 means no limit
+	ConcentrationLimit float64 // position value of one symbol per unit of equity RiskReport warns above, 0 means DefaultConcentrationLimit
+
+	ReservationTTL time.Duration // order margin reservations expire after it, 0 means DefaultReservationTTL
+
+	DepositSafetyFactor float64 // target equity per unit of maintenance margin of GetRequiredDepositToAvoidLiquidation, 0 means position.DefaultDepositSafetyFactor (1.2)
+}
+
+// DefaultMarginConfig config of NewMarginSystem(pm, nil)
+var DefaultMarginConfig = MarginConfig{
+	DefaultInitialMarginRate:     0.10, // 10%
+	DefaultMaintenanceMarginRate: 0.05, // 5%
+	MinTransferAmount:            1.0,
+	NegativeBalanceProtection:    true,
+}
+
+// SetConfig replace the config, e.g. on a configuration reload. the new rates and limits apply to what
+// is computed from now on: open positions, reservations and settled fills keep their amounts
+func (ms *MarginSystem) SetConfig(config MarginConfig) {
+	ms.configMu.Lock()
+	defer ms.configMu.Unlock()
+	ms.config = &config
+}
+
+// Config copy of the config in force
+func (ms *MarginSystem) Config() MarginConfig {
+	return *ms.currentConfig()
+}
+
+// currentConfig the config in force, never modified once set
+func (ms *MarginSystem) currentConfig() *MarginConfig {
+	ms.configMu.RLock()
+	defer ms.configMu.RUnlock()
+	return ms.config
 }
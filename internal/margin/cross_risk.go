@@ -0,0 +1,93 @@
+package margin
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+)
+
+// DefaultCrossRiskThreshold global margin ratio below which a cross account is at risk
+const DefaultCrossRiskThreshold = 1.1
+
+// CrossSymbolRisk (全倉跨品種風險) risk of all cross positions of one account, backed by one equity
+type CrossSymbolRisk struct {
+	TotalEquity             float64 `json:"total_equity"` // balance + unrealized PnL of cross positions
+	TotalPositionValue      float64 `json:"total_position_value"`
+	TotalMaintenanceMargin  float64 `json:"total_maintenance_margin"`
+	GlobalMarginRatio       float64 `json:"global_margin_ratio"` // equity / maintenance margin
+	IsAtRisk                bool    `json:"is_at_risk"`
+	LargestExposureSymbol   string  `json:"largest_exposure_symbol"`
+	LargestExposureFraction float64 `json:"largest_exposure_fraction"` // of TotalPositionValue
+}
+
+// GetCrossSymbolRisk computed from one snapshot of the user's cross positions and balance,
+// at risk below MarginConfig.CrossRiskThreshold (default 1.1). zero value for unknown users.
+func (ms *MarginSystem) GetCrossSymbolRisk(userID string) CrossSymbolRisk {
+	var risk CrossSymbolRisk
+
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return risk
+	}
+
+	// retry while the balance moves under the position snapshot
+	var snapshots []position.PositionSnapshot
+	balance := account.getBalance()
+	for attempt := 0; attempt < 3; attempt++ {
+		snapshots = ms.crossSnapshots(userID)
+		after := account.getBalance()
+		if after == balance {
+			break
+		}
+		balance = after
+	}
+
+	exposures := make(map[string]float64)
+	risk.TotalEquity = balance
+	for _, snapshot := range snapshots {
+		risk.TotalEquity += snapshot.UnrealizedPnL
+		risk.TotalPositionValue += snapshot.PositionValue
+		risk.TotalMaintenanceMargin += ms.CalculateMaintenanceMargin(snapshot.Symbol, snapshot.PositionValue)
+		exposures[snapshot.Symbol] += snapshot.PositionValue
+	}
+
+	for symbol, value := range exposures {
+		if value > exposures[risk.LargestExposureSymbol] || (value == exposures[risk.LargestExposureSymbol] && symbol < risk.LargestExposureSymbol) {
+			risk.LargestExposureSymbol = symbol
+		}
+	}
+	if risk.TotalPositionValue > 0 {
+		risk.LargestExposureFraction = exposures[risk.LargestExposureSymbol] / risk.TotalPositionValue
+	}
+
+	if risk.TotalMaintenanceMargin <= 0 {
+		risk.GlobalMarginRatio = 999 // no cross position
+	} else {
+		risk.GlobalMarginRatio = risk.TotalEquity / risk.TotalMaintenanceMargin
+	}
+
+	threshold := ms.currentConfig().CrossRiskThreshold
+	if threshold <= 0 {
+		threshold = DefaultCrossRiskThreshold
+	}
+	risk.IsAtRisk = risk.GlobalMarginRatio < threshold
+
+	return risk
+}
+
+// crossSnapshots open cross positions of the user
+func (ms *MarginSystem) crossSnapshots(userID string) []position.PositionSnapshot {
+	positions, err := ms.positionMgr.GetUserPositions(userID)
+	if err != nil {
+		return nil
+	}
+
+	snapshots := make([]position.PositionSnapshot, 0, len(positions))
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.MarginMode != common.CROSS || snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
@@ -0,0 +1,58 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/position"
+	"math"
+)
+
+// FundingSettlement result of one funding settlement of a symbol
+type FundingSettlement struct {
+	Symbol        string  `json:"symbol"`
+	Rate          float64 `json:"rate"`
+	TotalPaid     float64 `json:"total_paid"`     // paid by the paying side
+	TotalReceived float64 `json:"total_received"` // received by the other side
+	Positions     int     `json:"positions"`
+	Skipped       int     `json:"skipped"` // positions without margin account
+}
+
+// SettleFunding (資金費率結算) payment = size × mark price × rate.
+// rate > 0: longs pay shorts, rate < 0: shorts pay longs. no trade is generated.
+func (ms *MarginSystem) SettleFunding(symbol string, rate float64) (FundingSettlement, error) {
+	if math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return FundingSettlement{}, fmt.Errorf("invalid funding rate %v", rate)
+	}
+	positions, err := ms.positionMgr.GetSymbolPositions(symbol)
+	if err != nil {
+		return FundingSettlement{}, err
+	}
+
+	settlement := FundingSettlement{Symbol: symbol, Rate: rate}
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		account, err := ms.GetAccount(snapshot.UserID)
+		if err != nil {
+			settlement.Skipped++
+			continue
+		}
+
+		price := snapshot.MarkPrice
+		if price <= 0 {
+			price = snapshot.EntryPrice
+		}
+		payment := snapshot.Size * price * rate
+		if snapshot.Side == position.LONG {
+			payment = -payment
+		}
+
+		account.SettleFunding(payment)
+		if payment < 0 {
+			settlement.TotalPaid -= payment
+		} else {
+			settlement.TotalReceived += payment
+		}
+		settlement.Positions++
+	}
+
+	return settlement, nil
+}
@@ -0,0 +1,199 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	InsuranceFundDeposit  = "deposit"  // 強平剩餘保證金/強平費 流入
+	InsuranceFundWithdraw = "withdraw" // 穿倉損失 流出
+)
+
+// InsuranceFundEvent one balance change of the insurance fund, Amount is signed (withdraw < 0)
+type InsuranceFundEvent struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	Amount        float64   `json:"amount"`
+	BalanceBefore float64   `json:"balance_before"`
+	BalanceAfter  float64   `json:"balance_after"`
+	PositionID    string    `json:"position_id"`
+	Symbol        string    `json:"symbol,omitempty"` // empty for manual top-ups
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// InsuranceFund (保險基金) absorbs liquidation losses
+type InsuranceFund struct {
+	balance float64
+	history []InsuranceFundEvent
+	mu      sync.RWMutex
+}
+
+func NewInsuranceFund() *InsuranceFund {
+	return &InsuranceFund{
+		history: make([]InsuranceFundEvent, 0),
+	}
+}
+
+func (f *InsuranceFund) Balance() float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.balance
+}
+
+// Deposit (注資) e.g. remaining margin of a liquidated position
+func (f *InsuranceFund) Deposit(positionID string, amount float64) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be greater than zero")
+	}
+
+	f.deposit("", positionID, amount)
+	return nil
+}
+
+// Withdraw (賠付) cover the loss of a bankrupt position
+func (f *InsuranceFund) Withdraw(positionID string, amount float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if amount <= 0 {
+		return fmt.Errorf("amount must be greater than zero")
+	}
+
+	if f.balance < amount {
+		return fmt.Errorf("insufficient insurance fund: %.2f < %.2f", f.balance, amount)
+	}
+
+	f.record(InsuranceFundWithdraw, "", positionID, -amount)
+	return nil
+}
+
+// Cover withdraw as much of amount as the balance allows, return the covered part
+func (f *InsuranceFund) Cover(positionID string, amount float64) float64 {
+	return f.cover("", positionID, amount)
+}
+
+// InsuranceFundSymbolReport contributions and payouts of one symbol
+type InsuranceFundSymbolReport struct {
+	Symbol        string  `json:"symbol"`
+	Contributions float64 `json:"contributions"`
+	Payouts       float64 `json:"payouts"`
+	Events        int     `json:"events"`
+}
+
+// InsuranceFundReport (保險基金報告) Balance always equals TotalContributions - TotalPayouts
+type InsuranceFundReport struct {
+	Balance            float64                              `json:"balance"`
+	TotalContributions float64                              `json:"total_contributions"`
+	TotalPayouts       float64                              `json:"total_payouts"`
+	BySymbol           map[string]InsuranceFundSymbolReport `json:"by_symbol"`
+	LargestPayouts     []InsuranceFundEvent                 `json:"largest_payouts"` // largest first
+	Timestamp          time.Time                            `json:"timestamp"`
+}
+
+// Report aggregate the history, keep the topN largest single payouts
+func (f *InsuranceFund) Report(topN int) InsuranceFundReport {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	report := InsuranceFundReport{
+		Balance:   f.balance,
+		BySymbol:  make(map[string]InsuranceFundSymbolReport),
+		Timestamp: time.Now(),
+	}
+	var payouts []InsuranceFundEvent
+	for _, event := range f.history {
+		symbol := report.BySymbol[event.Symbol]
+		symbol.Symbol = event.Symbol
+		symbol.Events++
+		if event.Amount > 0 {
+			report.TotalContributions += event.Amount
+			symbol.Contributions += event.Amount
+		} else {
+			report.TotalPayouts -= event.Amount
+			symbol.Payouts -= event.Amount
+			payouts = append(payouts, event)
+		}
+		if event.Symbol != "" {
+			report.BySymbol[event.Symbol] = symbol
+		}
+	}
+
+	sort.SliceStable(payouts, func(i, j int) bool {
+		return payouts[i].Amount < payouts[j].Amount
+	})
+	report.LargestPayouts = payouts[:min(max(topN, 0), len(payouts))]
+	return report
+}
+
+// InsuranceFundSnapshot balance and history, for persistence
+type InsuranceFundSnapshot struct {
+	Balance float64              `json:"balance"`
+	History []InsuranceFundEvent `json:"history"`
+}
+
+func (f *InsuranceFund) Snapshot() InsuranceFundSnapshot {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	history := make([]InsuranceFundEvent, len(f.history))
+	copy(history, f.history)
+	return InsuranceFundSnapshot{Balance: f.balance, History: history}
+}
+
+// restore replace balance and history with the snapshot's
+func (f *InsuranceFund) restore(snapshot InsuranceFundSnapshot) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.balance = snapshot.Balance
+	f.history = append(make([]InsuranceFundEvent, 0, len(snapshot.History)), snapshot.History...)
+}
+
+// History all events, oldest first
+func (f *InsuranceFund) History() []InsuranceFundEvent {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	history := make([]InsuranceFundEvent, len(f.history))
+	copy(history, f.history)
+	return history
+}
+
+func (f *InsuranceFund) deposit(symbol, positionID string, amount float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(InsuranceFundDeposit, symbol, positionID, amount)
+}
+
+func (f *InsuranceFund) cover(symbol, positionID string, amount float64) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	covered := min(amount, f.balance)
+	if covered <= 0 {
+		return 0
+	}
+	f.record(InsuranceFundWithdraw, symbol, positionID, -covered)
+	return covered
+}
+
+// record apply signed amount and append event, no lock
+func (f *InsuranceFund) record(eventType, symbol, positionID string, amount float64) {
+	before := f.balance
+	f.balance += amount
+
+	f.history = append(f.history, InsuranceFundEvent{
+		ID:            common.GenerateShortUUID("ife"),
+		Type:          eventType,
+		Amount:        amount,
+		BalanceBefore: before,
+		BalanceAfter:  f.balance,
+		PositionID:    positionID,
+		Symbol:        symbol,
+		Timestamp:     time.Now(),
+	})
+}
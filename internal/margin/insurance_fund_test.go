@@ -0,0 +1,183 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var symbols = []string{"BTCUSDT", "ETHUSDT"}
+
+func TestInsuranceFundDepositWithdraw(t *testing.T) {
+	fund := NewInsuranceFund()
+
+	assert.Error(t, fund.Deposit("pos_1", 0))
+	assert.Error(t, fund.Withdraw("pos_1", 10), "empty fund can not cover loss")
+
+	require.NoError(t, fund.Deposit("pos_1", 100))
+	require.NoError(t, fund.Withdraw("pos_2", 40))
+	assert.Equal(t, 60.0, fund.Balance())
+
+	history := fund.History()
+	require.Len(t, history, 2)
+
+	assert.Equal(t, InsuranceFundDeposit, history[0].Type)
+	assert.Equal(t, 100.0, history[0].Amount)
+	assert.Equal(t, 0.0, history[0].BalanceBefore)
+	assert.Equal(t, 100.0, history[0].BalanceAfter)
+	assert.Equal(t, "pos_1", history[0].PositionID)
+
+	assert.Equal(t, InsuranceFundWithdraw, history[1].Type)
+	assert.Equal(t, -40.0, history[1].Amount)
+	assert.Equal(t, 100.0, history[1].BalanceBefore)
+	assert.Equal(t, 60.0, history[1].BalanceAfter)
+	assert.NotEqual(t, history[0].ID, history[1].ID)
+}
+
+// TestInsuranceFundLiquidationHistory 10 筆強平：剩餘保證金流入基金，穿倉損失由基金賠付
+func TestInsuranceFundLiquidationHistory(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+	fund := ms.InsuranceFund()
+
+	start := time.Now()
+	require.NoError(t, fund.Deposit("", 10000)) // 初始注資
+
+	for i := 0; i < 10; i++ {
+		userID := fmt.Sprintf("user_%d", i)
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 5000))
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", position.LONG, 50000, 1, 50)
+		require.NoError(t, err)
+
+		// 偶數：在強平價附近成交 (有剩餘保證金)，奇數：跌穿破產價 (穿倉)
+		closePrice := pos.LiquidationPrice
+		if i%2 == 1 {
+			closePrice = pos.EntryPrice - pos.InitialMargin/pos.Size - float64(100*i)
+		}
+
+		initialMargin := pos.InitialMargin
+		pnl, err := pos.Close(closePrice)
+		require.NoError(t, err)
+
+		// 經由強平結算入帳，而不是直接存取基金
+		settlement, err := ms.SettleLiquidation(userID, pos.ID, "BTCUSDT", common.ISOLATED, closePrice, initialMargin, pnl)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			assert.Positive(t, settlement.FundDeposit)
+		} else {
+			assert.Positive(t, settlement.FundCovered)
+			assert.Zero(t, settlement.Uncovered)
+		}
+	}
+
+	history := ms.GetInsuranceFundHistory(start, time.Now())
+	require.Len(t, history, 11)
+
+	net := 0.0
+	deposits, withdraws := 0, 0
+	for i, event := range history {
+		net += event.Amount
+		assert.InDelta(t, event.BalanceBefore+event.Amount, event.BalanceAfter, 1e-9)
+		if i > 0 {
+			assert.Equal(t, history[i-1].BalanceAfter, event.BalanceBefore)
+			assert.Equal(t, "BTCUSDT", event.Symbol, "recorded by the liquidation settlement")
+		}
+		switch event.Type {
+		case InsuranceFundDeposit:
+			deposits++
+		case InsuranceFundWithdraw:
+			withdraws++
+		}
+	}
+
+	assert.InDelta(t, fund.Balance(), net, 1e-9)
+	assert.Equal(t, 6, deposits)
+	assert.Equal(t, 5, withdraws)
+
+	// 時間範圍外不回傳
+	assert.Empty(t, ms.GetInsuranceFundHistory(start.Add(-time.Hour), start.Add(-time.Minute)))
+}
+
+func TestInsuranceFundReport(t *testing.T) {
+	ms := NewMarginSystem(position.NewPositionManager(symbols), &MarginConfig{
+		DefaultInitialMarginRate:     0.10,
+		DefaultMaintenanceMarginRate: 0.05,
+		NegativeBalanceProtection:    true,
+		LiquidationFeeRate:           0.005,
+	})
+	require.NoError(t, ms.SetLiquidationFeeRate("ETHUSDT", 0.01))
+	assert.Error(t, ms.SetLiquidationFeeRate("ETHUSDT", -0.01))
+	assert.Equal(t, 0.005, ms.LiquidationFeeRate("BTCUSDT"))
+	assert.Equal(t, 0.01, ms.LiquidationFeeRate("ETHUSDT"))
+
+	require.NoError(t, ms.InsuranceFund().Deposit("", 1000)) // 初始注資
+
+	liquidations := []struct {
+		userID, symbol       string
+		mode                 common.MarginMode
+		deposit, notional    float64
+		marginReleased, pnl  float64
+		fee, covered, charge float64
+	}{
+		// 剩餘 600, 強平費 9600*0.5% = 48, 退回 552
+		{"user_a", "BTCUSDT", common.ISOLATED, 1000, 9600, 1000, -400, 48, 0, 448},
+		// 剩餘 10 不足 45.05 的強平費
+		{"user_b", "BTCUSDT", common.ISOLATED, 1000, 9010, 1000, -990, 10, 0, 1000},
+		// 穿倉 200 / 50
+		{"user_c", "ETHUSDT", common.ISOLATED, 1000, 3000, 500, -700, 0, 200, 500},
+		{"user_d", "ETHUSDT", common.ISOLATED, 1000, 3000, 500, -550, 0, 50, 500},
+		// 全倉: 虧損 300 + 強平費 100
+		{"user_e", "BTCUSDT", common.CROSS, 1000, 20000, 2000, -300, 100, 0, 400},
+		// 全倉: 只剩 10 付強平費 50
+		{"user_f", "ETHUSDT", common.CROSS, 100, 5000, 500, -90, 10, 0, 100},
+	}
+	for i, liq := range liquidations {
+		_, err := ms.CreateAccount(liq.userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(liq.userID, liq.deposit))
+
+		settlement, err := ms.SettleLiquidation(liq.userID, fmt.Sprintf("pos_%d", i), liq.symbol, liq.mode, liq.notional, liq.marginReleased, liq.pnl)
+		require.NoError(t, err)
+		assert.InDelta(t, liq.fee, settlement.FundDeposit, 1e-9, liq.userID)
+		assert.InDelta(t, liq.covered, settlement.FundCovered, 1e-9, liq.userID)
+		assert.InDelta(t, liq.charge, settlement.AccountCharge, 1e-9, liq.userID)
+
+		account, err := ms.GetAccount(liq.userID)
+		require.NoError(t, err)
+		assert.InDelta(t, max(liq.deposit-liq.charge, 0), account.Balance, 1e-9, liq.userID)
+	}
+
+	report := ms.GetInsuranceFundReport()
+	assert.InDelta(t, 1168.0, report.TotalContributions, 1e-9)
+	assert.InDelta(t, 250.0, report.TotalPayouts, 1e-9)
+	assert.InDelta(t, report.TotalContributions-report.TotalPayouts, report.Balance, 1e-9)
+	assert.Equal(t, ms.InsuranceFund().Balance(), report.Balance)
+
+	require.Len(t, report.BySymbol, 2)
+	btc, eth := report.BySymbol["BTCUSDT"], report.BySymbol["ETHUSDT"]
+	assert.InDelta(t, 158.0, btc.Contributions, 1e-9)
+	assert.Zero(t, btc.Payouts)
+	assert.Equal(t, 3, btc.Events)
+	assert.InDelta(t, 10.0, eth.Contributions, 1e-9)
+	assert.InDelta(t, 250.0, eth.Payouts, 1e-9)
+	assert.Equal(t, 3, eth.Events)
+	// manual top-up is not attributed to a symbol
+	assert.InDelta(t, report.TotalContributions, btc.Contributions+eth.Contributions+1000, 1e-9)
+
+	require.Len(t, report.LargestPayouts, 2)
+	assert.Equal(t, -200.0, report.LargestPayouts[0].Amount)
+	assert.Equal(t, "ETHUSDT", report.LargestPayouts[0].Symbol)
+	assert.Equal(t, -50.0, report.LargestPayouts[1].Amount)
+	assert.Len(t, ms.InsuranceFund().Report(1).LargestPayouts, 1)
+
+	snapshot := ms.InsuranceFund().Snapshot()
+	assert.Equal(t, report.Balance, snapshot.Balance)
+	assert.Len(t, snapshot.History, 7)
+}
@@ -0,0 +1,55 @@
+package margin
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/position"
+	"math"
+)
+
+// balanceTolerance absolute slack of the ledger check, float rounding of repeated settlements
+const balanceTolerance = 1e-6
+
+// VerifyBalances (帳本檢查) accounts whose ledger does not add up:
+// Balance + UnrealizedPnL - FrozenBalance must equal AvailableBalance + PositionMargin.
+// an account under water (available clamped to 0) is not a leak. registered as the position manager's
+// BalanceVerifier, the figures are those of the last UpdatePositionMargin
+func (ms *MarginSystem) VerifyBalances(ctx context.Context) []position.IntegrityViolation {
+	ms.mu.RLock()
+	accounts := make([]*MarginAccount, 0, len(ms.accounts))
+	for _, account := range ms.accounts {
+		accounts = append(accounts, account)
+	}
+	ms.mu.RUnlock()
+
+	var violations []position.IntegrityViolation
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			break // VerifyIntegrity reports the interruption
+		}
+		if detail, leaked := account.balanceLeak(); leaked {
+			violations = append(violations, position.IntegrityViolation{
+				Type:   position.ViolationBalanceLeak,
+				UserID: account.UserID,
+				Detail: detail,
+			})
+		}
+	}
+	return violations
+}
+
+func (ma *MarginAccount) balanceLeak() (string, bool) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	funds := ma.Balance + ma.UnrealizedPnL - ma.FrozenBalance
+	allocated := ma.AvailableBalance + ma.PositionMargin
+	if math.Abs(funds-allocated) <= balanceTolerance {
+		return "", false
+	}
+	if ma.AvailableBalance == 0 && funds < allocated {
+		return "", false
+	}
+	return fmt.Sprintf("balance %f + unrealized %f - frozen %f != available %f + position margin %f",
+		ma.Balance, ma.UnrealizedPnL, ma.FrozenBalance, ma.AvailableBalance, ma.PositionMargin), true
+}
@@ -0,0 +1,94 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+)
+
+// LiquidationSettlement money flows of one liquidated position
+type LiquidationSettlement struct {
+	AccountCharge float64 `json:"account_charge"` // loss and fee paid by the user
+	FeeRate       float64 `json:"fee_rate"`       // liquidation fee rate of the symbol
+	Fee           float64 `json:"fee"`            // liquidation fee charged, capped by what the close left
+	FundDeposit   float64 `json:"fund_deposit"`   // fee, or without a fee rate the remaining isolated margin, into the insurance fund
+	FundCovered   float64 `json:"fund_covered"`   // shortfall paid by the insurance fund
+	Uncovered     float64 `json:"uncovered"`      // shortfall the fund could not pay (ADL)
+}
+
+// SettleLiquidation (強平結算) settle a closed liquidation, notional is the closed size at the close price.
+// isolated: the liquidation fee is taken from the margin left after the loss and the rest is returned,
+// without a fee rate the whole remaining margin goes to the fund.
+// cross: the loss is charged to the balance, the fee to what is left of it.
+// any shortfall beyond the user's margin is covered by the fund.
+func (ms *MarginSystem) SettleLiquidation(userID, positionID, symbol string, mode common.MarginMode, notional, marginReleased, pnl float64) (LiquidationSettlement, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return LiquidationSettlement{}, fmt.Errorf("settle liquidation of %s: %w", positionID, err)
+	}
+
+	var settlement LiquidationSettlement
+	balance := account.getBalance()
+	defer func() {
+		ms.audit(account, userID, AuditSettleLiquidation, -settlement.AccountCharge, balance, nil)
+	}()
+	shortfall := 0.0
+	feeRate := ms.LiquidationFeeRate(symbol)
+	settlement.FeeRate = feeRate
+
+	if mode == common.ISOLATED {
+		settlement.AccountCharge = marginReleased
+		if remaining := marginReleased + pnl; remaining > 0 {
+			settlement.FundDeposit = remaining
+			if feeRate > 0 {
+				settlement.Fee = min(feeRate*notional, remaining)
+				settlement.FundDeposit = settlement.Fee
+				settlement.AccountCharge -= remaining - settlement.Fee
+			}
+		} else {
+			shortfall = -remaining
+		}
+		account.SettleLiquidation(marginReleased, settlement.AccountCharge)
+	} else {
+		settlement.AccountCharge = -pnl
+		shortfall = account.SettleLiquidation(marginReleased, -pnl)
+		settlement.AccountCharge -= shortfall
+		if shortfall == 0 && feeRate > 0 {
+			settlement.Fee = account.ChargeLiquidationFee(feeRate * notional)
+			settlement.FundDeposit = settlement.Fee
+			settlement.AccountCharge += settlement.Fee
+		}
+	}
+
+	if settlement.FundDeposit > 0 {
+		ms.insuranceFund.deposit(symbol, positionID, settlement.FundDeposit)
+	}
+	if shortfall > 0 {
+		settlement.FundCovered = ms.insuranceFund.cover(symbol, positionID, shortfall)
+		settlement.Uncovered = shortfall - settlement.FundCovered
+	}
+
+	return settlement, nil
+}
+
+// SetLiquidationFeeRate per symbol liquidation fee rate of the notional, overrides MarginConfig.LiquidationFeeRate
+func (ms *MarginSystem) SetLiquidationFeeRate(symbol string, rate float64) error {
+	if rate < 0 || rate >= 1 {
+		return fmt.Errorf("liquidation fee rate must be in [0, 1)")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.liquidationFeeRates[symbol] = rate
+	return nil
+}
+
+// LiquidationFeeRate rate of the symbol, the configured default otherwise
+func (ms *MarginSystem) LiquidationFeeRate(symbol string) float64 {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if rate, exists := ms.liquidationFeeRates[symbol]; exists {
+		return rate
+	}
+	return ms.currentConfig().LiquidationFeeRate
+}
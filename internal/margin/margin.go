@@ -1,9 +1,12 @@
 package margin
 
 import (
+	"context"
 	"fmt"
+	"frizo/futures_engine/internal/common"
 	"frizo/futures_engine/internal/position"
 	"sync"
+	"time"
 )
 
 // MarginSystem Main type
@@ -13,29 +16,70 @@ type MarginSystem struct {
 
 	// position manager
 	positionMgr *position.PositionManager
-	// config
-	config *MarginConfig
+	// insurance fund
+	insuranceFund *InsuranceFund
+	// config, replaced whole by SetConfig
+	config   *MarginConfig
+	configMu sync.RWMutex
+	// paper trading accounts, follows the position manager
+	simulated bool
+	// portfolio margin, opted-in users only
+	portfolioConfig *PortfolioMarginConfig
+	portfolioUsers  map[string]bool
+	// liquidation fee rate per symbol, MarginConfig.LiquidationFeeRate otherwise
+	liquidationFeeRates map[string]float64
+	// socialized losses, haircuts waiting for the position's next reducing fill
+	socializedLosses []SocializedLoss
+	haircuts         []*SocializedHaircut
+	pendingHaircuts  map[string][]*SocializedHaircut // positionID -> haircuts
+	socializedMu     sync.Mutex
+	// order margin reservations, userID -> orderID -> reservation
+	reservations  map[string]map[string]*MarginReservation
+	reservationMu sync.Mutex
+	// in-memory audit log of balance operations
+	auditLog *common.RingBuffer[MarginAuditEntry]
+	auditMu  sync.Mutex
+	onAudit  []func(MarginAuditEntry) // guarded by auditMu
+	// persisted accounts, the snapshot of each account at its last flush
+	store   AccountStore
+	flushed map[string]AccountSnapshot
+	storeMu sync.Mutex
 
 	mu sync.RWMutex
 }
 
-// NewMarginSystem
+// NewMarginSystem config nil means DefaultMarginConfig
 func NewMarginSystem(positionMgr *position.PositionManager, config *MarginConfig) *MarginSystem {
 	if config == nil {
-		config = &MarginConfig{
-			DefaultInitialMarginRate:     0.10, // 10%
-			DefaultMaintenanceMarginRate: 0.05, // 5%
-			MinTransferAmount:            1.0,
-			NegativeBalanceProtection:    true,
-		}
+		defaults := DefaultMarginConfig
+		config = &defaults
 	}
 
-	return &MarginSystem{
-		accounts:     make(map[string]*MarginAccount),
-		requirements: make(map[string]*MarginRequirement),
-		positionMgr:  positionMgr,
-		config:       config,
+	ms := &MarginSystem{
+		accounts:        make(map[string]*MarginAccount),
+		requirements:    make(map[string]*MarginRequirement),
+		positionMgr:     positionMgr,
+		insuranceFund:   NewInsuranceFund(),
+		config:          config,
+		portfolioConfig: DefaultPortfolioMarginConfig,
+		portfolioUsers:  make(map[string]bool),
+
+		liquidationFeeRates: make(map[string]float64),
+		pendingHaircuts:     make(map[string][]*SocializedHaircut),
+		reservations:        make(map[string]map[string]*MarginReservation),
+		auditLog:            common.NewRingBuffer[MarginAuditEntry](MaxAuditLogEntries),
+		store:               NewMemoryAccountStore(),
+		flushed:             make(map[string]AccountSnapshot),
+	}
+	if positionMgr != nil {
+		ms.simulated = positionMgr.IsSimulated()
+		positionMgr.SetCrossMarginEquityProvider(ms.crossMarginEquity)
+		positionMgr.SetBalanceVerifier(ms.VerifyBalances)
+		positionMgr.SetExpirySettler(ms.settleExpiry)
+		positionMgr.SetRecoveryHook(ms.recoverPosition)
 	}
+
+	return ms
 }
 
 func (ms *MarginSystem) GetAccount(userID string) (*MarginAccount, error) {
@@ -45,22 +89,119 @@ func (ms *MarginSystem) GetAccount(userID string) (*MarginAccount, error) {
 	if account, ok := ms.accounts[userID]; ok {
 		return account, nil
 	} else {
-		return nil, fmt.Errorf("account not found")
+		return nil, ErrAccountNotFound
 	}
 }
 
 func (ms *MarginSystem) CreateAccount(userID string) (*MarginAccount, error) {
+	ms.mu.Lock()
+	account, err := ms.createAccount(userID)
+	ms.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return account, ms.flushAccount(context.Background(), account)
+}
+
+// CreateAccountBatch bulk provisioning under a single lock. the results are parallel to users:
+// accounts[i] is nil when errs[i] is set, e.g. ErrAccountAlreadyExists for a duplicate.
+func (ms *MarginSystem) CreateAccountBatch(users []string) ([]*MarginAccount, []error) {
+	accounts := make([]*MarginAccount, len(users))
+	errs := make([]error, len(users))
+
+	ms.mu.Lock()
+	for i, userID := range users {
+		accounts[i], errs[i] = ms.createAccount(userID)
+	}
+	ms.mu.Unlock()
+
+	for i, account := range accounts {
+		if account != nil {
+			errs[i] = ms.flushAccount(context.Background(), account)
+		}
+	}
+	return accounts, errs
+}
+
+// DeleteAccount remove an account without open positions and frozen order margin
+func (ms *MarginSystem) DeleteAccount(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// checked before taking ms.mu, the position manager calls back into the margin system
+	if ms.positionMgr != nil {
+		positions, _ := ms.positionMgr.GetUserPositions(userID)
+		for _, pos := range positions {
+			if snapshot := pos.Snapshot(); snapshot.Status != position.PositionClosed && snapshot.Size > 0 {
+				return fmt.Errorf("%w: user %s has open position %s", ErrAccountInUse, userID, snapshot.ID)
+			}
+		}
+	}
+
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
+	account, ok := ms.accounts[userID]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	account.mu.RLock()
+	frozen := account.FrozenBalance
+	account.mu.RUnlock()
+	if frozen != 0 {
+		return fmt.Errorf("%w: user %s has frozen balance %.2f", ErrAccountInUse, userID, frozen)
+	}
+
+	delete(ms.accounts, userID)
+	delete(ms.portfolioUsers, userID)
+	return nil
+}
+
+// createAccount no lock
+func (ms *MarginSystem) createAccount(userID string) (*MarginAccount, error) {
 	if _, ok := ms.accounts[userID]; ok {
-		return nil, fmt.Errorf("account already exists")
-	} else {
-		// create margin account
-		ma := NewMarginAccount(userID)
-		ms.accounts[userID] = ma
-		return ma, nil
+		return nil, fmt.Errorf("%w: %s", ErrAccountAlreadyExists, userID)
 	}
+	ma := NewMarginAccount(userID)
+	ms.accounts[userID] = ma
+	return ma, nil
+}
+
+// RestoreAccount put back an account from its snapshot, e.g. after a restart
+func (ms *MarginSystem) RestoreAccount(snapshot AccountSnapshot) (*MarginAccount, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.accounts[snapshot.UserID]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrAccountAlreadyExists, snapshot.UserID)
+	}
+	account := RestoreMarginAccount(snapshot)
+	ms.accounts[snapshot.UserID] = account
+	return account, nil
+}
+
+func (ms *MarginSystem) IsSimulated() bool {
+	return ms.simulated
+}
+
+// SeedSimAccount create (if needed) a paper trading account and credit virtual funds,
+// refused on a real margin system
+func (ms *MarginSystem) SeedSimAccount(userID string, amount float64) (*MarginAccount, error) {
+	if !ms.simulated {
+		return nil, fmt.Errorf("can not seed virtual funds into a real margin system")
+	}
+
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		if account, err = ms.CreateAccount(userID); err != nil {
+			return nil, err
+		}
+	}
+	if err = ms.Deposit(userID, amount); err != nil {
+		return nil, err
+	}
+	return account, nil
 }
 
 // =====================================================
@@ -99,19 +240,100 @@ func (ms *MarginSystem) CalculateMaintenanceMargin(symbol string, positionValue
 	return positionValue * requirement.MaintenanceMarginRate
 }
 
+// CalculateMaxPositionSize (最大可開倉數量) size of a side order the user can afford: AvailableBalance *
+// leverage / mark price, capped by MaxNotional less the position already held on that side. an opposite
+// position (one-way) or hedge position (hedge mode) of the symbol is netted first and adds its size
+func (ms *MarginSystem) CalculateMaxPositionSize(userID, symbol string, leverage int16, side position.PositionSide) (float64, error) {
+	if leverage <= 0 {
+		return 0, fmt.Errorf("leverage must be greater than zero")
+	}
+	if maxLeverage := ms.getRequirement(symbol).MaxLeverage; maxLeverage > 0 && leverage > maxLeverage {
+		return 0, fmt.Errorf("leverage %d exceeds max leverage %d of %s", leverage, maxLeverage, symbol)
+	}
+
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	markPrice := ms.positionMgr.GetSymbolMarkPrice(symbol)
+	if markPrice <= 0 {
+		return 0, fmt.Errorf("no mark price for symbol %s", symbol)
+	}
+
+	account.mu.RLock()
+	available := account.AvailableBalance
+	account.mu.RUnlock()
+
+	held, opposite := ms.sideSizes(userID, symbol, side)
+	maxPositionValue := max(available, 0) * float64(leverage)
+	if maxNotional := ms.currentConfig().MaxNotional; maxNotional > 0 {
+		maxPositionValue = min(maxPositionValue, max(maxNotional-held*markPrice, 0))
+	}
+
+	return opposite + maxPositionValue/markPrice, nil
+}
+
+// sideSizes open size of the user's symbol positions on side and on the opposite side
+func (ms *MarginSystem) sideSizes(userID, symbol string, side position.PositionSide) (held, opposite float64) {
+	positions, err := ms.positionMgr.GetUserPositions(userID)
+	if err != nil {
+		return 0, 0 // no positions
+	}
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Symbol != symbol || snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+			continue
+		}
+		if snapshot.Side == side {
+			held += snapshot.Size
+		} else {
+			opposite += snapshot.Size
+		}
+	}
+	return held, opposite
+}
+
+// GetRequiredDepositToAvoidLiquidation deposit the user needs to bring the position's equity back to its
+// maintenance margin * MarginConfig.DepositSafetyFactor, 0 if already above. read only
+func (ms *MarginSystem) GetRequiredDepositToAvoidLiquidation(userID, positionID string) (float64, error) {
+	if _, err := ms.GetAccount(userID); err != nil {
+		return 0, err
+	}
+	pos, err := ms.positionMgr.GetPositionByID(positionID)
+	if err != nil {
+		return 0, err
+	}
+	if pos.UserID != userID {
+		return 0, fmt.Errorf("position %s does not exist", positionID)
+	}
+	return pos.GetRequiredDepositToAvoidLiquidation(ms.depositSafetyFactor()), nil
+}
+
+func (ms *MarginSystem) depositSafetyFactor() float64 {
+	if ms.currentConfig().DepositSafetyFactor > 0 {
+		return ms.currentConfig().DepositSafetyFactor
+	}
+	return position.DefaultDepositSafetyFactor
+}
+
 // =====================================================
 // price check
 // =====================================================
 
 // CheckOrderMargin
 func (ms *MarginSystem) CheckOrderMargin(userID, symbol string, size, price float64, leverage int16) error {
+	if ms.IsPortfolioMargin(userID) {
+		// side unknown, take the worse of long and short
+		return ms.checkPortfolioOrderMargin(userID, symbol, []position.PositionSide{position.LONG, position.SHORT}, size, price)
+	}
 
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 
-	account, err := ms.GetAccount(userID)
-	if err != nil {
-		return err
+	account, ok := ms.accounts[userID]
+	if !ok {
+		return ErrAccountNotFound
 	}
 
 	// calculate initial Margin
@@ -121,7 +343,7 @@ func (ms *MarginSystem) CheckOrderMargin(userID, symbol string, size, price floa
 	}
 
 	if account.AvailableBalance < requiredMargin {
-		return fmt.Errorf("insufficient margin: required %.2f, available %.2f",
+		return fmt.Errorf("%w: required %.2f, available %.2f", ErrInsufficientMargin,
 			requiredMargin, account.AvailableBalance)
 	}
 
@@ -134,7 +356,10 @@ func (ms *MarginSystem) FreezeOrderMargin(userID string, amount float64) error {
 	defer ms.mu.RUnlock()
 
 	if account, ok := ms.accounts[userID]; ok {
-		return account.FreezeOrderMargin(amount)
+		balance := account.getBalance()
+		err := account.FreezeOrderMargin(amount)
+		ms.audit(account, userID, AuditFreezeOrderMargin, amount, balance, err)
+		return err
 	} else {
 		return fmt.Errorf("account not found")
 	}
@@ -146,7 +371,10 @@ func (ms *MarginSystem) UnfreezeOrderMargin(userID string, amount float64) error
 	defer ms.mu.RUnlock()
 
 	if account, ok := ms.accounts[userID]; ok {
-		return account.UnFreezeOrderMargin(amount)
+		balance := account.getBalance()
+		err := account.UnFreezeOrderMargin(amount)
+		ms.audit(account, userID, AuditUnfreezeMargin, amount, balance, err)
+		return err
 	} else {
 		return fmt.Errorf("account not found")
 	}
@@ -158,12 +386,10 @@ func (ms *MarginSystem) UnfreezeOrderMargin(userID string, amount float64) error
 
 // UpdatePositionMargin
 func (ms *MarginSystem) UpdatePositionMargin(userID string) error {
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
-
-	account, exists := ms.accounts[userID]
-	if !exists {
-		return fmt.Errorf("account not found")
+	// do not hold ms.mu while reading positions, cross positions call back into ComputeCrossMarginEquity
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return err
 	}
 
 	positions, err := ms.positionMgr.GetUserPositions(userID)
@@ -190,6 +416,77 @@ func (ms *MarginSystem) UpdatePositionMargin(userID string) error {
 	return nil
 }
 
+// SettleReduceFill settle one reducing fill: reduce the position, then credit released margin + PnL - fee
+// to the account atomically, pending socialized loss haircuts of the position are charged with it.
+// return released margin and realized PnL
+func (ms *MarginSystem) SettleReduceFill(userID, symbol string, side position.PositionSide, price, size, fee float64) (float64, float64, error) {
+	return ms.SettleReduceOrderFill(userID, "", symbol, side, price, size, fee)
+}
+
+// SettleReduceOrderFill SettleReduceFill of a fill of the given order, the trade is recorded with its ID
+func (ms *MarginSystem) SettleReduceOrderFill(userID, orderID, symbol string, side position.PositionSide, price, size, fee float64) (float64, float64, error) {
+	if fee < 0 {
+		return 0, 0, fmt.Errorf("fee must not be negative")
+	}
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pos, pnl, marginReleased, err := ms.positionMgr.ReducePositionWithRelease(userID, symbol, side, price, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fee > 0 {
+		pos.AddTradingFee(fee)
+	}
+	balance := account.getBalance()
+	account.SettleReduce(marginReleased, pnl, fee)
+	_, haircut := ms.applyHaircuts(pos.ID)
+	pnl -= haircut
+	ms.audit(account, userID, AuditSettleReduce, pnl-fee, balance, nil)
+	account.RecordTrade(TradeRecord{
+		OrderID:     orderID,
+		PositionID:  pos.ID,
+		Symbol:      symbol,
+		Side:        side,
+		Price:       price,
+		Size:        size,
+		Fee:         fee,
+		RealizedPnL: pnl,
+	})
+
+	return marginReleased, pnl, nil
+}
+
+// settleExpiry close an expired position as a reduce fill without fee, the ExpirySettler of the position manager
+func (ms *MarginSystem) settleExpiry(userID, symbol string, side position.PositionSide, price, size float64) error {
+	_, _, err := ms.SettleReduceFill(userID, symbol, side, price, size, 0)
+	return err
+}
+
+// recoverPosition refresh the owner's position margin once a position is recovered, the RecoveryHook of the position manager
+func (ms *MarginSystem) recoverPosition(ctx context.Context, snapshot position.PositionSnapshot) error {
+	return ms.UpdatePositionMargin(snapshot.UserID)
+}
+
+// SwitchMarginMode switch position between cross and isolated (全倉/逐倉切換)
+func (ms *MarginSystem) SwitchMarginMode(userID, symbol string, side position.PositionSide, newMode common.MarginMode) error {
+	pos, err := ms.positionMgr.GetPosition(userID, symbol, side)
+	if err != nil {
+		return err
+	}
+
+	crossEquity := 0.0
+	if newMode == common.CROSS {
+		if crossEquity, err = ms.ComputeCrossMarginEquity(userID); err != nil {
+			return err
+		}
+	}
+
+	return pos.ReclassifyMarginMode(newMode, crossEquity)
+}
+
 // =====================================================
 // About Risk
 // =====================================================
@@ -203,6 +500,12 @@ func (ms *MarginSystem) GetMarginLevel(userID string) (float64, error) {
 
 	accountEquity := account.GetAccountEquity()
 	usedMargin := account.GetUsedMargin()
+	if ms.IsPortfolioMargin(userID) {
+		account.mu.RLock()
+		orderMargin := account.OrderMargin
+		account.mu.RUnlock()
+		usedMargin = ms.portfolioRequirement(ms.userExposures(userID)) + orderMargin
+	}
 
 	if usedMargin <= 0 {
 		return 999, nil // no order and position -> return max
@@ -212,6 +515,38 @@ func (ms *MarginSystem) GetMarginLevel(userID string) (float64, error) {
 	}
 }
 
+// ComputeCrossMarginEquity (全倉權益) Balance + live unrealized PnL of the user's open cross positions at the latest
+// mark prices, shared by all cross positions of the user. isolated positions are backed by their own margin only
+func (ms *MarginSystem) ComputeCrossMarginEquity(userID string) (float64, error) {
+	return ms.crossMarginEquity(userID, "")
+}
+
+// crossMarginEquity ComputeCrossMarginEquity without the position excludePositionID, the
+// position.CrossMarginEquityProvider of the position manager
+func (ms *MarginSystem) crossMarginEquity(userID, excludePositionID string) (float64, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	equity := account.getBalance()
+	positions, _ := ms.positionMgr.GetUserPositions(userID) // none yet
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.ID == excludePositionID || snapshot.Status == position.PositionClosed || snapshot.Size <= 0 ||
+			snapshot.MarginMode != common.CROSS {
+			continue
+		}
+		markPrice := ms.positionMgr.GetSymbolMarkPrice(snapshot.Symbol)
+		if markPrice <= 0 {
+			equity += snapshot.UnrealizedPnL
+			continue
+		}
+		equity += position.PositionMath{}.CalculateUnrealizedPnL(snapshot.Side, snapshot.EntryPrice, markPrice, snapshot.Size)
+	}
+	return equity, nil
+}
+
 // IsLiquidatable
 func (ms *MarginSystem) IsLiquidatable(userID string) (bool, error) {
 	marginLevel, err := ms.GetMarginLevel(userID)
@@ -237,8 +572,13 @@ func (ms *MarginSystem) Deposit(userID string, amount float64) error {
 		return err
 	}
 
-	account.Deposit(amount)
-	return nil
+	balance := account.getBalance()
+	err = account.Deposit(amount)
+	ms.audit(account, userID, AuditDeposit, amount, balance, err)
+	if err != nil {
+		return err
+	}
+	return ms.flushAccount(context.Background(), account)
 }
 
 // Withdraw
@@ -252,7 +592,42 @@ func (ms *MarginSystem) Withdraw(userID string, amount float64) error {
 		return err
 	}
 
-	return account.Withdraw(amount)
+	balance := account.getBalance()
+	err = account.Withdraw(amount)
+	ms.audit(account, userID, AuditWithdraw, -amount, balance, err)
+	if err != nil {
+		return err
+	}
+	return ms.flushAccount(context.Background(), account)
+}
+
+// =====================================================
+// Insurance Fund
+// =====================================================
+
+// InsuranceFund
+func (ms *MarginSystem) InsuranceFund() *InsuranceFund {
+	return ms.insuranceFund
+}
+
+// InsuranceFundTopPayouts largest single payouts kept in the report
+const InsuranceFundTopPayouts = 10
+
+// GetInsuranceFundReport balance, contributions, payouts and the per-symbol breakdown
+func (ms *MarginSystem) GetInsuranceFundReport() InsuranceFundReport {
+	return ms.insuranceFund.Report(InsuranceFundTopPayouts)
+}
+
+// GetInsuranceFundHistory events within [from, to]
+func (ms *MarginSystem) GetInsuranceFundHistory(from, to time.Time) []InsuranceFundEvent {
+	events := make([]InsuranceFundEvent, 0)
+	for _, event := range ms.insuranceFund.History() {
+		if event.Timestamp.Before(from) || event.Timestamp.After(to) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
 }
 
 // =====================================================
@@ -289,8 +664,8 @@ func (ms *MarginSystem) getRequirement(symbol string) *MarginRequirement {
 	// default
 	return &MarginRequirement{
 		Symbol:                symbol,
-		InitialMarginRate:     ms.config.DefaultInitialMarginRate,
-		MaintenanceMarginRate: ms.config.DefaultMaintenanceMarginRate,
+		InitialMarginRate:     ms.currentConfig().DefaultInitialMarginRate,
+		MaintenanceMarginRate: ms.currentConfig().DefaultMaintenanceMarginRate,
 		MinInitialMargin:      1.0,
 		MaxLeverage:           125,
 	}
@@ -1,3 +1,594 @@
 package margin
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrossMarginEquity(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	// IM = 50000/10 = 5000, MM = 50000*0.4% = 200
+	pos, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+
+	// 虧損 5000: 逐倉權益 = 5000-5000 = 0, 全倉權益 = 10000-5000 = 5000
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 45000)
+	require.NoError(t, err)
+
+	equity, err := ms.ComputeCrossMarginEquity("user1")
+	require.NoError(t, err)
+	assert.Equal(t, 5000.0, equity)
+	assert.InDelta(t, 5000.0/45000*100, pos.GetMarginRatio(), 1e-9)
+	assert.False(t, pos.IsLiquidatable(), "account equity still covers the loss")
+
+	// 虧損 9900: 全倉權益 = 100 < MM 200
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 40100)
+	require.NoError(t, err)
+
+	assert.InDelta(t, 100.0/40100*100, pos.GetMarginRatio(), 1e-9)
+	assert.True(t, pos.IsLiquidatable())
+
+	_, err = ms.ComputeCrossMarginEquity("unknown")
+	assert.Error(t, err)
+}
+
+func TestCrossMarginEquityAcrossSymbols(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	btc, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "user1", "ETHUSDT", position.SHORT, 3000, 10, 10)
+	require.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 50000)
+	require.NoError(t, err)
+
+	// ETH up 900: the short loses 9000, the BTC long is backed by 10000 - 9000 = 1000 at once
+	_, err = pm.UpdateMarkPrices("ETHUSDT", 3900)
+	require.NoError(t, err)
+	equity, err := ms.ComputeCrossMarginEquity("user1")
+	require.NoError(t, err)
+	assert.InDelta(t, 1000.0, equity, 1e-9)
+	assert.InDelta(t, 1000.0/50000*100, btc.GetMarginRatio(), 1e-9)
+
+	// concurrent mark updates and reads of the cross ratio
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_, _ = pm.UpdateMarkPrices(symbols[i%2], []float64{50000, 3000}[i%2]+float64(j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_ = btc.GetMarginRatio()
+				_ = pm.GetAllLiquidatablePositions()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCrossMarginEquityIgnoresIsolated(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	btc, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	eth, err := pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", position.SHORT, 3000, 10, 10)
+	require.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 49000)
+	require.NoError(t, err)
+
+	// ETH up 900: the isolated short loses 9000 out of its own 3000 margin, the cross long only sees its -1000
+	_, err = pm.UpdateMarkPrices("ETHUSDT", 3900)
+	require.NoError(t, err)
+	assert.InDelta(t, -9000.0, eth.UnrealizedPnL, 1e-9)
+
+	equity, err := ms.ComputeCrossMarginEquity("user1")
+	require.NoError(t, err)
+	assert.InDelta(t, 9000.0, equity, 1e-9)
+	assert.InDelta(t, 9000.0/49000*100, btc.GetMarginRatio(), 1e-9)
+	assert.False(t, btc.IsLiquidatable())
+
+	// and isolated gains do not back the cross position either
+	_, err = pm.UpdateMarkPrices("ETHUSDT", 2000)
+	require.NoError(t, err)
+	equity, err = ms.ComputeCrossMarginEquity("user1")
+	require.NoError(t, err)
+	assert.InDelta(t, 9000.0, equity, 1e-9)
+}
+
+func TestCrossMarginWithoutProvider(t *testing.T) {
+	// 未接 margin system 時退回倉位自身保證金計算
+	pm := position.NewPositionManager(symbols)
+	pos, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+
+	assert.InDelta(t, 5000.0/50000*100, pos.GetMarginRatio(), 1e-9)
+}
+
+func TestSwitchMarginMode(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 20000))
+
+	pos, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 48000)
+	require.NoError(t, err)
+	isolatedRatio := pos.GetMarginRatio()
+
+	require.NoError(t, ms.SwitchMarginMode("user1", "BTCUSDT", position.LONG, common.CROSS))
+	assert.Equal(t, common.CROSS, pos.MarginMode)
+	assert.Greater(t, pos.GetMarginRatio(), isolatedRatio)
+
+	assert.Error(t, ms.SwitchMarginMode("user1", "ETHUSDT", position.LONG, common.CROSS))
+}
+
+func TestSettleReduceFillViaBook(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	// IM = 50000*2/10 = 10000
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 2, 10)
+	require.NoError(t, err)
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+
+	account, err := ms.GetAccount("user1")
+	require.NoError(t, err)
+	availableBefore := account.AvailableBalance
+
+	// 買盤掛單, user1 以 reduce-only 賣單部分平倉 0.5 顆
+	book := orderbook.NewOrderBook("BTCUSDT")
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "o1", UserID: "user2", Side: orderbook.BUY, Price: 52000, Size: 0.3})
+	require.NoError(t, err)
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "o2", UserID: "user3", Side: orderbook.BUY, Price: 51000, Size: 1})
+	require.NoError(t, err)
+
+	trades, err := book.PlaceOrder(&orderbook.Order{ID: "r1", UserID: "user1", Side: orderbook.SELL, Price: 51000, Size: 0.5, ReduceOnly: true})
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+	assert.Equal(t, 0.0, trades[1].SellRemaining)
+
+	feeRate := 0.0005
+	expected := 0.0
+	for _, trade := range trades {
+		require.Equal(t, "user1", trade.SellUserID)
+		fee := trade.Price * trade.Size * feeRate
+		released, pnl, err := ms.SettleReduceOrderFill(trade.SellUserID, trade.SellOrderID, trade.Symbol, position.LONG,
+			trade.Price, trade.Size, fee)
+		require.NoError(t, err)
+		assert.InDelta(t, 50000*trade.Size/10, released, 1e-6)
+		assert.InDelta(t, (trade.Price-50000)*trade.Size, pnl, 1e-6)
+		expected += released + pnl - fee
+	}
+
+	// released = 2500, pnl = 600+200 = 800, fee = (15600+10200)*0.0005 = 12.9
+	assert.InDelta(t, 3287.1, expected, 1e-6)
+	assert.InDelta(t, availableBefore+expected, account.AvailableBalance, 1e-6)
+	assert.InDelta(t, 7500.0, account.PositionMargin, 1e-6)
+	assert.InDelta(t, 800.0, account.RealizedPnL, 1e-6)
+	assert.InDelta(t, 10000+800-12.9, account.Balance, 1e-6)
+
+	pos, err := pm.GetPosition("user1", "BTCUSDT", position.LONG)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, pos.Size, 1e-9)
+	assert.InDelta(t, 12.9, pos.TradingFees, 1e-9)
+
+	history, err := ms.GetTradeHistory("user1", 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	for _, record := range history {
+		assert.Equal(t, "r1", record.OrderID)
+	}
+}
+
+func TestRequiredDepositCrossMargin(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 5000))
+
+	// MM = 200
+	pos, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+
+	// 帳戶權益 = 5000 - 4900 = 100 < MM
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+	require.True(t, pos.IsLiquidatable())
+
+	needed, err := ms.GetRequiredDepositToAvoidLiquidation("user1", pos.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 140.0, needed, 1e-6) // 200*1.2 - 100
+
+	config := ms.Config()
+	config.DepositSafetyFactor = 1.5
+	ms.SetConfig(config)
+	needed, err = ms.GetRequiredDepositToAvoidLiquidation("user1", pos.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 200.0, needed, 1e-6) // 200*1.5 - 100
+
+	_, err = ms.CreateAccount("user2")
+	require.NoError(t, err)
+	_, err = ms.GetRequiredDepositToAvoidLiquidation("user2", pos.ID)
+	assert.Error(t, err, "not the user's position")
+
+	require.NoError(t, ms.Deposit("user1", needed))
+	assert.False(t, pos.IsLiquidatable())
+	assert.Error(t, pos.AddMargin(100), "cross position uses account balance")
+}
+
+func TestRecoverPositionUpdatesAccount(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	ctx := context.Background()
+
+	err := pm.RecoverPosition(ctx, position.PositionSnapshot{ID: "p0", UserID: "ghost", Symbol: "BTCUSDT"})
+	require.ErrorIs(t, err, ErrAccountNotFound, "account must exist")
+
+	account, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	require.NoError(t, pm.RecoverPosition(ctx, position.PositionSnapshot{
+		ID: "p1", UserID: "user1", Symbol: "BTCUSDT", Side: position.LONG, Status: position.PositionNormal,
+		Size: 1, EntryPrice: 50000, MarkPrice: 50000, PositionValue: 50000, InitialMargin: 5000,
+		Leverage: 10, MarginMode: common.ISOLATED, RealizedPnL: 42, UnrealizedPnL: -250,
+	}))
+	pos, err := pm.GetPositionByID("p1")
+	require.NoError(t, err)
+	assert.Equal(t, 42.0, pos.RealizedPnL)
+	// the margin system's recovery hook ran inside the same call
+	assert.Equal(t, 5000.0, account.PositionMargin)
+	assert.Equal(t, -250.0, account.UnrealizedPnL)
+}
+
+func TestCalculateMaxPositionSize(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	_, err = ms.CalculateMaxPositionSize("user1", "BTCUSDT", 10, position.LONG)
+	assert.Error(t, err, "no mark price yet")
+
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 50000)
+	require.NoError(t, err)
+	assert.Equal(t, 50000.0, pm.GetSymbolMarkPrice("BTCUSDT"))
+
+	// 10000 * 10 = 100000 USDT notional
+	size, err := ms.CalculateMaxPositionSize("user1", "BTCUSDT", 10, position.LONG)
+	require.NoError(t, err)
+	assert.InDelta(t, 100000.0/50000, size, 1e-12)
+
+	_, err = ms.CalculateMaxPositionSize("user1", "BTCUSDT", 200, position.LONG)
+	assert.Error(t, err, "above max leverage")
+
+	ms.config.MaxNotional = 60000
+	size, err = ms.CalculateMaxPositionSize("user1", "BTCUSDT", 10, position.LONG)
+	require.NoError(t, err)
+	assert.InDelta(t, 60000.0/50000, size, 1e-12)
+}
+
+func TestCalculateMaxPositionSizeBySide(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+	ms.config.MaxNotional = 60000
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 50000)
+	require.NoError(t, err)
+
+	// long 0.4: 20000 notional, 2000 margin
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 0.4, 10)
+	require.NoError(t, err)
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+
+	// more long: 8000 * 10 = 80000, capped by the 40000 left under MaxNotional
+	size, err := ms.CalculateMaxPositionSize("user1", "BTCUSDT", 10, position.LONG)
+	require.NoError(t, err)
+	assert.InDelta(t, 40000.0/50000, size, 1e-12)
+
+	// short: closes the 0.4 long first, then up to the full MaxNotional
+	size, err = ms.CalculateMaxPositionSize("user1", "BTCUSDT", 10, position.SHORT)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.4+60000.0/50000, size, 1e-12)
+}
+
+func TestGetCrossSymbolRisk(t *testing.T) {
+	pm := position.NewPositionManager([]string{"BTCUSDT", "ETHUSDT", "SOLUSDT", "XRPUSDT"})
+	ms := NewMarginSystem(pm, nil) // default maintenance rate 5%
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 20000))
+
+	_, err = pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "user1", "ETHUSDT", position.LONG, 3000, 10, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "user1", "SOLUSDT", position.SHORT, 100, 100, 10)
+	require.NoError(t, err)
+	// isolated margin is not backed by the shared equity
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "XRPUSDT", position.LONG, 1, 500000, 10)
+	require.NoError(t, err)
+
+	// maintenance 2500 + 1500 + 500
+	risk := ms.GetCrossSymbolRisk("user1")
+	assert.Equal(t, 20000.0, risk.TotalEquity)
+	assert.Equal(t, 90000.0, risk.TotalPositionValue)
+	assert.InDelta(t, 4500.0, risk.TotalMaintenanceMargin, 1e-9)
+	assert.InDelta(t, 20000.0/4500, risk.GlobalMarginRatio, 1e-9)
+	assert.False(t, risk.IsAtRisk)
+	assert.Equal(t, "BTCUSDT", risk.LargestExposureSymbol)
+	assert.InDelta(t, 50000.0/90000, risk.LargestExposureFraction, 1e-9)
+
+	// BTC -32%: equity 20000 - 16000 = 4000, maintenance 1700 + 1500 + 500
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 34000)
+	require.NoError(t, err)
+
+	risk = ms.GetCrossSymbolRisk("user1")
+	assert.Equal(t, 4000.0, risk.TotalEquity)
+	assert.Equal(t, 74000.0, risk.TotalPositionValue)
+	assert.InDelta(t, 4000.0/3700, risk.GlobalMarginRatio, 1e-9)
+	assert.True(t, risk.IsAtRisk)
+	assert.Equal(t, "BTCUSDT", risk.LargestExposureSymbol)
+	assert.InDelta(t, 34000.0/74000, risk.LargestExposureFraction, 1e-9)
+
+	// threshold is configurable
+	strict := NewMarginSystem(pm, &MarginConfig{DefaultMaintenanceMarginRate: 0.05, CrossRiskThreshold: 1.05})
+	_, err = strict.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, strict.Deposit("user1", 20000))
+	assert.False(t, strict.GetCrossSymbolRisk("user1").IsAtRisk)
+
+	assert.Equal(t, CrossSymbolRisk{}, ms.GetCrossSymbolRisk("unknown"))
+}
+
+func TestCreateAccountBatch(t *testing.T) {
+	ms := NewMarginSystem(position.NewPositionManager(symbols), nil)
+
+	// 1000 entries, the last 10 repeat earlier users
+	users := make([]string, 0, 1000)
+	for i := 0; i < 990; i++ {
+		users = append(users, fmt.Sprintf("user_%d", i))
+	}
+	for i := 0; i < 10; i++ {
+		users = append(users, fmt.Sprintf("user_%d", i*7))
+	}
+
+	accounts, errs := ms.CreateAccountBatch(users)
+	require.Len(t, accounts, len(users))
+	require.Len(t, errs, len(users))
+
+	created, duplicates := 0, 0
+	for i, err := range errs {
+		if err == nil {
+			created++
+			assert.Equal(t, users[i], accounts[i].UserID)
+			continue
+		}
+		duplicates++
+		assert.True(t, errors.Is(err, ErrAccountAlreadyExists))
+		assert.Nil(t, accounts[i])
+	}
+	assert.Equal(t, 990, created)
+	assert.Equal(t, 10, duplicates)
+
+	_, err := ms.CreateAccount("user_0")
+	assert.ErrorIs(t, err, ErrAccountAlreadyExists)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	// open position
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	assert.ErrorIs(t, ms.DeleteAccount(context.Background(), "user1"), ErrAccountInUse)
+
+	// frozen order margin
+	_, _, err = pm.ClosePosition("user1", "BTCUSDT", position.LONG, 50000)
+	require.NoError(t, err)
+	require.NoError(t, ms.FreezeOrderMargin("user1", 100))
+	assert.ErrorIs(t, ms.DeleteAccount(context.Background(), "user1"), ErrAccountInUse)
+
+	require.NoError(t, ms.UnfreezeOrderMargin("user1", 100))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, ms.DeleteAccount(ctx, "user1"), context.Canceled)
+
+	require.NoError(t, ms.DeleteAccount(context.Background(), "user1"))
+	_, err = ms.GetAccount("user1")
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+	assert.ErrorIs(t, ms.DeleteAccount(context.Background(), "user1"), ErrAccountNotFound)
+
+	// the user id can be provisioned again
+	_, err = ms.CreateAccount("user1")
+	assert.NoError(t, err)
+}
+
+func TestSimulateMarkPrices(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 20000))
+	require.NoError(t, pm.SetPositionMode("user1", position.HedgeMode))
+
+	// hedged cross pair on BTC, isolated long on ETH
+	crossLong, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 2, 10)
+	require.NoError(t, err)
+	crossShort, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.SHORT, 50000, 1, 10)
+	require.NoError(t, err)
+	isolated, err := pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", position.LONG, 3000, 10, 20)
+	require.NoError(t, err)
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+
+	held := map[string]*position.Position{crossLong.ID: crossLong, crossShort.ID: crossShort, isolated.ID: isolated}
+	live := map[string]position.PositionSnapshot{}
+	for id, pos := range held {
+		live[id] = pos.Snapshot()
+	}
+	account, err := ms.GetAccount("user1")
+	require.NoError(t, err)
+	equityBefore := account.GetAccountEquity()
+
+	// BTC -10%, ETH -6%: the isolated ETH long (IM 1500 on 30000) goes under, the cross pair survives
+	prices := map[string]float64{"BTCUSDT": 45000, "ETHUSDT": 2820}
+	simulation, err := ms.SimulateMarkPrices("user1", prices)
+	require.NoError(t, err)
+	assert.Len(t, simulation.Positions, 3)
+	assert.Equal(t, []string{isolated.ID}, simulation.Liquidatable)
+	assert.InDelta(t, 20000-10000+5000-1800, simulation.Equity, 1e-9)
+
+	// nothing moved
+	for id, pos := range held {
+		snapshot := pos.Snapshot()
+		assert.Equal(t, live[id].MarkPrice, snapshot.MarkPrice)
+		assert.Equal(t, live[id].UnrealizedPnL, snapshot.UnrealizedPnL)
+		assert.Equal(t, position.PositionNormal, snapshot.Status)
+	}
+	assert.Equal(t, equityBefore, account.GetAccountEquity())
+
+	// apply the same prices for real: the simulation matches
+	for symbol, price := range prices {
+		_, err = pm.UpdateMarkPrices(symbol, price)
+		require.NoError(t, err)
+	}
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+	assert.InDelta(t, account.GetAccountEquity(), simulation.Equity, 1e-9)
+	for _, sim := range simulation.Positions {
+		pos := held[sim.PositionID]
+		assert.InDelta(t, pos.Snapshot().UnrealizedPnL, sim.UnrealizedPnL, 1e-9)
+		assert.InDelta(t, pos.GetMarginRatio(), sim.MarginRatio, 1e-9)
+		assert.Equal(t, pos.IsLiquidatable(), sim.IsLiquidatable)
+	}
+
+	// unknown symbols keep the current mark, invalid prices are refused
+	simulation, err = ms.SimulateMarkPrices("user1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 45000.0, simulation.Prices["BTCUSDT"])
+	_, err = ms.SimulateMarkPrices("user1", map[string]float64{"BTCUSDT": 0})
+	assert.Error(t, err)
+	_, err = ms.SimulateMarkPrices("unknown", prices)
+	assert.Error(t, err)
+}
+
+func TestRiskReport(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, &MarginConfig{DailyLossLimit: 100})
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 10000))
+
+	// realized loss today: 200 on ETH
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", position.LONG, 3000, 1, 10)
+	require.NoError(t, err)
+	_, _, err = ms.SettleReduceFill("user1", "ETHUSDT", position.LONG, 2800, 1, 0)
+	require.NoError(t, err)
+
+	// 50x BTC long worth ~5x the equity, marked down next to its liquidation price
+	pos, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 1, 50)
+	require.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 49500)
+	require.NoError(t, err)
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+
+	report := ms.RiskReport("user1")
+	assert.InDelta(t, 49500, report.TotalPositionValue, 1e-9)
+	assert.InDelta(t, -500, report.TotalUnrealizedPnL, 1e-9)
+	assert.InDelta(t, (9800-500)/1000.0, report.MarginLevel, 1e-9)
+	assert.InDelta(t, 9800-500-1000, report.AvailableBalance, 1e-9)
+	assert.Greater(t, report.MaxPositionRiskScore, 0.3)
+
+	require.Len(t, report.LiquidationWarnings, 1)
+	assert.Equal(t, pos.ID, report.LiquidationWarnings[0].PositionID)
+	assert.Less(t, report.LiquidationWarnings[0].Distance, LiquidationProximity)
+
+	assert.InDelta(t, 200, report.DailyLossToday, 1e-9)
+	assert.Equal(t, 100.0, report.DailyLossLimit)
+	assert.Error(t, ms.CheckDailyLossLimit("user1"))
+
+	require.Len(t, report.ConcentrationWarnings, 1)
+	assert.Equal(t, "BTCUSDT", report.ConcentrationWarnings[0].Symbol)
+	assert.Greater(t, report.ConcentrationWarnings[0].EquityMultiple, DefaultConcentrationLimit)
+
+	assert.Len(t, report.RecommendedActions, 3)
+
+	// read-only
+	assert.Equal(t, position.PositionNormal, pos.Snapshot().Status)
+	assert.Equal(t, report.LiquidationWarnings, ms.GetPositionsByLiquidationProximity("user1", LiquidationProximity))
+
+	// a quiet account
+	_, err = ms.CreateAccount("user2")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user2", 10000))
+	quiet := ms.RiskReport("user2")
+	assert.Equal(t, 999.0, quiet.MarginLevel)
+	assert.Empty(t, quiet.LiquidationWarnings)
+	assert.Empty(t, quiet.ConcentrationWarnings)
+	assert.Empty(t, quiet.RecommendedActions)
+	assert.NoError(t, ms.CheckDailyLossLimit("user2"))
+}
+
+func TestSetConfig(t *testing.T) {
+	ms := NewMarginSystem(position.NewPositionManager([]string{"BTCUSDT"}), nil)
+	require.NoError(t, ms.SetLiquidationFeeRate("ETHUSDT", 0.01))
+
+	config := ms.Config()
+	config.LiquidationFeeRate = 0.02
+	config.DefaultInitialMarginRate = 0.2
+	ms.SetConfig(config)
+	assert.Equal(t, 0.02, ms.LiquidationFeeRate("BTCUSDT"))
+	assert.Equal(t, 0.01, ms.LiquidationFeeRate("ETHUSDT"), "symbol override kept")
+	assert.Equal(t, 0.2, ms.getRequirement("BTCUSDT").InitialMarginRate)
+	assert.Equal(t, 0.0, DefaultMarginConfig.LiquidationFeeRate, "defaults untouched")
+}
@@ -0,0 +1,99 @@
+package margin
+
+import (
+	"fmt"
+	"time"
+)
+
+// Merge (帳戶合併) move balances, frozen order margin, realized PnL, rebates and trade history of source
+// into the receiver and mark source merged, atomically under both locks taken in user ID order.
+// positions stay with their user, a source still holding position margin is refused
+func (ma *MarginAccount) Merge(source *MarginAccount) error {
+	if source == nil || source == ma {
+		return fmt.Errorf("merge needs another account")
+	}
+	if source.UserID == ma.UserID {
+		return fmt.Errorf("can not merge account %s into itself", ma.UserID)
+	}
+
+	first, second := ma, source
+	if second.UserID < first.UserID {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	if err := ma.checkActive(); err != nil {
+		return err
+	}
+	if err := source.checkActive(); err != nil {
+		return err
+	}
+	if source.PositionMargin > 0 {
+		return fmt.Errorf("%w: %s holds position margin %.2f", ErrAccountInUse, source.UserID, source.PositionMargin)
+	}
+	if ma.balanceCap > 0 && ma.Balance+source.Balance > ma.balanceCap {
+		return fmt.Errorf("%w: balance %.2f + merged %.2f > cap %.2f",
+			ErrBalanceCapExceeded, ma.Balance, source.Balance, ma.balanceCap)
+	}
+
+	ma.Balance += source.Balance
+	ma.AvailableBalance += source.AvailableBalance
+	ma.FrozenBalance += source.FrozenBalance
+	ma.OrderMargin += source.OrderMargin
+	ma.RealizedPnL += source.RealizedPnL
+	ma.AccruedRebates += source.AccruedRebates
+
+	// oldest first, so the receiver's ring keeps the newest trades when it overflows
+	for _, record := range source.tradeHistory[source.tradeHead:] {
+		ma.appendTrade(record)
+	}
+	for _, record := range source.tradeHistory[:source.tradeHead] {
+		ma.appendTrade(record)
+	}
+
+	now := time.Now()
+	ma.UpdatedAt = now
+	source.Balance, source.AvailableBalance, source.FrozenBalance, source.OrderMargin = 0, 0, 0, 0
+	source.RealizedPnL, source.AccruedRebates = 0, 0
+	source.Status = AccountMerged
+	source.UpdatedAt = now
+	return nil
+}
+
+// MergeAccounts merge the account of sourceUserID into the one of targetUserID, pending order margin
+// reservations of the source move to the target with the frozen margin
+func (ms *MarginSystem) MergeAccounts(targetUserID, sourceUserID string) error {
+	target, err := ms.GetAccount(targetUserID)
+	if err != nil {
+		return err
+	}
+	source, err := ms.GetAccount(sourceUserID)
+	if err != nil {
+		return err
+	}
+
+	// no reservation of the source is released between the merge and the move
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+
+	balance, sourceBalance := target.getBalance(), source.getBalance()
+	err = target.Merge(source)
+	ms.audit(target, targetUserID, AuditMerge, target.getBalance()-balance, balance, err)
+	if err != nil {
+		return err
+	}
+	ms.audit(source, sourceUserID, AuditMerge, -sourceBalance, sourceBalance, nil)
+
+	for orderID, reservation := range ms.reservations[sourceUserID] {
+		if ms.reservations[targetUserID] == nil {
+			ms.reservations[targetUserID] = make(map[string]*MarginReservation)
+		}
+		reservation.UserID = targetUserID
+		ms.reservations[targetUserID][orderID] = reservation
+	}
+	delete(ms.reservations, sourceUserID)
+	return nil
+}
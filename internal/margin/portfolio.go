@@ -0,0 +1,173 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/position"
+)
+
+// OffsetGroup symbols moving together (e.g. BTCUSDT + BTCUSD), gains of one offset losses of
+// another after the Haircut (0.2 means only 80% of the gains are credited)
+type OffsetGroup struct {
+	Name    string
+	Symbols []string
+	Haircut float64
+}
+
+// PortfolioMarginConfig (組合保證金) requirement = worst loss over Shocks, per offset group.
+// symbols outside any group are margined on their own.
+type PortfolioMarginConfig struct {
+	Shocks []float64 // relative price moves, e.g. -0.1 = price down 10%
+	Groups []OffsetGroup
+}
+
+var DefaultPortfolioMarginConfig = &PortfolioMarginConfig{
+	Shocks: []float64{-0.10, -0.05, 0.05, 0.10},
+}
+
+// SetPortfolioMarginConfig nil means DefaultPortfolioMarginConfig
+func (ms *MarginSystem) SetPortfolioMarginConfig(config *PortfolioMarginConfig) {
+	if config == nil {
+		config = DefaultPortfolioMarginConfig
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.portfolioConfig = config
+}
+
+// SetPortfolioMargin opt the user in or out of portfolio margin, default is additive
+func (ms *MarginSystem) SetPortfolioMargin(userID string, enabled bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.accounts[userID]; !ok {
+		return fmt.Errorf("account not found")
+	}
+	if enabled {
+		ms.portfolioUsers[userID] = true
+	} else {
+		delete(ms.portfolioUsers, userID)
+	}
+	return nil
+}
+
+func (ms *MarginSystem) IsPortfolioMargin(userID string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.portfolioUsers[userID]
+}
+
+// CalculatePortfolioRequirement margin required by the user's open positions under portfolio margin
+func (ms *MarginSystem) CalculatePortfolioRequirement(userID string) (float64, error) {
+	if _, err := ms.GetAccount(userID); err != nil {
+		return 0, err
+	}
+	return ms.portfolioRequirement(ms.userExposures(userID)), nil
+}
+
+// CheckOrderMarginForSide like CheckOrderMargin, portfolio margin users get credit when the order hedges
+func (ms *MarginSystem) CheckOrderMarginForSide(userID, symbol string, side position.PositionSide, size, price float64, leverage int16) error {
+	if !ms.IsPortfolioMargin(userID) {
+		return ms.CheckOrderMargin(userID, symbol, size, price, leverage)
+	}
+	return ms.checkPortfolioOrderMargin(userID, symbol, []position.PositionSide{side}, size, price)
+}
+
+// checkPortfolioOrderMargin requirement after the order fills must fit in equity minus order margin,
+// with several sides the worst one is used
+func (ms *MarginSystem) checkPortfolioOrderMargin(userID, symbol string, sides []position.PositionSide, size, price float64) error {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return err
+	}
+
+	current := ms.userExposures(userID)
+	required := 0.0
+	for _, side := range sides {
+		exposures := make(map[string]float64, len(current)+1)
+		for s, notional := range current {
+			exposures[s] = notional
+		}
+		exposures[symbol] += float64(side) * size * price
+		required = max(required, ms.portfolioRequirement(exposures))
+	}
+
+	account.mu.RLock()
+	available := account.Balance + account.UnrealizedPnL - account.OrderMargin
+	account.mu.RUnlock()
+
+	if available < required {
+		return fmt.Errorf("%w (portfolio): required %.2f, available %.2f", ErrInsufficientMargin, required, available)
+	}
+	return nil
+}
+
+// userExposures signed notional per symbol (long > 0) of open positions at mark price
+func (ms *MarginSystem) userExposures(userID string) map[string]float64 {
+	exposures := make(map[string]float64)
+
+	positions, err := ms.positionMgr.GetUserPositions(userID)
+	if err != nil {
+		return exposures // no positions
+	}
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+			continue
+		}
+		price := snapshot.MarkPrice
+		if price <= 0 {
+			price = snapshot.EntryPrice
+		}
+		exposures[snapshot.Symbol] += float64(snapshot.Side) * snapshot.Size * price
+	}
+	return exposures
+}
+
+// portfolioRequirement sum over offset groups of the worst scenario loss
+func (ms *MarginSystem) portfolioRequirement(exposures map[string]float64) float64 {
+	ms.mu.RLock()
+	config := ms.portfolioConfig
+	ms.mu.RUnlock()
+
+	type group struct {
+		haircut   float64
+		notionals []float64
+	}
+	groups := make(map[string]*group)
+	groupOf := make(map[string]OffsetGroup)
+	for _, offsetGroup := range config.Groups {
+		for _, symbol := range offsetGroup.Symbols {
+			groupOf[symbol] = offsetGroup
+		}
+	}
+
+	for symbol, notional := range exposures {
+		key, haircut := "symbol:"+symbol, 0.0
+		if offsetGroup, ok := groupOf[symbol]; ok {
+			key, haircut = "group:"+offsetGroup.Name, offsetGroup.Haircut
+		}
+		if _, ok := groups[key]; !ok {
+			groups[key] = &group{haircut: haircut}
+		}
+		groups[key].notionals = append(groups[key].notionals, notional)
+	}
+
+	total := 0.0
+	for _, g := range groups {
+		worst := 0.0
+		for _, shock := range config.Shocks {
+			gains, losses := 0.0, 0.0
+			for _, notional := range g.notionals {
+				if pnl := notional * shock; pnl > 0 {
+					gains += pnl
+				} else {
+					losses -= pnl
+				}
+			}
+			worst = max(worst, losses-gains*(1-g.haircut))
+		}
+		total += worst
+	}
+	return total
+}
@@ -0,0 +1,89 @@
+package margin
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPortfolioSystem BTCUSDT / BTCUSD in one offset group, shocks up to 10% (= 10x initial margin)
+func newPortfolioSystem(t *testing.T) (*position.PositionManager, *MarginSystem) {
+	pm := position.NewPositionManager([]string{"BTCUSDT", "BTCUSD", "ETHUSDT"})
+	ms := NewMarginSystem(pm, nil)
+	ms.SetPortfolioMarginConfig(&PortfolioMarginConfig{
+		Shocks: []float64{-0.10, -0.05, 0.05, 0.10},
+		Groups: []OffsetGroup{{Name: "BTC", Symbols: []string{"BTCUSDT", "BTCUSD"}, Haircut: 0.2}},
+	})
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 20000))
+	require.NoError(t, ms.SetPortfolioMargin("user1", true))
+	return pm, ms
+}
+
+// additiveMargin sum of per-position initial margins
+func additiveMargin(t *testing.T, pm *position.PositionManager, userID string) float64 {
+	positions, err := pm.GetUserPositions(userID)
+	require.NoError(t, err)
+	total := 0.0
+	for _, pos := range positions {
+		total += pos.InitialMargin
+	}
+	return total
+}
+
+func TestPortfolioMarginHedgedPair(t *testing.T) {
+	pm, ms := newPortfolioSystem(t)
+	require.NoError(t, pm.SetPositionMode("user1", position.HedgeMode))
+
+	_, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "user1", "BTCUSD", position.SHORT, 50000, 1, 10)
+	require.NoError(t, err)
+
+	required, err := ms.CalculatePortfolioRequirement("user1")
+	require.NoError(t, err)
+	// +10%: short loses 5000, long gains 5000 * 80% -> 1000
+	assert.InDelta(t, 1000.0, required, 1e-9)
+	assert.Equal(t, 10000.0, additiveMargin(t, pm, "user1"))
+	assert.Less(t, required, additiveMargin(t, pm, "user1")/5)
+
+	// hedge-closing order only needs the residual, a same-direction order needs the full move
+	require.NoError(t, ms.CheckOrderMarginForSide("user1", "BTCUSD", position.SHORT, 3, 50000, 10))
+	assert.Error(t, ms.CheckOrderMarginForSide("user1", "BTCUSDT", position.LONG, 4, 50000, 10))
+
+	level, err := ms.GetMarginLevel("user1")
+	require.NoError(t, err)
+	assert.InDelta(t, 20000.0/1000, level, 1e-9)
+}
+
+func TestPortfolioMarginUnhedgedEqualsAdditive(t *testing.T) {
+	pm, ms := newPortfolioSystem(t)
+
+	_, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "user1", "ETHUSDT", position.SHORT, 3000, 10, 10)
+	require.NoError(t, err)
+
+	required, err := ms.CalculatePortfolioRequirement("user1")
+	require.NoError(t, err)
+	assert.InDelta(t, additiveMargin(t, pm, "user1"), required, 1e-9)
+}
+
+func TestPortfolioMarginOptIn(t *testing.T) {
+	pm, ms := newPortfolioSystem(t)
+	require.NoError(t, ms.SetPortfolioMargin("user1", false))
+	assert.Error(t, ms.SetPortfolioMargin("nobody", true))
+
+	_, err := pm.OpenPosition(common.CROSS, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+
+	// additive mode untouched: 10000 available, 0.25 * 50000 / 10 needs 1250
+	require.NoError(t, ms.UpdatePositionMargin("user1"))
+	assert.Equal(t, ms.CheckOrderMargin("user1", "BTCUSD", 0.25, 50000, 10),
+		ms.CheckOrderMarginForSide("user1", "BTCUSD", position.SHORT, 0.25, 50000, 10))
+}
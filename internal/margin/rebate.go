@@ -0,0 +1,83 @@
+package margin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AccrueMakerRebate (maker 返佣) accrue instead of crediting every trade, paid out
+// once the accrued amount reaches RebatePayoutThreshold or by the payout schedule.
+// return the amount paid out by this call, usually 0.
+func (ms *MarginSystem) AccrueMakerRebate(userID string, amount float64) (float64, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	accrued, err := account.AccrueRebate(amount)
+	if err != nil {
+		return 0, err
+	}
+
+	batched := ms.currentConfig().RebatePayoutThreshold > 0 || ms.currentConfig().RebatePayoutInterval > 0
+	if !batched || (ms.currentConfig().RebatePayoutThreshold > 0 && accrued >= ms.currentConfig().RebatePayoutThreshold) {
+		return account.PayoutRebates(), nil
+	}
+	return 0, nil
+}
+
+// GetAccruedRebates rebates owed to the user but not yet paid
+func (ms *MarginSystem) GetAccruedRebates(userID string) (float64, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+	return account.GetAccruedRebates(), nil
+}
+
+// PayoutRebates force pay out the accrued rebates of one user
+func (ms *MarginSystem) PayoutRebates(userID string) (float64, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+	return account.PayoutRebates(), nil
+}
+
+// PayoutAllRebates pay out every account with accrued rebates, return userID -> amount paid
+func (ms *MarginSystem) PayoutAllRebates() map[string]float64 {
+	ms.mu.RLock()
+	accounts := make([]*MarginAccount, 0, len(ms.accounts))
+	for _, account := range ms.accounts {
+		accounts = append(accounts, account)
+	}
+	ms.mu.RUnlock()
+
+	paid := make(map[string]float64)
+	for _, account := range accounts {
+		if amount := account.PayoutRebates(); amount > 0 {
+			paid[account.UserID] = amount
+		}
+	}
+	return paid
+}
+
+// RunRebatePayout pay out all accrued rebates every RebatePayoutInterval until ctx is done
+func (ms *MarginSystem) RunRebatePayout(ctx context.Context) error {
+	if ms.currentConfig().RebatePayoutInterval <= 0 {
+		return fmt.Errorf("rebate payout interval not configured")
+	}
+
+	ticker := time.NewTicker(ms.currentConfig().RebatePayoutInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			ms.PayoutAllRebates()
+		}
+	}
+}
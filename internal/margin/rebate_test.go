@@ -0,0 +1,95 @@
+package margin
+
+import (
+	"frizo/futures_engine/internal/position"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRebateSystem(t *testing.T, config *MarginConfig) *MarginSystem {
+	ms := NewMarginSystem(position.NewPositionManager(symbols), config)
+	_, err := ms.CreateAccount("maker")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("maker", 1000))
+	return ms
+}
+
+func TestRebateBatchedPayoutEqualsPerTradeSum(t *testing.T) {
+	ms := newRebateSystem(t, &MarginConfig{RebatePayoutInterval: time.Hour})
+
+	// 1000 筆小額成交, maker fee -0.01% of notional
+	perTradeSum := 0.0
+	for i := 0; i < 1000; i++ {
+		rebate := float64(100+i%7) * 0.0001
+		perTradeSum += rebate
+		paid, err := ms.AccrueMakerRebate("maker", rebate)
+		require.NoError(t, err)
+		assert.Zero(t, paid)
+	}
+
+	accrued, err := ms.GetAccruedRebates("maker")
+	require.NoError(t, err)
+	assert.InDelta(t, perTradeSum, accrued, 1e-9)
+
+	account, _ := ms.GetAccount("maker")
+	assert.Equal(t, 1000.0, account.AvailableBalance, "nothing credited before payout")
+
+	paid, err := ms.PayoutRebates("maker")
+	require.NoError(t, err)
+	assert.InDelta(t, perTradeSum, paid, 1e-9)
+	assert.InDelta(t, 1000+perTradeSum, account.AvailableBalance, 1e-9)
+	assert.InDelta(t, 1000+perTradeSum, account.Balance, 1e-9)
+	assert.Zero(t, account.GetAccruedRebates())
+}
+
+func TestRebateThresholdPayout(t *testing.T) {
+	ms := newRebateSystem(t, &MarginConfig{RebatePayoutThreshold: 1})
+
+	paid, err := ms.AccrueMakerRebate("maker", 0.6)
+	require.NoError(t, err)
+	assert.Zero(t, paid)
+
+	paid, err = ms.AccrueMakerRebate("maker", 0.6)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.2, paid, 1e-9)
+
+	_, err = ms.AccrueMakerRebate("maker", -1)
+	assert.Error(t, err)
+}
+
+func TestRebateUnbatchedPaysImmediately(t *testing.T) {
+	ms := newRebateSystem(t, nil)
+
+	paid, err := ms.AccrueMakerRebate("maker", 0.5)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, paid)
+}
+
+func TestRebateSurvivesSnapshotRestore(t *testing.T) {
+	ms := newRebateSystem(t, &MarginConfig{RebatePayoutInterval: time.Hour})
+	_, err := ms.AccrueMakerRebate("maker", 2.5)
+	require.NoError(t, err)
+
+	account, _ := ms.GetAccount("maker")
+	snapshot := account.Snapshot()
+
+	restored := NewMarginSystem(position.NewPositionManager(symbols), &MarginConfig{RebatePayoutInterval: time.Hour})
+	_, err = restored.RestoreAccount(snapshot)
+	require.NoError(t, err)
+	_, err = restored.RestoreAccount(snapshot)
+	assert.Error(t, err)
+
+	accrued, err := restored.GetAccruedRebates("maker")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, accrued)
+
+	summary, err := restored.GetAccountSummary("maker")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, summary["accrued_rebates"])
+
+	assert.Equal(t, map[string]float64{"maker": 2.5}, restored.PayoutAllRebates())
+	assert.Empty(t, restored.PayoutAllRebates())
+}
@@ -0,0 +1,172 @@
+package margin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultRedisKeyPrefix prefix of the account keys when RedisStoreConfig.KeyPrefix is ""
+	DefaultRedisKeyPrefix = "futures_engine:"
+	// DefaultRedisMaxRetries attempts of an Update against concurrent writers when RedisStoreConfig.MaxRetries is 0
+	DefaultRedisMaxRetries = 20
+
+	retryBackoff = 200 * time.Microsecond // upper bound of the first retry delay, grows with the attempts
+)
+
+// RedisStoreConfig "" Addr means no Redis, see NewAccountStore
+type RedisStoreConfig struct {
+	Addr       string // host:port
+	Password   string
+	DB         int
+	KeyPrefix  string // "" means DefaultRedisKeyPrefix
+	MaxRetries int    // 0 means DefaultRedisMaxRetries
+}
+
+// RedisAccountStore accounts as JSON AccountSnapshot values under <prefix>account:<userID>, plus the set
+// of user ids under <prefix>accounts. Update is optimistic: WATCH the key, run fn on the value read, write
+// it in MULTI / EXEC, and start over when another client changed the key in between
+type RedisAccountStore struct {
+	client *redis.Client
+	config RedisStoreConfig
+}
+
+func NewRedisAccountStore(config *RedisStoreConfig) *RedisAccountStore {
+	s := &RedisAccountStore{config: *config}
+	if s.config.KeyPrefix == "" {
+		s.config.KeyPrefix = DefaultRedisKeyPrefix
+	}
+	if s.config.MaxRetries <= 0 {
+		s.config.MaxRetries = DefaultRedisMaxRetries
+	}
+	s.client = redis.NewClient(&redis.Options{Addr: s.config.Addr, Password: s.config.Password, DB: s.config.DB})
+	return s
+}
+
+// Ping check the connection
+func (s *RedisAccountStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// Close the Redis client
+func (s *RedisAccountStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisAccountStore) Get(ctx context.Context, userID string) (AccountSnapshot, error) {
+	return s.get(ctx, s.client, userID)
+}
+
+func (s *RedisAccountStore) Put(ctx context.Context, account AccountSnapshot) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.accountKey(account.UserID), data, 0)
+		pipe.SAdd(ctx, s.usersKey(), account.UserID)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisAccountStore) List(ctx context.Context) ([]AccountSnapshot, error) {
+	users, err := s.client.SMembers(ctx, s.usersKey()).Result()
+	if err != nil || len(users) == 0 {
+		return []AccountSnapshot{}, err
+	}
+	sort.Strings(users)
+
+	keys := make([]string, len(users))
+	for i, userID := range users {
+		keys[i] = s.accountKey(userID)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]AccountSnapshot, 0, len(values))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue // listed but removed in between
+		}
+		var account AccountSnapshot
+		if err = json.Unmarshal([]byte(data), &account); err != nil {
+			return nil, fmt.Errorf("decode account %s: %w", users[i], err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+func (s *RedisAccountStore) Update(ctx context.Context, userID string, fn func(account *AccountSnapshot) error) (AccountSnapshot, error) {
+	key := s.accountKey(userID)
+	var updated AccountSnapshot
+	for attempt := 0; attempt < s.config.MaxRetries; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			account, err := s.get(ctx, tx, userID)
+			if errors.Is(err, ErrAccountNotFound) {
+				account = AccountSnapshot{UserID: userID}
+			} else if err != nil {
+				return err
+			}
+			if err = fn(&account); err != nil {
+				return err
+			}
+			account.UserID = userID
+			data, err := json.Marshal(account)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				pipe.SAdd(ctx, s.usersKey(), userID)
+				return nil
+			})
+			updated = account
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return updated, err
+		}
+
+		// lost to another writer: back off a little so the contenders spread out
+		select {
+		case <-ctx.Done():
+			return AccountSnapshot{}, ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(attempt+1) * int64(retryBackoff)))):
+		}
+	}
+	return AccountSnapshot{}, fmt.Errorf("%w: %s after %d attempts", ErrStoreConflict, userID, s.config.MaxRetries)
+}
+
+func (s *RedisAccountStore) get(ctx context.Context, client redis.Cmdable, userID string) (AccountSnapshot, error) {
+	data, err := client.Get(ctx, s.accountKey(userID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return AccountSnapshot{}, fmt.Errorf("%w: %s", ErrAccountNotFound, userID)
+	}
+	if err != nil {
+		return AccountSnapshot{}, err
+	}
+	var account AccountSnapshot
+	if err = json.Unmarshal(data, &account); err != nil {
+		return AccountSnapshot{}, fmt.Errorf("decode account %s: %w", userID, err)
+	}
+	return account, nil
+}
+
+func (s *RedisAccountStore) accountKey(userID string) string {
+	return s.config.KeyPrefix + "account:" + userID
+}
+
+func (s *RedisAccountStore) usersKey() string {
+	return s.config.KeyPrefix + "accounts"
+}
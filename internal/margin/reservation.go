@@ -0,0 +1,167 @@
+package margin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultReservationTTL lifetime of an order margin reservation (limit orders) when MarginConfig.ReservationTTL is 0
+const DefaultReservationTTL = 30 * time.Second
+
+// MarginReservation (委託保證金預留) order margin frozen until the order fills, is cancelled or the reservation expires
+type MarginReservation struct {
+	UserID    string    `json:"user_id"`
+	OrderID   string    `json:"order_id"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ReserveMarginForOrder freeze amount of the available balance for the order, released automatically
+// by RunReservationExpiry once the reservation TTL passed
+func (ms *MarginSystem) ReserveMarginForOrder(ctx context.Context, userID, orderID string, amount float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return err
+	}
+
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+
+	if _, exists := ms.reservations[userID][orderID]; exists {
+		return fmt.Errorf("order %s already has a margin reservation", orderID)
+	}
+	if err = account.FreezeOrderMargin(amount); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if ms.reservations[userID] == nil {
+		ms.reservations[userID] = make(map[string]*MarginReservation)
+	}
+	ms.reservations[userID][orderID] = &MarginReservation{
+		UserID:    userID,
+		OrderID:   orderID,
+		Amount:    amount,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ms.reservationTTL()),
+	}
+	return nil
+}
+
+// ReleaseMarginReservation unfreeze the reservation of a filled or cancelled order
+func (ms *MarginSystem) ReleaseMarginReservation(userID, orderID string) error {
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+
+	reservation, exists := ms.reservations[userID][orderID]
+	if !exists {
+		return fmt.Errorf("no margin reservation for order %s", orderID)
+	}
+	return ms.release(reservation)
+}
+
+// ReleaseFilledReservation unfreeze the share of the order's reservation a fill consumed: filled out of
+// filled + remaining size. the whole reservation goes once nothing remains
+func (ms *MarginSystem) ReleaseFilledReservation(userID, orderID string, filled, remaining float64) error {
+	if filled <= 0 {
+		return fmt.Errorf("filled size must be greater than zero")
+	}
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+
+	reservation, exists := ms.reservations[userID][orderID]
+	if !exists {
+		return fmt.Errorf("no margin reservation for order %s", orderID)
+	}
+	if remaining <= 0 {
+		return ms.release(reservation)
+	}
+
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return err
+	}
+	amount := reservation.Amount * filled / (filled + remaining)
+	if err = account.UnFreezeOrderMargin(amount); err != nil {
+		return err
+	}
+	reservation.Amount -= amount
+	return nil
+}
+
+// GetPendingReservations active reservations of the user, oldest first
+func (ms *MarginSystem) GetPendingReservations(userID string) []MarginReservation {
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+
+	reservations := make([]MarginReservation, 0, len(ms.reservations[userID]))
+	for _, reservation := range ms.reservations[userID] {
+		reservations = append(reservations, *reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].CreatedAt.Before(reservations[j].CreatedAt)
+	})
+	return reservations
+}
+
+// ReleaseExpiredReservations unfreeze every reservation expired at now, return how many were released
+func (ms *MarginSystem) ReleaseExpiredReservations(now time.Time) int {
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+
+	released := 0
+	for _, reservations := range ms.reservations {
+		for _, reservation := range reservations {
+			if now.Before(reservation.ExpiresAt) {
+				continue
+			}
+			if err := ms.release(reservation); err == nil {
+				released++
+			}
+		}
+	}
+	return released
+}
+
+// RunReservationExpiry release expired reservations until ctx is done, checked several times per TTL
+func (ms *MarginSystem) RunReservationExpiry(ctx context.Context) error {
+	interval := min(max(ms.reservationTTL()/4, time.Millisecond), time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			ms.ReleaseExpiredReservations(now)
+		}
+	}
+}
+
+func (ms *MarginSystem) reservationTTL() time.Duration {
+	if ms.currentConfig().ReservationTTL > 0 {
+		return ms.currentConfig().ReservationTTL
+	}
+	return DefaultReservationTTL
+}
+
+// release unfreeze and forget one reservation, reservationMu held
+func (ms *MarginSystem) release(reservation *MarginReservation) error {
+	delete(ms.reservations[reservation.UserID], reservation.OrderID)
+	if len(ms.reservations[reservation.UserID]) == 0 {
+		delete(ms.reservations, reservation.UserID)
+	}
+
+	account, err := ms.GetAccount(reservation.UserID)
+	if err != nil {
+		return err
+	}
+	return account.UnFreezeOrderMargin(reservation.Amount)
+}
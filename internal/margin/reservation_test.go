@@ -0,0 +1,104 @@
+package margin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveMarginForOrderExpires(t *testing.T) {
+	ms := NewMarginSystem(nil, &MarginConfig{ReservationTTL: 100 * time.Millisecond})
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 1000))
+	account, err := ms.GetAccount("user1")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ms.RunReservationExpiry(ctx)
+
+	require.NoError(t, ms.ReserveMarginForOrder(ctx, "user1", "order1", 300))
+	assert.Equal(t, 700.0, account.Snapshot().AvailableBalance)
+	assert.Equal(t, 300.0, account.Snapshot().FrozenBalance)
+	assert.Error(t, ms.ReserveMarginForOrder(ctx, "user1", "order1", 100), "one reservation per order")
+	assert.Error(t, ms.ReserveMarginForOrder(ctx, "user1", "order2", 800), "beyond the available balance")
+
+	pending := ms.GetPendingReservations("user1")
+	require.Len(t, pending, 1)
+	assert.Equal(t, "order1", pending[0].OrderID)
+	assert.Equal(t, 300.0, pending[0].Amount)
+	assert.Equal(t, 100*time.Millisecond, pending[0].ExpiresAt.Sub(pending[0].CreatedAt))
+
+	time.Sleep(200 * time.Millisecond)
+
+	assert.Empty(t, ms.GetPendingReservations("user1"))
+	snapshot := account.Snapshot()
+	assert.Equal(t, 1000.0, snapshot.AvailableBalance)
+	assert.Zero(t, snapshot.FrozenBalance)
+	assert.Zero(t, snapshot.OrderMargin)
+}
+
+func TestReleaseMarginReservation(t *testing.T) {
+	ms := NewMarginSystem(nil, nil)
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 1000))
+
+	require.NoError(t, ms.ReserveMarginForOrder(context.Background(), "user1", "order1", 300))
+	pending := ms.GetPendingReservations("user1")
+	require.Len(t, pending, 1)
+	assert.Equal(t, DefaultReservationTTL, pending[0].ExpiresAt.Sub(pending[0].CreatedAt))
+	assert.Zero(t, ms.ReleaseExpiredReservations(time.Now()))
+
+	// cancelled order
+	require.NoError(t, ms.ReleaseMarginReservation("user1", "order1"))
+	assert.Error(t, ms.ReleaseMarginReservation("user1", "order1"))
+	account, err := ms.GetAccount("user1")
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, account.Snapshot().AvailableBalance)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, ms.ReserveMarginForOrder(ctx, "user1", "order2", 100), context.Canceled)
+	assert.ErrorIs(t, ms.ReserveMarginForOrder(context.Background(), "nobody", "order3", 100), ErrAccountNotFound)
+}
+
+func TestExportRestoreState(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMarginSystem(nil, nil)
+	for _, userID := range []string{"bob", "alice"} {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 1000))
+	}
+	require.NoError(t, ms.ReserveMarginForOrder(ctx, "alice", "order1", 300))
+	require.NoError(t, ms.ReserveMarginForOrder(ctx, "alice", "order2", 100))
+	require.NoError(t, ms.SetPortfolioMargin("bob", true))
+	require.NoError(t, ms.InsuranceFund().Deposit("pos1", 50))
+
+	state := ms.ExportState()
+	require.Len(t, state.Accounts, 2)
+	assert.Equal(t, "alice", state.Accounts[0].UserID)
+	require.Len(t, state.Reservations, 2)
+	assert.Equal(t, "order1", state.Reservations[0].OrderID)
+	assert.Equal(t, []string{"bob"}, state.PortfolioUsers)
+
+	restored := NewMarginSystem(nil, nil)
+	require.NoError(t, restored.RestoreState(state))
+	assert.Equal(t, state, restored.ExportState())
+	assert.True(t, restored.IsPortfolioMargin("bob"))
+	assert.Equal(t, 50.0, restored.InsuranceFund().Balance())
+
+	// the restored reservation releases into the restored order margin
+	require.NoError(t, restored.ReleaseMarginReservation("alice", "order1"))
+	alice, err := restored.GetAccount("alice")
+	require.NoError(t, err)
+	assert.Equal(t, 900.0, alice.Snapshot().AvailableBalance)
+	assert.Equal(t, 100.0, alice.Snapshot().OrderMargin)
+
+	assert.Error(t, restored.RestoreState(state), "accounts already there")
+}
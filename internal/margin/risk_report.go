@@ -0,0 +1,290 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"sort"
+	"time"
+)
+
+// LiquidationProximity distance of the mark price to the liquidation price under which RiskReport warns
+const LiquidationProximity = 0.10
+
+// DefaultConcentrationLimit position value of one symbol, as a multiple of the account equity,
+// above which RiskReport warns when MarginConfig.ConcentrationLimit is 0
+const DefaultConcentrationLimit = 5.0
+
+// LiquidationWarning position whose mark price is close to its liquidation price
+type LiquidationWarning struct {
+	PositionID       string                `json:"position_id"`
+	Symbol           string                `json:"symbol"`
+	Side             position.PositionSide `json:"side"`
+	MarkPrice        float64               `json:"mark_price"`
+	LiquidationPrice float64               `json:"liquidation_price"`
+	Distance         float64               `json:"distance"` // relative move left, <= 0 when already crossed
+}
+
+// ConcentrationWarning symbol holding too large a share of the account's risk
+type ConcentrationWarning struct {
+	Symbol         string  `json:"symbol"`
+	PositionValue  float64 `json:"position_value"`
+	EquityMultiple float64 `json:"equity_multiple"` // position value / account equity
+	Limit          float64 `json:"limit"`
+}
+
+// RiskReport (風險報告) every risk figure of one user, computed from one snapshot of the account and positions
+type RiskReport struct {
+	UserID string `json:"user_id"`
+
+	MarginLevel        float64 `json:"margin_level"` // equity / used margin, 999 without used margin
+	MarginRatio        float64 `json:"margin_ratio"` // equity / position margin, 999.99 without positions
+	AvailableBalance   float64 `json:"available_balance"`
+	TotalPositionValue float64 `json:"total_position_value"`
+	TotalUnrealizedPnL float64 `json:"total_unrealized_pnl"`
+
+	MaxPositionRiskScore float64 `json:"max_position_risk_score"` // 0 safe .. 1 at or past liquidation
+
+	LiquidationWarnings   []LiquidationWarning   `json:"liquidation_warnings"` // closest first
+	DailyLossToday        float64                `json:"daily_loss_today"`
+	DailyLossLimit        float64                `json:"daily_loss_limit"` // 0 means no limit
+	ConcentrationWarnings []ConcentrationWarning `json:"concentration_warnings"`
+
+	RecommendedActions []string `json:"recommended_actions"`
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// riskView one read of the account and its open positions shared by the report sections
+type riskView struct {
+	balance     float64
+	orderMargin float64
+	trades      []TradeRecord
+	snapshots   []position.PositionSnapshot
+	equity      float64 // balance + unrealized PnL
+	positionIM  float64
+	unrealized  float64
+	portfolio   bool
+	requirement float64 // portfolio requirement, portfolio margin only
+}
+
+// RiskReport read-only consolidation of margin level, liquidation proximity, daily loss and concentration.
+// zero report for unknown users
+func (ms *MarginSystem) RiskReport(userID string) RiskReport {
+	now := time.Now()
+	report := RiskReport{UserID: userID, DailyLossLimit: ms.currentConfig().DailyLossLimit, Timestamp: now}
+
+	view, err := ms.riskView(userID)
+	if err != nil {
+		return report
+	}
+
+	report.TotalUnrealizedPnL = view.unrealized
+	report.MarginLevel = view.marginLevel()
+	report.MarginRatio = 999.99 // no position
+	if view.positionIM > 0 {
+		report.MarginRatio = view.equity / view.positionIM
+	}
+	report.AvailableBalance = max(view.equity-view.positionIM-view.orderMargin, 0)
+
+	for _, snapshot := range view.snapshots {
+		report.TotalPositionValue += snapshot.PositionValue
+		report.MaxPositionRiskScore = max(report.MaxPositionRiskScore, positionRiskScore(snapshot, view.equity))
+	}
+	report.LiquidationWarnings = liquidationWarnings(view.snapshots, LiquidationProximity)
+	report.DailyLossToday = dailyLoss(view.trades, now)
+	report.ConcentrationWarnings = concentrationWarnings(view.snapshots, view.equity, ms.concentrationLimit())
+
+	report.RecommendedActions = recommendedActions(report)
+	return report
+}
+
+// GetPositionsByLiquidationProximity open positions of the user whose mark price is within distance
+// (e.g. 0.1 for 10%) of the liquidation price, closest first
+func (ms *MarginSystem) GetPositionsByLiquidationProximity(userID string, distance float64) []LiquidationWarning {
+	view, err := ms.riskView(userID)
+	if err != nil {
+		return nil
+	}
+	return liquidationWarnings(view.snapshots, distance)
+}
+
+// GetConcentrationWarnings symbols whose position value exceeds the concentration limit times the equity
+func (ms *MarginSystem) GetConcentrationWarnings(userID string) []ConcentrationWarning {
+	view, err := ms.riskView(userID)
+	if err != nil {
+		return nil
+	}
+	return concentrationWarnings(view.snapshots, view.equity, ms.concentrationLimit())
+}
+
+// GetDailyLoss net realized loss (fees included) of the user's fills since UTC midnight, 0 on a profitable day
+func (ms *MarginSystem) GetDailyLoss(userID string) (float64, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return 0, err
+	}
+	return dailyLoss(account.TradeHistory(), time.Now()), nil
+}
+
+// CheckDailyLossLimit error once the user's daily loss reached MarginConfig.DailyLossLimit
+func (ms *MarginSystem) CheckDailyLossLimit(userID string) error {
+	limit := ms.currentConfig().DailyLossLimit
+	if limit <= 0 {
+		return nil
+	}
+	loss, err := ms.GetDailyLoss(userID)
+	if err != nil {
+		return err
+	}
+	if loss >= limit {
+		return fmt.Errorf("daily loss %.2f reached the limit %.2f", loss, limit)
+	}
+	return nil
+}
+
+func (ms *MarginSystem) riskView(userID string) (*riskView, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &riskView{trades: account.TradeHistory(), portfolio: ms.IsPortfolioMargin(userID)}
+	account.mu.RLock()
+	view.balance = account.Balance
+	view.orderMargin = account.OrderMargin
+	account.mu.RUnlock()
+
+	positions, _ := ms.positionMgr.GetUserPositions(userID) // error only for a user without positions yet
+	exposures := make(map[string]float64)
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+			continue
+		}
+		view.snapshots = append(view.snapshots, snapshot)
+		view.positionIM += snapshot.InitialMargin
+		view.unrealized += snapshot.UnrealizedPnL
+
+		price := snapshot.MarkPrice
+		if price <= 0 {
+			price = snapshot.EntryPrice
+		}
+		exposures[snapshot.Symbol] += float64(snapshot.Side) * snapshot.Size * price
+	}
+	view.equity = view.balance + view.unrealized
+	if view.portfolio {
+		view.requirement = ms.portfolioRequirement(exposures)
+	}
+	return view, nil
+}
+
+// marginLevel GetMarginLevel on the view
+func (v *riskView) marginLevel() float64 {
+	usedMargin := v.positionIM + v.orderMargin
+	if v.portfolio {
+		usedMargin = v.requirement + v.orderMargin
+	}
+	if usedMargin <= 0 {
+		return 999 // no order and position
+	}
+	return v.equity / usedMargin
+}
+
+func (ms *MarginSystem) concentrationLimit() float64 {
+	if ms.currentConfig().ConcentrationLimit > 0 {
+		return ms.currentConfig().ConcentrationLimit
+	}
+	return DefaultConcentrationLimit
+}
+
+// positionRiskScore maintenance margin over the equity backing the position, capped at 1
+func positionRiskScore(snapshot position.PositionSnapshot, accountEquity float64) float64 {
+	backing := snapshot.InitialMargin + snapshot.UnrealizedPnL
+	if snapshot.MarginMode == common.CROSS {
+		backing = accountEquity
+	}
+	if backing <= 0 {
+		return 1
+	}
+	return min(snapshot.MaintenanceMargin/backing, 1)
+}
+
+func liquidationWarnings(snapshots []position.PositionSnapshot, within float64) []LiquidationWarning {
+	var warnings []LiquidationWarning
+	for _, snapshot := range snapshots {
+		if snapshot.LiquidationPrice <= 0 || snapshot.MarkPrice <= 0 {
+			continue
+		}
+		distance := (snapshot.MarkPrice - snapshot.LiquidationPrice) / snapshot.MarkPrice
+		if snapshot.Side == position.SHORT {
+			distance = -distance
+		}
+		if distance > within {
+			continue
+		}
+		warnings = append(warnings, LiquidationWarning{
+			PositionID:       snapshot.ID,
+			Symbol:           snapshot.Symbol,
+			Side:             snapshot.Side,
+			MarkPrice:        snapshot.MarkPrice,
+			LiquidationPrice: snapshot.LiquidationPrice,
+			Distance:         distance,
+		})
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Distance < warnings[j].Distance })
+	return warnings
+}
+
+// dailyLoss net realized loss of the trades of now's UTC day
+func dailyLoss(trades []TradeRecord, now time.Time) float64 {
+	dayStart := now.UTC().Truncate(24 * time.Hour)
+	net := 0.0
+	for _, trade := range trades {
+		if trade.Timestamp.Before(dayStart) || trade.Timestamp.After(now) {
+			continue
+		}
+		net += trade.RealizedPnL - trade.Fee
+	}
+	return max(-net, 0)
+}
+
+func concentrationWarnings(snapshots []position.PositionSnapshot, equity, limit float64) []ConcentrationWarning {
+	values := make(map[string]float64)
+	for _, snapshot := range snapshots {
+		values[snapshot.Symbol] += snapshot.PositionValue
+	}
+
+	var warnings []ConcentrationWarning
+	for symbol, value := range values {
+		multiple := limit + 1 // no equity left: every holding is concentrated
+		if equity > 0 {
+			multiple = value / equity
+		}
+		if multiple <= limit {
+			continue
+		}
+		warnings = append(warnings, ConcentrationWarning{Symbol: symbol, PositionValue: value, EquityMultiple: multiple, Limit: limit})
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].PositionValue > warnings[j].PositionValue })
+	return warnings
+}
+
+func recommendedActions(report RiskReport) []string {
+	var actions []string
+	if report.MarginLevel < 1.5 {
+		actions = append(actions, "deposit funds or reduce positions to raise the margin level")
+	}
+	for _, warning := range report.LiquidationWarnings {
+		actions = append(actions, fmt.Sprintf("add margin to or reduce the %s %s position, %.1f%% from liquidation",
+			warning.Symbol, warning.Side, warning.Distance*100))
+	}
+	if report.DailyLossLimit > 0 && report.DailyLossToday >= report.DailyLossLimit {
+		actions = append(actions, "daily loss limit reached, stop opening new positions today")
+	}
+	for _, warning := range report.ConcentrationWarnings {
+		actions = append(actions, fmt.Sprintf("reduce %s exposure, %.1fx equity (limit %.1fx)",
+			warning.Symbol, warning.EquityMultiple, warning.Limit))
+	}
+	return actions
+}
@@ -0,0 +1,81 @@
+package margin
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulatedIsolation(t *testing.T) {
+	realPm := position.NewPositionManager(symbols)
+	realMs := NewMarginSystem(realPm, nil)
+	simPm := position.NewSimulatedPositionManager(symbols)
+	simMs := NewMarginSystem(simPm, nil)
+
+	assert.False(t, realMs.IsSimulated())
+	assert.True(t, simMs.IsSimulated())
+
+	// 真實帳戶不能灌入虛擬資金
+	_, err := realMs.SeedSimAccount("user1", 100000)
+	assert.Error(t, err)
+
+	_, err = realMs.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, realMs.Deposit("user1", 1000))
+
+	// 同一個 userID 在模擬命名空間是另一個帳戶
+	simAccount, err := simMs.SeedSimAccount("user1", 100000)
+	require.NoError(t, err)
+	_, err = simMs.SeedSimAccount("user1", 50000) // top up
+	require.NoError(t, err)
+	assert.Equal(t, 150000.0, simAccount.Balance)
+
+	realAccount, err := realMs.GetAccount("user1")
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, realAccount.Balance)
+
+	// 倉位標記 simulated, 不出現在真實倉位管理器
+	simPos, err := simPm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	assert.True(t, simPos.Simulated)
+	assert.True(t, simPos.Snapshot().Simulated)
+	_, err = realPm.GetPosition("user1", "BTCUSDT", position.LONG)
+	assert.Error(t, err)
+
+	realPos, err := realPm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	assert.False(t, realPos.Simulated)
+
+	oi, err := realPm.GetOpenInterest("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, 0.1, oi)
+}
+
+func TestSimulatedBooksNeverMatch(t *testing.T) {
+	realAsks := orderbook.NewBookSide(orderbook.SELL, nil)
+	simAsks := orderbook.NewSimulatedBookSide(orderbook.SELL, nil)
+
+	simOrder := &orderbook.Order{ID: "s1", UserID: "user1", Side: orderbook.SELL, Price: 50000, Size: 1, Simulated: true}
+	realOrder := &orderbook.Order{ID: "r1", UserID: "user2", Side: orderbook.SELL, Price: 50000, Size: 1}
+
+	assert.Error(t, realAsks.AddOrder(simOrder), "simulated order rejected by real book")
+	assert.Error(t, simAsks.AddOrder(realOrder), "real order rejected by simulated book")
+	require.NoError(t, simAsks.AddOrder(simOrder))
+	require.NoError(t, realAsks.AddOrder(realOrder))
+
+	// 真實 taker 只會吃到真實掛單
+	fills, _ := realAsks.Match(50000, 2)
+	require.Len(t, fills, 1)
+	assert.Equal(t, "r1", fills[0].MakerOrderID)
+	assert.Equal(t, 1, simAsks.Len())
+
+	// 事件標記 simulated
+	simHub := orderbook.NewSimulatedOrderEventHub(8)
+	realHub := orderbook.NewOrderEventHub(8)
+	assert.True(t, simHub.Publish(orderbook.OrderEvent{Type: orderbook.OrderAccepted, UserID: "user1"}).Simulated)
+	assert.False(t, realHub.Publish(orderbook.OrderEvent{Type: orderbook.OrderAccepted, UserID: "user1"}).Simulated)
+}
@@ -0,0 +1,156 @@
+package margin
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AccountSnapshot (帳戶快照) ledger fields of a MarginAccount captured at one instant
+type AccountSnapshot struct {
+	UserID string        `json:"user_id"`
+	Status AccountStatus `json:"status"`
+
+	Balance          float64 `json:"balance"`
+	AvailableBalance float64 `json:"available_balance"`
+	FrozenBalance    float64 `json:"frozen_balance"`
+	PositionMargin   float64 `json:"position_margin"`
+	OrderMargin      float64 `json:"order_margin"`
+
+	UnrealizedPnL  float64 `json:"unrealized_pnl"`
+	RealizedPnL    float64 `json:"realized_pnl"`
+	AccruedRebates float64 `json:"accrued_rebates"`
+
+	BalanceCap float64   `json:"balance_cap"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Snapshot copy account fields under read lock
+func (ma *MarginAccount) Snapshot() AccountSnapshot {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	return AccountSnapshot{
+		UserID:           ma.UserID,
+		Status:           ma.Status,
+		Balance:          ma.Balance,
+		AvailableBalance: ma.AvailableBalance,
+		FrozenBalance:    ma.FrozenBalance,
+		PositionMargin:   ma.PositionMargin,
+		OrderMargin:      ma.OrderMargin,
+		UnrealizedPnL:    ma.UnrealizedPnL,
+		RealizedPnL:      ma.RealizedPnL,
+		AccruedRebates:   ma.AccruedRebates,
+		BalanceCap:       ma.balanceCap,
+		UpdatedAt:        ma.UpdatedAt,
+	}
+}
+
+// RestoreMarginAccount rebuild an account from its snapshot
+func RestoreMarginAccount(snapshot AccountSnapshot) *MarginAccount {
+	return &MarginAccount{
+		UserID:           snapshot.UserID,
+		Status:           snapshot.Status,
+		Balance:          snapshot.Balance,
+		AvailableBalance: snapshot.AvailableBalance,
+		FrozenBalance:    snapshot.FrozenBalance,
+		PositionMargin:   snapshot.PositionMargin,
+		OrderMargin:      snapshot.OrderMargin,
+		UnrealizedPnL:    snapshot.UnrealizedPnL,
+		RealizedPnL:      snapshot.RealizedPnL,
+		AccruedRebates:   snapshot.AccruedRebates,
+		balanceCap:       snapshot.BalanceCap,
+		UpdatedAt:        snapshot.UpdatedAt,
+	}
+}
+
+// ========================================================
+
+// MarginState (保證金系統狀態) what a restart needs to rebuild the margin system: accounts, order margin
+// reservations, portfolio margin opt-ins and the insurance fund. audit log, trade history and socialized
+// losses are not part of it
+type MarginState struct {
+	Accounts       []AccountSnapshot     `json:"accounts"`     // sorted by user id
+	Reservations   []MarginReservation   `json:"reservations"` // sorted by user id, then creation
+	PortfolioUsers []string              `json:"portfolio_users"`
+	InsuranceFund  InsuranceFundSnapshot `json:"insurance_fund"`
+}
+
+// ExportState accounts and reservations at one instant: a reservation being placed or released is
+// either in both or in neither
+func (ms *MarginSystem) ExportState() MarginState {
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	state := MarginState{
+		Accounts:       make([]AccountSnapshot, 0, len(ms.accounts)),
+		PortfolioUsers: make([]string, 0, len(ms.portfolioUsers)),
+		InsuranceFund:  ms.insuranceFund.Snapshot(),
+	}
+	for _, account := range ms.accounts {
+		state.Accounts = append(state.Accounts, account.Snapshot())
+	}
+	sort.Slice(state.Accounts, func(i, j int) bool { return state.Accounts[i].UserID < state.Accounts[j].UserID })
+	for _, reservations := range ms.reservations {
+		for _, reservation := range reservations {
+			state.Reservations = append(state.Reservations, *reservation)
+		}
+	}
+	sort.Slice(state.Reservations, func(i, j int) bool {
+		a, b := state.Reservations[i], state.Reservations[j]
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	for userID := range ms.portfolioUsers {
+		state.PortfolioUsers = append(state.PortfolioUsers, userID)
+	}
+	sort.Strings(state.PortfolioUsers)
+	return state
+}
+
+// RestoreState rebuild the state into a margin system without accounts. reservations are taken as is,
+// their amount is already part of the restored accounts' order margin
+func (ms *MarginSystem) RestoreState(state MarginState) error {
+	ms.reservationMu.Lock()
+	defer ms.reservationMu.Unlock()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(ms.accounts) > 0 {
+		return fmt.Errorf("margin system already has %d accounts", len(ms.accounts))
+	}
+	accounts := make(map[string]*MarginAccount, len(state.Accounts))
+	for _, snapshot := range state.Accounts {
+		if _, exists := accounts[snapshot.UserID]; exists {
+			return fmt.Errorf("%w: %s", ErrAccountAlreadyExists, snapshot.UserID)
+		}
+		accounts[snapshot.UserID] = RestoreMarginAccount(snapshot)
+	}
+	reservations := make(map[string]map[string]*MarginReservation)
+	for _, reservation := range state.Reservations {
+		if _, exists := accounts[reservation.UserID]; !exists {
+			return fmt.Errorf("reservation of order %s: %w: %s", reservation.OrderID, ErrAccountNotFound, reservation.UserID)
+		}
+		if reservations[reservation.UserID] == nil {
+			reservations[reservation.UserID] = make(map[string]*MarginReservation)
+		}
+		reservations[reservation.UserID][reservation.OrderID] = &reservation
+	}
+	for _, userID := range state.PortfolioUsers {
+		if _, exists := accounts[userID]; !exists {
+			return fmt.Errorf("portfolio margin user: %w: %s", ErrAccountNotFound, userID)
+		}
+	}
+
+	ms.accounts = accounts
+	ms.reservations = reservations
+	for _, userID := range state.PortfolioUsers {
+		ms.portfolioUsers[userID] = true
+	}
+	ms.insuranceFund.restore(state.InsuranceFund)
+	return nil
+}
@@ -0,0 +1,160 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"time"
+)
+
+// SocializedHaircut share of a socialized loss charged to one profitable position
+type SocializedHaircut struct {
+	LossID           string    `json:"loss_id"`
+	UserID           string    `json:"user_id"`
+	PositionID       string    `json:"position_id"`
+	Symbol           string    `json:"symbol"`
+	UnrealizedProfit float64   `json:"unrealized_profit"` // when the loss was socialized
+	Amount           float64   `json:"amount"`
+	Applied          bool      `json:"applied"`
+	AppliedAt        time.Time `json:"applied_at,omitempty"`
+}
+
+// SocializedLoss (穿倉損失分攤) shortfall the insurance fund could not cover, spread pro-rata over the
+// unrealized profit of the opposite side instead of deleveraging it
+type SocializedLoss struct {
+	ID                   string                `json:"id"`
+	LiquidatedPositionID string                `json:"liquidated_position_id"`
+	Symbol               string                `json:"symbol"`
+	Side                 position.PositionSide `json:"side"` // side of the haircut positions
+	Shortfall            float64               `json:"shortfall"`
+	TotalProfit          float64               `json:"total_profit"`
+	HaircutRatio         float64               `json:"haircut_ratio"` // of each position's unrealized profit, at most 1
+	Covered              float64               `json:"covered"`
+	Uncovered            float64               `json:"uncovered"` // beyond the total profit of the side
+	Haircuts             []SocializedHaircut   `json:"haircuts"`
+	Timestamp            time.Time             `json:"timestamp"`
+}
+
+// SocializeLoss spread shortfall over the profitable normal positions of side. each haircut is
+// ratio × unrealized profit and they sum to the covered shortfall. haircuts are charged to the
+// realized PnL at the position's next reducing fill, or by ApplyPendingHaircuts.
+func (ms *MarginSystem) SocializeLoss(liquidatedPositionID, symbol string, side position.PositionSide, shortfall float64) (SocializedLoss, error) {
+	if shortfall <= 0 {
+		return SocializedLoss{}, fmt.Errorf("shortfall must be greater than zero")
+	}
+	queue, err := ms.positionMgr.GetADLQueue(symbol, side)
+	if err != nil {
+		return SocializedLoss{}, err
+	}
+
+	loss := SocializedLoss{
+		ID:                   common.GenerateShortUUID("sl"),
+		LiquidatedPositionID: liquidatedPositionID,
+		Symbol:               symbol,
+		Side:                 side,
+		Shortfall:            shortfall,
+		Haircuts:             make([]SocializedHaircut, 0, len(queue)),
+		Timestamp:            time.Now(),
+	}
+	for _, candidate := range queue {
+		loss.TotalProfit += candidate.UnrealizedPnL
+	}
+	if loss.TotalProfit > 0 {
+		loss.HaircutRatio = min(shortfall/loss.TotalProfit, 1)
+		loss.Covered = min(shortfall, loss.TotalProfit)
+	}
+	loss.Uncovered = shortfall - loss.Covered
+
+	// the last haircut takes the rounding remainder so the sum is exact
+	charged := 0.0
+	for i, candidate := range queue {
+		amount := candidate.UnrealizedPnL * loss.HaircutRatio
+		if i == len(queue)-1 {
+			amount = loss.Covered - charged
+		}
+		charged += amount
+		loss.Haircuts = append(loss.Haircuts, SocializedHaircut{
+			LossID:           loss.ID,
+			UserID:           candidate.UserID,
+			PositionID:       candidate.PositionID,
+			Symbol:           symbol,
+			UnrealizedProfit: candidate.UnrealizedPnL,
+			Amount:           amount,
+		})
+	}
+
+	ms.socializedMu.Lock()
+	defer ms.socializedMu.Unlock()
+	for _, haircut := range loss.Haircuts {
+		record := haircut
+		ms.haircuts = append(ms.haircuts, &record)
+		ms.pendingHaircuts[haircut.PositionID] = append(ms.pendingHaircuts[haircut.PositionID], &record)
+	}
+	ms.socializedLosses = append(ms.socializedLosses, loss)
+	return loss, nil
+}
+
+// ApplyPendingHaircuts (分攤結算) charge every haircut not applied yet, e.g. by a periodic settlement
+// of positions that stay open. return userID -> amount charged
+func (ms *MarginSystem) ApplyPendingHaircuts() map[string]float64 {
+	ms.socializedMu.Lock()
+	positionIDs := make([]string, 0, len(ms.pendingHaircuts))
+	for positionID := range ms.pendingHaircuts {
+		positionIDs = append(positionIDs, positionID)
+	}
+	ms.socializedMu.Unlock()
+
+	charged := make(map[string]float64)
+	for _, positionID := range positionIDs {
+		userID, amount := ms.applyHaircuts(positionID)
+		if amount > 0 {
+			charged[userID] += amount
+		}
+	}
+	return charged
+}
+
+// GetSocializedHaircuts haircuts of the user, applied or pending, oldest first
+func (ms *MarginSystem) GetSocializedHaircuts(userID string) []SocializedHaircut {
+	ms.socializedMu.Lock()
+	defer ms.socializedMu.Unlock()
+
+	var haircuts []SocializedHaircut
+	for _, haircut := range ms.haircuts {
+		if haircut.UserID == userID {
+			haircuts = append(haircuts, *haircut)
+		}
+	}
+	return haircuts
+}
+
+// SocializedLosses every socialized loss, oldest first
+func (ms *MarginSystem) SocializedLosses() []SocializedLoss {
+	ms.socializedMu.Lock()
+	defer ms.socializedMu.Unlock()
+	return append([]SocializedLoss(nil), ms.socializedLosses...)
+}
+
+// applyHaircuts charge the pending haircuts of a position to its account, return owner and amount
+func (ms *MarginSystem) applyHaircuts(positionID string) (string, float64) {
+	ms.socializedMu.Lock()
+	pending := ms.pendingHaircuts[positionID]
+	delete(ms.pendingHaircuts, positionID)
+
+	userID, amount := "", 0.0
+	now := time.Now()
+	for _, haircut := range pending {
+		userID = haircut.UserID
+		amount += haircut.Amount
+		haircut.Applied, haircut.AppliedAt = true, now
+	}
+	ms.socializedMu.Unlock()
+
+	if amount <= 0 {
+		return userID, 0
+	}
+	if account, err := ms.GetAccount(userID); err == nil {
+		account.ApplyHaircut(amount)
+	}
+	return userID, amount
+}
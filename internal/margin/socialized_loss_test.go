@@ -0,0 +1,101 @@
+package margin
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocializeLoss(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	open := func(userID string, side position.PositionSide, price, size float64) *position.Position {
+		_, err := ms.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, ms.Deposit(userID, 10000))
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", side, price, size, 5)
+		require.NoError(t, err)
+		return pos
+	}
+	// profit at 44000: 1200, 1800, 6000. losing: short at 40000, the long
+	open("short1", position.SHORT, 50000, 0.2)
+	open("short2", position.SHORT, 50000, 0.3)
+	open("short3", position.SHORT, 50000, 1)
+	open("short4", position.SHORT, 40000, 0.1)
+	open("long1", position.LONG, 50000, 0.1)
+	_, err := pm.UpdateMarkPrices("BTCUSDT", 44000)
+	require.NoError(t, err)
+
+	balances := func() map[string]float64 {
+		result := make(map[string]float64)
+		for _, userID := range []string{"short1", "short2", "short3", "short4", "long1"} {
+			account, err := ms.GetAccount(userID)
+			require.NoError(t, err)
+			result[userID] = account.Balance
+		}
+		return result
+	}
+	before := balances()
+
+	loss, err := ms.SocializeLoss("liquidated", "BTCUSDT", position.SHORT, 900)
+	require.NoError(t, err)
+	assert.InDelta(t, 9000.0, loss.TotalProfit, 1e-9)
+	assert.InDelta(t, 0.1, loss.HaircutRatio, 1e-12)
+	assert.Equal(t, 900.0, loss.Covered)
+	assert.Zero(t, loss.Uncovered)
+
+	// pro-rata, summing exactly to the shortfall, only the profitable positions
+	amounts := make(map[string]float64)
+	sum := 0.0
+	for _, haircut := range loss.Haircuts {
+		amounts[haircut.UserID] = haircut.Amount
+		sum += haircut.Amount
+		assert.InDelta(t, haircut.UnrealizedProfit*0.1, haircut.Amount, 1e-9)
+	}
+	assert.Equal(t, 900.0, sum)
+	assert.Len(t, amounts, 3)
+	assert.NotContains(t, amounts, "short4")
+	assert.NotContains(t, amounts, "long1")
+
+	// nothing charged before the next settlement
+	assert.Equal(t, before, balances())
+	require.Len(t, ms.GetSocializedHaircuts("short1"), 1)
+	assert.False(t, ms.GetSocializedHaircuts("short1")[0].Applied)
+
+	// next reducing fill realizes 0.1 × 6000 = 600, minus the 120 haircut
+	_, pnl, err := ms.SettleReduceFill("short1", "BTCUSDT", position.SHORT, 44000, 0.1, 0)
+	require.NoError(t, err)
+	assert.InDelta(t, 480.0, pnl, 1e-9)
+	assert.True(t, ms.GetSocializedHaircuts("short1")[0].Applied)
+	trades, err := ms.GetTradeHistory("short1", 1)
+	require.NoError(t, err)
+	assert.InDelta(t, 480.0, trades[0].RealizedPnL, 1e-9)
+
+	// the rest at the settlement run, once
+	charged := ms.ApplyPendingHaircuts()
+	assert.Equal(t, map[string]float64{"short2": amounts["short2"], "short3": amounts["short3"]}, charged)
+	assert.Empty(t, ms.ApplyPendingHaircuts())
+
+	after := balances()
+	assert.InDelta(t, before["short1"]+600-120, after["short1"], 1e-9)
+	for userID, expected := range map[string]float64{"short2": 180, "short3": 600} {
+		assert.InDelta(t, before[userID]-expected, after[userID], 1e-9, userID)
+	}
+	assert.Equal(t, before["short4"], after["short4"])
+	assert.Equal(t, before["long1"], after["long1"])
+
+	// shortfall beyond the whole profit: everything is taken, the rest stays uncovered
+	loss, err = ms.SocializeLoss("liquidated2", "BTCUSDT", position.SHORT, 20000)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, loss.HaircutRatio)
+	assert.InDelta(t, 8400.0, loss.Covered, 1e-9)
+	assert.InDelta(t, 11600.0, loss.Uncovered, 1e-9)
+	assert.Len(t, ms.SocializedLosses(), 2)
+
+	_, err = ms.SocializeLoss("liquidated3", "BTCUSDT", position.SHORT, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package margin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrStoreConflict an atomic update kept losing to concurrent writers
+var ErrStoreConflict = errors.New("account store update conflict")
+
+// AccountStore (帳戶儲存) where the margin system persists its accounts, serialized as AccountSnapshot.
+// Update is the atomic read-modify-write: fn gets the stored account (a zero one with UserID set when
+// there is none) and what it leaves is stored, unless a concurrent writer changed the account in between,
+// then fn runs again on the fresh copy
+type AccountStore interface {
+	Get(ctx context.Context, userID string) (AccountSnapshot, error) // ErrAccountNotFound
+	Put(ctx context.Context, account AccountSnapshot) error
+	List(ctx context.Context) ([]AccountSnapshot, error) // sorted by user id
+	Update(ctx context.Context, userID string, fn func(account *AccountSnapshot) error) (AccountSnapshot, error)
+}
+
+// NewAccountStore Redis store of config, the in-memory store when config is nil or has no address
+func NewAccountStore(config *RedisStoreConfig) AccountStore {
+	if config == nil || config.Addr == "" {
+		return NewMemoryAccountStore()
+	}
+	return NewRedisAccountStore(config)
+}
+
+// ========================================================
+
+// MemoryAccountStore process-local store, the default of a margin system
+type MemoryAccountStore struct {
+	accounts map[string]AccountSnapshot
+	mu       sync.Mutex
+}
+
+func NewMemoryAccountStore() *MemoryAccountStore {
+	return &MemoryAccountStore{accounts: make(map[string]AccountSnapshot)}
+}
+
+func (s *MemoryAccountStore) Get(ctx context.Context, userID string) (AccountSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[userID]
+	if !ok {
+		return AccountSnapshot{}, fmt.Errorf("%w: %s", ErrAccountNotFound, userID)
+	}
+	return account, nil
+}
+
+func (s *MemoryAccountStore) Put(ctx context.Context, account AccountSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts[account.UserID] = account
+	return nil
+}
+
+func (s *MemoryAccountStore) List(ctx context.Context) ([]AccountSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := make([]AccountSnapshot, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].UserID < accounts[j].UserID })
+	return accounts, nil
+}
+
+func (s *MemoryAccountStore) Update(ctx context.Context, userID string, fn func(account *AccountSnapshot) error) (AccountSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[userID]
+	if !ok {
+		account = AccountSnapshot{UserID: userID}
+	}
+	if err := fn(&account); err != nil {
+		return AccountSnapshot{}, err
+	}
+	account.UserID = userID
+	s.accounts[userID] = account
+	return account, nil
+}
+
+// ========================================================
+
+// SetAccountStore persist the accounts to store from now on, e.g. a RedisAccountStore shared by several
+// engine instances. call LoadAccounts next to pick up the stored ones
+func (ms *MarginSystem) SetAccountStore(store AccountStore) {
+	ms.storeMu.Lock()
+	defer ms.storeMu.Unlock()
+
+	ms.store = store
+	ms.flushed = make(map[string]AccountSnapshot)
+}
+
+// AccountStore the store the accounts are persisted to
+func (ms *MarginSystem) AccountStore() AccountStore {
+	ms.storeMu.Lock()
+	defer ms.storeMu.Unlock()
+	return ms.store
+}
+
+// LoadAccounts restore the stored accounts this margin system does not have yet and return how many.
+// call on startup, after a snapshot restore and before taking traffic: accounts both restored and stored
+// count as flushed, those only restored are written in full by their next flush
+func (ms *MarginSystem) LoadAccounts(ctx context.Context) (int, error) {
+	ms.storeMu.Lock()
+	defer ms.storeMu.Unlock()
+
+	stored, err := ms.store.List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list stored accounts: %w", err)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	loaded := 0
+	for _, snapshot := range stored {
+		if account, ok := ms.accounts[snapshot.UserID]; ok {
+			if _, flushed := ms.flushed[snapshot.UserID]; !flushed {
+				ms.flushed[snapshot.UserID] = account.Snapshot()
+			}
+			continue
+		}
+		ms.accounts[snapshot.UserID] = RestoreMarginAccount(snapshot)
+		ms.flushed[snapshot.UserID] = snapshot
+		loaded++
+	}
+	return loaded, nil
+}
+
+// FlushAccounts persist every account changed since its last flush
+func (ms *MarginSystem) FlushAccounts(ctx context.Context) error {
+	ms.mu.RLock()
+	accounts := make([]*MarginAccount, 0, len(ms.accounts))
+	for _, account := range ms.accounts {
+		accounts = append(accounts, account)
+	}
+	ms.mu.RUnlock()
+
+	var errs []error
+	for _, account := range accounts {
+		if err := ms.flushAccount(ctx, account); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushAccount add the change of account since its last flush to the stored copy. adding the change
+// rather than writing the account keeps the updates of other instances: two deposits add up
+func (ms *MarginSystem) flushAccount(ctx context.Context, account *MarginAccount) error {
+	ms.storeMu.Lock()
+	defer ms.storeMu.Unlock()
+
+	current := account.Snapshot()
+	before, ok := ms.flushed[current.UserID]
+	if ok && before == current {
+		return nil
+	}
+	if !ok {
+		before = AccountSnapshot{UserID: current.UserID}
+	}
+	if _, err := ms.store.Update(ctx, current.UserID, func(stored *AccountSnapshot) error {
+		mergeAccount(stored, before, current)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("flush account %s: %w", current.UserID, err)
+	}
+	ms.flushed[current.UserID] = current
+	return nil
+}
+
+// mergeAccount apply the change from before to after onto stored
+func mergeAccount(stored *AccountSnapshot, before, after AccountSnapshot) {
+	stored.Balance += after.Balance - before.Balance
+	stored.AvailableBalance += after.AvailableBalance - before.AvailableBalance
+	stored.FrozenBalance += after.FrozenBalance - before.FrozenBalance
+	stored.PositionMargin += after.PositionMargin - before.PositionMargin
+	stored.OrderMargin += after.OrderMargin - before.OrderMargin
+	stored.UnrealizedPnL += after.UnrealizedPnL - before.UnrealizedPnL
+	stored.RealizedPnL += after.RealizedPnL - before.RealizedPnL
+	stored.AccruedRebates += after.AccruedRebates - before.AccruedRebates
+	stored.Status = after.Status
+	stored.BalanceCap = after.BalanceCap
+	stored.UpdatedAt = after.UpdatedAt
+}
@@ -0,0 +1,166 @@
+package margin
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisStore(t *testing.T, addr string) *RedisAccountStore {
+	store := NewRedisAccountStore(&RedisStoreConfig{Addr: addr})
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestNewAccountStoreFallsBackToMemory(t *testing.T) {
+	assert.IsType(t, &MemoryAccountStore{}, NewAccountStore(nil))
+	assert.IsType(t, &MemoryAccountStore{}, NewAccountStore(&RedisStoreConfig{}))
+	assert.IsType(t, &RedisAccountStore{}, NewAccountStore(&RedisStoreConfig{Addr: "localhost:6379"}))
+
+	ms := NewMarginSystem(nil, nil)
+	assert.IsType(t, &MemoryAccountStore{}, ms.AccountStore(), "in-memory by default")
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 100))
+	stored, err := ms.AccountStore().Get(context.Background(), "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, stored.Balance)
+}
+
+func TestAccountStores(t *testing.T) {
+	server := miniredis.RunT(t)
+	stores := map[string]AccountStore{
+		"memory": NewMemoryAccountStore(),
+		"redis":  newRedisStore(t, server.Addr()),
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Get(ctx, "bob")
+			assert.ErrorIs(t, err, ErrAccountNotFound)
+			accounts, err := store.List(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, accounts)
+
+			account := NewMarginAccount("bob")
+			require.NoError(t, account.Deposit(250))
+			require.NoError(t, store.Put(ctx, account.Snapshot()))
+			got, err := store.Get(ctx, "bob")
+			require.NoError(t, err)
+			assert.Equal(t, 250.0, got.Balance)
+
+			updated, err := store.Update(ctx, "alice", func(account *AccountSnapshot) error {
+				assert.Zero(t, account.Balance, "missing account starts empty")
+				account.Balance = 10
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "alice", updated.UserID)
+
+			_, err = store.Update(ctx, "alice", func(account *AccountSnapshot) error { return ErrInsufficientMargin })
+			assert.ErrorIs(t, err, ErrInsufficientMargin)
+
+			accounts, err = store.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, accounts, 2)
+			assert.Equal(t, "alice", accounts[0].UserID)
+			assert.Equal(t, 10.0, accounts[0].Balance, "failed update left the account alone")
+			assert.Equal(t, "bob", accounts[1].UserID)
+		})
+	}
+}
+
+func TestRedisAccountStoreConcurrentUpdates(t *testing.T) {
+	server := miniredis.RunT(t)
+	// one client per engine instance
+	clients := []*RedisAccountStore{newRedisStore(t, server.Addr()), newRedisStore(t, server.Addr())}
+	for _, client := range clients {
+		client.config.MaxRetries = 1000
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				_, err := clients[i%2].Update(ctx, "alice", func(account *AccountSnapshot) error {
+					account.Balance++
+					return nil
+				})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	account, err := clients[0].Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 200.0, account.Balance, "no update lost")
+}
+
+func TestMarginSystemsShareRedisAccounts(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// two engine instances funding the same user at the same time
+	instances := []*MarginSystem{NewMarginSystem(nil, nil), NewMarginSystem(nil, nil)}
+	for _, ms := range instances {
+		ms.SetAccountStore(newRedisStore(t, server.Addr()))
+		_, err := ms.CreateAccount("alice")
+		require.NoError(t, err)
+	}
+	var wg sync.WaitGroup
+	for _, ms := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				assert.NoError(t, ms.Deposit("alice", 10))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, instances[0].Withdraw("alice", 50))
+
+	stored, err := instances[0].AccountStore().Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 350.0, stored.Balance, "deposits of both instances kept")
+	assert.Equal(t, 350.0, stored.AvailableBalance)
+
+	// a third instance starts from the store
+	restarted := NewMarginSystem(nil, nil)
+	restarted.SetAccountStore(newRedisStore(t, server.Addr()))
+	loaded, err := restarted.LoadAccounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	account, err := restarted.GetAccount("alice")
+	require.NoError(t, err)
+	assert.Equal(t, 350.0, account.getBalance())
+
+	require.NoError(t, restarted.Deposit("alice", 1))
+	stored, err = restarted.AccountStore().Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 351.0, stored.Balance, "loaded accounts flush their changes only")
+	require.NoError(t, restarted.FlushAccounts(ctx), "nothing left to flush")
+}
+
+func TestLoadAccountsSeedsRestoredAccounts(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMarginSystem(nil, nil)
+	ms.SetAccountStore(NewMemoryAccountStore())
+	_, err := ms.RestoreAccount(AccountSnapshot{UserID: "carol", Balance: 40, AvailableBalance: 40})
+	require.NoError(t, err)
+
+	_, err = ms.LoadAccounts(ctx)
+	require.NoError(t, err)
+	require.NoError(t, ms.FlushAccounts(ctx))
+	stored, err := ms.AccountStore().Get(ctx, "carol")
+	require.NoError(t, err)
+	assert.Equal(t, 40.0, stored.Balance, "restored account missing from the store written in full")
+}
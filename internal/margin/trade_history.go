@@ -0,0 +1,96 @@
+package margin
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"time"
+)
+
+// MaxTradeHistory trades kept per account, the oldest are dropped beyond it
+const MaxTradeHistory = 10000
+
+// TradeRecord (成交紀錄) one fill that reduced or closed a position
+type TradeRecord struct {
+	TradeID     string                `json:"trade_id"`
+	OrderID     string                `json:"order_id,omitempty"` // empty for liquidations and ADL
+	PositionID  string                `json:"position_id"`
+	Symbol      string                `json:"symbol"`
+	Side        position.PositionSide `json:"side"` // side of the reduced position
+	Price       float64               `json:"price"`
+	Size        float64               `json:"size"`
+	Fee         float64               `json:"fee"`
+	Timestamp   time.Time             `json:"timestamp"`
+	RealizedPnL float64               `json:"realized_pnl"`
+}
+
+// RecordTrade append a trade, TradeID and Timestamp are filled in when empty.
+// the history is a ring of MaxTradeHistory entries.
+func (ma *MarginAccount) RecordTrade(record TradeRecord) {
+	if record.TradeID == "" {
+		record.TradeID = common.GenerateShortUUID("trd")
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	ma.appendTrade(record)
+}
+
+// appendTrade no lock
+func (ma *MarginAccount) appendTrade(record TradeRecord) {
+	if len(ma.tradeHistory) < MaxTradeHistory {
+		ma.tradeHistory = append(ma.tradeHistory, record)
+		return
+	}
+	ma.tradeHistory[ma.tradeHead] = record
+	ma.tradeHead = (ma.tradeHead + 1) % MaxTradeHistory
+}
+
+// TradeHistory copy of the kept trades, oldest first
+func (ma *MarginAccount) TradeHistory() []TradeRecord {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	history := make([]TradeRecord, 0, len(ma.tradeHistory))
+	history = append(history, ma.tradeHistory[ma.tradeHead:]...)
+	return append(history, ma.tradeHistory[:ma.tradeHead]...)
+}
+
+// GetTradeHistoryFiltered newest first. zero from/to are unbounded, from inclusive and to exclusive,
+// nil symbol matches every symbol
+func (ma *MarginAccount) GetTradeHistoryFiltered(from, to time.Time, symbol *string) []TradeRecord {
+	history := ma.TradeHistory()
+
+	var trades []TradeRecord
+	for i := len(history) - 1; i >= 0; i-- {
+		trade := history[i]
+		if symbol != nil && trade.Symbol != *symbol {
+			continue
+		}
+		if (!from.IsZero() && trade.Timestamp.Before(from)) || (!to.IsZero() && !trade.Timestamp.Before(to)) {
+			continue
+		}
+		trades = append(trades, trade)
+	}
+	return trades
+}
+
+// GetTradeHistory up to limit trades of the user, newest first. limit <= 0 returns every kept trade
+func (ms *MarginSystem) GetTradeHistory(userID string, limit int) ([]TradeRecord, error) {
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := account.TradeHistory()
+	if limit <= 0 || limit > len(history) {
+		limit = len(history)
+	}
+	trades := make([]TradeRecord, limit)
+	for i := range trades {
+		trades[i] = history[len(history)-1-i]
+	}
+	return trades, nil
+}
@@ -0,0 +1,127 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTradeHistory(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := NewMarginSystem(pm, nil)
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 1000000))
+
+	btc, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 25, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", position.SHORT, 3000, 25, 10)
+	require.NoError(t, err)
+
+	start := time.Now()
+	var middle time.Time
+	for i := 0; i < 50; i++ {
+		if i == 25 {
+			middle = time.Now()
+		}
+		symbol, side, price := "BTCUSDT", position.LONG, 51000.0
+		if i%2 == 1 {
+			symbol, side, price = "ETHUSDT", position.SHORT, 2900.0
+		}
+		_, _, err = ms.SettleReduceOrderFill("user1", fmt.Sprintf("o%d", i), symbol, side, price, 1, 0.5)
+		require.NoError(t, err)
+	}
+
+	trades, err := ms.GetTradeHistory("user1", 100)
+	require.NoError(t, err)
+	require.Len(t, trades, 50)
+	assert.Equal(t, "o49", trades[0].OrderID)
+	assert.Equal(t, "ETHUSDT", trades[0].Symbol)
+	assert.Equal(t, "o0", trades[49].OrderID)
+	assert.Equal(t, btc.ID, trades[49].PositionID)
+	assert.Equal(t, position.LONG, trades[49].Side)
+	assert.InDelta(t, 1000.0, trades[49].RealizedPnL, 1e-9)
+	assert.Equal(t, 0.5, trades[49].Fee)
+	assert.NotEmpty(t, trades[0].TradeID)
+	assert.NotEqual(t, trades[0].TradeID, trades[1].TradeID)
+
+	latest, err := ms.GetTradeHistory("user1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, trades[:10], latest)
+
+	_, err = ms.GetTradeHistory("nobody", 10)
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+
+	// filters
+	account, err := ms.GetAccount("user1")
+	require.NoError(t, err)
+	symbol := "BTCUSDT"
+	filtered := account.GetTradeHistoryFiltered(time.Time{}, time.Time{}, &symbol)
+	require.Len(t, filtered, 25)
+	assert.Equal(t, "o48", filtered[0].OrderID)
+	for _, trade := range filtered {
+		assert.Equal(t, "BTCUSDT", trade.Symbol)
+	}
+	assert.Len(t, account.GetTradeHistoryFiltered(start, time.Time{}, nil), 50)
+	assert.Len(t, account.GetTradeHistoryFiltered(middle, time.Time{}, nil), 25)
+	assert.Len(t, account.GetTradeHistoryFiltered(start, middle, nil), 25)
+	assert.Empty(t, account.GetTradeHistoryFiltered(time.Time{}, start, nil))
+}
+
+func TestTradeHistoryCapped(t *testing.T) {
+	account := NewMarginAccount("user1")
+	for i := 0; i < MaxTradeHistory+5; i++ {
+		account.RecordTrade(TradeRecord{TradeID: fmt.Sprintf("t%d", i), Symbol: "BTCUSDT"})
+	}
+
+	history := account.TradeHistory()
+	require.Len(t, history, MaxTradeHistory)
+	assert.Equal(t, "t5", history[0].TradeID)
+	assert.Equal(t, fmt.Sprintf("t%d", MaxTradeHistory+4), history[len(history)-1].TradeID)
+}
+
+func TestMarginAuditLog(t *testing.T) {
+	ms := NewMarginSystem(position.NewPositionManager(symbols), nil)
+	_, err := ms.CreateAccount("audited")
+	require.NoError(t, err)
+
+	for i := 0; i < MaxAuditLogEntries+5; i++ {
+		require.NoError(t, ms.Deposit("audited", 1))
+	}
+	assert.Error(t, ms.Withdraw("audited", 1e9))
+	require.NoError(t, ms.Withdraw("audited", 5))
+
+	log := ms.AuditLog()
+	assert.Len(t, log, MaxAuditLogEntries)
+	assert.Equal(t, 7.0, log[0].BalanceBefore, "oldest 7 of 1007 entries dropped")
+
+	recent := ms.GetRecentAuditLog(3)
+	require.Len(t, recent, 3)
+	assert.Equal(t, MarginAuditEntry{UserID: "audited", Operation: AuditWithdraw, Amount: -5, BalanceBefore: 1005, BalanceAfter: 1000},
+		MarginAuditEntry{UserID: recent[0].UserID, Operation: recent[0].Operation, Amount: recent[0].Amount, BalanceBefore: recent[0].BalanceBefore, BalanceAfter: recent[0].BalanceAfter})
+	assert.NotEmpty(t, recent[1].Error)
+	assert.Equal(t, recent[1].BalanceBefore, recent[1].BalanceAfter)
+	assert.Equal(t, AuditDeposit, recent[2].Operation)
+}
+
+func TestOnAuditEntry(t *testing.T) {
+	ms := NewMarginSystem(position.NewPositionManager(symbols), nil)
+	var seen []MarginAuditEntry
+	ms.OnAuditEntry(func(entry MarginAuditEntry) { seen = append(seen, entry) })
+
+	_, err := ms.CreateAccount("audited")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("audited", 10))
+	assert.Error(t, ms.Withdraw("audited", 1e9))
+
+	require.Len(t, seen, 2)
+	assert.Equal(t, AuditDeposit, seen[0].Operation)
+	assert.Equal(t, 10.0, seen[0].BalanceAfter)
+	assert.NotEmpty(t, seen[1].Error, "failed operations reported too")
+}
@@ -0,0 +1,79 @@
+package margin
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"time"
+)
+
+// MarkPriceSimulation (假設行情試算) the user's account as it would be at hypothetical mark prices
+type MarkPriceSimulation struct {
+	UserID string             `json:"user_id"`
+	Prices map[string]float64 `json:"prices"` // price used per held symbol, the current mark where none was given
+
+	Positions    []position.PositionSimulation `json:"positions"`
+	Liquidatable []string                      `json:"liquidatable"` // position IDs
+
+	Balance       float64 `json:"balance"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	Equity        float64 `json:"equity"` // balance + unrealized PnL of every open position
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SimulateMarkPrices what-if of mark prices for the user's open positions, e.g. "BTC drops 10%".
+// symbols missing from prices keep their current mark price. computed on snapshots: no position,
+// account or index changes and no events
+func (ms *MarginSystem) SimulateMarkPrices(userID string, prices map[string]float64) (*MarkPriceSimulation, error) {
+	for symbol, price := range prices {
+		if price <= 0 {
+			return nil, fmt.Errorf("mark price of %s must be greater than zero", symbol)
+		}
+	}
+	account, err := ms.GetAccount(userID)
+	if err != nil {
+		return nil, err
+	}
+	positions, _ := ms.positionMgr.GetUserPositions(userID) // error only for a user without positions yet
+
+	simulation := &MarkPriceSimulation{
+		UserID:    userID,
+		Prices:    make(map[string]float64),
+		Balance:   account.getBalance(),
+		Timestamp: time.Now(),
+	}
+
+	// pass 1: unrealized PnL at the new prices, the cross positions share the balance and their own PnL
+	snapshots := make([]position.PositionSnapshot, 0, len(positions))
+	crossEquity := simulation.Balance
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+			continue
+		}
+		price, ok := prices[snapshot.Symbol]
+		if !ok {
+			price = snapshot.MarkPrice
+		}
+		simulation.Prices[snapshot.Symbol] = price
+		pnl := position.PositionMath{}.CalculateUnrealizedPnL(snapshot.Side, snapshot.EntryPrice, price, snapshot.Size)
+		simulation.UnrealizedPnL += pnl
+		if snapshot.MarginMode == common.CROSS {
+			crossEquity += pnl
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	simulation.Equity = simulation.Balance + simulation.UnrealizedPnL
+
+	// pass 2: margin ratio and liquidation per position
+	simulation.Positions = make([]position.PositionSimulation, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		sim := snapshot.SimulateMarkPrice(simulation.Prices[snapshot.Symbol], crossEquity)
+		simulation.Positions = append(simulation.Positions, sim)
+		if sim.IsLiquidatable {
+			simulation.Liquidatable = append(simulation.Liquidatable, sim.PositionID)
+		}
+	}
+	return simulation, nil
+}
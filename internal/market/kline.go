@@ -0,0 +1,248 @@
+package market
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"math"
+	"sync"
+	"time"
+)
+
+// KlineInterval candle interval
+type KlineInterval string
+
+const (
+	Interval1m KlineInterval = "1m"
+	Interval5m KlineInterval = "5m"
+	Interval1h KlineInterval = "1h"
+	Interval1d KlineInterval = "1d"
+)
+
+func (i KlineInterval) Duration() time.Duration {
+	switch i {
+	case Interval1m:
+		return time.Minute
+	case Interval5m:
+		return 5 * time.Minute
+	case Interval1h:
+		return time.Hour
+	case Interval1d:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// Kline (K線) OHLCV candle
+type Kline struct {
+	Symbol      string        `json:"symbol"`
+	Interval    KlineInterval `json:"interval"`
+	OpenTime    time.Time     `json:"open_time"`
+	CloseTime   time.Time     `json:"close_time"` // exclusive
+	Open        float64       `json:"open"`
+	High        float64       `json:"high"`
+	Low         float64       `json:"low"`
+	Close       float64       `json:"close"`
+	Volume      float64       `json:"volume"`
+	QuoteVolume float64       `json:"quote_volume"`
+	TradeCount  int64         `json:"trade_count"`
+	Closed      bool          `json:"closed"` // false for the current unclosed candle
+}
+
+// KlinePersistFunc called once for every closed candle (persistence hook)
+type KlinePersistFunc func(kline Kline)
+
+// KlineConfig
+type KlineConfig struct {
+	Intervals []KlineInterval
+	Capacity  int              // candles kept per symbol per interval
+	FillGaps  bool             // emit flat zero volume candles for intervals without trades
+	OnClose   KlinePersistFunc // optional
+}
+
+var DefaultKlineConfig = &KlineConfig{
+	Intervals: []KlineInterval{Interval1m, Interval5m, Interval1h, Interval1d},
+	Capacity:  1440,
+	FillGaps:  true,
+}
+
+// ========================================================
+
+// klineSeries closed candles in a bounded ring plus the current candle
+type klineSeries struct {
+	ring    []Kline
+	head    int // index of the oldest candle
+	count   int
+	current *Kline
+}
+
+func (ks *klineSeries) push(kline Kline) {
+	capacity := len(ks.ring)
+	if capacity == 0 {
+		return
+	}
+	if ks.count < capacity {
+		ks.ring[(ks.head+ks.count)%capacity] = kline
+		ks.count++
+		return
+	}
+	// full, overwrite the oldest
+	ks.ring[ks.head] = kline
+	ks.head = (ks.head + 1) % capacity
+}
+
+func (ks *klineSeries) at(i int) Kline {
+	return ks.ring[(ks.head+i)%len(ks.ring)]
+}
+
+// ========================================================
+
+// KlineAggregator (K線聚合) builds candles of every configured interval from trades
+type KlineAggregator struct {
+	config *KlineConfig
+	series map[string]map[KlineInterval]*klineSeries // symbol -> interval -> series
+	clock  common.Clock
+	mu     sync.RWMutex
+}
+
+// NewKlineAggregator clock nil means system clock, config nil means DefaultKlineConfig
+func NewKlineAggregator(clock common.Clock, config *KlineConfig) *KlineAggregator {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	if config == nil {
+		config = DefaultKlineConfig
+	}
+	return &KlineAggregator{
+		config: config,
+		series: make(map[string]map[KlineInterval]*klineSeries),
+		clock:  clock,
+	}
+}
+
+// RecordTrade apply one trade to the current candle of every interval
+func (ka *KlineAggregator) RecordTrade(symbol string, price, size float64) error {
+	if price <= 0 || size <= 0 {
+		return fmt.Errorf("price and size must be greater than zero")
+	}
+
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+
+	intervals, exists := ka.series[symbol]
+	if !exists {
+		intervals = make(map[KlineInterval]*klineSeries, len(ka.config.Intervals))
+		for _, interval := range ka.config.Intervals {
+			if interval.Duration() <= 0 {
+				continue // unknown interval
+			}
+			intervals[interval] = &klineSeries{ring: make([]Kline, ka.config.Capacity)}
+		}
+		ka.series[symbol] = intervals
+	}
+
+	now := ka.clock.Now()
+	for interval, ks := range intervals {
+		ka.apply(symbol, interval, ks, now, price, size)
+	}
+
+	return nil
+}
+
+// GetKlines candles with OpenTime in [from, to), ascending. zero to means no upper bound,
+// limit > 0 keeps the latest limit candles. the last one may be the unclosed current candle.
+func (ka *KlineAggregator) GetKlines(symbol string, interval KlineInterval, from, to time.Time, limit int) ([]Kline, error) {
+	ka.mu.RLock()
+	defer ka.mu.RUnlock()
+
+	intervals, exists := ka.series[symbol]
+	if !exists {
+		return nil, fmt.Errorf("symbol %s has no klines", symbol)
+	}
+	ks, exists := intervals[interval]
+	if !exists {
+		return nil, fmt.Errorf("interval %s not configured", interval)
+	}
+
+	inRange := func(k *Kline) bool {
+		return !k.OpenTime.Before(from) && (to.IsZero() || k.OpenTime.Before(to))
+	}
+
+	klines := make([]Kline, 0, ks.count+1)
+	for i := 0; i < ks.count; i++ {
+		if k := ks.at(i); inRange(&k) {
+			klines = append(klines, k)
+		}
+	}
+	if ks.current != nil && inRange(ks.current) {
+		current := *ks.current
+		// no trade rolled it yet, but its time is over
+		current.Closed = !ka.clock.Now().Before(current.CloseTime)
+		klines = append(klines, current)
+	}
+
+	if limit > 0 && len(klines) > limit {
+		klines = klines[len(klines)-limit:]
+	}
+	return klines, nil
+}
+
+func (ka *KlineAggregator) apply(symbol string, interval KlineInterval, ks *klineSeries, now time.Time, price, size float64) {
+	d := interval.Duration()
+	openTime := now.Truncate(d)
+
+	if ks.current != nil && openTime.After(ks.current.OpenTime) {
+		// roll over: close current candle, fill gaps, then start a new one
+		prev := *ks.current
+		ka.closeKline(ks, prev)
+
+		if ka.config.FillGaps {
+			gapStart := prev.CloseTime
+			// only the latest Capacity gap candles can be kept anyway
+			if gaps := int(openTime.Sub(gapStart) / d); gaps > ka.config.Capacity {
+				gapStart = openTime.Add(-time.Duration(ka.config.Capacity) * d)
+			}
+			for t := gapStart; t.Before(openTime); t = t.Add(d) {
+				ka.closeKline(ks, Kline{
+					Symbol:    symbol,
+					Interval:  interval,
+					OpenTime:  t,
+					CloseTime: t.Add(d),
+					Open:      prev.Close,
+					High:      prev.Close,
+					Low:       prev.Close,
+					Close:     prev.Close,
+				})
+			}
+		}
+		ks.current = nil
+	}
+
+	if ks.current == nil {
+		ks.current = &Kline{
+			Symbol:    symbol,
+			Interval:  interval,
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(d),
+			Open:      price,
+			High:      price,
+			Low:       price,
+		}
+	}
+
+	k := ks.current
+	k.High = math.Max(k.High, price)
+	k.Low = math.Min(k.Low, price)
+	k.Close = price
+	k.Volume += size
+	k.QuoteVolume += price * size
+	k.TradeCount++
+}
+
+func (ka *KlineAggregator) closeKline(ks *klineSeries, kline Kline) {
+	kline.Closed = true
+	ks.push(kline)
+	if ka.config.OnClose != nil {
+		ka.config.OnClose(kline)
+	}
+}
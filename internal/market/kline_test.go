@@ -0,0 +1,137 @@
+package market
+
+import (
+	"frizo/futures_engine/internal/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKlineAggregation(t *testing.T) {
+	clock := common.NewFakeClock(start)
+	var persisted []Kline
+	ka := NewKlineAggregator(clock, &KlineConfig{
+		Intervals: []KlineInterval{Interval1m, Interval5m},
+		Capacity:  100,
+		OnClose:   func(k Kline) { persisted = append(persisted, k) },
+	})
+
+	// 00:00
+	require.NoError(t, ka.RecordTrade("BTCUSDT", 100, 1))
+	clock.Advance(10 * time.Second)
+	require.NoError(t, ka.RecordTrade("BTCUSDT", 105, 2))
+	clock.Advance(10 * time.Second)
+	require.NoError(t, ka.RecordTrade("BTCUSDT", 98, 1))
+	// 00:01
+	clock.Set(start.Add(time.Minute + 5*time.Second))
+	require.NoError(t, ka.RecordTrade("BTCUSDT", 101, 3))
+
+	klines, err := ka.GetKlines("BTCUSDT", Interval1m, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, klines, 2)
+
+	assert.Equal(t, Kline{
+		Symbol: "BTCUSDT", Interval: Interval1m,
+		OpenTime: start, CloseTime: start.Add(time.Minute),
+		Open: 100, High: 105, Low: 98, Close: 98,
+		Volume: 4, QuoteVolume: 100 + 210 + 98, TradeCount: 3, Closed: true,
+	}, klines[0])
+	assert.Equal(t, 101.0, klines[1].Open)
+	assert.Equal(t, 3.0, klines[1].Volume)
+	assert.False(t, klines[1].Closed, "current candle is unclosed")
+
+	// 5m 全部在同一根
+	klines, err = ka.GetKlines("BTCUSDT", Interval5m, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, klines, 1)
+	assert.Equal(t, 105.0, klines[0].High)
+	assert.Equal(t, 7.0, klines[0].Volume)
+	assert.Equal(t, int64(4), klines[0].TradeCount)
+
+	require.Len(t, persisted, 1)
+	assert.Equal(t, start, persisted[0].OpenTime)
+
+	// 時間過了但還沒有新成交, 當前 K 線視為已收
+	clock.Set(start.Add(2 * time.Minute))
+	klines, err = ka.GetKlines("BTCUSDT", Interval1m, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	assert.True(t, klines[1].Closed)
+
+	_, err = ka.GetKlines("BTCUSDT", Interval1h, time.Time{}, time.Time{}, 0)
+	assert.Error(t, err)
+	_, err = ka.GetKlines("ETHUSDT", Interval1m, time.Time{}, time.Time{}, 0)
+	assert.Error(t, err)
+}
+
+func TestKlineGaps(t *testing.T) {
+	run := func(fillGaps bool) []Kline {
+		clock := common.NewFakeClock(start)
+		ka := NewKlineAggregator(clock, &KlineConfig{
+			Intervals: []KlineInterval{Interval1m},
+			Capacity:  100,
+			FillGaps:  fillGaps,
+		})
+		require.NoError(t, ka.RecordTrade("BTCUSDT", 100, 1))
+		clock.Set(start.Add(3*time.Minute + time.Second)) // 00:01, 00:02 沒有成交
+		require.NoError(t, ka.RecordTrade("BTCUSDT", 110, 1))
+
+		klines, err := ka.GetKlines("BTCUSDT", Interval1m, time.Time{}, time.Time{}, 0)
+		require.NoError(t, err)
+		return klines
+	}
+
+	klines := run(true)
+	require.Len(t, klines, 4)
+	for i, minute := range []int{1, 2} {
+		gap := klines[i+1]
+		assert.Equal(t, start.Add(time.Duration(minute)*time.Minute), gap.OpenTime)
+		assert.Equal(t, 100.0, gap.Open)
+		assert.Equal(t, 100.0, gap.High)
+		assert.Equal(t, 100.0, gap.Low)
+		assert.Equal(t, 100.0, gap.Close)
+		assert.Equal(t, 0.0, gap.Volume)
+		assert.Equal(t, int64(0), gap.TradeCount)
+		assert.True(t, gap.Closed)
+	}
+	assert.Equal(t, start.Add(3*time.Minute), klines[3].OpenTime)
+	assert.Equal(t, 110.0, klines[3].Close)
+
+	klines = run(false)
+	require.Len(t, klines, 2)
+	assert.Equal(t, start, klines[0].OpenTime)
+	assert.Equal(t, start.Add(3*time.Minute), klines[1].OpenTime)
+}
+
+func TestKlineRangeAndCapacity(t *testing.T) {
+	clock := common.NewFakeClock(start)
+	ka := NewKlineAggregator(clock, &KlineConfig{
+		Intervals: []KlineInterval{Interval1m},
+		Capacity:  5,
+	})
+
+	for i := 0; i < 10; i++ {
+		clock.Set(start.Add(time.Duration(i) * time.Minute))
+		require.NoError(t, ka.RecordTrade("BTCUSDT", float64(100+i), 1))
+	}
+
+	// ring 保留 5 根已收 + 1 根當前
+	klines, err := ka.GetKlines("BTCUSDT", Interval1m, time.Time{}, time.Time{}, 0)
+	require.NoError(t, err)
+	require.Len(t, klines, 6)
+	assert.Equal(t, start.Add(4*time.Minute), klines[0].OpenTime)
+	assert.Equal(t, start.Add(9*time.Minute), klines[5].OpenTime)
+
+	klines, err = ka.GetKlines("BTCUSDT", Interval1m, start.Add(5*time.Minute), start.Add(8*time.Minute), 0)
+	require.NoError(t, err)
+	require.Len(t, klines, 3)
+	assert.Equal(t, 105.0, klines[0].Open)
+	assert.Equal(t, 107.0, klines[2].Open)
+
+	klines, err = ka.GetKlines("BTCUSDT", Interval1m, time.Time{}, time.Time{}, 2)
+	require.NoError(t, err)
+	require.Len(t, klines, 2)
+	assert.Equal(t, 108.0, klines[0].Open)
+	assert.Equal(t, 109.0, klines[1].Open)
+}
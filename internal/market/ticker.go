@@ -0,0 +1,179 @@
+package market
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tickerWindow     = 24 * time.Hour
+	tickerBucketSize = time.Minute
+	tickerBuckets    = int(tickerWindow / tickerBucketSize)
+)
+
+// Ticker 24h rolling statistics of a symbol
+type Ticker struct {
+	Symbol             string    `json:"symbol"`
+	LastPrice          float64   `json:"last_price"`
+	OpenPrice          float64   `json:"open_price"` // first trade price in the window
+	HighPrice          float64   `json:"high_price"`
+	LowPrice           float64   `json:"low_price"`
+	PriceChange        float64   `json:"price_change"`
+	PriceChangePercent float64   `json:"price_change_percent"`
+	BaseVolume         float64   `json:"base_volume"`  // 成交量 (張/幣)
+	QuoteVolume        float64   `json:"quote_volume"` // 成交額 (USDT)
+	TradeCount         int64     `json:"trade_count"`
+	UpdateTime         time.Time `json:"update_time"`
+}
+
+// tickerBucket trades aggregated in one minute
+type tickerBucket struct {
+	start       time.Time // zero means empty
+	open        float64
+	high        float64
+	low         float64
+	baseVolume  float64
+	quoteVolume float64
+	count       int64
+}
+
+// symbolTicker ring buffer of minute buckets, old buckets are overwritten as the clock advances
+type symbolTicker struct {
+	buckets    [tickerBuckets]tickerBucket
+	lastPrice  float64
+	updateTime time.Time
+}
+
+func (st *symbolTicker) record(now time.Time, price, size float64) {
+	start := now.Truncate(tickerBucketSize)
+	b := &st.buckets[bucketIndex(start)]
+
+	if !b.start.Equal(start) {
+		// bucket expired (or never used), reuse it
+		*b = tickerBucket{start: start, open: price, high: price, low: price}
+	}
+
+	b.high = math.Max(b.high, price)
+	b.low = math.Min(b.low, price)
+	b.baseVolume += size
+	b.quoteVolume += price * size
+	b.count++
+
+	st.lastPrice = price
+	st.updateTime = now
+}
+
+func (st *symbolTicker) ticker(symbol string, now time.Time) Ticker {
+	ticker := Ticker{
+		Symbol:     symbol,
+		LastPrice:  st.lastPrice,
+		UpdateTime: st.updateTime,
+	}
+
+	windowStart := now.Add(-tickerWindow)
+	var oldest time.Time
+
+	for i := range st.buckets {
+		b := &st.buckets[i]
+		if b.start.IsZero() || !b.start.After(windowStart) || b.start.After(now) {
+			continue
+		}
+
+		if ticker.TradeCount == 0 {
+			ticker.HighPrice, ticker.LowPrice = b.high, b.low
+		} else {
+			ticker.HighPrice = math.Max(ticker.HighPrice, b.high)
+			ticker.LowPrice = math.Min(ticker.LowPrice, b.low)
+		}
+		if oldest.IsZero() || b.start.Before(oldest) {
+			oldest = b.start
+			ticker.OpenPrice = b.open
+		}
+
+		ticker.BaseVolume += b.baseVolume
+		ticker.QuoteVolume += b.quoteVolume
+		ticker.TradeCount += b.count
+	}
+
+	if ticker.TradeCount > 0 && ticker.OpenPrice > 0 {
+		ticker.PriceChange = ticker.LastPrice - ticker.OpenPrice
+		ticker.PriceChangePercent = ticker.PriceChange / ticker.OpenPrice * 100
+	}
+
+	return ticker
+}
+
+func bucketIndex(start time.Time) int {
+	return int(start.Unix()/int64(tickerBucketSize/time.Second)) % tickerBuckets
+}
+
+// ========================================================
+
+// TickerManager (行情統計) 24h rolling ticker of every symbol, fed by trades
+type TickerManager struct {
+	tickers map[string]*symbolTicker
+	clock   common.Clock
+	mu      sync.RWMutex
+}
+
+// NewTickerManager clock nil means system clock
+func NewTickerManager(clock common.Clock) *TickerManager {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	return &TickerManager{
+		tickers: make(map[string]*symbolTicker),
+		clock:   clock,
+	}
+}
+
+// RecordTrade add one trade into the symbol's current bucket
+func (tm *TickerManager) RecordTrade(symbol string, price, size float64) error {
+	if price <= 0 || size <= 0 {
+		return fmt.Errorf("price and size must be greater than zero")
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	st, exists := tm.tickers[symbol]
+	if !exists {
+		st = &symbolTicker{}
+		tm.tickers[symbol] = st
+	}
+	st.record(tm.clock.Now(), price, size)
+
+	return nil
+}
+
+// GetTicker 24h statistics of the symbol
+func (tm *TickerManager) GetTicker(symbol string) (Ticker, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	st, exists := tm.tickers[symbol]
+	if !exists {
+		return Ticker{}, fmt.Errorf("symbol %s has no ticker", symbol)
+	}
+	return st.ticker(symbol, tm.clock.Now()), nil
+}
+
+// GetTickers 24h statistics of all symbols, sorted by symbol (market overview)
+func (tm *TickerManager) GetTickers() []Ticker {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	now := tm.clock.Now()
+	tickers := make([]Ticker, 0, len(tm.tickers))
+	for symbol, st := range tm.tickers {
+		tickers = append(tickers, st.ticker(symbol, now))
+	}
+	sort.Slice(tickers, func(i, j int) bool {
+		return tickers[i].Symbol < tickers[j].Symbol
+	})
+	return tickers
+}
@@ -0,0 +1,95 @@
+package market
+
+import (
+	"frizo/futures_engine/internal/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestTickerStats(t *testing.T) {
+	clock := common.NewFakeClock(start)
+	tm := NewTickerManager(clock)
+
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 50000, 1))
+	clock.Advance(30 * time.Second)
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 51000, 2))
+	clock.Advance(time.Hour)
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 49000, 1))
+
+	ticker, err := tm.GetTicker("BTCUSDT")
+	require.NoError(t, err)
+
+	assert.Equal(t, 49000.0, ticker.LastPrice)
+	assert.Equal(t, 50000.0, ticker.OpenPrice)
+	assert.Equal(t, 51000.0, ticker.HighPrice)
+	assert.Equal(t, 49000.0, ticker.LowPrice)
+	assert.Equal(t, 4.0, ticker.BaseVolume)
+	assert.Equal(t, 50000.0+102000.0+49000.0, ticker.QuoteVolume)
+	assert.Equal(t, int64(3), ticker.TradeCount)
+	assert.Equal(t, -1000.0, ticker.PriceChange)
+	assert.InDelta(t, -2.0, ticker.PriceChangePercent, 1e-9)
+
+	_, err = tm.GetTicker("ETHUSDT")
+	assert.Error(t, err)
+
+	assert.Error(t, tm.RecordTrade("BTCUSDT", 0, 1))
+}
+
+func TestTickerRollover(t *testing.T) {
+	clock := common.NewFakeClock(start)
+	tm := NewTickerManager(clock)
+
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 50000, 1))
+	clock.Advance(12 * time.Hour)
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 55000, 2))
+
+	// 24h 邊界前: 兩筆都在窗口內
+	clock.Set(start.Add(24*time.Hour - time.Second))
+	ticker, err := tm.GetTicker("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ticker.TradeCount)
+	assert.Equal(t, 50000.0, ticker.OpenPrice)
+
+	// 跨過 24h: 第一筆過期
+	clock.Set(start.Add(24 * time.Hour))
+	ticker, err = tm.GetTicker("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), ticker.TradeCount)
+	assert.Equal(t, 2.0, ticker.BaseVolume)
+	assert.Equal(t, 55000.0, ticker.OpenPrice)
+	assert.Equal(t, 0.0, ticker.PriceChange)
+
+	// 同一個 ring slot 被新交易覆寫
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 60000, 3))
+	ticker, err = tm.GetTicker("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ticker.TradeCount)
+	assert.Equal(t, 5.0, ticker.BaseVolume)
+	assert.Equal(t, 60000.0, ticker.HighPrice)
+
+	// 全部過期後只保留最後成交價
+	clock.Advance(48 * time.Hour)
+	ticker, err = tm.GetTicker("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), ticker.TradeCount)
+	assert.Equal(t, 0.0, ticker.BaseVolume)
+	assert.Equal(t, 60000.0, ticker.LastPrice)
+}
+
+func TestGetTickers(t *testing.T) {
+	clock := common.NewFakeClock(start)
+	tm := NewTickerManager(clock)
+
+	require.NoError(t, tm.RecordTrade("ETHUSDT", 3000, 1))
+	require.NoError(t, tm.RecordTrade("BTCUSDT", 50000, 1))
+
+	tickers := tm.GetTickers()
+	require.Len(t, tickers, 2)
+	assert.Equal(t, "BTCUSDT", tickers[0].Symbol)
+	assert.Equal(t, "ETHUSDT", tickers[1].Symbol)
+}
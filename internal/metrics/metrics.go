@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Labels label values of one series, the keys of a metric must be the same on every call
+type Labels map[string]string
+
+// Registry (指標) backend of the engine's counters, gauges and histograms, e.g. Prometheus,
+// or Recorder in tests. series are created on first use. implementations must be safe for
+// concurrent use and must not block, instrumented code may call them under its own locks
+type Registry interface {
+	AddCounter(name string, delta float64, labels Labels)
+	SetGauge(name string, value float64, labels Labels)
+	Observe(name string, value float64, labels Labels)
+}
+
+// Nop drops everything, the default of every instrumented component
+type Nop struct{}
+
+func (Nop) AddCounter(string, float64, Labels) {}
+func (Nop) SetGauge(string, float64, Labels)   {}
+func (Nop) Observe(string, float64, Labels)    {}
+
+// OrNop registry, Nop when nil
+func OrNop(registry Registry) Registry {
+	if registry == nil {
+		return Nop{}
+	}
+	return registry
+}
+
+// Recorder in-memory Registry keeping every value, for tests
+type Recorder struct {
+	counters     map[string]float64
+	gauges       map[string]float64
+	observations map[string][]float64
+	mu           sync.Mutex
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		counters:     make(map[string]float64),
+		gauges:       make(map[string]float64),
+		observations: make(map[string][]float64),
+	}
+}
+
+func (r *Recorder) AddCounter(name string, delta float64, labels Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[seriesKey(name, labels)] += delta
+}
+
+func (r *Recorder) SetGauge(name string, value float64, labels Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[seriesKey(name, labels)] = value
+}
+
+func (r *Recorder) Observe(name string, value float64, labels Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := seriesKey(name, labels)
+	r.observations[key] = append(r.observations[key], value)
+}
+
+// Counter value of one series, 0 if never added to
+func (r *Recorder) Counter(name string, labels Labels) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[seriesKey(name, labels)]
+}
+
+// Gauge last value of one series, false if never set
+func (r *Recorder) Gauge(name string, labels Labels) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value, ok := r.gauges[seriesKey(name, labels)]
+	return value, ok
+}
+
+// Observations copy of the observed values of one series, in order
+func (r *Recorder) Observations(name string, labels Labels) []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]float64(nil), r.observations[seriesKey(name, labels)]...)
+}
+
+// seriesKey name{k1=v1,k2=v2} with sorted keys
+func seriesKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(labels[key])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	var registry Registry = recorder
+
+	registry.AddCounter("orders_total", 1, Labels{"symbol": "BTCUSDT", "side": "buy"})
+	registry.AddCounter("orders_total", 2, Labels{"side": "buy", "symbol": "BTCUSDT"})
+	registry.AddCounter("orders_total", 1, Labels{"symbol": "ETHUSDT", "side": "buy"})
+	assert.Equal(t, 3.0, recorder.Counter("orders_total", Labels{"symbol": "BTCUSDT", "side": "buy"}), "label order does not matter")
+	assert.Zero(t, recorder.Counter("orders_total", nil))
+
+	_, ok := recorder.Gauge("halted_symbols", nil)
+	assert.False(t, ok)
+	registry.SetGauge("halted_symbols", 2, nil)
+	registry.SetGauge("halted_symbols", 1, nil)
+	value, ok := recorder.Gauge("halted_symbols", nil)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, value)
+
+	registry.Observe("latency_seconds", 0.1, nil)
+	registry.Observe("latency_seconds", 0.3, nil)
+	assert.Equal(t, []float64{0.1, 0.3}, recorder.Observations("latency_seconds", nil))
+
+	assert.Equal(t, Nop{}, OrNop(nil))
+	assert.Equal(t, recorder, OrNop(recorder))
+}
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultNamespace prefix of every series of a Prometheus registry
+const DefaultNamespace = "futures_engine"
+
+// MetricRegistryErrors calls dropped because their labels did not match the first call of the metric,
+// or the name was already used by another kind
+const MetricRegistryErrors = "metrics_registry_errors_total"
+
+// PrometheusConfig nil means DefaultNamespace and prometheus.DefBuckets for every histogram
+type PrometheusConfig struct {
+	Namespace string               // "" means DefaultNamespace
+	Buckets   map[string][]float64 // histogram buckets by metric name, prometheus.DefBuckets for the rest
+}
+
+// Prometheus Registry backed by a Prometheus registry, served by Handler in the text exposition format.
+// a metric takes the label keys of its first call, later calls with other keys are dropped and counted
+// in MetricRegistryErrors. the registry also exports the Go runtime (goroutines, GC) and process collectors
+type Prometheus struct {
+	config     PrometheusConfig
+	registry   *prometheus.Registry
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+	errors     prometheus.Counter
+	mu         sync.RWMutex
+
+	scrapeHooks []func(Registry)
+	scrapeMu    sync.Mutex
+}
+
+func NewPrometheus(config *PrometheusConfig) *Prometheus {
+	if config == nil {
+		config = &PrometheusConfig{}
+	}
+	p := &Prometheus{
+		config:     *config,
+		registry:   prometheus.NewRegistry(),
+		counters:   make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+	if p.config.Namespace == "" {
+		p.config.Namespace = DefaultNamespace
+	}
+	p.errors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: p.config.Namespace,
+		Name:      MetricRegistryErrors,
+		Help:      "Metric calls dropped for mismatched labels or kind.",
+	})
+	p.registry.MustRegister(
+		p.errors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return p
+}
+
+func (p *Prometheus) AddCounter(name string, delta float64, labels Labels) {
+	if delta < 0 {
+		p.errors.Inc() // counters only go up
+		return
+	}
+	vec, ok := metricVec(p, p.counters, name, labels, func(opts prometheus.Opts, keys []string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts(opts), keys)
+	})
+	if !ok {
+		return
+	}
+	if counter, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		counter.Add(delta)
+	} else {
+		p.errors.Inc()
+	}
+}
+
+func (p *Prometheus) SetGauge(name string, value float64, labels Labels) {
+	vec, ok := metricVec(p, p.gauges, name, labels, func(opts prometheus.Opts, keys []string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts(opts), keys)
+	})
+	if !ok {
+		return
+	}
+	if gauge, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		gauge.Set(value)
+	} else {
+		p.errors.Inc()
+	}
+}
+
+func (p *Prometheus) Observe(name string, value float64, labels Labels) {
+	vec, ok := metricVec(p, p.histograms, name, labels, func(opts prometheus.Opts, keys []string) *prometheus.HistogramVec {
+		buckets := p.config.Buckets[name]
+		if buckets == nil {
+			buckets = prometheus.DefBuckets
+		}
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace, Name: opts.Name, Help: opts.Help, Buckets: buckets,
+		}, keys)
+	})
+	if !ok {
+		return
+	}
+	if histogram, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		histogram.Observe(value)
+	} else {
+		p.errors.Inc()
+	}
+}
+
+// OnScrape run hook before every Handler scrape, for gauges sampled from component state
+// (e.g. open positions) rather than set as things happen
+func (p *Prometheus) OnScrape(hook func(Registry)) {
+	p.scrapeMu.Lock()
+	defer p.scrapeMu.Unlock()
+	p.scrapeHooks = append(p.scrapeHooks, hook)
+}
+
+// Handler GET /metrics: run the scrape hooks, then expose every series
+func (p *Prometheus) Handler() http.Handler {
+	gather := promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.scrapeMu.Lock()
+		hooks := append([]func(Registry){}, p.scrapeHooks...)
+		p.scrapeMu.Unlock()
+		for _, hook := range hooks {
+			hook(p)
+		}
+		gather.ServeHTTP(w, r)
+	})
+}
+
+// Gatherer the underlying registry, e.g. to push or to register more collectors
+func (p *Prometheus) Gatherer() prometheus.Gatherer {
+	return p.registry
+}
+
+// metricVec vec of name, created with the label keys of this first call. false when the name is taken
+// by another kind of metric
+func metricVec[V prometheus.Collector](p *Prometheus, vecs map[string]V, name string, labels Labels,
+	create func(opts prometheus.Opts, keys []string) V) (V, bool) {
+	p.mu.RLock()
+	vec, ok := vecs[name]
+	p.mu.RUnlock()
+	if ok {
+		return vec, true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if vec, ok = vecs[name]; ok {
+		return vec, true
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	vec = create(prometheus.Opts{
+		Namespace: p.config.Namespace,
+		Name:      name,
+		Help:      strings.ReplaceAll(name, "_", " ") + ".",
+	}, keys)
+	if err := p.registry.Register(vec); err != nil {
+		p.errors.Inc()
+		var zero V
+		return zero, false
+	}
+	vecs[name] = vec
+	return vec, true
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus(&PrometheusConfig{Buckets: map[string][]float64{"latency_seconds": {0.1, 1}}})
+	var registry Registry = p
+
+	registry.AddCounter("orders_total", 1, Labels{"symbol": "BTCUSDT", "side": "buy"})
+	registry.AddCounter("orders_total", 2, Labels{"side": "buy", "symbol": "BTCUSDT"})
+	registry.SetGauge("halted_symbols", 2, nil)
+	registry.Observe("latency_seconds", 0.05, Labels{"symbol": "BTCUSDT"})
+	registry.Observe("latency_seconds", 0.5, Labels{"symbol": "BTCUSDT"})
+
+	// dropped: other label keys, another kind under a taken name, a negative counter delta
+	registry.AddCounter("orders_total", 1, Labels{"symbol": "BTCUSDT"})
+	registry.SetGauge("orders_total", 1, nil)
+	registry.AddCounter("orders_total", -1, Labels{"symbol": "BTCUSDT", "side": "buy"})
+
+	scrapes := 0
+	p.OnScrape(func(registry Registry) {
+		scrapes++
+		registry.SetGauge("sampled", float64(scrapes), nil)
+	})
+
+	body := scrape(t, p.Handler())
+	assert.Contains(t, body, `futures_engine_orders_total{side="buy",symbol="BTCUSDT"} 3`)
+	assert.Contains(t, body, `futures_engine_halted_symbols 2`)
+	assert.Contains(t, body, `futures_engine_latency_seconds_bucket{symbol="BTCUSDT",le="0.1"} 1`)
+	assert.Contains(t, body, `futures_engine_latency_seconds_bucket{symbol="BTCUSDT",le="1"} 2`)
+	assert.Contains(t, body, `futures_engine_latency_seconds_count{symbol="BTCUSDT"} 2`)
+	assert.Contains(t, body, `futures_engine_metrics_registry_errors_total 3`)
+	assert.Contains(t, body, `futures_engine_sampled 1`)
+	assert.Contains(t, body, "go_goroutines ")
+
+	assert.Contains(t, scrape(t, p.Handler()), `futures_engine_sampled 2`, "hooks run on every scrape")
+}
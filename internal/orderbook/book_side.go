@@ -0,0 +1,210 @@
+package orderbook
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BookSide one side of the order book (買盤 or 賣盤)
+type BookSide struct {
+	side   Side
+	levels PriceLevels
+	orders map[string]*Order // orderID -> order
+	mu     sync.RWMutex
+
+	simulated bool // paper trading book, only accepts simulated orders
+}
+
+// NewBookSide new side with given levels implementation, nil means NewPriceLevels default.
+func NewBookSide(side Side, levels PriceLevels) *BookSide {
+	if levels == nil {
+		levels = NewPriceLevels(side, 0)
+	}
+	return &BookSide{
+		side:   side,
+		levels: levels,
+		orders: make(map[string]*Order),
+	}
+}
+
+// NewSimulatedBookSide book side of the paper trading flow, never mixed with real orders
+func NewSimulatedBookSide(side Side, levels PriceLevels) *BookSide {
+	bs := NewBookSide(side, levels)
+	bs.simulated = true
+	return bs
+}
+
+func (bs *BookSide) IsSimulated() bool {
+	return bs.simulated
+}
+
+// AddOrder (掛單)
+func (bs *BookSide) AddOrder(order *Order) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if order.Side != bs.side {
+		return fmt.Errorf("order side %s does not match book side %s", order.Side, bs.side)
+	}
+	if order.Simulated != bs.simulated {
+		return fmt.Errorf("simulated order can not rest in a real book and vice versa")
+	}
+	if order.Size <= 0 || order.Price <= 0 {
+		return fmt.Errorf("order price and size must be greater than zero")
+	}
+	if _, exists := bs.orders[order.ID]; exists {
+		return fmt.Errorf("order %s already exists", order.ID)
+	}
+
+	bs.levels.GetOrCreate(order.Price).append(order)
+	bs.orders[order.ID] = order
+
+	return nil
+}
+
+// CancelOrder (撤單)
+func (bs *BookSide) CancelOrder(orderID string) (*Order, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	order, exists := bs.orders[orderID]
+	if !exists {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+
+	level, ok := bs.levels.Get(order.Price)
+	if !ok {
+		return nil, fmt.Errorf("price level %f not found", order.Price)
+	}
+	level.remove(orderID)
+	if level.Len() == 0 {
+		bs.levels.Delete(order.Price)
+	}
+	delete(bs.orders, orderID)
+
+	return order, nil
+}
+
+// CancelAll (全部撤單) remove every resting order, return them from the best price in time priority
+func (bs *BookSide) CancelAll() []*Order {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var cancelled []*Order
+	var prices []float64
+	bs.levels.Ascend(func(level *PriceLevel) bool {
+		cancelled = append(cancelled, level.orders...)
+		prices = append(prices, level.Price)
+		return true
+	})
+	for _, price := range prices {
+		bs.levels.Delete(price)
+	}
+	bs.orders = make(map[string]*Order)
+
+	return cancelled
+}
+
+// BestPrice (最優價)
+func (bs *BookSide) BestPrice() (float64, bool) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	if level, ok := bs.levels.Best(); ok {
+		return level.Price, true
+	}
+	return 0, false
+}
+
+// Match sweep resting orders from the best price while they cross limitPrice,
+// return fills and the unfilled size of the taker.
+func (bs *BookSide) Match(limitPrice, size float64) ([]Fill, float64) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var fills []Fill
+	remaining := size
+
+	for remaining > 0 {
+		level, ok := bs.levels.Best()
+		if !ok || !bs.crosses(level.Price, limitPrice) {
+			break
+		}
+
+		for remaining > 0 && level.Len() > 0 {
+			maker := level.orders[0]
+			fillSize := min(maker.Size, remaining)
+
+			remaining -= fillSize
+			maker.Size -= fillSize
+			level.TotalSize -= fillSize
+
+			fills = append(fills, Fill{
+				MakerOrderID:   maker.ID,
+				MakerUserID:    maker.UserID,
+				Price:          level.Price,
+				Size:           fillSize,
+				MakerRemaining: maker.Size,
+			})
+
+			if maker.Size <= 0 {
+				level.popFront()
+				delete(bs.orders, maker.ID)
+			}
+		}
+
+		if level.Len() == 0 {
+			bs.levels.Delete(level.Price)
+		}
+	}
+
+	return fills, remaining
+}
+
+// Depth top n levels (price, total size) from best to worst
+func (bs *BookSide) Depth(n int) [][2]float64 {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	if n <= 0 {
+		return nil
+	}
+
+	depth := make([][2]float64, 0, n)
+	bs.levels.Ascend(func(level *PriceLevel) bool {
+		depth = append(depth, [2]float64{level.Price, level.TotalSize})
+		return len(depth) < n
+	})
+	return depth
+}
+
+// Orders copy of the resting orders from the best price in time priority
+func (bs *BookSide) Orders() []Order {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	orders := make([]Order, 0, len(bs.orders))
+	bs.levels.Ascend(func(level *PriceLevel) bool {
+		for _, order := range level.orders {
+			orders = append(orders, *order)
+		}
+		return true
+	})
+	return orders
+}
+
+func (bs *BookSide) Len() int {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return len(bs.orders)
+}
+
+// crosses whether a resting level price is acceptable for a taker limit price.
+func (bs *BookSide) crosses(levelPrice, limitPrice float64) bool {
+	if bs.side == BUY {
+		// taker sells into bids: bid >= limit
+		return levelPrice >= limitPrice
+	}
+	// taker buys from asks: ask <= limit
+	return levelPrice <= limitPrice
+}
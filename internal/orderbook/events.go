@@ -0,0 +1,245 @@
+package orderbook
+
+import (
+	"sync"
+	"time"
+)
+
+// OrderEventType order lifecycle event
+type OrderEventType string
+
+const (
+	OrderAccepted        OrderEventType = "accepted"
+	OrderPartiallyFilled OrderEventType = "partially_filled"
+	OrderFilled          OrderEventType = "filled"
+	OrderCanceled        OrderEventType = "canceled"
+	OrderExpired         OrderEventType = "expired"
+	OrderRejected        OrderEventType = "rejected"
+	OrderEventGap        OrderEventType = "gap" // slow consumer, Dropped events were discarded before this marker
+)
+
+// OrderEvent pushed to the order owner. Sequence increases by one per user,
+// a jump in Sequence is always preceded by a gap marker.
+type OrderEvent struct {
+	Sequence      uint64         `json:"sequence"`
+	Type          OrderEventType `json:"type"`
+	UserID        string         `json:"user_id"`
+	OrderID       string         `json:"order_id,omitempty"`
+	Symbol        string         `json:"symbol,omitempty"`
+	Side          Side           `json:"side,omitempty"`
+	Price         float64        `json:"price,omitempty"`
+	FillPrice     float64        `json:"fill_price,omitempty"`
+	FillSize      float64        `json:"fill_size,omitempty"`
+	RemainingSize float64        `json:"remaining_size,omitempty"`
+	Reason        string         `json:"reason,omitempty"`     // cancel / reject reason
+	Dropped       uint64         `json:"dropped,omitempty"`    // gap marker only
+	RequestID     string         `json:"request_id,omitempty"` // request that placed or cancelled the order
+	Simulated     bool           `json:"simulated"`            // paper trading event
+	Timestamp     time.Time      `json:"timestamp"`
+}
+
+// ========================================================
+
+// OrderSubscription bounded event channel of one user, drops the oldest events when full
+type OrderSubscription struct {
+	userID string
+	ch     chan OrderEvent
+	closed bool
+	hub    *OrderEventHub
+	mu     sync.Mutex
+}
+
+// C receive events, closed after Unsubscribe
+func (s *OrderSubscription) C() <-chan OrderEvent {
+	return s.ch
+}
+
+func (s *OrderSubscription) Unsubscribe() {
+	s.hub.unsubscribe(s)
+}
+
+// deliver never blocks. when full, the queue is compacted: oldest events are dropped and
+// merged with any leading gap marker into one marker at the head.
+func (s *OrderSubscription) deliver(event OrderEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if len(s.ch) == cap(s.ch) {
+		// only this goroutine sends, the consumer can only make more room
+		queued := make([]OrderEvent, 0, cap(s.ch))
+	drain:
+		for {
+			select {
+			case queuedEvent := <-s.ch:
+				queued = append(queued, queuedEvent)
+			default:
+				break drain
+			}
+		}
+
+		dropped := uint64(0)
+		for {
+			for len(queued) > 0 && queued[0].Type == OrderEventGap {
+				dropped += queued[0].Dropped
+				queued = queued[1:]
+			}
+			need := len(queued) + 1
+			if dropped > 0 {
+				need++ // gap marker
+			}
+			if need <= cap(s.ch) {
+				break
+			}
+			queued = queued[1:]
+			dropped++
+		}
+
+		if dropped > 0 {
+			s.ch <- OrderEvent{
+				Type:      OrderEventGap,
+				UserID:    s.userID,
+				Dropped:   dropped,
+				Timestamp: event.Timestamp,
+			}
+		}
+		for _, queuedEvent := range queued {
+			s.ch <- queuedEvent
+		}
+	}
+
+	s.ch <- event
+}
+
+func (s *OrderSubscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// ========================================================
+
+// OrderEventHub (訂單事件推送) per user subscriptions. Publish must be called after the
+// order / position state is committed, so a consumer reacting to "filled" sees the new state.
+type OrderEventHub struct {
+	bufferSize    int
+	subscriptions map[string][]*OrderSubscription // userID -> subscriptions
+	sequences     map[string]uint64               // userID -> last sequence
+	simulated     bool                            // label every event as paper trading
+	mu            sync.Mutex
+}
+
+// NewOrderEventHub bufferSize is the channel capacity of every subscription,
+// at least 2 so a gap marker and the next event always fit
+func NewOrderEventHub(bufferSize int) *OrderEventHub {
+	return &OrderEventHub{
+		bufferSize:    max(bufferSize, 2),
+		subscriptions: make(map[string][]*OrderSubscription),
+		sequences:     make(map[string]uint64),
+	}
+}
+
+// NewSimulatedOrderEventHub hub of the paper trading flow, every event is labeled simulated
+func NewSimulatedOrderEventHub(bufferSize int) *OrderEventHub {
+	hub := NewOrderEventHub(bufferSize)
+	hub.simulated = true
+	return hub
+}
+
+// Subscribe receive all order events of the user
+func (h *OrderEventHub) Subscribe(userID string) *OrderSubscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &OrderSubscription{
+		userID: userID,
+		ch:     make(chan OrderEvent, h.bufferSize),
+		hub:    h,
+	}
+	h.subscriptions[userID] = append(h.subscriptions[userID], sub)
+	return sub
+}
+
+// Publish assign the next sequence of the user and fan out, return the event sent
+func (h *OrderEventHub) Publish(event OrderEvent) OrderEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sequences[event.UserID]++
+	event.Sequence = h.sequences[event.UserID]
+	event.Simulated = h.simulated
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	for _, sub := range h.subscriptions[event.UserID] {
+		sub.deliver(event)
+	}
+	return event
+}
+
+// PublishFills fill events of the maker orders, call after the match is settled
+func (h *OrderEventHub) PublishFills(symbol string, side Side, fills []Fill) {
+	for _, fill := range fills {
+		eventType := OrderPartiallyFilled
+		if fill.MakerRemaining <= 0 {
+			eventType = OrderFilled
+		}
+		h.Publish(OrderEvent{
+			Type:          eventType,
+			UserID:        fill.MakerUserID,
+			OrderID:       fill.MakerOrderID,
+			Symbol:        symbol,
+			Side:          side,
+			FillPrice:     fill.Price,
+			FillSize:      fill.Size,
+			RemainingSize: fill.MakerRemaining,
+		})
+	}
+}
+
+// Sequences last order sequence of every user, a copy for persistence
+func (h *OrderEventHub) Sequences() map[string]uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sequences := make(map[string]uint64, len(h.sequences))
+	for userID, sequence := range h.sequences {
+		sequences[userID] = sequence
+	}
+	return sequences
+}
+
+// RestoreSequences continue the users' order sequences after the persisted ones, a sequence never moves back
+func (h *OrderEventHub) RestoreSequences(sequences map[string]uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for userID, sequence := range sequences {
+		h.sequences[userID] = max(h.sequences[userID], sequence)
+	}
+}
+
+func (h *OrderEventHub) unsubscribe(target *OrderSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.subscriptions[target.userID]
+	for i, sub := range subs {
+		if sub == target {
+			h.subscriptions[target.userID] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(h.subscriptions[target.userID]) == 0 {
+		delete(h.subscriptions, target.userID)
+	}
+	target.close()
+}
@@ -0,0 +1,123 @@
+package orderbook
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func drain(sub *OrderSubscription) []OrderEvent {
+	var events []OrderEvent
+	for {
+		select {
+		case event := <-sub.C():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestOrderEventsPartialFillThenCancel(t *testing.T) {
+	hub := NewOrderEventHub(16)
+	pm := position.NewPositionManager([]string{"BTCUSDT"})
+	sub := hub.Subscribe("maker")
+	other := hub.Subscribe("someone_else")
+
+	asks := NewBookSide(SELL, NewPriceLevels(SELL, 0))
+	order := &Order{ID: "o1", UserID: "maker", Side: SELL, Price: 50000, Size: 2}
+	require.NoError(t, asks.AddOrder(order))
+	hub.Publish(OrderEvent{Type: OrderAccepted, UserID: "maker", OrderID: "o1", Symbol: "BTCUSDT", Side: SELL, Price: 50000, RemainingSize: 2})
+
+	// taker 買 0.5: 先結算倉位, 再推送事件
+	fills, _ := asks.Match(50000, 0.5)
+	require.Len(t, fills, 1)
+	for _, fill := range fills {
+		_, err := pm.OpenPosition(common.ISOLATED, fill.MakerUserID, "BTCUSDT", position.SHORT, fill.Price, fill.Size, 10)
+		require.NoError(t, err)
+	}
+	hub.PublishFills("BTCUSDT", SELL, fills)
+
+	// 消費者收到 fill 時倉位已更新
+	events := drain(sub)
+	require.Len(t, events, 2)
+	fillEvent := events[1]
+	assert.Equal(t, OrderPartiallyFilled, fillEvent.Type)
+	pos, err := pm.GetPosition(fillEvent.UserID, fillEvent.Symbol, position.SHORT)
+	require.NoError(t, err)
+	assert.Equal(t, fillEvent.FillSize, pos.Size)
+
+	_, err = asks.CancelOrder("o1")
+	require.NoError(t, err)
+	hub.Publish(OrderEvent{Type: OrderCanceled, UserID: "maker", OrderID: "o1", Symbol: "BTCUSDT", Side: SELL, RemainingSize: 1.5, Reason: "user"})
+
+	events = append(events, drain(sub)...)
+	require.Len(t, events, 3)
+
+	assert.Equal(t, OrderAccepted, events[0].Type)
+	assert.Equal(t, OrderPartiallyFilled, events[1].Type)
+	assert.Equal(t, 0.5, events[1].FillSize)
+	assert.Equal(t, 1.5, events[1].RemainingSize)
+	assert.Equal(t, OrderCanceled, events[2].Type)
+	for i, event := range events {
+		assert.Equal(t, uint64(i+1), event.Sequence)
+	}
+
+	assert.Empty(t, drain(other), "events only go to the order owner")
+}
+
+func TestOrderEventsFullFill(t *testing.T) {
+	hub := NewOrderEventHub(4)
+	sub := hub.Subscribe("maker")
+
+	hub.PublishFills("BTCUSDT", SELL, []Fill{{MakerOrderID: "o1", MakerUserID: "maker", Price: 100, Size: 1, MakerRemaining: 0}})
+	events := drain(sub)
+	require.Len(t, events, 1)
+	assert.Equal(t, OrderFilled, events[0].Type)
+}
+
+func TestOrderEventsSlowConsumerGap(t *testing.T) {
+	hub := NewOrderEventHub(4)
+	sub := hub.Subscribe("user1")
+
+	// 消費者不讀, 推 10 筆
+	for i := 0; i < 10; i++ {
+		hub.Publish(OrderEvent{Type: OrderAccepted, UserID: "user1"})
+	}
+
+	events := drain(sub)
+	require.Len(t, events, 4)
+	assert.Equal(t, OrderEventGap, events[0].Type)
+	assert.Equal(t, uint64(7), events[0].Dropped)
+	assert.Equal(t, []uint64{8, 9, 10}, []uint64{events[1].Sequence, events[2].Sequence, events[3].Sequence})
+
+	// 讀完後恢復正常
+	hub.Publish(OrderEvent{Type: OrderCanceled, UserID: "user1"})
+	events = drain(sub)
+	require.Len(t, events, 1)
+	assert.Equal(t, uint64(11), events[0].Sequence)
+
+	sub.Unsubscribe()
+	_, ok := <-sub.C()
+	assert.False(t, ok, "channel closed after unsubscribe")
+	hub.Publish(OrderEvent{Type: OrderCanceled, UserID: "user1"}) // no panic
+}
+
+func TestOrderEventSequencesRestore(t *testing.T) {
+	hub := NewOrderEventHub(4)
+	hub.Publish(OrderEvent{Type: OrderAccepted, UserID: "alice"})
+	hub.Publish(OrderEvent{Type: OrderCanceled, UserID: "alice"})
+	hub.Publish(OrderEvent{Type: OrderAccepted, UserID: "bob"})
+	assert.Equal(t, map[string]uint64{"alice": 2, "bob": 1}, hub.Sequences())
+
+	restored := NewOrderEventHub(4)
+	restored.Publish(OrderEvent{Type: OrderAccepted, UserID: "bob"})
+	restored.Publish(OrderEvent{Type: OrderAccepted, UserID: "bob"})
+	restored.RestoreSequences(hub.Sequences())
+
+	assert.Equal(t, uint64(3), restored.Publish(OrderEvent{Type: OrderAccepted, UserID: "alice"}).Sequence)
+	assert.Equal(t, uint64(3), restored.Publish(OrderEvent{Type: OrderAccepted, UserID: "bob"}).Sequence, "never moves back")
+}
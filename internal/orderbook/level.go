@@ -0,0 +1,148 @@
+package orderbook
+
+// PriceLevel all resting orders at one price, FIFO (價格優先、時間優先)
+type PriceLevel struct {
+	Price     float64
+	TotalSize float64
+	orders    []*Order
+}
+
+func newPriceLevel(price float64) *PriceLevel {
+	return &PriceLevel{
+		Price:  price,
+		orders: make([]*Order, 0, 4),
+	}
+}
+
+// Orders returns the resting orders in time priority.
+func (l *PriceLevel) Orders() []*Order {
+	return l.orders
+}
+
+func (l *PriceLevel) Len() int {
+	return len(l.orders)
+}
+
+func (l *PriceLevel) append(o *Order) {
+	l.orders = append(l.orders, o)
+	l.TotalSize += o.Size
+}
+
+// remove order by ID, keep time priority of the rest.
+func (l *PriceLevel) remove(orderID string) (*Order, bool) {
+	for i, o := range l.orders {
+		if o.ID == orderID {
+			copy(l.orders[i:], l.orders[i+1:])
+			l.orders[len(l.orders)-1] = nil
+			l.orders = l.orders[:len(l.orders)-1]
+			l.TotalSize -= o.Size
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+// popFront remove the oldest order.
+func (l *PriceLevel) popFront() {
+	o := l.orders[0]
+	l.orders[0] = nil
+	l.orders = l.orders[1:]
+	l.TotalSize -= o.Size
+}
+
+// ========================================================
+
+// PriceLevels sorted price levels of one book side. Best price first:
+// descending for BUY (bids), ascending for SELL (asks).
+// Implementations are not safe for concurrent use, the book side owns the lock.
+type PriceLevels interface {
+	Len() int
+	// Get level by exact price
+	Get(price float64) (*PriceLevel, bool)
+	// GetOrCreate returns the level at price, inserting an empty one if missing
+	GetOrCreate(price float64) *PriceLevel
+	// Delete level by price, return false if not exist
+	Delete(price float64) bool
+	// Best level (最優價)
+	Best() (*PriceLevel, bool)
+	// Ascend iterate levels from best to worst until fn returns false
+	Ascend(fn func(level *PriceLevel) bool)
+}
+
+// LevelsKind implementation of PriceLevels
+type LevelsKind int
+
+const (
+	SliceLevels LevelsKind = iota
+	SkipListLevels
+	BTreeLevels
+)
+
+func (k LevelsKind) String() string {
+	switch k {
+	case SliceLevels:
+		return "slice"
+	case SkipListLevels:
+		return "skiplist"
+	case BTreeLevels:
+		return "btree"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultLevelsKind is used for books expected to be deep (see level_bench_test.go).
+var DefaultLevelsKind = BTreeLevels
+
+// smallBookLevels below this depth a sorted slice beats the tree structures.
+const smallBookLevels = 64
+
+// NewPriceLevels create levels by expected depth (預估價位檔數)
+func NewPriceLevels(side Side, expectedLevels int) PriceLevels {
+	if expectedLevels > 0 && expectedLevels <= smallBookLevels {
+		return NewPriceLevelsOf(SliceLevels, side)
+	}
+	return NewPriceLevelsOf(DefaultLevelsKind, side)
+}
+
+// NewPriceLevelsOf create levels with specific implementation
+func NewPriceLevelsOf(kind LevelsKind, side Side) PriceLevels {
+	better := priceComparator(side)
+	switch kind {
+	case SkipListLevels:
+		return newSkipListLevels(better)
+	case BTreeLevels:
+		return newBTreeLevels(better)
+	default:
+		return newSliceLevels(better)
+	}
+}
+
+// priceComparator better(a, b) reports whether price a has priority over price b.
+func priceComparator(side Side) func(a, b float64) bool {
+	if side == BUY {
+		// 買方：價格高者優先
+		return func(a, b float64) bool { return a > b }
+	}
+	// 賣方：價格低者優先
+	return func(a, b float64) bool { return a < b }
+}
+
+// --------------------------------------------------------------------------------------------
+// slice tools
+// --------------------------------------------------------------------------------------------
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
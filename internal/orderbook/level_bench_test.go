@@ -0,0 +1,109 @@
+package orderbook
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Book sizes and the number of distinct price levels they spread over.
+var benchBookSizes = []struct {
+	orders int
+	levels int
+}{
+	{10000, 1000},
+	{100000, 10000},
+	{1000000, 100000},
+}
+
+type benchOrder struct {
+	id    string
+	price float64
+}
+
+func setupBenchOrders(orders, levels int) []benchOrder {
+	rnd := rand.New(rand.NewSource(1))
+	list := make([]benchOrder, orders)
+	for i := range list {
+		list[i] = benchOrder{
+			id:    fmt.Sprintf("o%d", i),
+			price: float64(50000 + rnd.Intn(levels)),
+		}
+	}
+	return list
+}
+
+func setupBenchBookSide(kind LevelsKind, orders []benchOrder) *BookSide {
+	bs := NewBookSide(SELL, NewPriceLevelsOf(kind, SELL))
+	for _, o := range orders {
+		bs.AddOrder(&Order{ID: o.id, Side: SELL, Price: o.price, Size: 1})
+	}
+	return bs
+}
+
+func forEachBench(b *testing.B, fn func(b *testing.B, kind LevelsKind, orders []benchOrder)) {
+	for _, size := range benchBookSizes {
+		orders := setupBenchOrders(size.orders, size.levels)
+		for _, kind := range allLevelsKinds {
+			b.Run(fmt.Sprintf("%s/%d", kind, size.orders), func(b *testing.B) {
+				fn(b, kind, orders)
+			})
+		}
+	}
+}
+
+// BenchmarkLevelsInsert add one order into a book already holding N orders
+func BenchmarkLevelsInsert(b *testing.B) {
+	forEachBench(b, func(b *testing.B, kind LevelsKind, orders []benchOrder) {
+		bs := setupBenchBookSide(kind, orders)
+		extra := setupBenchOrders(b.N, len(orders)/5)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bs.AddOrder(&Order{ID: fmt.Sprintf("x%d", i), Side: SELL, Price: extra[i].price + 0.5, Size: 1})
+		}
+	})
+}
+
+// BenchmarkLevelsCancel cancel then re-add, keep the book at N orders
+func BenchmarkLevelsCancel(b *testing.B) {
+	forEachBench(b, func(b *testing.B, kind LevelsKind, orders []benchOrder) {
+		bs := setupBenchBookSide(kind, orders)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			o := orders[i%len(orders)]
+			order, _ := bs.CancelOrder(o.id)
+			bs.AddOrder(order)
+		}
+	})
+}
+
+// BenchmarkLevelsBestPrice top of book access
+func BenchmarkLevelsBestPrice(b *testing.B) {
+	forEachBench(b, func(b *testing.B, kind LevelsKind, orders []benchOrder) {
+		bs := setupBenchBookSide(kind, orders)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bs.BestPrice()
+		}
+	})
+}
+
+// BenchmarkLevelsMatchSweep one taker consumes the whole book
+func BenchmarkLevelsMatchSweep(b *testing.B) {
+	forEachBench(b, func(b *testing.B, kind LevelsKind, orders []benchOrder) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			bs := setupBenchBookSide(kind, orders)
+			b.StartTimer()
+
+			bs.Match(1e12, float64(len(orders)))
+		}
+	})
+}
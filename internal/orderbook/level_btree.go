@@ -0,0 +1,270 @@
+package orderbook
+
+import "sort"
+
+const (
+	btreeDegree   = 16                // minimum degree t
+	btreeMaxItems = 2*btreeDegree - 1 // a full node
+	btreeMinItems = btreeDegree - 1   // every non-root node keeps at least t-1 items
+)
+
+type btreeNode struct {
+	items    []*PriceLevel
+	children []*btreeNode
+}
+
+func (n *btreeNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+// btreeLevels in-memory B-tree ordered by price priority, the leftmost item is the best level.
+type btreeLevels struct {
+	root   *btreeNode
+	length int
+	better func(a, b float64) bool
+}
+
+func newBTreeLevels(better func(a, b float64) bool) *btreeLevels {
+	return &btreeLevels{better: better}
+}
+
+func (t *btreeLevels) Len() int {
+	return t.length
+}
+
+// find index of the first item that price does not come after.
+func (t *btreeLevels) find(n *btreeNode, price float64) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return !t.better(n.items[i].Price, price)
+	})
+	return i, i < len(n.items) && n.items[i].Price == price
+}
+
+func (t *btreeLevels) Get(price float64) (*PriceLevel, bool) {
+	n := t.root
+	for n != nil {
+		i, found := t.find(n, price)
+		if found {
+			return n.items[i], true
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return nil, false
+}
+
+func (t *btreeLevels) GetOrCreate(price float64) *PriceLevel {
+	if level, ok := t.Get(price); ok {
+		return level
+	}
+
+	level := newPriceLevel(price)
+	if t.root == nil {
+		t.root = &btreeNode{items: make([]*PriceLevel, 0, btreeMaxItems)}
+	}
+	if len(t.root.items) == btreeMaxItems {
+		// grow tree height by splitting the full root
+		oldRoot := t.root
+		t.root = &btreeNode{
+			items:    make([]*PriceLevel, 0, btreeMaxItems),
+			children: []*btreeNode{oldRoot},
+		}
+		t.splitChild(t.root, 0)
+	}
+	t.insertNonFull(t.root, level)
+	t.length++
+
+	return level
+}
+
+func (t *btreeLevels) insertNonFull(n *btreeNode, level *PriceLevel) {
+	for {
+		i, _ := t.find(n, level.Price)
+		if n.leaf() {
+			n.items = insertAt(n.items, i, level)
+			return
+		}
+		if len(n.children[i].items) == btreeMaxItems {
+			t.splitChild(n, i)
+			if t.better(n.items[i].Price, level.Price) {
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// splitChild split full child i of n, move its median item up into n.
+func (t *btreeLevels) splitChild(n *btreeNode, i int) {
+	child := n.children[i]
+	median := child.items[btreeMinItems]
+
+	right := &btreeNode{items: make([]*PriceLevel, 0, btreeMaxItems)}
+	right.items = append(right.items, child.items[btreeMinItems+1:]...)
+	if !child.leaf() {
+		right.children = append(make([]*btreeNode, 0, btreeMaxItems+1), child.children[btreeMinItems+1:]...)
+		clear(child.children[btreeMinItems+1:])
+		child.children = child.children[:btreeMinItems+1]
+	}
+	clear(child.items[btreeMinItems:])
+	child.items = child.items[:btreeMinItems]
+
+	n.items = insertAt(n.items, i, median)
+	n.children = insertAt(n.children, i+1, right)
+}
+
+func (t *btreeLevels) Delete(price float64) bool {
+	if t.root == nil {
+		return false
+	}
+
+	deleted := t.remove(t.root, price)
+
+	// shrink tree height when root becomes empty
+	if len(t.root.items) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	if deleted {
+		t.length--
+	}
+
+	return deleted
+}
+
+// remove price from subtree n, n always holds more than the minimum items (except root).
+func (t *btreeLevels) remove(n *btreeNode, price float64) bool {
+	i, found := t.find(n, price)
+
+	if n.leaf() {
+		if !found {
+			return false
+		}
+		n.items = removeAt(n.items, i)
+		return true
+	}
+
+	if found {
+		switch {
+		case len(n.children[i].items) > btreeMinItems:
+			// replace by predecessor
+			pred := t.max(n.children[i])
+			n.items[i] = pred
+			return t.remove(n.children[i], pred.Price)
+		case len(n.children[i+1].items) > btreeMinItems:
+			// replace by successor
+			succ := t.min(n.children[i+1])
+			n.items[i] = succ
+			return t.remove(n.children[i+1], succ.Price)
+		default:
+			t.merge(n, i)
+			return t.remove(n.children[i], price)
+		}
+	}
+
+	if len(n.children[i].items) == btreeMinItems {
+		i = t.fill(n, i)
+	}
+	return t.remove(n.children[i], price)
+}
+
+// fill make sure child i has more than the minimum items, return the child index to descend.
+func (t *btreeLevels) fill(n *btreeNode, i int) int {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > btreeMinItems:
+		t.borrowFromPrev(n, i)
+	case i < len(n.children)-1 && len(n.children[i+1].items) > btreeMinItems:
+		t.borrowFromNext(n, i)
+	case i < len(n.children)-1:
+		t.merge(n, i)
+	default:
+		t.merge(n, i-1)
+		i--
+	}
+	return i
+}
+
+func (t *btreeLevels) borrowFromPrev(n *btreeNode, i int) {
+	child, sibling := n.children[i], n.children[i-1]
+
+	child.items = insertAt(child.items, 0, n.items[i-1])
+	n.items[i-1] = sibling.items[len(sibling.items)-1]
+	sibling.items = removeAt(sibling.items, len(sibling.items)-1)
+
+	if !sibling.leaf() {
+		child.children = insertAt(child.children, 0, sibling.children[len(sibling.children)-1])
+		sibling.children = removeAt(sibling.children, len(sibling.children)-1)
+	}
+}
+
+func (t *btreeLevels) borrowFromNext(n *btreeNode, i int) {
+	child, sibling := n.children[i], n.children[i+1]
+
+	child.items = append(child.items, n.items[i])
+	n.items[i] = sibling.items[0]
+	sibling.items = removeAt(sibling.items, 0)
+
+	if !sibling.leaf() {
+		child.children = append(child.children, sibling.children[0])
+		sibling.children = removeAt(sibling.children, 0)
+	}
+}
+
+// merge child i, separator item i and child i+1 into child i.
+func (t *btreeLevels) merge(n *btreeNode, i int) {
+	child, sibling := n.children[i], n.children[i+1]
+
+	child.items = append(child.items, n.items[i])
+	child.items = append(child.items, sibling.items...)
+	child.children = append(child.children, sibling.children...)
+
+	n.items = removeAt(n.items, i)
+	n.children = removeAt(n.children, i+1)
+}
+
+func (t *btreeLevels) min(n *btreeNode) *PriceLevel {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.items[0]
+}
+
+func (t *btreeLevels) max(n *btreeNode) *PriceLevel {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.items[len(n.items)-1]
+}
+
+func (t *btreeLevels) Best() (*PriceLevel, bool) {
+	if t.root == nil || len(t.root.items) == 0 {
+		return nil, false
+	}
+	return t.min(t.root), true
+}
+
+func (t *btreeLevels) Ascend(fn func(level *PriceLevel) bool) {
+	if t.root != nil {
+		t.ascend(t.root, fn)
+	}
+}
+
+func (t *btreeLevels) ascend(n *btreeNode, fn func(level *PriceLevel) bool) bool {
+	for i, item := range n.items {
+		if !n.leaf() && !t.ascend(n.children[i], fn) {
+			return false
+		}
+		if !fn(item) {
+			return false
+		}
+	}
+	if !n.leaf() {
+		return t.ascend(n.children[len(n.children)-1], fn)
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package orderbook
+
+import "math/rand"
+
+const (
+	skipListMaxHeight = 24   // enough for 4^24 levels
+	skipListP         = 0.25 // promote probability
+)
+
+type skipNode struct {
+	level *PriceLevel
+	next  []*skipNode
+}
+
+// skipListLevels skip list ordered by price priority, head.next[0] is the best level.
+type skipListLevels struct {
+	head   *skipNode
+	height int
+	length int
+	better func(a, b float64) bool
+	rnd    *rand.Rand
+
+	// update reused predecessor buffer, avoid alloc on insert/delete
+	update [skipListMaxHeight]*skipNode
+}
+
+func newSkipListLevels(better func(a, b float64) bool) *skipListLevels {
+	return &skipListLevels{
+		head:   &skipNode{next: make([]*skipNode, skipListMaxHeight)},
+		height: 1,
+		better: better,
+		rnd:    rand.New(rand.NewSource(1)),
+	}
+}
+
+func (s *skipListLevels) Len() int {
+	return s.length
+}
+
+// findPredecessors fill s.update with the last node before price on every height.
+func (s *skipListLevels) findPredecessors(price float64) *skipNode {
+	x := s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.better(x.next[i].level.Price, price) {
+			x = x.next[i]
+		}
+		s.update[i] = x
+	}
+	return x.next[0]
+}
+
+func (s *skipListLevels) Get(price float64) (*PriceLevel, bool) {
+	x := s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.better(x.next[i].level.Price, price) {
+			x = x.next[i]
+		}
+	}
+	x = x.next[0]
+	if x != nil && x.level.Price == price {
+		return x.level, true
+	}
+	return nil, false
+}
+
+func (s *skipListLevels) GetOrCreate(price float64) *PriceLevel {
+	if x := s.findPredecessors(price); x != nil && x.level.Price == price {
+		return x.level
+	}
+
+	h := s.randomHeight()
+	if h > s.height {
+		for i := s.height; i < h; i++ {
+			s.update[i] = s.head
+		}
+		s.height = h
+	}
+
+	node := &skipNode{
+		level: newPriceLevel(price),
+		next:  make([]*skipNode, h),
+	}
+	for i := 0; i < h; i++ {
+		node.next[i] = s.update[i].next[i]
+		s.update[i].next[i] = node
+	}
+	s.length++
+
+	return node.level
+}
+
+func (s *skipListLevels) Delete(price float64) bool {
+	x := s.findPredecessors(price)
+	if x == nil || x.level.Price != price {
+		return false
+	}
+
+	for i := 0; i < len(x.next); i++ {
+		s.update[i].next[i] = x.next[i]
+	}
+	for s.height > 1 && s.head.next[s.height-1] == nil {
+		s.height--
+	}
+	s.length--
+
+	return true
+}
+
+func (s *skipListLevels) Best() (*PriceLevel, bool) {
+	if first := s.head.next[0]; first != nil {
+		return first.level, true
+	}
+	return nil, false
+}
+
+func (s *skipListLevels) Ascend(fn func(level *PriceLevel) bool) {
+	for x := s.head.next[0]; x != nil; x = x.next[0] {
+		if !fn(x.level) {
+			return
+		}
+	}
+}
+
+func (s *skipListLevels) randomHeight() int {
+	h := 1
+	for h < skipListMaxHeight && s.rnd.Float64() < skipListP {
+		h++
+	}
+	return h
+}
@@ -0,0 +1,70 @@
+package orderbook
+
+import "sort"
+
+// sliceLevels sorted slice, worst price first and best price last,
+// so taking the best level never shifts the slice.
+type sliceLevels struct {
+	levels []*PriceLevel
+	better func(a, b float64) bool
+}
+
+func newSliceLevels(better func(a, b float64) bool) *sliceLevels {
+	return &sliceLevels{
+		levels: make([]*PriceLevel, 0, smallBookLevels),
+		better: better,
+	}
+}
+
+func (s *sliceLevels) Len() int {
+	return len(s.levels)
+}
+
+// search index of the first level that is not worse than price.
+func (s *sliceLevels) search(price float64) (int, bool) {
+	i := sort.Search(len(s.levels), func(i int) bool {
+		return !s.better(price, s.levels[i].Price)
+	})
+	return i, i < len(s.levels) && s.levels[i].Price == price
+}
+
+func (s *sliceLevels) Get(price float64) (*PriceLevel, bool) {
+	if i, found := s.search(price); found {
+		return s.levels[i], true
+	}
+	return nil, false
+}
+
+func (s *sliceLevels) GetOrCreate(price float64) *PriceLevel {
+	i, found := s.search(price)
+	if found {
+		return s.levels[i]
+	}
+	level := newPriceLevel(price)
+	s.levels = insertAt(s.levels, i, level)
+	return level
+}
+
+func (s *sliceLevels) Delete(price float64) bool {
+	i, found := s.search(price)
+	if !found {
+		return false
+	}
+	s.levels = removeAt(s.levels, i)
+	return true
+}
+
+func (s *sliceLevels) Best() (*PriceLevel, bool) {
+	if len(s.levels) == 0 {
+		return nil, false
+	}
+	return s.levels[len(s.levels)-1], true
+}
+
+func (s *sliceLevels) Ascend(fn func(level *PriceLevel) bool) {
+	for i := len(s.levels) - 1; i >= 0; i-- {
+		if !fn(s.levels[i]) {
+			return
+		}
+	}
+}
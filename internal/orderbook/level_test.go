@@ -0,0 +1,267 @@
+package orderbook
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var allLevelsKinds = []LevelsKind{SliceLevels, SkipListLevels, BTreeLevels}
+
+func newTestOrder(id string, side Side, price, size float64) *Order {
+	return &Order{ID: id, UserID: "user_" + id, Side: side, Price: price, Size: size}
+}
+
+func collectPrices(levels PriceLevels) []float64 {
+	prices := make([]float64, 0, levels.Len())
+	levels.Ascend(func(level *PriceLevel) bool {
+		prices = append(prices, level.Price)
+		return true
+	})
+	return prices
+}
+
+// forEachLevelsKind run the same suite against every PriceLevels implementation
+func forEachLevelsKind(t *testing.T, fn func(t *testing.T, kind LevelsKind)) {
+	for _, kind := range allLevelsKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			fn(t, kind)
+		})
+	}
+}
+
+func TestPriceLevelsOrdering(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		bids := NewPriceLevelsOf(kind, BUY)
+		asks := NewPriceLevelsOf(kind, SELL)
+
+		for _, price := range []float64{100, 102, 99, 101, 98} {
+			bids.GetOrCreate(price)
+			asks.GetOrCreate(price)
+		}
+
+		// bids: 價格高者優先, asks: 價格低者優先
+		assert.Equal(t, []float64{102, 101, 100, 99, 98}, collectPrices(bids))
+		assert.Equal(t, []float64{98, 99, 100, 101, 102}, collectPrices(asks))
+
+		best, ok := bids.Best()
+		require.True(t, ok)
+		assert.Equal(t, 102.0, best.Price)
+
+		best, ok = asks.Best()
+		require.True(t, ok)
+		assert.Equal(t, 98.0, best.Price)
+	})
+}
+
+func TestPriceLevelsGetOrCreateIdempotent(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		levels := NewPriceLevelsOf(kind, BUY)
+
+		first := levels.GetOrCreate(100)
+		second := levels.GetOrCreate(100)
+
+		assert.Same(t, first, second)
+		assert.Equal(t, 1, levels.Len())
+
+		level, ok := levels.Get(100)
+		assert.True(t, ok)
+		assert.Same(t, first, level)
+
+		_, ok = levels.Get(101)
+		assert.False(t, ok)
+	})
+}
+
+func TestPriceLevelsDelete(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		levels := NewPriceLevelsOf(kind, SELL)
+		for i := 0; i < 10; i++ {
+			levels.GetOrCreate(float64(100 + i))
+		}
+
+		assert.True(t, levels.Delete(100))
+		assert.False(t, levels.Delete(100))
+		assert.False(t, levels.Delete(500))
+		assert.Equal(t, 9, levels.Len())
+
+		best, ok := levels.Best()
+		require.True(t, ok)
+		assert.Equal(t, 101.0, best.Price)
+
+		for i := 1; i < 10; i++ {
+			assert.True(t, levels.Delete(float64(100+i)))
+		}
+		assert.Equal(t, 0, levels.Len())
+
+		_, ok = levels.Best()
+		assert.False(t, ok)
+	})
+}
+
+func TestPriceLevelsAscendStop(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		levels := NewPriceLevelsOf(kind, BUY)
+		for i := 0; i < 1000; i++ {
+			levels.GetOrCreate(float64(i))
+		}
+
+		visited := 0
+		levels.Ascend(func(level *PriceLevel) bool {
+			visited++
+			return visited < 3
+		})
+		assert.Equal(t, 3, visited)
+	})
+}
+
+// TestPriceLevelsRandomized compare every implementation with a reference sorted set
+func TestPriceLevelsRandomized(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		for _, side := range []Side{BUY, SELL} {
+			rnd := rand.New(rand.NewSource(42))
+			levels := NewPriceLevelsOf(kind, side)
+			reference := make(map[float64]bool)
+
+			for i := 0; i < 20000; i++ {
+				price := float64(rnd.Intn(3000))
+				if rnd.Intn(3) == 0 {
+					assert.Equal(t, reference[price], levels.Delete(price))
+					delete(reference, price)
+				} else {
+					levels.GetOrCreate(price)
+					reference[price] = true
+				}
+			}
+
+			expected := make([]float64, 0, len(reference))
+			for price := range reference {
+				expected = append(expected, price)
+			}
+			if side == BUY {
+				sort.Sort(sort.Reverse(sort.Float64Slice(expected)))
+			} else {
+				sort.Float64s(expected)
+			}
+
+			assert.Equal(t, len(expected), levels.Len())
+			assert.Equal(t, expected, collectPrices(levels))
+		}
+	})
+}
+
+func TestNewPriceLevelsBySize(t *testing.T) {
+	_, isSlice := NewPriceLevels(BUY, 10).(*sliceLevels)
+	assert.True(t, isSlice, "small book should use slice levels")
+
+	_, isSlice = NewPriceLevels(BUY, 100000).(*sliceLevels)
+	assert.False(t, isSlice, "deep book should use the default tree levels")
+}
+
+// ========================================================
+// BookSide (matching suite)
+// ========================================================
+
+func TestBookSideAddAndCancel(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		bids := NewBookSide(BUY, NewPriceLevelsOf(kind, BUY))
+
+		require.NoError(t, bids.AddOrder(newTestOrder("1", BUY, 100, 1)))
+		require.NoError(t, bids.AddOrder(newTestOrder("2", BUY, 101, 2)))
+		require.NoError(t, bids.AddOrder(newTestOrder("3", BUY, 101, 3)))
+
+		assert.Error(t, bids.AddOrder(newTestOrder("3", BUY, 99, 1)), "duplicate order id")
+		assert.Error(t, bids.AddOrder(newTestOrder("4", SELL, 99, 1)), "wrong side")
+
+		best, ok := bids.BestPrice()
+		require.True(t, ok)
+		assert.Equal(t, 101.0, best)
+		assert.Equal(t, [][2]float64{{101, 5}, {100, 1}}, bids.Depth(10))
+
+		_, err := bids.CancelOrder("2")
+		require.NoError(t, err)
+		assert.Equal(t, [][2]float64{{101, 3}, {100, 1}}, bids.Depth(10))
+
+		_, err = bids.CancelOrder("3")
+		require.NoError(t, err)
+		best, _ = bids.BestPrice()
+		assert.Equal(t, 100.0, best)
+
+		_, err = bids.CancelOrder("3")
+		assert.Error(t, err)
+		assert.Equal(t, 1, bids.Len())
+	})
+}
+
+func TestBookSideMatchPriceTimePriority(t *testing.T) {
+	forEachLevelsKind(t, func(t *testing.T, kind LevelsKind) {
+		asks := NewBookSide(SELL, NewPriceLevelsOf(kind, SELL))
+
+		require.NoError(t, asks.AddOrder(newTestOrder("a", SELL, 101, 1)))
+		require.NoError(t, asks.AddOrder(newTestOrder("b", SELL, 100, 1)))
+		require.NoError(t, asks.AddOrder(newTestOrder("c", SELL, 100, 2)))
+		require.NoError(t, asks.AddOrder(newTestOrder("d", SELL, 102, 5)))
+
+		// buy 3.5 with limit 101: b(100) -> c(100) -> a(101, partial)
+		fills, remaining := asks.Match(101, 3.5)
+
+		assert.Equal(t, 0.0, remaining)
+		require.Len(t, fills, 3)
+		assert.Equal(t, Fill{MakerOrderID: "b", MakerUserID: "user_b", Price: 100, Size: 1, MakerRemaining: 0}, fills[0])
+		assert.Equal(t, Fill{MakerOrderID: "c", MakerUserID: "user_c", Price: 100, Size: 2, MakerRemaining: 0}, fills[1])
+		assert.Equal(t, Fill{MakerOrderID: "a", MakerUserID: "user_a", Price: 101, Size: 0.5, MakerRemaining: 0.5}, fills[2])
+
+		assert.Equal(t, [][2]float64{{101, 0.5}, {102, 5}}, asks.Depth(10))
+
+		// limit below best ask: nothing matches
+		fills, remaining = asks.Match(100, 1)
+		assert.Empty(t, fills)
+		assert.Equal(t, 1.0, remaining)
+
+		// sweep the whole side
+		fills, remaining = asks.Match(1000, 10)
+		assert.Len(t, fills, 2)
+		assert.InDelta(t, 4.5, remaining, 1e-9)
+		assert.Equal(t, 0, asks.Len())
+	})
+}
+
+// TestBookSideRandomizedAgainstSlice every implementation must produce the same fills as the slice levels
+func TestBookSideRandomizedAgainstSlice(t *testing.T) {
+	run := func(kind LevelsKind) []Fill {
+		rnd := rand.New(rand.NewSource(7))
+		bids := NewBookSide(BUY, NewPriceLevelsOf(kind, BUY))
+		live := make([]string, 0)
+		var fills []Fill
+
+		for i := 0; i < 20000; i++ {
+			switch op := rnd.Intn(10); {
+			case op < 6:
+				id := fmt.Sprintf("o%d", i)
+				order := newTestOrder(id, BUY, float64(900+rnd.Intn(200)), float64(1+rnd.Intn(5)))
+				if err := bids.AddOrder(order); err == nil {
+					live = append(live, id)
+				}
+			case op < 8 && len(live) > 0:
+				idx := rnd.Intn(len(live))
+				_, _ = bids.CancelOrder(live[idx])
+				live[idx] = live[len(live)-1]
+				live = live[:len(live)-1]
+			default:
+				f, _ := bids.Match(float64(900+rnd.Intn(200)), float64(1+rnd.Intn(20)))
+				fills = append(fills, f...)
+			}
+		}
+		return fills
+	}
+
+	expected := run(SliceLevels)
+	assert.NotEmpty(t, expected)
+	for _, kind := range []LevelsKind{SkipListLevels, BTreeLevels} {
+		assert.Equal(t, expected, run(kind), kind.String())
+	}
+}
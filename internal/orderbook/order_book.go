@@ -0,0 +1,476 @@
+package orderbook
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// MatchingMode how incoming orders are matched
+type MatchingMode int
+
+const (
+	MatchingContinuous MatchingMode = iota // match on arrival
+	MatchingAuction                        // call auction (集合競價), accumulate then uncross at one price
+)
+
+func (m MatchingMode) String() string {
+	switch m {
+	case MatchingContinuous:
+		return "CONTINUOUS"
+	case MatchingAuction:
+		return "AUCTION"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Trade one execution between a buy order and a sell order
+type Trade struct {
+	Symbol        string  `json:"symbol"`
+	Price         float64 `json:"price"`
+	Size          float64 `json:"size"`
+	BuyOrderID    string  `json:"buy_order_id"`
+	BuyUserID     string  `json:"buy_user_id"`
+	SellOrderID   string  `json:"sell_order_id"`
+	SellUserID    string  `json:"sell_user_id"`
+	BuyRemaining  float64 `json:"buy_remaining"`
+	SellRemaining float64 `json:"sell_remaining"`
+	RequestID     string  `json:"request_id,omitempty"` // request of the taker order, "" outside the API
+}
+
+// AuctionResult equilibrium of the auction book. Imbalance is buy minus sell volume
+// crossing at Price, positive means unmatched buy pressure.
+type AuctionResult struct {
+	Symbol    string  `json:"symbol"`
+	Price     float64 `json:"price"`
+	Volume    float64 `json:"volume"`
+	Imbalance float64 `json:"imbalance"`
+}
+
+// OrderGuard decides whether an order may enter the book, e.g. the risk kill switch.
+// called under the book lock, must not call back into the book
+type OrderGuard func(order *Order) error
+
+// IndicativePriceHandler receives the indicative price after every change of the auction book
+type IndicativePriceHandler func(result AuctionResult)
+
+// ========================================================
+
+// OrderBook (訂單簿) both sides of one symbol with a switchable matching mode
+type OrderBook struct {
+	symbol         string
+	bids           *BookSide
+	asks           *BookSide
+	mode           MatchingMode
+	referencePrice float64 // last trade price, auction tie-break
+	onIndicative   IndicativePriceHandler
+	guard          OrderGuard
+	mu             sync.Mutex
+}
+
+func NewOrderBook(symbol string) *OrderBook {
+	return &OrderBook{
+		symbol: symbol,
+		bids:   NewBookSide(BUY, nil),
+		asks:   NewBookSide(SELL, nil),
+	}
+}
+
+func (ob *OrderBook) Symbol() string {
+	return ob.symbol
+}
+
+func (ob *OrderBook) MatchingMode() MatchingMode {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.mode
+}
+
+// SetReferencePrice price the auction tie-break moves towards, updated by every trade
+func (ob *OrderBook) SetReferencePrice(price float64) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.referencePrice = price
+}
+
+// OnIndicativePrice set the handler of indicative price events during the auction
+func (ob *OrderBook) OnIndicativePrice(handler IndicativePriceHandler) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.onIndicative = handler
+}
+
+// SetOrderGuard guard of PlaceOrder and PlaceIOC, nil removes it
+func (ob *OrderBook) SetOrderGuard(guard OrderGuard) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.guard = guard
+}
+
+// SetMatchingMode switching from AUCTION to CONTINUOUS uncrosses the book first
+// and returns the auction trades.
+func (ob *OrderBook) SetMatchingMode(mode MatchingMode) ([]Trade, error) {
+	if mode != MatchingContinuous && mode != MatchingAuction {
+		return nil, fmt.Errorf("unknown matching mode %d", mode)
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.mode == mode {
+		return nil, nil
+	}
+
+	var trades []Trade
+	if ob.mode == MatchingAuction {
+		trades = ob.uncross()
+	}
+	ob.mode = mode
+
+	return trades, nil
+}
+
+// PlaceOrder limit order. continuous: match then rest the remaining size,
+// auction: rest without matching and emit the new indicative price.
+func (ob *OrderBook) PlaceOrder(order *Order) ([]Trade, error) {
+	if order.Side != BUY && order.Side != SELL {
+		return nil, fmt.Errorf("unknown order side %d", order.Side)
+	}
+	if order.Size <= 0 || order.Price <= 0 {
+		return nil, fmt.Errorf("order price and size must be greater than zero")
+	}
+
+	ob.mu.Lock()
+
+	own, opposite := ob.bids, ob.asks
+	if order.Side == SELL {
+		own, opposite = ob.asks, ob.bids
+	}
+	if order.Simulated != own.IsSimulated() {
+		ob.mu.Unlock()
+		return nil, fmt.Errorf("simulated order can not rest in a real book and vice versa")
+	}
+	if err := ob.checkGuard(order); err != nil {
+		ob.mu.Unlock()
+		return nil, err
+	}
+
+	if ob.mode == MatchingAuction {
+		if err := own.AddOrder(order); err != nil {
+			ob.mu.Unlock()
+			return nil, err
+		}
+		ob.emitIndicative()
+		return nil, nil
+	}
+	defer ob.mu.Unlock()
+
+	trades := ob.match(order, opposite)
+	if order.Size > 0 {
+		if err := own.AddOrder(order); err != nil {
+			return trades, err
+		}
+	}
+	return trades, nil
+}
+
+// PlaceIOC immediate-or-cancel: match up to order.Price, the unfilled size is left in order.Size
+// and never rests. refused during an auction.
+func (ob *OrderBook) PlaceIOC(order *Order) ([]Trade, error) {
+	if order.Side != BUY && order.Side != SELL {
+		return nil, fmt.Errorf("unknown order side %d", order.Side)
+	}
+	if order.Size <= 0 || order.Price <= 0 {
+		return nil, fmt.Errorf("order price and size must be greater than zero")
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.mode == MatchingAuction {
+		return nil, fmt.Errorf("ioc order not allowed during auction")
+	}
+	opposite := ob.asks
+	if order.Side == SELL {
+		opposite = ob.bids
+	}
+	if order.Simulated != opposite.IsSimulated() {
+		return nil, fmt.Errorf("simulated order can not match a real book and vice versa")
+	}
+	if err := ob.checkGuard(order); err != nil {
+		return nil, err
+	}
+	return ob.match(order, opposite), nil
+}
+
+// CancelOrder (撤單)
+func (ob *OrderBook) CancelOrder(side Side, orderID string) (*Order, error) {
+	ob.mu.Lock()
+
+	bookSide := ob.bids
+	if side == SELL {
+		bookSide = ob.asks
+	}
+	order, err := bookSide.CancelOrder(orderID)
+	if err != nil {
+		ob.mu.Unlock()
+		return nil, err
+	}
+
+	if ob.mode == MatchingAuction {
+		ob.emitIndicative()
+		return order, nil
+	}
+	ob.mu.Unlock()
+	return order, nil
+}
+
+// CancelAll (全部撤單) remove the resting orders of both sides, bids first
+func (ob *OrderBook) CancelAll() []*Order {
+	ob.mu.Lock()
+	cancelled := append(ob.bids.CancelAll(), ob.asks.CancelAll()...)
+	if ob.mode == MatchingAuction {
+		ob.emitIndicative()
+		return cancelled
+	}
+	ob.mu.Unlock()
+	return cancelled
+}
+
+// IndicativePrice equilibrium the auction would uncross at now, false if the book does not cross
+func (ob *OrderBook) IndicativePrice() (AuctionResult, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.equilibrium()
+}
+
+// Depth top n levels of one side
+func (ob *OrderBook) Depth(side Side, n int) [][2]float64 {
+	if side == SELL {
+		return ob.asks.Depth(n)
+	}
+	return ob.bids.Depth(n)
+}
+
+// match taker order against the opposite side at maker prices, order.Size becomes the unfilled size. no lock
+func (ob *OrderBook) match(order *Order, opposite *BookSide) []Trade {
+	fills, remaining := opposite.Match(order.Price, order.Size)
+	order.Size = remaining
+
+	trades := make([]Trade, 0, len(fills))
+	for _, fill := range fills {
+		trade := Trade{Symbol: ob.symbol, Price: fill.Price, Size: fill.Size}
+		if order.Side == BUY {
+			trade.BuyOrderID, trade.BuyUserID, trade.BuyRemaining = order.ID, order.UserID, remaining
+			trade.SellOrderID, trade.SellUserID, trade.SellRemaining = fill.MakerOrderID, fill.MakerUserID, fill.MakerRemaining
+		} else {
+			trade.SellOrderID, trade.SellUserID, trade.SellRemaining = order.ID, order.UserID, remaining
+			trade.BuyOrderID, trade.BuyUserID, trade.BuyRemaining = fill.MakerOrderID, fill.MakerUserID, fill.MakerRemaining
+		}
+		trades = append(trades, trade)
+		ob.referencePrice = fill.Price
+	}
+	return trades
+}
+
+// checkGuard no lock
+func (ob *OrderBook) checkGuard(order *Order) error {
+	if ob.guard == nil {
+		return nil
+	}
+	return ob.guard(order)
+}
+
+// emitIndicative unlock ob.mu, then call the handler outside the lock
+func (ob *OrderBook) emitIndicative() {
+	result, _ := ob.equilibrium()
+	handler := ob.onIndicative
+	ob.mu.Unlock()
+
+	if handler != nil {
+		handler(result)
+	}
+}
+
+// equilibrium price maximizing executable volume. ties are broken by
+// 1. minimal absolute imbalance  2. closest to the reference price  3. lower price
+func (ob *OrderBook) equilibrium() (AuctionResult, bool) {
+	result := AuctionResult{Symbol: ob.symbol}
+
+	bids := ob.bids.Depth(ob.bids.Len()) // high -> low
+	asks := ob.asks.Depth(ob.asks.Len()) // low -> high
+	if len(bids) == 0 || len(asks) == 0 || bids[0][0] < asks[0][0] {
+		return result, false
+	}
+
+	candidates := make([]float64, 0, len(bids)+len(asks))
+	totalBuy := 0.0
+	for _, level := range bids {
+		candidates = append(candidates, level[0])
+		totalBuy += level[1]
+	}
+	for _, level := range asks {
+		candidates = append(candidates, level[0])
+	}
+	sort.Float64s(candidates)
+
+	// ascending price: sell volume (ask <= p) grows, buy volume (bid >= p) shrinks
+	sellCum, buyBelow := 0.0, 0.0
+	askIdx, bidIdx := 0, len(bids)-1
+	found := false
+	for i, price := range candidates {
+		if i > 0 && price == candidates[i-1] {
+			continue
+		}
+		for askIdx < len(asks) && asks[askIdx][0] <= price {
+			sellCum += asks[askIdx][1]
+			askIdx++
+		}
+		for bidIdx >= 0 && bids[bidIdx][0] < price {
+			buyBelow += bids[bidIdx][1]
+			bidIdx--
+		}
+		buyCum := totalBuy - buyBelow
+
+		volume := math.Min(buyCum, sellCum)
+		if volume <= 0 {
+			continue
+		}
+		candidate := AuctionResult{Symbol: ob.symbol, Price: price, Volume: volume, Imbalance: buyCum - sellCum}
+		if !found || ob.better(candidate, result) {
+			result, found = candidate, true
+		}
+	}
+
+	return result, found
+}
+
+// better whether auction candidate a beats b, candidates arrive in ascending price
+func (ob *OrderBook) better(a, b AuctionResult) bool {
+	if a.Volume != b.Volume {
+		return a.Volume > b.Volume
+	}
+	if imbA, imbB := math.Abs(a.Imbalance), math.Abs(b.Imbalance); imbA != imbB {
+		return imbA < imbB
+	}
+	if ob.referencePrice > 0 {
+		distA, distB := math.Abs(a.Price-ob.referencePrice), math.Abs(b.Price-ob.referencePrice)
+		if distA != distB {
+			return distA < distB
+		}
+	}
+	return a.Price < b.Price
+}
+
+// uncross execute every crossing order at the equilibrium price, no lock
+func (ob *OrderBook) uncross() []Trade {
+	result, ok := ob.equilibrium()
+	if !ok {
+		return nil
+	}
+
+	// price-time priority on each side, both sweeps fill exactly result.Volume
+	buyFills, _ := ob.bids.Match(result.Price, result.Volume)
+	sellFills, _ := ob.asks.Match(result.Price, result.Volume)
+
+	var trades []Trade
+	buyLeft, sellLeft := 0.0, 0.0
+	for i, j := 0, 0; i < len(buyFills) && j < len(sellFills); {
+		buy, sell := buyFills[i], sellFills[j]
+		if buyLeft == 0 {
+			buyLeft = buy.Size
+		}
+		if sellLeft == 0 {
+			sellLeft = sell.Size
+		}
+
+		size := math.Min(buyLeft, sellLeft)
+		buyLeft -= size
+		sellLeft -= size
+
+		trades = append(trades, Trade{
+			Symbol:        ob.symbol,
+			Price:         result.Price,
+			Size:          size,
+			BuyOrderID:    buy.MakerOrderID,
+			BuyUserID:     buy.MakerUserID,
+			SellOrderID:   sell.MakerOrderID,
+			SellUserID:    sell.MakerUserID,
+			BuyRemaining:  buy.MakerRemaining + buyLeft,
+			SellRemaining: sell.MakerRemaining + sellLeft,
+		})
+
+		if buyLeft <= 0 {
+			buyLeft = 0
+			i++
+		}
+		if sellLeft <= 0 {
+			sellLeft = 0
+			j++
+		}
+	}
+
+	ob.referencePrice = result.Price
+	return trades
+}
+
+// ========================================================
+
+// BookSnapshot (訂單簿快照) resting orders of both sides in priority order and the matching state
+type BookSnapshot struct {
+	Symbol         string       `json:"symbol"`
+	Mode           MatchingMode `json:"mode"`
+	ReferencePrice float64      `json:"reference_price"`
+	Bids           []Order      `json:"bids"` // best price first, time priority within a price
+	Asks           []Order      `json:"asks"`
+}
+
+// Snapshot copy of both sides taken under the book lock
+func (ob *OrderBook) Snapshot() BookSnapshot {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	return BookSnapshot{
+		Symbol:         ob.symbol,
+		Mode:           ob.mode,
+		ReferencePrice: ob.referencePrice,
+		Bids:           ob.bids.Orders(),
+		Asks:           ob.asks.Orders(),
+	}
+}
+
+// Restore rest the orders of snapshot into an empty book in their priority order, without matching
+// or the order guard
+func (ob *OrderBook) Restore(snapshot BookSnapshot) error {
+	if snapshot.Symbol != ob.symbol {
+		return fmt.Errorf("snapshot of %s can not be restored into the %s book", snapshot.Symbol, ob.symbol)
+	}
+	if snapshot.Mode != MatchingContinuous && snapshot.Mode != MatchingAuction {
+		return fmt.Errorf("unknown matching mode %d", snapshot.Mode)
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.bids.Len() > 0 || ob.asks.Len() > 0 {
+		return fmt.Errorf("order book %s is not empty", ob.symbol)
+	}
+	for _, orders := range [][]Order{snapshot.Bids, snapshot.Asks} {
+		for _, order := range orders {
+			own := ob.bids
+			if order.Side == SELL {
+				own = ob.asks
+			}
+			if err := own.AddOrder(&order); err != nil {
+				ob.bids.CancelAll()
+				ob.asks.CancelAll()
+				return fmt.Errorf("restore order %s: %w", order.ID, err)
+			}
+		}
+	}
+	ob.mode = snapshot.Mode
+	ob.referencePrice = snapshot.ReferencePrice
+	return nil
+}
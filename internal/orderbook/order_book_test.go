@@ -0,0 +1,187 @@
+package orderbook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func place(t *testing.T, ob *OrderBook, id string, side Side, price, size float64) []Trade {
+	t.Helper()
+	trades, err := ob.PlaceOrder(&Order{ID: id, UserID: "u_" + id, Side: side, Price: price, Size: size})
+	require.NoError(t, err)
+	return trades
+}
+
+// tieBook every price 100..103 executes 6, 102 and 103 have the smaller imbalance
+func tieBook(t *testing.T) *OrderBook {
+	ob := NewOrderBook("BTCUSDT")
+	_, err := ob.SetMatchingMode(MatchingAuction)
+	require.NoError(t, err)
+
+	assert.Empty(t, place(t, ob, "b1", BUY, 103, 6))
+	assert.Empty(t, place(t, ob, "b2", BUY, 101, 4))
+	assert.Empty(t, place(t, ob, "s1", SELL, 100, 6))
+	assert.Empty(t, place(t, ob, "s2", SELL, 102, 3))
+	return ob
+}
+
+func TestAuctionTieBreakMinimalImbalance(t *testing.T) {
+	ob := tieBook(t)
+
+	result, ok := ob.IndicativePrice()
+	require.True(t, ok)
+	// 100, 101: imbalance +4 / 102, 103: imbalance -3 -> lower of 102, 103
+	assert.Equal(t, 102.0, result.Price)
+	assert.Equal(t, 6.0, result.Volume)
+	assert.Equal(t, -3.0, result.Imbalance)
+
+	// reference price breaks the remaining tie
+	ob.SetReferencePrice(110)
+	result, _ = ob.IndicativePrice()
+	assert.Equal(t, 103.0, result.Price)
+}
+
+func TestAuctionUncrossAtSinglePrice(t *testing.T) {
+	ob := tieBook(t)
+
+	trades, err := ob.SetMatchingMode(MatchingContinuous)
+	require.NoError(t, err)
+	require.Len(t, trades, 1)
+	assert.Equal(t, Trade{
+		Symbol: "BTCUSDT", Price: 102, Size: 6,
+		BuyOrderID: "b1", BuyUserID: "u_b1", SellOrderID: "s1", SellUserID: "u_s1",
+	}, trades[0])
+
+	// book no longer crosses
+	assert.Equal(t, [][2]float64{{101, 4}}, ob.Depth(BUY, 10))
+	assert.Equal(t, [][2]float64{{102, 3}}, ob.Depth(SELL, 10))
+	_, ok := ob.IndicativePrice()
+	assert.False(t, ok)
+
+	// continuous again: incoming order matches at the maker price
+	trades = place(t, ob, "b3", BUY, 105, 1)
+	require.Len(t, trades, 1)
+	assert.Equal(t, 102.0, trades[0].Price)
+	assert.Equal(t, 2.0, trades[0].SellRemaining)
+}
+
+func TestAuctionUncrossSplitsOrders(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	_, err := ob.SetMatchingMode(MatchingAuction)
+	require.NoError(t, err)
+
+	place(t, ob, "b1", BUY, 101, 3)
+	place(t, ob, "b2", BUY, 101, 2)
+	place(t, ob, "s1", SELL, 100, 4)
+
+	trades, err := ob.SetMatchingMode(MatchingContinuous)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+
+	assert.Equal(t, "b1", trades[0].BuyOrderID)
+	assert.Equal(t, 3.0, trades[0].Size)
+	assert.Equal(t, 0.0, trades[0].BuyRemaining)
+	assert.Equal(t, 1.0, trades[0].SellRemaining)
+
+	assert.Equal(t, "b2", trades[1].BuyOrderID)
+	assert.Equal(t, 1.0, trades[1].Size)
+	assert.Equal(t, 1.0, trades[1].BuyRemaining)
+	assert.Equal(t, 0.0, trades[1].SellRemaining)
+
+	for _, trade := range trades {
+		assert.Equal(t, trades[0].Price, trade.Price)
+	}
+	assert.Equal(t, [][2]float64{{101, 1}}, ob.Depth(BUY, 10))
+}
+
+func TestAuctionIndicativePriceEvents(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	var events []AuctionResult
+	ob.OnIndicativePrice(func(result AuctionResult) {
+		events = append(events, result)
+	})
+
+	// continuous mode does not emit
+	place(t, ob, "b0", BUY, 90, 1)
+	assert.Empty(t, events)
+
+	_, err := ob.SetMatchingMode(MatchingAuction)
+	require.NoError(t, err)
+
+	place(t, ob, "b1", BUY, 101, 2)
+	require.Len(t, events, 1)
+	assert.Zero(t, events[0].Volume) // nothing crosses yet
+
+	place(t, ob, "s1", SELL, 100, 1)
+	require.Len(t, events, 2)
+	assert.Equal(t, AuctionResult{Symbol: "BTCUSDT", Price: 100, Volume: 1, Imbalance: 1}, events[1])
+
+	_, err = ob.CancelOrder(SELL, "s1")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Zero(t, events[2].Volume)
+}
+
+func TestOrderGuardAndCancelAll(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	place(t, ob, "b1", BUY, 99, 1)
+	place(t, ob, "b2", BUY, 100, 1)
+	place(t, ob, "a1", SELL, 101, 1)
+
+	ob.SetOrderGuard(func(order *Order) error {
+		if !order.ReduceOnly {
+			return assert.AnError
+		}
+		return nil
+	})
+	_, err := ob.PlaceOrder(&Order{ID: "b3", Side: BUY, Price: 98, Size: 1})
+	assert.ErrorIs(t, err, assert.AnError)
+	_, err = ob.PlaceIOC(&Order{ID: "b4", Side: BUY, Price: 101, Size: 1})
+	assert.ErrorIs(t, err, assert.AnError)
+	_, err = ob.PlaceOrder(&Order{ID: "b5", Side: BUY, Price: 98, Size: 1, ReduceOnly: true})
+	assert.NoError(t, err)
+
+	cancelled := ob.CancelAll()
+	ids := make([]string, len(cancelled))
+	for i, order := range cancelled {
+		ids[i] = order.ID
+	}
+	assert.Equal(t, []string{"b2", "b1", "b5", "a1"}, ids)
+	assert.Empty(t, ob.Depth(BUY, 10))
+	assert.Empty(t, ob.Depth(SELL, 10))
+
+	ob.SetOrderGuard(nil)
+	place(t, ob, "b6", BUY, 100, 1)
+	_, err = ob.CancelOrder(BUY, "b1")
+	assert.Error(t, err)
+}
+
+func TestSnapshotRestoreKeepsPriority(t *testing.T) {
+	ob := NewOrderBook("BTCUSDT")
+	place(t, ob, "b1", BUY, 100, 1)
+	place(t, ob, "b2", BUY, 101, 2)
+	place(t, ob, "b3", BUY, 101, 3)
+	place(t, ob, "s1", SELL, 105, 4)
+	ob.SetReferencePrice(103)
+
+	snapshot := ob.Snapshot()
+	require.Len(t, snapshot.Bids, 3)
+	assert.Equal(t, []string{"b2", "b3", "b1"}, []string{snapshot.Bids[0].ID, snapshot.Bids[1].ID, snapshot.Bids[2].ID})
+	require.Len(t, snapshot.Asks, 1)
+
+	restored := NewOrderBook("BTCUSDT")
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, ob.Depth(BUY, 5), restored.Depth(BUY, 5))
+	assert.Equal(t, ob.Depth(SELL, 5), restored.Depth(SELL, 5))
+	assert.Equal(t, snapshot, restored.Snapshot())
+
+	// time priority survives: b2 fills before b3
+	trades := place(t, restored, "s2", SELL, 101, 2)
+	require.Len(t, trades, 1)
+	assert.Equal(t, "b2", trades[0].BuyOrderID)
+
+	assert.Error(t, restored.Restore(snapshot), "book not empty")
+	assert.Error(t, NewOrderBook("ETHUSDT").Restore(snapshot), "other symbol")
+}
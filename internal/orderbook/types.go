@@ -0,0 +1,50 @@
+package orderbook
+
+import "time"
+
+// Side BUY or SELL
+type Side int
+
+const (
+	BUY  Side = 1
+	SELL Side = -1
+)
+
+func (s Side) String() string {
+	switch s {
+	case BUY:
+		return "buy"
+	case SELL:
+		return "sell"
+	default:
+		return "unknown"
+	}
+}
+
+// ========================================================
+
+// Order resting order (掛單)
+type Order struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Side      Side      `json:"side"`
+	Price     float64   `json:"price"`
+	Size      float64   `json:"size"` // 剩餘未成交數量
+	Timestamp time.Time `json:"timestamp"`
+	Simulated bool      `json:"simulated"` // paper trading order
+
+	ReduceOnly bool  `json:"reduce_only"`        // only reduces the owner's position, set by the order entry
+	Leverage   int16 `json:"leverage,omitempty"` // of the positions its fills open, set by the order entry
+}
+
+// ========================================================
+
+// Fill one maker order filled by a taker sweep (成交)
+type Fill struct {
+	MakerOrderID string  `json:"maker_order_id"`
+	MakerUserID  string  `json:"maker_user_id"`
+	Price        float64 `json:"price"`
+	Size         float64 `json:"size"`
+
+	MakerRemaining float64 `json:"maker_remaining"` // maker size left after this fill
+}
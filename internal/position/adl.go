@@ -0,0 +1,53 @@
+package position
+
+import (
+	"sort"
+)
+
+// ADLCandidate (自動減倉候選) profitable position ranked by ADL score
+type ADLCandidate struct {
+	Position      *Position    `json:"-"`
+	PositionID    string       `json:"position_id"`
+	UserID        string       `json:"user_id"`
+	Side          PositionSide `json:"side"`
+	Size          float64      `json:"size"`
+	UnrealizedPnL float64      `json:"unrealized_pnl"`
+	Leverage      int16        `json:"leverage"`
+	Score         float64      `json:"score"` // PnL% × leverage
+}
+
+// GetADLQueue (ADL 排序) profitable normal positions of one side, highest score first.
+// score = ROI × leverage, ties go to the larger position.
+func (pm *PositionManager) GetADLQueue(symbol string, side PositionSide) ([]ADLCandidate, error) {
+	positions, err := pm.symbolPositions.GetPositions(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := make([]ADLCandidate, 0)
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Side != side || snapshot.Status != PositionNormal || snapshot.Size <= 0 ||
+			snapshot.InitialMargin <= 0 || snapshot.UnrealizedPnL <= 0 {
+			continue
+		}
+		queue = append(queue, ADLCandidate{
+			Position:      pos,
+			PositionID:    snapshot.ID,
+			UserID:        snapshot.UserID,
+			Side:          snapshot.Side,
+			Size:          snapshot.Size,
+			UnrealizedPnL: snapshot.UnrealizedPnL,
+			Leverage:      snapshot.Leverage,
+			Score:         snapshot.UnrealizedPnL / snapshot.InitialMargin * float64(snapshot.Leverage),
+		})
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].Score != queue[j].Score {
+			return queue[i].Score > queue[j].Score
+		}
+		return queue[i].Size > queue[j].Size
+	})
+	return queue, nil
+}
@@ -0,0 +1,134 @@
+package position
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AgeCategory bucket of position age (持倉時間)
+type AgeCategory string
+
+const (
+	AgeIntraday   AgeCategory = "intraday"    // < 1 day
+	AgeShortTerm  AgeCategory = "short-term"  // < 7 days
+	AgeMediumTerm AgeCategory = "medium-term" // < 30 days
+	AgeLongTerm   AgeCategory = "long-term"   // >= 30 days
+)
+
+// ageCategoryOf bucket by time since open
+func ageCategoryOf(age time.Duration) AgeCategory {
+	switch {
+	case age < 24*time.Hour:
+		return AgeIntraday
+	case age < 7*24*time.Hour:
+		return AgeShortTerm
+	case age < 30*24*time.Hour:
+		return AgeMediumTerm
+	default:
+		return AgeLongTerm
+	}
+}
+
+// GetPositionAgeDistribution count of open positions per age category, every category is present
+func (pm *PositionManager) GetPositionAgeDistribution() map[string]int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	distribution := map[string]int{
+		string(AgeIntraday):   0,
+		string(AgeShortTerm):  0,
+		string(AgeMediumTerm): 0,
+		string(AgeLongTerm):   0,
+	}
+
+	now := time.Now()
+	for _, userPositions := range pm.userPositions {
+		for _, pos := range userPositions {
+			snapshot := pos.Snapshot()
+			if snapshot.Status == PositionClosed || snapshot.Size <= 0 {
+				continue
+			}
+			distribution[string(ageCategoryOf(now.Sub(snapshot.OpenTime)))]++
+		}
+	}
+	return distribution
+}
+
+// ========================================================
+
+// stallWatcher remembers which touch of each position was already alerted
+type stallWatcher struct {
+	maxAge  time.Duration
+	fn      func([]*Position)
+	alerted map[string]time.Time // positionID -> touchedAt when alerted
+	mu      sync.Mutex
+}
+
+// AlertOnStalledPositions call fn from a background goroutine with open positions untouched
+// (no mark price update, add or reduce) for longer than maxAge. each position is alerted once,
+// touching it re-arms the alert. stops on Close.
+func (pm *PositionManager) AlertOnStalledPositions(maxAge time.Duration, fn func([]*Position)) error {
+	watcher := &stallWatcher{
+		maxAge:  maxAge,
+		fn:      fn,
+		alerted: make(map[string]time.Time),
+	}
+	interval := min(max(maxAge/2, time.Millisecond), time.Minute)
+
+	return pm.runBackground(func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case now := <-ticker.C:
+				pm.checkStalledPositions(watcher, now)
+			}
+		}
+	})
+}
+
+// checkStalledPositions one scan of the watcher at now
+func (pm *PositionManager) checkStalledPositions(watcher *stallWatcher, now time.Time) {
+	pm.mu.RLock()
+	var stalled []*Position
+	seen := make(map[string]bool)
+
+	watcher.mu.Lock()
+	for _, userPositions := range pm.userPositions {
+		for _, pos := range userPositions {
+			pos.mu.RLock()
+			open := pos.Status != PositionClosed && pos.Size > pos.ZeroSize()
+			touchedAt := pos.touchedAt
+			pos.mu.RUnlock()
+
+			if !open {
+				continue
+			}
+			seen[pos.ID] = true
+			if now.Sub(touchedAt) <= watcher.maxAge {
+				continue
+			}
+			if alertedAt, ok := watcher.alerted[pos.ID]; ok && alertedAt.Equal(touchedAt) {
+				continue // already alerted for this touch
+			}
+			watcher.alerted[pos.ID] = touchedAt
+			stalled = append(stalled, pos)
+		}
+	}
+	// forget closed positions
+	for positionID := range watcher.alerted {
+		if !seen[positionID] {
+			delete(watcher.alerted, positionID)
+		}
+	}
+	watcher.mu.Unlock()
+	pm.mu.RUnlock()
+
+	if len(stalled) > 0 {
+		watcher.fn(stalled)
+	}
+}
@@ -0,0 +1,70 @@
+package position
+
+import "time"
+
+// MaxAuditLogEntries position audit entries kept in memory, the oldest are dropped beyond it
+const MaxAuditLogEntries = 1000
+
+// position audit operations
+const (
+	AuditOpen      = "open"
+	AuditAdd       = "add"
+	AuditReduce    = "reduce"
+	AuditClose     = "close"
+	AuditLiquidate = "liquidate"
+	AuditRecover   = "recover"
+)
+
+// PositionAuditEntry (倉位稽核) one position operation, kept in process for debugging.
+// Size and Price are those of the operation, Status the position's status after it
+type PositionAuditEntry struct {
+	Timestamp  time.Time    `json:"timestamp"`
+	UserID     string       `json:"user_id"`
+	PositionID string       `json:"position_id"`
+	Operation  string       `json:"operation"`
+	Side       PositionSide `json:"side"`
+	Size       float64      `json:"size"`
+	Price      float64      `json:"price"`
+	Leverage   int16        `json:"leverage"`
+	Status     string       `json:"status"`
+}
+
+// AuditLog the last MaxAuditLogEntries operations, oldest first
+func (pm *PositionManager) AuditLog() []PositionAuditEntry {
+	pm.auditMu.Lock()
+	defer pm.auditMu.Unlock()
+	return pm.auditLog.Items()
+}
+
+// GetRecentAuditLog up to limit operations, newest first. limit <= 0 returns every kept entry
+func (pm *PositionManager) GetRecentAuditLog(limit int) []PositionAuditEntry {
+	pm.auditMu.Lock()
+	defer pm.auditMu.Unlock()
+	return pm.auditLog.Recent(limit)
+}
+
+// audit record the operation and publish its lifecycle event
+func (pm *PositionManager) audit(position *Position, operation string, size, price float64) {
+	snapshot := position.Snapshot()
+	now := time.Now()
+	if event, ok := lifecycleEvent(snapshot, operation, size, price, now); ok {
+		pm.events.Publish(event)
+	}
+
+	entry := PositionAuditEntry{
+		Timestamp:  now,
+		UserID:     snapshot.UserID,
+		PositionID: snapshot.ID,
+		Operation:  operation,
+		Side:       snapshot.Side,
+		Size:       size,
+		Price:      price,
+		Leverage:   snapshot.Leverage,
+		Status:     snapshot.Status.String(),
+	}
+
+	pm.auditMu.Lock()
+	pm.auditLog.Add(entry)
+	pm.auditMu.Unlock()
+	pm.journalEntry(entry)
+}
@@ -0,0 +1,61 @@
+package position
+
+// ConsolidatedPosition (合併倉位) long and short legs of one symbol shown as a single effective position
+type ConsolidatedPosition struct {
+	Symbol             string       `json:"symbol"`
+	NetSize            float64      `json:"net_size"`             // long - short
+	NetSide            PositionSide `json:"net_side"`             // 0 when flat
+	WeightedEntryPrice float64      `json:"weighted_entry_price"` // entry of the legs weighted by size
+	TotalUnrealizedPnL float64      `json:"total_unrealized_pnl"`
+	TotalInitialMargin float64      `json:"total_initial_margin"`
+
+	LongLeg  *PositionSnapshot `json:"long_leg,omitempty"`
+	ShortLeg *PositionSnapshot `json:"short_leg,omitempty"`
+}
+
+// GetConsolidatedPositions combine the open legs of the user's symbol, in one-way mode it wraps the single
+// position. an unknown user or symbol gives an empty (flat) position
+func (pm *PositionManager) GetConsolidatedPositions(userID, symbol string) ConsolidatedPosition {
+	consolidated := ConsolidatedPosition{Symbol: symbol}
+
+	pm.mu.RLock()
+	var legs []*Position
+	for _, pos := range pm.userPositions[userID] {
+		if pos.Symbol == symbol {
+			legs = append(legs, pos)
+		}
+	}
+	pm.mu.RUnlock()
+
+	entryValue, totalSize := 0.0, 0.0
+	for _, pos := range legs {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == PositionClosed || snapshot.Size <= 0 {
+			continue
+		}
+
+		leg := snapshot
+		if snapshot.Side == LONG {
+			consolidated.LongLeg = &leg
+			consolidated.NetSize += snapshot.Size
+		} else {
+			consolidated.ShortLeg = &leg
+			consolidated.NetSize -= snapshot.Size
+		}
+		entryValue += snapshot.EntryPrice * snapshot.Size
+		totalSize += snapshot.Size
+		consolidated.TotalUnrealizedPnL += snapshot.UnrealizedPnL
+		consolidated.TotalInitialMargin += snapshot.InitialMargin
+	}
+
+	if totalSize > 0 {
+		consolidated.WeightedEntryPrice = entryValue / totalSize
+	}
+	switch {
+	case consolidated.NetSize > 0:
+		consolidated.NetSide = LONG
+	case consolidated.NetSize < 0:
+		consolidated.NetSide = SHORT
+	}
+	return consolidated
+}
@@ -4,6 +4,9 @@ import (
 	"math"
 )
 
+// DefaultDepositSafetyFactor target equity = 120% of maintenance margin
+const DefaultDepositSafetyFactor = 1.2
+
 var DefaultMarginTiers = []MarginTier{
 	{0, 50000, 0.004, 125},           // 0.4% for userPositions < 50k USDT
 	{50000, 250000, 0.005, 100},      // 0.5% for 50k-250k
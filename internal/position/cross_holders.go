@@ -0,0 +1,47 @@
+package position
+
+import (
+	"frizo/futures_engine/internal/common"
+	"sort"
+)
+
+// addHolder index the user under the symbol, pm.mu held
+func (pm *PositionManager) addHolder(symbol, userID string) {
+	if pm.holders[symbol] == nil {
+		pm.holders[symbol] = make(map[string]struct{})
+	}
+	pm.holders[symbol][userID] = struct{}{}
+}
+
+// CrossUsers (全倉持倉用戶) users holding an open cross position in the symbol, sorted. the symbol index
+// only grows on open, users without an open position in the symbol anymore are pruned here.
+// the margin mode is read per call, so positions switched to cross are found too
+func (pm *PositionManager) CrossUsers(symbol string) []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var users []string
+	for userID := range pm.holders[symbol] {
+		holding, cross := false, false
+		for _, pos := range pm.userPositions[userID] {
+			if pos.Symbol != symbol {
+				continue
+			}
+			snapshot := pos.Snapshot()
+			if snapshot.Status == PositionClosed || snapshot.Size <= pos.ZeroSize() {
+				continue
+			}
+			holding = true
+			cross = cross || snapshot.MarginMode == common.CROSS
+		}
+		if !holding {
+			delete(pm.holders[symbol], userID)
+			continue
+		}
+		if cross {
+			users = append(users, userID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
@@ -0,0 +1,123 @@
+package position
+
+import "frizo/futures_engine/internal/logger"
+
+// PositionEventHandler (事件處理器) typed callbacks of the position event stream, one per event type.
+// embed NoopPositionEventHandler to implement only the ones needed
+type PositionEventHandler interface {
+	HandlePositionOpened(event *PositionOpenedEvent) error
+	HandlePositionIncreased(event *PositionIncreasedEvent) error
+	HandlePositionReduced(event *PositionReducedEvent) error
+	HandlePositionClosed(event *PositionClosedEvent) error
+	HandlePositionLiquidated(event *PositionLiquidatedEvent) error
+	HandlePreLiquidationWarning(event *PreLiquidationWarning) error
+	HandlePositionExpired(event *PositionExpiredEvent) error
+}
+
+// NoopPositionEventHandler ignores every event
+type NoopPositionEventHandler struct{}
+
+func (NoopPositionEventHandler) HandlePositionOpened(*PositionOpenedEvent) error          { return nil }
+func (NoopPositionEventHandler) HandlePositionIncreased(*PositionIncreasedEvent) error    { return nil }
+func (NoopPositionEventHandler) HandlePositionReduced(*PositionReducedEvent) error        { return nil }
+func (NoopPositionEventHandler) HandlePositionClosed(*PositionClosedEvent) error          { return nil }
+func (NoopPositionEventHandler) HandlePositionLiquidated(*PositionLiquidatedEvent) error  { return nil }
+func (NoopPositionEventHandler) HandlePreLiquidationWarning(*PreLiquidationWarning) error { return nil }
+func (NoopPositionEventHandler) HandlePositionExpired(*PositionExpiredEvent) error        { return nil }
+
+// SubscribeHandler dispatch the events of eventCh (from SubscribeEvents) to handler on a new goroutine,
+// until eventCh is closed. handler errors are logged and do not stop the dispatch.
+// the returned channel is closed once the last event was handled
+func (pm *PositionManager) SubscribeHandler(eventCh <-chan PositionEvent, handler PositionEventHandler) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range eventCh {
+			if err := DispatchPositionEvent(event, handler); err != nil {
+				logger.Default().Warn("position event handler failed",
+					"type", event.Type, "position_id", event.PositionID, "error", err)
+			}
+		}
+	}()
+	return done
+}
+
+// DispatchPositionEvent call the handler method matching the event's type, nil for events without a payload
+func DispatchPositionEvent(event PositionEvent, handler PositionEventHandler) error {
+	switch {
+	case event.Opened != nil:
+		return handler.HandlePositionOpened(event.Opened)
+	case event.Increased != nil:
+		return handler.HandlePositionIncreased(event.Increased)
+	case event.Reduced != nil:
+		return handler.HandlePositionReduced(event.Reduced)
+	case event.Closed != nil:
+		return handler.HandlePositionClosed(event.Closed)
+	case event.Liquidated != nil:
+		return handler.HandlePositionLiquidated(event.Liquidated)
+	case event.PreLiquidation != nil:
+		return handler.HandlePreLiquidationWarning(event.PreLiquidation)
+	case event.Expired != nil:
+		return handler.HandlePositionExpired(event.Expired)
+	}
+	return nil
+}
+
+// AuditLogger PositionEventHandler writing every event as one structured line of the audit log
+type AuditLogger struct {
+	log *logger.Logger
+}
+
+// NewAuditLogger log nil means logger.Default()
+func NewAuditLogger(log *logger.Logger) *AuditLogger {
+	if log == nil {
+		log = logger.Default()
+	}
+	return &AuditLogger{log: log.WithFields(map[string]interface{}{"component": "position_audit"})}
+}
+
+func (a *AuditLogger) change(event PositionEventType, change PositionChange, args ...any) {
+	args = append([]any{"position_id", change.PositionID, "user_id", change.UserID, "symbol", change.Symbol,
+		"side", change.Side.String(), "price", change.Price, "size", change.Size}, args...)
+	a.log.Info(string(event), args...)
+}
+
+func (a *AuditLogger) HandlePositionOpened(event *PositionOpenedEvent) error {
+	a.change(EventPositionOpened, event.PositionChange, "leverage", event.Leverage)
+	return nil
+}
+
+func (a *AuditLogger) HandlePositionIncreased(event *PositionIncreasedEvent) error {
+	a.change(EventPositionIncreased, event.PositionChange, "entry_price", event.EntryPrice, "total_size", event.TotalSize)
+	return nil
+}
+
+func (a *AuditLogger) HandlePositionReduced(event *PositionReducedEvent) error {
+	a.change(EventPositionReduced, event.PositionChange, "remaining_size", event.RemainingSize, "realized_pnl", event.RealizedPnL)
+	return nil
+}
+
+func (a *AuditLogger) HandlePositionClosed(event *PositionClosedEvent) error {
+	a.change(EventPositionClosed, event.PositionChange, "realized_pnl", event.RealizedPnL)
+	return nil
+}
+
+func (a *AuditLogger) HandlePositionLiquidated(event *PositionLiquidatedEvent) error {
+	a.log.Warn(string(EventPositionLiquidated), "position_id", event.PositionID, "user_id", event.UserID,
+		"symbol", event.Symbol, "side", event.Side.String(), "price", event.Price, "size", event.Size,
+		"remaining_size", event.RemainingSize)
+	return nil
+}
+
+func (a *AuditLogger) HandlePreLiquidationWarning(event *PreLiquidationWarning) error {
+	a.log.Warn(string(EventPreLiquidationWarning), "position_id", event.PositionID, "user_id", event.UserID,
+		"symbol", event.Symbol, "side", event.Side.String(), "mark_price", event.MarkPrice,
+		"liquidation_price", event.LiquidationPrice, "margin_ratio", event.MarginRatio, "top_up", event.TopUp)
+	return nil
+}
+
+func (a *AuditLogger) HandlePositionExpired(event *PositionExpiredEvent) error {
+	a.log.Info(string(EventPositionExpired), "position_id", event.PositionID, "user_id", event.UserID,
+		"symbol", event.Symbol, "side", event.Side.String(), "size", event.Size, "close_price", event.ClosePrice)
+	return nil
+}
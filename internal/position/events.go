@@ -0,0 +1,189 @@
+package position
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PositionEventType kind of PositionEvent
+type PositionEventType string
+
+const (
+	EventPositionOpened        PositionEventType = "position_opened"
+	EventPositionIncreased     PositionEventType = "position_increased"
+	EventPositionReduced       PositionEventType = "position_reduced"
+	EventPositionClosed        PositionEventType = "position_closed"
+	EventPositionLiquidated    PositionEventType = "position_liquidated"
+	EventPreLiquidationWarning PositionEventType = "pre_liquidation_warning"
+)
+
+// PositionEvent (倉位事件) one entry of the position event stream, the payload matching Type is set
+type PositionEvent struct {
+	Type       PositionEventType `json:"type"`
+	UserID     string            `json:"user_id"`
+	PositionID string            `json:"position_id"`
+	Symbol     string            `json:"symbol"`
+	Timestamp  time.Time         `json:"timestamp"`
+
+	Opened         *PositionOpenedEvent     `json:"opened,omitempty"`
+	Increased      *PositionIncreasedEvent  `json:"increased,omitempty"`
+	Reduced        *PositionReducedEvent    `json:"reduced,omitempty"`
+	Closed         *PositionClosedEvent     `json:"closed,omitempty"`
+	Liquidated     *PositionLiquidatedEvent `json:"liquidated,omitempty"`
+	PreLiquidation *PreLiquidationWarning   `json:"pre_liquidation,omitempty"`
+	Expired        *PositionExpiredEvent    `json:"expired,omitempty"`
+}
+
+// PositionChange what every lifecycle payload carries: the position and the price and size of the operation
+type PositionChange struct {
+	PositionID string       `json:"position_id"`
+	UserID     string       `json:"user_id"`
+	Symbol     string       `json:"symbol"`
+	Side       PositionSide `json:"side"`
+	Price      float64      `json:"price"`
+	Size       float64      `json:"size"`
+	Timestamp  time.Time    `json:"timestamp"`
+}
+
+// PositionOpenedEvent payload of EventPositionOpened
+type PositionOpenedEvent struct {
+	PositionChange
+	Leverage int16 `json:"leverage"`
+}
+
+// PositionIncreasedEvent payload of EventPositionIncreased, EntryPrice and TotalSize after the add
+type PositionIncreasedEvent struct {
+	PositionChange
+	EntryPrice float64 `json:"entry_price"`
+	TotalSize  float64 `json:"total_size"`
+}
+
+// PositionReducedEvent payload of EventPositionReduced, a reduce leaving the position open
+type PositionReducedEvent struct {
+	PositionChange
+	RemainingSize float64 `json:"remaining_size"`
+	RealizedPnL   float64 `json:"realized_pnl"` // of the position so far
+}
+
+// PositionClosedEvent payload of EventPositionClosed, a close or a reduce of the whole size
+type PositionClosedEvent struct {
+	PositionChange
+	RealizedPnL float64 `json:"realized_pnl"` // of the position over its life
+}
+
+// PositionLiquidatedEvent payload of EventPositionLiquidated, one liquidation fill
+type PositionLiquidatedEvent struct {
+	PositionChange
+	RemainingSize float64 `json:"remaining_size"`
+}
+
+// lifecycleEvent event of an audited operation, false for operations without one (recover)
+func lifecycleEvent(snapshot PositionSnapshot, operation string, size, price float64, now time.Time) (PositionEvent, bool) {
+	event := PositionEvent{UserID: snapshot.UserID, PositionID: snapshot.ID, Symbol: snapshot.Symbol, Timestamp: now}
+	change := PositionChange{
+		PositionID: snapshot.ID,
+		UserID:     snapshot.UserID,
+		Symbol:     snapshot.Symbol,
+		Side:       snapshot.Side,
+		Price:      price,
+		Size:       size,
+		Timestamp:  now,
+	}
+
+	switch {
+	case operation == AuditOpen:
+		event.Type = EventPositionOpened
+		event.Opened = &PositionOpenedEvent{PositionChange: change, Leverage: snapshot.Leverage}
+	case operation == AuditAdd:
+		event.Type = EventPositionIncreased
+		event.Increased = &PositionIncreasedEvent{PositionChange: change, EntryPrice: snapshot.EntryPrice, TotalSize: snapshot.Size}
+	case operation == AuditLiquidate:
+		event.Type = EventPositionLiquidated
+		event.Liquidated = &PositionLiquidatedEvent{PositionChange: change, RemainingSize: snapshot.Size}
+	case operation == AuditClose, operation == AuditReduce && snapshot.Status == PositionClosed:
+		event.Type = EventPositionClosed
+		event.Closed = &PositionClosedEvent{PositionChange: change, RealizedPnL: snapshot.RealizedPnL}
+	case operation == AuditReduce:
+		event.Type = EventPositionReduced
+		event.Reduced = &PositionReducedEvent{PositionChange: change, RemainingSize: snapshot.Size, RealizedPnL: snapshot.RealizedPnL}
+	default:
+		return event, false
+	}
+	return event, true
+}
+
+// EventBus (倉位事件匯流排) fan-out of the position events of one or more managers (WithEventBus).
+// Publish must never block the publisher, managers publish under their own locks
+type EventBus interface {
+	Publish(event PositionEvent)
+	// Subscribe receive events until cancel is called, which closes the channel
+	Subscribe(buffer int) (<-chan PositionEvent, func())
+	// Dropped events lost to full subscribers
+	Dropped() int64
+}
+
+// NewEventBus the in-process bus of every manager: a full subscriber misses the event
+func NewEventBus() EventBus {
+	return newEventBus()
+}
+
+// eventBus fan-out to subscribers, never blocks the publisher: a full subscriber misses the event
+type eventBus struct {
+	subscribers map[int]chan PositionEvent
+	nextID      int
+	dropped     atomic.Int64
+	mu          sync.RWMutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[int]chan PositionEvent)}
+}
+
+func (b *eventBus) Subscribe(buffer int) (<-chan PositionEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan PositionEvent, max(buffer, 1))
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+func (b *eventBus) Publish(event PositionEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+func (b *eventBus) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// SubscribeEvents (倉位事件流) receive position events until cancel is called, which closes the channel.
+// publishing never waits: events beyond buffer are dropped for this subscriber (DroppedEvents)
+func (pm *PositionManager) SubscribeEvents(buffer int) (<-chan PositionEvent, func()) {
+	return pm.events.Subscribe(buffer)
+}
+
+// DroppedEvents events lost to full subscriber buffers
+func (pm *PositionManager) DroppedEvents() int64 {
+	return pm.events.Dropped()
+}
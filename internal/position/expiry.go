@@ -0,0 +1,153 @@
+package position
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	EventPositionExpired PositionEventType = "position_expired"
+)
+
+// PositionInfo read-only view of a position returned by queries
+type PositionInfo = PositionSnapshot
+
+// PositionExpiredEvent (到期交割) payload of EventPositionExpired, published before the position is closed
+type PositionExpiredEvent struct {
+	PositionID string       `json:"position_id"`
+	UserID     string       `json:"user_id"`
+	Symbol     string       `json:"symbol"`
+	Side       PositionSide `json:"side"`
+	Size       float64      `json:"size"`
+	ExpiryTime time.Time    `json:"expiry_time"`
+	ClosePrice float64      `json:"close_price"` // settlement price, or the mark price without one
+}
+
+// ExpirySettler closes an expired position with account settlement, registered by the margin system.
+// without one CheckExpiry closes through ClosePosition, positions only
+type ExpirySettler func(userID, symbol string, side PositionSide, price, size float64) error
+
+// IsExpired the position has an expiry time and now is past it
+func (p *Position) IsExpired(now time.Time) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.ExpiryTime.IsZero() && now.After(p.ExpiryTime)
+}
+
+// SetExpiry set the time the position is closed at by CheckExpiry, zero means no expiry
+func (p *Position) SetExpiry(expiry time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ExpiryTime = expiry
+	p.UpdateTime = time.Now()
+}
+
+// SetSettlementPrice set the price the position is closed at on expiry (交割價), 0 means the mark price
+func (p *Position) SetSettlementPrice(price float64) error {
+	if price < 0 {
+		return fmt.Errorf("settlement price must not be negative")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.SettlementPrice = price
+	p.UpdateTime = time.Now()
+	return nil
+}
+
+// SetExpirySettler register the settlement used by CheckExpiry,
+// lets the margin system plug in without a circular import
+func (pm *PositionManager) SetExpirySettler(fn ExpirySettler) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.expirySettler = fn
+}
+
+// CheckExpiry (到期檢查) close every open position past its expiry time at its settlement price,
+// or the mark price without one. EventPositionExpired is published before each close.
+// return the expired positions that were closed, and the errors of those that could not be
+func (pm *PositionManager) CheckExpiry() ([]*Position, error) {
+	now := time.Now()
+
+	pm.mu.RLock()
+	settle := pm.expirySettler
+	var expired []*Position
+	for _, userPositions := range pm.userPositions {
+		for _, pos := range userPositions {
+			if pos.IsExpired(now) {
+				expired = append(expired, pos)
+			}
+		}
+	}
+	pm.mu.RUnlock()
+
+	var closed []*Position
+	var errs []error
+	for _, pos := range expired {
+		snapshot := pos.Snapshot()
+		if snapshot.Status != PositionNormal || snapshot.Size <= 0 {
+			continue // liquidation owns it, or already gone
+		}
+		price := snapshot.SettlementPrice
+		if price <= 0 {
+			price = snapshot.MarkPrice
+		}
+
+		pm.events.Publish(PositionEvent{
+			Type:       EventPositionExpired,
+			UserID:     snapshot.UserID,
+			PositionID: snapshot.ID,
+			Symbol:     snapshot.Symbol,
+			Timestamp:  now,
+			Expired: &PositionExpiredEvent{
+				PositionID: snapshot.ID,
+				UserID:     snapshot.UserID,
+				Symbol:     snapshot.Symbol,
+				Side:       snapshot.Side,
+				Size:       snapshot.Size,
+				ExpiryTime: snapshot.ExpiryTime,
+				ClosePrice: price,
+			},
+		})
+
+		var err error
+		if settle != nil {
+			err = settle(snapshot.UserID, snapshot.Symbol, snapshot.Side, price, snapshot.Size)
+		} else {
+			_, _, err = pm.ClosePosition(snapshot.UserID, snapshot.Symbol, snapshot.Side, price)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("close expired position %s: %w", snapshot.ID, err))
+			continue
+		}
+		closed = append(closed, pos)
+	}
+	return closed, errors.Join(errs...)
+}
+
+// GetExpiringWithin open positions of the symbol expiring within d from now (already expired ones included),
+// earliest expiry first
+func (pm *PositionManager) GetExpiringWithin(symbol string, d time.Duration) []*PositionInfo {
+	positions, err := pm.symbolPositions.GetPositions(symbol)
+	if err != nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(d)
+	var expiring []*PositionInfo
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == PositionClosed || snapshot.Size <= 0 || snapshot.ExpiryTime.IsZero() {
+			continue
+		}
+		if snapshot.ExpiryTime.After(deadline) {
+			continue
+		}
+		expiring = append(expiring, &snapshot)
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].ExpiryTime.Before(expiring[j].ExpiryTime)
+	})
+	return expiring
+}
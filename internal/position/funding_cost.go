@@ -0,0 +1,148 @@
+package position
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// FundingRateSchedule current funding rate and next settlement of a symbol, implemented by
+// funding.FundingScheduler. injected with SetFundingRateSchedule, position can not import funding
+type FundingRateSchedule interface {
+	CurrentFundingRate(symbol string) (float64, error)
+	NextSettlement(symbol string) time.Time
+}
+
+// FundingCostInfo (資金費預估) open position with the funding it pays or receives at the next settlement
+type FundingCostInfo struct {
+	Position             PositionSnapshot `json:"position"`
+	FundingRate          float64          `json:"funding_rate"`
+	EstimatedFundingCost float64          `json:"estimated_funding_cost"` // |rate| × position value
+	NextFundingAt        time.Time        `json:"next_funding_at"`
+}
+
+// SetFundingRateSchedule source of GetPositionsNearFundingTime
+func (pm *PositionManager) SetFundingRateSchedule(schedule FundingRateSchedule) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.fundingSchedule = schedule
+}
+
+// GetPositionsNearFundingTime open positions of the symbol when its next funding settlement is within
+// fundingIn, largest estimated funding cost first. empty when the settlement is further away
+func (pm *PositionManager) GetPositionsNearFundingTime(symbol string, fundingIn time.Duration) ([]FundingCostInfo, error) {
+	pm.mu.RLock()
+	schedule := pm.fundingSchedule
+	pm.mu.RUnlock()
+
+	if schedule == nil {
+		return nil, fmt.Errorf("no funding rate schedule")
+	}
+	positions, err := pm.symbolPositions.GetPositions(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	nextFundingAt := schedule.NextSettlement(symbol)
+	if time.Until(nextFundingAt) > fundingIn {
+		return nil, nil
+	}
+	rate, err := schedule.CurrentFundingRate(symbol)
+	if err != nil {
+		return nil, fmt.Errorf("funding rate of %s: %w", symbol, err)
+	}
+
+	infos := make([]FundingCostInfo, 0, len(positions))
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == PositionClosed || snapshot.Size <= pos.ZeroSize() {
+			continue
+		}
+		infos = append(infos, FundingCostInfo{
+			Position:             snapshot,
+			FundingRate:          rate,
+			EstimatedFundingCost: math.Abs(rate) * snapshot.PositionValue,
+			NextFundingAt:        nextFundingAt,
+		})
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].EstimatedFundingCost > infos[j].EstimatedFundingCost
+	})
+	return infos, nil
+}
+
+// FundingPreview (資金費預覽) estimated payment of one position at the next funding settlement
+type FundingPreview struct {
+	PositionID    string        `json:"position_id"`
+	Symbol        string        `json:"symbol"`
+	Side          PositionSide  `json:"side"`
+	PredictedRate float64       `json:"predicted_rate"`
+	PositionValue float64       `json:"position_value"` // size × mark price, entry price before the first mark
+	Payment       float64       `json:"payment"`        // positive is received, negative is paid
+	NextFundingAt time.Time     `json:"next_funding_at"`
+	TimeRemaining time.Duration `json:"time_remaining"`
+}
+
+// GetFundingPreview what the user's positions in the symbol pay or receive at the next settlement at the
+// predicted rate, same arithmetic as the settlement (rate > 0: longs pay shorts). nothing is mutated.
+// one item per open position (two in hedge mode, longs first), none for a flat user
+func (pm *PositionManager) GetFundingPreview(userID, symbol string) ([]FundingPreview, error) {
+	pm.mu.RLock()
+	schedule := pm.fundingSchedule
+	var positions []*Position
+	for _, pos := range pm.userPositions[userID] {
+		if pos.Symbol == symbol {
+			positions = append(positions, pos)
+		}
+	}
+	pm.mu.RUnlock()
+
+	if schedule == nil {
+		return nil, fmt.Errorf("no funding rate schedule")
+	}
+	if !pm.symbolPositions.HasSymbol(symbol) {
+		return nil, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+
+	var previews []FundingPreview
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if snapshot.Status == PositionClosed || snapshot.Size <= pos.ZeroSize() {
+			continue
+		}
+		price := snapshot.MarkPrice
+		if price <= 0 {
+			price = snapshot.EntryPrice
+		}
+		previews = append(previews, FundingPreview{
+			PositionID:    snapshot.ID,
+			Symbol:        symbol,
+			Side:          snapshot.Side,
+			PositionValue: snapshot.Size * price,
+		})
+	}
+	if len(previews) == 0 {
+		return nil, nil
+	}
+
+	rate, err := schedule.CurrentFundingRate(symbol)
+	if err != nil {
+		return nil, fmt.Errorf("funding rate of %s: %w", symbol, err)
+	}
+	nextFundingAt := schedule.NextSettlement(symbol)
+	for i := range previews {
+		preview := &previews[i]
+		preview.PredictedRate = rate
+		preview.Payment = preview.PositionValue * rate
+		if preview.Side == LONG {
+			preview.Payment = -preview.Payment
+		}
+		preview.NextFundingAt = nextFundingAt
+		preview.TimeRemaining = max(time.Until(nextFundingAt), 0)
+	}
+	sort.Slice(previews, func(i, j int) bool {
+		return previews[i].Side > previews[j].Side
+	})
+	return previews, nil
+}
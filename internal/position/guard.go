@@ -0,0 +1,28 @@
+package position
+
+// ExposureGuard decides whether a position change in symbol may go ahead, increase is true for opens and
+// adds, false for reduces and closes. registered by the risk kill switch, liquidations never go through it
+type ExposureGuard func(symbol string, increase bool) error
+
+// SetExposureGuard register the guard consulted by OpenPosition, AddPosition, ReducePosition and
+// ClosePosition, nil removes it
+func (pm *PositionManager) SetExposureGuard(fn ExposureGuard) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.exposureGuard = fn
+}
+
+// checkExposure pm.mu held
+func (pm *PositionManager) checkExposure(symbol string, increase bool) error {
+	if pm.exposureGuard == nil {
+		return nil
+	}
+	return pm.exposureGuard(symbol, increase)
+}
+
+// guardReduce checkExposure of a reduce or close, takes pm.mu
+func (pm *PositionManager) guardReduce(symbol string) error {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.checkExposure(symbol, false)
+}
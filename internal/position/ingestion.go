@@ -0,0 +1,213 @@
+package position
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PriceTick one mark price from a feed
+type PriceTick struct {
+	Symbol    string
+	Price     float64
+	Timestamp time.Time // optional, ticks older than the last accepted one of the symbol are dropped
+}
+
+// IngestionStats counters of a price ingestion pipeline
+type IngestionStats struct {
+	Received   uint64 `json:"received"`
+	Applied    uint64 `json:"applied"`    // mark price updates run
+	Conflated  uint64 `json:"conflated"`  // replaced by a newer tick before being applied
+	Stale      uint64 `json:"stale"`      // older than the last accepted tick
+	Rejected   uint64 `json:"rejected"`   // unknown symbol or non positive price
+	Liquidated uint64 `json:"liquidated"` // positions moved to liquidating
+
+	// falling behind: ticks waiting in the feed channel, and symbols with a tick not applied yet
+	QueueDepth     int `json:"queue_depth"`
+	MaxQueueDepth  int `json:"max_queue_depth"`
+	PendingSymbols int `json:"pending_symbols"`
+}
+
+// PriceIngestion (標記價格匯入) conflating consumer of a tick feed. each symbol has one worker that always
+// applies the latest tick, so updates of a symbol are applied in feed order and a slow symbol never
+// holds back the others.
+type PriceIngestion struct {
+	apply func(symbol string, price float64) ([]*Position, error)
+	ticks <-chan PriceTick
+
+	slots map[string]*ingestionSlot // only touched by the reader goroutine
+	wg    sync.WaitGroup
+	done  chan struct{}
+
+	received, applied, conflated, stale, rejected, liquidated atomic.Uint64
+	queueDepth, maxQueueDepth, pending                        atomic.Int64
+}
+
+// ingestionSlot latest unapplied tick of one symbol
+type ingestionSlot struct {
+	tick    PriceTick
+	pending bool
+	last    time.Time // timestamp of the last accepted tick
+	wake    chan struct{}
+	mu      sync.Mutex
+}
+
+// StartPriceIngestion consume ticks until ctx is cancelled, the manager is closed or the feed is closed.
+// a closed feed is drained: the latest pending tick of every symbol is still applied.
+// positions crossing their liquidation condition move to liquidating as with UpdateMarkPrices.
+func (pm *PositionManager) StartPriceIngestion(ctx context.Context, ticks <-chan PriceTick) (*PriceIngestion, error) {
+	return pm.startPriceIngestion(ctx, ticks, pm.UpdateMarkPrices)
+}
+
+func (pm *PositionManager) startPriceIngestion(ctx context.Context, ticks <-chan PriceTick, apply func(string, float64) ([]*Position, error)) (*PriceIngestion, error) {
+	ingestion := &PriceIngestion{
+		apply: apply,
+		ticks: ticks,
+		slots: make(map[string]*ingestionSlot),
+		done:  make(chan struct{}),
+	}
+
+	err := pm.runBackground(func(pmCtx context.Context) error {
+		defer close(ingestion.done)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(pmCtx, cancel)
+		defer stop()
+
+		err := ingestion.read(ctx, pm.HasSymbol)
+		ingestion.wg.Wait()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ingestion, nil
+}
+
+// Done closed once the reader and every worker exited
+func (in *PriceIngestion) Done() <-chan struct{} {
+	return in.done
+}
+
+// Stats current counters
+func (in *PriceIngestion) Stats() IngestionStats {
+	return IngestionStats{
+		Received:       in.received.Load(),
+		Applied:        in.applied.Load(),
+		Conflated:      in.conflated.Load(),
+		Stale:          in.stale.Load(),
+		Rejected:       in.rejected.Load(),
+		Liquidated:     in.liquidated.Load(),
+		QueueDepth:     int(in.queueDepth.Load()),
+		MaxQueueDepth:  int(in.maxQueueDepth.Load()),
+		PendingSymbols: int(in.pending.Load()),
+	}
+}
+
+// read hand every tick to its symbol slot, return nil once the feed is closed
+func (in *PriceIngestion) read(ctx context.Context, hasSymbol func(string) bool) error {
+	defer func() {
+		for _, slot := range in.slots {
+			close(slot.wake)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case tick, ok := <-in.ticks:
+			if !ok {
+				return nil
+			}
+			in.received.Add(1)
+			depth := int64(len(in.ticks))
+			in.queueDepth.Store(depth)
+			if depth > in.maxQueueDepth.Load() {
+				in.maxQueueDepth.Store(depth)
+			}
+
+			slot, exists := in.slots[tick.Symbol]
+			if !exists {
+				if tick.Price <= 0 || !hasSymbol(tick.Symbol) {
+					in.rejected.Add(1)
+					continue
+				}
+				slot = &ingestionSlot{wake: make(chan struct{}, 1)}
+				in.slots[tick.Symbol] = slot
+				in.wg.Add(1)
+				go in.work(ctx, tick.Symbol, slot)
+			}
+			in.offer(slot, tick)
+		}
+	}
+}
+
+// offer replace the pending tick of the slot and wake its worker
+func (in *PriceIngestion) offer(slot *ingestionSlot, tick PriceTick) {
+	if tick.Price <= 0 {
+		in.rejected.Add(1)
+		return
+	}
+
+	slot.mu.Lock()
+	if !tick.Timestamp.IsZero() && tick.Timestamp.Before(slot.last) {
+		slot.mu.Unlock()
+		in.stale.Add(1)
+		return
+	}
+	if !tick.Timestamp.IsZero() {
+		slot.last = tick.Timestamp
+	}
+	if slot.pending {
+		in.conflated.Add(1)
+	} else {
+		in.pending.Add(1)
+	}
+	slot.tick, slot.pending = tick, true
+	slot.mu.Unlock()
+
+	select {
+	case slot.wake <- struct{}{}:
+	default: // worker already woken
+	}
+}
+
+// work apply the latest tick of one symbol until ctx is cancelled or the reader closed the slot
+func (in *PriceIngestion) work(ctx context.Context, symbol string, slot *ingestionSlot) {
+	defer in.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-slot.wake:
+			in.applyPending(symbol, slot)
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (in *PriceIngestion) applyPending(symbol string, slot *ingestionSlot) {
+	slot.mu.Lock()
+	tick, pending := slot.tick, slot.pending
+	slot.pending = false
+	slot.mu.Unlock()
+
+	if !pending {
+		return
+	}
+	in.pending.Add(-1)
+
+	liquidated, err := in.apply(symbol, tick.Price)
+	if err != nil {
+		in.rejected.Add(1)
+		return
+	}
+	in.applied.Add(1)
+	in.liquidated.Add(uint64(len(liquidated)))
+}
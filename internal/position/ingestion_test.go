@@ -0,0 +1,137 @@
+package position
+
+import (
+	"context"
+	"frizo/futures_engine/internal/common"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceIngestionConflates(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	var mu sync.Mutex
+	applied := make(map[string][]float64)
+	slowApply := func(symbol string, price float64) ([]*Position, error) {
+		time.Sleep(20 * time.Microsecond)
+		mu.Lock()
+		applied[symbol] = append(applied[symbol], price)
+		mu.Unlock()
+		return nil, nil
+	}
+
+	ticks := make(chan PriceTick, 1024)
+	ingestion, err := pm.startPriceIngestion(context.Background(), ticks, slowApply)
+	require.NoError(t, err)
+
+	const count = 100_000
+	last := make(map[string]float64)
+	for i := 0; i < count; i++ {
+		symbol := symbols[i%2]
+		price := 1000 + float64(i)
+		ticks <- PriceTick{Symbol: symbol, Price: price}
+		last[symbol] = price
+	}
+	close(ticks)
+	<-ingestion.Done()
+
+	stats := ingestion.Stats()
+	assert.Equal(t, uint64(count), stats.Received)
+	assert.Equal(t, stats.Received, stats.Applied+stats.Conflated)
+	assert.Less(t, stats.Applied, stats.Received, "ticks must be conflated")
+	assert.Positive(t, stats.MaxQueueDepth)
+	assert.Zero(t, stats.PendingSymbols)
+
+	// per symbol: a subsequence of the feed, in feed order, ending with the last tick
+	for symbol, prices := range applied {
+		for i := 1; i < len(prices); i++ {
+			require.Greater(t, prices[i], prices[i-1], "%s applied out of order", symbol)
+		}
+		assert.Equal(t, last[symbol], prices[len(prices)-1])
+	}
+	assert.Len(t, applied, 2)
+}
+
+func TestStartPriceIngestionAppliesLatestTick(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	// 強平價 = 50000 - (5000 - 200) = 45200
+	pos, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	require.NoError(t, err)
+
+	ticks := make(chan PriceTick, 256)
+	ingestion, err := pm.StartPriceIngestion(context.Background(), ticks)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 100_000; i++ {
+		ticks <- PriceTick{Symbol: "BTCUSDT", Price: 50000 - float64(i%1000), Timestamp: now.Add(time.Duration(i))}
+	}
+	ticks <- PriceTick{Symbol: "BTCUSDT", Price: 44000, Timestamp: now.Add(time.Hour)}
+	ticks <- PriceTick{Symbol: "BTCUSDT", Price: 60000, Timestamp: now} // stale
+	ticks <- PriceTick{Symbol: "XRPUSDT", Price: 1}                     // unknown symbol
+	ticks <- PriceTick{Symbol: "ETHUSDT", Price: 0}
+	close(ticks)
+	<-ingestion.Done()
+
+	assert.Equal(t, 44000.0, pm.GetSymbolMarkPrice("BTCUSDT"))
+	assert.Equal(t, PositionLiquidating, pos.Snapshot().Status)
+
+	stats := ingestion.Stats()
+	assert.Equal(t, uint64(100_004), stats.Received)
+	assert.Equal(t, uint64(1), stats.Stale)
+	assert.Equal(t, uint64(2), stats.Rejected)
+	assert.Equal(t, uint64(1), stats.Liquidated)
+}
+
+func TestPriceIngestionStopsWithoutLeaks(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	before := runtime.NumGoroutine()
+
+	feed := func() chan PriceTick {
+		ticks := make(chan PriceTick, 64)
+		for i := 0; i < 64; i++ {
+			ticks <- PriceTick{Symbol: symbols[i%len(symbols)], Price: 100 + float64(i)}
+		}
+		return ticks // never closed
+	}
+
+	// cancelled by its context
+	ctx, cancel := context.WithCancel(context.Background())
+	ingestion, err := pm.StartPriceIngestion(ctx, feed())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return ingestion.Stats().Received == 64 }, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case <-ingestion.Done():
+	case <-time.After(time.Second):
+		t.Fatal("ingestion did not stop on cancel")
+	}
+
+	// stopped by Close
+	ingestion, err = pm.StartPriceIngestion(context.Background(), feed())
+	require.NoError(t, err)
+	require.NoError(t, pm.Close())
+	select {
+	case <-ingestion.Done():
+	default:
+		t.Fatal("Close returned before the ingestion stopped")
+	}
+
+	// polled inline, assert.Eventually runs the condition in goroutines of its own
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "leaked goroutines")
+
+	_, err = pm.StartPriceIngestion(context.Background(), feed())
+	assert.Error(t, err)
+}
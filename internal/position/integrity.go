@@ -0,0 +1,137 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// IntegrityViolationType kind of IntegrityViolation
+type IntegrityViolationType string
+
+const (
+	ViolationOrphaned      IntegrityViolationType = "orphaned"      // in userPositions, missing from the id index
+	ViolationMisclassified IntegrityViolationType = "misclassified" // filed under another symbol
+	ViolationContradictory IntegrityViolationType = "contradictory" // closed with a size left
+	ViolationMiscalculated IntegrityViolationType = "miscalculated" // open without a liquidation price
+	ViolationBalanceLeak   IntegrityViolationType = "balance_leak"  // account ledger does not add up
+	ViolationIncomplete    IntegrityViolationType = "incomplete"    // verification interrupted
+)
+
+// IntegrityViolation one broken invariant, PositionID is empty for account violations
+type IntegrityViolation struct {
+	Type       IntegrityViolationType `json:"type"`
+	UserID     string                 `json:"user_id,omitempty"`
+	PositionID string                 `json:"position_id,omitempty"`
+	Symbol     string                 `json:"symbol,omitempty"`
+	Detail     string                 `json:"detail"`
+}
+
+// IntegrityReport (完整性報告) result of VerifyIntegrity
+type IntegrityReport struct {
+	IsHealthy  bool                 `json:"is_healthy"`
+	Violations []IntegrityViolation `json:"violations"`
+	Positions  int                  `json:"positions"` // positions checked
+	CheckedAt  time.Time            `json:"checked_at"`
+}
+
+// BalanceVerifier account ledger checks contributed by the margin system, see SetBalanceVerifier
+type BalanceVerifier func(ctx context.Context) []IntegrityViolation
+
+// SetBalanceVerifier register the account checks run by VerifyIntegrity,
+// lets the margin system plug in without a circular import
+func (pm *PositionManager) SetBalanceVerifier(fn BalanceVerifier) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.balanceVerifier = fn
+}
+
+// VerifyIntegrity (完整性驗證) full pass over the indexes, the positions and, with a balance verifier,
+// the accounts. meant to run after a warm-up or recovery before serving traffic: unlike HealthCheck it also
+// checks derived state (symbol index, liquidation prices). a cancelled ctx yields an unhealthy report
+func (pm *PositionManager) VerifyIntegrity(ctx context.Context) IntegrityReport {
+	report := IntegrityReport{CheckedAt: time.Now()}
+
+	pm.mu.RLock()
+	for userID, userPositions := range pm.userPositions {
+		for _, pos := range userPositions {
+			report.Positions++
+			snapshot := pos.Snapshot()
+			violation := IntegrityViolation{UserID: userID, PositionID: pos.ID, Symbol: snapshot.Symbol}
+
+			if indexed, exists := pm.positionsByID[pos.ID]; (!exists || indexed != pos) && snapshot.Status != PositionClosed {
+				violation.Type = ViolationOrphaned
+				violation.Detail = "missing from id index"
+				report.Violations = append(report.Violations, violation)
+			}
+			switch {
+			case snapshot.Status == PositionClosed && snapshot.Size > 0:
+				violation.Type = ViolationContradictory
+				violation.Detail = fmt.Sprintf("closed with size %f", snapshot.Size)
+				report.Violations = append(report.Violations, violation)
+			case snapshot.Status != PositionClosed && snapshot.Size > pos.ZeroSize() && snapshot.LiquidationPrice <= 0:
+				violation.Type = ViolationMiscalculated
+				violation.Detail = fmt.Sprintf("size %f with liquidation price %f", snapshot.Size, snapshot.LiquidationPrice)
+				report.Violations = append(report.Violations, violation)
+			}
+		}
+	}
+	verifyBalances := pm.balanceVerifier
+	pm.mu.RUnlock()
+
+	for _, symbol := range pm.symbolPositions.GetAllSymbols() {
+		if err := ctx.Err(); err != nil {
+			return report.interrupted(err)
+		}
+		positions, err := pm.symbolPositions.GetPositions(symbol)
+		if err != nil {
+			continue // removed meanwhile
+		}
+		for _, pos := range positions {
+			if pos.Symbol != symbol {
+				report.Violations = append(report.Violations, IntegrityViolation{
+					Type:       ViolationMisclassified,
+					UserID:     pos.UserID,
+					PositionID: pos.ID,
+					Symbol:     pos.Symbol,
+					Detail:     fmt.Sprintf("filed under %s", symbol),
+				})
+			}
+		}
+	}
+
+	if verifyBalances != nil {
+		if err := ctx.Err(); err != nil {
+			return report.interrupted(err)
+		}
+		report.Violations = append(report.Violations, verifyBalances(ctx)...)
+	}
+	if err := ctx.Err(); err != nil {
+		return report.interrupted(err)
+	}
+
+	report.sortViolations()
+	report.IsHealthy = len(report.Violations) == 0
+	return report
+}
+
+func (r IntegrityReport) interrupted(err error) IntegrityReport {
+	r.Violations = append(r.Violations, IntegrityViolation{Type: ViolationIncomplete, Detail: err.Error()})
+	r.sortViolations()
+	r.IsHealthy = false
+	return r
+}
+
+func (r IntegrityReport) sortViolations() {
+	sort.SliceStable(r.Violations, func(i, j int) bool {
+		a, b := r.Violations[i], r.Violations[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.PositionID < b.PositionID
+	})
+}
@@ -0,0 +1,76 @@
+package position
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LeverageTierPolicy what an add does when the grown position value falls in a tier whose max leverage
+// is below the position leverage
+type LeverageTierPolicy int
+
+const (
+	// LeverageTierRequireMargin lower the leverage to the tier max, the position locks the extra initial margin
+	LeverageTierRequireMargin LeverageTierPolicy = iota
+	// LeverageTierReject refuse the add, the position is left untouched
+	LeverageTierReject
+)
+
+func (p LeverageTierPolicy) String() string {
+	switch p {
+	case LeverageTierRequireMargin:
+		return "require_margin"
+	case LeverageTierReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+var ErrLeverageExceedsTier = errors.New("leverage exceeds margin tier max")
+
+// TierEnforcement (槓桿階梯檢查) outcome of the tier check of one add
+type TierEnforcement struct {
+	Policy           LeverageTierPolicy `json:"policy"`
+	Applied          bool               `json:"applied"` // the add crossed into a tier below the position leverage
+	PositionValue    float64            `json:"position_value"`
+	MaxLeverage      uint               `json:"max_leverage"`
+	PreviousLeverage int16              `json:"previous_leverage"`
+	Leverage         int16              `json:"leverage"`
+	AdditionalMargin float64            `json:"additional_margin"` // initial margin above the one at PreviousLeverage
+}
+
+// SetLeverageTierPolicy policy of later adds, LeverageTierRequireMargin by default
+func (pm *PositionManager) SetLeverageTierPolicy(policy LeverageTierPolicy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.tierPolicy = policy
+}
+
+// AddPosition (加倉) add to the open position of the user under the leverage tier policy,
+// OpenPosition does the same for an existing position but does not report the enforcement
+func (pm *PositionManager) AddPosition(userID, symbol string, side PositionSide, price, size float64) (*Position, TierEnforcement, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if err := pm.checkExposure(symbol, true); err != nil {
+		return nil, TierEnforcement{}, err
+	}
+	position, exists := pm.userPositions[userID][getPositionKey(symbol, side, pm.mode[userID])]
+	if !exists || position.Size <= position.ZeroSize() {
+		return nil, TierEnforcement{}, fmt.Errorf("no open %s position of %s in %s to add to", side, userID, symbol)
+	}
+	enforcement, err := pm.addPosition(position, price, size)
+	return position, enforcement, err
+}
+
+// addPosition pm.mu held
+func (pm *PositionManager) addPosition(position *Position, price, size float64) (TierEnforcement, error) {
+	enforcement, err := position.AddWithTierPolicy(price, size, pm.tierPolicy)
+	if err != nil {
+		return enforcement, err
+	}
+	_ = pm.symbolPositions.AdjustOpenInterest(position.Symbol, position.Side, size)
+	pm.audit(position, AuditAdd, size, price)
+	return enforcement, nil
+}
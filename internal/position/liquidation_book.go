@@ -0,0 +1,188 @@
+package position
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// LiquidationBucketRate default bucket width, 0.5% of the mark price
+const LiquidationBucketRate = 0.005
+
+// LiquidationCluster positions whose liquidation price falls in one bucket
+type LiquidationCluster struct {
+	PriceBucket   float64 `json:"price_bucket"`
+	TotalSize     float64 `json:"total_size"`
+	PositionCount int     `json:"position_count"`
+}
+
+// LiquidationOrderBook (強平價格分佈) like an order book but for liquidation prices.
+// longs are liquidated below the mark (sorted high -> low like bids),
+// shorts above the mark (sorted low -> high like asks).
+type LiquidationOrderBook struct {
+	Symbol            string               `json:"symbol"`
+	BucketSize        float64              `json:"bucket_size"`
+	LongLiquidations  []LiquidationCluster `json:"long_liquidations"`
+	ShortLiquidations []LiquidationCluster `json:"short_liquidations"`
+}
+
+// GetLiquidationOrderBook cluster open positions of the symbol by liquidation price,
+// bucket width is 0.5% of the average mark price
+func (pm *PositionManager) GetLiquidationOrderBook(symbol string) (*LiquidationOrderBook, error) {
+	positions, err := pm.symbolPositions.GetPositions(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	markSum, count := 0.0, 0
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if isOpenSnapshot(snapshot) {
+			markSum += snapshot.MarkPrice
+			count++
+		}
+	}
+	if count == 0 {
+		return &LiquidationOrderBook{Symbol: symbol}, nil
+	}
+
+	return pm.GetLiquidationOrderBookWithBucket(symbol, markSum/float64(count)*LiquidationBucketRate)
+}
+
+// GetLiquidationOrderBookWithBucket cluster with a fixed bucket width (USDT)
+func (pm *PositionManager) GetLiquidationOrderBookWithBucket(symbol string, bucketSize float64) (*LiquidationOrderBook, error) {
+	if bucketSize <= 0 {
+		return nil, fmt.Errorf("bucket size must be greater than zero")
+	}
+	positions, err := pm.symbolPositions.GetPositions(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	longs := make(map[float64]*LiquidationCluster)
+	shorts := make(map[float64]*LiquidationCluster)
+
+	for _, pos := range positions {
+		snapshot := pos.Snapshot()
+		if !isOpenSnapshot(snapshot) || snapshot.LiquidationPrice <= 0 {
+			continue
+		}
+
+		// round to the nearest bucket
+		bucket := math.Round(snapshot.LiquidationPrice/bucketSize) * bucketSize
+
+		clusters := longs
+		if snapshot.Side == SHORT {
+			clusters = shorts
+		}
+		cluster, exists := clusters[bucket]
+		if !exists {
+			cluster = &LiquidationCluster{PriceBucket: bucket}
+			clusters[bucket] = cluster
+		}
+		cluster.TotalSize += snapshot.Size
+		cluster.PositionCount++
+	}
+
+	book := &LiquidationOrderBook{
+		Symbol:            symbol,
+		BucketSize:        bucketSize,
+		LongLiquidations:  sortedClusters(longs, true),
+		ShortLiquidations: sortedClusters(shorts, false),
+	}
+	return book, nil
+}
+
+func sortedClusters(clusters map[float64]*LiquidationCluster, descending bool) []LiquidationCluster {
+	result := make([]LiquidationCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		result = append(result, *cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if descending {
+			return result[i].PriceBucket > result[j].PriceBucket
+		}
+		return result[i].PriceBucket < result[j].PriceBucket
+	})
+	return result
+}
+
+func isOpenSnapshot(snapshot PositionSnapshot) bool {
+	return snapshot.Status == PositionNormal && snapshot.Size > 0
+}
+
+// LiquidationCandidate liquidatable position with the values it was selected on
+type LiquidationCandidate struct {
+	Position          *Position    `json:"-"`
+	PositionID        string       `json:"position_id"`
+	UserID            string       `json:"user_id"`
+	Symbol            string       `json:"symbol"`
+	Side              PositionSide `json:"side"`
+	Size              float64      `json:"size"`
+	MarkPrice         float64      `json:"mark_price"`
+	LiquidationPrice  float64      `json:"liquidation_price"`
+	MarginRatio       float64      `json:"margin_ratio"`
+	MaintenanceMargin float64      `json:"maintenance_margin"`
+}
+
+// GetAllLiquidatablePositions liquidatable positions of every symbol in a single pass (symbol -> candidates)
+func (pm *PositionManager) GetAllLiquidatablePositions() map[string][]*LiquidationCandidate {
+	candidates := make(map[string][]*LiquidationCandidate)
+	for _, pos := range pm.allPositions() {
+		if candidate, ok := pos.liquidationCandidate(); ok {
+			candidates[candidate.Symbol] = append(candidates[candidate.Symbol], &candidate)
+		}
+	}
+	return candidates
+}
+
+// liquidationCandidate read position under its lock, false if not liquidatable
+func (p *Position) liquidationCandidate() (LiquidationCandidate, bool) {
+	p.refreshCrossEquity()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.isLiquidatable() {
+		return LiquidationCandidate{}, false
+	}
+	return p.candidate(), true
+}
+
+// GetPositionsByMarginRatio (追保預警) open positions of the symbol with MarginRatio <= maxRatio (%),
+// liquidating or not, lowest ratio first. e.g. maintenance ratio * 1.5 for margin call alerts
+func (pm *PositionManager) GetPositionsByMarginRatio(symbol string, maxRatio float64) []LiquidationCandidate {
+	// the symbol index drops liquidating positions, walk the users instead
+	var candidates []LiquidationCandidate
+	for _, pos := range pm.allPositions() {
+		if pos.Symbol != symbol {
+			continue
+		}
+		pos.refreshCrossEquity()
+		pos.mu.RLock()
+		if pos.Status != PositionClosed && pos.Size > pos.ZeroSize() && pos.getMarginRatio() <= maxRatio {
+			candidates = append(candidates, pos.candidate())
+		}
+		pos.mu.RUnlock()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].MarginRatio < candidates[j].MarginRatio
+	})
+	return candidates
+}
+
+// candidate values of the position, no lock
+func (p *Position) candidate() LiquidationCandidate {
+	return LiquidationCandidate{
+		Position:          p,
+		PositionID:        p.ID,
+		UserID:            p.UserID,
+		Symbol:            p.Symbol,
+		Side:              p.Side,
+		Size:              p.Size,
+		MarkPrice:         p.MarkPrice,
+		LiquidationPrice:  p.LiquidationPrice,
+		MarginRatio:       p.getMarginRatio(),
+		MaintenanceMargin: p.MaintenanceMargin,
+	}
+}
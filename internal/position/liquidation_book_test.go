@@ -0,0 +1,138 @@
+package position
+
+import (
+	"frizo/futures_engine/internal/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiquidationOrderBook(t *testing.T) {
+	pm := NewPositionManager(symbols)
+
+	openWithLiquidationPrice := func(userID string, side PositionSide, size, liquidationPrice float64) {
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", side, 50000, size, 10)
+		require.NoError(t, err)
+		pos.LiquidationPrice = liquidationPrice
+	}
+
+	openWithLiquidationPrice("user1", LONG, 1, 48000)
+	openWithLiquidationPrice("user2", LONG, 2, 48050)
+	openWithLiquidationPrice("user3", LONG, 0.5, 49000)
+	openWithLiquidationPrice("user4", SHORT, 1, 52100)
+	openWithLiquidationPrice("user5", SHORT, 3, 55000)
+
+	// closed position 不計入
+	openWithLiquidationPrice("user6", LONG, 1, 48000)
+	_, _, err := pm.ClosePosition("user6", "BTCUSDT", LONG, 50000)
+	require.NoError(t, err)
+
+	book, err := pm.GetLiquidationOrderBookWithBucket("BTCUSDT", 250)
+	require.NoError(t, err)
+
+	assert.Equal(t, []LiquidationCluster{
+		{PriceBucket: 49000, TotalSize: 0.5, PositionCount: 1},
+		{PriceBucket: 48000, TotalSize: 3, PositionCount: 2}, // 48000 + 48050
+	}, book.LongLiquidations)
+	assert.Equal(t, []LiquidationCluster{
+		{PriceBucket: 52000, TotalSize: 1, PositionCount: 1},
+		{PriceBucket: 55000, TotalSize: 3, PositionCount: 1},
+	}, book.ShortLiquidations)
+
+	// mark price 50000 -> 0.5% = 250
+	book, err = pm.GetLiquidationOrderBook("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, 250.0, book.BucketSize)
+	assert.Len(t, book.LongLiquidations, 2)
+
+	_, err = pm.GetLiquidationOrderBookWithBucket("BTCUSDT", 0)
+	assert.Error(t, err)
+	_, err = pm.GetLiquidationOrderBook("UNKNOWN")
+	assert.Error(t, err)
+
+	book, err = pm.GetLiquidationOrderBook("ETHUSDT")
+	require.NoError(t, err)
+	assert.Empty(t, book.LongLiquidations)
+}
+
+func TestGetAllLiquidatablePositions(t *testing.T) {
+	pm := NewPositionManager([]string{"BTCUSDT", "ETHUSDT", "SOLUSDT", "XRPUSDT"})
+
+	open := func(userID, symbol string, price float64, leverage uint) {
+		_, err := pm.OpenPosition(common.ISOLATED, userID, symbol, LONG, price, 1, leverage)
+		require.NoError(t, err)
+	}
+	// 100x 高槓桿 -> 下跌 2% 會被強平, 5x 安全
+	open("user1", "BTCUSDT", 50000, 100)
+	open("user2", "BTCUSDT", 50000, 100)
+	open("user3", "BTCUSDT", 50000, 5)
+	open("user1", "ETHUSDT", 3000, 100)
+	open("user2", "ETHUSDT", 3000, 5)
+	open("user1", "SOLUSDT", 100, 100)
+	open("user2", "SOLUSDT", 100, 100)
+	open("user3", "SOLUSDT", 100, 100)
+	open("user1", "XRPUSDT", 1, 5)
+
+	assert.Empty(t, pm.GetAllLiquidatablePositions())
+
+	for symbol, price := range map[string]float64{"BTCUSDT": 49000, "ETHUSDT": 2940, "SOLUSDT": 98, "XRPUSDT": 0.98} {
+		_, err := pm.UpdateMarkPrices(symbol, price)
+		require.NoError(t, err)
+	}
+
+	candidates := pm.GetAllLiquidatablePositions()
+	require.Len(t, candidates, 3)
+	assert.Len(t, candidates["BTCUSDT"], 2)
+	assert.Len(t, candidates["ETHUSDT"], 1)
+	assert.Len(t, candidates["SOLUSDT"], 3)
+	assert.NotContains(t, candidates, "XRPUSDT")
+
+	candidate := candidates["ETHUSDT"][0]
+	assert.Equal(t, "user1", candidate.UserID)
+	assert.Equal(t, 2940.0, candidate.MarkPrice)
+	assert.Equal(t, candidate.Position.ID, candidate.PositionID)
+	assert.LessOrEqual(t, candidate.MarginRatio, candidate.MaintenanceMargin/(candidate.MarkPrice*candidate.Size)*100)
+}
+
+func TestGetPositionsByMarginRatio(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	// margin ratio = margin / position value at mark = entry: 2500, 4000, 7500 of 50000
+	open := func(userID string, leverage uint, extraMargin float64) *Position {
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", LONG, 50000, 1, leverage)
+		require.NoError(t, err)
+		if extraMargin > 0 {
+			require.NoError(t, pos.AddMargin(extraMargin))
+		}
+		return pos
+	}
+	at5 := open("user1", 20, 0)
+	at8 := open("user2", 20, 1500)
+	open("user3", 10, 2500)
+	_, err := pm.OpenPosition(common.ISOLATED, "user4", "ETHUSDT", LONG, 3000, 1, 20)
+	require.NoError(t, err)
+
+	candidates := pm.GetPositionsByMarginRatio("BTCUSDT", 10.0)
+	require.Len(t, candidates, 2)
+	assert.Equal(t, at5.ID, candidates[0].PositionID)
+	assert.InDelta(t, 5.0, candidates[0].MarginRatio, 1e-9)
+	assert.Equal(t, at8.ID, candidates[1].PositionID)
+	assert.InDelta(t, 8.0, candidates[1].MarginRatio, 1e-9)
+
+	// values, not live: later mark prices do not change the returned candidates
+	// 47400: user1 under water, user2 at 1400/47400, user3 at 4900/47400 still above 10%
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 47400)
+	require.NoError(t, err)
+	assert.InDelta(t, 5.0, candidates[0].MarginRatio, 1e-9)
+
+	// liquidating positions are still reported, closed ones are not
+	assert.Equal(t, PositionLiquidating, at5.Snapshot().Status)
+	assert.Len(t, pm.GetPositionsByMarginRatio("BTCUSDT", 10.0), 2)
+	_, _, err = pm.ClosePosition("user2", "BTCUSDT", LONG, 47400)
+	require.NoError(t, err)
+	assert.Len(t, pm.GetPositionsByMarginRatio("BTCUSDT", 10.0), 1)
+
+	assert.Empty(t, pm.GetPositionsByMarginRatio("XRPUSDT", 10.0))
+}
@@ -0,0 +1,151 @@
+package position
+
+import (
+	"container/heap"
+	"frizo/futures_engine/internal/common"
+)
+
+// liquidationIndexTolerance relative slack on the crossing test, the margin ratio check decides the boundary
+const liquidationIndexTolerance = 1e-9
+
+// liquidationIndexEntry one indexed position, price is its liquidation price when indexed
+type liquidationIndexEntry struct {
+	position *Position
+	price    float64
+	side     PositionSide
+	index    int // heap slot
+}
+
+// liquidationHeap longs: highest liquidation price first (hit first by a falling mark),
+// shorts: lowest first (hit first by a rising mark)
+type liquidationHeap struct {
+	entries []*liquidationIndexEntry
+	long    bool
+}
+
+func (h *liquidationHeap) Len() int { return len(h.entries) }
+
+func (h *liquidationHeap) Less(i, j int) bool {
+	if h.long {
+		return h.entries[i].price > h.entries[j].price
+	}
+	return h.entries[i].price < h.entries[j].price
+}
+
+func (h *liquidationHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *liquidationHeap) Push(x any) {
+	entry := x.(*liquidationIndexEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *liquidationHeap) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries[last] = nil
+	h.entries = h.entries[:last]
+	return entry
+}
+
+// crossed whether the mark price reached the top liquidation price
+func (h *liquidationHeap) crossed(markPrice float64) bool {
+	if len(h.entries) == 0 {
+		return false
+	}
+	if h.long {
+		return markPrice <= h.entries[0].price*(1+liquidationIndexTolerance)
+	}
+	return markPrice >= h.entries[0].price*(1-liquidationIndexTolerance)
+}
+
+// liquidationIndex (強平索引) normal positions of one symbol ordered by liquidation price, so a mark price
+// update only inspects the positions it crossed. cross margin positions depend on the account equity,
+// not on their own liquidation price, and are inspected on every update. no lock, guarded by AtomicPositions.
+type liquidationIndex struct {
+	longs     *liquidationHeap
+	shorts    *liquidationHeap
+	unindexed map[*Position]struct{}
+	entries   map[*Position]*liquidationIndexEntry
+}
+
+func newLiquidationIndex() *liquidationIndex {
+	return &liquidationIndex{
+		longs:     &liquidationHeap{long: true},
+		shorts:    &liquidationHeap{},
+		unindexed: make(map[*Position]struct{}),
+		entries:   make(map[*Position]*liquidationIndexEntry),
+	}
+}
+
+func (idx *liquidationIndex) Len() int {
+	return len(idx.entries) + len(idx.unindexed)
+}
+
+// upsert (re)position after a change of its liquidation price, status or margin mode
+func (idx *liquidationIndex) upsert(pos *Position) {
+	pos.mu.RLock()
+	open := pos.Status == PositionNormal && pos.Size > pos.ZeroSize()
+	cross := pos.MarginMode == common.CROSS
+	side, price := pos.Side, pos.LiquidationPrice
+	pos.mu.RUnlock()
+
+	if !open || cross {
+		idx.remove(pos)
+		if open {
+			idx.unindexed[pos] = struct{}{}
+		}
+		return
+	}
+	delete(idx.unindexed, pos)
+
+	if entry, exists := idx.entries[pos]; exists {
+		if entry.price == price {
+			return
+		}
+		entry.price = price
+		heap.Fix(idx.heap(entry.side), entry.index)
+		return
+	}
+	entry := &liquidationIndexEntry{position: pos, price: price, side: side}
+	idx.entries[pos] = entry
+	heap.Push(idx.heap(side), entry)
+}
+
+func (idx *liquidationIndex) remove(pos *Position) {
+	delete(idx.unindexed, pos)
+	entry, exists := idx.entries[pos]
+	if !exists {
+		return
+	}
+	delete(idx.entries, pos)
+	heap.Remove(idx.heap(entry.side), entry.index)
+}
+
+// popCrossed take out every indexed position the mark price crossed, plus the unindexed ones.
+// the caller puts back what is still normal.
+func (idx *liquidationIndex) popCrossed(markPrice float64) []*Position {
+	positions := make([]*Position, 0, len(idx.unindexed))
+	for pos := range idx.unindexed {
+		positions = append(positions, pos)
+	}
+	for _, h := range []*liquidationHeap{idx.longs, idx.shorts} {
+		for h.crossed(markPrice) {
+			entry := heap.Pop(h).(*liquidationIndexEntry)
+			delete(idx.entries, entry.position)
+			positions = append(positions, entry.position)
+		}
+	}
+	return positions
+}
+
+func (idx *liquidationIndex) heap(side PositionSide) *liquidationHeap {
+	if side == LONG {
+		return idx.longs
+	}
+	return idx.shorts
+}
@@ -0,0 +1,134 @@
+package position
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func liquidatedKeys(positions []*Position) []string {
+	keys := make([]string, 0, len(positions))
+	for _, pos := range positions {
+		keys = append(keys, pos.UserID+"_"+pos.Side.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TestLiquidationIndexMatchesScan same random workload on a scanning and an indexed manager,
+// every mark price update must liquidate the same positions
+func TestLiquidationIndexMatchesScan(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed_%d", seed), func(t *testing.T) {
+			scan := NewPositionManager(symbols)
+			indexed := NewPositionManager(symbols)
+			indexed.UseLiquidationIndex(true)
+			defer scan.Close()
+			defer indexed.Close()
+
+			rng := rand.New(rand.NewSource(seed))
+			mark := 50000.0
+			total := 0
+
+			for step := 0; step < 3000; step++ {
+				userID := fmt.Sprintf("user_%d", rng.Intn(300))
+				side := LONG
+				if rng.Intn(2) == 0 {
+					side = SHORT
+				}
+				price := mark * (0.99 + rng.Float64()*0.02)
+				size := float64(1+rng.Intn(20)) / 10
+
+				switch op := rng.Intn(10); {
+				case op < 4:
+					mode := common.ISOLATED
+					if rng.Intn(5) == 0 {
+						mode = common.CROSS
+					}
+					leverage := uint(2 + rng.Intn(49))
+					_, errScan := scan.OpenPosition(mode, userID, "BTCUSDT", side, price, size, leverage)
+					_, errIndexed := indexed.OpenPosition(mode, userID, "BTCUSDT", side, price, size, leverage)
+					require.Equal(t, errScan == nil, errIndexed == nil)
+				case op < 6:
+					pos, err := scan.GetPosition(userID, "BTCUSDT", side)
+					if err != nil {
+						continue
+					}
+					reduce := min(size, pos.Snapshot().Size)
+					_, _, errScan := scan.ReducePosition(userID, "BTCUSDT", side, price, reduce)
+					_, _, errIndexed := indexed.ReducePosition(userID, "BTCUSDT", side, price, reduce)
+					require.Equal(t, errScan == nil, errIndexed == nil)
+				case op < 7:
+					margin := float64(1 + rng.Intn(500))
+					for _, pm := range []*PositionManager{scan, indexed} {
+						if pos, err := pm.GetPosition(userID, "BTCUSDT", side); err == nil {
+							_ = pos.AddMargin(margin)
+						}
+					}
+				default:
+					// random walk with occasional jumps
+					move := (rng.Float64() - 0.5) * 0.01
+					if rng.Intn(20) == 0 {
+						move *= 10
+					}
+					mark *= 1 + move
+
+					fromScan, err := scan.UpdateMarkPrices("BTCUSDT", mark)
+					require.NoError(t, err)
+					fromIndex, err := indexed.UpdateMarkPrices("BTCUSDT", mark)
+					require.NoError(t, err)
+					require.Equal(t, liquidatedKeys(fromScan), liquidatedKeys(fromIndex), "step %d mark %.2f", step, mark)
+					total += len(fromScan)
+				}
+			}
+			assert.Positive(t, total, "workload never liquidated anything")
+		})
+	}
+}
+
+func TestLiquidationIndexRelease(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	pm.UseLiquidationIndex(true)
+	defer pm.Close()
+
+	// 強平價 = 50000 - (5000 - 200) = 45200
+	pos, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	far, err := pm.OpenPosition(common.ISOLATED, "user2", "BTCUSDT", LONG, 50000, 1, 2)
+	require.NoError(t, err)
+
+	liquidated, err := pm.UpdateMarkPrices("BTCUSDT", 46000)
+	require.NoError(t, err)
+	assert.Empty(t, liquidated)
+	assert.Equal(t, 50000.0, pos.Snapshot().MarkPrice, "not crossed, not inspected")
+
+	liquidated, err = pm.UpdateMarkPrices("BTCUSDT", 45100)
+	require.NoError(t, err)
+	require.Len(t, liquidated, 1)
+	assert.Same(t, pos, liquidated[0])
+
+	// claimed, topped up and given back: indexed again
+	require.True(t, pos.ClaimLiquidation())
+	pos.mu.Lock()
+	pos.InitialMargin += 2000
+	pos.calculateLiquidationPrice()
+	pos.mu.Unlock()
+	require.True(t, pos.ReleaseLiquidation(0))
+
+	liquidated, err = pm.UpdateMarkPrices("BTCUSDT", 44000)
+	require.NoError(t, err)
+	assert.Empty(t, liquidated)
+	liquidated, err = pm.UpdateMarkPrices("BTCUSDT", 43100)
+	require.NoError(t, err)
+	assert.Len(t, liquidated, 1)
+
+	// full refresh updates everyone
+	_, err = pm.RefreshMarkPrices("BTCUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, 43100.0, far.Snapshot().MarkPrice)
+}
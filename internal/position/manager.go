@@ -1,8 +1,12 @@
 package position
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/metrics"
+	"sort"
 	"sync"
 )
 
@@ -17,16 +21,6 @@ func (p *UserPositions) updateMarkPrice(prices map[string]float64) {
 	}
 }
 
-func (p *UserPositions) getLiquidatablePositions() []*Position {
-	var liquidatable []*Position
-	for _, position := range *p {
-		if position.IsLiquidatable() {
-			liquidatable = append(liquidatable, position)
-		}
-	}
-	return liquidatable
-}
-
 func (p *UserPositions) hasOpenPosition() bool {
 	for _, position := range *p {
 		if position.Size > position.ZeroSize() {
@@ -43,17 +37,108 @@ func (p *UserPositions) hasOpenPosition() bool {
 type PositionManager struct {
 	userPositions   map[string]UserPositions // userID -> UserPosition
 	symbolPositions *SymbolPositions         // symbol : *Position
+	positionsByID   map[string]*Position     // positionID -> Position
 	mode            map[string]PositionMode  // userID -> position mode
 	mu              sync.RWMutex
+
+	// cross margin equity callback into the margin system
+	crossEquityProvider CrossMarginEquityProvider
+
+	// paper trading manager, every position is tagged simulated
+	simulated bool
+
+	// optional persistence of recovered positions
+	store PositionStore
+
+	// symbol -> users who opened a position in it, pruned by CrossUsers
+	holders map[string]map[string]struct{}
+
+	// exchange-wide maintenance rate floor, nil means tier rates only
+	globalMaintenanceOverride *float64
+
+	// what adds crossing into a lower max leverage tier do
+	tierPolicy LeverageTierPolicy
+
+	// funding rate source of GetPositionsNearFundingTime
+	fundingSchedule FundingRateSchedule
+
+	// in-memory audit log of position operations
+	auditLog *common.RingBuffer[PositionAuditEntry]
+	auditMu  sync.Mutex
+
+	// account checks of VerifyIntegrity, registered by the margin system
+	balanceVerifier BalanceVerifier
+
+	// account settlement of CheckExpiry, registered by the margin system
+	expirySettler ExpirySettler
+
+	// account update of RecoverPosition, registered by the margin system
+	recoveryHook RecoveryHook
+
+	// kill switch of position changes, nil means everything goes
+	exposureGuard ExposureGuard
+
+	// position event stream (SubscribeEvents)
+	events EventBus
+
+	// margin call counts (SetMetrics), metrics.Nop by default
+	metrics   metrics.Registry
+	metricsMu sync.Mutex
+
+	// NewWithOptions dependencies: operation journal, audit log handler and its subscription,
+	// per symbol precision of new positions, open positions limit of a user (0 unlimited)
+	journal             PositionJournal
+	auditLogger         *AuditLogger
+	stopAuditLogger     func()
+	precision           map[string]*PrecisionSetting
+	maxPositionsPerUser int
+
+	// background goroutines lifecycle
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	closed   bool
+	bgErrors []error
+	bgMu     sync.Mutex // guards closed, bgErrors
 }
 
-// NewPositionManager new
+var ErrPositionAlreadyExists = errors.New("position already exists")
+
+// NewPositionManager manager of symbols with the defaults, see NewWithOptions
 func NewPositionManager(symbols []string) *PositionManager {
-	return &PositionManager{
-		userPositions:   make(map[string]UserPositions),
-		symbolPositions: NewSymbolPositions(symbols),
-		mode:            make(map[string]PositionMode),
+	return NewWithOptions(WithSymbols(symbols...))
+}
+
+// NewSimulatedPositionManager manager for paper trading, kept apart from the real one
+func NewSimulatedPositionManager(symbols []string) *PositionManager {
+	pm := NewPositionManager(symbols)
+	pm.simulated = true
+	return pm
+}
+
+func (pm *PositionManager) IsSimulated() bool {
+	return pm.simulated
+}
+
+// Close stop all background goroutines and wait for them to finish, return their accumulated errors
+func (pm *PositionManager) Close() error {
+	pm.bgMu.Lock()
+	if pm.closed {
+		pm.bgMu.Unlock()
+		return fmt.Errorf("position manager already closed")
 	}
+	pm.closed = true
+	pm.bgMu.Unlock()
+
+	pm.cancel()
+	pm.wg.Wait()
+	if pm.stopAuditLogger != nil {
+		pm.stopAuditLogger()
+	}
+
+	pm.bgMu.Lock()
+	defer pm.bgMu.Unlock()
+	return errors.Join(pm.bgErrors...)
 }
 
 // GetPosition
@@ -81,15 +166,166 @@ func (pm *PositionManager) GetPosition(userID string, symbol string, side Positi
 	return position, nil
 }
 
+// GetPositionSnapshot (倉位快照) value copy of a single position, safe to serialize
+func (pm *PositionManager) GetPositionSnapshot(positionID string) (PositionSnapshot, error) {
+	pm.mu.RLock()
+	position, exists := pm.positionsByID[positionID]
+	pm.mu.RUnlock()
+
+	if !exists {
+		return PositionSnapshot{}, fmt.Errorf("position %s does not exist", positionID)
+	}
+
+	return position.Snapshot(), nil
+}
+
+// GetPositionByID position of positionID, closed ones included
+func (pm *PositionManager) GetPositionByID(positionID string) (*Position, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	position, exists := pm.positionsByID[positionID]
+	if !exists {
+		return nil, fmt.Errorf("position %s does not exist", positionID)
+	}
+	return position, nil
+}
+
+// SetCrossMarginEquityProvider register account equity callback used by cross positions' margin ratio,
+// lets the margin system plug in without a circular import
+func (pm *PositionManager) SetCrossMarginEquityProvider(fn CrossMarginEquityProvider) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.crossEquityProvider = fn
+	for _, position := range pm.positionsByID {
+		position.setCrossEquityProvider(fn)
+	}
+}
+
+// SetGlobalMaintenanceMarginOverride (全域維持保證金率) emergency exchange-wide maintenance rate, every position
+// uses max(tier rate, rate). maintenance margin and liquidation price of open positions are recalculated at once
+func (pm *PositionManager) SetGlobalMaintenanceMarginOverride(rate float64) error {
+	if !(rate > 0 && rate < 1) {
+		return fmt.Errorf("maintenance margin override must be between 0 and 1, got %v", rate)
+	}
+	pm.setGlobalMaintenanceOverride(&rate)
+	return nil
+}
+
+// ClearGlobalMaintenanceMarginOverride back to tier rates, open positions are recalculated at once
+func (pm *PositionManager) ClearGlobalMaintenanceMarginOverride() {
+	pm.setGlobalMaintenanceOverride(nil)
+}
+
+func (pm *PositionManager) setGlobalMaintenanceOverride(rate *float64) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.globalMaintenanceOverride = rate
+	for _, position := range pm.positionsByID {
+		position.setMaintenanceOverride(rate)
+	}
+}
+
+// SetPositionStore persistence used by RecoverPosition
+func (pm *PositionManager) SetPositionStore(store PositionStore) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.store = store
+}
+
+// RecoverPosition (倉位恢復) put back a single position from its snapshot without a full warm up.
+// fields are restored as is, the entry is persisted before it becomes visible. the recovery hook then
+// updates the owner's account, the position stays recovered when it fails
+func (pm *PositionManager) RecoverPosition(ctx context.Context, snap PositionSnapshot) error {
+	if snap.ID == "" || snap.UserID == "" {
+		return fmt.Errorf("snapshot must have position id and user id")
+	}
+	if snap.Simulated != pm.simulated {
+		return fmt.Errorf("simulated position can not be recovered into a real manager and vice versa")
+	}
+
+	if err := pm.recoverPosition(ctx, snap); err != nil {
+		return err
+	}
+
+	pm.mu.RLock()
+	hook := pm.recoveryHook
+	pm.mu.RUnlock()
+	if hook != nil {
+		if err := hook(ctx, snap); err != nil {
+			return fmt.Errorf("account of recovered position %s: %w", snap.ID, err)
+		}
+	}
+	return nil
+}
+
+// recoverPosition persist and index the recovered position under the manager lock
+func (pm *PositionManager) recoverPosition(ctx context.Context, snap PositionSnapshot) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if !pm.symbolPositions.HasSymbol(snap.Symbol) {
+		return fmt.Errorf("%w: %s", ErrSymbolNotFound, snap.Symbol)
+	}
+	if _, exists := pm.positionsByID[snap.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrPositionAlreadyExists, snap.ID)
+	}
+
+	mode, exists := pm.mode[snap.UserID]
+	if !exists {
+		mode = OneWayMode
+	}
+	positionKey := getPositionKey(snap.Symbol, snap.Side, mode)
+	if existing, exists := pm.userPositions[snap.UserID][positionKey]; exists && existing.Size > existing.ZeroSize() {
+		return fmt.Errorf("%w: user %s already holds %s", ErrPositionAlreadyExists, snap.UserID, positionKey)
+	}
+
+	if pm.store != nil {
+		if err := pm.store.SavePosition(ctx, snap); err != nil {
+			return fmt.Errorf("save recovered position %s: %w", snap.ID, err)
+		}
+	}
+
+	position := positionFromSnapshot(snap, pm.precisionOf(snap.Symbol))
+	position.crossEquityProvider = pm.crossEquityProvider
+	position.maintenanceOverride = pm.globalMaintenanceOverride
+
+	if _, exists := pm.userPositions[snap.UserID]; !exists {
+		pm.userPositions[snap.UserID] = make(map[string]*Position)
+		pm.mode[snap.UserID] = mode
+	}
+	pm.userPositions[snap.UserID][positionKey] = position
+	pm.positionsByID[position.ID] = position
+	if err := pm.symbolPositions.AddPosition(snap.Symbol, position); err != nil {
+		return err
+	}
+	if position.Status != PositionClosed {
+		_ = pm.symbolPositions.AdjustOpenInterest(snap.Symbol, position.Side, position.Size)
+	}
+	pm.addHolder(snap.Symbol, snap.UserID)
+	pm.audit(position, AuditRecover, snap.Size, snap.EntryPrice)
+
+	return nil
+}
+
 // OpenPosition (開倉)
 func (pm *PositionManager) OpenPosition(marginMode common.MarginMode, userID, symbol string, side PositionSide, price, size float64, leverage uint) (*Position, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	if err := pm.checkExposure(symbol, true); err != nil {
+		return nil, err
+	}
+
 	// make sure userID in userPositions
 	if _, exists := pm.userPositions[userID]; !exists {
 		pm.userPositions[userID] = make(map[string]*Position)
-		pm.mode[userID] = OneWayMode // default using 單向持倉
+	}
+	if _, exists := pm.mode[userID]; !exists {
+		pm.mode[userID] = OneWayMode // default using 單向持倉, unless set before the first position
 	}
 
 	positionKey := getPositionKey(symbol, side, pm.mode[userID])
@@ -97,21 +333,31 @@ func (pm *PositionManager) OpenPosition(marginMode common.MarginMode, userID, sy
 	// check user's position is exist
 	if existingPosition, exists := pm.userPositions[userID][positionKey]; exists && existingPosition.Size > existingPosition.ZeroSize() {
 		// if existing: Add() - 加倉
-		err := existingPosition.Add(price, size)
+		_, err := pm.addPosition(existingPosition, price, size)
 		return existingPosition, err
 	} else {
 		// not exist: Open() - 開倉
-		position := NewPosition(userID, symbol, marginMode, nil)
+		if err := pm.checkMaxPositions(userID); err != nil {
+			return nil, err
+		}
+		position := NewPosition(userID, symbol, marginMode, pm.precisionOf(symbol))
+		position.crossEquityProvider = pm.crossEquityProvider
+		position.maintenanceOverride = pm.globalMaintenanceOverride
+		position.Simulated = pm.simulated
 		err := position.Open(side, price, size, int16(leverage))
 		if err != nil {
 			return nil, err
 		}
 		// add position into manager cache.
 		pm.userPositions[userID][positionKey] = position
+		pm.positionsByID[position.ID] = position
 		// add position into symbol array
 		if err = pm.symbolPositions.AddPosition(symbol, position); err != nil {
 			return nil, err
 		}
+		_ = pm.symbolPositions.AdjustOpenInterest(symbol, side, size)
+		pm.addHolder(symbol, userID)
+		pm.audit(position, AuditOpen, size, price)
 
 		return position, nil
 	}
@@ -119,44 +365,71 @@ func (pm *PositionManager) OpenPosition(marginMode common.MarginMode, userID, sy
 
 // ClosePosition (關倉/全部平倉) return PnL
 func (pm *PositionManager) ClosePosition(userID, symbol string, side PositionSide, price float64) (*Position, float64, error) {
+	if err := pm.guardReduce(symbol); err != nil {
+		return nil, 0.0, err
+	}
 	position, err := pm.GetPosition(userID, symbol, side)
 	if err != nil {
 		return position, 0.0, err
 	}
+	closeSize := position.Size
 	pnl, err := position.Close(price)
 	if err != nil {
 		return position, 0.0, err
 	}
+	_ = pm.symbolPositions.AdjustOpenInterest(symbol, position.Side, -closeSize)
+	pm.audit(position, AuditClose, closeSize, price)
 
 	// remove position from pm
-	positionKey := getPositionKey(symbol, side, pm.mode[userID])
-	if userPosition, exists := pm.userPositions[positionKey]; exists {
-		delete(userPosition, positionKey)
-	}
+	pm.removeUserPosition(userID, symbol, side, position)
 
 	return position, pnl, nil
 }
 
 // ReducePosition (減倉/部分平倉) return PnL
 func (pm *PositionManager) ReducePosition(userID, symbol string, side PositionSide, price, size float64) (*Position, float64, error) {
+	position, pnl, _, err := pm.ReducePositionWithRelease(userID, symbol, side, price, size)
+	return position, pnl, err
+}
+
+// ReducePositionWithRelease (減倉/部分平倉) return PnL and released initial margin
+func (pm *PositionManager) ReducePositionWithRelease(userID, symbol string, side PositionSide, price, size float64) (*Position, float64, float64, error) {
+	if err := pm.guardReduce(symbol); err != nil {
+		return nil, 0.0, 0.0, err
+	}
 	position, err := pm.GetPosition(userID, symbol, side)
 	if err != nil {
-		return position, 0.0, err
+		return position, 0.0, 0.0, err
 	}
-	pnl, err := position.Reduce(price, size)
+	pnl, marginReleased, err := position.ReduceWithRelease(price, size)
 	if err != nil {
-		return position, pnl, err
+		return position, pnl, 0.0, err
 	}
+	_ = pm.symbolPositions.AdjustOpenInterest(symbol, position.Side, -size)
+	pm.audit(position, AuditReduce, size, price)
 
 	if position.Status == PositionClosed {
 		// remove position from pm
-		positionKey := getPositionKey(symbol, side, pm.mode[userID])
-		if userPosition, exists := pm.userPositions[positionKey]; exists {
-			delete(userPosition, positionKey)
-		}
+		pm.removeUserPosition(userID, symbol, side, position)
 	}
 
-	return position, pnl, nil
+	return position, pnl, marginReleased, nil
+}
+
+// LiquidatePosition reduce a position claimed by the liquidation engine and keep open interest in sync
+func (pm *PositionManager) LiquidatePosition(position *Position, price, size float64) (float64, float64, error) {
+	return pm.LiquidatePositionAs("", position, price, size)
+}
+
+// LiquidatePositionAs LiquidatePosition fenced by the claim owner (Position.ReduceForLiquidationAs)
+func (pm *PositionManager) LiquidatePositionAs(owner string, position *Position, price, size float64) (float64, float64, error) {
+	pnl, marginReleased, err := position.ReduceForLiquidationAs(owner, price, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	_ = pm.symbolPositions.AdjustOpenInterest(position.Symbol, position.Side, -size)
+	pm.audit(position, AuditLiquidate, size, price)
+	return pnl, marginReleased, nil
 }
 
 // UpdateMarkPrices batch update mark price - input prices (symbol: markPrice)
@@ -164,19 +437,164 @@ func (pm *PositionManager) UpdateMarkPrices(symbol string, price float64) ([]*Po
 	return pm.symbolPositions.UpdateMarkPrice(symbol, price)
 }
 
-// GetLiquidatablePositions (取得所有可強平倉位)
-func (pm *PositionManager) GetLiquidatablePositions() []*Position {
+// UseLiquidationIndex (強平索引) UpdateMarkPrices only inspects positions whose liquidation price the mark
+// crossed instead of scanning every position. positions far from liquidation keep their previous mark price,
+// unrealized PnL and position value until RefreshMarkPrices.
+func (pm *PositionManager) UseLiquidationIndex(enabled bool) {
+	pm.symbolPositions.SetIndexed(enabled)
+}
+
+// RefreshMarkPrices push the latest mark price to every position of the symbol, return liquidateList
+func (pm *PositionManager) RefreshMarkPrices(symbol string) ([]*Position, error) {
+	return pm.symbolPositions.RefreshMarkPrice(symbol)
+}
+
+// GetSymbolMarkPrice latest mark price recorded by UpdateMarkPrices, 0 if none yet or unknown symbol
+func (pm *PositionManager) GetSymbolMarkPrice(symbol string) float64 {
+	price, _ := pm.symbolPositions.GetMarkPrice(symbol)
+	return price
+}
+
+// GetSymbolMarkPrices latest mark price of every symbol that had one, a copy
+func (pm *PositionManager) GetSymbolMarkPrices() map[string]float64 {
+	return pm.symbolPositions.GetMarkPrices()
+}
+
+// GetMarkPriceHistory the last limit mark prices of the symbol (at most MarkPriceHistorySize), oldest first.
+// nil for an unknown symbol
+func (pm *PositionManager) GetMarkPriceHistory(symbol string, limit int) []PricePoint {
+	history, _ := pm.symbolPositions.GetMarkPriceHistory(symbol, limit)
+	return history
+}
+
+// GetOpenInterest (未平倉量) total open long size of the symbol, equals the short side in a matched market
+func (pm *PositionManager) GetOpenInterest(symbol string) (float64, error) {
+	long, _, err := pm.symbolPositions.GetOpenInterest(symbol)
+	return long, err
+}
+
+// GetOpenInterestBySide total open long and short size of the symbol
+func (pm *PositionManager) GetOpenInterestBySide(symbol string) (long float64, short float64, err error) {
+	return pm.symbolPositions.GetOpenInterest(symbol)
+}
+
+// SymbolOpenInterest open interest of one symbol in notional (size × mark price)
+type SymbolOpenInterest struct {
+	Symbol        string  `json:"symbol"`
+	LongNotional  float64 `json:"long_notional"`
+	ShortNotional float64 `json:"short_notional"`
+	TotalNotional float64 `json:"total_notional"`
+	PositionCount int     `json:"position_count"`
+}
+
+// GetTopSymbolsByOpenInterest top n symbols by total notional open interest, computed in a single pass
+// over every user's positions. ties are ordered by symbol.
+func (pm *PositionManager) GetTopSymbolsByOpenInterest(n int) []SymbolOpenInterest {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	var liquidatable []*Position
+	bySymbol := make(map[string]*SymbolOpenInterest)
+	for _, symbol := range pm.symbolPositions.GetAllSymbols() {
+		bySymbol[symbol] = &SymbolOpenInterest{Symbol: symbol}
+	}
 	for _, userPositions := range pm.userPositions {
-		liquidatable = append(liquidatable, userPositions.getLiquidatablePositions()...)
+		for _, pos := range userPositions {
+			snapshot := pos.Snapshot()
+			if snapshot.Status == PositionClosed || snapshot.Size <= 0 {
+				continue
+			}
+			oi, ok := bySymbol[snapshot.Symbol]
+			if !ok {
+				oi = &SymbolOpenInterest{Symbol: snapshot.Symbol}
+				bySymbol[snapshot.Symbol] = oi
+			}
+			if snapshot.Side == LONG {
+				oi.LongNotional += snapshot.PositionValue
+			} else {
+				oi.ShortNotional += snapshot.PositionValue
+			}
+			oi.TotalNotional += snapshot.PositionValue
+			oi.PositionCount++
+		}
+	}
+
+	result := make([]SymbolOpenInterest, 0, len(bySymbol))
+	for _, oi := range bySymbol {
+		result = append(result, *oi)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalNotional != result[j].TotalNotional {
+			return result[i].TotalNotional > result[j].TotalNotional
+		}
+		return result[i].Symbol < result[j].Symbol
+	})
+
+	if n < len(result) {
+		result = result[:max(n, 0)]
+	}
+	return result
+}
+
+// GetSymbolPositions open positions of one symbol across all users
+func (pm *PositionManager) GetSymbolPositions(symbol string) ([]*Position, error) {
+	positions, err := pm.symbolPositions.GetPositions(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	open := make([]*Position, 0, len(positions))
+	for _, pos := range positions {
+		if snapshot := pos.Snapshot(); snapshot.Status != PositionClosed && snapshot.Size > 0 {
+			open = append(open, pos)
+		}
+	}
+	return open, nil
+}
+
+// Subscribe start tracking positions of a new symbol
+func (pm *PositionManager) Subscribe(symbol string) {
+	pm.symbolPositions.AddSymbol(symbol)
+}
+
+// GetAllSymbols all symbols the manager knows about, sorted
+func (pm *PositionManager) GetAllSymbols() []string {
+	return pm.symbolPositions.GetAllSymbols()
+}
+
+// HasSymbol
+func (pm *PositionManager) HasSymbol(symbol string) bool {
+	return pm.symbolPositions.HasSymbol(symbol)
+}
+
+// GetLiquidatablePositions (取得所有可強平倉位)
+//
+// Deprecated: use GetAllLiquidatablePositions, grouped by symbol in one pass.
+func (pm *PositionManager) GetLiquidatablePositions() []*Position {
+	var liquidatable []*Position
+	for _, position := range pm.allPositions() {
+		if position.IsLiquidatable() {
+			liquidatable = append(liquidatable, position)
+		}
 	}
 
 	return liquidatable
 }
 
+// allPositions every user's positions, copied under pm.mu: cross positions are evaluated outside of it,
+// their equity provider reads the user's positions back
+func (pm *PositionManager) allPositions() []*Position {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var positions []*Position
+	for _, userPositions := range pm.userPositions {
+		for _, position := range userPositions {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
+
 // SetPositionMode (設定雙向/單向持倉)
 func (pm *PositionManager) SetPositionMode(userID string, mode PositionMode) error {
 	pm.mu.Lock()
@@ -194,7 +612,17 @@ func (pm *PositionManager) SetPositionMode(userID string, mode PositionMode) err
 	return nil
 }
 
+// GetPositionMode mode of the user's positions, OneWayMode until set
+func (pm *PositionManager) GetPositionMode(userID string) PositionMode {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.mode[userID] // zero value OneWayMode
+}
+
 func (pm *PositionManager) GetUserPositions(userID string) ([]*Position, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
 	if userPositions, exists := pm.userPositions[userID]; exists {
 		positions := make([]*Position, 0, len(userPositions))
 		for _, position := range userPositions {
@@ -206,10 +634,191 @@ func (pm *PositionManager) GetUserPositions(userID string) ([]*Position, error)
 	}
 }
 
+// GetUserPositionIDs ids of the user's current positions, no position data copied. nil for an unknown user
+func (pm *PositionManager) GetUserPositionIDs(userID string) []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	userPositions, exists := pm.userPositions[userID]
+	if !exists {
+		return nil
+	}
+	ids := make([]string, 0, len(userPositions))
+	for _, position := range userPositions {
+		ids = append(ids, position.ID)
+	}
+	return ids
+}
+
+// PositionExists whether the id index knows the position. closed positions stay known until Defragment
+func (pm *PositionManager) PositionExists(positionID string) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	_, exists := pm.positionsByID[positionID]
+	return exists
+}
+
+// ForEachUser call fn with every user and a copy of their position slice, in user id order, until fn
+// returns false. users are collected under the read lock and fn runs without it, so fn may open or
+// close positions; users added meanwhile are not visited.
+func (pm *PositionManager) ForEachUser(fn func(userID string, positions []*Position) bool) {
+	userIDs, positions := pm.usersSnapshot()
+	for i, userID := range userIDs {
+		if !fn(userID, positions[i]) {
+			return
+		}
+	}
+}
+
+// ForEachUserParallel ForEachUser on workers goroutines, every user is visited even when fn fails.
+// return the errors of fn joined in user id order
+func (pm *PositionManager) ForEachUserParallel(workers int, fn func(userID string, positions []*Position) error) error {
+	userIDs, positions := pm.usersSnapshot()
+	errs := make([]error, len(userIDs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(userIDs[i], positions[i]); err != nil {
+					errs[i] = fmt.Errorf("user %s: %w", userIDs[i], err)
+				}
+			}
+		}()
+	}
+	for i := range userIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// usersSnapshot user ids sorted, with a copy of each user's positions
+func (pm *PositionManager) usersSnapshot() ([]string, [][]*Position) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	userIDs := make([]string, 0, len(pm.userPositions))
+	for userID := range pm.userPositions {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	positions := make([][]*Position, len(userIDs))
+	for i, userID := range userIDs {
+		userPositions := pm.userPositions[userID]
+		positions[i] = make([]*Position, 0, len(userPositions))
+		for _, position := range userPositions {
+			positions[i] = append(positions[i], position)
+		}
+	}
+	return userIDs, positions
+}
+
+// HealthCheck (一致性檢查) invariants that must hold at any instant, even under concurrent updates.
+// return one error per violation, nil when healthy
+func (pm *PositionManager) HealthCheck() []error {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var violations []error
+	for userID, userPositions := range pm.userPositions {
+		for key, pos := range userPositions {
+			if indexed, exists := pm.positionsByID[pos.ID]; !exists || indexed != pos {
+				violations = append(violations, fmt.Errorf("position %s of user %s (%s) missing from id index", pos.ID, userID, key))
+			}
+			if pos.UserID != userID {
+				violations = append(violations, fmt.Errorf("position %s filed under user %s, owned by %s", pos.ID, userID, pos.UserID))
+			}
+
+			snapshot := pos.Snapshot()
+			switch {
+			case snapshot.Size < 0:
+				violations = append(violations, fmt.Errorf("position %s has negative size %f", pos.ID, snapshot.Size))
+			case snapshot.Status == PositionClosed && snapshot.Size > 0:
+				violations = append(violations, fmt.Errorf("closed position %s still has size %f", pos.ID, snapshot.Size))
+			case snapshot.Status == PositionNormal && snapshot.Size > 0 && (snapshot.EntryPrice <= 0 || snapshot.InitialMargin <= 0):
+				violations = append(violations, fmt.Errorf("open position %s has entry price %f, initial margin %f",
+					pos.ID, snapshot.EntryPrice, snapshot.InitialMargin))
+			}
+		}
+	}
+
+	return violations
+}
+
+// Defragment (整理) replace the empty position maps of users whose positions were all closed with fresh ones,
+// so the GC can free the old bucket arrays, and rebuild the id index from the open positions only
+// (closed positions are no longer found by id afterwards). return the number of maps replaced.
+// meant for idle periods, it holds the write lock for a full pass.
+func (pm *PositionManager) Defragment() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	defragmented := 0
+	positionsByID := make(map[string]*Position, len(pm.positionsByID))
+	for userID, userPositions := range pm.userPositions {
+		if len(userPositions) == 0 {
+			pm.userPositions[userID] = make(UserPositions)
+			defragmented++
+			continue
+		}
+		for _, pos := range userPositions {
+			positionsByID[pos.ID] = pos
+		}
+	}
+	pm.positionsByID = positionsByID
+
+	return defragmented
+}
+
 // ============================================================================================================
 // private func
 // ============================================================================================================
 
+// runBackground run fn in a managed goroutine, ctx is cancelled by Close
+func (pm *PositionManager) runBackground(fn func(ctx context.Context) error) error {
+	pm.bgMu.Lock()
+	defer pm.bgMu.Unlock()
+
+	if pm.closed {
+		return fmt.Errorf("position manager already closed")
+	}
+
+	pm.wg.Add(1)
+	go func() {
+		defer pm.wg.Done()
+		if err := fn(pm.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			pm.bgMu.Lock()
+			pm.bgErrors = append(pm.bgErrors, err)
+			pm.bgMu.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// removeUserPosition drop a closed position from the user's slot, unless the slot was reopened meanwhile
+func (pm *PositionManager) removeUserPosition(userID, symbol string, side PositionSide, position *Position) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	userPositions, exists := pm.userPositions[userID]
+	if !exists {
+		return
+	}
+	positionKey := getPositionKey(symbol, side, pm.mode[userID])
+	if userPositions[positionKey] == position {
+		delete(userPositions, positionKey)
+	}
+}
+
 // getPositionKey get position key by symbol, side, mode
 func getPositionKey(symbol string, side PositionSide, mode PositionMode) string {
 	switch mode {
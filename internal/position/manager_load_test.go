@@ -0,0 +1,30 @@
+package position_test
+
+import (
+	"frizo/futures_engine/internal/testutil"
+	"testing"
+)
+
+func TestPositionManagerLoadTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("10s load test")
+	}
+
+	const symbols, goroutines = 3, 32
+	result := testutil.LoadTestPositionManager(symbols, goroutines, 10)
+	t.Logf("ops=%d errors=%d throughput=%.0f/s max_open=%d final_open=%d",
+		result.TotalOperations, result.ErrorCount, result.Throughput, result.MaxOpenPositions, result.FinalPositionCount)
+
+	if result.TotalOperations == 0 {
+		t.Fatal("no operations executed")
+	}
+	if rate := float64(result.ErrorCount) / float64(result.TotalOperations); rate >= 0.001 {
+		t.Errorf("error rate %.5f >= 0.001", rate)
+	}
+	if result.DataRaceDetected {
+		t.Error("health check violations")
+	}
+	if result.FinalPositionCount > symbols*goroutines {
+		t.Errorf("final open positions %d exceed one per user per symbol", result.FinalPositionCount)
+	}
+}
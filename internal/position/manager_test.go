@@ -1,10 +1,21 @@
 package position
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/logger"
 	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var symbols = []string{"BTCUSDT", "ETHUSDT"}
@@ -357,3 +368,1173 @@ func ExamplePositionManager() {
 		fmt.Printf("警告：倉位面臨強平風險！\n")
 	}
 }
+
+// TestPositionManagerClose background goroutines must all exit after Close
+func TestPositionManagerClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 100; i++ {
+		pm := NewPositionManager(symbols)
+		for j := 0; j < 3; j++ {
+			err := pm.runBackground(func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, pm.Close())
+	}
+
+	// 給 runtime 一點時間回收 goroutine
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestPositionManagerCloseErrors(t *testing.T) {
+	pm := NewPositionManager(symbols)
+
+	err := pm.runBackground(func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("flush failed")
+	})
+	assert.NoError(t, err)
+
+	err = pm.Close()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "flush failed")
+
+	// 關閉後不能再次關閉，也不能再啟動背景工作
+	assert.Error(t, pm.Close())
+	assert.Error(t, pm.runBackground(func(ctx context.Context) error { return nil }))
+}
+
+// TestOpenInterestConsistency 增量維護的未平倉量要等於全量掃描的結果
+func TestOpenInterestConsistency(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	rnd := rand.New(rand.NewSource(1))
+	sides := []PositionSide{LONG, SHORT}
+
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			assert.NoError(t, pm.SetPositionMode(fmt.Sprintf("user_%d", i), HedgeMode))
+		}
+	}
+
+	for i := 0; i < 5000; i++ {
+		userID := fmt.Sprintf("user_%d", rnd.Intn(20))
+		symbol := symbols[rnd.Intn(len(symbols))]
+		side := sides[rnd.Intn(2)]
+		price := 1000 + float64(rnd.Intn(100))
+
+		switch rnd.Intn(4) {
+		case 0, 1:
+			_, _ = pm.OpenPosition(common.ISOLATED, userID, symbol, side, price, float64(1+rnd.Intn(10)), 10)
+		case 2:
+			pos, err := pm.GetPosition(userID, symbol, side)
+			if err == nil && pos.Size > pos.ZeroSize() {
+				_, _, _ = pm.ReducePosition(userID, symbol, side, price, pos.Size/2)
+			}
+		case 3:
+			pos, err := pm.GetPosition(userID, symbol, side)
+			if err == nil && pos.Status == PositionNormal {
+				_, _, _ = pm.ClosePosition(userID, symbol, side, price)
+			}
+		}
+	}
+
+	for _, symbol := range symbols {
+		scanLong, scanShort := 0.0, 0.0
+		for _, pos := range pm.positionsByID {
+			if pos.Symbol != symbol || pos.Status == PositionClosed {
+				continue
+			}
+			if pos.Side == LONG {
+				scanLong += pos.Size
+			} else {
+				scanShort += pos.Size
+			}
+		}
+
+		long, short, err := pm.GetOpenInterestBySide(symbol)
+		assert.NoError(t, err)
+		assert.InDelta(t, scanLong, long, 1e-6, symbol)
+		assert.InDelta(t, scanShort, short, 1e-6, symbol)
+
+		oi, err := pm.GetOpenInterest(symbol)
+		assert.NoError(t, err)
+		assert.Equal(t, long, oi)
+	}
+
+	_, err := pm.GetOpenInterest("UNKNOWN")
+	assert.Error(t, err)
+}
+
+func TestGetAllSymbols(t *testing.T) {
+	pm := NewPositionManager([]string{"SOLUSDT", "BTCUSDT", "XRPUSDT", "ETHUSDT", "BNBUSDT"})
+
+	assert.True(t, pm.HasSymbol("BTCUSDT"))
+	assert.False(t, pm.HasSymbol("DOGEUSDT"))
+
+	pm.Subscribe("DOGEUSDT")
+	assert.True(t, pm.HasSymbol("DOGEUSDT"))
+
+	assert.Equal(t, []string{"BNBUSDT", "BTCUSDT", "DOGEUSDT", "ETHUSDT", "SOLUSDT", "XRPUSDT"}, pm.GetAllSymbols())
+
+	// 重複訂閱不影響
+	pm.Subscribe("DOGEUSDT")
+	assert.Len(t, pm.GetAllSymbols(), 6)
+}
+
+type recordingStore struct {
+	saved []PositionSnapshot
+	err   error
+}
+
+func (s *recordingStore) SavePosition(ctx context.Context, snapshot PositionSnapshot) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.saved = append(s.saved, snapshot)
+	return nil
+}
+
+func (s *recordingStore) ListSnapshots(userID string) ([]PositionSnapshot, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var snapshots []PositionSnapshot
+	for _, snapshot := range s.saved {
+		if snapshot.UserID == userID {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+func TestRecoverPosition(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	store := &recordingStore{}
+	pm.SetPositionStore(store)
+
+	openTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := PositionSnapshot{
+		ID:                "pos-recovered",
+		UserID:            "user1",
+		Symbol:            "BTCUSDT",
+		Side:              SHORT,
+		Status:            PositionNormal,
+		Size:              0.5,
+		EntryPrice:        60000,
+		MarkPrice:         59000,
+		PositionValue:     29500,
+		LiquidationPrice:  65700,
+		InitialMargin:     3000,
+		MaintenanceMargin: 118,
+		Leverage:          10,
+		MarginMode:        common.ISOLATED,
+		RealizedPnL:       -123.45,
+		UnrealizedPnL:     500,
+		OpenTime:          openTime,
+		UpdateTime:        openTime.Add(time.Hour),
+	}
+
+	assert.NoError(t, pm.RecoverPosition(context.Background(), snapshot))
+	position, err := pm.GetPositionByID("pos-recovered")
+	assert.NoError(t, err)
+
+	got := position.Snapshot()
+	got.SnapshotTimestamp = snapshot.SnapshotTimestamp
+	assert.Equal(t, snapshot, got)
+
+	// visible through every index
+	byKey, err := pm.GetPosition("user1", "BTCUSDT", SHORT)
+	assert.NoError(t, err)
+	assert.Same(t, position, byKey)
+	_, err = pm.GetPositionSnapshot("pos-recovered")
+	assert.NoError(t, err)
+	_, short, _ := pm.GetOpenInterestBySide("BTCUSDT")
+	assert.Equal(t, 0.5, short)
+
+	assert.Equal(t, []PositionSnapshot{snapshot}, store.saved)
+
+	err = pm.RecoverPosition(context.Background(), snapshot)
+	assert.ErrorIs(t, err, ErrPositionAlreadyExists)
+	assert.Len(t, store.saved, 1)
+}
+
+func TestRecoverPositionStoreFailure(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	pm.SetPositionStore(&recordingStore{err: fmt.Errorf("disk full")})
+
+	err := pm.RecoverPosition(context.Background(), PositionSnapshot{ID: "p1", UserID: "user1", Symbol: "BTCUSDT", Side: LONG, Size: 1, Leverage: 10})
+	assert.ErrorContains(t, err, "disk full")
+
+	// nothing half inserted
+	_, err = pm.GetPositionSnapshot("p1")
+	assert.Error(t, err)
+}
+
+func TestRecoverPositionHook(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	var hooked []PositionSnapshot
+	pm.SetRecoveryHook(func(ctx context.Context, snapshot PositionSnapshot) error {
+		_, err := pm.GetPositionSnapshot(snapshot.ID) // visible to the hook
+		assert.NoError(t, err)
+		hooked = append(hooked, snapshot)
+		if snapshot.UserID == "ghost" {
+			return fmt.Errorf("no account")
+		}
+		return nil
+	})
+
+	snapshot := PositionSnapshot{ID: "p1", UserID: "user1", Symbol: "BTCUSDT", Side: LONG, Size: 1, Leverage: 10}
+	assert.NoError(t, pm.RecoverPosition(context.Background(), snapshot))
+	assert.Equal(t, []PositionSnapshot{snapshot}, hooked)
+
+	err := pm.RecoverPosition(context.Background(), PositionSnapshot{ID: "p2", UserID: "ghost", Symbol: "BTCUSDT", Side: LONG, Size: 1, Leverage: 10})
+	assert.ErrorContains(t, err, "no account")
+	assert.Len(t, hooked, 2)
+}
+
+func TestGetPositionAgeDistribution(t *testing.T) {
+	pm := NewPositionManager(symbols)
+
+	ages := []time.Duration{time.Hour, 2 * time.Hour, 3 * 24 * time.Hour, 10 * 24 * time.Hour, 45 * 24 * time.Hour}
+	for i, age := range ages {
+		position, err := pm.OpenPosition(common.ISOLATED, fmt.Sprintf("user%d", i), "BTCUSDT", LONG, 50000, 1, 10)
+		assert.NoError(t, err)
+		position.OpenTime = time.Now().Add(-age)
+	}
+	_, _, err := pm.ClosePosition("user0", "BTCUSDT", LONG, 50000)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]int{
+		"intraday":    1,
+		"short-term":  1,
+		"medium-term": 1,
+		"long-term":   1,
+	}, pm.GetPositionAgeDistribution())
+}
+
+func TestAlertOnStalledPositions(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	position, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+
+	var alerts [][]*Position
+	watcher := &stallWatcher{
+		maxAge:  time.Minute,
+		fn:      func(stalled []*Position) { alerts = append(alerts, stalled) },
+		alerted: make(map[string]time.Time),
+	}
+
+	// not stalled yet
+	pm.checkStalledPositions(watcher, time.Now())
+	assert.Empty(t, alerts)
+
+	// no updates for maxAge: fires once
+	later := time.Now().Add(2 * time.Minute)
+	pm.checkStalledPositions(watcher, later)
+	pm.checkStalledPositions(watcher, later.Add(time.Minute))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, []*Position{position}, alerts[0])
+
+	// touched by a mark price update: re-armed
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 50100)
+	assert.NoError(t, err)
+	pm.checkStalledPositions(watcher, time.Now())
+	assert.Len(t, alerts, 1)
+	pm.checkStalledPositions(watcher, time.Now().Add(2*time.Minute))
+	assert.Len(t, alerts, 2)
+
+	// background loop
+	fired := make(chan []*Position, 10)
+	assert.NoError(t, pm.AlertOnStalledPositions(10*time.Millisecond, func(stalled []*Position) {
+		fired <- stalled
+	}))
+	select {
+	case stalled := <-fired:
+		assert.Equal(t, []*Position{position}, stalled)
+	case <-time.After(time.Second):
+		t.Fatal("stalled position alert did not fire")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, fired, "alert fires once per position")
+}
+
+func TestGetTopSymbolsByOpenInterest(t *testing.T) {
+	var topSymbols []string
+	for i := 0; i < 10; i++ {
+		topSymbols = append(topSymbols, fmt.Sprintf("SYM%dUSDT", i))
+	}
+	pm := NewPositionManager(topSymbols)
+
+	// notional of SYMi: long i*1000 + short i*500 (SYM0 has none)
+	for i, symbol := range topSymbols[1:] {
+		notional := float64(i+1) * 1000
+		_, err := pm.OpenPosition(common.ISOLATED, "long_user", symbol, LONG, 100, notional/100, 10)
+		assert.NoError(t, err)
+		_, err = pm.OpenPosition(common.ISOLATED, "short_user", symbol, SHORT, 100, notional/200, 10)
+		assert.NoError(t, err)
+	}
+	// closed positions do not count
+	_, err := pm.OpenPosition(common.ISOLATED, "user3", "SYM0USDT", LONG, 100, 1000, 10)
+	assert.NoError(t, err)
+	_, _, err = pm.ClosePosition("user3", "SYM0USDT", LONG, 100)
+	assert.NoError(t, err)
+
+	top := pm.GetTopSymbolsByOpenInterest(3)
+	assert.Equal(t, []SymbolOpenInterest{
+		{Symbol: "SYM9USDT", LongNotional: 9000, ShortNotional: 4500, TotalNotional: 13500, PositionCount: 2},
+		{Symbol: "SYM8USDT", LongNotional: 8000, ShortNotional: 4000, TotalNotional: 12000, PositionCount: 2},
+		{Symbol: "SYM7USDT", LongNotional: 7000, ShortNotional: 3500, TotalNotional: 10500, PositionCount: 2},
+	}, top)
+
+	all := pm.GetTopSymbolsByOpenInterest(100)
+	assert.Len(t, all, 10)
+	assert.Equal(t, SymbolOpenInterest{Symbol: "SYM0USDT"}, all[9])
+	assert.Empty(t, pm.GetTopSymbolsByOpenInterest(0))
+}
+
+func TestDefragment(t *testing.T) {
+	pm := NewPositionManager(symbols)
+
+	for i := 0; i < 100; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		closed, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", LONG, 50000, 1, 10)
+		assert.NoError(t, err)
+		_, _, err = pm.ClosePosition(userID, "BTCUSDT", LONG, 50000)
+		assert.NoError(t, err)
+
+		// closed positions stay in the id index until defragmented
+		_, err = pm.GetPositionSnapshot(closed.ID)
+		assert.NoError(t, err)
+	}
+	open, err := pm.OpenPosition(common.ISOLATED, "user0", "ETHUSDT", SHORT, 3000, 1, 10)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 99, pm.Defragment())
+	assert.Len(t, pm.positionsByID, 1)
+	_, err = pm.GetPositionSnapshot(open.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, pm.HealthCheck())
+
+	// positions still open after defragmenting
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pm.HealthCheck())
+}
+
+func TestGetUserPositionIDs(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	assert.Nil(t, pm.GetUserPositionIDs("user1"))
+
+	btc, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	eth, err := pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", SHORT, 3000, 1, 10)
+	assert.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "user2", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{btc.ID, eth.ID}, pm.GetUserPositionIDs("user1"))
+	assert.True(t, pm.PositionExists(btc.ID))
+	assert.False(t, pm.PositionExists("unknown"))
+
+	_, _, err = pm.ClosePosition("user1", "BTCUSDT", LONG, 50000)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{eth.ID}, pm.GetUserPositionIDs("user1"))
+
+	// closed but still indexed until defragmented
+	assert.True(t, pm.PositionExists(btc.ID))
+	pm.Defragment()
+	assert.False(t, pm.PositionExists(btc.ID))
+	assert.True(t, pm.PositionExists(eth.ID))
+}
+
+func TestGetRealizedPnLSummary(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	store := &recordingStore{}
+	pm.SetPositionStore(store)
+
+	day1 := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	day2, day3 := day1.AddDate(0, 0, 1), day1.AddDate(0, 0, 2)
+	saveAt := func(pos *Position, at time.Time) {
+		snapshot := pos.Snapshot()
+		snapshot.UpdateTime = at
+		assert.NoError(t, store.SavePosition(context.Background(), snapshot))
+	}
+
+	// BTC: +1000 on day 1, closed on day 2 with -500
+	btc, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	saveAt(btc, day1)
+	_, _, err = pm.ReducePosition("user1", "BTCUSDT", LONG, 52000, 0.5)
+	assert.NoError(t, err)
+	btc.AddTradingFee(10)
+	saveAt(btc, day1.Add(3*time.Hour))
+	_, _, err = pm.ClosePosition("user1", "BTCUSDT", LONG, 49000)
+	assert.NoError(t, err)
+	btc.AddTradingFee(5)
+	saveAt(btc, day2)
+
+	// ETH: opened day 2, closed day 3 with +1000
+	eth, err := pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", SHORT, 3000, 10, 10)
+	assert.NoError(t, err)
+	saveAt(eth, day2.Add(time.Hour))
+	_, _, err = pm.ClosePosition("user1", "ETHUSDT", SHORT, 2900)
+	assert.NoError(t, err)
+	eth.AddTradingFee(20)
+	saveAt(eth, day3)
+
+	// BTC again: opened and closed on day 3 with -200
+	again, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 0.1, 10)
+	assert.NoError(t, err)
+	saveAt(again, day3)
+	_, _, err = pm.ClosePosition("user1", "BTCUSDT", LONG, 48000)
+	assert.NoError(t, err)
+	saveAt(again, day3.Add(time.Hour))
+
+	// someone else's history is never counted
+	other, err := pm.OpenPosition(common.ISOLATED, "user2", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	_, _, err = pm.ClosePosition("user2", "BTCUSDT", LONG, 60000)
+	assert.NoError(t, err)
+	saveAt(other, day2)
+
+	summary, err := pm.GetRealizedPnLSummary("user1", day1, day3.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1300.0, summary.TotalRealizedPnL, 1e-9)
+	assert.InDelta(t, 35.0, summary.TotalTradingFees, 1e-9)
+	assert.InDelta(t, 1265.0, summary.NetPnLAfterFees, 1e-9)
+
+	assert.InDelta(t, 300.0, summary.BySymbol["BTCUSDT"], 1e-9)
+	assert.InDelta(t, 1000.0, summary.BySymbol["ETHUSDT"], 1e-9)
+	assert.InDelta(t, 1000.0, summary.ByDate["2025-03-01"], 1e-9)
+	assert.InDelta(t, -500.0, summary.ByDate["2025-03-02"], 1e-9)
+	assert.InDelta(t, 800.0, summary.ByDate["2025-03-03"], 1e-9)
+
+	bySymbol, byDate := 0.0, 0.0
+	for _, pnl := range summary.BySymbol {
+		bySymbol += pnl
+	}
+	for _, pnl := range summary.ByDate {
+		byDate += pnl
+	}
+	assert.InDelta(t, summary.TotalRealizedPnL, bySymbol, 1e-9)
+	assert.InDelta(t, summary.TotalRealizedPnL, byDate, 1e-9)
+
+	// period from day 2: the day 1 partial close is excluded
+	summary, err = pm.GetRealizedPnLSummary("user1", day2, day3.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 300.0, summary.TotalRealizedPnL, 1e-9)
+	assert.InDelta(t, -700.0, summary.BySymbol["BTCUSDT"], 1e-9)
+	assert.NotContains(t, summary.ByDate, "2025-03-01")
+
+	store.err = fmt.Errorf("disk gone")
+	_, err = pm.GetRealizedPnLSummary("user1", day1, day3)
+	assert.ErrorContains(t, err, "disk gone")
+	_, err = NewPositionManager(symbols).GetRealizedPnLSummary("user1", day1, day3)
+	assert.Error(t, err)
+}
+
+func TestForEachUser(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	for i := 0; i < 200; i++ {
+		userID := fmt.Sprintf("user_%03d", i)
+		_, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", LONG, 50000, 1, 10)
+		assert.NoError(t, err)
+		if i%3 == 0 {
+			_, err = pm.OpenPosition(common.ISOLATED, userID, "ETHUSDT", SHORT, 3000, 1, 10)
+			assert.NoError(t, err)
+		}
+	}
+
+	// serial: every user once, in id order
+	visits := make(map[string]int)
+	serial := make(map[string]float64)
+	var order []string
+	pm.ForEachUser(func(userID string, positions []*Position) bool {
+		visits[userID]++
+		order = append(order, userID)
+		for _, pos := range positions {
+			serial[userID] += pos.Snapshot().Size
+		}
+		positions[0] = nil // a copy, the manager is not affected
+		return true
+	})
+	assert.Len(t, visits, 200)
+	for userID, count := range visits {
+		assert.Equal(t, 1, count, userID)
+	}
+	assert.Equal(t, "user_000", order[0])
+	assert.Equal(t, "user_199", order[199])
+	userPositions, err := pm.GetUserPositions("user_000")
+	assert.NoError(t, err)
+	assert.Len(t, userPositions, 2)
+	assert.NotContains(t, userPositions, (*Position)(nil))
+
+	// stops when fn returns false
+	visited := 0
+	pm.ForEachUser(func(string, []*Position) bool {
+		visited++
+		return visited < 10
+	})
+	assert.Equal(t, 10, visited)
+
+	// parallel: same results as the serial pass
+	var mu sync.Mutex
+	parallel := make(map[string]float64)
+	err = pm.ForEachUserParallel(8, func(userID string, positions []*Position) error {
+		total := 0.0
+		for _, pos := range positions {
+			total += pos.Snapshot().Size
+		}
+		mu.Lock()
+		parallel[userID] += total
+		mu.Unlock()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, serial, parallel)
+
+	// errors are aggregated, every user still visited
+	var calls atomic.Int64
+	err = pm.ForEachUserParallel(4, func(userID string, positions []*Position) error {
+		calls.Add(1)
+		if len(positions) > 1 {
+			return fmt.Errorf("two positions")
+		}
+		return nil
+	})
+	assert.Equal(t, int64(200), calls.Load())
+	assert.ErrorContains(t, err, "user user_000: two positions")
+	assert.ErrorContains(t, err, "user user_198: two positions")
+	assert.NotContains(t, err.Error(), "user_001")
+}
+
+func TestGetConsolidatedPositions(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	assert.NoError(t, pm.SetPositionMode("hedger", HedgeMode))
+	_, err := pm.OpenPosition(common.ISOLATED, "hedger", "BTCUSDT", LONG, 50000, 2.0, 10)
+	assert.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "hedger", "BTCUSDT", SHORT, 52000, 0.5, 10)
+	assert.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 51000)
+	assert.NoError(t, err)
+
+	consolidated := pm.GetConsolidatedPositions("hedger", "BTCUSDT")
+	assert.Equal(t, "BTCUSDT", consolidated.Symbol)
+	assert.InDelta(t, 1.5, consolidated.NetSize, 1e-12)
+	assert.Equal(t, LONG, consolidated.NetSide)
+	// long +2000, short +500
+	assert.InDelta(t, 2500, consolidated.TotalUnrealizedPnL, 1e-9)
+	assert.InDelta(t, consolidated.LongLeg.UnrealizedPnL+consolidated.ShortLeg.UnrealizedPnL, consolidated.TotalUnrealizedPnL, 1e-9)
+	assert.InDelta(t, (2*50000+0.5*52000)/2.5, consolidated.WeightedEntryPrice, 1e-9)
+	assert.InDelta(t, 10000+2600, consolidated.TotalInitialMargin, 1e-9)
+
+	// one-way: the single position
+	_, err = pm.OpenPosition(common.ISOLATED, "oneway", "BTCUSDT", SHORT, 50000, 1.0, 10)
+	assert.NoError(t, err)
+	consolidated = pm.GetConsolidatedPositions("oneway", "BTCUSDT")
+	assert.Equal(t, -1.0, consolidated.NetSize)
+	assert.Equal(t, SHORT, consolidated.NetSide)
+	assert.Nil(t, consolidated.LongLeg)
+	assert.NotNil(t, consolidated.ShortLeg)
+	assert.Equal(t, 50000.0, consolidated.WeightedEntryPrice)
+
+	// nothing open
+	flat := pm.GetConsolidatedPositions("oneway", "ETHUSDT")
+	assert.Zero(t, flat.NetSize)
+	assert.Zero(t, flat.NetSide)
+	assert.Nil(t, flat.LongLeg)
+	assert.Nil(t, flat.ShortLeg)
+}
+
+func TestPreLiquidationWarning(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+	pm.EnablePreLiquidationWarnings(nil)
+
+	pos, err := pm.OpenPosition(common.ISOLATED, "warned", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	events, cancel := pm.SubscribeEvents(16) // after the open event
+	defer cancel()
+	liquidationPrice := pos.Snapshot().LiquidationPrice
+
+	walk := func(from, to float64, steps int) {
+		for i := 0; i <= steps; i++ {
+			_, err := pm.UpdateMarkPrices("BTCUSDT", from+(to-from)*float64(i)/float64(steps))
+			assert.NoError(t, err)
+		}
+	}
+	drain := func() []PositionEvent {
+		var received []PositionEvent
+		for {
+			select {
+			case event := <-events:
+				received = append(received, event)
+			default:
+				return received
+			}
+		}
+	}
+
+	// down towards liquidation, then jittering below the re-arm level: one warning
+	walk(50000, liquidationPrice*1.001, 200)
+	received := drain()
+	if assert.Len(t, received, 1) {
+		// isolated long of size 1: ratio = (margin + mark - entry) / mark
+		maintenance := received[0].PreLiquidation.MaintenanceRatio
+		belowRearm := (50000 - pos.Snapshot().InitialMargin) / (1 - maintenance*1.55/100)
+		for range 5 {
+			walk(liquidationPrice*1.001, belowRearm, 10)
+			walk(belowRearm, liquidationPrice*1.001, 10)
+		}
+		assert.Empty(t, drain())
+	}
+	if assert.Len(t, received, 1) {
+		event := received[0]
+		assert.Equal(t, EventPreLiquidationWarning, event.Type)
+		assert.Equal(t, pos.ID, event.PositionID)
+
+		warning := event.PreLiquidation
+		assert.Equal(t, "warned", warning.UserID)
+		assert.Equal(t, liquidationPrice, warning.LiquidationPrice)
+		assert.LessOrEqual(t, warning.MarginRatio, warning.MaintenanceRatio*DefaultPreLiquidationConfig.Multiple)
+
+		// top-up brings the ratio back to the re-arm level
+		positionValue := warning.MarkPrice // size 1
+		equity := warning.MarginRatio / 100 * positionValue
+		rearm := warning.MaintenanceRatio * (DefaultPreLiquidationConfig.Multiple + DefaultPreLiquidationConfig.Hysteresis)
+		assert.InDelta(t, rearm, (equity+warning.TopUp)/positionValue*100, 1e-9)
+		assert.Greater(t, warning.TopUp, 0.0)
+	}
+
+	// recovered: re-armed, the next crossing warns again
+	walk(liquidationPrice*1.001, 50000, 50)
+	assert.Empty(t, drain())
+	walk(50000, liquidationPrice*1.001, 50)
+	assert.Len(t, drain(), 1)
+
+	pm.DisablePreLiquidationWarnings()
+	walk(liquidationPrice*1.001, 50000, 10)
+	walk(50000, liquidationPrice*1.001, 10)
+	assert.Empty(t, drain())
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	open := func(userID, symbol string) *Position {
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, symbol, LONG, 50000, 1, 10)
+		assert.NoError(t, err)
+		return pos
+	}
+	orphaned, misfiled, contradictory, miscalculated := open("u1", "BTCUSDT"), open("u2", "BTCUSDT"), open("u3", "ETHUSDT"), open("u4", "ETHUSDT")
+	open("u5", "BTCUSDT")
+
+	report := pm.VerifyIntegrity(context.Background())
+	assert.True(t, report.IsHealthy, "%+v", report.Violations)
+	assert.Equal(t, 5, report.Positions)
+
+	pm.mu.Lock()
+	delete(pm.positionsByID, orphaned.ID)
+	pm.mu.Unlock()
+	assert.NoError(t, pm.symbolPositions.AddPosition("ETHUSDT", misfiled))
+	contradictory.mu.Lock()
+	contradictory.Status = PositionClosed
+	contradictory.mu.Unlock()
+	miscalculated.mu.Lock()
+	miscalculated.LiquidationPrice = 0
+	miscalculated.mu.Unlock()
+
+	report = pm.VerifyIntegrity(context.Background())
+	assert.False(t, report.IsHealthy)
+	found := make(map[IntegrityViolationType]string)
+	for _, violation := range report.Violations {
+		found[violation.Type] = violation.PositionID
+	}
+	assert.Equal(t, map[IntegrityViolationType]string{
+		ViolationOrphaned:      orphaned.ID,
+		ViolationMisclassified: misfiled.ID,
+		ViolationContradictory: contradictory.ID,
+		ViolationMiscalculated: miscalculated.ID,
+	}, found)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report = pm.VerifyIntegrity(ctx)
+	assert.False(t, report.IsHealthy)
+	assert.Contains(t, report.Violations, IntegrityViolation{Type: ViolationIncomplete, Detail: context.Canceled.Error()})
+}
+
+type fixedFundingSchedule struct {
+	rate float64
+	next time.Time
+}
+
+func (s fixedFundingSchedule) CurrentFundingRate(symbol string) (float64, error) { return s.rate, nil }
+func (s fixedFundingSchedule) NextSettlement(symbol string) time.Time            { return s.next }
+
+func TestGetPositionsNearFundingTime(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	_, err := pm.GetPositionsNearFundingTime("BTCUSDT", time.Hour)
+	assert.Error(t, err, "no schedule")
+
+	pm.SetFundingRateSchedule(fixedFundingSchedule{rate: -0.0002, next: time.Now().Add(10 * time.Minute)})
+	for i, size := range []float64{0.5, 2, 1} {
+		_, err = pm.OpenPosition(common.CROSS, fmt.Sprintf("user%d", i), "BTCUSDT", LONG, 50000, size, 10)
+		assert.NoError(t, err)
+	}
+	_, err = pm.OpenPosition(common.CROSS, "user3", "BTCUSDT", SHORT, 50000, 1.5, 10)
+	assert.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "user4", "ETHUSDT", LONG, 3000, 100, 10)
+	assert.NoError(t, err)
+
+	infos, err := pm.GetPositionsNearFundingTime("BTCUSDT", time.Hour)
+	assert.NoError(t, err)
+	var users []string
+	for _, info := range infos {
+		users = append(users, info.Position.UserID)
+		assert.InDelta(t, 0.0002*info.Position.Size*50000, info.EstimatedFundingCost, 1e-9)
+	}
+	assert.Equal(t, []string{"user1", "user3", "user2", "user0"}, users)
+	assert.InDelta(t, 20.0, infos[0].EstimatedFundingCost, 1e-9)
+
+	// settlement further away than the window
+	infos, err = pm.GetPositionsNearFundingTime("BTCUSDT", 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, infos)
+
+	_, err = pm.GetPositionsNearFundingTime("DOGEUSDT", time.Hour)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
+
+func TestGetRecentAuditLog(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	// 20 operations: open, 9 adds, 9 reduces, close
+	_, err := pm.OpenPosition(common.ISOLATED, "audited", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	for i := 1; i <= 9; i++ {
+		_, err = pm.OpenPosition(common.ISOLATED, "audited", "BTCUSDT", LONG, 50000+float64(i), 1, 10)
+		assert.NoError(t, err)
+	}
+	for i := 1; i <= 9; i++ {
+		_, _, err = pm.ReducePosition("audited", "BTCUSDT", LONG, 51000+float64(i), 1)
+		assert.NoError(t, err)
+	}
+	_, _, err = pm.ClosePosition("audited", "BTCUSDT", LONG, 52000)
+	assert.NoError(t, err)
+	assert.Len(t, pm.AuditLog(), 20)
+
+	recent := pm.GetRecentAuditLog(10)
+	assert.Len(t, recent, 10)
+	assert.Equal(t, AuditClose, recent[0].Operation)
+	assert.Equal(t, 52000.0, recent[0].Price)
+	assert.Equal(t, "closed", recent[0].Status)
+	for i, entry := range recent[1:] {
+		assert.Equal(t, AuditReduce, entry.Operation)
+		assert.Equal(t, 51009-float64(i), entry.Price, "newest first")
+		assert.Equal(t, "audited", entry.UserID)
+		assert.Equal(t, LONG, entry.Side)
+	}
+	assert.Equal(t, AuditOpen, pm.AuditLog()[0].Operation)
+}
+
+func TestGetFundingPreview(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+	next := time.Now().Add(30 * time.Minute)
+	pm.SetFundingRateSchedule(fixedFundingSchedule{rate: 0.0001, next: next})
+
+	previews, err := pm.GetFundingPreview("flat", "BTCUSDT")
+	assert.NoError(t, err)
+	assert.Empty(t, previews)
+
+	assert.NoError(t, pm.SetPositionMode("hedged", HedgeMode))
+	_, err = pm.OpenPosition(common.CROSS, "hedged", "BTCUSDT", LONG, 50000, 2, 10)
+	assert.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "hedged", "BTCUSDT", SHORT, 50000, 0.5, 10)
+	assert.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 52000)
+	assert.NoError(t, err)
+
+	previews, err = pm.GetFundingPreview("hedged", "BTCUSDT")
+	assert.NoError(t, err)
+	if assert.Len(t, previews, 2) {
+		// long pays 2 × 52000 × 0.01% = 10.4, short receives 0.5 × 52000 × 0.01% = 2.6
+		assert.Equal(t, LONG, previews[0].Side)
+		assert.InDelta(t, 104000, previews[0].PositionValue, 1e-9)
+		assert.InDelta(t, -10.4, previews[0].Payment, 1e-9)
+		assert.Equal(t, SHORT, previews[1].Side)
+		assert.InDelta(t, 2.6, previews[1].Payment, 1e-9)
+		assert.Equal(t, 0.0001, previews[1].PredictedRate)
+		assert.Equal(t, next, previews[1].NextFundingAt)
+		assert.InDelta(t, 30*time.Minute, previews[1].TimeRemaining, float64(time.Second))
+	}
+
+	// preview only, nothing settled
+	pos, err := pm.GetPosition("hedged", "BTCUSDT", LONG)
+	assert.NoError(t, err)
+	assert.Zero(t, pos.Snapshot().RealizedPnL)
+}
+
+func TestGetMarkPriceHistory(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	assert.Empty(t, pm.GetSymbolMarkPrices())
+	assert.Empty(t, pm.GetMarkPriceHistory("BTCUSDT", 5))
+
+	for i := 1; i <= 10; i++ {
+		_, err := pm.UpdateMarkPrices("BTCUSDT", 50000+float64(i))
+		assert.NoError(t, err)
+	}
+	_, err := pm.UpdateMarkPrices("ETHUSDT", 3000)
+	assert.NoError(t, err)
+
+	history := pm.GetMarkPriceHistory("BTCUSDT", 5)
+	assert.Len(t, history, 5)
+	for i, point := range history {
+		assert.Equal(t, 50006+float64(i), point.Price, "oldest first")
+		assert.False(t, point.Timestamp.IsZero())
+	}
+	assert.Len(t, pm.GetMarkPriceHistory("BTCUSDT", 0), 10)
+	assert.Nil(t, pm.GetMarkPriceHistory("UNKNOWN", 5))
+
+	prices := pm.GetSymbolMarkPrices()
+	assert.Equal(t, map[string]float64{"BTCUSDT": 50010, "ETHUSDT": 3000}, prices)
+	prices["BTCUSDT"] = 1
+	assert.Equal(t, 50010.0, pm.GetSymbolMarkPrices()["BTCUSDT"], "a copy")
+}
+
+func TestAddEnforcesLeverageTier(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	// 100x at 40k notional, the add grows it to 290k where the tier max is 50x
+	open := func(userID string) *Position {
+		position, err := pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", LONG, 50000, 0.8, 100)
+		assert.NoError(t, err)
+		return position
+	}
+
+	t.Run("require margin", func(t *testing.T) {
+		position := open("tier_margin")
+		liquidationPrice := position.LiquidationPrice
+
+		_, enforcement, err := pm.AddPosition("tier_margin", "BTCUSDT", LONG, 50000, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, LeverageTierRequireMargin, enforcement.Policy)
+		assert.True(t, enforcement.Applied)
+		assert.Equal(t, uint(50), enforcement.MaxLeverage)
+		assert.Equal(t, int16(100), enforcement.PreviousLeverage)
+		assert.Equal(t, int16(50), enforcement.Leverage)
+		assert.InDelta(t, 2900, enforcement.AdditionalMargin, 1e-6)
+
+		snapshot := position.Snapshot()
+		assert.Equal(t, int16(50), snapshot.Leverage)
+		assert.InDelta(t, 5.8, snapshot.Size, 1e-9)
+		assert.InDelta(t, 5800, snapshot.InitialMargin, 1e-6)
+		assert.Less(t, snapshot.LiquidationPrice, liquidationPrice, "more margin moves a long's liquidation price away")
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		pm.SetLeverageTierPolicy(LeverageTierReject)
+		defer pm.SetLeverageTierPolicy(LeverageTierRequireMargin)
+		position := open("tier_reject")
+		before := position.Snapshot()
+
+		_, enforcement, err := pm.AddPosition("tier_reject", "BTCUSDT", LONG, 50000, 5)
+		assert.ErrorIs(t, err, ErrLeverageExceedsTier)
+		assert.Equal(t, LeverageTierReject, enforcement.Policy)
+		assert.True(t, enforcement.Applied)
+		after := position.Snapshot()
+		assert.Equal(t, before.Size, after.Size)
+		assert.Equal(t, before.InitialMargin, after.InitialMargin)
+		assert.Equal(t, int16(100), after.Leverage)
+
+		// OpenPosition on the existing position goes through the same check
+		_, err = pm.OpenPosition(common.ISOLATED, "tier_reject", "BTCUSDT", LONG, 50000, 5, 100)
+		assert.ErrorIs(t, err, ErrLeverageExceedsTier)
+
+		// within the tier the add passes untouched
+		_, enforcement, err = pm.AddPosition("tier_reject", "BTCUSDT", LONG, 50000, 0.4)
+		assert.NoError(t, err)
+		assert.False(t, enforcement.Applied)
+		assert.Equal(t, int16(100), enforcement.Leverage)
+	})
+}
+
+func TestGlobalMaintenanceMarginOverride(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	// 5k notional, tier rate 0.4%
+	position, err := pm.OpenPosition(common.ISOLATED, "override", "BTCUSDT", LONG, 50000, 0.1, 10)
+	assert.NoError(t, err)
+	before := position.Snapshot()
+	assert.InDelta(t, 20, before.MaintenanceMargin, 1e-9)
+
+	assert.Error(t, pm.SetGlobalMaintenanceMarginOverride(0))
+	assert.Error(t, pm.SetGlobalMaintenanceMarginOverride(1))
+
+	assert.NoError(t, pm.SetGlobalMaintenanceMarginOverride(0.05))
+	overridden := position.Snapshot()
+	assert.InDelta(t, 250, overridden.MaintenanceMargin, 1e-9)
+	assert.Greater(t, overridden.LiquidationPrice, before.LiquidationPrice)
+
+	// positions opened under the override use it too
+	other, err := pm.OpenPosition(common.ISOLATED, "override", "ETHUSDT", LONG, 3000, 1, 10)
+	assert.NoError(t, err)
+	assert.InDelta(t, 150, other.Snapshot().MaintenanceMargin, 1e-9)
+
+	pm.ClearGlobalMaintenanceMarginOverride()
+	cleared := position.Snapshot()
+	assert.InDelta(t, 20, cleared.MaintenanceMargin, 1e-9)
+	assert.InDelta(t, before.LiquidationPrice, cleared.LiquidationPrice, 1e-9)
+	assert.InDelta(t, 12, other.Snapshot().MaintenanceMargin, 1e-9)
+}
+
+func TestPositionExpiry(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	now := time.Now()
+	expiries := []time.Duration{50 * time.Minute, 10 * time.Minute, 2 * time.Hour, 30 * time.Minute, 5 * time.Hour}
+	for i, in := range expiries {
+		pos, err := pm.OpenPosition(common.ISOLATED, fmt.Sprintf("dated-%d", i), "BTCUSDT", LONG, 50000, 1, 10)
+		assert.NoError(t, err)
+		pos.SetExpiry(now.Add(in))
+	}
+	_, err := pm.OpenPosition(common.ISOLATED, "perpetual", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+
+	expiring := pm.GetExpiringWithin("BTCUSDT", 1*time.Hour)
+	assert.Len(t, expiring, 3)
+	assert.Equal(t, []string{"dated-1", "dated-3", "dated-0"},
+		[]string{expiring[0].UserID, expiring[1].UserID, expiring[2].UserID})
+	assert.Nil(t, pm.GetExpiringWithin("UNKNOWN", time.Hour))
+
+	// nothing expired yet
+	closed, err := pm.CheckExpiry()
+	assert.NoError(t, err)
+	assert.Empty(t, closed)
+
+	events, cancel := pm.SubscribeEvents(16)
+	defer cancel()
+
+	settled, err := pm.GetPosition("dated-1", "BTCUSDT", LONG)
+	assert.NoError(t, err)
+	settled.SetExpiry(now.Add(-time.Second))
+	assert.NoError(t, settled.SetSettlementPrice(51000))
+	marked, err := pm.GetPosition("dated-3", "BTCUSDT", LONG)
+	assert.NoError(t, err)
+	marked.SetExpiry(now.Add(-time.Second))
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 49000)
+	assert.NoError(t, err)
+
+	assert.True(t, settled.IsExpired(time.Now()))
+	assert.False(t, settled.IsExpired(now.Add(-time.Minute)))
+
+	closed, err = pm.CheckExpiry()
+	assert.NoError(t, err)
+	assert.Len(t, closed, 2)
+
+	snapshot := settled.Snapshot()
+	assert.Equal(t, PositionClosed, snapshot.Status)
+	assert.InDelta(t, 1000, snapshot.RealizedPnL, 1e-9) // settlement price
+	snapshot = marked.Snapshot()
+	assert.Equal(t, PositionClosed, snapshot.Status)
+	assert.InDelta(t, -1000, snapshot.RealizedPnL, 1e-9) // mark price
+
+	var expired []PositionEvent
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventPositionExpired {
+			expired = append(expired, event)
+		}
+	}
+	assert.Len(t, expired, 2)
+	for _, event := range expired {
+		assert.NotNil(t, event.Expired)
+		assert.Contains(t, []float64{51000, 49000}, event.Expired.ClosePrice)
+	}
+	assert.Len(t, pm.GetExpiringWithin("BTCUSDT", time.Hour), 1)
+}
+
+// countingHandler counts the events of each type and keeps the last close
+type countingHandler struct {
+	NoopPositionEventHandler
+	counts map[PositionEventType]int
+	closed *PositionClosedEvent
+}
+
+func (h *countingHandler) HandlePositionOpened(*PositionOpenedEvent) error {
+	h.counts[EventPositionOpened]++
+	return nil
+}
+
+func (h *countingHandler) HandlePositionIncreased(*PositionIncreasedEvent) error {
+	h.counts[EventPositionIncreased]++
+	return nil
+}
+
+func (h *countingHandler) HandlePositionReduced(*PositionReducedEvent) error {
+	h.counts[EventPositionReduced]++
+	return nil
+}
+
+func (h *countingHandler) HandlePositionClosed(event *PositionClosedEvent) error {
+	h.counts[EventPositionClosed]++
+	h.closed = event
+	return nil
+}
+
+func (h *countingHandler) HandlePositionLiquidated(*PositionLiquidatedEvent) error {
+	h.counts[EventPositionLiquidated]++
+	return errors.New("logged, dispatch goes on")
+}
+
+func (h *countingHandler) HandlePreLiquidationWarning(*PreLiquidationWarning) error {
+	h.counts[EventPreLiquidationWarning]++
+	return nil
+}
+
+func (h *countingHandler) HandlePositionExpired(*PositionExpiredEvent) error {
+	h.counts[EventPositionExpired]++
+	return nil
+}
+
+func TestSubscribeHandler(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+	pm.EnablePreLiquidationWarnings(nil)
+
+	events, cancel := pm.SubscribeEvents(64)
+	handler := &countingHandler{counts: make(map[PositionEventType]int)}
+	done := pm.SubscribeHandler(events, handler)
+	auditEvents, cancelAudit := pm.SubscribeEvents(64)
+	var auditLines bytes.Buffer
+	auditDone := pm.SubscribeHandler(auditEvents, NewAuditLogger(&logger.Logger{Logger: slog.New(slog.NewTextHandler(&auditLines, nil))}))
+
+	// open, add, reduce, close
+	_, err := pm.OpenPosition(common.ISOLATED, "alice", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "alice", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	_, _, err = pm.ReducePosition("alice", "BTCUSDT", LONG, 51000, 0.5)
+	assert.NoError(t, err)
+	_, _, err = pm.ClosePosition("alice", "BTCUSDT", LONG, 51000)
+	assert.NoError(t, err)
+
+	// warned, then liquidated
+	pos, err := pm.OpenPosition(common.ISOLATED, "bob", "BTCUSDT", LONG, 50000, 1, 10)
+	assert.NoError(t, err)
+	liquidationPrice := pos.Snapshot().LiquidationPrice
+	_, err = pm.UpdateMarkPrices("BTCUSDT", liquidationPrice*1.001)
+	assert.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", liquidationPrice*0.999)
+	assert.NoError(t, err)
+	assert.True(t, pos.ClaimLiquidation())
+	_, _, err = pm.LiquidatePosition(pos, liquidationPrice, 1)
+	assert.NoError(t, err)
+
+	// expired
+	dated, err := pm.OpenPosition(common.ISOLATED, "carol", "ETHUSDT", SHORT, 3000, 1, 10)
+	assert.NoError(t, err)
+	dated.SetExpiry(time.Now().Add(-time.Second))
+	_, err = pm.CheckExpiry()
+	assert.NoError(t, err)
+
+	cancel()
+	cancelAudit()
+	<-done
+	<-auditDone
+	assert.Equal(t, map[PositionEventType]int{
+		EventPositionOpened:        3,
+		EventPositionIncreased:     1,
+		EventPositionReduced:       1,
+		EventPositionClosed:        2, // alice's close and carol's expiry close
+		EventPositionLiquidated:    1,
+		EventPreLiquidationWarning: 1,
+		EventPositionExpired:       1,
+	}, handler.counts)
+	if assert.NotNil(t, handler.closed) {
+		assert.Equal(t, "carol", handler.closed.UserID)
+		assert.Equal(t, SHORT, handler.closed.Side)
+		assert.Equal(t, 1.0, handler.closed.Size)
+	}
+	assert.Zero(t, pm.DroppedEvents())
+
+	assert.Equal(t, 10, strings.Count(auditLines.String(), "component=position_audit"))
+	assert.Contains(t, auditLines.String(), "level=WARN msg=position_liquidated")
+
+	// events without a typed payload are skipped
+	assert.NoError(t, DispatchPositionEvent(PositionEvent{Type: "unknown"}, handler))
+}
+
+func TestGetPositionsByStrategyTag(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	defer pm.Close()
+
+	strategies := []string{"grid", "trend", "arb"}
+	want := make(map[string][]string)
+	for i := 0; i < 10; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		pos, err := pm.OpenPosition(common.ISOLATED, userID, symbols[i%2], LONG, 1000, 1, 10)
+		assert.NoError(t, err)
+		strategy := strategies[i%3]
+		assert.NoError(t, pos.SetTag(TagStrategy, strategy))
+		assert.NoError(t, pos.SetTag("desk", fmt.Sprintf("desk-%d", i%2)))
+		want[strategy] = append(want[strategy], pos.ID)
+	}
+
+	seen := make(map[string]bool)
+	for _, strategy := range strategies {
+		tagged := pm.GetPositionsByStrategyTag(strategy)
+		ids := make([]string, 0, len(tagged))
+		for _, info := range tagged {
+			assert.Equal(t, strategy, info.Tags[TagStrategy])
+			assert.False(t, seen[info.ID], "position %s in two strategies", info.ID)
+			seen[info.ID] = true
+			ids = append(ids, info.ID)
+		}
+		assert.ElementsMatch(t, want[strategy], ids)
+	}
+	assert.Len(t, seen, 10)
+	assert.Empty(t, pm.GetPositionsByStrategyTag("unknown"))
+	assert.Len(t, pm.GetPositionsByTag("desk", "desk-1"), 5)
+
+	// snapshots are copies, closed positions drop out, empty values remove tags
+	tagged := pm.GetPositionsByStrategyTag("arb")
+	tagged[0].Tags[TagStrategy] = "changed"
+	assert.Len(t, pm.GetPositionsByStrategyTag("arb"), 3)
+	_, _, err := pm.ClosePosition(tagged[0].UserID, tagged[0].Symbol, LONG, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, pm.GetPositionsByStrategyTag("arb"), 2)
+
+	pos, err := pm.GetPosition("user-0", "BTCUSDT", LONG)
+	assert.NoError(t, err)
+	assert.NoError(t, pos.SetTag("desk", ""))
+	assert.Error(t, pos.SetTag("", "x"))
+
+	assert.Equal(t, map[string][]string{
+		TagStrategy: {"arb", "grid", "trend"},
+		"desk":      {"desk-0", "desk-1"},
+	}, pm.GetAllTags())
+	assert.Len(t, pm.GetPositionsByTag("desk", "desk-0"), 3) // user-0 untagged, user-2 (oldest arb) closed
+}
@@ -0,0 +1,128 @@
+package position
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidMarginTiers = errors.New("invalid margin tiers")
+
+// ValidateMarginTiers tiers must be non-empty, contiguous and ascending from a non-negative MinValue:
+// each tier starts where the previous one ends, rates in (0, 1] do not decrease and max leverages do not increase
+func ValidateMarginTiers(tiers []MarginTier) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("%w: no tier", ErrInvalidMarginTiers)
+	}
+	for i, t := range tiers {
+		switch {
+		case math.IsNaN(t.MinValue) || math.IsNaN(t.MaxValue) || t.MinValue < 0 || t.MinValue >= t.MaxValue:
+			return fmt.Errorf("%w: tier %d range [%v, %v]", ErrInvalidMarginTiers, i, t.MinValue, t.MaxValue)
+		case !(t.MaintenanceRate > 0 && t.MaintenanceRate <= 1):
+			return fmt.Errorf("%w: tier %d maintenance rate %v", ErrInvalidMarginTiers, i, t.MaintenanceRate)
+		case t.MaxLeverage == 0:
+			return fmt.Errorf("%w: tier %d max leverage 0", ErrInvalidMarginTiers, i)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := tiers[i-1]
+		switch {
+		case t.MinValue != prev.MaxValue:
+			return fmt.Errorf("%w: tier %d starts at %v, tier %d ends at %v", ErrInvalidMarginTiers, i, t.MinValue, i-1, prev.MaxValue)
+		case t.MaintenanceRate < prev.MaintenanceRate:
+			return fmt.Errorf("%w: tier %d maintenance rate %v below tier %d", ErrInvalidMarginTiers, i, t.MaintenanceRate, i-1)
+		case t.MaxLeverage > prev.MaxLeverage:
+			return fmt.Errorf("%w: tier %d max leverage %d above tier %d", ErrInvalidMarginTiers, i, t.MaxLeverage, i-1)
+		}
+	}
+	return nil
+}
+
+// MarginTierCalculator (維持保證金階梯) tier lookup of a validated tier table by binary search over the tier upper
+// bounds, same results as PositionMath.CalculateMaintenanceMargin / MaxLeverage on the table. safe for concurrent use
+type MarginTierCalculator struct {
+	tiers []MarginTier
+	// the table by column: contiguous tiers only need their upper bounds and the floor of the first one
+	floor     float64
+	maxValues []float64 // ascending
+	rates     []float64
+	leverages []uint
+}
+
+// defaultMarginTierCalculator calculator of DefaultMarginTiers, used by positions without one
+var defaultMarginTierCalculator = mustMarginTierCalculator(DefaultMarginTiers)
+
+// NewMarginTierCalculator validate tiers and build their lookup, tiers is copied
+func NewMarginTierCalculator(tiers []MarginTier) (*MarginTierCalculator, error) {
+	if err := ValidateMarginTiers(tiers); err != nil {
+		return nil, err
+	}
+	c := &MarginTierCalculator{
+		tiers:     append([]MarginTier(nil), tiers...),
+		floor:     tiers[0].MinValue,
+		maxValues: make([]float64, len(tiers)),
+		rates:     make([]float64, len(tiers)),
+		leverages: make([]uint, len(tiers)),
+	}
+	for i, t := range tiers {
+		c.maxValues[i] = t.MaxValue
+		c.rates[i], c.leverages[i] = t.MaintenanceRate, t.MaxLeverage
+	}
+	return c, nil
+}
+
+func mustMarginTierCalculator(tiers []MarginTier) *MarginTierCalculator {
+	c, err := NewMarginTierCalculator(tiers)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Calculate (維持保證金) positionValue * maintenance rate of its tier, 0 outside every tier
+func (c *MarginTierCalculator) Calculate(positionValue float64) float64 {
+	if i := c.tier(positionValue); i >= 0 {
+		return positionValue * c.rates[i]
+	}
+	return 0
+}
+
+// MaxLeverage (最大槓桿) max leverage of the tier of positionValue, 0 outside every tier
+func (c *MarginTierCalculator) MaxLeverage(positionValue float64) uint {
+	if i := c.tier(positionValue); i >= 0 {
+		return c.leverages[i]
+	}
+	return 0
+}
+
+// Tiers copy of the tier table
+func (c *MarginTierCalculator) Tiers() []MarginTier {
+	return append([]MarginTier(nil), c.tiers...)
+}
+
+// tier index of the first tier whose range holds positionValue, -1 outside every tier. the tiers are
+// contiguous: the first upper bound not below the value, a shared boundary belongs to the lower tier
+func (c *MarginTierCalculator) tier(positionValue float64) int {
+	// !(>=) keeps NaN outside every tier
+	if !(positionValue >= c.floor) {
+		return -1
+	}
+	// branch-free lower bound, the halving does not depend on the comparisons
+	bounds := c.maxValues
+	base, n := 0, len(bounds)
+	for n > 1 {
+		half := n >> 1
+		if bounds[base+half-1] < positionValue {
+			base += half
+		}
+		n -= half
+	}
+	if bounds[base] < positionValue {
+		base++
+	}
+	if base == len(bounds) {
+		return -1
+	}
+	return base
+}
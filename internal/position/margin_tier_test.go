@@ -0,0 +1,129 @@
+package position
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMarginTiers(t *testing.T) {
+	require.NoError(t, ValidateMarginTiers(DefaultMarginTiers))
+
+	tests := []struct {
+		name  string
+		tiers []MarginTier
+	}{
+		{"empty", nil},
+		{"empty range", []MarginTier{{100, 100, 0.01, 10}}},
+		{"negative min", []MarginTier{{-1, 100, 0.01, 10}}},
+		{"zero rate", []MarginTier{{0, 100, 0, 10}}},
+		{"rate above 1", []MarginTier{{0, 100, 1.5, 10}}},
+		{"zero leverage", []MarginTier{{0, 100, 0.01, 0}}},
+		{"gap", []MarginTier{{0, 100, 0.01, 10}, {200, 300, 0.02, 5}}},
+		{"overlap", []MarginTier{{0, 100, 0.01, 10}, {50, 300, 0.02, 5}}},
+		{"rate decreases", []MarginTier{{0, 100, 0.02, 10}, {100, 300, 0.01, 5}}},
+		{"leverage increases", []MarginTier{{0, 100, 0.01, 10}, {100, 300, 0.02, 20}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, ValidateMarginTiers(tt.tiers), ErrInvalidMarginTiers)
+			_, err := NewMarginTierCalculator(tt.tiers)
+			assert.ErrorIs(t, err, ErrInvalidMarginTiers)
+		})
+	}
+}
+
+func TestMarginTierCalculatorMatchesLinearLookup(t *testing.T) {
+	calc, err := NewMarginTierCalculator(DefaultMarginTiers)
+	require.NoError(t, err)
+
+	values := []float64{-1, 0, 1, 49999.99, 50000, 50000.01, 250000, 999999, 1000000, 5000000, 7500000,
+		10000000, 20000000, 50000000, 50000000.5, 1e12, math.Inf(1), math.NaN()}
+	for _, value := range values {
+		assert.Equal(t, PositionMath{}.CalculateMaintenanceMargin(value, DefaultMarginTiers), calc.Calculate(value), "value %v", value)
+		assert.Equal(t, PositionMath{}.MaxLeverage(value, DefaultMarginTiers), calc.MaxLeverage(value), "value %v", value)
+	}
+
+	// a table starting above zero: values below it are outside every tier
+	calc, err = NewMarginTierCalculator([]MarginTier{{1000, 2000, 0.01, 20}})
+	require.NoError(t, err)
+	assert.Zero(t, calc.Calculate(500))
+	assert.Zero(t, calc.MaxLeverage(2500))
+	assert.InDelta(t, 15, calc.Calculate(1500), 1e-9)
+}
+
+func TestPositionMarginTiers(t *testing.T) {
+	// a symbol with a stricter table than the default
+	calc, err := NewMarginTierCalculator([]MarginTier{
+		{0, 10000, 0.02, 20},
+		{10000, math.Inf(1), 0.05, 5},
+	})
+	require.NoError(t, err)
+
+	custom := NewPosition("alice", "ALTUSDT", common.ISOLATED, &PrecisionSetting{PricePrecision: 2, SizePrecision: 8, MarginTiers: calc})
+	require.NoError(t, custom.Open(LONG, 100, 50, 10)) // 5000 value
+	assert.InDelta(t, 100, custom.MaintenanceMargin, 1e-9)
+
+	standard := NewPosition("alice", "BTCUSDT", common.ISOLATED, nil)
+	require.NoError(t, standard.Open(LONG, 100, 50, 10))
+	assert.InDelta(t, 20, standard.MaintenanceMargin, 1e-9)
+
+	// growing into the 5x tier lowers the leverage of the custom table only
+	enforcement, err := custom.AddWithTierPolicy(100, 100, LeverageTierRequireMargin)
+	require.NoError(t, err)
+	assert.True(t, enforcement.Applied)
+	assert.Equal(t, uint(5), enforcement.MaxLeverage)
+	assert.Equal(t, int16(5), custom.Leverage)
+	assert.InDelta(t, 750, custom.MaintenanceMargin, 1e-9)
+}
+
+func BenchmarkMaintenanceMarginLookup(b *testing.B) {
+	for _, size := range []int{len(DefaultMarginTiers), 64} {
+		tiers := DefaultMarginTiers
+		if size != len(tiers) {
+			tiers = benchMarginTiers(size)
+		}
+		calc, err := NewMarginTierCalculator(tiers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// position values spread over the tiers in random order, so neither lookup can learn a pattern
+		rng := rand.New(rand.NewSource(1))
+		values := make([]float64, 1024)
+		for i := range values {
+			t := tiers[rng.Intn(len(tiers))]
+			values[i] = t.MinValue + rng.Float64()*min(t.MaxValue-t.MinValue, 1e8)
+		}
+		mask := len(values) - 1
+
+		b.Run(fmt.Sprintf("Linear/%d", size), func(b *testing.B) {
+			var sum float64
+			for i := 0; i < b.N; i++ {
+				sum += PositionMath{}.CalculateMaintenanceMargin(values[i&mask], tiers)
+			}
+			_ = sum
+		})
+		b.Run(fmt.Sprintf("Calculator/%d", size), func(b *testing.B) {
+			var sum float64
+			for i := 0; i < b.N; i++ {
+				sum += calc.Calculate(values[i&mask])
+			}
+			_ = sum
+		})
+	}
+}
+
+// benchMarginTiers contiguous table of n tiers of 100k each, the last one open-ended
+func benchMarginTiers(n int) []MarginTier {
+	tiers := make([]MarginTier, n)
+	for i := range tiers {
+		tiers[i] = MarginTier{float64(i) * 1e5, float64(i+1) * 1e5, 0.004 + float64(i)*0.002, uint(max(125-2*i, 1))}
+	}
+	tiers[n-1].MaxValue = math.Inf(1)
+	return tiers
+}
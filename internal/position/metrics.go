@@ -0,0 +1,38 @@
+package position
+
+import "frizo/futures_engine/internal/metrics"
+
+// metrics of the position manager, labelled by symbol
+const (
+	MetricMarginCalls = "margin_calls_total" // pre-liquidation warnings (EnablePreLiquidationWarnings)
+
+	// price ingestion backlog (PriceIngestion.ReportMetrics), no labels
+	MetricIngestionQueueDepth     = "price_ingestion_queue_depth"
+	MetricIngestionPendingSymbols = "price_ingestion_pending_symbols"
+)
+
+// SetMetrics registry of the manager's metrics, nil means metrics.Nop
+func (pm *PositionManager) SetMetrics(registry metrics.Registry) {
+	pm.metricsMu.Lock()
+	defer pm.metricsMu.Unlock()
+	pm.metrics = metrics.OrNop(registry)
+}
+
+func (pm *PositionManager) metricsRegistry() metrics.Registry {
+	pm.metricsMu.Lock()
+	defer pm.metricsMu.Unlock()
+	return pm.metrics
+}
+
+// publishMarginCall count and publish a pre-liquidation warning
+func (pm *PositionManager) publishMarginCall(event PositionEvent) {
+	pm.metricsRegistry().AddCounter(MetricMarginCalls, 1, metrics.Labels{"symbol": event.Symbol})
+	pm.events.Publish(event)
+}
+
+// ReportMetrics set the backlog gauges of the pipeline, e.g. from a scrape hook
+func (in *PriceIngestion) ReportMetrics(registry metrics.Registry) {
+	stats := in.Stats()
+	registry.SetGauge(MetricIngestionQueueDepth, float64(stats.QueueDepth), nil)
+	registry.SetGauge(MetricIngestionPendingSymbols, float64(stats.PendingSymbols), nil)
+}
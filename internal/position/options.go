@@ -0,0 +1,160 @@
+package position
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/metrics"
+	"maps"
+)
+
+// DefaultAuditLoggerBuffer events buffered for the WithAuditLogger handler before they are dropped
+const DefaultAuditLoggerBuffer = 1024
+
+var ErrMaxPositionsPerUser = errors.New("max positions per user reached")
+
+// PositionJournal (倉位日誌) append-only record of every position operation, next to the in-memory audit log.
+// Append runs under the manager lock: it must be quick, errors are logged and do not undo the operation
+type PositionJournal interface {
+	Append(entry PositionAuditEntry) error
+}
+
+// PositionManagerOption configures NewWithOptions
+type PositionManagerOption func(*PositionManager)
+
+// WithSymbols symbols the manager trades, more can be added with Subscribe
+func WithSymbols(symbols ...string) PositionManagerOption {
+	return func(pm *PositionManager) {
+		for _, symbol := range symbols {
+			pm.symbolPositions.AddSymbol(symbol)
+		}
+	}
+}
+
+// WithPositionStore persistence used by RecoverPosition, same as SetPositionStore
+func WithPositionStore(store PositionStore) PositionManagerOption {
+	return func(pm *PositionManager) {
+		pm.store = store
+	}
+}
+
+// WithJournal journal of every audited operation
+func WithJournal(journal PositionJournal) PositionManagerOption {
+	return func(pm *PositionManager) {
+		pm.journal = journal
+	}
+}
+
+// SetJournal journal of every audited operation from now on, same as WithJournal
+func (pm *PositionManager) SetJournal(journal PositionJournal) {
+	pm.auditMu.Lock()
+	defer pm.auditMu.Unlock()
+	pm.journal = journal
+}
+
+// WithMetrics registry of the manager's metrics, same as SetMetrics
+func WithMetrics(registry metrics.Registry) PositionManagerOption {
+	return func(pm *PositionManager) {
+		pm.metrics = metrics.OrNop(registry)
+	}
+}
+
+// WithAuditLogger dispatch the manager's events to audit on a subscription of DefaultAuditLoggerBuffer events,
+// until Close. with a shared event bus it logs the events of every manager on it
+func WithAuditLogger(audit *AuditLogger) PositionManagerOption {
+	return func(pm *PositionManager) {
+		pm.auditLogger = audit
+	}
+}
+
+// WithEventBus publish to (and subscribe from) bus instead of a bus of the manager's own, e.g. one stream
+// for the real and simulated managers. nil keeps the manager's own
+func WithEventBus(bus EventBus) PositionManagerOption {
+	return func(pm *PositionManager) {
+		if bus != nil {
+			pm.events = bus
+		}
+	}
+}
+
+// WithSymbolPrecision precision (and margin tiers) of the positions opened or recovered in each symbol,
+// DefaultPrecisionSetting for the others. the map is copied
+func WithSymbolPrecision(precision map[string]*PrecisionSetting) PositionManagerOption {
+	return func(pm *PositionManager) {
+		pm.precision = maps.Clone(precision)
+	}
+}
+
+// WithMaxPositionsPerUser open positions a user may hold, opening one more returns ErrMaxPositionsPerUser.
+// adds to an existing position are not limited. 0 means unlimited
+func WithMaxPositionsPerUser(n int) PositionManagerOption {
+	return func(pm *PositionManager) {
+		pm.maxPositionsPerUser = max(n, 0)
+	}
+}
+
+// NewWithOptions (倉位管理) manager without symbols, its own event bus and no store, journal or metrics,
+// then every option applied in order
+func NewWithOptions(opts ...PositionManagerOption) *PositionManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	pm := &PositionManager{
+		userPositions:   make(map[string]UserPositions),
+		symbolPositions: NewSymbolPositions(nil),
+		positionsByID:   make(map[string]*Position),
+		mode:            make(map[string]PositionMode),
+		holders:         make(map[string]map[string]struct{}),
+		auditLog:        common.NewRingBuffer[PositionAuditEntry](MaxAuditLogEntries),
+		events:          newEventBus(),
+		metrics:         metrics.Nop{},
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+	for _, opt := range opts {
+		opt(pm)
+	}
+
+	if pm.auditLogger != nil {
+		events, stop := pm.SubscribeEvents(DefaultAuditLoggerBuffer)
+		pm.SubscribeHandler(events, pm.auditLogger)
+		pm.stopAuditLogger = stop
+	}
+	return pm
+}
+
+// precisionOf precision of new positions of symbol, nil means DefaultPrecisionSetting
+func (pm *PositionManager) precisionOf(symbol string) *PrecisionSetting {
+	return pm.precision[symbol]
+}
+
+// journalEntry append entry to the journal, if any
+func (pm *PositionManager) journalEntry(entry PositionAuditEntry) {
+	pm.auditMu.Lock()
+	journal := pm.journal
+	pm.auditMu.Unlock()
+	if journal == nil {
+		return
+	}
+	if err := journal.Append(entry); err != nil {
+		logger.Default().Warn("position journal append failed",
+			"operation", entry.Operation, "position_id", entry.PositionID, "error", err)
+	}
+}
+
+// checkMaxPositions ErrMaxPositionsPerUser when the user already holds the max open positions, pm.mu held
+func (pm *PositionManager) checkMaxPositions(userID string) error {
+	if pm.maxPositionsPerUser == 0 {
+		return nil
+	}
+	open := 0
+	for _, position := range pm.userPositions[userID] {
+		if position.Status != PositionClosed && position.Size > position.ZeroSize() {
+			open++
+		}
+	}
+	if open >= pm.maxPositionsPerUser {
+		return fmt.Errorf("%w: %s holds %d", ErrMaxPositionsPerUser, userID, open)
+	}
+	return nil
+}
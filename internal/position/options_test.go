@@ -0,0 +1,133 @@
+package position
+
+import (
+	"bytes"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/metrics"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingJournal struct {
+	entries []PositionAuditEntry
+	mu      sync.Mutex
+}
+
+func (j *recordingJournal) Append(entry PositionAuditEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, entry)
+	return nil
+}
+
+// syncBuffer bytes.Buffer written by the audit logger goroutine
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestNewWithOptions(t *testing.T) {
+	store := &recordingStore{}
+	journal := &recordingJournal{}
+	recorder := metrics.NewRecorder()
+	var auditLines syncBuffer
+	audit := NewAuditLogger(&logger.Logger{Logger: slog.New(slog.NewTextHandler(&auditLines, nil))})
+	bus := NewEventBus()
+	tiers, err := NewMarginTierCalculator([]MarginTier{{0, math.Inf(1), 0.05, 20}})
+	require.NoError(t, err)
+	precision := map[string]*PrecisionSetting{"ALTUSDT": {PricePrecision: 4, SizePrecision: 2, MarginTiers: tiers}}
+
+	pm := NewWithOptions(
+		WithSymbols("BTCUSDT", "ALTUSDT"),
+		WithPositionStore(store),
+		WithJournal(journal),
+		WithMetrics(recorder),
+		WithAuditLogger(audit),
+		WithEventBus(bus),
+		WithSymbolPrecision(precision),
+		WithMaxPositionsPerUser(2),
+	)
+
+	// every option is stored
+	assert.Equal(t, []string{"ALTUSDT", "BTCUSDT"}, pm.GetAllSymbols())
+	assert.Same(t, store, pm.store)
+	assert.Same(t, journal, pm.journal)
+	assert.Same(t, recorder, pm.metrics)
+	assert.Same(t, audit, pm.auditLogger)
+	assert.NotNil(t, pm.stopAuditLogger)
+	assert.Same(t, bus, pm.events)
+	assert.Equal(t, precision, pm.precision)
+	delete(precision, "ALTUSDT")
+	assert.NotNil(t, pm.precisionOf("ALTUSDT"), "the map is copied")
+	assert.Equal(t, 2, pm.maxPositionsPerUser)
+
+	// and used
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	alt, err := pm.OpenPosition(common.ISOLATED, "alice", "ALTUSDT", LONG, 10, 100, 10)
+	require.NoError(t, err)
+	assert.InDelta(t, 50, alt.Snapshot().MaintenanceMargin, 1e-9, "ALTUSDT tiers")
+	assert.Equal(t, 0.01, alt.ZeroSize(), "ALTUSDT size precision")
+	select {
+	case event := <-events:
+		assert.Equal(t, EventPositionOpened, event.Type)
+	case <-time.After(time.Second):
+		t.Fatal("no event on the shared bus")
+	}
+
+	_, err = pm.OpenPosition(common.ISOLATED, "alice", "BTCUSDT", LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "alice", "BTCUSDT", SHORT, 50000, 0.1, 10)
+	assert.NoError(t, err, "one-way mode: reduces the long, not a new position")
+	require.NoError(t, pm.SetPositionMode("bob", HedgeMode))
+	_, err = pm.OpenPosition(common.ISOLATED, "bob", "BTCUSDT", LONG, 50000, 0.1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "bob", "BTCUSDT", SHORT, 50000, 0.1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "bob", "ALTUSDT", SHORT, 10, 100, 10)
+	assert.ErrorIs(t, err, ErrMaxPositionsPerUser)
+	_, err = pm.OpenPosition(common.ISOLATED, "bob", "BTCUSDT", LONG, 50000, 0.1, 10)
+	assert.NoError(t, err, "adds are not limited")
+
+	journal.mu.Lock()
+	assert.Len(t, journal.entries, len(pm.AuditLog()))
+	assert.Equal(t, AuditOpen, journal.entries[0].Operation)
+	journal.mu.Unlock()
+
+	require.NoError(t, pm.Close())
+	assert.Eventually(t, func() bool { return strings.Contains(auditLines.String(), "position_opened") },
+		time.Second, 10*time.Millisecond)
+}
+
+func TestNewPositionManagerDefaults(t *testing.T) {
+	pm := NewPositionManager([]string{"BTCUSDT"})
+	defer pm.Close()
+
+	assert.Equal(t, []string{"BTCUSDT"}, pm.GetAllSymbols())
+	assert.Nil(t, pm.store)
+	assert.Nil(t, pm.journal)
+	assert.Equal(t, metrics.Nop{}, pm.metrics)
+	assert.Nil(t, pm.auditLogger)
+	assert.Nil(t, pm.precisionOf("BTCUSDT"))
+	assert.Zero(t, pm.maxPositionsPerUser)
+}
@@ -0,0 +1,70 @@
+package position
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RealizedPnLSummary (已實現盈虧彙總) realized PnL of one user within a period
+type RealizedPnLSummary struct {
+	TotalRealizedPnL float64            `json:"total_realized_pnl"`
+	BySymbol         map[string]float64 `json:"by_symbol"`
+	ByDate           map[string]float64 `json:"by_date"` // UTC date, 2006-01-02
+	TotalTradingFees float64            `json:"total_trading_fees"`
+	NetPnLAfterFees  float64            `json:"net_pnl_after_fees"`
+}
+
+// GetRealizedPnLSummary aggregate the position history of the store. each snapshot contributes the realized PnL
+// and fees added since the previous snapshot of the same position, on the date of its update time,
+// so a closed position counts on the date it was closed. period [from, to] inclusive.
+func (pm *PositionManager) GetRealizedPnLSummary(userID string, from, to time.Time) (RealizedPnLSummary, error) {
+	summary := RealizedPnLSummary{
+		BySymbol: make(map[string]float64),
+		ByDate:   make(map[string]float64),
+	}
+
+	pm.mu.RLock()
+	store := pm.store
+	pm.mu.RUnlock()
+	if store == nil {
+		return summary, fmt.Errorf("position store not configured")
+	}
+
+	snapshots, err := store.ListSnapshots(userID)
+	if err != nil {
+		return summary, fmt.Errorf("list snapshots of %s: %w", userID, err)
+	}
+
+	history := make(map[string][]PositionSnapshot)
+	for _, snapshot := range snapshots {
+		if snapshot.UserID == userID {
+			history[snapshot.ID] = append(history[snapshot.ID], snapshot)
+		}
+	}
+
+	for _, entries := range history {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].UpdateTime.Before(entries[j].UpdateTime)
+		})
+
+		var realized, fees float64
+		for _, snapshot := range entries {
+			pnl, fee := snapshot.RealizedPnL-realized, snapshot.TradingFees-fees
+			realized, fees = snapshot.RealizedPnL, snapshot.TradingFees
+
+			if snapshot.UpdateTime.Before(from) || snapshot.UpdateTime.After(to) {
+				continue
+			}
+			summary.TotalRealizedPnL += pnl
+			summary.TotalTradingFees += fee
+			if pnl != 0 {
+				summary.BySymbol[snapshot.Symbol] += pnl
+				summary.ByDate[snapshot.UpdateTime.UTC().Format(time.DateOnly)] += pnl
+			}
+		}
+	}
+	summary.NetPnLAfterFees = summary.TotalRealizedPnL - summary.TotalTradingFees
+
+	return summary, nil
+}
@@ -17,6 +17,8 @@ type Position struct {
 	Side   PositionSide   `json:"side"`
 	Status PositionStatus `json:"status"`
 
+	Simulated bool `json:"simulated"` // paper trading position (模擬倉位)
+
 	// position info (decimal)
 	Size             float64 `json:"size"`
 	EntryPrice       float64 `json:"entry_price"`       // 開倉價格
@@ -33,11 +35,19 @@ type Position struct {
 	// PnL info (decimal)
 	RealizedPnL   float64 `json:"realized_pnl"`   // 已實現盈虧
 	UnrealizedPnL float64 `json:"unrealized_pnl"` // 未實現盈虧
+	TradingFees   float64 `json:"trading_fees"`   // 手續費 of the fills settled on this position
 
 	// Timestamp
 	OpenTime   time.Time `json:"open_time"`
 	UpdateTime time.Time `json:"update_time"`
 
+	// dated contract expiry (交割), zero means perpetual
+	ExpiryTime      time.Time `json:"expiry_time"`
+	SettlementPrice float64   `json:"settlement_price"` // close price at expiry, 0 means the mark price
+
+	// free-form labels (e.g. TagStrategy), set through SetTag
+	Tags map[string]string `json:"tags,omitempty"`
+
 	// === Precision Control ===
 	sizePrecision  int8
 	pricePrecision int8
@@ -45,15 +55,53 @@ type Position struct {
 	sizeZero  float64
 	priceZero float64
 
+	// maintenance margin / max leverage tiers (PrecisionSetting.MarginTiers)
+	marginTiers *MarginTierCalculator
+
+	// cross margin: account level equity callback (set by PositionManager)
+	crossEquityProvider CrossMarginEquityProvider
+	// cross margin: account equity without this position's unrealized PnL, pulled from the provider by
+	// refreshCrossEquity outside p.mu. valid while crossEquitySet
+	crossEquity    float64
+	crossEquitySet bool
+	// cross margin: wallet equity captured when switched to cross, used when no provider
+	crossWalletEquity float64
+
+	// exchange-wide maintenance rate floor (set by PositionManager), nil means tier rates only
+	maintenanceOverride *float64
+
+	// liquidation owner took over this position (status alone is set by mark price updates too)
+	liquidationClaimed bool
+	// worker holding the claim and the end of its lease, zero means no expiry
+	liquidationOwner string
+	liquidationLease time.Time
+
+	// pre-liquidation warning sent, until the margin ratio recovers
+	preLiquidationWarned bool
+
+	// last open / add / reduce / mark price update, for stalled position alerts
+	touchedAt time.Time
+
+	// liquidation index of the symbol, told about changes of liquidation price, status and margin mode
+	riskListener func(p *Position)
+
 	// Lock
 	mu sync.RWMutex
 }
 
+// CrossMarginEquityProvider returns the account equity shared by all cross positions of the user, without
+// the unrealized PnL of the excluded position (none when empty). called without any position lock held
+type CrossMarginEquityProvider func(userID, excludePositionID string) (float64, error)
+
 // NewPosition create a init position
 func NewPosition(userID, symbol string, mode common.MarginMode, precisionSetting *PrecisionSetting) *Position {
 	if precisionSetting == nil {
 		precisionSetting = DefaultPrecisionSetting
 	}
+	marginTiers := precisionSetting.MarginTiers
+	if marginTiers == nil {
+		marginTiers = defaultMarginTierCalculator
+	}
 
 	return &Position{
 		ID:             common.GeneratePositionID(),
@@ -69,6 +117,7 @@ func NewPosition(userID, symbol string, mode common.MarginMode, precisionSetting
 		sizePrecision:  precisionSetting.SizePrecision,
 		priceZero:      math.Pow(10, -float64(precisionSetting.PricePrecision)),
 		sizeZero:       math.Pow(10, -float64(precisionSetting.SizePrecision)),
+		marginTiers:    marginTiers,
 		OpenTime:       time.Now(),
 		UpdateTime:     time.Now(),
 	}
@@ -76,6 +125,7 @@ func NewPosition(userID, symbol string, mode common.MarginMode, precisionSetting
 
 // Open Position (開倉)
 func (p *Position) Open(side PositionSide, price float64, size float64, leverage int16) error {
+	defer p.notifyRiskChange() // after unlock
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -97,17 +147,27 @@ func (p *Position) Open(side PositionSide, price float64, size float64, leverage
 	p.LiquidationPrice = p.calculateLiquidationPrice()
 	// time
 	p.UpdateTime = time.Now()
+	p.touchedAt = p.UpdateTime
 
 	return nil
 }
 
-// Add position (加倉)
+// Add position (加倉) under LeverageTierRequireMargin
 func (p *Position) Add(price float64, size float64) error {
+	_, err := p.AddWithTierPolicy(price, size, LeverageTierRequireMargin)
+	return err
+}
+
+// AddWithTierPolicy add position, when the grown position value is in a tier whose max leverage is below
+// the position leverage the policy either rejects the add or lowers the leverage to the tier max
+func (p *Position) AddWithTierPolicy(price float64, size float64, policy LeverageTierPolicy) (TierEnforcement, error) {
+	defer p.notifyRiskChange() // after unlock
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	enforcement := TierEnforcement{Policy: policy, PreviousLeverage: p.Leverage, Leverage: p.Leverage}
 	if p.Status != PositionNormal {
-		return fmt.Errorf("add position failed, position status is not normal")
+		return enforcement, fmt.Errorf("add position failed, position status is not normal")
 	}
 
 	// calculate new open price
@@ -116,47 +176,70 @@ func (p *Position) Add(price float64, size float64) error {
 	newValue := price * size          // 補倉倉位額度
 	totalValue := oldValue + newValue // 合併倉位額度
 	totalSize := p.Size + size        // 合併 Size
+	entryPrice := totalValue / totalSize
+
+	// leverage tier of the grown position
+	marginValue := entryPrice * totalSize
+	enforcement.PositionValue = marginValue
+	enforcement.MaxLeverage = p.marginTiers.MaxLeverage(marginValue)
+	if enforcement.MaxLeverage > 0 && uint(p.Leverage) > enforcement.MaxLeverage {
+		enforcement.Applied = true
+		if policy == LeverageTierReject {
+			return enforcement, fmt.Errorf("%w: %dx above %dx for position value %.2f",
+				ErrLeverageExceedsTier, p.Leverage, enforcement.MaxLeverage, marginValue)
+		}
+		enforcement.Leverage = int16(enforcement.MaxLeverage)
+		enforcement.AdditionalMargin = marginValue/float64(enforcement.Leverage) - marginValue/float64(p.Leverage)
+		p.Leverage = enforcement.Leverage
+	}
 
 	// update entry-price & size
-	p.EntryPrice = totalValue / totalSize
+	p.EntryPrice = entryPrice
 	p.Size = totalSize
 
 	// update mark price & position value (no lock)
 	p.updateMarkPriceAndPositionVal(price)
 
 	// update margin
-	marginValue := p.EntryPrice * totalSize
 	p.InitialMargin = marginValue / float64(p.Leverage)
 	p.MaintenanceMargin = p.calculateMaintenanceMargin()
 	// update l price
 	p.LiquidationPrice = p.calculateLiquidationPrice()
 	// update time
 	p.UpdateTime = time.Now()
+	p.touchedAt = p.UpdateTime
 
-	return nil
+	return enforcement, nil
 }
 
 // Reduce position (減倉) return pnl, error
 func (p *Position) Reduce(price float64, size float64) (pnl float64, err error) {
+	pnl, _, err = p.ReduceWithRelease(price, size)
+	return pnl, err
+}
+
+// ReduceWithRelease reduce position, also return the initial margin released by this reduce (釋放保證金)
+func (p *Position) ReduceWithRelease(price float64, size float64) (pnl float64, marginReleased float64, err error) {
+	defer p.notifyRiskChange() // after unlock
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	if p.Status != PositionNormal {
-		return pnl, fmt.Errorf("reduce position failed, position status is not normal")
+		return pnl, 0, fmt.Errorf("reduce position failed, position status is not normal")
 	}
 
+	return p.reduce(price, size)
+}
+
+// reduce no lock, no status check
+func (p *Position) reduce(price float64, size float64) (pnl float64, marginReleased float64, err error) {
 	if size > p.Size {
-		return pnl, fmt.Errorf("reduce position failed, reduce size exceeds position size")
+		return pnl, 0, fmt.Errorf("reduce position failed, reduce size exceeds position size")
 	}
+	marginBefore := p.InitialMargin
 
 	// calculate and update Realized PnL
-	if p.Side == LONG { // calculate long side
-		// long_pnl = (price - EntryPrice) * size
-		pnl = (price - p.EntryPrice) * size
-	} else { // calculate short side
-		// short_pnl = (EntryPrice - price) * size
-		pnl = (p.EntryPrice - price) * size
-	}
+	pnl = PositionMath{}.CalculateUnrealizedPnL(p.Side, p.EntryPrice, price, size)
 	p.RealizedPnL = p.RealizedPnL + pnl
 
 	// reduce position size
@@ -181,8 +264,9 @@ func (p *Position) Reduce(price float64, size float64) (pnl float64, err error)
 
 	// update time
 	p.UpdateTime = time.Now()
+	p.touchedAt = p.UpdateTime
 
-	return pnl, nil
+	return pnl, marginBefore - p.InitialMargin, nil
 }
 
 // Close position（全部平倉）
@@ -193,24 +277,254 @@ func (p *Position) Close(price float64) (float64, error) {
 
 // UpdateMarkPrice (更新標記價格)
 func (p *Position) UpdateMarkPrice(markPrice float64) {
+	p.refreshCrossEquity()
+	p.updateMarkPriceStatus(markPrice)
+}
+
+// updateMarkPriceStatus update mark price, return the status before and after under the same lock
+func (p *Position) updateMarkPriceStatus(markPrice float64) (before PositionStatus, after PositionStatus) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	before = p.Status
 	if p.Status != PositionNormal {
 		// only normal position can be updated.
-		return
+		return before, p.Status
 	}
 
 	p.updateMarkPriceAndPositionVal(markPrice)
 	p.calculateLiquidationPrice()
+	p.touchedAt = time.Now()
 
 	if p.isLiquidatable() {
 		p.Status = PositionLiquidating
 	}
+	return before, p.Status
+}
+
+// ClaimLiquidation take single ownership of a liquidatable position, Normal -> Liquidating.
+// a position already flipped to Liquidating by a mark price update can still be claimed once.
+func (p *Position) ClaimLiquidation() bool {
+	return p.ClaimLiquidationLease("", 0, time.Now())
+}
+
+// ClaimLiquidationLease (強平租約) ClaimLiquidation on behalf of owner until now + lease, 0 means no expiry.
+// once the lease ran out the next claimer takes the open position over, liquidatable or not,
+// the previous owner may have left it half closed.
+func (p *Position) ClaimLiquidationLease(owner string, lease time.Duration, now time.Time) bool {
+	return p.claimLiquidationLease(owner, lease, now, false)
+}
+
+// ClaimAccountLiquidationLease ClaimLiquidationLease for a cross account that breached as a whole:
+// the open position is claimed whether or not it is liquidatable on its own
+func (p *Position) ClaimAccountLiquidationLease(owner string, lease time.Duration, now time.Time) bool {
+	return p.claimLiquidationLease(owner, lease, now, true)
+}
+
+func (p *Position) claimLiquidationLease(owner string, lease time.Duration, now time.Time, account bool) bool {
+	p.refreshCrossEquity()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.liquidationClaimed {
+		if p.liquidationLease.IsZero() || now.Before(p.liquidationLease) || p.Size <= p.ZeroSize() {
+			return false
+		}
+	} else if account {
+		if p.Status == PositionClosed || p.Size <= p.ZeroSize() {
+			return false
+		}
+	} else if !p.isLiquidatable() {
+		return false
+	}
+	p.Status = PositionLiquidating
+	p.liquidationClaimed = true
+	p.setLiquidationLease(owner, lease, now)
+	p.UpdateTime = now
+	return true
+}
+
+// RenewLiquidationLease extend the claim of owner to now + lease (0: no expiry), false once it was lost
+func (p *Position) RenewLiquidationLease(owner string, lease time.Duration, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.liquidationClaimed || p.liquidationOwner != owner {
+		return false
+	}
+	p.setLiquidationLease(owner, lease, now)
+	return true
+}
+
+// LiquidationLease owner and lease end of the current claim, false when not claimed
+func (p *Position) LiquidationLease() (owner string, expiresAt time.Time, claimed bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.liquidationOwner, p.liquidationLease, p.liquidationClaimed
+}
+
+// AbandonLiquidation give the claim of owner back without closing the rest: Liquidating for the next
+// claimer while still liquidatable, Normal otherwise
+func (p *Position) AbandonLiquidation(owner string) bool {
+	defer p.notifyRiskChange() // after unlock
+	p.refreshCrossEquity()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.liquidationClaimed || p.liquidationOwner != owner {
+		return false
+	}
+	p.liquidationClaimed = false
+	p.setLiquidationLease("", 0, time.Time{})
+	if p.Status == PositionLiquidating && !p.isLiquidatable() {
+		p.Status = PositionNormal
+	}
+	p.UpdateTime = time.Now()
+	return true
+}
+
+func (p *Position) setLiquidationLease(owner string, lease time.Duration, now time.Time) {
+	p.liquidationOwner = owner
+	p.liquidationLease = time.Time{}
+	if lease > 0 {
+		p.liquidationLease = now.Add(lease)
+	}
+}
+
+// ReduceForLiquidation (強平減倉) only for a claimed position, closes it once size reaches zero
+func (p *Position) ReduceForLiquidation(price float64, size float64) (pnl float64, marginReleased float64, err error) {
+	return p.ReduceForLiquidationAs("", price, size)
+}
+
+// ReduceForLiquidationAs ReduceForLiquidation fenced by the claim owner, a worker whose lease was
+// taken over can no longer reduce. an empty owner accepts any claim
+func (p *Position) ReduceForLiquidationAs(owner string, price float64, size float64) (pnl float64, marginReleased float64, err error) {
+	defer p.notifyRiskChange() // after unlock
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Status != PositionLiquidating || !p.liquidationClaimed {
+		return 0, 0, fmt.Errorf("position %s not claimed for liquidation", p.ID)
+	}
+	if owner != "" && p.liquidationOwner != owner {
+		return 0, 0, fmt.Errorf("position %s claimed by %s, not %s", p.ID, p.liquidationOwner, owner)
+	}
+	return p.reduce(price, size)
+}
+
+// ReleaseLiquidation give a claimed position back, Liquidating -> Normal, once its margin ratio is at least
+// the maintenance ratio plus buffer (percentage points). used between tranches of a partial liquidation.
+func (p *Position) ReleaseLiquidation(buffer float64) bool {
+	defer p.notifyRiskChange() // after unlock
+	p.refreshCrossEquity()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Status != PositionLiquidating || !p.liquidationClaimed || p.Size <= p.ZeroSize() || p.PositionValue <= 0 {
+		return false
+	}
+	maintenanceRatio := p.MaintenanceMargin / p.PositionValue * 100
+	if p.getMarginRatio() < maintenanceRatio+buffer {
+		return false
+	}
+	p.Status = PositionNormal
+	p.liquidationClaimed = false
+	p.setLiquidationLease("", 0, time.Time{})
+	p.UpdateTime = time.Now()
+	return true
+}
+
+// AddTradingFee record the fee of a fill settled on this position
+func (p *Position) AddTradingFee(fee float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.TradingFees += fee
+	p.UpdateTime = time.Now()
+}
+
+// BankruptcyPrice (破產價格) price at which the position's initial margin is fully lost
+func (p *Position) BankruptcyPrice() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.Size <= p.ZeroSize() {
+		return 0
+	}
+	if p.Side == LONG {
+		return max(p.EntryPrice-p.InitialMargin/p.Size, 0)
+	}
+	return p.EntryPrice + p.InitialMargin/p.Size
+}
+
+// ReclassifyMarginMode switch margin mode (全倉/逐倉), crossEquity is the wallet equity backing a cross position.
+// liquidation price is kept, only the margin ratio computation changes.
+func (p *Position) ReclassifyMarginMode(newMode common.MarginMode, crossEquity float64) error {
+	defer p.notifyRiskChange() // after unlock
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Status == PositionClosed {
+		return fmt.Errorf("can not switch margin mode of a closed position")
+	}
+
+	p.MarginMode = newMode
+	p.crossEquitySet = false // refreshed on the next read
+	if newMode == common.CROSS {
+		p.crossWalletEquity = crossEquity
+	} else {
+		p.crossWalletEquity = 0
+	}
+	p.UpdateTime = time.Now()
+
+	return nil
+}
+
+// AddMargin add isolated margin to the position (追加保證金), liquidation price moves away
+func (p *Position) AddMargin(amount float64) error {
+	defer p.notifyRiskChange() // after unlock
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if amount <= 0 {
+		return fmt.Errorf("amount must be greater than zero")
+	}
+	if p.Status != PositionNormal {
+		return fmt.Errorf("add margin failed, position status is not normal")
+	}
+	if p.MarginMode != common.ISOLATED {
+		return fmt.Errorf("add margin failed, cross position uses account balance")
+	}
+
+	p.InitialMargin += amount
+	p.calculateLiquidationPrice()
+	p.UpdateTime = time.Now()
+
+	return nil
+}
+
+// GetRequiredDepositToAvoidLiquidation deposit needed to bring equity back to
+// MaintenanceMargin * safetyFactor, 0 if already above. safetyFactor <= 0 means DefaultDepositSafetyFactor.
+// read only, no side effect. MarginSystem.GetRequiredDepositToAvoidLiquidation applies the configured factor
+func (p *Position) GetRequiredDepositToAvoidLiquidation(safetyFactor float64) float64 {
+	p.refreshCrossEquity()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if safetyFactor <= 0 {
+		safetyFactor = DefaultDepositSafetyFactor
+	}
+	if p.Status == PositionClosed || p.Size <= p.ZeroSize() {
+		return 0
+	}
+
+	needed := p.MaintenanceMargin*safetyFactor - p.getEquity()
+	return max(needed, 0)
 }
 
 // GetMarginRatio (保證金率)
 func (p *Position) GetMarginRatio() float64 {
+	p.refreshCrossEquity()
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -219,6 +533,7 @@ func (p *Position) GetMarginRatio() float64 {
 
 // IsLiquidatable (可清算)
 func (p *Position) IsLiquidatable() bool {
+	p.refreshCrossEquity()
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -232,6 +547,7 @@ func (p *Position) GetRoi() float64 {
 
 // GetDisplayInfo（用於顯示）
 func (p *Position) GetDisplayInfo() map[string]interface{} {
+	p.refreshCrossEquity()
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -261,12 +577,12 @@ func (p *Position) GetDisplayInfo() map[string]interface{} {
 
 // calculateMaintenanceMargin calculate Maintenance Margin value
 func (p *Position) calculateMaintenanceMargin() float64 {
-	for _, t := range DefaultMarginTiers {
-		if p.PositionValue >= t.MinValue && p.PositionValue <= t.MaxValue {
-			return p.PositionValue * t.MaintenanceRate
-		}
+	maintenanceMargin := p.marginTiers.Calculate(p.PositionValue)
+	if p.maintenanceOverride != nil {
+		// max(tier rate, override)
+		maintenanceMargin = max(maintenanceMargin, p.PositionValue*(*p.maintenanceOverride))
 	}
-	return 0
+	return maintenanceMargin
 }
 
 // calculateLiquidationPrice (強平價格)
@@ -274,26 +590,7 @@ func (p *Position) calculateLiquidationPrice() float64 {
 	if p.Size <= 0 {
 		return 0
 	}
-
-	// Liquidation Price Formula:
-	// (LONG) :  LiquidationPrice = EntryPrice - (InitialMargin - MaintenanceMargin) / Size
-	// (SHORT):  LiquidationPrice = EntryPrice + (InitialMargin - MaintenanceMargin) / Size
-
-	// 理解公式：假如初始押金我投入 10000 USDT，我開倉數量為 10 顆 FZO 幣，不考慮維持保證金情況下，我每一顆 FZO 的押金是 10000/10 = 1000
-	// 		   相當於我每一個 FZO 幣最多虧損 1000 元就應概要被強制平倉．
-	//         假如我的開倉價為 100000 USDT：
-	//        		多頭強平價就是 100000-1000 = 99000  USDT
-	//        		空頭強平價就是 100000+1000 = 110000 USDT
-
-	marginBuffer := p.InitialMargin - p.MaintenanceMargin // 保證金緩衝額 = 初始放入的押金 - 滑價保險額度
-	priceBuffer := marginBuffer / p.Size                  // 價格緩衝額   = 保證金緩衝額 / 倉位數量
-
-	if p.Side == LONG { // LONG side
-		p.LiquidationPrice = p.EntryPrice - priceBuffer
-	} else { // SHORT side
-		p.LiquidationPrice = p.EntryPrice + priceBuffer
-	}
-
+	p.LiquidationPrice = PositionMath{}.CalculateLiquidationPrice(p.Side, p.EntryPrice, p.InitialMargin, p.MaintenanceMargin, p.Size)
 	return p.LiquidationPrice
 }
 
@@ -307,13 +604,7 @@ func (p *Position) updateMarkPriceAndPositionVal(markPrice float64) {
 	}
 
 	// calculate unrealized PnL
-	if p.Side == LONG { // long side
-		// formula = (markPrice - entryPrice) * size
-		p.UnrealizedPnL = (markPrice - p.EntryPrice) * p.Size
-	} else { // short side
-		// formula = (entryPrice - markPrice) * size
-		p.UnrealizedPnL = (p.EntryPrice - markPrice) * p.Size
-	}
+	p.UnrealizedPnL = PositionMath{}.CalculateUnrealizedPnL(p.Side, p.EntryPrice, markPrice, p.Size)
 
 	p.PositionValue = p.MarkPrice * p.Size
 }
@@ -324,24 +615,29 @@ func (p *Position) getMarginRatio() float64 {
 		return 100 // safe
 	}
 
-	if p.PositionValue <= 0 {
-		return 100
-	}
-
 	// MarginRatio Formula:
 	// MarginRatio = (MarginAccount Equity Value / Position Value) * 100%
-
-	// cross: TODO
-	// Isolated: (InitialMargin + UnrealizedPnL) / (MarkPrice * Size)
-	accountEquity := 0.0
-	switch p.MarginMode {
-	case common.CROSS:
-		panic("not implemented cross mode yet")
-	case common.ISOLATED:
-		accountEquity = p.InitialMargin + p.UnrealizedPnL
+	if p.MarginMode != common.CROSS {
+		return PositionMath{}.CalculateMarginRatio(p.InitialMargin, p.UnrealizedPnL, p.PositionValue)
 	}
+	// cross equity already includes the unrealized PnL
+	return PositionMath{}.CalculateMarginRatio(p.getEquity(), 0, p.PositionValue)
+}
 
-	return accountEquity / p.PositionValue * 100
+// getEquity equity backing the position (保證金權益) no lock
+// Cross: Balance + UnrealizedPnL of the whole account, the position's own PnL live
+// Isolated: InitialMargin + UnrealizedPnL
+func (p *Position) getEquity() float64 {
+	if p.MarginMode == common.CROSS {
+		// refreshed account equity first, then wallet equity from mode switch, otherwise position's own margin
+		if p.crossEquitySet {
+			return p.crossEquity + p.UnrealizedPnL
+		}
+		if p.crossWalletEquity > 0 {
+			return p.crossWalletEquity
+		}
+	}
+	return p.InitialMargin + p.UnrealizedPnL
 }
 
 func (p *Position) isLiquidatable() bool {
@@ -362,6 +658,62 @@ func (p *Position) isLiquidatable() bool {
 	return marginRatio <= maintenanceRatio
 }
 
+func (p *Position) setRiskListener(listener func(p *Position)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.riskListener = listener
+}
+
+// notifyRiskChange call the listener outside the position lock
+func (p *Position) notifyRiskChange() {
+	p.mu.RLock()
+	listener := p.riskListener
+	p.mu.RUnlock()
+
+	if listener != nil {
+		listener(p)
+	}
+}
+
+func (p *Position) setCrossEquityProvider(provider CrossMarginEquityProvider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.crossEquityProvider = provider
+	p.crossEquitySet = false
+}
+
+// refreshCrossEquity pull the account equity of a cross position from the provider. the provider reads the
+// user's other positions, so it is called before taking p.mu, never under it
+func (p *Position) refreshCrossEquity() {
+	p.mu.RLock()
+	provider, userID, positionID := p.crossEquityProvider, p.UserID, p.ID
+	cross := p.MarginMode == common.CROSS
+	p.mu.RUnlock()
+	if !cross || provider == nil {
+		return
+	}
+
+	equity, err := provider(userID, positionID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.crossEquity, p.crossEquitySet = equity, err == nil
+}
+
+// setMaintenanceOverride recompute maintenance margin and liquidation price of an open position under the
+// override, nil clears it. the status follows on the next mark price update
+func (p *Position) setMaintenanceOverride(rate *float64) {
+	defer p.notifyRiskChange() // after unlock
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maintenanceOverride = rate
+	if p.Status == PositionClosed || p.Size <= p.ZeroSize() {
+		return
+	}
+	p.MaintenanceMargin = p.calculateMaintenanceMargin()
+	p.calculateLiquidationPrice()
+}
+
 func (p *Position) ZeroSize() float64 {
 	return p.sizeZero
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"frizo/futures_engine/internal/common"
 	"math/rand"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -471,3 +472,71 @@ func BenchmarkLegacyComparison(b *testing.B) {
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
+
+// BenchmarkDefragment heap in use after 10,000 open-then-close cycles, before and after Defragment
+func BenchmarkDefragment(b *testing.B) {
+	heapInUse := func() uint64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return stats.HeapInuse
+	}
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pm := NewPositionManager([]string{"BTCUSDT"})
+		for u := 0; u < 10000; u++ {
+			userID := fmt.Sprintf("user_%d", u)
+			pm.OpenPosition(common.ISOLATED, userID, "BTCUSDT", LONG, 50000, 1, 10)
+			pm.ClosePosition(userID, "BTCUSDT", LONG, 50000)
+		}
+		// drop closed positions kept by the symbol index
+		pm.UpdateMarkPrices("BTCUSDT", 50000)
+		before := heapInUse()
+		b.StartTimer()
+
+		pm.Defragment()
+
+		b.StopTimer()
+		after := heapInUse()
+		b.ReportMetric(float64(before)/1024, "KiB_before")
+		b.ReportMetric(float64(after)/1024, "KiB_after")
+		runtime.KeepAlive(pm)
+		b.StartTimer()
+	}
+}
+
+// BenchmarkUpdateMarkPrices linear scan vs liquidation index, mark moves ±1% so nothing liquidates
+func BenchmarkUpdateMarkPrices(b *testing.B) {
+	for _, count := range []int{10_000, 100_000, 1_000_000} {
+		for _, indexed := range []bool{false, true} {
+			name := fmt.Sprintf("linear_%d", count)
+			if indexed {
+				name = fmt.Sprintf("indexed_%d", count)
+			}
+			b.Run(name, func(b *testing.B) {
+				if count >= 1_000_000 && testing.Short() {
+					b.Skip("skipping 1M positions in short mode")
+				}
+				pm := NewPositionManager([]string{"BTCUSDT"})
+				defer pm.Close()
+				pm.UseLiquidationIndex(indexed)
+				rng := rand.New(rand.NewSource(1))
+				for i := 0; i < count; i++ {
+					side := LONG
+					if i%2 == 1 {
+						side = SHORT
+					}
+					leverage := uint(2 + rng.Intn(19))
+					pm.OpenPosition(common.ISOLATED, fmt.Sprintf("user_%d", i), "BTCUSDT", side, 50000, 1, leverage)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					price := 50000 * (1 + 0.01*float64(i%2*2-1))
+					pm.UpdateMarkPrices("BTCUSDT", price)
+				}
+			})
+		}
+	}
+}
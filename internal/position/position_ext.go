@@ -1,15 +1,24 @@
 package position
 
 import (
+	"errors"
 	"fmt"
+	"frizo/futures_engine/internal/common"
+	"sort"
 	"sync"
+	"time"
 )
 
 // atomic position slice ==================================================================
 
 type AtomicPositions struct {
 	slice []*Position
+	index *liquidationIndex
 	mutex sync.RWMutex
+
+	// open interest (未平倉量), maintained incrementally by PositionManager
+	longOpenInterest  float64
+	shortOpenInterest float64
 }
 
 func (ap *AtomicPositions) Len() int {
@@ -22,6 +31,27 @@ func (ap *AtomicPositions) Append(p *Position) {
 	ap.mutex.Lock()
 	defer ap.mutex.Unlock()
 	ap.slice = append(ap.slice, p)
+
+	if ap.index != nil {
+		ap.index.upsert(p)
+		p.setRiskListener(ap.reindex)
+	}
+}
+
+// reindex risk listener of every appended position
+func (ap *AtomicPositions) reindex(p *Position) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	ap.index.upsert(p)
+}
+
+// Positions copy of the slice
+func (ap *AtomicPositions) Positions() []*Position {
+	ap.mutex.RLock()
+	defer ap.mutex.RUnlock()
+	positions := make([]*Position, len(ap.slice))
+	copy(positions, ap.slice)
+	return positions
 }
 
 func (ap *AtomicPositions) Remove(index int) *Position {
@@ -39,46 +69,130 @@ func (ap *AtomicPositions) remove(index int) *Position {
 }
 
 func (ap *AtomicPositions) UpdateMarkPrice(price float64) []*Position {
+	ap.refreshCrossEquity()
+	return ap.updateMarkPrice(price, nil)
+}
+
+// refreshCrossEquity account equity of the cross positions ahead of a mark price update, outside ap.mutex:
+// the equity provider reads the user's positions of every symbol
+func (ap *AtomicPositions) refreshCrossEquity() {
+	ap.mutex.RLock()
+	var positions []*Position
+	if ap.index != nil {
+		positions = make([]*Position, 0, len(ap.index.unindexed)) // the open cross positions
+		for pos := range ap.index.unindexed {
+			positions = append(positions, pos)
+		}
+	} else {
+		positions = make([]*Position, len(ap.slice))
+		copy(positions, ap.slice)
+	}
+	ap.mutex.RUnlock()
+
+	for _, pos := range positions {
+		pos.refreshCrossEquity()
+	}
+}
+
+// updateMarkPrice UpdateMarkPrice, positions still normal go through the pre-liquidation warner if any
+func (ap *AtomicPositions) updateMarkPrice(price float64, warner *preLiquidationWarner) []*Position {
 	ap.mutex.Lock()
 	defer ap.mutex.Unlock()
 
 	liquidateList := make([]*Position, 0)
 
-	for idx, pos := range ap.slice {
+	// compact in place, removing while ranging would skip / overrun entries
+	kept := ap.slice[:0]
+	for _, pos := range ap.slice {
 
-		if pos.Status != PositionNormal { // if status is not normal, just skip.
+		before, after := pos.updateMarkPriceStatus(price) // update mark price.
+
+		if before == PositionClosed { // closed by reduce / close, clean it.
+			continue
+		}
+		if before != PositionNormal { // if status is not normal, just skip.
+			kept = append(kept, pos)
 			continue
 		}
-
-		pos.UpdateMarkPrice(price) // update mark price.
 
 		// clean the position slice.
-		switch pos.Status {
+		switch after {
 		case PositionClosed:
-			ap.remove(idx)
-			break
 		case PositionLiquidating:
 			liquidateList = append(liquidateList, pos)
-			ap.remove(idx)
-			break
 		default:
-			break
+			kept = append(kept, pos)
+			if warner != nil {
+				warner.check(pos)
+			}
 		}
 	}
+	clear(ap.slice[len(kept):])
+	ap.slice = kept
 
 	return liquidateList
 }
 
+// UpdateMarkPriceIndexed only positions whose liquidation price the mark crossed (and cross margin positions)
+// get the new mark price and a liquidation check. the slice is not compacted, UpdateMarkPrice does that.
+func (ap *AtomicPositions) UpdateMarkPriceIndexed(price float64) []*Position {
+	ap.refreshCrossEquity()
+	return ap.updateMarkPriceIndexed(price, nil)
+}
+
+func (ap *AtomicPositions) updateMarkPriceIndexed(price float64, warner *preLiquidationWarner) []*Position {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+
+	liquidateList := make([]*Position, 0)
+	for _, pos := range ap.index.popCrossed(price) {
+		before, after := pos.updateMarkPriceStatus(price)
+		if before == PositionNormal && after == PositionLiquidating {
+			liquidateList = append(liquidateList, pos)
+		} else if after == PositionNormal && warner != nil {
+			warner.check(pos)
+		}
+		ap.index.upsert(pos) // still normal: back in, otherwise dropped
+	}
+	return liquidateList
+}
+
+// adjustOpenInterest add signed size delta to one side
+func (ap *AtomicPositions) adjustOpenInterest(side PositionSide, delta float64) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+
+	if side == LONG {
+		ap.longOpenInterest = max(ap.longOpenInterest+delta, 0)
+	} else {
+		ap.shortOpenInterest = max(ap.shortOpenInterest+delta, 0)
+	}
+}
+
+func (ap *AtomicPositions) openInterest() (long float64, short float64) {
+	ap.mutex.RLock()
+	defer ap.mutex.RUnlock()
+	return ap.longOpenInterest, ap.shortOpenInterest
+}
+
 // symbol: userPositions ==================================================================
 
+var ErrSymbolNotFound = errors.New("symbol not found")
+
 type SymbolPositions struct {
-	container map[string]*AtomicPositions
-	mu        sync.RWMutex
+	container     map[string]*AtomicPositions
+	lastMarkPrice map[string]float64                        // symbol -> latest mark price
+	priceHistory  map[string]*common.RingBuffer[PricePoint] // symbol -> recent mark prices
+	indexed       bool                                      // mark price updates go through the liquidation index
+	warner        *preLiquidationWarner
+	mu            sync.RWMutex
 }
 
 func NewSymbolPositions(symbols []string) *SymbolPositions {
 	sp := &SymbolPositions{
-		container: make(map[string]*AtomicPositions),
+		container:     make(map[string]*AtomicPositions),
+		lastMarkPrice: make(map[string]float64),
+		priceHistory:  make(map[string]*common.RingBuffer[PricePoint]),
 	}
 
 	for _, symbol := range symbols {
@@ -94,7 +208,9 @@ func (s *SymbolPositions) AddSymbol(symbol string) {
 	if _, ok := s.container[symbol]; !ok {
 		s.container[symbol] = &AtomicPositions{
 			slice: make([]*Position, 0),
+			index: newLiquidationIndex(),
 		}
+		s.priceHistory[symbol] = common.NewRingBuffer[PricePoint](MarkPriceHistorySize)
 	}
 }
 
@@ -103,6 +219,8 @@ func (s *SymbolPositions) RemoveSymbol(symbol string) {
 	defer s.mu.Unlock()
 	if _, ok := s.container[symbol]; ok {
 		delete(s.container, symbol)
+		delete(s.lastMarkPrice, symbol)
+		delete(s.priceHistory, symbol)
 	}
 }
 
@@ -111,15 +229,164 @@ func (s *SymbolPositions) AddPosition(symbol string, position *Position) error {
 		atomicPositions.Append(position)
 		return nil
 	} else {
-		return fmt.Errorf("symbol %s not exist", symbol)
+		return fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+}
+
+// MarkPriceHistorySize mark prices kept per symbol for GetMarkPriceHistory
+const MarkPriceHistorySize = 1000
+
+// PricePoint one mark price update
+type PricePoint struct {
+	Price     float64   `json:"price"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GetMarkPrices copy of the latest mark price of every symbol that had one
+func (s *SymbolPositions) GetMarkPrices() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prices := make(map[string]float64, len(s.lastMarkPrice))
+	for symbol, price := range s.lastMarkPrice {
+		prices[symbol] = price
+	}
+	return prices
+}
+
+// GetMarkPriceHistory the last limit mark prices of the symbol, oldest first. limit <= 0 returns all kept
+func (s *SymbolPositions) GetMarkPriceHistory(symbol string, limit int) ([]PricePoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history, ok := s.priceHistory[symbol]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
 	}
+	points := history.Items()
+	if limit > 0 && limit < len(points) {
+		points = points[len(points)-limit:]
+	}
+	return points, nil
 }
 
-// UpdateMarkPrice return liquidateList
+// UpdateMarkPrice record the symbol's mark price and push it to every position, return liquidateList
 func (s *SymbolPositions) UpdateMarkPrice(symbol string, price float64) ([]*Position, error) {
+	s.mu.Lock()
+	atomicPositions, ok := s.container[symbol]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+	s.lastMarkPrice[symbol] = price
+	s.priceHistory[symbol].Add(PricePoint{Price: price, Timestamp: time.Now()})
+	indexed, warner := s.indexed, s.warner
+	s.mu.Unlock()
+
+	atomicPositions.refreshCrossEquity()
+	if indexed {
+		return atomicPositions.updateMarkPriceIndexed(price, warner), nil
+	}
+	return atomicPositions.updateMarkPrice(price, warner), nil
+}
+
+func (s *SymbolPositions) setWarner(warner *preLiquidationWarner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.warner = warner
+}
+
+// SetIndexed switch UpdateMarkPrice between the liquidation index and the full scan
+func (s *SymbolPositions) SetIndexed(indexed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexed = indexed
+}
+
+// RefreshMarkPrice full scan with the latest mark price: every position gets it and the slice is compacted
+func (s *SymbolPositions) RefreshMarkPrice(symbol string) ([]*Position, error) {
+	s.mu.RLock()
+	atomicPositions, ok := s.container[symbol]
+	price, warner := s.lastMarkPrice[symbol], s.warner
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+	if price <= 0 {
+		return nil, nil
+	}
+	atomicPositions.refreshCrossEquity()
+	return atomicPositions.updateMarkPrice(price, warner), nil
+}
+
+// GetMarkPrice latest mark price passed to UpdateMarkPrice, 0 if the symbol has not been marked yet
+func (s *SymbolPositions) GetMarkPrice(symbol string) (float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.container[symbol]; !ok {
+		return 0, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+	return s.lastMarkPrice[symbol], nil
+}
+
+// GetPositions all tracked positions of the symbol (may include closed ones not cleaned yet)
+func (s *SymbolPositions) GetPositions(symbol string) ([]*Position, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if atomicPositions, ok := s.container[symbol]; ok {
+		return atomicPositions.Positions(), nil
+	} else {
+		return nil, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+}
+
+// AdjustOpenInterest add signed size delta to the symbol's open interest
+func (s *SymbolPositions) AdjustOpenInterest(symbol string, side PositionSide, delta float64) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if atomicPositions, ok := s.container[symbol]; ok {
+		atomicPositions.adjustOpenInterest(side, delta)
+		return nil
+	} else {
+		return fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+}
+
+// GetOpenInterest return total open long and short size of the symbol
+func (s *SymbolPositions) GetOpenInterest(symbol string) (long float64, short float64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if atomicPositions, ok := s.container[symbol]; ok {
-		return atomicPositions.UpdateMarkPrice(price), nil
+		long, short = atomicPositions.openInterest()
+		return long, short, nil
 	} else {
-		return nil, fmt.Errorf("symbol %s not exist", symbol)
+		return 0, 0, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
 	}
 }
+
+// GetAllSymbols return all known symbols, sorted
+func (s *SymbolPositions) GetAllSymbols() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	symbols := make([]string, 0, len(s.container))
+	for symbol := range s.container {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// HasSymbol
+func (s *SymbolPositions) HasSymbol(symbol string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.container[symbol]
+	return ok
+}
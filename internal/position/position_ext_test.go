@@ -46,3 +46,24 @@ func TestAtomicPositions(t *testing.T) {
 	assert.Equal(t, 1, atomicPositions.Len())
 
 }
+
+func TestSymbolPositionsGetMarkPrice(t *testing.T) {
+	sp := NewSymbolPositions([]string{"BTCUSDT"})
+
+	price, err := sp.GetMarkPrice("BTCUSDT")
+	assert.NoError(t, err)
+	assert.Zero(t, price, "not marked yet")
+
+	for _, markPrice := range []float64{50000, 50100, 49900, 50250, 50050} {
+		_, err = sp.UpdateMarkPrice("BTCUSDT", markPrice)
+		assert.NoError(t, err)
+	}
+	price, err = sp.GetMarkPrice("BTCUSDT")
+	assert.NoError(t, err)
+	assert.Equal(t, 50050.0, price)
+
+	_, err = sp.GetMarkPrice("DOGEUSDT")
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+	_, err = sp.UpdateMarkPrice("DOGEUSDT", 1)
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
@@ -0,0 +1,65 @@
+package position
+
+// PositionMath (倉位計算) pure PnL and margin formulas, Position delegates to it
+type PositionMath struct{}
+
+// CalculateUnrealizedPnL
+// (LONG) : (markPrice - entryPrice) * size
+// (SHORT): (entryPrice - markPrice) * size
+func (PositionMath) CalculateUnrealizedPnL(side PositionSide, entryPrice, markPrice, size float64) float64 {
+	if side == LONG {
+		return (markPrice - entryPrice) * size
+	}
+	return (entryPrice - markPrice) * size
+}
+
+// CalculateLiquidationPrice (強平價格)
+// (LONG) : entryPrice - (initialMargin - maintenanceMargin) / size
+// (SHORT): entryPrice + (initialMargin - maintenanceMargin) / size
+func (PositionMath) CalculateLiquidationPrice(side PositionSide, entryPrice, initialMargin, maintenanceMargin, size float64) float64 {
+	if size <= 0 {
+		return 0
+	}
+
+	// 理解公式：假如初始押金我投入 10000 USDT，我開倉數量為 10 顆 FZO 幣，不考慮維持保證金情況下，我每一顆 FZO 的押金是 10000/10 = 1000
+	// 		   相當於我每一個 FZO 幣最多虧損 1000 元就應概要被強制平倉．
+	//         假如我的開倉價為 100000 USDT：
+	//        		多頭強平價就是 100000-1000 = 99000  USDT
+	//        		空頭強平價就是 100000+1000 = 110000 USDT
+
+	marginBuffer := initialMargin - maintenanceMargin // 保證金緩衝額 = 初始放入的押金 - 滑價保險額度
+	priceBuffer := marginBuffer / size                // 價格緩衝額   = 保證金緩衝額 / 倉位數量
+
+	if side == LONG {
+		return entryPrice - priceBuffer
+	}
+	return entryPrice + priceBuffer
+}
+
+// CalculateMarginRatio (保證金率) (initialMargin + unrealizedPnL) / positionValue * 100%, 100 when there is no position value
+func (PositionMath) CalculateMarginRatio(initialMargin, unrealizedPnL, positionValue float64) float64 {
+	if positionValue <= 0 {
+		return 100
+	}
+	return (initialMargin + unrealizedPnL) / positionValue * 100
+}
+
+// CalculateMaintenanceMargin (維持保證金) positionValue * maintenance rate of the first matching tier, 0 outside every tier
+func (PositionMath) CalculateMaintenanceMargin(positionValue float64, tiers []MarginTier) float64 {
+	for _, t := range tiers {
+		if positionValue >= t.MinValue && positionValue <= t.MaxValue {
+			return positionValue * t.MaintenanceRate
+		}
+	}
+	return 0
+}
+
+// MaxLeverage (最大槓桿) max leverage of the first tier matching positionValue, 0 outside every tier
+func (PositionMath) MaxLeverage(positionValue float64, tiers []MarginTier) uint {
+	for _, t := range tiers {
+		if positionValue >= t.MinValue && positionValue <= t.MaxValue {
+			return t.MaxLeverage
+		}
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package position
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPositionMathUnrealizedPnL(t *testing.T) {
+	tests := []struct {
+		name                        string
+		side                        PositionSide
+		entryPrice, markPrice, size float64
+		expected                    float64
+	}{
+		{"long profit", LONG, 50000, 51000, 1, 1000},
+		{"long loss", LONG, 50000, 48000, 0.5, -1000},
+		{"short profit", SHORT, 50000, 48000, 0.5, 1000},
+		{"short loss", SHORT, 3000, 3150, 10, -1500},
+		{"flat", LONG, 50000, 50000, 2, 0},
+		{"no size", SHORT, 50000, 40000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, PositionMath{}.CalculateUnrealizedPnL(tt.side, tt.entryPrice, tt.markPrice, tt.size), 1e-9)
+		})
+	}
+}
+
+func TestPositionMathLiquidationPrice(t *testing.T) {
+	tests := []struct {
+		name                                         string
+		side                                         PositionSide
+		entryPrice, initialMargin, maintenance, size float64
+		expected                                     float64
+	}{
+		// 10 FZO @ 100000 with 10000 margin: 1000 of margin per coin
+		{"long without maintenance", LONG, 100000, 10000, 0, 10, 99000},
+		{"short without maintenance", SHORT, 100000, 10000, 0, 10, 101000},
+		// 1 BTC @ 50000 10x, 0.4% maintenance on 50000 = 200
+		{"long 10x", LONG, 50000, 5000, 200, 1, 45200},
+		{"short 10x", SHORT, 50000, 5000, 200, 1, 54800},
+		// 125x: margin 400, maintenance 200 -> 0.4% away
+		{"long 125x", LONG, 50000, 400, 200, 1, 49800},
+		{"no size", LONG, 50000, 5000, 200, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PositionMath{}.CalculateLiquidationPrice(tt.side, tt.entryPrice, tt.initialMargin, tt.maintenance, tt.size)
+			assert.InDelta(t, tt.expected, got, 1e-9)
+		})
+	}
+}
+
+func TestPositionMathMarginRatio(t *testing.T) {
+	tests := []struct {
+		name                                        string
+		initialMargin, unrealizedPnL, positionValue float64
+		expected                                    float64
+	}{
+		{"10x at entry", 5000, 0, 50000, 10},
+		{"10x after 4% drop", 5000, -2000, 48000, 6.25},
+		{"profit", 5000, 5000, 55000, 10000.0 / 55000 * 100},
+		{"bankrupt", 5000, -5000, 45000, 0},
+		{"no position value", 5000, 0, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PositionMath{}.CalculateMarginRatio(tt.initialMargin, tt.unrealizedPnL, tt.positionValue)
+			assert.InDelta(t, tt.expected, got, 1e-9)
+		})
+	}
+}
+
+func TestPositionMathMaintenanceMargin(t *testing.T) {
+	tests := []struct {
+		name          string
+		positionValue float64
+		expected      float64
+	}{
+		{"tier 1", 10000, 40},
+		{"tier 1 upper bound", 50000, 200},
+		{"tier 2", 100000, 500},
+		{"tier 3", 500000, 5000},
+		{"tier 4", 2000000, 50000},
+		{"tier 5", 8000000, 400000},
+		{"tier 8", 100000000, 15000000},
+		{"zero", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, PositionMath{}.CalculateMaintenanceMargin(tt.positionValue, DefaultMarginTiers), 1e-6)
+		})
+	}
+
+	assert.Zero(t, PositionMath{}.CalculateMaintenanceMargin(math.Inf(1), nil))
+}
@@ -4,7 +4,7 @@ import (
 	"frizo/futures_engine/internal/common"
 	_ "math"
 	"testing"
-	_ "time"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -348,6 +348,37 @@ func Test_Liquidation(t *testing.T) {
 	})
 }
 
+func TestLiquidationLease(t *testing.T) {
+	pos := createTestPosition("user1", "BTCUSDT")
+	require.NoError(t, pos.Open(LONG, 50000, 1.0, 100))
+	pos.UpdateMarkPrice(pos.LiquidationPrice - 10)
+
+	now := time.Now()
+	require.True(t, pos.ClaimLiquidationLease("worker-a", time.Second, now))
+	assert.False(t, pos.ClaimLiquidationLease("worker-b", time.Second, now.Add(500*time.Millisecond)), "lease still held")
+	assert.False(t, pos.RenewLiquidationLease("worker-b", time.Second, now))
+	require.True(t, pos.RenewLiquidationLease("worker-a", time.Second, now.Add(500*time.Millisecond)))
+
+	owner, expiresAt, claimed := pos.LiquidationLease()
+	assert.True(t, claimed)
+	assert.Equal(t, "worker-a", owner)
+	assert.Equal(t, now.Add(1500*time.Millisecond), expiresAt)
+
+	// expired: worker-b takes over, worker-a is fenced off
+	require.True(t, pos.ClaimLiquidationLease("worker-b", time.Second, now.Add(2*time.Second)))
+	_, _, err := pos.ReduceForLiquidationAs("worker-a", pos.MarkPrice, 0.5)
+	assert.Error(t, err)
+	_, _, err = pos.ReduceForLiquidationAs("worker-b", pos.MarkPrice, 0.5)
+	require.NoError(t, err)
+
+	// given back while still liquidatable: stays Liquidating for the next claimer
+	assert.False(t, pos.AbandonLiquidation("worker-a"))
+	require.True(t, pos.AbandonLiquidation("worker-b"))
+	assert.Equal(t, PositionLiquidating, pos.Status)
+	assert.True(t, pos.ClaimLiquidation())
+	assert.False(t, pos.ClaimLiquidation(), "no expiry without a lease")
+}
+
 // Test ROI Calculation
 func TestROI(t *testing.T) {
 	t.Run("PositiveROI", func(t *testing.T) {
@@ -479,3 +510,52 @@ func TestEdgeCases(t *testing.T) {
 		assert.Equal(t, 0.0, pos.MarkPrice)
 	})
 }
+
+// Test Margin Mode Switch
+func TestReclassifyMarginMode(t *testing.T) {
+	pos := createTestPosition("user1", "BTCUSDT")
+	require.NoError(t, pos.Open(LONG, 50000, 1.0, 10))
+
+	pos.UpdateMarkPrice(48000)
+	isolatedRatio := pos.GetMarginRatio() // (5000-2000)/48000
+	liquidationPrice := pos.LiquidationPrice
+
+	require.NoError(t, pos.ReclassifyMarginMode(common.CROSS, 20000))
+	assert.Equal(t, common.CROSS, pos.MarginMode)
+	assert.InDelta(t, 20000.0/48000*100, pos.GetMarginRatio(), 1e-9)
+	assert.Greater(t, pos.GetMarginRatio(), isolatedRatio)
+	assert.Equal(t, liquidationPrice, pos.LiquidationPrice, "liquidation price should not change")
+
+	// 切回逐倉
+	require.NoError(t, pos.ReclassifyMarginMode(common.ISOLATED, 0))
+	assert.InDelta(t, isolatedRatio, pos.GetMarginRatio(), 1e-9)
+
+	_, err := pos.Close(48000)
+	require.NoError(t, err)
+	assert.Error(t, pos.ReclassifyMarginMode(common.CROSS, 20000))
+}
+
+// Test Required Deposit
+func TestGetRequiredDepositToAvoidLiquidation(t *testing.T) {
+	pos := createTestPosition("user1", "BTCUSDT")
+	require.NoError(t, pos.Open(LONG, 50000, 1.0, 10)) // IM 5000, MM 200
+
+	assert.Equal(t, 0.0, pos.GetRequiredDepositToAvoidLiquidation(0), "healthy position")
+
+	// equity = 5000 - 4790 = 210, target = 200 * 1.2 = 240
+	pos.UpdateMarkPrice(45210)
+	require.Equal(t, PositionNormal, pos.Status)
+	needed := pos.GetRequiredDepositToAvoidLiquidation(0)
+	assert.InDelta(t, 30.0, needed, 1e-6)
+	assert.InDelta(t, 5000.0, pos.InitialMargin, 1e-9, "read only")
+
+	assert.InDelta(t, 200*1.5-210.0, pos.GetRequiredDepositToAvoidLiquidation(1.5), 1e-6)
+
+	liquidationPrice := pos.LiquidationPrice
+	require.NoError(t, pos.AddMargin(needed))
+	assert.Less(t, pos.LiquidationPrice, liquidationPrice)
+	assert.InDelta(t, 0.0, pos.GetRequiredDepositToAvoidLiquidation(0), 1e-6)
+	assert.False(t, pos.IsLiquidatable())
+
+	assert.Error(t, pos.AddMargin(0))
+}
@@ -0,0 +1,100 @@
+package position
+
+import "time"
+
+// PreLiquidationConfig (強平預警) warn once the margin ratio is at most Multiple × the maintenance ratio,
+// re-armed only after it recovered to (Multiple + Hysteresis) × the maintenance ratio
+type PreLiquidationConfig struct {
+	Multiple   float64 `json:"multiple"`
+	Hysteresis float64 `json:"hysteresis"`
+}
+
+var DefaultPreLiquidationConfig = PreLiquidationConfig{Multiple: 1.5, Hysteresis: 0.1}
+
+// PreLiquidationWarning position getting close to liquidation. TopUp is the margin that brings the margin ratio
+// back to the re-arm level
+type PreLiquidationWarning struct {
+	PositionID       string       `json:"position_id"`
+	UserID           string       `json:"user_id"`
+	Symbol           string       `json:"symbol"`
+	Side             PositionSide `json:"side"`
+	MarkPrice        float64      `json:"mark_price"`
+	LiquidationPrice float64      `json:"liquidation_price"`
+	MarginRatio      float64      `json:"margin_ratio"`      // %
+	MaintenanceRatio float64      `json:"maintenance_ratio"` // %
+	TopUp            float64      `json:"top_up"`
+	Timestamp        time.Time    `json:"timestamp"`
+}
+
+// EnablePreLiquidationWarnings publish EventPreLiquidationWarning once per crossing of the warning level,
+// checked on every position a mark price update reaches. with the liquidation index only RefreshMarkPrices
+// reaches every position. config nil means DefaultPreLiquidationConfig
+func (pm *PositionManager) EnablePreLiquidationWarnings(config *PreLiquidationConfig) {
+	if config == nil {
+		config = &DefaultPreLiquidationConfig
+	}
+	pm.symbolPositions.setWarner(&preLiquidationWarner{config: *config, publish: pm.publishMarginCall})
+}
+
+// DisablePreLiquidationWarnings stop checking, positions keep their warned state
+func (pm *PositionManager) DisablePreLiquidationWarnings() {
+	pm.symbolPositions.setWarner(nil)
+}
+
+type preLiquidationWarner struct {
+	config  PreLiquidationConfig
+	publish func(PositionEvent)
+}
+
+func (w *preLiquidationWarner) check(pos *Position) {
+	if warning, ok := pos.checkPreLiquidation(w.config); ok {
+		w.publish(PositionEvent{
+			Type:           EventPreLiquidationWarning,
+			UserID:         warning.UserID,
+			PositionID:     warning.PositionID,
+			Symbol:         warning.Symbol,
+			Timestamp:      warning.Timestamp,
+			PreLiquidation: &warning,
+		})
+	}
+}
+
+// checkPreLiquidation true once when the margin ratio falls to the warning level, the position is then
+// warned until the ratio recovers above the re-arm level
+func (p *Position) checkPreLiquidation(config PreLiquidationConfig) (PreLiquidationWarning, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Status != PositionNormal || p.Size <= p.ZeroSize() || p.PositionValue <= 0 {
+		return PreLiquidationWarning{}, false
+	}
+	marginRatio := p.getMarginRatio()
+	maintenanceRatio := p.MaintenanceMargin / p.PositionValue * 100
+	rearmRatio := maintenanceRatio * (config.Multiple + config.Hysteresis)
+
+	if p.preLiquidationWarned {
+		if marginRatio >= rearmRatio {
+			p.preLiquidationWarned = false
+		}
+		return PreLiquidationWarning{}, false
+	}
+	if marginRatio > maintenanceRatio*config.Multiple {
+		return PreLiquidationWarning{}, false
+	}
+	p.preLiquidationWarned = true
+
+	// equity behind the ratio, isolated: margin + unrealized PnL, cross: the account's share
+	equity := marginRatio / 100 * p.PositionValue
+	return PreLiquidationWarning{
+		PositionID:       p.ID,
+		UserID:           p.UserID,
+		Symbol:           p.Symbol,
+		Side:             p.Side,
+		MarkPrice:        p.MarkPrice,
+		LiquidationPrice: p.LiquidationPrice,
+		MarginRatio:      marginRatio,
+		MaintenanceRatio: maintenanceRatio,
+		TopUp:            max(rearmRatio/100*p.PositionValue-equity, 0),
+		Timestamp:        time.Now(),
+	}, true
+}
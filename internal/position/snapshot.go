@@ -0,0 +1,141 @@
+package position
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"maps"
+	"sort"
+	"time"
+)
+
+// PositionSnapshot (倉位快照) all fields of a Position captured at one instant
+type PositionSnapshot struct {
+	ID     string         `json:"id"`
+	UserID string         `json:"user_id"`
+	Symbol string         `json:"symbol"`
+	Side   PositionSide   `json:"side"`
+	Status PositionStatus `json:"status"`
+
+	Size             float64 `json:"size"`
+	EntryPrice       float64 `json:"entry_price"`
+	MarkPrice        float64 `json:"mark_price"`
+	PositionValue    float64 `json:"position_value"`
+	LiquidationPrice float64 `json:"liquidation_price"`
+
+	InitialMargin     float64           `json:"initial_margin"`
+	MaintenanceMargin float64           `json:"maintenance_margin"`
+	Leverage          int16             `json:"leverage"`
+	MarginMode        common.MarginMode `json:"margin_mode"`
+
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	TradingFees   float64 `json:"trading_fees"`
+
+	OpenTime   time.Time `json:"open_time"`
+	UpdateTime time.Time `json:"update_time"`
+
+	ExpiryTime      time.Time `json:"expiry_time"`
+	SettlementPrice float64   `json:"settlement_price"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	Simulated bool `json:"simulated"`
+
+	SnapshotTimestamp time.Time `json:"snapshot_timestamp"`
+}
+
+// Age time elapsed since the snapshot was taken
+func (s PositionSnapshot) Age() time.Duration {
+	return time.Since(s.SnapshotTimestamp)
+}
+
+// Snapshot copy position fields under read lock
+func (p *Position) Snapshot() PositionSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return PositionSnapshot{
+		ID:                p.ID,
+		UserID:            p.UserID,
+		Symbol:            p.Symbol,
+		Side:              p.Side,
+		Status:            p.Status,
+		Size:              p.Size,
+		EntryPrice:        p.EntryPrice,
+		MarkPrice:         p.MarkPrice,
+		PositionValue:     p.PositionValue,
+		LiquidationPrice:  p.LiquidationPrice,
+		InitialMargin:     p.InitialMargin,
+		MaintenanceMargin: p.MaintenanceMargin,
+		Leverage:          p.Leverage,
+		MarginMode:        p.MarginMode,
+		RealizedPnL:       p.RealizedPnL,
+		UnrealizedPnL:     p.UnrealizedPnL,
+		TradingFees:       p.TradingFees,
+		OpenTime:          p.OpenTime,
+		UpdateTime:        p.UpdateTime,
+		ExpiryTime:        p.ExpiryTime,
+		SettlementPrice:   p.SettlementPrice,
+		Tags:              maps.Clone(p.Tags),
+		Simulated:         p.Simulated,
+		SnapshotTimestamp: time.Now(),
+	}
+}
+
+// ========================================================
+
+// ManagerState (倉位管理器狀態) what a restart needs to rebuild the manager: open positions, the users'
+// position modes and the last mark price of every symbol
+type ManagerState struct {
+	Positions  []PositionSnapshot      `json:"positions"` // sorted by position id
+	Modes      map[string]PositionMode `json:"modes"`
+	MarkPrices map[string]float64      `json:"mark_prices"`
+}
+
+// ExportState open positions and position modes under the manager lock, closed positions are left out
+func (pm *PositionManager) ExportState() ManagerState {
+	pm.mu.RLock()
+	state := ManagerState{
+		Positions:  make([]PositionSnapshot, 0, len(pm.positionsByID)),
+		Modes:      maps.Clone(pm.mode),
+		MarkPrices: pm.symbolPositions.GetMarkPrices(),
+	}
+	for _, position := range pm.positionsByID {
+		if snapshot := position.Snapshot(); snapshot.Status != PositionClosed && snapshot.Size > 0 {
+			state.Positions = append(state.Positions, snapshot)
+		}
+	}
+	pm.mu.RUnlock()
+
+	sort.Slice(state.Positions, func(i, j int) bool { return state.Positions[i].ID < state.Positions[j].ID })
+	return state
+}
+
+// RestoreState set the position modes, recover every position, then apply the mark prices.
+// the manager must not hold positions yet
+func (pm *PositionManager) RestoreState(state ManagerState) error {
+	pm.mu.RLock()
+	existing := len(pm.positionsByID)
+	pm.mu.RUnlock()
+	if existing > 0 {
+		return fmt.Errorf("position manager already has %d positions", existing)
+	}
+
+	for userID, mode := range state.Modes {
+		if err := pm.SetPositionMode(userID, mode); err != nil {
+			return fmt.Errorf("position mode of %s: %w", userID, err)
+		}
+	}
+	for _, snapshot := range state.Positions {
+		if err := pm.RecoverPosition(context.Background(), snapshot); err != nil {
+			return fmt.Errorf("recover position %s: %w", snapshot.ID, err)
+		}
+	}
+	for symbol, price := range state.MarkPrices {
+		if _, err := pm.UpdateMarkPrices(symbol, price); err != nil {
+			return fmt.Errorf("mark price of %s: %w", symbol, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package position
+
+import (
+	"frizo/futures_engine/internal/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPositionSnapshot(t *testing.T) {
+	pm := NewPositionManager(symbols)
+
+	pos, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	require.NoError(t, err)
+
+	snap, err := pm.GetPositionSnapshot(pos.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, pos.ID, snap.ID)
+	assert.Equal(t, "user1", snap.UserID)
+	assert.Equal(t, LONG, snap.Side)
+	assert.Equal(t, 1.0, snap.Size)
+	assert.Equal(t, 50000.0, snap.MarkPrice)
+	assert.Equal(t, 5000.0, snap.InitialMargin)
+	assert.Equal(t, pos.LiquidationPrice, snap.LiquidationPrice)
+
+	// 更新真實倉位，快照不應該被影響
+	pos.UpdateMarkPrice(48000)
+	require.NoError(t, pos.Add(48000, 1))
+
+	assert.Equal(t, 50000.0, snap.MarkPrice)
+	assert.Equal(t, 0.0, snap.UnrealizedPnL)
+	assert.Equal(t, 1.0, snap.Size)
+	assert.Equal(t, 50000.0, snap.EntryPrice)
+
+	// 重新取得的快照反映最新狀態
+	latest, err := pm.GetPositionSnapshot(pos.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, latest.Size)
+	assert.Equal(t, 48000.0, latest.MarkPrice)
+
+	time.Sleep(2 * time.Millisecond)
+	assert.GreaterOrEqual(t, snap.Age(), 2*time.Millisecond)
+}
+
+func TestGetPositionSnapshotNotFound(t *testing.T) {
+	pm := NewPositionManager(symbols)
+
+	_, err := pm.GetPositionSnapshot("pos_not_exist")
+	assert.Error(t, err)
+}
+
+func TestExportRestoreState(t *testing.T) {
+	pm := NewPositionManager(symbols)
+	require.NoError(t, pm.SetPositionMode("hedger", HedgeMode))
+	_, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", LONG, 50000, 1, 10)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "hedger", "ETHUSDT", LONG, 3000, 2, 5)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.CROSS, "hedger", "ETHUSDT", SHORT, 3000, 1, 5)
+	require.NoError(t, err)
+	_, err = pm.OpenPosition(common.ISOLATED, "closer", "BTCUSDT", SHORT, 50000, 1, 10)
+	require.NoError(t, err)
+	_, _, err = pm.ClosePosition("closer", "BTCUSDT", SHORT, 50000)
+	require.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 48000)
+	require.NoError(t, err)
+
+	state := pm.ExportState()
+	require.Len(t, state.Positions, 3, "closed position left out")
+	assert.Equal(t, HedgeMode, state.Modes["hedger"])
+	assert.Equal(t, 48000.0, state.MarkPrices["BTCUSDT"])
+
+	restored := NewPositionManager(symbols)
+	require.NoError(t, restored.RestoreState(state))
+	got := restored.ExportState()
+	require.Len(t, got.Positions, len(state.Positions))
+	for i := range state.Positions {
+		want := state.Positions[i]
+		want.SnapshotTimestamp = got.Positions[i].SnapshotTimestamp
+		assert.Equal(t, want, got.Positions[i])
+	}
+	assert.Equal(t, state.Modes, got.Modes)
+	assert.Equal(t, state.MarkPrices, got.MarkPrices)
+
+	long, short, err := restored.GetOpenInterestBySide("ETHUSDT")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, long)
+	assert.Equal(t, 1.0, short)
+
+	assert.Error(t, restored.RestoreState(state), "manager already holds positions")
+}
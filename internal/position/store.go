@@ -0,0 +1,54 @@
+package position
+
+import (
+	"context"
+	"maps"
+)
+
+// PositionStore (倉位持久化) persistence of position snapshots
+type PositionStore interface {
+	SavePosition(ctx context.Context, snapshot PositionSnapshot) error
+	// ListSnapshots every saved snapshot of the user's positions, the position history
+	ListSnapshots(userID string) ([]PositionSnapshot, error)
+}
+
+// RecoveryHook account side of a recovered position, registered by the margin system.
+// called by RecoverPosition once the position is visible, outside the manager lock
+type RecoveryHook func(ctx context.Context, snapshot PositionSnapshot) error
+
+// SetRecoveryHook register the hook run by RecoverPosition,
+// lets the margin system plug in without a circular import
+func (pm *PositionManager) SetRecoveryHook(fn RecoveryHook) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.recoveryHook = fn
+}
+
+// positionFromSnapshot rebuild a position by direct field assignment, no Open validation
+func positionFromSnapshot(snapshot PositionSnapshot, precision *PrecisionSetting) *Position {
+	position := NewPosition(snapshot.UserID, snapshot.Symbol, snapshot.MarginMode, precision)
+
+	position.ID = snapshot.ID
+	position.Side = snapshot.Side
+	position.Status = snapshot.Status
+	position.Simulated = snapshot.Simulated
+	position.Size = snapshot.Size
+	position.EntryPrice = snapshot.EntryPrice
+	position.MarkPrice = snapshot.MarkPrice
+	position.PositionValue = snapshot.PositionValue
+	position.LiquidationPrice = snapshot.LiquidationPrice
+	position.InitialMargin = snapshot.InitialMargin
+	position.MaintenanceMargin = snapshot.MaintenanceMargin
+	position.Leverage = snapshot.Leverage
+	position.RealizedPnL = snapshot.RealizedPnL
+	position.UnrealizedPnL = snapshot.UnrealizedPnL
+	position.TradingFees = snapshot.TradingFees
+	position.OpenTime = snapshot.OpenTime
+	position.UpdateTime = snapshot.UpdateTime
+	position.touchedAt = snapshot.UpdateTime
+	position.ExpiryTime = snapshot.ExpiryTime
+	position.SettlementPrice = snapshot.SettlementPrice
+	position.Tags = maps.Clone(snapshot.Tags)
+
+	return position
+}
@@ -0,0 +1,90 @@
+package position
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TagStrategy tag key of the strategy owning a position
+const TagStrategy = "strategy"
+
+// SetTag (倉位標籤) label the position, an empty value removes the tag
+func (p *Position) SetTag(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("tag key must not be empty")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if value == "" {
+		delete(p.Tags, key)
+		return nil
+	}
+	if p.Tags == nil {
+		p.Tags = make(map[string]string)
+	}
+	p.Tags[key] = value
+	return nil
+}
+
+// GetPositionsByStrategyTag open positions whose TagStrategy is tag
+func (pm *PositionManager) GetPositionsByStrategyTag(tag string) []*PositionInfo {
+	return pm.GetPositionsByTag(TagStrategy, tag)
+}
+
+// GetPositionsByTag open positions tagged key=value, oldest first
+func (pm *PositionManager) GetPositionsByTag(key, value string) []*PositionInfo {
+	var tagged []*PositionInfo
+	for _, snapshot := range pm.openSnapshots() {
+		if tag, ok := snapshot.Tags[key]; ok && tag == value {
+			tagged = append(tagged, &snapshot)
+		}
+	}
+	sort.Slice(tagged, func(i, j int) bool {
+		if !tagged[i].OpenTime.Equal(tagged[j].OpenTime) {
+			return tagged[i].OpenTime.Before(tagged[j].OpenTime)
+		}
+		return tagged[i].ID < tagged[j].ID
+	})
+	return tagged
+}
+
+// GetAllTags distinct values of every tag key across open positions, values sorted
+func (pm *PositionManager) GetAllTags() map[string][]string {
+	seen := make(map[string]map[string]struct{})
+	for _, snapshot := range pm.openSnapshots() {
+		for key, value := range snapshot.Tags {
+			if seen[key] == nil {
+				seen[key] = make(map[string]struct{})
+			}
+			seen[key][value] = struct{}{}
+		}
+	}
+
+	tags := make(map[string][]string, len(seen))
+	for key, values := range seen {
+		for value := range values {
+			tags[key] = append(tags[key], value)
+		}
+		sort.Strings(tags[key])
+	}
+	return tags
+}
+
+// openSnapshots snapshot of every open position
+func (pm *PositionManager) openSnapshots() []PositionSnapshot {
+	pm.mu.RLock()
+	positions := make([]*Position, 0, len(pm.positionsByID))
+	for _, position := range pm.positionsByID {
+		positions = append(positions, position)
+	}
+	pm.mu.RUnlock()
+
+	snapshots := make([]PositionSnapshot, 0, len(positions))
+	for _, position := range positions {
+		if snapshot := position.Snapshot(); snapshot.Status != PositionClosed && snapshot.Size > 0 {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots
+}
@@ -79,4 +79,6 @@ type MarginTier struct {
 type PrecisionSetting struct {
 	PricePrecision int8
 	SizePrecision  int8
+	// MarginTiers maintenance margin and max leverage tiers of the symbol, nil means DefaultMarginTiers
+	MarginTiers *MarginTierCalculator
 }
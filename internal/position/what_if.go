@@ -0,0 +1,53 @@
+package position
+
+import "frizo/futures_engine/internal/common"
+
+// PositionSimulation (假設試算) state a position would have at a hypothetical mark price
+type PositionSimulation struct {
+	PositionID string            `json:"position_id"`
+	Symbol     string            `json:"symbol"`
+	Side       PositionSide      `json:"side"`
+	MarginMode common.MarginMode `json:"margin_mode"`
+	Size       float64           `json:"size"`
+
+	MarkPrice         float64 `json:"mark_price"`
+	PositionValue     float64 `json:"position_value"`
+	UnrealizedPnL     float64 `json:"unrealized_pnl"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+	LiquidationPrice  float64 `json:"liquidation_price"`
+	MarginRatio       float64 `json:"margin_ratio"` // %
+	IsLiquidatable    bool    `json:"is_liquidatable"`
+}
+
+// SimulateMarkPrice what a mark price update to markPrice would make of the snapshot, same formulas as
+// Position.UpdateMarkPrice. crossEquity is the account equity at that price, used by cross positions only.
+// pure function of the snapshot, the position itself is not touched
+func (s PositionSnapshot) SimulateMarkPrice(markPrice, crossEquity float64) PositionSimulation {
+	sim := PositionSimulation{
+		PositionID:        s.ID,
+		Symbol:            s.Symbol,
+		Side:              s.Side,
+		MarginMode:        s.MarginMode,
+		Size:              s.Size,
+		MarkPrice:         markPrice,
+		MaintenanceMargin: s.MaintenanceMargin,
+		LiquidationPrice:  s.LiquidationPrice,
+		MarginRatio:       100, // safe
+	}
+	if s.Status == PositionClosed || s.Size <= 0 || markPrice <= 0 {
+		return sim
+	}
+
+	sim.UnrealizedPnL = PositionMath{}.CalculateUnrealizedPnL(s.Side, s.EntryPrice, markPrice, s.Size)
+	sim.PositionValue = markPrice * s.Size
+	sim.LiquidationPrice = PositionMath{}.CalculateLiquidationPrice(s.Side, s.EntryPrice, s.InitialMargin, s.MaintenanceMargin, s.Size)
+
+	if s.MarginMode == common.CROSS {
+		// cross equity already includes the unrealized PnL
+		sim.MarginRatio = PositionMath{}.CalculateMarginRatio(crossEquity, 0, sim.PositionValue)
+	} else {
+		sim.MarginRatio = PositionMath{}.CalculateMarginRatio(s.InitialMargin, sim.UnrealizedPnL, sim.PositionValue)
+	}
+	sim.IsLiquidatable = sim.MarginRatio <= s.MaintenanceMargin/sim.PositionValue*100
+	return sim
+}
@@ -0,0 +1,192 @@
+package risk
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"math"
+)
+
+// checker names
+const (
+	CheckAccountFrozen = "account_frozen"
+	CheckReduceOnly    = "reduce_only"
+	CheckMinNotional   = "min_notional"
+	CheckPriceBand     = "price_band"
+	CheckLeverage      = "leverage"
+	CheckOpenOrders    = "open_orders"
+	CheckMargin        = "margin"
+)
+
+// AccountStatusProvider tells whether an account is frozen (凍結帳戶)
+type AccountStatusProvider interface {
+	IsAccountFrozen(userID string) bool
+}
+
+// OpenOrderCounter number of resting orders of a user
+type OpenOrderCounter interface {
+	OpenOrderCount(userID string) int
+}
+
+// ReferencePriceFunc reference price for the price band, false if unknown
+type ReferencePriceFunc func(symbol string) (float64, bool)
+
+// ========================================================
+
+// AccountFrozenChecker reject orders from frozen accounts
+type AccountFrozenChecker struct {
+	Accounts AccountStatusProvider
+}
+
+func (c *AccountFrozenChecker) Name() string { return CheckAccountFrozen }
+
+func (c *AccountFrozenChecker) Check(req *OrderRequest) error {
+	if c.Accounts.IsAccountFrozen(req.UserID) {
+		return fmt.Errorf("account %s is frozen", req.UserID)
+	}
+	return nil
+}
+
+// ========================================================
+
+// ReduceOnlyChecker reduce-only order must not exceed the position it reduces
+type ReduceOnlyChecker struct {
+	PositionMgr *position.PositionManager
+}
+
+func (c *ReduceOnlyChecker) Name() string { return CheckReduceOnly }
+
+func (c *ReduceOnlyChecker) Check(req *OrderRequest) error {
+	if !req.ReduceOnly {
+		return nil
+	}
+
+	pos, err := c.PositionMgr.GetPosition(req.UserID, req.Symbol, req.Side)
+	if err != nil || pos.Status != position.PositionNormal || pos.Size <= pos.ZeroSize() {
+		return fmt.Errorf("no open %s position to reduce on %s", req.Side, req.Symbol)
+	}
+	if pos.Side != req.Side {
+		return fmt.Errorf("reduce-only %s order does not match %s position", req.Side, pos.Side)
+	}
+	if req.Size > pos.Size {
+		return fmt.Errorf("reduce-only size %f exceeds position size %f", req.Size, pos.Size)
+	}
+	return nil
+}
+
+// ========================================================
+
+// MinNotionalChecker (最小下單金額)
+type MinNotionalChecker struct {
+	MinNotional float64
+}
+
+func (c *MinNotionalChecker) Name() string { return CheckMinNotional }
+
+func (c *MinNotionalChecker) Check(req *OrderRequest) error {
+	if req.Price <= 0 || req.Size <= 0 {
+		return fmt.Errorf("price and size must be greater than zero")
+	}
+	if req.ReduceOnly {
+		// allow closing dust positions
+		return nil
+	}
+	if req.Notional() < c.MinNotional {
+		return fmt.Errorf("notional %.2f below minimum %.2f", req.Notional(), c.MinNotional)
+	}
+	return nil
+}
+
+// ========================================================
+
+// PriceBandChecker order price must stay within Band (e.g. 0.05 = 5%) of the reference price
+type PriceBandChecker struct {
+	Band           float64
+	ReferencePrice ReferencePriceFunc
+}
+
+func (c *PriceBandChecker) Name() string { return CheckPriceBand }
+
+func (c *PriceBandChecker) Check(req *OrderRequest) error {
+	refPrice, ok := c.ReferencePrice(req.Symbol)
+	if !ok || refPrice <= 0 {
+		// no reference yet, nothing to compare with
+		return nil
+	}
+
+	deviation := math.Abs(req.Price-refPrice) / refPrice
+	if deviation > c.Band {
+		return fmt.Errorf("price %.2f deviates %.2f%% from reference %.2f (band %.2f%%)",
+			req.Price, deviation*100, refPrice, c.Band*100)
+	}
+	return nil
+}
+
+// ========================================================
+
+// LeverageChecker leverage must fit the margin tier of the order notional
+type LeverageChecker struct {
+	Tiers []position.MarginTier // nil means position.DefaultMarginTiers
+}
+
+func (c *LeverageChecker) Name() string { return CheckLeverage }
+
+func (c *LeverageChecker) Check(req *OrderRequest) error {
+	if req.ReduceOnly {
+		return nil
+	}
+	if req.Leverage <= 0 {
+		return fmt.Errorf("leverage must be greater than zero")
+	}
+
+	tiers := c.Tiers
+	if tiers == nil {
+		tiers = position.DefaultMarginTiers
+	}
+
+	notional := req.Notional()
+	for _, tier := range tiers {
+		if notional >= tier.MinValue && notional <= tier.MaxValue {
+			if uint(req.Leverage) > tier.MaxLeverage {
+				return fmt.Errorf("leverage %dx exceeds max %dx for notional %.2f",
+					req.Leverage, tier.MaxLeverage, notional)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// ========================================================
+
+// OpenOrderLimitChecker (掛單數量上限)
+type OpenOrderLimitChecker struct {
+	MaxOpenOrders int
+	Orders        OpenOrderCounter
+}
+
+func (c *OpenOrderLimitChecker) Name() string { return CheckOpenOrders }
+
+func (c *OpenOrderLimitChecker) Check(req *OrderRequest) error {
+	if count := c.Orders.OpenOrderCount(req.UserID); count >= c.MaxOpenOrders {
+		return fmt.Errorf("open orders %d reached limit %d", count, c.MaxOpenOrders)
+	}
+	return nil
+}
+
+// ========================================================
+
+// MarginChecker available balance must cover the initial margin
+type MarginChecker struct {
+	MarginSystem *margin.MarginSystem
+}
+
+func (c *MarginChecker) Name() string { return CheckMargin }
+
+func (c *MarginChecker) Check(req *OrderRequest) error {
+	if req.ReduceOnly {
+		// reduce-only releases margin
+		return nil
+	}
+	return c.MarginSystem.CheckOrderMargin(req.UserID, req.Symbol, req.Size, req.Price, req.Leverage)
+}
@@ -0,0 +1,161 @@
+package risk
+
+import (
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var symbols = []string{"BTCUSDT", "ETHUSDT"}
+
+type frozenAccounts map[string]bool
+
+func (f frozenAccounts) IsAccountFrozen(userID string) bool { return f[userID] }
+
+type openOrders map[string]int
+
+func (o openOrders) OpenOrderCount(userID string) int { return o[userID] }
+
+func newOrder(price, size float64, leverage int16) *OrderRequest {
+	return &OrderRequest{
+		UserID:   "user1",
+		Symbol:   "BTCUSDT",
+		Side:     position.LONG,
+		Price:    price,
+		Size:     size,
+		Leverage: leverage,
+	}
+}
+
+func TestAccountFrozenChecker(t *testing.T) {
+	checker := &AccountFrozenChecker{Accounts: frozenAccounts{"frozen": true}}
+
+	assert.NoError(t, checker.Check(newOrder(50000, 1, 10)))
+
+	req := newOrder(50000, 1, 10)
+	req.UserID = "frozen"
+	assert.Error(t, checker.Check(req))
+}
+
+func TestReduceOnlyChecker(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	checker := &ReduceOnlyChecker{PositionMgr: pm}
+
+	req := newOrder(50000, 1, 10)
+	req.ReduceOnly = true
+	assert.Error(t, checker.Check(req), "no position to reduce")
+
+	_, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 2, 10)
+	require.NoError(t, err)
+
+	assert.NoError(t, checker.Check(req))
+
+	req.Size = 3
+	assert.Error(t, checker.Check(req), "reduce size exceeds position")
+
+	req.Size = 1
+	req.Side = position.SHORT
+	assert.Error(t, checker.Check(req), "wrong side")
+
+	// 非 reduce-only 單不檢查
+	assert.NoError(t, checker.Check(newOrder(50000, 100, 10)))
+}
+
+func TestMinNotionalChecker(t *testing.T) {
+	checker := &MinNotionalChecker{MinNotional: 5}
+
+	assert.NoError(t, checker.Check(newOrder(50000, 0.001, 10)))
+	assert.Error(t, checker.Check(newOrder(50000, 0.00001, 10)))
+	assert.Error(t, checker.Check(newOrder(0, 1, 10)))
+
+	dust := newOrder(50000, 0.00001, 10)
+	dust.ReduceOnly = true
+	assert.NoError(t, checker.Check(dust))
+}
+
+func TestPriceBandChecker(t *testing.T) {
+	checker := &PriceBandChecker{
+		Band: 0.05,
+		ReferencePrice: func(symbol string) (float64, bool) {
+			if symbol == "BTCUSDT" {
+				return 50000, true
+			}
+			return 0, false
+		},
+	}
+
+	assert.NoError(t, checker.Check(newOrder(52500, 1, 10)))
+	assert.NoError(t, checker.Check(newOrder(47500, 1, 10)))
+	assert.Error(t, checker.Check(newOrder(52600, 1, 10)))
+	assert.Error(t, checker.Check(newOrder(47000, 1, 10)))
+
+	// 沒有參考價格時不限制
+	req := newOrder(1, 1, 10)
+	req.Symbol = "ETHUSDT"
+	assert.NoError(t, checker.Check(req))
+}
+
+func TestLeverageChecker(t *testing.T) {
+	checker := &LeverageChecker{}
+
+	assert.NoError(t, checker.Check(newOrder(40000, 1, 125))) // < 50k: max 125x
+	assert.Error(t, checker.Check(newOrder(50000, 2, 125)))   // 100k: max 100x
+	assert.NoError(t, checker.Check(newOrder(50000, 2, 100))) // 100k: max 100x
+	assert.Error(t, checker.Check(newOrder(50000, 10, 75)))   // 500k: max 50x
+	assert.Error(t, checker.Check(newOrder(50000, 1, 0)))     // invalid leverage
+}
+
+func TestOpenOrderLimitChecker(t *testing.T) {
+	orders := openOrders{"user1": 199}
+	checker := &OpenOrderLimitChecker{MaxOpenOrders: 200, Orders: orders}
+
+	assert.NoError(t, checker.Check(newOrder(50000, 1, 10)))
+
+	orders["user1"] = 200
+	assert.Error(t, checker.Check(newOrder(50000, 1, 10)))
+}
+
+func TestMarginChecker(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := margin.NewMarginSystem(pm, nil)
+	checker := &MarginChecker{MarginSystem: ms}
+
+	assert.Error(t, checker.Check(newOrder(50000, 1, 10)), "account not found")
+
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 5000))
+
+	// initial margin = max(50000/10, 50000*10%) = 5000
+	assert.NoError(t, checker.Check(newOrder(50000, 1, 10)))
+	assert.Error(t, checker.Check(newOrder(50000, 1.1, 10)))
+}
+
+func TestRiskPipelineWithCheckers(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	ms := margin.NewMarginSystem(pm, nil)
+	_, err := ms.CreateAccount("user1")
+	require.NoError(t, err)
+	require.NoError(t, ms.Deposit("user1", 1000))
+
+	pipeline := NewRiskPipeline(
+		&AccountFrozenChecker{Accounts: frozenAccounts{}},
+		&ReduceOnlyChecker{PositionMgr: pm},
+		&MinNotionalChecker{MinNotional: 5},
+		&LeverageChecker{},
+		&MarginChecker{MarginSystem: ms},
+	)
+
+	err = pipeline.Check(newOrder(50000, 1, 10))
+	var rejection *Rejection
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, CheckMargin, rejection.Check)
+
+	require.NoError(t, pipeline.Disable(CheckMargin))
+	assert.NoError(t, pipeline.Check(newOrder(50000, 1, 10)))
+}
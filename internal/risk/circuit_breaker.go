@@ -0,0 +1,332 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"math"
+	"sync"
+	"time"
+)
+
+const CheckCircuitBreaker = "circuit_breaker"
+
+var ErrTradingHalted = errors.New("trading halted")
+
+// metrics of the circuit breaker
+const (
+	MetricBreakerHalts  = "circuit_breaker_halts_total" // by symbol
+	MetricHaltedSymbols = "halted_symbols"
+)
+
+// BreakerState ACTIVE or HALTED
+type BreakerState int
+
+const (
+	BreakerActive BreakerState = iota
+	BreakerHalted
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerActive:
+		return "ACTIVE"
+	case BreakerHalted:
+		return "HALTED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// CircuitBreakerConfig
+type CircuitBreakerConfig struct {
+	MaxMove float64       // e.g. 0.10: halt when the mark price moves more than 10% within Window
+	Window  time.Duration // rolling window of mark prices
+	CoolOff time.Duration // automatic resume after this long, 0 means operators resume manually
+
+	// halted books switch to auction and uncross at one price on resumption
+	AuctionOnResume bool
+}
+
+var DefaultCircuitBreakerConfig = &CircuitBreakerConfig{
+	MaxMove:         0.10,
+	Window:          time.Minute,
+	CoolOff:         5 * time.Minute,
+	AuctionOnResume: true,
+}
+
+// BreakerEvent state transition of one symbol
+type BreakerEvent struct {
+	Symbol    string            `json:"symbol"`
+	From      BreakerState      `json:"from"`
+	To        BreakerState      `json:"to"`
+	Price     float64           `json:"price,omitempty"`
+	Move      float64           `json:"move,omitempty"` // largest move within the window
+	Manual    bool              `json:"manual"`         // operator override
+	Reason    string            `json:"reason"`
+	Trades    []orderbook.Trade `json:"trades,omitempty"` // auction uncross on resumption
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// BreakerEventHandler called on every transition, outside the breaker lock
+type BreakerEventHandler func(event BreakerEvent)
+
+type markPricePoint struct {
+	price float64
+	at    time.Time
+}
+
+// breakerSymbol state of one symbol
+type breakerSymbol struct {
+	state    BreakerState
+	prices   []markPricePoint // within the window, oldest first
+	haltedAt time.Time
+	manual   bool // halted by an operator, never resumed automatically
+}
+
+// CircuitBreaker (熔斷) halts continuous matching of a symbol whose mark price moves too far too fast.
+// new orders are rejected while halted (reduce-only still allowed), cancels never pass through the pipeline.
+type CircuitBreaker struct {
+	clock   common.Clock
+	config  *CircuitBreakerConfig
+	symbols map[string]*breakerSymbol
+	books   map[string]*orderbook.OrderBook
+	onEvent BreakerEventHandler
+	metrics metrics.Registry
+	mu      sync.Mutex
+}
+
+// NewCircuitBreaker clock nil means system clock, config nil means DefaultCircuitBreakerConfig
+func NewCircuitBreaker(clock common.Clock, config *CircuitBreakerConfig) *CircuitBreaker {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	if config == nil {
+		config = DefaultCircuitBreakerConfig
+	}
+	return &CircuitBreaker{
+		clock:   clock,
+		config:  config,
+		symbols: make(map[string]*breakerSymbol),
+		books:   make(map[string]*orderbook.OrderBook),
+		metrics: metrics.Nop{},
+	}
+}
+
+// SetOrderBook book switched to auction on halt when AuctionOnResume is set
+func (cb *CircuitBreaker) SetOrderBook(symbol string, book *orderbook.OrderBook) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.books[symbol] = book
+}
+
+// SetConfig replace the config from the next mark price on, nil means DefaultCircuitBreakerConfig. a
+// running halt keeps its start and resumes after the new CoolOff
+func (cb *CircuitBreaker) SetConfig(config *CircuitBreakerConfig) {
+	if config == nil {
+		config = DefaultCircuitBreakerConfig
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.config = config
+}
+
+// SetMetrics registry of the breaker's metrics, nil means metrics.Nop
+func (cb *CircuitBreaker) SetMetrics(registry metrics.Registry) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.metrics = metrics.OrNop(registry)
+}
+
+func (cb *CircuitBreaker) OnEvent(handler BreakerEventHandler) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.onEvent = handler
+}
+
+func (cb *CircuitBreaker) State(symbol string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if entry, exists := cb.symbols[symbol]; exists {
+		return entry.state
+	}
+	return BreakerActive
+}
+
+// OnMarkPrice feed from the mark price service. resumes after the cool-off, halts on a move beyond MaxMove
+func (cb *CircuitBreaker) OnMarkPrice(symbol string, price float64) error {
+	if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		return fmt.Errorf("invalid mark price %v", price)
+	}
+
+	cb.mu.Lock()
+	now := cb.clock.Now()
+	entry := cb.entry(symbol)
+
+	var events []BreakerEvent
+	if event, resumed := cb.resumeExpired(symbol, entry, now); resumed {
+		events = append(events, event)
+	}
+
+	// rolling window
+	entry.prices = append(entry.prices, markPricePoint{price: price, at: now})
+	cutoff := now.Add(-cb.config.Window)
+	drop := 0
+	for drop < len(entry.prices)-1 && entry.prices[drop].at.Before(cutoff) {
+		drop++
+	}
+	entry.prices = entry.prices[drop:]
+
+	if entry.state == BreakerActive {
+		if move := entry.move(price); move > cb.config.MaxMove {
+			reason := fmt.Sprintf("mark price moved %.2f%% within %s", move*100, cb.config.Window)
+			event := cb.halt(symbol, entry, now, false, reason)
+			event.Price, event.Move = price, move
+			events = append(events, event)
+		}
+	}
+	handler := cb.onEvent
+	cb.mu.Unlock()
+
+	cb.emit(handler, events)
+	return nil
+}
+
+// Poll resume every symbol whose cool-off expired, for periods without mark price updates
+func (cb *CircuitBreaker) Poll() {
+	cb.mu.Lock()
+	now := cb.clock.Now()
+	var events []BreakerEvent
+	for symbol, entry := range cb.symbols {
+		if event, resumed := cb.resumeExpired(symbol, entry, now); resumed {
+			events = append(events, event)
+		}
+	}
+	handler := cb.onEvent
+	cb.mu.Unlock()
+
+	cb.emit(handler, events)
+}
+
+// ForceHalt operator halt, stays halted until ForceResume
+func (cb *CircuitBreaker) ForceHalt(symbol, reason string) {
+	cb.mu.Lock()
+	entry := cb.entry(symbol)
+	var events []BreakerEvent
+	if entry.state == BreakerHalted {
+		entry.manual = true
+	} else {
+		events = append(events, cb.halt(symbol, entry, cb.clock.Now(), true, reason))
+	}
+	handler := cb.onEvent
+	cb.mu.Unlock()
+
+	cb.emit(handler, events)
+}
+
+// ForceResume operator resume, also ends an automatic halt before its cool-off
+func (cb *CircuitBreaker) ForceResume(symbol, reason string) {
+	cb.mu.Lock()
+	var events []BreakerEvent
+	if entry, exists := cb.symbols[symbol]; exists && entry.state == BreakerHalted {
+		events = append(events, cb.resume(symbol, entry, cb.clock.Now(), true, reason))
+	}
+	handler := cb.onEvent
+	cb.mu.Unlock()
+
+	cb.emit(handler, events)
+}
+
+// Name / Check risk pipeline checker
+func (cb *CircuitBreaker) Name() string { return CheckCircuitBreaker }
+
+func (cb *CircuitBreaker) Check(req *OrderRequest) error {
+	if req.ReduceOnly {
+		return nil
+	}
+	if cb.State(req.Symbol) == BreakerHalted {
+		return fmt.Errorf("%w: %s", ErrTradingHalted, req.Symbol)
+	}
+	return nil
+}
+
+// entry no lock
+func (cb *CircuitBreaker) entry(symbol string) *breakerSymbol {
+	entry, exists := cb.symbols[symbol]
+	if !exists {
+		entry = &breakerSymbol{}
+		cb.symbols[symbol] = entry
+	}
+	return entry
+}
+
+// resumeExpired no lock
+func (cb *CircuitBreaker) resumeExpired(symbol string, entry *breakerSymbol, now time.Time) (BreakerEvent, bool) {
+	if entry.state != BreakerHalted || entry.manual || cb.config.CoolOff <= 0 || now.Sub(entry.haltedAt) < cb.config.CoolOff {
+		return BreakerEvent{}, false
+	}
+	return cb.resume(symbol, entry, now, false, "cool-off elapsed"), true
+}
+
+// halt no lock
+func (cb *CircuitBreaker) halt(symbol string, entry *breakerSymbol, now time.Time, manual bool, reason string) BreakerEvent {
+	entry.state = BreakerHalted
+	entry.haltedAt = now
+	entry.manual = manual
+
+	if book := cb.books[symbol]; book != nil && cb.config.AuctionOnResume {
+		_, _ = book.SetMatchingMode(orderbook.MatchingAuction)
+	}
+	cb.metrics.AddCounter(MetricBreakerHalts, 1, metrics.Labels{"symbol": symbol})
+	cb.observeHalted()
+	return BreakerEvent{Symbol: symbol, From: BreakerActive, To: BreakerHalted, Manual: manual, Reason: reason, Timestamp: now}
+}
+
+// resume no lock. the window restarts, prices before the halt do not count against the new regime
+func (cb *CircuitBreaker) resume(symbol string, entry *breakerSymbol, now time.Time, manual bool, reason string) BreakerEvent {
+	entry.state = BreakerActive
+	entry.manual = false
+	if len(entry.prices) > 0 {
+		entry.prices = entry.prices[len(entry.prices)-1:]
+		entry.prices[0].at = now
+	}
+
+	event := BreakerEvent{Symbol: symbol, From: BreakerHalted, To: BreakerActive, Manual: manual, Reason: reason, Timestamp: now}
+	if book := cb.books[symbol]; book != nil && book.MatchingMode() == orderbook.MatchingAuction {
+		event.Trades, _ = book.SetMatchingMode(orderbook.MatchingContinuous)
+	}
+	cb.observeHalted()
+	return event
+}
+
+// observeHalted no lock
+func (cb *CircuitBreaker) observeHalted() {
+	halted := 0
+	for _, entry := range cb.symbols {
+		if entry.state == BreakerHalted {
+			halted++
+		}
+	}
+	cb.metrics.SetGauge(MetricHaltedSymbols, float64(halted), nil)
+}
+
+func (cb *CircuitBreaker) emit(handler BreakerEventHandler, events []BreakerEvent) {
+	if handler == nil {
+		return
+	}
+	for _, event := range events {
+		handler(event)
+	}
+}
+
+// move largest relative distance of price from any price in the window
+func (s *breakerSymbol) move(price float64) float64 {
+	move := 0.0
+	for _, point := range s.prices {
+		move = math.Max(move, math.Abs(price-point.price)/point.price)
+	}
+	return move
+}
@@ -0,0 +1,201 @@
+package risk
+
+import (
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/orderbook"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCircuitBreaker(t *testing.T) (*CircuitBreaker, *common.FakeClock, *orderbook.OrderBook, *[]BreakerEvent) {
+	clock := common.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	breaker := NewCircuitBreaker(clock, nil)
+
+	book := orderbook.NewOrderBook("BTCUSDT")
+	breaker.SetOrderBook("BTCUSDT", book)
+
+	var events []BreakerEvent
+	breaker.OnEvent(func(event BreakerEvent) {
+		events = append(events, event)
+	})
+	return breaker, clock, book, &events
+}
+
+func TestCircuitBreakerHaltsOnExtremeMove(t *testing.T) {
+	breaker, clock, book, events := newCircuitBreaker(t)
+	pipeline := NewRiskPipeline(breaker)
+
+	_, err := book.PlaceOrder(&orderbook.Order{ID: "b1", UserID: "user2", Side: orderbook.BUY, Price: 100, Size: 1})
+	require.NoError(t, err)
+
+	// 15% within one minute
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 100))
+	clock.Advance(30 * time.Second)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 108))
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+	clock.Advance(30 * time.Second)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 115))
+
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+	require.Len(t, *events, 1)
+	halt := (*events)[0]
+	assert.Equal(t, BreakerActive, halt.From)
+	assert.Equal(t, BreakerHalted, halt.To)
+	assert.InDelta(t, 0.15, halt.Move, 1e-9)
+	assert.False(t, halt.Manual)
+	assert.Equal(t, orderbook.MatchingAuction, book.MatchingMode())
+
+	// new orders rejected, reduce-only and other symbols pass
+	err = pipeline.Check(newOrder(115, 1, 10))
+	var rejection *Rejection
+	require.ErrorAs(t, err, &rejection)
+	assert.Equal(t, CheckCircuitBreaker, rejection.Check)
+	assert.True(t, errors.Is(err, ErrTradingHalted))
+
+	reduce := newOrder(115, 1, 10)
+	reduce.ReduceOnly = true
+	assert.NoError(t, pipeline.Check(reduce))
+	eth := newOrder(2000, 1, 10)
+	eth.Symbol = "ETHUSDT"
+	assert.NoError(t, pipeline.Check(eth))
+
+	// cancels still allowed
+	_, err = book.CancelOrder(orderbook.BUY, "b1")
+	require.NoError(t, err)
+
+	// orders resting during the halt accumulate for the auction
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "b2", UserID: "user2", Side: orderbook.BUY, Price: 116, Size: 2})
+	require.NoError(t, err)
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "s1", UserID: "user3", Side: orderbook.SELL, Price: 114, Size: 1})
+	require.NoError(t, err)
+
+	// still inside the cool-off
+	clock.Advance(4 * time.Minute)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 114))
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+
+	clock.Advance(time.Minute)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 115))
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+	assert.Equal(t, orderbook.MatchingContinuous, book.MatchingMode())
+	assert.NoError(t, pipeline.Check(newOrder(115, 1, 10)))
+
+	require.Len(t, *events, 2)
+	resume := (*events)[1]
+	assert.Equal(t, BreakerHalted, resume.From)
+	assert.Equal(t, BreakerActive, resume.To)
+	require.Len(t, resume.Trades, 1)
+	assert.Equal(t, 1.0, resume.Trades[0].Size)
+
+	// the window restarted at resumption, the pre-halt 100 no longer counts
+	clock.Advance(10 * time.Second)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 120))
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+}
+
+func TestCircuitBreakerSlowMove(t *testing.T) {
+	breaker, clock, _, events := newCircuitBreaker(t)
+
+	// +3% a minute, never more than 10% within the window
+	price := 100.0
+	for i := 0; i < 10; i++ {
+		require.NoError(t, breaker.OnMarkPrice("BTCUSDT", price))
+		clock.Advance(61 * time.Second)
+		price *= 1.03
+	}
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+	assert.Empty(t, *events)
+
+	// a crash halts too
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", price))
+	clock.Advance(10 * time.Second)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", price*0.85))
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+
+	assert.Error(t, breaker.OnMarkPrice("BTCUSDT", 0))
+}
+
+func TestCircuitBreakerManualOverride(t *testing.T) {
+	breaker, clock, book, events := newCircuitBreaker(t)
+
+	breaker.ForceHalt("BTCUSDT", "exchange maintenance")
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+	assert.Equal(t, orderbook.MatchingAuction, book.MatchingMode())
+
+	// manual halt never resumes on its own
+	clock.Advance(time.Hour)
+	breaker.Poll()
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 100))
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+
+	breaker.ForceResume("BTCUSDT", "maintenance done")
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+	assert.Equal(t, orderbook.MatchingContinuous, book.MatchingMode())
+
+	// automatic halt can be resumed early, or by Poll once the cool-off elapsed
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 150))
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+	clock.Advance(5 * time.Minute)
+	breaker.Poll()
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+
+	// resuming an active symbol is a no-op
+	breaker.ForceResume("BTCUSDT", "noop")
+
+	require.Len(t, *events, 4)
+	assert.True(t, (*events)[0].Manual)
+	assert.Equal(t, "exchange maintenance", (*events)[0].Reason)
+	assert.True(t, (*events)[1].Manual)
+	assert.False(t, (*events)[2].Manual)
+	assert.False(t, (*events)[3].Manual)
+	assert.Equal(t, BreakerActive, (*events)[3].To)
+}
+
+func TestCircuitBreakerMetrics(t *testing.T) {
+	breaker, clock, _, _ := newCircuitBreaker(t)
+	recorder := metrics.NewRecorder()
+	breaker.SetMetrics(recorder)
+
+	// crash on two symbols, one resumed by an operator
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		require.NoError(t, breaker.OnMarkPrice(symbol, 100))
+	}
+	clock.Advance(10 * time.Second)
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		require.NoError(t, breaker.OnMarkPrice(symbol, 80))
+	}
+	halted, ok := recorder.Gauge(MetricHaltedSymbols, nil)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, halted)
+
+	breaker.ForceResume("ETHUSDT", "operator")
+	halted, _ = recorder.Gauge(MetricHaltedSymbols, nil)
+	assert.Equal(t, 1.0, halted)
+	assert.Equal(t, 1.0, recorder.Counter(MetricBreakerHalts, metrics.Labels{"symbol": "BTCUSDT"}))
+	assert.Equal(t, 1.0, recorder.Counter(MetricBreakerHalts, metrics.Labels{"symbol": "ETHUSDT"}))
+}
+
+func TestCircuitBreakerSetConfig(t *testing.T) {
+	breaker, clock, _, _ := newCircuitBreaker(t)
+	breaker.SetConfig(&CircuitBreakerConfig{MaxMove: 0.05, Window: time.Minute, CoolOff: time.Minute})
+
+	// 8% stays under the default 10%, not under 5%
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 100))
+	clock.Advance(10 * time.Second)
+	require.NoError(t, breaker.OnMarkPrice("BTCUSDT", 108))
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+
+	// the halt resumes after the cool-off in force
+	breaker.SetConfig(&CircuitBreakerConfig{MaxMove: 0.05, Window: time.Minute, CoolOff: 2 * time.Minute})
+	clock.Advance(90 * time.Second)
+	breaker.Poll()
+	assert.Equal(t, BreakerHalted, breaker.State("BTCUSDT"))
+	clock.Advance(30 * time.Second)
+	breaker.Poll()
+	assert.Equal(t, BreakerActive, breaker.State("BTCUSDT"))
+}
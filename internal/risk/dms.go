@@ -0,0 +1,186 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"sync"
+	"time"
+)
+
+// CancelAllReasonDMS reason of cancel-all triggered by the dead man's switch
+const CancelAllReasonDMS = "dms"
+
+// CancelAllEvent cancel all open orders of a user, empty Symbols means every symbol
+type CancelAllEvent struct {
+	UserID    string    `json:"user_id"`
+	Symbols   []string  `json:"symbols,omitempty"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CancelAllHandler cancels the orders and publishes the event
+type CancelAllHandler func(event CancelAllEvent)
+
+// dmsEntry one armed switch
+type dmsEntry struct {
+	userID   string
+	timeout  time.Duration
+	deadline time.Time
+	symbols  []string
+}
+
+// DeadMansSwitch (死手開關) cancel all orders of a user who stops sending heartbeats.
+// all users share one timer wheel, heartbeats only move the deadline,
+// entries are rescheduled lazily when their slot comes up.
+type DeadMansSwitch struct {
+	clock    common.Clock
+	tick     time.Duration
+	wheel    [][]*dmsEntry
+	cursor   int       // slot of lastTick
+	lastTick time.Time // time the wheel has been advanced to
+	armed    map[string]*dmsEntry
+	handler  CancelAllHandler
+	mu       sync.Mutex
+}
+
+// NewDeadMansSwitch tick is the wheel resolution, slots * tick one revolution
+func NewDeadMansSwitch(clock common.Clock, tick time.Duration, slots int, handler CancelAllHandler) *DeadMansSwitch {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	return &DeadMansSwitch{
+		clock:    clock,
+		tick:     tick,
+		wheel:    make([][]*dmsEntry, slots),
+		lastTick: clock.Now(),
+		armed:    make(map[string]*dmsEntry),
+		handler:  handler,
+	}
+}
+
+// Arm (re)arm the switch of the user, optionally only for some symbols
+func (d *DeadMansSwitch) Arm(userID string, timeout time.Duration, symbols ...string) error {
+	if timeout < d.tick {
+		return fmt.Errorf("timeout %s shorter than tick %s", timeout, d.tick)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry := &dmsEntry{
+		userID:   userID,
+		timeout:  timeout,
+		deadline: d.clock.Now().Add(timeout),
+		symbols:  symbols,
+	}
+	// old entry (if any) is dropped when its slot comes up
+	d.armed[userID] = entry
+	d.schedule(entry)
+
+	return nil
+}
+
+// Heartbeat push the deadline of the user's switch forward by its timeout
+func (d *DeadMansSwitch) Heartbeat(userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, exists := d.armed[userID]
+	if !exists {
+		return fmt.Errorf("dead man's switch of user %s not armed", userID)
+	}
+	entry.deadline = d.clock.Now().Add(entry.timeout)
+
+	return nil
+}
+
+// Disarm
+func (d *DeadMansSwitch) Disarm(userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.armed, userID)
+}
+
+func (d *DeadMansSwitch) IsArmed(userID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, exists := d.armed[userID]
+	return exists
+}
+
+// Advance move the wheel to clock.Now(), fire expired switches and return their events
+func (d *DeadMansSwitch) Advance() []CancelAllEvent {
+	d.mu.Lock()
+	now := d.clock.Now()
+
+	steps := int(now.Sub(d.lastTick) / d.tick)
+	// one revolution visits every entry
+	visit := min(steps, len(d.wheel))
+	start, startCursor := d.lastTick, d.cursor
+
+	var events []CancelAllEvent
+	for i := 1; i <= visit; i++ {
+		d.cursor = (startCursor + i) % len(d.wheel)
+		d.lastTick = start.Add(time.Duration(i) * d.tick)
+		slot := d.wheel[d.cursor]
+		d.wheel[d.cursor] = nil
+
+		for _, entry := range slot {
+			if d.armed[entry.userID] != entry {
+				continue // disarmed or re-armed
+			}
+			if entry.deadline.After(now) {
+				d.schedule(entry) // heartbeat moved the deadline
+				continue
+			}
+			delete(d.armed, entry.userID)
+			events = append(events, CancelAllEvent{
+				UserID:    entry.userID,
+				Symbols:   entry.symbols,
+				Reason:    CancelAllReasonDMS,
+				Timestamp: now,
+			})
+		}
+	}
+	d.cursor = (startCursor + steps) % len(d.wheel)
+	d.lastTick = start.Add(time.Duration(steps) * d.tick)
+	d.mu.Unlock()
+
+	// outside the lock, handler may call back into the engine
+	if d.handler != nil {
+		for _, event := range events {
+			d.handler(event)
+		}
+	}
+	return events
+}
+
+// Run advance the wheel every tick until ctx is done
+func (d *DeadMansSwitch) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			d.Advance()
+		}
+	}
+}
+
+// schedule put entry into the slot of its deadline, no lock
+func (d *DeadMansSwitch) schedule(entry *dmsEntry) {
+	// ticks from the wheel position, round up so the slot is never early
+	ticks := int((entry.deadline.Sub(d.lastTick) + d.tick - 1) / d.tick)
+	ticks = max(ticks, 1)
+	// beyond one revolution: park in the farthest slot, rescheduled when visited
+	ticks = min(ticks, len(d.wheel))
+
+	slot := (d.cursor + ticks) % len(d.wheel)
+	d.wheel[slot] = append(d.wheel[slot], entry)
+}
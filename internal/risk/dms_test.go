@@ -0,0 +1,116 @@
+package risk
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var dmsStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestDMS() (*DeadMansSwitch, *common.FakeClock, *[]CancelAllEvent) {
+	clock := common.NewFakeClock(dmsStart)
+	var handled []CancelAllEvent
+	dms := NewDeadMansSwitch(clock, 100*time.Millisecond, 64, func(event CancelAllEvent) {
+		handled = append(handled, event)
+	})
+	return dms, clock, &handled
+}
+
+func TestDeadMansSwitchExpiry(t *testing.T) {
+	dms, clock, handled := newTestDMS()
+	require.NoError(t, dms.Arm("mm1", time.Second, "BTCUSDT"))
+
+	clock.Advance(900 * time.Millisecond)
+	assert.Empty(t, dms.Advance())
+	assert.True(t, dms.IsArmed("mm1"))
+
+	clock.Advance(100 * time.Millisecond)
+	events := dms.Advance()
+	require.Len(t, events, 1)
+	assert.Equal(t, CancelAllEvent{
+		UserID:    "mm1",
+		Symbols:   []string{"BTCUSDT"},
+		Reason:    CancelAllReasonDMS,
+		Timestamp: dmsStart.Add(time.Second),
+	}, events[0])
+	assert.Equal(t, events, *handled)
+
+	// one-shot: 觸發後自動解除
+	assert.False(t, dms.IsArmed("mm1"))
+	clock.Advance(5 * time.Second)
+	assert.Empty(t, dms.Advance())
+}
+
+func TestDeadMansSwitchHeartbeat(t *testing.T) {
+	dms, clock, _ := newTestDMS()
+	require.NoError(t, dms.Arm("mm1", time.Second))
+
+	for i := 0; i < 20; i++ {
+		clock.Advance(800 * time.Millisecond)
+		assert.Empty(t, dms.Advance())
+		require.NoError(t, dms.Heartbeat("mm1"))
+	}
+
+	// 停止心跳
+	clock.Advance(900 * time.Millisecond)
+	assert.Empty(t, dms.Advance())
+	clock.Advance(100 * time.Millisecond)
+	require.Len(t, dms.Advance(), 1)
+
+	assert.Error(t, dms.Heartbeat("mm1"), "not armed anymore")
+}
+
+func TestDeadMansSwitchDisarm(t *testing.T) {
+	dms, clock, _ := newTestDMS()
+	require.NoError(t, dms.Arm("mm1", time.Second))
+	dms.Disarm("mm1")
+
+	clock.Advance(2 * time.Second)
+	assert.Empty(t, dms.Advance())
+	assert.Error(t, dms.Heartbeat("mm1"))
+
+	assert.Error(t, dms.Arm("mm1", time.Millisecond), "timeout shorter than tick")
+}
+
+func TestDeadMansSwitchLongTimeout(t *testing.T) {
+	dms, clock, _ := newTestDMS()
+	// 超過一圈 (6.4s)
+	require.NoError(t, dms.Arm("mm1", 15*time.Second))
+
+	for elapsed := 100 * time.Millisecond; elapsed < 15*time.Second; elapsed += 100 * time.Millisecond {
+		clock.Set(dmsStart.Add(elapsed))
+		require.Empty(t, dms.Advance(), elapsed)
+	}
+	clock.Set(dmsStart.Add(15 * time.Second))
+	assert.Len(t, dms.Advance(), 1)
+}
+
+func TestDeadMansSwitchManyUsers(t *testing.T) {
+	dms, clock, _ := newTestDMS()
+
+	const users = 5000
+	for i := 0; i < users; i++ {
+		timeout := time.Duration(1+i%100) * 100 * time.Millisecond // 0.1s ~ 10s
+		require.NoError(t, dms.Arm(fmt.Sprintf("user%d", i), timeout))
+	}
+
+	fired := 0
+	for step := 1; step <= 100; step++ {
+		clock.Advance(100 * time.Millisecond)
+		events := dms.Advance()
+		// 每個 tick 剛好 50 個 user 到期
+		require.Len(t, events, users/100, step)
+		fired += len(events)
+	}
+	assert.Equal(t, users, fired)
+
+	// 時鐘一次跳很遠也不會漏
+	require.NoError(t, dms.Arm("late", 3*time.Second))
+	clock.Advance(time.Minute)
+	assert.Len(t, dms.Advance(), 1)
+}
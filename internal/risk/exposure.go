@@ -0,0 +1,206 @@
+package risk
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/position"
+	"sync"
+)
+
+const CheckExposure = "exposure"
+
+// ExposureLimit (持倉上限) notional caps, 0 means unlimited
+type ExposureLimit struct {
+	MaxGrossNotional float64            `json:"max_gross_notional"` // across all symbols
+	SymbolLimits     map[string]float64 `json:"symbol_limits"`      // symbol -> max notional
+}
+
+// ExposureLimitSnapshot persisted state of an ExposureLimitStore
+type ExposureLimitSnapshot struct {
+	Tiers     map[string]ExposureLimit `json:"tiers"`      // tier name -> limit, includes DefaultTierName
+	UserTiers map[string]string        `json:"user_tiers"` // userID -> tier name
+	Users     map[string]ExposureLimit `json:"users"`      // userID -> override
+}
+
+// ExposureLimitStore resolves a user's limit: user override, then the user's tier, then the default tier
+type ExposureLimitStore struct {
+	tiers    map[string]ExposureLimit
+	userTier map[string]string
+	users    map[string]ExposureLimit
+	mu       sync.RWMutex
+}
+
+func NewExposureLimitStore(defaultLimit ExposureLimit) *ExposureLimitStore {
+	return &ExposureLimitStore{
+		tiers:    map[string]ExposureLimit{DefaultTierName: defaultLimit},
+		userTier: make(map[string]string),
+		users:    make(map[string]ExposureLimit),
+	}
+}
+
+// SetTierLimit add or replace a tier, DefaultTierName replaces the default. takes effect on the next order
+func (s *ExposureLimitStore) SetTierLimit(tier string, limit ExposureLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tiers[tier] = limit
+}
+
+// SetUserTier move user to a registered tier
+func (s *ExposureLimitStore) SetUserTier(userID, tier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tiers[tier]; !exists {
+		return fmt.Errorf("exposure limit tier %s not found", tier)
+	}
+	s.userTier[userID] = tier
+	return nil
+}
+
+// SetUserLimit per-user override, wins over any tier
+func (s *ExposureLimitStore) SetUserLimit(userID string, limit ExposureLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[userID] = limit
+}
+
+func (s *ExposureLimitStore) RemoveUserLimit(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, userID)
+}
+
+// Limit effective limit of a user
+func (s *ExposureLimitStore) Limit(userID string) ExposureLimit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if limit, exists := s.users[userID]; exists {
+		return limit
+	}
+	if tier, exists := s.userTier[userID]; exists {
+		return s.tiers[tier]
+	}
+	return s.tiers[DefaultTierName]
+}
+
+func (s *ExposureLimitStore) Snapshot() ExposureLimitSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := ExposureLimitSnapshot{
+		Tiers:     make(map[string]ExposureLimit, len(s.tiers)),
+		UserTiers: make(map[string]string, len(s.userTier)),
+		Users:     make(map[string]ExposureLimit, len(s.users)),
+	}
+	for tier, limit := range s.tiers {
+		snapshot.Tiers[tier] = limit.clone()
+	}
+	for userID, tier := range s.userTier {
+		snapshot.UserTiers[userID] = tier
+	}
+	for userID, limit := range s.users {
+		snapshot.Users[userID] = limit.clone()
+	}
+	return snapshot
+}
+
+// RestoreExposureLimitStore rebuild a store from its snapshot
+func RestoreExposureLimitStore(snapshot ExposureLimitSnapshot) (*ExposureLimitStore, error) {
+	if _, exists := snapshot.Tiers[DefaultTierName]; !exists {
+		return nil, fmt.Errorf("exposure limit snapshot has no %s tier", DefaultTierName)
+	}
+
+	store := NewExposureLimitStore(snapshot.Tiers[DefaultTierName].clone())
+	for tier, limit := range snapshot.Tiers {
+		store.tiers[tier] = limit.clone()
+	}
+	for userID, tier := range snapshot.UserTiers {
+		if _, exists := store.tiers[tier]; !exists {
+			return nil, fmt.Errorf("exposure limit tier %s of user %s not found", tier, userID)
+		}
+		store.userTier[userID] = tier
+	}
+	for userID, limit := range snapshot.Users {
+		store.users[userID] = limit.clone()
+	}
+	return store, nil
+}
+
+func (l ExposureLimit) clone() ExposureLimit {
+	if l.SymbolLimits == nil {
+		return l
+	}
+	symbolLimits := make(map[string]float64, len(l.SymbolLimits))
+	for symbol, limit := range l.SymbolLimits {
+		symbolLimits[symbol] = limit
+	}
+	l.SymbolLimits = symbolLimits
+	return l
+}
+
+// ========================================================
+
+// OpenOrderNotionalProvider notional of a user's resting orders per symbol
+type OpenOrderNotionalProvider interface {
+	OpenOrderNotional(userID string) map[string]float64
+}
+
+// ExposureChecker (持倉上限) position notional + resting orders + this order must stay within the user's limit.
+// reduce-only orders are always allowed.
+type ExposureChecker struct {
+	PositionMgr *position.PositionManager
+	Orders      OpenOrderNotionalProvider // nil means no resting orders counted
+	Limits      *ExposureLimitStore
+}
+
+func (c *ExposureChecker) Name() string { return CheckExposure }
+
+func (c *ExposureChecker) Check(req *OrderRequest) error {
+	if req.ReduceOnly {
+		return nil
+	}
+
+	limit := c.Limits.Limit(req.UserID)
+	symbolLimit := limit.SymbolLimits[req.Symbol]
+	if limit.MaxGrossNotional <= 0 && symbolLimit <= 0 {
+		return nil
+	}
+
+	// would-be exposure after the order
+	exposure := c.exposure(req.UserID)
+	exposure[req.Symbol] += req.Notional()
+
+	gross := 0.0
+	for _, notional := range exposure {
+		gross += notional
+	}
+
+	if symbolLimit > 0 && exposure[req.Symbol] > symbolLimit {
+		return fmt.Errorf("%s notional %.2f would exceed limit %.2f", req.Symbol, exposure[req.Symbol], symbolLimit)
+	}
+	if limit.MaxGrossNotional > 0 && gross > limit.MaxGrossNotional {
+		return fmt.Errorf("gross notional %.2f would exceed limit %.2f", gross, limit.MaxGrossNotional)
+	}
+	return nil
+}
+
+// exposure current notional per symbol: open positions at mark price plus resting orders
+func (c *ExposureChecker) exposure(userID string) map[string]float64 {
+	exposure := make(map[string]float64)
+
+	if positions, err := c.PositionMgr.GetUserPositions(userID); err == nil {
+		for _, pos := range positions {
+			snapshot := pos.Snapshot()
+			if snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+				continue
+			}
+			exposure[snapshot.Symbol] += snapshot.PositionValue
+		}
+	}
+	if c.Orders != nil {
+		for symbol, notional := range c.Orders.OpenOrderNotional(userID) {
+			exposure[symbol] += notional
+		}
+	}
+	return exposure
+}
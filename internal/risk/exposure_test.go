@@ -0,0 +1,100 @@
+package risk
+
+import (
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type restingNotional map[string]map[string]float64
+
+func (r restingNotional) OpenOrderNotional(userID string) map[string]float64 { return r[userID] }
+
+func newExposureChecker(t *testing.T, orders restingNotional) (*ExposureChecker, *ExposureLimitStore) {
+	pm := position.NewPositionManager(symbols)
+	// 1.5M BTC at mark price
+	_, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 50000, 30, 10)
+	require.NoError(t, err)
+
+	limits := NewExposureLimitStore(ExposureLimit{MaxGrossNotional: 2_000_000})
+	return &ExposureChecker{PositionMgr: pm, Orders: orders, Limits: limits}, limits
+}
+
+func exposureOrder(symbol string, price, size float64) *OrderRequest {
+	req := newOrder(price, size, 10)
+	req.Symbol = symbol
+	return req
+}
+
+func TestExposureCheckerGrossLimit(t *testing.T) {
+	// 300k resting on ETH
+	checker, _ := newExposureChecker(t, restingNotional{"user1": {"ETHUSDT": 300_000}})
+
+	// 1.5M + 300k + 200k = exactly 2M
+	assert.NoError(t, checker.Check(exposureOrder("ETHUSDT", 2000, 100)))
+	assert.ErrorContains(t, checker.Check(exposureOrder("ETHUSDT", 2000, 100.5)), "gross notional")
+
+	// without the resting orders the same order would fit
+	checker.Orders = nil
+	assert.NoError(t, checker.Check(exposureOrder("ETHUSDT", 2000, 250)))
+
+	// reduce-only always passes
+	checker.Orders = restingNotional{"user1": {"ETHUSDT": 300_000}}
+	req := exposureOrder("BTCUSDT", 50000, 30)
+	req.ReduceOnly = true
+	assert.NoError(t, checker.Check(req))
+
+	// other users are not affected by user1's exposure
+	req = exposureOrder("BTCUSDT", 50000, 40)
+	req.UserID = "user2"
+	assert.NoError(t, checker.Check(req))
+}
+
+func TestExposureCheckerSymbolLimitAndOverrides(t *testing.T) {
+	checker, limits := newExposureChecker(t, nil)
+
+	limits.SetTierLimit("vip", ExposureLimit{
+		MaxGrossNotional: 10_000_000,
+		SymbolLimits:     map[string]float64{"BTCUSDT": 1_600_000},
+	})
+	require.NoError(t, limits.SetUserTier("user1", "vip"))
+	assert.Error(t, limits.SetUserTier("user1", "unknown"))
+
+	// sub-limit: 1.5M + 100k = exactly 1.6M
+	assert.NoError(t, checker.Check(exposureOrder("BTCUSDT", 50000, 2)))
+	assert.ErrorContains(t, checker.Check(exposureOrder("BTCUSDT", 50000, 2.1)), "BTCUSDT notional")
+	assert.NoError(t, checker.Check(exposureOrder("ETHUSDT", 2000, 1000)), "no ETH sub-limit")
+
+	// per-user override wins over the tier, changed at runtime
+	limits.SetUserLimit("user1", ExposureLimit{MaxGrossNotional: 1_500_000})
+	assert.Error(t, checker.Check(exposureOrder("ETHUSDT", 2000, 1)))
+	limits.RemoveUserLimit("user1")
+	assert.NoError(t, checker.Check(exposureOrder("ETHUSDT", 2000, 1)))
+
+	// limits survive a snapshot round trip
+	limits.SetUserLimit("user2", ExposureLimit{MaxGrossNotional: 100})
+	snapshot := limits.Snapshot()
+	restored, err := RestoreExposureLimitStore(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, restored.Snapshot())
+	assert.Equal(t, limits.Limit("user1"), restored.Limit("user1"))
+	assert.Equal(t, 100.0, restored.Limit("user2").MaxGrossNotional)
+	assert.Equal(t, 2_000_000.0, restored.Limit("user3").MaxGrossNotional)
+
+	// snapshot is a copy
+	snapshot.Tiers["vip"].SymbolLimits["BTCUSDT"] = 1
+	assert.Equal(t, 1_600_000.0, limits.Limit("user1").SymbolLimits["BTCUSDT"])
+}
+
+func TestExposureCheckerInPipeline(t *testing.T) {
+	checker, _ := newExposureChecker(t, nil)
+	pipeline := NewRiskPipeline(checker)
+
+	err := pipeline.Check(exposureOrder("ETHUSDT", 2000, 300))
+	var rejection *Rejection
+	require.ErrorAs(t, err, &rejection)
+	assert.Equal(t, CheckExposure, rejection.Check)
+}
@@ -0,0 +1,164 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sync"
+	"time"
+)
+
+const CheckKillSwitch = "kill_switch"
+
+var ErrKillSwitch = errors.New("kill switch engaged")
+
+// KillSwitchMode OFF, CLOSE_ONLY or HALT
+type KillSwitchMode int
+
+const (
+	KillSwitchOff       KillSwitchMode = iota
+	KillSwitchCloseOnly                // only reduce-only orders and position reduces
+	KillSwitchHalt                     // resting orders cancelled, nothing but liquidations
+)
+
+func (m KillSwitchMode) String() string {
+	switch m {
+	case KillSwitchOff:
+		return "OFF"
+	case KillSwitchCloseOnly:
+		return "CLOSE_ONLY"
+	case KillSwitchHalt:
+		return "HALT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// KillSwitchEvent operator audit entry of one mode change
+type KillSwitchEvent struct {
+	Symbol    string             `json:"symbol"`
+	From      KillSwitchMode     `json:"from"`
+	To        KillSwitchMode     `json:"to"`
+	Reason    string             `json:"reason"`
+	Cancelled []*orderbook.Order `json:"cancelled,omitempty"` // resting orders cancelled by HALT
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// KillSwitchEventHandler called on every mode change, outside the switch lock
+type KillSwitchEventHandler func(event KillSwitchEvent)
+
+// SymbolKillSwitch (緊急開關) per-symbol operator control honored by the order books, the position manager
+// and the risk pipeline. CLOSE_ONLY refuses anything that increases exposure, HALT also cancels the resting
+// orders and refuses every order and position change; liquidations are never blocked.
+type SymbolKillSwitch struct {
+	clock   common.Clock
+	modes   map[string]KillSwitchMode
+	books   map[string]*orderbook.OrderBook
+	onEvent KillSwitchEventHandler
+	mu      sync.RWMutex
+}
+
+// NewSymbolKillSwitch clock nil means system clock
+func NewSymbolKillSwitch(clock common.Clock) *SymbolKillSwitch {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	return &SymbolKillSwitch{
+		clock: clock,
+		modes: make(map[string]KillSwitchMode),
+		books: make(map[string]*orderbook.OrderBook),
+	}
+}
+
+// SetOrderBook guard the book's PlaceOrder and PlaceIOC, cancel its resting orders on HALT
+func (ks *SymbolKillSwitch) SetOrderBook(symbol string, book *orderbook.OrderBook) {
+	ks.mu.Lock()
+	ks.books[symbol] = book
+	ks.mu.Unlock()
+
+	book.SetOrderGuard(func(order *orderbook.Order) error {
+		return ks.check(symbol, !order.ReduceOnly)
+	})
+}
+
+// SetPositionManager guard opens, adds, reduces and closes of the position manager
+func (ks *SymbolKillSwitch) SetPositionManager(pm *position.PositionManager) {
+	pm.SetExposureGuard(ks.check)
+}
+
+func (ks *SymbolKillSwitch) OnEvent(handler KillSwitchEventHandler) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.onEvent = handler
+}
+
+// KillSwitch set the mode of symbol, KillSwitchOff restores normal flow. switching to HALT cancels the
+// resting orders of the symbol's book, they are reported in the event
+func (ks *SymbolKillSwitch) KillSwitch(symbol string, mode KillSwitchMode, reason string) (KillSwitchEvent, error) {
+	if mode < KillSwitchOff || mode > KillSwitchHalt {
+		return KillSwitchEvent{}, fmt.Errorf("unknown kill switch mode %d", mode)
+	}
+
+	ks.mu.Lock()
+	event := KillSwitchEvent{Symbol: symbol, From: ks.modes[symbol], To: mode, Reason: reason, Timestamp: ks.clock.Now()}
+	if mode == KillSwitchOff {
+		delete(ks.modes, symbol)
+	} else {
+		ks.modes[symbol] = mode
+	}
+	book := ks.books[symbol]
+	handler := ks.onEvent
+	ks.mu.Unlock()
+
+	if event.From == event.To {
+		return event, nil
+	}
+	// outside the switch lock: the book guard takes it under the book lock.
+	// orders placed after the mode change are refused, so none rests after the cancel
+	if mode == KillSwitchHalt && book != nil {
+		event.Cancelled = book.CancelAll()
+	}
+	if handler != nil {
+		handler(event)
+	}
+	return event, nil
+}
+
+// Mode current mode of symbol
+func (ks *SymbolKillSwitch) Mode(symbol string) KillSwitchMode {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.modes[symbol]
+}
+
+// Engaged mode of every symbol whose switch is not OFF
+func (ks *SymbolKillSwitch) Engaged() map[string]KillSwitchMode {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	engaged := make(map[string]KillSwitchMode, len(ks.modes))
+	for symbol, mode := range ks.modes {
+		engaged[symbol] = mode
+	}
+	return engaged
+}
+
+// Name / Check risk pipeline checker
+func (ks *SymbolKillSwitch) Name() string { return CheckKillSwitch }
+
+func (ks *SymbolKillSwitch) Check(req *OrderRequest) error {
+	return ks.check(req.Symbol, !req.ReduceOnly)
+}
+
+// check allowed change of symbol, increase is true for anything that may add exposure
+func (ks *SymbolKillSwitch) check(symbol string, increase bool) error {
+	switch mode := ks.Mode(symbol); {
+	case mode == KillSwitchHalt:
+		return fmt.Errorf("%w: %s %s", ErrKillSwitch, symbol, mode)
+	case mode == KillSwitchCloseOnly && increase:
+		return fmt.Errorf("%w: %s %s", ErrKillSwitch, symbol, mode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package risk
+
+import (
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillSwitchModes(t *testing.T) {
+	ks := NewSymbolKillSwitch(nil)
+	var events []KillSwitchEvent
+	ks.OnEvent(func(event KillSwitchEvent) { events = append(events, event) })
+
+	book := orderbook.NewOrderBook("BTCUSDT")
+	ks.SetOrderBook("BTCUSDT", book)
+	pm := position.NewPositionManager([]string{"BTCUSDT", "ETHUSDT"})
+	defer pm.Close()
+	ks.SetPositionManager(pm)
+	pipeline := NewRiskPipeline(ks)
+
+	_, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 100, 3, 10)
+	require.NoError(t, err)
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "b1", UserID: "user2", Side: orderbook.BUY, Price: 90, Size: 1})
+	require.NoError(t, err)
+	_, err = book.PlaceOrder(&orderbook.Order{ID: "a1", UserID: "user2", Side: orderbook.SELL, Price: 110, Size: 1})
+	require.NoError(t, err)
+
+	order := func(id string, reduceOnly bool) error {
+		_, err := book.PlaceOrder(&orderbook.Order{ID: id, UserID: "user1", Side: orderbook.BUY, Price: 95, Size: 0.1, ReduceOnly: reduceOnly})
+		return err
+	}
+	request := func(reduceOnly bool) error {
+		req := newOrder(100, 1, 10)
+		req.ReduceOnly = reduceOnly
+		return pipeline.Check(req)
+	}
+	open := func() error {
+		_, err := pm.OpenPosition(common.ISOLATED, "user1", "BTCUSDT", position.LONG, 100, 0.1, 10)
+		return err
+	}
+	reduce := func() error {
+		_, _, err := pm.ReducePosition("user1", "BTCUSDT", position.LONG, 100, 0.1)
+		return err
+	}
+
+	// CLOSE_ONLY: reduce-only orders and reduces pass
+	event, err := ks.KillSwitch("BTCUSDT", KillSwitchCloseOnly, "exchange incident")
+	require.NoError(t, err)
+	assert.Equal(t, KillSwitchOff, event.From)
+	assert.Equal(t, KillSwitchCloseOnly, ks.Mode("BTCUSDT"))
+	assert.Empty(t, event.Cancelled)
+	assert.Len(t, book.Depth(orderbook.BUY, 10), 1)
+	assert.Len(t, book.Depth(orderbook.SELL, 10), 1)
+
+	assert.ErrorIs(t, order("c1", false), ErrKillSwitch)
+	assert.NoError(t, order("c2", true))
+	assert.ErrorIs(t, request(false), ErrKillSwitch)
+	assert.NoError(t, request(true))
+	assert.ErrorIs(t, open(), ErrKillSwitch)
+	_, _, err = pm.AddPosition("user1", "BTCUSDT", position.LONG, 100, 0.1)
+	assert.ErrorIs(t, err, ErrKillSwitch)
+	assert.NoError(t, reduce())
+
+	// other symbols are not affected
+	_, err = pm.OpenPosition(common.ISOLATED, "user1", "ETHUSDT", position.LONG, 100, 1, 10)
+	assert.NoError(t, err)
+
+	// HALT: resting orders cancelled, everything blocked but liquidations
+	event, err = ks.KillSwitch("BTCUSDT", KillSwitchHalt, "exchange incident")
+	require.NoError(t, err)
+	assert.Len(t, event.Cancelled, 3)
+	assert.Empty(t, book.Depth(orderbook.BUY, 10))
+	assert.Empty(t, book.Depth(orderbook.SELL, 10))
+
+	assert.ErrorIs(t, order("h1", false), ErrKillSwitch)
+	assert.ErrorIs(t, order("h2", true), ErrKillSwitch)
+	_, err = book.PlaceIOC(&orderbook.Order{ID: "h3", UserID: "user1", Side: orderbook.BUY, Price: 95, Size: 0.1, ReduceOnly: true})
+	assert.ErrorIs(t, err, ErrKillSwitch)
+	assert.ErrorIs(t, request(true), ErrKillSwitch)
+	assert.ErrorIs(t, open(), ErrKillSwitch)
+	assert.ErrorIs(t, reduce(), ErrKillSwitch)
+	_, _, err = pm.ClosePosition("user1", "BTCUSDT", position.LONG, 100)
+	assert.ErrorIs(t, err, ErrKillSwitch)
+
+	pos, err := pm.GetPosition("user1", "BTCUSDT", position.LONG)
+	require.NoError(t, err)
+	require.True(t, pos.ClaimAccountLiquidationLease("liquidator", 0, pos.Snapshot().OpenTime))
+	_, _, err = pm.LiquidatePositionAs("liquidator", pos, 100, 0.1)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]KillSwitchMode{"BTCUSDT": KillSwitchHalt}, ks.Engaged())
+
+	// OFF: normal flow again
+	_, err = ks.KillSwitch("BTCUSDT", KillSwitchOff, "resolved")
+	require.NoError(t, err)
+	assert.Empty(t, ks.Engaged())
+	assert.NoError(t, order("o1", false))
+	assert.NoError(t, request(false))
+	_, err = pm.OpenPosition(common.ISOLATED, "user3", "BTCUSDT", position.SHORT, 100, 1, 10)
+	assert.NoError(t, err)
+
+	// audit trail
+	require.Len(t, events, 3)
+	assert.Equal(t, []KillSwitchMode{KillSwitchCloseOnly, KillSwitchHalt, KillSwitchOff},
+		[]KillSwitchMode{events[0].To, events[1].To, events[2].To})
+	assert.Equal(t, "resolved", events[2].Reason)
+
+	// no-op switch emits nothing
+	_, err = ks.KillSwitch("BTCUSDT", KillSwitchOff, "again")
+	require.NoError(t, err)
+	assert.Len(t, events, 3)
+	_, err = ks.KillSwitch("BTCUSDT", KillSwitchMode(9), "")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrKillSwitch))
+}
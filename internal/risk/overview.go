@@ -0,0 +1,161 @@
+package risk
+
+import (
+	"container/heap"
+	"fmt"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"math"
+	"time"
+)
+
+// DefaultMarginRatioBuckets upper bounds (%) of the margin ratio histogram, the last bucket is open ended
+var DefaultMarginRatioBuckets = []float64{1, 2, 5, 10, 20, 50, 100}
+
+// LiquidationDistances distance of the mark price to the liquidation price reported by GetRiskOverview
+var LiquidationDistances = []float64{0.01, 0.05, 0.10}
+
+// RiskOverviewTopN largest positions by maintenance margin in the overview
+const RiskOverviewTopN = 10
+
+// MarginRatioBucket positions with Min <= MarginRatio < Max (%)
+type MarginRatioBucket struct {
+	Min      float64 `json:"min"` // 0 for the first bucket, which also holds negative ratios
+	Max      float64 `json:"max"` // 0 for the open-ended last bucket
+	Count    int     `json:"count"`
+	Notional float64 `json:"notional"`
+}
+
+// NotionalNearLiquidation open notional whose mark price is within Distance of its liquidation price
+type NotionalNearLiquidation struct {
+	Distance float64 `json:"distance"` // e.g. 0.01 for 1%
+	Count    int     `json:"count"`
+	Notional float64 `json:"notional"`
+}
+
+// RiskOverview (風險總覽) margin ratio distribution and tail exposure of one symbol, for dashboards
+type RiskOverview struct {
+	Symbol            string  `json:"symbol"`
+	Positions         int     `json:"positions"`
+	OpenNotional      float64 `json:"open_notional"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+
+	MarginRatioHistogram []MarginRatioBucket             `json:"margin_ratio_histogram"`
+	NearLiquidation      []NotionalNearLiquidation       `json:"near_liquidation"`
+	TopMaintenanceMargin []position.LiquidationCandidate `json:"top_maintenance_margin"` // largest first
+
+	InsuranceFund     float64 `json:"insurance_fund"`
+	InsuranceCoverage float64 `json:"insurance_coverage"` // fund / open notional, 0 without open notional
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RiskService read-only risk views composing position and margin data
+type RiskService struct {
+	PositionMgr  *position.PositionManager
+	Margin       *margin.MarginSystem
+	RatioBuckets []float64 // ascending upper bounds, nil means DefaultMarginRatioBuckets
+}
+
+// GetRiskOverview one pass over the open positions of the symbol (liquidating included).
+// the candidates come sorted by margin ratio so the histogram is filled in order, and the top
+// maintenance margins are kept in a bounded heap.
+func (s *RiskService) GetRiskOverview(symbol string) (*RiskOverview, error) {
+	if !s.PositionMgr.HasSymbol(symbol) {
+		return nil, fmt.Errorf("%w: %s", position.ErrSymbolNotFound, symbol)
+	}
+
+	bounds := s.RatioBuckets
+	if len(bounds) == 0 {
+		bounds = DefaultMarginRatioBuckets
+	}
+	overview := &RiskOverview{
+		Symbol:               symbol,
+		MarginRatioHistogram: make([]MarginRatioBucket, len(bounds)+1),
+		NearLiquidation:      make([]NotionalNearLiquidation, len(LiquidationDistances)),
+		Timestamp:            time.Now(),
+	}
+	for i := range overview.MarginRatioHistogram {
+		bucket := &overview.MarginRatioHistogram[i]
+		if i > 0 {
+			bucket.Min = bounds[i-1]
+		}
+		if i < len(bounds) {
+			bucket.Max = bounds[i]
+		}
+	}
+	for i, distance := range LiquidationDistances {
+		overview.NearLiquidation[i].Distance = distance
+	}
+
+	top := &maintenanceMarginHeap{}
+	bucket := 0
+	for _, candidate := range s.PositionMgr.GetPositionsByMarginRatio(symbol, math.Inf(1)) {
+		notional := candidate.Size * candidate.MarkPrice
+		overview.Positions++
+		overview.OpenNotional += notional
+		overview.MaintenanceMargin += candidate.MaintenanceMargin
+
+		for bucket < len(bounds) && candidate.MarginRatio >= bounds[bucket] {
+			bucket++
+		}
+		overview.MarginRatioHistogram[bucket].Count++
+		overview.MarginRatioHistogram[bucket].Notional += notional
+
+		if distance, ok := liquidationDistance(candidate); ok {
+			for i := range overview.NearLiquidation {
+				if distance <= overview.NearLiquidation[i].Distance {
+					overview.NearLiquidation[i].Count++
+					overview.NearLiquidation[i].Notional += notional
+				}
+			}
+		}
+
+		if top.Len() < RiskOverviewTopN {
+			heap.Push(top, candidate)
+		} else if candidate.MaintenanceMargin > (*top)[0].MaintenanceMargin {
+			(*top)[0] = candidate
+			heap.Fix(top, 0)
+		}
+	}
+
+	overview.TopMaintenanceMargin = make([]position.LiquidationCandidate, top.Len())
+	for i := top.Len() - 1; i >= 0; i-- {
+		overview.TopMaintenanceMargin[i] = heap.Pop(top).(position.LiquidationCandidate)
+	}
+
+	if s.Margin != nil {
+		overview.InsuranceFund = s.Margin.InsuranceFund().Balance()
+		if overview.OpenNotional > 0 {
+			overview.InsuranceCoverage = overview.InsuranceFund / overview.OpenNotional
+		}
+	}
+	return overview, nil
+}
+
+// liquidationDistance relative move of the mark price left before liquidation, <= 0 when already crossed
+func liquidationDistance(candidate position.LiquidationCandidate) (float64, bool) {
+	if candidate.LiquidationPrice <= 0 || candidate.MarkPrice <= 0 {
+		return 0, false
+	}
+	if candidate.Side == position.LONG {
+		return (candidate.MarkPrice - candidate.LiquidationPrice) / candidate.MarkPrice, true
+	}
+	return (candidate.LiquidationPrice - candidate.MarkPrice) / candidate.MarkPrice, true
+}
+
+// maintenanceMarginHeap min-heap, the root is the smallest of the current top N
+type maintenanceMarginHeap []position.LiquidationCandidate
+
+func (h maintenanceMarginHeap) Len() int { return len(h) }
+func (h maintenanceMarginHeap) Less(i, j int) bool {
+	return h[i].MaintenanceMargin < h[j].MaintenanceMargin
+}
+func (h maintenanceMarginHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *maintenanceMarginHeap) Push(x any)   { *h = append(*h, x.(position.LiquidationCandidate)) }
+func (h *maintenanceMarginHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
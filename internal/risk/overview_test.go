@@ -0,0 +1,100 @@
+package risk
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRiskOverview(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	defer pm.Close()
+	ms := margin.NewMarginSystem(pm, nil)
+	require.NoError(t, ms.InsuranceFund().Deposit("seed", 30_000))
+
+	// 1 BTC longs, margin ratio at the entry price = 100 / leverage (%)
+	leverages := []uint{2, 5, 10, 20, 25, 50}
+	for i, leverage := range leverages {
+		_, err := pm.OpenPosition(common.ISOLATED, fmt.Sprintf("user%d", i), "BTCUSDT", position.LONG, 50000, 1, leverage)
+		require.NoError(t, err)
+	}
+	// other symbols are not counted
+	_, err := pm.OpenPosition(common.ISOLATED, "user0", "ETHUSDT", position.LONG, 2000, 10, 50)
+	require.NoError(t, err)
+	_, err = pm.UpdateMarkPrices("BTCUSDT", 50000)
+	require.NoError(t, err)
+
+	service := &RiskService{PositionMgr: pm, Margin: ms}
+	overview, err := service.GetRiskOverview("BTCUSDT")
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, overview.Positions)
+	assert.InDelta(t, 300_000, overview.OpenNotional, 1e-6)
+
+	// [0,1) [1,2) [2,5) [5,10) [10,20) [20,50) [50,100) [100,∞)
+	counts := make([]int, len(overview.MarginRatioHistogram))
+	for i, bucket := range overview.MarginRatioHistogram {
+		counts[i] = bucket.Count
+	}
+	assert.Equal(t, []int{0, 0, 2, 1, 1, 1, 1, 0}, counts)
+	assert.Equal(t, MarginRatioBucket{Min: 2, Max: 5, Count: 2, Notional: 100_000}, overview.MarginRatioHistogram[2])
+	assert.Equal(t, 100.0, overview.MarginRatioHistogram[7].Min)
+	assert.Zero(t, overview.MarginRatioHistogram[7].Max)
+
+	// expected distances from the positions' own liquidation prices
+	require.Len(t, overview.NearLiquidation, 3)
+	positions, err := pm.GetSymbolPositions("BTCUSDT")
+	require.NoError(t, err)
+	for _, near := range overview.NearLiquidation {
+		expected := 0
+		for _, pos := range positions {
+			snapshot := pos.Snapshot()
+			if (snapshot.MarkPrice-snapshot.LiquidationPrice)/snapshot.MarkPrice <= near.Distance {
+				expected++
+			}
+		}
+		assert.Equal(t, expected, near.Count, "within %.0f%%", near.Distance*100)
+		assert.InDelta(t, float64(expected)*50000, near.Notional, 1e-6)
+	}
+	// distance ≈ 1/leverage - maintenance rate
+	assert.Zero(t, overview.NearLiquidation[0].Count)
+	assert.Equal(t, 3, overview.NearLiquidation[1].Count, "50x, 25x and 20x within 5%")
+	assert.Equal(t, 4, overview.NearLiquidation[2].Count, "and 10x within 10%")
+
+	// maintenance margin is the same rate for every 1 BTC position
+	assert.Len(t, overview.TopMaintenanceMargin, 6)
+	for i := 1; i < len(overview.TopMaintenanceMargin); i++ {
+		assert.GreaterOrEqual(t, overview.TopMaintenanceMargin[i-1].MaintenanceMargin, overview.TopMaintenanceMargin[i].MaintenanceMargin)
+	}
+
+	assert.Equal(t, 30_000.0, overview.InsuranceFund)
+	assert.InDelta(t, 0.1, overview.InsuranceCoverage, 1e-9)
+
+	_, err = service.GetRiskOverview("DOGEUSDT")
+	assert.ErrorIs(t, err, position.ErrSymbolNotFound)
+}
+
+func TestGetRiskOverviewTopMaintenanceMargin(t *testing.T) {
+	pm := position.NewPositionManager(symbols)
+	defer pm.Close()
+
+	// sizes 1..25, the top 10 are the 10 largest
+	for i := 1; i <= 25; i++ {
+		_, err := pm.OpenPosition(common.ISOLATED, fmt.Sprintf("user%02d", i), "ETHUSDT", position.SHORT, 2000, float64(i), 10)
+		require.NoError(t, err)
+	}
+
+	overview, err := (&RiskService{PositionMgr: pm}).GetRiskOverview("ETHUSDT")
+	require.NoError(t, err)
+	require.Len(t, overview.TopMaintenanceMargin, RiskOverviewTopN)
+	for i, candidate := range overview.TopMaintenanceMargin {
+		assert.Equal(t, fmt.Sprintf("user%02d", 25-i), candidate.UserID)
+	}
+	assert.Zero(t, overview.InsuranceCoverage, "no margin system")
+	assert.Equal(t, 25, overview.MarginRatioHistogram[4].Count, "10x shorts at the entry price")
+}
@@ -0,0 +1,141 @@
+package risk
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/position"
+	"sync"
+)
+
+// OrderRequest order to be checked before it hits the book
+type OrderRequest struct {
+	UserID     string
+	Symbol     string
+	Side       position.PositionSide // position side the order opens or reduces
+	Price      float64
+	Size       float64
+	Leverage   int16
+	ReduceOnly bool
+}
+
+// Notional order value (名目價值)
+func (r *OrderRequest) Notional() float64 {
+	return r.Price * r.Size
+}
+
+// ========================================================
+
+// Rejection typed pre-trade rejection carrying the failed check
+type Rejection struct {
+	Check string
+	Err   error
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("order rejected by %s check: %v", r.Check, r.Err)
+}
+
+func (r *Rejection) Unwrap() error {
+	return r.Err
+}
+
+// ========================================================
+
+// Checker one pre-trade risk check
+type Checker interface {
+	Name() string
+	Check(req *OrderRequest) error
+}
+
+// RiskPipeline (下單前風控) ordered chain of checkers, each can be toggled at runtime
+type RiskPipeline struct {
+	checkers []Checker
+	disabled map[string]bool // checker name -> disabled
+	mu       sync.RWMutex
+}
+
+func NewRiskPipeline(checkers ...Checker) *RiskPipeline {
+	return &RiskPipeline{
+		checkers: checkers,
+		disabled: make(map[string]bool),
+	}
+}
+
+// Register append checker to the end of the chain
+func (p *RiskPipeline) Register(checker Checker) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.checkers {
+		if c.Name() == checker.Name() {
+			return fmt.Errorf("checker %s already registered", checker.Name())
+		}
+	}
+	p.checkers = append(p.checkers, checker)
+	return nil
+}
+
+// Enable turn on checker by name
+func (p *RiskPipeline) Enable(name string) error {
+	return p.setEnabled(name, true)
+}
+
+// Disable turn off checker by name
+func (p *RiskPipeline) Disable(name string) error {
+	return p.setEnabled(name, false)
+}
+
+func (p *RiskPipeline) IsEnabled(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.disabled[name]
+}
+
+// Check run enabled checkers in order, stop at first failure
+func (p *RiskPipeline) Check(req *OrderRequest) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, checker := range p.checkers {
+		if p.disabled[checker.Name()] {
+			continue
+		}
+		if err := checker.Check(req); err != nil {
+			return &Rejection{Check: checker.Name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// CheckAll run every enabled checker and collect all failures (for diagnostics)
+func (p *RiskPipeline) CheckAll(req *OrderRequest) []*Rejection {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var rejections []*Rejection
+	for _, checker := range p.checkers {
+		if p.disabled[checker.Name()] {
+			continue
+		}
+		if err := checker.Check(req); err != nil {
+			rejections = append(rejections, &Rejection{Check: checker.Name(), Err: err})
+		}
+	}
+	return rejections
+}
+
+func (p *RiskPipeline) setEnabled(name string, enabled bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.checkers {
+		if c.Name() == name {
+			if enabled {
+				delete(p.disabled, name)
+			} else {
+				p.disabled[name] = true
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("checker %s not registered", name)
+}
@@ -0,0 +1,85 @@
+package risk
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubChecker fails when fail is true and counts invocations
+type stubChecker struct {
+	name  string
+	fail  bool
+	calls int
+}
+
+func (c *stubChecker) Name() string { return c.name }
+
+func (c *stubChecker) Check(req *OrderRequest) error {
+	c.calls++
+	if c.fail {
+		return errors.New(c.name + " failed")
+	}
+	return nil
+}
+
+func TestRiskPipelineShortCircuit(t *testing.T) {
+	first := &stubChecker{name: "first"}
+	second := &stubChecker{name: "second", fail: true}
+	third := &stubChecker{name: "third", fail: true}
+	pipeline := NewRiskPipeline(first, second, third)
+
+	err := pipeline.Check(&OrderRequest{UserID: "user1"})
+	require.Error(t, err)
+
+	var rejection *Rejection
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, "second", rejection.Check)
+	assert.Contains(t, err.Error(), "second failed")
+
+	assert.Equal(t, 1, first.calls)
+	assert.Equal(t, 1, second.calls)
+	assert.Equal(t, 0, third.calls, "checks after the first failure should not run")
+}
+
+func TestRiskPipelineCheckAll(t *testing.T) {
+	pipeline := NewRiskPipeline(
+		&stubChecker{name: "a", fail: true},
+		&stubChecker{name: "b"},
+		&stubChecker{name: "c", fail: true},
+	)
+
+	rejections := pipeline.CheckAll(&OrderRequest{})
+	require.Len(t, rejections, 2)
+	assert.Equal(t, "a", rejections[0].Check)
+	assert.Equal(t, "c", rejections[1].Check)
+}
+
+func TestRiskPipelineRuntimeToggle(t *testing.T) {
+	failing := &stubChecker{name: "failing", fail: true}
+	pipeline := NewRiskPipeline(failing)
+
+	assert.Error(t, pipeline.Check(&OrderRequest{}))
+
+	require.NoError(t, pipeline.Disable("failing"))
+	assert.False(t, pipeline.IsEnabled("failing"))
+	assert.NoError(t, pipeline.Check(&OrderRequest{}))
+	assert.Empty(t, pipeline.CheckAll(&OrderRequest{}))
+
+	require.NoError(t, pipeline.Enable("failing"))
+	assert.True(t, pipeline.IsEnabled("failing"))
+	assert.Error(t, pipeline.Check(&OrderRequest{}))
+
+	assert.Error(t, pipeline.Disable("unknown"))
+}
+
+func TestRiskPipelineRegister(t *testing.T) {
+	pipeline := NewRiskPipeline()
+	assert.NoError(t, pipeline.Check(&OrderRequest{}))
+
+	require.NoError(t, pipeline.Register(&stubChecker{name: "late", fail: true}))
+	assert.Error(t, pipeline.Register(&stubChecker{name: "late"}), "duplicate name")
+	assert.Error(t, pipeline.Check(&OrderRequest{}))
+}
@@ -0,0 +1,194 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"math"
+	"sync"
+	"time"
+)
+
+const CheckRateLimit = "rate_limit"
+
+// OrderAction order operation being rate limited
+type OrderAction int
+
+const (
+	ActionPlace OrderAction = iota
+	ActionCancel
+	ActionAmend
+)
+
+func (a OrderAction) String() string {
+	switch a {
+	case ActionPlace:
+		return "place"
+	case ActionCancel:
+		return "cancel"
+	case ActionAmend:
+		return "amend"
+	default:
+		return "unknown"
+	}
+}
+
+// OrderSource who sent the order
+type OrderSource int
+
+const (
+	SourceUser        OrderSource = iota
+	SourceLiquidation             // liquidation engine, never limited
+)
+
+// ========================================================
+
+// RateLimit token bucket setting: Rate tokens per second, up to Burst
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// TierLimits limits of every action for one user tier
+type TierLimits map[OrderAction]RateLimit
+
+// DefaultTierName tier of users without an explicit tier
+const DefaultTierName = "default"
+
+// DefaultTierLimits cancels get more budget than placements
+var DefaultTierLimits = TierLimits{
+	ActionPlace:  {Rate: 10, Burst: 20},
+	ActionCancel: {Rate: 50, Burst: 100},
+	ActionAmend:  {Rate: 10, Burst: 20},
+}
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError rejection with the time until the next token is available
+type RateLimitError struct {
+	UserID     string
+	Action     OrderAction
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: user %s %s, retry after %s", ErrRateLimited, e.UserID, e.Action, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// ========================================================
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refill by elapsed time then consume one token, return wait time if empty
+func (b *tokenBucket) take(now time.Time, limit RateLimit) (time.Duration, bool) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(limit.Burst, b.tokens+max(elapsed, 0)*limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if limit.Rate <= 0 {
+		return time.Duration(math.MaxInt64), false
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return time.Duration(math.Ceil(wait * float64(time.Second))), false
+}
+
+// RateLimiter (限流) per user token buckets for place / cancel / amend
+type RateLimiter struct {
+	tiers    map[string]TierLimits                   // tier name -> limits
+	userTier map[string]string                       // userID -> tier name
+	buckets  map[string]map[OrderAction]*tokenBucket // userID -> action -> bucket
+	clock    common.Clock
+	mu       sync.Mutex
+}
+
+// NewRateLimiter defaultLimits nil means DefaultTierLimits
+func NewRateLimiter(clock common.Clock, defaultLimits TierLimits) *RateLimiter {
+	if clock == nil {
+		clock = common.SystemClock{}
+	}
+	if defaultLimits == nil {
+		defaultLimits = DefaultTierLimits
+	}
+	return &RateLimiter{
+		tiers:    map[string]TierLimits{DefaultTierName: defaultLimits},
+		userTier: make(map[string]string),
+		buckets:  make(map[string]map[OrderAction]*tokenBucket),
+		clock:    clock,
+	}
+}
+
+// SetTierLimits add or replace a tier, takes effect on the next request
+func (rl *RateLimiter) SetTierLimits(tier string, limits TierLimits) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.tiers[tier] = limits
+}
+
+// SetUserTier move user to a registered tier
+func (rl *RateLimiter) SetUserTier(userID, tier string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if _, exists := rl.tiers[tier]; !exists {
+		return fmt.Errorf("rate limit tier %s not found", tier)
+	}
+	rl.userTier[userID] = tier
+	return nil
+}
+
+// Allow consume one token of the action, return *RateLimitError (ErrRateLimited) if empty
+func (rl *RateLimiter) Allow(userID string, action OrderAction, source OrderSource) error {
+	if source == SourceLiquidation {
+		return nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	tier, exists := rl.userTier[userID]
+	if !exists {
+		tier = DefaultTierName
+	}
+	limit, exists := rl.tiers[tier][action]
+	if !exists {
+		// action not limited in this tier
+		return nil
+	}
+
+	now := rl.clock.Now()
+	userBuckets, exists := rl.buckets[userID]
+	if !exists {
+		userBuckets = make(map[OrderAction]*tokenBucket)
+		rl.buckets[userID] = userBuckets
+	}
+	bucket, exists := userBuckets[action]
+	if !exists {
+		// new bucket starts full
+		bucket = &tokenBucket{tokens: limit.Burst, last: now}
+		userBuckets[action] = bucket
+	}
+
+	if retryAfter, ok := bucket.take(now, limit); !ok {
+		return &RateLimitError{UserID: userID, Action: action, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Name / Check risk pipeline checker: every order checked takes a placement token of its user
+func (rl *RateLimiter) Name() string { return CheckRateLimit }
+
+func (rl *RateLimiter) Check(req *OrderRequest) error {
+	return rl.Allow(req.UserID, ActionPlace, SourceUser)
+}
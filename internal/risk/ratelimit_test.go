@@ -0,0 +1,119 @@
+package risk
+
+import (
+	"errors"
+	"frizo/futures_engine/internal/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLimits = TierLimits{
+	ActionPlace:  {Rate: 2, Burst: 3},
+	ActionCancel: {Rate: 10, Burst: 6},
+}
+
+// acceptPattern send n requests at the current time, true = accepted
+func acceptPattern(rl *RateLimiter, userID string, action OrderAction, n int) []bool {
+	pattern := make([]bool, n)
+	for i := range pattern {
+		pattern[i] = rl.Allow(userID, action, SourceUser) == nil
+	}
+	return pattern
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	clock := common.NewFakeClock(dmsStart)
+	rl := NewRateLimiter(clock, testLimits)
+
+	assert.Equal(t, []bool{true, true, true, false, false}, acceptPattern(rl, "user1", ActionPlace, 5))
+
+	err := rl.Allow("user1", ActionPlace, SourceUser)
+	require.True(t, errors.Is(err, ErrRateLimited))
+	var rateLimitErr *RateLimitError
+	require.True(t, errors.As(err, &rateLimitErr))
+	assert.Equal(t, 500*time.Millisecond, rateLimitErr.RetryAfter)
+	assert.Equal(t, ActionPlace, rateLimitErr.Action)
+
+	// 0.5s 補一個 token
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, []bool{true, false}, acceptPattern(rl, "user1", ActionPlace, 2))
+
+	// 補滿最多 burst
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, []bool{true, true, true, false}, acceptPattern(rl, "user1", ActionPlace, 4))
+
+	// 其他 user 不受影響
+	assert.Equal(t, []bool{true, true, true, false}, acceptPattern(rl, "user2", ActionPlace, 4))
+}
+
+func TestRateLimiterCancelBudget(t *testing.T) {
+	clock := common.NewFakeClock(dmsStart)
+	rl := NewRateLimiter(clock, testLimits)
+
+	// place 用完不影響 cancel
+	acceptPattern(rl, "user1", ActionPlace, 3)
+	assert.Equal(t, []bool{true, true, true, true, true, true, false}, acceptPattern(rl, "user1", ActionCancel, 7))
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, []bool{true, false}, acceptPattern(rl, "user1", ActionCancel, 2))
+
+	// amend 未設定 -> 不限流
+	assert.Equal(t, []bool{true, true, true, true, true}, acceptPattern(rl, "user1", ActionAmend, 5))
+
+	// 預設設定 cancel 額度大於 place
+	assert.Greater(t, DefaultTierLimits[ActionCancel].Burst, DefaultTierLimits[ActionPlace].Burst)
+	assert.Greater(t, DefaultTierLimits[ActionCancel].Rate, DefaultTierLimits[ActionPlace].Rate)
+}
+
+func TestRateLimiterLiquidationBypass(t *testing.T) {
+	clock := common.NewFakeClock(dmsStart)
+	rl := NewRateLimiter(clock, testLimits)
+
+	acceptPattern(rl, "user1", ActionPlace, 3)
+	assert.Error(t, rl.Allow("user1", ActionPlace, SourceUser))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, rl.Allow("user1", ActionPlace, SourceLiquidation))
+	}
+}
+
+func TestRateLimiterTiers(t *testing.T) {
+	clock := common.NewFakeClock(dmsStart)
+	rl := NewRateLimiter(clock, testLimits)
+
+	assert.Error(t, rl.SetUserTier("mm1", "vip"), "tier not registered")
+
+	rl.SetTierLimits("vip", TierLimits{ActionPlace: {Rate: 100, Burst: 5}})
+	require.NoError(t, rl.SetUserTier("mm1", "vip"))
+
+	// 新 bucket 以 vip burst 開始
+	assert.Equal(t, []bool{true, true, true, true, true, false}, acceptPattern(rl, "mm1", ActionPlace, 6))
+	clock.Advance(10 * time.Millisecond)
+	assert.Equal(t, []bool{true, false}, acceptPattern(rl, "mm1", ActionPlace, 2))
+
+	// runtime 調降
+	rl.SetTierLimits("vip", TierLimits{ActionPlace: {Rate: 1, Burst: 1}})
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, []bool{true, false}, acceptPattern(rl, "mm1", ActionPlace, 2))
+}
+
+func TestRateLimiterChecker(t *testing.T) {
+	clock := common.NewFakeClock(dmsStart)
+	rl := NewRateLimiter(clock, testLimits)
+	pipeline := NewRiskPipeline(rl)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pipeline.Check(newOrder(100, 1, 10)))
+	}
+	err := pipeline.Check(newOrder(100, 1, 10))
+	var rejection *Rejection
+	require.ErrorAs(t, err, &rejection)
+	assert.Equal(t, CheckRateLimit, rejection.Check)
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	// the checks took the placement budget, cancels keep their own
+	assert.Error(t, rl.Allow("user1", ActionPlace, SourceUser))
+	assert.NoError(t, rl.Allow("user1", ActionCancel, SourceUser))
+}
@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationMetadata metadata key of the API key, "Bearer <key>" or the bare key
+const AuthorizationMetadata = "authorization"
+
+// RequestIDMetadata response header of the id given to every call, see common.WithRequestID
+const RequestIDMetadata = "x-request-id"
+
+type userIDKey struct{}
+
+// UserIDFromContext user of the API key of the call, set by the auth interceptors
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey{}).(string)
+	return userID, ok
+}
+
+// authenticate resolve the caller's API key against keys, the context of the call carries the user and a
+// new request id
+func authenticate(ctx context.Context, keys api.APIKeyStore) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(AuthorizationMetadata)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing api key")
+	}
+	key := strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+	userID, ok := keys.LookupAPIKey(key)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	ctx = common.WithRequestID(common.WithUserID(ctx, userID), common.GenerateShortUUID("req"))
+	return context.WithValue(ctx, userIDKey{}, userID), nil
+}
+
+func unaryAuth(keys api.APIKeyStore) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, common.RequestIDFrom(ctx)))
+		return handler(ctx, req)
+	}
+}
+
+func streamAuth(keys api.APIKeyStore) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), keys)
+		if err != nil {
+			return err
+		}
+		_ = stream.SetHeader(metadata.Pairs(RequestIDMetadata, common.RequestIDFrom(ctx)))
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticatedStream stream whose context carries the user id
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/rpc/enginepb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// orderSideFromProto side of api.PlaceOrderRequest
+func orderSideFromProto(side enginepb.Side) (string, error) {
+	switch side {
+	case enginepb.Side_SIDE_BUY:
+		return "buy", nil
+	case enginepb.Side_SIDE_SELL:
+		return "sell", nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "side must be buy or sell, got %s", side)
+	}
+}
+
+func orderSideToProto(side orderbook.Side) enginepb.Side {
+	if side == orderbook.SELL {
+		return enginepb.Side_SIDE_SELL
+	}
+	return enginepb.Side_SIDE_BUY
+}
+
+func positionSideFromProto(side enginepb.PositionSide) (position.PositionSide, error) {
+	switch side {
+	case enginepb.PositionSide_POSITION_SIDE_LONG:
+		return position.LONG, nil
+	case enginepb.PositionSide_POSITION_SIDE_SHORT:
+		return position.SHORT, nil
+	default:
+		return 0, status.Errorf(codes.InvalidArgument, "side must be long or short, got %s", side)
+	}
+}
+
+func positionSideToProto(side position.PositionSide) enginepb.PositionSide {
+	if side == position.SHORT {
+		return enginepb.PositionSide_POSITION_SIDE_SHORT
+	}
+	return enginepb.PositionSide_POSITION_SIDE_LONG
+}
+
+// orderToProto symbol is not part of orderbook.Order, empty when unknown
+func orderToProto(symbol string, order orderbook.Order) *enginepb.Order {
+	return &enginepb.Order{
+		Id:         order.ID,
+		UserId:     order.UserID,
+		Symbol:     symbol,
+		Side:       orderSideToProto(order.Side),
+		Price:      order.Price,
+		Size:       order.Size,
+		ReduceOnly: order.ReduceOnly,
+		Timestamp:  timestamppb.New(order.Timestamp),
+	}
+}
+
+func placeOrderResponseToProto(symbol string, resp api.PlaceOrderResponse) *enginepb.PlaceOrderResponse {
+	trades := make([]*enginepb.Trade, 0, len(resp.Trades))
+	for _, trade := range resp.Trades {
+		trades = append(trades, &enginepb.Trade{
+			Price:         trade.Price,
+			Size:          trade.Size,
+			BuyOrderId:    trade.BuyOrderID,
+			SellOrderId:   trade.SellOrderID,
+			BuyRemaining:  trade.BuyRemaining,
+			SellRemaining: trade.SellRemaining,
+		})
+	}
+	return &enginepb.PlaceOrderResponse{Order: orderToProto(symbol, resp.Order), Trades: trades}
+}
+
+func positionToProto(snapshot position.PositionSnapshot) *enginepb.Position {
+	return &enginepb.Position{
+		Id:                snapshot.ID,
+		UserId:            snapshot.UserID,
+		Symbol:            snapshot.Symbol,
+		Side:              positionSideToProto(snapshot.Side),
+		Status:            snapshot.Status.String(),
+		Size:              snapshot.Size,
+		EntryPrice:        snapshot.EntryPrice,
+		MarkPrice:         snapshot.MarkPrice,
+		LiquidationPrice:  snapshot.LiquidationPrice,
+		InitialMargin:     snapshot.InitialMargin,
+		MaintenanceMargin: snapshot.MaintenanceMargin,
+		Leverage:          int32(snapshot.Leverage),
+		MarginMode:        snapshot.MarginMode.String(),
+		RealizedPnl:       snapshot.RealizedPnL,
+		UnrealizedPnl:     snapshot.UnrealizedPnL,
+		UpdateTime:        timestamppb.New(snapshot.UpdateTime),
+	}
+}
+
+func accountToProto(snapshot margin.AccountSnapshot) *enginepb.Account {
+	return &enginepb.Account{
+		UserId:           snapshot.UserID,
+		Status:           snapshot.Status.String(),
+		Balance:          snapshot.Balance,
+		AvailableBalance: snapshot.AvailableBalance,
+		FrozenBalance:    snapshot.FrozenBalance,
+		PositionMargin:   snapshot.PositionMargin,
+		OrderMargin:      snapshot.OrderMargin,
+		UnrealizedPnl:    snapshot.UnrealizedPnL,
+		RealizedPnl:      snapshot.RealizedPnL,
+		UpdatedAt:        timestamppb.New(snapshot.UpdatedAt),
+	}
+}
@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/rpc/enginepb"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server (gRPC) enginepb.Engine on the engine core of the REST server: same order books, margin
+// reservations and event stream. every call is authenticated by an API key of keys
+type Server struct {
+	enginepb.UnimplementedEngineServer
+
+	engine *engine.FuturesEngine
+	rest   *api.Server
+	grpc   *grpc.Server
+}
+
+func NewServer(e *engine.FuturesEngine, rest *api.Server, keys api.APIKeyStore) *Server {
+	s := &Server{
+		engine: e,
+		rest:   rest,
+		grpc: grpc.NewServer(
+			grpc.ChainUnaryInterceptor(unaryAuth(keys)),
+			grpc.ChainStreamInterceptor(streamAuth(keys)),
+		),
+	}
+	enginepb.RegisterEngineServer(s.grpc, s)
+	return s
+}
+
+// Serve accept connections on lis until Stop / GracefulStop
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+// GracefulStop stop accepting calls and wait for the running ones, open event streams included
+func (s *Server) GracefulStop() {
+	s.grpc.GracefulStop()
+}
+
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
+
+func (s *Server) PlaceOrder(ctx context.Context, req *enginepb.PlaceOrderRequest) (*enginepb.PlaceOrderResponse, error) {
+	userID, _ := UserIDFromContext(ctx)
+	side, err := orderSideFromProto(req.GetSide())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.rest.PlaceOrder(ctx, api.PlaceOrderRequest{
+		UserID:     userID,
+		Symbol:     req.GetSymbol(),
+		Side:       side,
+		Price:      req.GetPrice(),
+		Size:       req.GetSize(),
+		Leverage:   int16(req.GetLeverage()),
+		ReduceOnly: req.GetReduceOnly(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return placeOrderResponseToProto(req.GetSymbol(), resp), nil
+}
+
+func (s *Server) CancelOrder(ctx context.Context, req *enginepb.CancelOrderRequest) (*enginepb.Order, error) {
+	userID, _ := UserIDFromContext(ctx)
+	order, err := s.rest.CancelOrder(ctx, userID, req.GetOrderId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return orderToProto("", *order), nil
+}
+
+func (s *Server) GetPosition(ctx context.Context, req *enginepb.GetPositionRequest) (*enginepb.Position, error) {
+	userID, _ := UserIDFromContext(ctx)
+	side, err := positionSideFromProto(req.GetSide())
+	if err != nil {
+		return nil, err
+	}
+	pos, err := s.engine.PositionManager().GetPosition(userID, req.GetSymbol(), side)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	snapshot := pos.Snapshot()
+	// in one-way mode the position of the symbol answers for both sides
+	if snapshot.Side != side || snapshot.Status == position.PositionClosed || snapshot.Size <= 0 {
+		return nil, status.Errorf(codes.NotFound, "no open %s position in %s", side, req.GetSymbol())
+	}
+	return positionToProto(snapshot), nil
+}
+
+func (s *Server) GetAccount(ctx context.Context, _ *enginepb.GetAccountRequest) (*enginepb.Account, error) {
+	userID, _ := UserIDFromContext(ctx)
+	account, err := s.engine.MarginSystem().GetAccount(userID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return accountToProto(account.Snapshot()), nil
+}
+
+// StreamEvents the caller's private channels and the public channels of every requested symbol, until the
+// client cancels. the response headers are sent once subscribed; a client too slow for the feed is dropped
+// with ResourceExhausted
+func (s *Server) StreamEvents(req *enginepb.StreamEventsRequest, stream enginepb.Engine_StreamEventsServer) error {
+	userID, _ := UserIDFromContext(stream.Context())
+	var requests []api.StreamRequest
+	for _, channel := range req.GetChannels() {
+		switch channel {
+		case api.ChannelTicker, api.ChannelDepth:
+			for _, symbol := range req.GetSymbols() {
+				requests = append(requests, api.StreamRequest{Channel: channel, Symbol: symbol})
+			}
+		default:
+			requests = append(requests, api.StreamRequest{Channel: channel, UserID: userID})
+		}
+	}
+	if len(requests) == 0 {
+		return status.Error(codes.InvalidArgument, "no channel to stream")
+	}
+
+	sub, err := s.rest.Subscribe(requests...)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer sub.Close()
+	// headers mark the subscription in place: updates after them are not missed
+	if err = stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case payload := <-sub.C():
+			event, err := eventFromStream(payload)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err = stream.Send(event); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			return status.Error(codes.ResourceExhausted, "event stream closed: too slow or server shutting down")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// eventFromStream Event of a websocket stream update
+func eventFromStream(payload []byte) (*enginepb.Event, error) {
+	var message struct {
+		Channel  string          `json:"channel"`
+		UserID   string          `json:"user_id"`
+		Symbol   string          `json:"symbol"`
+		Sequence uint64          `json:"sequence"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, err
+	}
+	return &enginepb.Event{
+		Sequence: message.Sequence,
+		Channel:  message.Channel,
+		UserId:   message.UserID,
+		Symbol:   message.Symbol,
+		Data:     message.Data,
+	}, nil
+}
+
+// toStatus gRPC status of err, following the HTTP status the REST layer gives it
+func toStatus(err error) error {
+	if errors.Is(err, margin.ErrInsufficientMargin) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	switch api.StatusCode(err) {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/rpc/enginepb"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T) (*engine.FuturesEngine, enginepb.EngineClient) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT", "ETHUSDT"}})
+	require.NoError(t, err)
+	keys := api.NewMemoryAPIKeyStore()
+	for _, userID := range []string{"alice", "bob"} {
+		_, err = e.MarginSystem().CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, e.MarginSystem().Deposit(userID, 10_000))
+		require.NoError(t, keys.Add(userID+"-key", userID))
+	}
+	require.NoError(t, e.Start(context.Background()))
+
+	rest := api.NewServer(e, "", nil, keys)
+	server := NewServer(e, rest, keys)
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = server.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+		_ = rest.Shutdown(context.Background())
+		_ = e.Close()
+	})
+	return e, enginepb.NewEngineClient(conn)
+}
+
+// as call context authenticated as userID
+func as(t *testing.T, userID string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return metadata.AppendToOutgoingContext(ctx, AuthorizationMetadata, "Bearer "+userID+"-key")
+}
+
+func TestUnauthenticated(t *testing.T) {
+	_, client := newTestClient(t)
+
+	_, err := client.GetAccount(context.Background(), &enginepb.GetAccountRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AuthorizationMetadata, "Bearer mallory-key")
+	_, err = client.GetAccount(ctx, &enginepb.GetAccountRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.StreamEvents(ctx, &enginepb.StreamEventsRequest{Channels: []string{api.ChannelOrders}})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestOrdersPositionsAndAccount(t *testing.T) {
+	e, client := newTestClient(t)
+
+	stream, err := client.StreamEvents(as(t, "alice"), &enginepb.StreamEventsRequest{Channels: []string{api.ChannelOrders}})
+	require.NoError(t, err)
+	_, err = stream.Header() // subscribed
+	require.NoError(t, err)
+
+	bid, err := client.PlaceOrder(as(t, "alice"), &enginepb.PlaceOrderRequest{
+		Symbol: "BTCUSDT", Side: enginepb.Side_SIDE_BUY, Price: 50000, Size: 0.2, Leverage: 10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", bid.Order.UserId)
+	assert.Equal(t, "BTCUSDT", bid.Order.Symbol)
+	assert.Empty(t, bid.Trades)
+
+	// the events stream is scoped to the caller and sequenced
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, api.ChannelOrders, event.Channel)
+	assert.Equal(t, "alice", event.UserId)
+	var orderEvent orderbook.OrderEvent
+	require.NoError(t, json.Unmarshal(event.Data, &orderEvent))
+	assert.Equal(t, orderbook.OrderAccepted, orderEvent.Type)
+	assert.Equal(t, bid.Order.Id, orderEvent.OrderID)
+	assert.Equal(t, uint64(1), orderEvent.Sequence)
+
+	// bob crosses half of it
+	ask, err := client.PlaceOrder(as(t, "bob"), &enginepb.PlaceOrderRequest{
+		Symbol: "BTCUSDT", Side: enginepb.Side_SIDE_SELL, Price: 50000, Size: 0.1, Leverage: 10,
+	})
+	require.NoError(t, err)
+	require.Len(t, ask.Trades, 1)
+	assert.Equal(t, bid.Order.Id, ask.Trades[0].BuyOrderId)
+	assert.InDelta(t, 0.1, ask.Trades[0].BuyRemaining, 1e-9)
+
+	fill, err := stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(fill.Data, &orderEvent))
+	assert.Equal(t, orderbook.OrderPartiallyFilled, orderEvent.Type)
+	assert.Greater(t, fill.Sequence, event.Sequence)
+
+	// the fill opened alice's long
+	pos, err := client.GetPosition(as(t, "alice"), &enginepb.GetPositionRequest{Symbol: "BTCUSDT", Side: enginepb.PositionSide_POSITION_SIDE_LONG})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", pos.UserId)
+	assert.Equal(t, enginepb.PositionSide_POSITION_SIDE_LONG, pos.Side)
+	assert.InDelta(t, 0.1, pos.Size, 1e-9)
+	assert.Equal(t, int32(10), pos.Leverage)
+	short, err := e.PositionManager().GetPosition("bob", "BTCUSDT", position.SHORT)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.1, short.Snapshot().Size, 1e-9)
+
+	_, err = client.GetPosition(as(t, "alice"), &enginepb.GetPositionRequest{Symbol: "BTCUSDT", Side: enginepb.PositionSide_POSITION_SIDE_SHORT})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetPosition(as(t, "alice"), &enginepb.GetPositionRequest{Symbol: "BTCUSDT"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	account, err := client.GetAccount(as(t, "alice"), &enginepb.GetAccountRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", account.UserId)
+	assert.Greater(t, account.PositionMargin, 0.0)
+	assert.Greater(t, account.OrderMargin, 0.0)
+
+	// only the owner cancels the rest
+	_, err = client.CancelOrder(as(t, "bob"), &enginepb.CancelOrderRequest{OrderId: bid.Order.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	canceled, err := client.CancelOrder(as(t, "alice"), &enginepb.CancelOrderRequest{OrderId: bid.Order.Id})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.1, canceled.Size, 1e-9)
+
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(event.Data, &orderEvent))
+	assert.Equal(t, orderbook.OrderCanceled, orderEvent.Type)
+
+	_, err = client.PlaceOrder(as(t, "alice"), &enginepb.PlaceOrderRequest{Symbol: "DOGEUSDT", Side: enginepb.Side_SIDE_BUY, Price: 1, Size: 1, Leverage: 10})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.PlaceOrder(as(t, "alice"), &enginepb.PlaceOrderRequest{Symbol: "BTCUSDT", Price: 1, Size: 1, Leverage: 10})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
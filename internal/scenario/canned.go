@@ -0,0 +1,47 @@
+package scenario
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// canned scenarios for regression tests and capacity planning
+var canned = map[string]Scenario{
+	// -30% in 5 minutes at 20x average leverage
+	"crash-30pct-5m-20x": {
+		Name:            "crash-30pct-5m-20x",
+		Seed:            1,
+		Symbol:          "BTCUSDT",
+		Users:           1000,
+		EntryPrice:      50000,
+		Leverage:        Range{Min: 10, Max: 30},
+		Notional:        Range{Min: 1000, Max: 50000},
+		LongRatio:       0.6,
+		BalanceMultiple: 1.5,
+		InsuranceFund:   10000,
+		FundingRate:     -0.0005,
+		FundingInterval: time.Minute,
+		Shocks:          []Shock{{Move: -0.30, Ticks: 30, Duration: 5 * time.Minute}},
+	},
+}
+
+// Canned copy of a canned scenario
+func Canned(name string) (Scenario, error) {
+	scenario, exists := canned[name]
+	if !exists {
+		return Scenario{}, fmt.Errorf("unknown scenario %s", name)
+	}
+	scenario.Shocks = append([]Shock(nil), scenario.Shocks...)
+	return scenario, nil
+}
+
+// CannedNames names of the canned scenarios, sorted
+func CannedNames() []string {
+	names := make([]string, 0, len(canned))
+	for name := range canned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
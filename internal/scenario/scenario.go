@@ -0,0 +1,346 @@
+package scenario
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/funding"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/metrics"
+	"frizo/futures_engine/internal/position"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Range uniform distribution over [Min, Max]
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (r Range) sample(rng *rand.Rand) float64 {
+	return r.Min + rng.Float64()*(r.Max-r.Min)
+}
+
+// Shock one scripted price move, spread over Ticks equal (compounded) mark price updates across Duration
+type Shock struct {
+	Move     float64       `json:"move"` // e.g. -0.30 for -30%
+	Ticks    int           `json:"ticks"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Scenario (壓力情境) users with random leverage / size, then a sequence of price shocks
+type Scenario struct {
+	Name   string `json:"name"`
+	Seed   int64  `json:"seed"`
+	Symbol string `json:"symbol"`
+
+	Users           int     `json:"users"`
+	EntryPrice      float64 `json:"entry_price"`
+	Leverage        Range   `json:"leverage"` // rounded to whole leverage
+	Notional        Range   `json:"notional"` // USDT per position
+	LongRatio       float64 `json:"long_ratio"`
+	BalanceMultiple float64 `json:"balance_multiple"` // deposit = initial margin × multiple, at least 1
+
+	InsuranceFund   float64       `json:"insurance_fund"` // starting balance
+	FundingRate     float64       `json:"funding_rate"`   // per interval, 0 disables funding
+	FundingInterval time.Duration `json:"funding_interval"`
+
+	Shocks []Shock `json:"shocks"`
+}
+
+func (s Scenario) Validate() error {
+	if s.Symbol == "" {
+		return fmt.Errorf("scenario symbol is required")
+	}
+	if s.Users <= 0 {
+		return fmt.Errorf("scenario needs at least one user")
+	}
+	if s.EntryPrice <= 0 {
+		return fmt.Errorf("entry price must be greater than zero")
+	}
+	if s.Leverage.Min < 1 || s.Leverage.Max < s.Leverage.Min {
+		return fmt.Errorf("invalid leverage range [%v, %v]", s.Leverage.Min, s.Leverage.Max)
+	}
+	if s.Notional.Min <= 0 || s.Notional.Max < s.Notional.Min {
+		return fmt.Errorf("invalid notional range [%v, %v]", s.Notional.Min, s.Notional.Max)
+	}
+	if s.LongRatio < 0 || s.LongRatio > 1 {
+		return fmt.Errorf("long ratio must be in [0, 1]")
+	}
+	if s.FundingRate != 0 {
+		if err := (funding.FundingConfig{Interval: s.FundingInterval}).Validate(); err != nil {
+			return err
+		}
+	}
+	for i, shock := range s.Shocks {
+		if shock.Ticks <= 0 || shock.Move <= -1 {
+			return fmt.Errorf("invalid shock %d", i)
+		}
+	}
+	return nil
+}
+
+// StepReport state after one mark price update
+type StepReport struct {
+	Elapsed       time.Duration `json:"elapsed"`
+	Price         float64       `json:"price"`
+	Liquidations  int           `json:"liquidations"`
+	InsuranceFund float64       `json:"insurance_fund"`
+}
+
+// Report outcome of a scenario run
+type Report struct {
+	Scenario string `json:"scenario"`
+	Seed     int64  `json:"seed"`
+	Users    int    `json:"users"`
+
+	OpenNotional    float64 `json:"open_notional"`
+	AverageLeverage float64 `json:"average_leverage"`
+	StartPrice      float64 `json:"start_price"`
+	FinalPrice      float64 `json:"final_price"`
+
+	Liquidations       int     `json:"liquidations"`
+	LiquidatedNotional float64 `json:"liquidated_notional"`
+
+	InsuranceFundStart    float64 `json:"insurance_fund_start"`
+	InsuranceFundEnd      float64 `json:"insurance_fund_end"`
+	InsuranceFundDrawdown float64 `json:"insurance_fund_drawdown"` // peak to trough
+	ADLTriggered          int     `json:"adl_triggered"`           // liquidations the fund could not cover
+	ADLCovered            float64 `json:"adl_covered"`
+	UncoveredLoss         float64 `json:"uncovered_loss"` // left after the fund and ADL
+
+	FundingSettlements int     `json:"funding_settlements"`
+	FundingPaid        float64 `json:"funding_paid"`
+
+	WorstAccountEquity float64       `json:"worst_account_equity"`
+	WorstAccountUserID string        `json:"worst_account_user_id"`
+	WorstAccountAt     time.Duration `json:"worst_account_at"`
+
+	Steps []StepReport `json:"steps"`
+}
+
+// scenarioStart fixed clock origin, aligned to funding boundaries
+var scenarioStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ScenarioRunner (壓力測試) runs a scenario against a fresh position manager, margin system,
+// liquidation engine and funding scheduler. the same scenario and seed always give the same report.
+type ScenarioRunner struct {
+	scenario Scenario
+
+	clock     *common.FakeClock
+	pm        *position.PositionManager
+	ms        *margin.MarginSystem
+	engine    *liquidation.LiquidationEngine
+	scheduler *funding.FundingScheduler
+	users     []string
+	report    Report
+	fundPeak  float64
+	metrics   metrics.Registry
+}
+
+func NewScenarioRunner(scenario Scenario) (*ScenarioRunner, error) {
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	scenario.Shocks = append([]Shock(nil), scenario.Shocks...)
+	return &ScenarioRunner{scenario: scenario}, nil
+}
+
+// SetMetrics registry the liquidation engine and funding scheduler of later runs report to
+func (r *ScenarioRunner) SetMetrics(registry metrics.Registry) {
+	r.metrics = registry
+}
+
+// fixedRate funding rate of every interval
+type fixedRate float64
+
+func (r fixedRate) FundingRate(string, time.Time) (float64, error) {
+	return float64(r), nil
+}
+
+// Run set up the users, apply every shock and report. each Run starts from scratch
+func (r *ScenarioRunner) Run() (Report, error) {
+	s := r.scenario
+	if err := r.setup(); err != nil {
+		return Report{}, err
+	}
+	defer r.pm.Close()
+
+	price := s.EntryPrice
+	if _, err := r.pm.UpdateMarkPrices(s.Symbol, price); err != nil {
+		return Report{}, err
+	}
+	r.scheduler.RunDue()
+	r.sample(0, price, 0)
+
+	elapsed := time.Duration(0)
+	for _, shock := range s.Shocks {
+		factor := math.Pow(1+shock.Move, 1/float64(shock.Ticks))
+		for tick := 0; tick < shock.Ticks; tick++ {
+			price *= factor
+			elapsed += shock.Duration / time.Duration(shock.Ticks)
+			r.clock.Set(scenarioStart.Add(elapsed))
+
+			if _, err := r.pm.UpdateMarkPrices(s.Symbol, price); err != nil {
+				return Report{}, err
+			}
+			liquidations := r.liquidate()
+			r.settleFunding()
+			r.sample(elapsed, price, liquidations)
+		}
+	}
+
+	r.report.FinalPrice = price
+	r.report.InsuranceFundEnd = r.ms.InsuranceFund().Balance()
+	return r.report, nil
+}
+
+// setup fresh system with the scenario's users, all in at the entry price
+func (r *ScenarioRunner) setup() error {
+	s := r.scenario
+	rng := rand.New(rand.NewSource(s.Seed))
+
+	r.clock = common.NewFakeClock(scenarioStart)
+	r.pm = position.NewPositionManager([]string{s.Symbol})
+	r.ms = margin.NewMarginSystem(r.pm, nil)
+	r.engine = liquidation.NewLiquidationEngine(r.pm, r.ms, &liquidation.Config{Workers: 1, MarkPriceFallback: true})
+	r.engine.SetMetrics(r.metrics)
+
+	// without a funding rate the scheduler has no symbol to settle
+	interval, symbols := funding.DefaultFundingConfig.Interval, func() []string { return nil }
+	if s.FundingRate != 0 {
+		interval, symbols = s.FundingInterval, func() []string { return []string{s.Symbol} }
+	}
+	registry, err := funding.NewConfigRegistry(r.clock, &funding.FundingConfig{
+		Interval:  interval,
+		RateCap:   math.Abs(s.FundingRate),
+		RateFloor: -math.Abs(s.FundingRate),
+	})
+	if err != nil {
+		return err
+	}
+	r.scheduler = funding.NewFundingScheduler(r.clock, registry, fixedRate(s.FundingRate), r.ms, symbols, nil, nil)
+	r.scheduler.SetMetrics(r.metrics)
+
+	r.report = Report{
+		Scenario:           s.Name,
+		Seed:               s.Seed,
+		Users:              s.Users,
+		StartPrice:         s.EntryPrice,
+		InsuranceFundStart: s.InsuranceFund,
+		WorstAccountEquity: math.Inf(1),
+	}
+	if s.InsuranceFund > 0 {
+		if err = r.ms.InsuranceFund().Deposit("scenario", s.InsuranceFund); err != nil {
+			r.pm.Close()
+			return err
+		}
+	}
+	r.fundPeak = s.InsuranceFund
+
+	r.users = make([]string, 0, s.Users)
+	leverageSum := 0.0
+	for i := 0; i < s.Users; i++ {
+		userID := fmt.Sprintf("user%06d", i)
+		leverage := uint(math.Round(s.Leverage.sample(rng)))
+		notional := s.Notional.sample(rng)
+		side := position.SHORT
+		if rng.Float64() < s.LongRatio {
+			side = position.LONG
+		}
+
+		if err = r.open(userID, side, notional, leverage); err != nil {
+			r.pm.Close()
+			return fmt.Errorf("open %s: %w", userID, err)
+		}
+		r.users = append(r.users, userID)
+		r.report.OpenNotional += notional
+		leverageSum += float64(leverage)
+	}
+	r.report.AverageLeverage = leverageSum / float64(s.Users)
+	return nil
+}
+
+func (r *ScenarioRunner) open(userID string, side position.PositionSide, notional float64, leverage uint) error {
+	s := r.scenario
+	if _, err := r.ms.CreateAccount(userID); err != nil {
+		return err
+	}
+	if err := r.ms.Deposit(userID, notional/float64(leverage)*max(s.BalanceMultiple, 1)); err != nil {
+		return err
+	}
+	if _, err := r.pm.OpenPosition(common.ISOLATED, userID, s.Symbol, side, s.EntryPrice, notional/s.EntryPrice, leverage); err != nil {
+		return err
+	}
+	return r.ms.UpdatePositionMargin(userID)
+}
+
+// liquidate one candidate at a time in user order, so the fund and ADL queue are drawn in a
+// reproducible order
+func (r *ScenarioRunner) liquidate() int {
+	candidates := r.pm.GetAllLiquidatablePositions()[r.scenario.Symbol]
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].UserID < candidates[j].UserID
+	})
+
+	count := 0
+	for _, candidate := range candidates {
+		batch := map[string][]*position.LiquidationCandidate{r.scenario.Symbol: {candidate}}
+		for _, result := range r.engine.ProcessAllLiquidations(batch)[r.scenario.Symbol] {
+			if result.Size <= 0 {
+				continue
+			}
+			count++
+			r.report.LiquidatedNotional += result.ClosePrice * result.Size
+			r.report.UncoveredLoss += result.Settlement.Uncovered
+			if result.ADL != nil {
+				r.report.ADLTriggered++
+				r.report.ADLCovered += result.ADL.Covered
+				r.report.UncoveredLoss -= result.ADL.Covered
+			}
+		}
+	}
+	r.report.Liquidations += count
+	return count
+}
+
+func (r *ScenarioRunner) settleFunding() {
+	for _, entry := range r.scheduler.RunDue() {
+		if entry.Error == "" && !entry.Skipped {
+			r.report.FundingSettlements++
+			r.report.FundingPaid += entry.TotalPaid
+		}
+	}
+}
+
+// sample insurance fund drawdown and worst account equity after a step
+func (r *ScenarioRunner) sample(elapsed time.Duration, price float64, liquidations int) {
+	fund := r.ms.InsuranceFund().Balance()
+	r.fundPeak = max(r.fundPeak, fund)
+	r.report.InsuranceFundDrawdown = max(r.report.InsuranceFundDrawdown, r.fundPeak-fund)
+
+	for _, userID := range r.users {
+		if err := r.ms.UpdatePositionMargin(userID); err != nil {
+			continue
+		}
+		account, err := r.ms.GetAccount(userID)
+		if err != nil {
+			continue
+		}
+		if equity := account.GetAccountEquity(); equity < r.report.WorstAccountEquity {
+			r.report.WorstAccountEquity = equity
+			r.report.WorstAccountUserID = userID
+			r.report.WorstAccountAt = elapsed
+		}
+	}
+
+	r.report.Steps = append(r.report.Steps, StepReport{
+		Elapsed:       elapsed,
+		Price:         price,
+		Liquidations:  liquidations,
+		InsuranceFund: fund,
+	})
+}
@@ -0,0 +1,107 @@
+package scenario
+
+import (
+	"frizo/futures_engine/internal/funding"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCrashScenario regression of the canned -30% in 5 minutes at 20x average leverage
+func TestCrashScenario(t *testing.T) {
+	scenario, err := Canned("crash-30pct-5m-20x")
+	require.NoError(t, err)
+	runner, err := NewScenarioRunner(scenario)
+	require.NoError(t, err)
+
+	report, err := runner.Run()
+	require.NoError(t, err)
+
+	assert.InDelta(t, 20.0, report.AverageLeverage, 0.5)
+	assert.InDelta(t, 35000.0, report.FinalPrice, 1e-6)
+	require.Len(t, report.Steps, 31)
+	assert.Equal(t, 5*time.Minute, report.Steps[30].Elapsed)
+
+	// every long is at least 10x and gets wiped out by -30%, shorts survive
+	assert.Equal(t, 594, report.Liquidations)
+	assert.InDelta(t, 14053555.72, report.LiquidatedNotional, 0.01)
+	assert.InDelta(t, 13311.76, report.InsuranceFundDrawdown, 0.01)
+	assert.InDelta(t, 238.26, report.InsuranceFundEnd, 0.01)
+	assert.Equal(t, 56, report.ADLTriggered)
+	assert.InDelta(t, 6449.71, report.ADLCovered, 0.01)
+	assert.Zero(t, report.UncoveredLoss)
+	assert.Equal(t, 5, report.FundingSettlements)
+	assert.Equal(t, "user000155", report.WorstAccountUserID)
+
+	// reproducible: same seed, same report
+	again, err := runner.Run()
+	require.NoError(t, err)
+	assert.Equal(t, report, again)
+
+	scenario.Seed = 2
+	other, err := NewScenarioRunner(scenario)
+	require.NoError(t, err)
+	otherReport, err := other.Run()
+	require.NoError(t, err)
+	assert.NotEqual(t, report.OpenNotional, otherReport.OpenNotional)
+}
+
+func TestCrashScenarioMetrics(t *testing.T) {
+	scenario, err := Canned("crash-30pct-5m-20x")
+	require.NoError(t, err)
+	runner, err := NewScenarioRunner(scenario)
+	require.NoError(t, err)
+	recorder := metrics.NewRecorder()
+	runner.SetMetrics(recorder)
+
+	report, err := runner.Run()
+	require.NoError(t, err)
+
+	btc := metrics.Labels{"symbol": "BTCUSDT"}
+	assert.Equal(t, float64(report.Liquidations), recorder.Counter(liquidation.MetricLiquidations, btc))
+	assert.InDelta(t, report.LiquidatedNotional, recorder.Counter(liquidation.MetricLiquidatedNotional, btc), 1e-3)
+	assert.Zero(t, recorder.Counter(liquidation.MetricLiquidationFailures, btc))
+	assert.GreaterOrEqual(t, recorder.Counter(liquidation.MetricADLEvents, btc), float64(report.ADLTriggered))
+	latencies := recorder.Observations(liquidation.MetricLiquidationLatency, btc)
+	assert.Len(t, latencies, report.Liquidations)
+	for _, latency := range latencies {
+		assert.GreaterOrEqual(t, latency, 0.0)
+	}
+	fund, ok := recorder.Gauge(liquidation.MetricInsuranceFundBalance, nil)
+	assert.True(t, ok)
+	assert.InDelta(t, report.InsuranceFundEnd, fund, 1e-9)
+
+	assert.Equal(t, float64(report.FundingSettlements), recorder.Counter(funding.MetricFundingSettlements, btc))
+	assert.InDelta(t, report.FundingPaid, recorder.Counter(funding.MetricFundingPaid, btc), 1e-9)
+	rate, ok := recorder.Gauge(funding.MetricFundingRate, btc)
+	assert.True(t, ok)
+	assert.Equal(t, -0.0005, rate)
+}
+
+func TestScenarioValidate(t *testing.T) {
+	scenario, err := Canned("crash-30pct-5m-20x")
+	require.NoError(t, err)
+
+	broken := scenario
+	broken.Users = 0
+	_, err = NewScenarioRunner(broken)
+	assert.Error(t, err)
+
+	broken = scenario
+	broken.FundingInterval = 7 * time.Hour
+	_, err = NewScenarioRunner(broken)
+	assert.Error(t, err)
+
+	broken = scenario
+	broken.Shocks = []Shock{{Move: -1, Ticks: 1}}
+	_, err = NewScenarioRunner(broken)
+	assert.Error(t, err)
+
+	_, err = Canned("unknown")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"crash-30pct-5m-20x"}, CannedNames())
+}
@@ -0,0 +1,103 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"frizo/futures_engine/internal/logger"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout deadline of the whole sequence when NewSequence is given 0
+const DefaultTimeout = 30 * time.Second
+
+// step one named stage of the shutdown
+type step struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// StepError a step that returned an error, the later steps still ran
+type StepError struct {
+	Step string
+	Err  error
+}
+
+// Report outcome of Run, steps in sequence order
+type Report struct {
+	Completed  []string
+	Failed     []StepError
+	Unfinished []string // the step running at the deadline and the ones after it
+	Elapsed    time.Duration
+}
+
+// Complete every step ran before the deadline, failed ones included
+func (r *Report) Complete() bool {
+	return len(r.Unfinished) == 0
+}
+
+func (r *Report) String() string {
+	failed := make([]string, len(r.Failed))
+	for i, f := range r.Failed {
+		failed[i] = f.Step + ": " + f.Err.Error()
+	}
+	return fmt.Sprintf("completed [%s] failed [%s] unfinished [%s] in %s", strings.Join(r.Completed, ", "),
+		strings.Join(failed, "; "), strings.Join(r.Unfinished, ", "), r.Elapsed.Round(time.Millisecond))
+}
+
+// Sequence (關機流程) ordered shutdown: the steps run one after the other, each given the context of the
+// whole sequence, e.g. stop taking requests, drain, stop the schedulers, flush, snapshot, close. a failing
+// step does not stop the ones after it, they may still save state; at the deadline Run returns without
+// waiting for the running step, the caller then exits with the report
+type Sequence struct {
+	timeout time.Duration
+	steps   []step
+}
+
+// NewSequence timeout 0 means DefaultTimeout
+func NewSequence(timeout time.Duration) *Sequence {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Sequence{timeout: timeout}
+}
+
+// Add run fn after the steps added before it. fn should return once ctx is done
+func (s *Sequence) Add(name string, fn func(ctx context.Context) error) {
+	s.steps = append(s.steps, step{name: name, run: fn})
+}
+
+// Run the steps in order until the last one returns or the deadline passes
+func (s *Sequence) Run(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	log := logger.Default()
+	start := time.Now()
+	report := &Report{}
+	for i, st := range s.steps {
+		stepStart := time.Now()
+		result := make(chan error, 1)
+		go func() { result <- st.run(ctx) }()
+
+		select {
+		case err := <-result:
+			if err != nil {
+				report.Failed = append(report.Failed, StepError{Step: st.name, Err: err})
+				log.Error("Shutdown step failed", "step", st.name, "error", err)
+				continue
+			}
+			report.Completed = append(report.Completed, st.name)
+			log.Debug("Shutdown step completed", "step", st.name, "elapsed", time.Since(stepStart))
+		case <-ctx.Done():
+			for _, rest := range s.steps[i:] {
+				report.Unfinished = append(report.Unfinished, rest.name)
+			}
+			report.Elapsed = time.Since(start)
+			log.Error("Shutdown deadline passed", "timeout", s.timeout, "running", st.name, "unfinished", report.Unfinished)
+			return report
+		}
+	}
+	report.Elapsed = time.Since(start)
+	return report
+}
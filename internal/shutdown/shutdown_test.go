@@ -0,0 +1,220 @@
+package shutdown
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/events"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/snapshot"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequenceRunsInOrder(t *testing.T) {
+	var order []string
+	sequence := NewSequence(0)
+	for _, name := range []string{"api server", "flush", "snapshot"} {
+		sequence.Add(name, func(context.Context) error {
+			order = append(order, name)
+			if name == "flush" {
+				return errors.New("store unreachable")
+			}
+			return nil
+		})
+	}
+
+	report := sequence.Run(context.Background())
+	assert.Equal(t, []string{"api server", "flush", "snapshot"}, order, "a failed step does not stop the later ones")
+	assert.True(t, report.Complete())
+	assert.Equal(t, []string{"api server", "snapshot"}, report.Completed)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, "flush", report.Failed[0].Step)
+}
+
+func TestSequenceDeadline(t *testing.T) {
+	wedged := make(chan struct{})
+	defer close(wedged)
+	sequence := NewSequence(30 * time.Millisecond)
+	sequence.Add("api server", func(context.Context) error { return nil })
+	sequence.Add("event publishing", func(context.Context) error {
+		<-wedged // ignores the deadline
+		return nil
+	})
+	sequence.Add("engine", func(context.Context) error { return nil })
+
+	start := time.Now()
+	report := sequence.Run(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.False(t, report.Complete())
+	assert.Equal(t, []string{"api server"}, report.Completed)
+	assert.Equal(t, []string{"event publishing", "engine"}, report.Unfinished)
+	assert.Contains(t, report.String(), "unfinished [event publishing, engine]")
+}
+
+// recordingPublisher acknowledges and keeps every message
+type recordingPublisher struct {
+	mu        sync.Mutex
+	delivered []events.Message
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, messages []events.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.delivered = append(p.delivered, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+// placeOrders post random orders of userID, with its API key userID+"-key", until the server stops
+// answering, return the accepted order ids
+func placeOrders(url, userID string, seed int64) []string {
+	rng := rand.New(rand.NewSource(seed))
+	var accepted []string
+	for {
+		side := "buy"
+		if rng.Intn(2) == 0 {
+			side = "sell"
+		}
+		body, _ := json.Marshal(api.PlaceOrderRequest{Symbol: "BTCUSDT", Side: side,
+			Price: float64(49900 + rng.Intn(200)), Size: 0.1, Leverage: 10})
+		req, _ := http.NewRequest(http.MethodPost, url+"/orders", bytes.NewReader(body))
+		req.Header.Set(api.AuthorizationHeader, "Bearer "+userID+"-key")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return accepted
+		}
+		var placed api.PlaceOrderResponse
+		if resp.StatusCode == http.StatusCreated && json.NewDecoder(resp.Body).Decode(&placed) == nil {
+			accepted = append(accepted, placed.Order.ID)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			return accepted
+		}
+	}
+}
+
+func TestShutdownBusyEngine(t *testing.T) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: []string{"BTCUSDT"}})
+	require.NoError(t, err)
+	users := []string{"alice", "bob", "carol", "dave"}
+	keys := api.NewMemoryAPIKeyStore()
+	for _, userID := range users {
+		_, err = e.MarginSystem().CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, e.MarginSystem().Deposit(userID, 1_000_000))
+		require.NoError(t, keys.Add(userID+"-key", userID))
+	}
+	ctx := context.Background()
+	require.NoError(t, e.Start(ctx))
+
+	server := api.NewServer(e, "", nil, keys)
+	dir := t.TempDir()
+	manager, err := snapshot.NewSnapshotManager(e, server, &snapshot.Config{Dir: dir})
+	require.NoError(t, err)
+	publisher := &recordingPublisher{}
+	forwarder := events.NewForwarder(publisher, nil)
+	var emitted atomic.Int64
+	server.OnOrderEvent(func(event orderbook.OrderEvent) {
+		emitted.Add(1)
+		forwarder.PublishOrderEvent(event)
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = server.Serve(lis) }()
+	url := "http://" + lis.Addr().String()
+
+	var traders sync.WaitGroup
+	accepted := make([][]string, len(users))
+	for i, userID := range users {
+		traders.Add(1)
+		go func() {
+			defer traders.Done()
+			accepted[i] = placeOrders(url, userID, int64(i))
+		}()
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	time.Sleep(200 * time.Millisecond)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	<-quit
+
+	var drained *snapshot.Snapshot
+	sequence := NewSequence(5 * time.Second)
+	sequence.Add("api server", server.Shutdown)
+	sequence.Add("drained", func(context.Context) error {
+		drained = manager.Capture() // the in-memory state once the in-flight requests are done
+		return nil
+	})
+	sequence.Add("shutdown snapshot", func(context.Context) error {
+		_, err := manager.Save()
+		return err
+	})
+	sequence.Add("engine", func(context.Context) error { return e.Close() })
+	sequence.Add("event publishing", forwarder.Close)
+	report := sequence.Run(ctx)
+	require.True(t, report.Complete(), report.String())
+	require.Empty(t, report.Failed)
+	traders.Wait()
+
+	// every order event of the drained requests reached the broker
+	assert.Zero(t, forwarder.Dropped())
+	require.Len(t, publisher.delivered, int(emitted.Load()))
+	published := make(map[string]bool)
+	for _, message := range publisher.delivered {
+		var event orderbook.OrderEvent
+		require.NoError(t, json.Unmarshal(message.Value, &event))
+		published[event.OrderID] = true
+	}
+	total := 0
+	for _, ids := range accepted {
+		total += len(ids)
+		for _, id := range ids {
+			assert.True(t, published[id], "events of accepted order %s published", id)
+		}
+	}
+	require.Positive(t, total, "orders placed before the signal")
+
+	// the final snapshot on disk is the state at drain completion
+	final, _, err := snapshot.Load(dir)
+	require.NoError(t, err)
+	require.Len(t, final.Positions.Positions, len(drained.Positions.Positions))
+	for i := range drained.Positions.Positions { // taken at each capture
+		drained.Positions.Positions[i].SnapshotTimestamp = final.Positions.Positions[i].SnapshotTimestamp
+	}
+	for _, part := range []struct {
+		name       string
+		want, have any
+	}{
+		{"margin", drained.Margin, final.Margin},
+		{"trading", drained.Trading, final.Trading},
+		{"positions", drained.Positions.Positions, final.Positions.Positions},
+	} {
+		want, err := json.Marshal(part.want)
+		require.NoError(t, err)
+		have, err := json.Marshal(part.have)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(want), string(have), fmt.Sprintf("%s state", part.name))
+	}
+	require.NotEmpty(t, final.Trading.Books)
+}
@@ -0,0 +1,141 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/version"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	filePrefix = "snapshot-"
+	fileSuffix = ".json"
+)
+
+// snapshotFile on-disk layout: the schema and a checksum around the snapshot, so a torn or
+// corrupted file is detected rather than half restored
+type snapshotFile struct {
+	Schema   int             `json:"schema"`
+	Checksum string          `json:"checksum"` // hex sha256 of State
+	State    json.RawMessage `json:"state"`
+}
+
+// fileName names sort by stamp
+func fileName(stamp int64) string {
+	return fmt.Sprintf("%s%020d%s", filePrefix, stamp, fileSuffix)
+}
+
+func encode(snapshot *Snapshot) ([]byte, error) {
+	state, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("encode snapshot: %w", err)
+	}
+	sum := sha256.Sum256(state)
+	return json.Marshal(snapshotFile{
+		Schema:   version.SnapshotSchema,
+		Checksum: hex.EncodeToString(sum[:]),
+		State:    state,
+	})
+}
+
+func readSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file snapshotFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+	if file.Schema != version.SnapshotSchema {
+		return nil, fmt.Errorf("%s has schema %d, this build reads %d", filepath.Base(path), file.Schema, version.SnapshotSchema)
+	}
+	sum := sha256.Sum256(file.State)
+	if hex.EncodeToString(sum[:]) != file.Checksum {
+		return nil, fmt.Errorf("%s checksum mismatch", filepath.Base(path))
+	}
+
+	var snapshot Snapshot
+	if err = json.Unmarshal(file.State, &snapshot); err != nil {
+		return nil, fmt.Errorf("decode %s state: %w", filepath.Base(path), err)
+	}
+	return &snapshot, nil
+}
+
+// writeAtomic write data to a temp file of dir, sync it, then rename it to name: readers see the old
+// file or the complete new one, never a partial write
+func writeAtomic(dir, name string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, "."+filePrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	path := filepath.Join(dir, name)
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+
+	// persist the rename itself, best effort where directories can not be synced
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return path, nil
+}
+
+// listSnapshots snapshot files of dir, newest first. a missing dir has none
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+	}
+	return paths, nil
+}
+
+// rotate remove every snapshot file of dir but the newest keep
+func rotate(dir string, keep int) error {
+	paths, err := listSnapshots(dir)
+	if err != nil || len(paths) <= keep {
+		return err
+	}
+	var errs []error
+	for _, path := range paths[keep:] {
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,226 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/version"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultInterval time between two snapshots of Run when Config.Interval is 0
+	DefaultInterval = time.Minute
+	// DefaultKeep snapshot files kept when Config.Keep is 0, the oldest are removed
+	DefaultKeep = 3
+)
+
+// ErrNoSnapshot Load found no snapshot file, a first start
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+// Config
+type Config struct {
+	Dir      string        // directory of the snapshot files, created if missing
+	Interval time.Duration // 0 means DefaultInterval
+	Keep     int           // rotations kept, 0 means DefaultKeep
+}
+
+// CommandLog write-ahead log the snapshots are taken against, e.g. wal.Recorder. Quiesce runs fn between
+// two commands with the sequence of the last one applied, Prune drops the entries a kept snapshot covers
+type CommandLog interface {
+	Quiesce(fn func(sequence uint64))
+	Prune(sequence uint64) error
+}
+
+// Snapshot (引擎快照) state of the whole engine at one consistent point
+type Snapshot struct {
+	Build     version.BuildInfo     `json:"build"`
+	TakenAt   time.Time             `json:"taken_at"`
+	Sequence  uint64                `json:"sequence"` // last command log entry applied, 0 without a log
+	Positions position.ManagerState `json:"positions"`
+	Margin    margin.MarginState    `json:"margin"`
+	Trading   api.ServerState       `json:"trading"` // order books and stream sequences
+}
+
+// SnapshotManager (快照管理) periodic on-disk snapshots of the engine and the order books of its API server,
+// written atomically (temp file + rename) and rotated. components that change the position manager or the
+// margin system without going through the engine or the server (funding settlement, reservation expiry)
+// are not paused by the consistent point
+type SnapshotManager struct {
+	config Config
+	engine *engine.FuturesEngine
+	server *api.Server
+	log    CommandLog // nil: snapshots alone
+
+	lastStamp int64        // file name stamp of the last write, names stay ordered
+	lastWrite atomic.Int64 // unix nanoseconds of the last successful write, or of the creation
+	mu        sync.Mutex   // one write at a time
+}
+
+func NewSnapshotManager(e *engine.FuturesEngine, server *api.Server, config *Config) (*SnapshotManager, error) {
+	if config == nil || config.Dir == "" {
+		return nil, fmt.Errorf("snapshot manager needs a directory")
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create snapshot directory: %w", err)
+	}
+	m := &SnapshotManager{config: *config, engine: e, server: server}
+	if m.config.Interval <= 0 {
+		m.config.Interval = DefaultInterval
+	}
+	if m.config.Keep <= 0 {
+		m.config.Keep = DefaultKeep
+	}
+	m.lastWrite.Store(time.Now().UnixNano())
+	return m, nil
+}
+
+// SetCommandLog take the snapshots between two commands of log, recording the sequence they cover,
+// and prune the log up to the oldest kept snapshot. call before the first snapshot
+func (m *SnapshotManager) SetCommandLog(log CommandLog) {
+	m.log = log
+}
+
+// Capture state of the engine while the server is quiesced (no order placed or cancelled, no position
+// opened, closed or marked), exported in a fixed order: positions, margin, then books and sequences
+func (m *SnapshotManager) Capture() *Snapshot {
+	snapshot := &Snapshot{Build: version.Get()}
+	capture := func() {
+		snapshot.TakenAt = time.Now()
+		snapshot.Positions = m.engine.PositionManager().ExportState()
+		snapshot.Margin = m.engine.MarginSystem().ExportState()
+		snapshot.Trading = m.server.ExportState()
+	}
+	if m.log == nil {
+		m.server.Quiesce(capture)
+		return snapshot
+	}
+	m.log.Quiesce(func(sequence uint64) {
+		snapshot.Sequence = sequence
+		capture()
+	})
+	return snapshot
+}
+
+// Save capture a snapshot and write it, return the file path
+func (m *SnapshotManager) Save() (string, error) {
+	return m.Write(m.Capture())
+}
+
+// Write snapshot as the newest file, then remove the rotations beyond Config.Keep
+func (m *SnapshotManager) Write(snapshot *Snapshot) (string, error) {
+	data, err := encode(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastStamp = max(m.lastStamp+1, snapshot.TakenAt.UnixNano())
+	path, err := writeAtomic(m.config.Dir, fileName(m.lastStamp), data)
+	if err != nil {
+		return "", fmt.Errorf("write snapshot: %w", err)
+	}
+	m.lastWrite.Store(time.Now().UnixNano())
+	if err = rotate(m.config.Dir, m.config.Keep); err != nil {
+		logger.Default().Warn("snapshot rotation failed", "dir", m.config.Dir, "error", err)
+	}
+	if m.log != nil {
+		m.prune()
+	}
+	return path, nil
+}
+
+// prune drop the log entries every kept snapshot covers: Load may fall back to the oldest one. m.mu held
+func (m *SnapshotManager) prune() {
+	paths, err := listSnapshots(m.config.Dir)
+	if err != nil || len(paths) == 0 {
+		return
+	}
+	oldest, err := readSnapshot(paths[len(paths)-1])
+	if err != nil {
+		return // unusable, Load skips it: keep the log until it rotates out
+	}
+	if err = m.log.Prune(oldest.Sequence); err != nil {
+		logger.Default().Warn("command log pruning failed", "sequence", oldest.Sequence, "error", err)
+	}
+}
+
+// Run save a snapshot every Config.Interval until ctx is done. the shutdown snapshot is the caller's:
+// Save once more after the servers stopped taking traffic
+func (m *SnapshotManager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := m.Save(); err != nil {
+				logger.Default().Warn("periodic snapshot failed", "dir", m.config.Dir, "error", err)
+			}
+		}
+	}
+}
+
+// CheckHealth implements health.Contributor: an error once no snapshot was written for 3 Intervals,
+// periodic snapshots failing (disk full, unwritable directory) or Run not running
+func (m *SnapshotManager) CheckHealth(context.Context) error {
+	age := time.Since(time.Unix(0, m.lastWrite.Load()))
+	if age > 3*m.config.Interval {
+		return fmt.Errorf("last snapshot written %s ago, interval %s", age.Round(time.Second), m.config.Interval)
+	}
+	return nil
+}
+
+// Restore rebuild the snapshot into the engine and server: margin, positions, then books and sequences.
+// call before engine.Start, which warms up the derived state and verifies the integrity of the result
+func (m *SnapshotManager) Restore(snapshot *Snapshot) error {
+	if m.engine.Accepting() == nil {
+		return fmt.Errorf("snapshot must be restored before the engine starts")
+	}
+	if err := m.engine.MarginSystem().RestoreState(snapshot.Margin); err != nil {
+		return fmt.Errorf("restore margin: %w", err)
+	}
+	if err := m.engine.PositionManager().RestoreState(snapshot.Positions); err != nil {
+		return fmt.Errorf("restore positions: %w", err)
+	}
+	if err := m.server.RestoreState(snapshot.Trading); err != nil {
+		return fmt.Errorf("restore order books: %w", err)
+	}
+	return nil
+}
+
+// Load newest snapshot of dir that reads back intact, falling back to the previous rotations.
+// return the snapshot and its path. ErrNoSnapshot when dir has no snapshot file, another error when
+// every file is unusable: starting empty then would lose the state
+func Load(dir string) (*Snapshot, string, error) {
+	paths, err := listSnapshots(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var errs []error
+	for _, path := range paths {
+		snapshot, err := readSnapshot(path)
+		if err == nil {
+			return snapshot, path, nil
+		}
+		logger.Default().Warn("snapshot unusable, trying the previous one", "path", path, "error", err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("%w in %s", ErrNoSnapshot, dir)
+	}
+	return nil, "", fmt.Errorf("no usable snapshot in %s: %w", dir, errors.Join(errs...))
+}
@@ -0,0 +1,302 @@
+package snapshot
+
+import (
+	"context"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	symbols = []string{"BTCUSDT", "ETHUSDT"}
+	prices  = map[string]float64{"BTCUSDT": 50000, "ETHUSDT": 3000}
+	users   = []string{"u0", "u1", "u2", "u3"} // order traffic
+	holders = []string{"h0", "h1"}             // positions opened through the engine
+)
+
+// newTestEngine engine and API server. funded: accounts created and the engine started,
+// otherwise an empty engine to restore into
+func newTestEngine(t *testing.T, funded bool) (*engine.FuturesEngine, *api.Server) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: symbols})
+	require.NoError(t, err)
+	if funded {
+		for _, userID := range append(append([]string{}, users...), holders...) {
+			_, err = e.MarginSystem().CreateAccount(userID)
+			require.NoError(t, err)
+			require.NoError(t, e.MarginSystem().Deposit(userID, 1_000_000))
+		}
+		require.NoError(t, e.Start(context.Background()))
+	}
+	server := api.NewServer(e, "", nil, nil)
+	t.Cleanup(func() {
+		_ = server.Shutdown(context.Background())
+		_ = e.Close()
+	})
+	return e, server
+}
+
+// trade one random order of the user: place one, or cancel one of its resting orders
+func trade(ctx context.Context, server *api.Server, rng *rand.Rand, resting *[]string, userID string) {
+	symbol := symbols[rng.Intn(len(symbols))]
+	price := math.Round(prices[symbol] * (1 + (rng.Float64()-0.5)/100))
+	if len(*resting) > 0 && rng.Intn(3) == 0 {
+		i := rng.Intn(len(*resting))
+		_, _ = server.CancelOrder(ctx, userID, (*resting)[i])
+		*resting = append((*resting)[:i], (*resting)[i+1:]...)
+		return
+	}
+	side := "buy"
+	if rng.Intn(2) == 0 {
+		side = "sell"
+	}
+	resp, err := server.PlaceOrder(ctx, api.PlaceOrderRequest{UserID: userID, Symbol: symbol, Side: side,
+		Price: price, Size: 0.1, Leverage: 10, ReduceOnly: rng.Intn(5) == 0})
+	if err == nil && resp.Order.Size > 0 {
+		*resting = append(*resting, resp.Order.ID)
+	}
+}
+
+// market open positions of the holders and move the mark prices. account and position fields are read
+// unlocked by the margin checks, so the engine calls share one goroutine and no user with the order traffic
+func market(ctx context.Context, e *engine.FuturesEngine, rng *rand.Rand) {
+	symbol := symbols[rng.Intn(len(symbols))]
+	price := math.Round(prices[symbol] * (1 + (rng.Float64()-0.5)/100))
+	if rng.Intn(4) == 0 {
+		_, _ = e.UpdateMarkPrice(ctx, symbol, price)
+		return
+	}
+	// one-way mode: every user keeps to one side
+	i := rng.Intn(len(holders))
+	side := position.LONG
+	if i%2 == 1 {
+		side = position.SHORT
+	}
+	_, _ = e.OpenPosition(ctx, common.ISOLATED, holders[i], symbol, side, price, 0.01, 10)
+}
+
+// busy trade n orders for every user in its own goroutine, and n market steps in another
+func busy(e *engine.FuturesEngine, server *api.Server, n int) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	run := func(seed int64, step func(rng *rand.Rand)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < n; i++ {
+				step(rng)
+			}
+		}()
+	}
+
+	ctx := context.Background()
+	for i, userID := range users {
+		var resting []string
+		run(int64(i), func(rng *rand.Rand) { trade(ctx, server, rng, &resting, userID) })
+	}
+	run(-1, func(rng *rand.Rand) { market(ctx, e, rng) })
+	return &wg
+}
+
+// assertConsistent the snapshot is one point across the subsystems: every resting order that reserves
+// margin has its reservation in the margin state
+func assertConsistent(t *testing.T, snapshot *Snapshot) {
+	t.Helper()
+	reservations := make(map[string]bool)
+	for _, reservation := range snapshot.Margin.Reservations {
+		reservations[reservation.OrderID] = true
+	}
+	for _, book := range snapshot.Trading.Books {
+		for _, order := range append(append([]orderbook.Order{}, book.Bids...), book.Asks...) {
+			assert.True(t, order.ReduceOnly || reservations[order.ID], "order %s without reservation", order.ID)
+		}
+	}
+}
+
+func TestSnapshotBusyEngineAndRestore(t *testing.T) {
+	e, server := newTestEngine(t, true)
+	manager, err := NewSnapshotManager(e, server, &Config{Dir: t.TempDir(), Keep: 2})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		busy(e, server, 2000).Wait()
+		close(done)
+	}()
+	var captured []*Snapshot
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		snapshot := manager.Capture()
+		assertConsistent(t, snapshot)
+		captured = append(captured, snapshot)
+		time.Sleep(time.Millisecond)
+	}
+	require.Greater(t, len(captured), 1, "snapshots taken under load")
+	for _, snapshot := range captured[len(captured)-3:] {
+		_, err = manager.Write(snapshot)
+		require.NoError(t, err)
+	}
+
+	// every position marked at the last mark price, as a restart would
+	ctx := context.Background()
+	for _, symbol := range symbols {
+		_, err = e.UpdateMarkPrice(ctx, symbol, prices[symbol])
+		require.NoError(t, err)
+	}
+	path, err := manager.Save()
+	require.NoError(t, err)
+
+	paths, err := listSnapshots(manager.config.Dir)
+	require.NoError(t, err)
+	assert.Len(t, paths, 2, "rotations beyond Keep removed")
+	entries, err := os.ReadDir(manager.config.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temp file left")
+
+	want, loaded, err := Load(manager.config.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, path, loaded)
+	require.NotEmpty(t, want.Positions.Positions)
+	require.NotEmpty(t, want.Margin.Reservations)
+	assertConsistent(t, want)
+
+	restoredEngine, restoredServer := newTestEngine(t, false)
+	restorer, err := NewSnapshotManager(restoredEngine, restoredServer, &Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, restorer.Restore(want))
+	require.NoError(t, restoredEngine.Start(ctx), "restored state passes the integrity check")
+	assert.Error(t, restorer.Restore(want), "engine already started")
+
+	// compared after the same JSON round trip as want
+	_, err = restorer.Save()
+	require.NoError(t, err)
+	got, _, err := Load(restorer.config.Dir)
+	require.NoError(t, err)
+	require.Len(t, got.Positions.Positions, len(want.Positions.Positions))
+	for i, pos := range want.Positions.Positions {
+		pos.SnapshotTimestamp = got.Positions.Positions[i].SnapshotTimestamp
+		assert.Equal(t, pos, got.Positions.Positions[i])
+	}
+	assert.Equal(t, want.Positions.Modes, got.Positions.Modes)
+	assert.Equal(t, want.Positions.MarkPrices, got.Positions.MarkPrices)
+	assert.Equal(t, want.Margin.Reservations, got.Margin.Reservations)
+	require.Len(t, got.Margin.Accounts, len(want.Margin.Accounts))
+	for i, account := range want.Margin.Accounts {
+		assert.InDelta(t, account.Balance, got.Margin.Accounts[i].Balance, 1e-9)
+		assert.InDelta(t, account.OrderMargin, got.Margin.Accounts[i].OrderMargin, 1e-9)
+		assert.InDelta(t, account.PositionMargin, got.Margin.Accounts[i].PositionMargin, 1e-6)
+	}
+	assert.Equal(t, want.Trading.Books, got.Trading.Books)
+	assert.Equal(t, want.Trading.OrderSequences, got.Trading.OrderSequences)
+	assert.Equal(t, want.Trading.StreamSequence, got.Trading.StreamSequence)
+
+	// the restored books take traffic: a resting order can be cancelled through the server
+	var resting []orderbook.Order
+	for _, book := range want.Trading.Books {
+		resting = append(append(resting, book.Bids...), book.Asks...)
+	}
+	require.NotEmpty(t, resting)
+	_, err = restoredServer.CancelOrder(ctx, resting[0].UserID, resting[0].ID)
+	assert.NoError(t, err)
+}
+
+func TestLoadFallsBackToPreviousRotation(t *testing.T) {
+	e, server := newTestEngine(t, true)
+	manager, err := NewSnapshotManager(e, server, &Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	var paths []string
+	for _, price := range []float64{49000, 50000, 51000} {
+		_, err = e.UpdateMarkPrice(ctx, "BTCUSDT", price)
+		require.NoError(t, err)
+		path, err := manager.Save()
+		require.NoError(t, err)
+		paths = append(paths, path)
+	}
+
+	_, loaded, err := Load(manager.config.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, paths[2], loaded)
+
+	// newest torn mid-write
+	data, err := os.ReadFile(paths[2])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(paths[2], data[:len(data)/2], 0o644))
+	snapshot, loaded, err := Load(manager.config.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, paths[1], loaded)
+	assert.Equal(t, 50000.0, snapshot.Positions.MarkPrices["BTCUSDT"])
+
+	// previous one still valid JSON but altered: the checksum catches it
+	data, err = os.ReadFile(paths[1])
+	require.NoError(t, err)
+	altered := []byte(string(data))
+	i := len(altered) - 10
+	for altered[i] < '0' || altered[i] > '9' {
+		i--
+	}
+	altered[i] = '0' + (altered[i]-'0'+1)%10
+	require.NoError(t, os.WriteFile(paths[1], altered, 0o644))
+	snapshot, loaded, err = Load(manager.config.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, paths[0], loaded)
+	assert.Equal(t, 49000.0, snapshot.Positions.MarkPrices["BTCUSDT"])
+
+	require.NoError(t, os.Remove(paths[0]))
+	_, _, err = Load(manager.config.Dir)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNoSnapshot, "files there but unusable")
+	_, _, err = Load(filepath.Join(manager.config.Dir, "missing"))
+	assert.ErrorIs(t, err, ErrNoSnapshot)
+}
+
+func TestLoadRefusesOtherSchema(t *testing.T) {
+	dir := t.TempDir()
+	_, err := writeAtomic(dir, fileName(1), []byte(`{"schema":999,"checksum":"","state":{}}`))
+	require.NoError(t, err)
+
+	_, _, err = Load(dir)
+	assert.ErrorContains(t, err, "schema 999")
+}
+
+func TestNewSnapshotManagerDefaults(t *testing.T) {
+	e, server := newTestEngine(t, false)
+	_, err := NewSnapshotManager(e, server, nil)
+	assert.Error(t, err)
+
+	manager, err := NewSnapshotManager(e, server, &Config{Dir: filepath.Join(t.TempDir(), "nested", "dir")})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultInterval, manager.config.Interval)
+	assert.Equal(t, DefaultKeep, manager.config.Keep)
+	assert.DirExists(t, manager.config.Dir)
+}
+
+func TestSnapshotManagerHealth(t *testing.T) {
+	e, server := newTestEngine(t, true)
+	m, err := NewSnapshotManager(e, server, &Config{Dir: t.TempDir(), Interval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, m.CheckHealth(context.Background()))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.ErrorContains(t, m.CheckHealth(context.Background()), "last snapshot written")
+
+	_, err = m.Save()
+	require.NoError(t, err)
+	assert.NoError(t, m.CheckHealth(context.Background()))
+}
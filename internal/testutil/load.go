@@ -0,0 +1,118 @@
+// Package testutil harnesses shared by the tests and benchmarks of several packages
+package testutil
+
+import (
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/position"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadTestResult outcome of a sustained concurrent run against one PositionManager
+type LoadTestResult struct {
+	TotalOperations    int64
+	ErrorCount         int64
+	DataRaceDetected   bool // HealthCheck reported a violation
+	MaxOpenPositions   int
+	FinalPositionCount int
+	Throughput         float64 // operations per second
+}
+
+// LoadTestPositionManager run goroutines users against a fresh PositionManager for duration seconds.
+// every user trades positions symbols (so holds at most that many positions) and randomly opens,
+// reprices, reduces and closes its longs, while HealthCheck runs every 100ms
+func LoadTestPositionManager(positions, goroutines, duration int) LoadTestResult {
+	symbols := make([]string, positions)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("LOAD%dUSDT", i)
+	}
+	pm := position.NewPositionManager(symbols)
+	defer pm.Close()
+
+	var (
+		result     LoadTestResult
+		violations int // written by the checker until it is done
+		wg         sync.WaitGroup
+	)
+
+	countOpen := func() int {
+		count := 0
+		for _, symbol := range symbols {
+			symbolPositions, _ := pm.GetSymbolPositions(symbol)
+			for _, pos := range symbolPositions {
+				if snapshot := pos.Snapshot(); snapshot.Status == position.PositionNormal && snapshot.Size > 0 {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	stop := make(chan struct{})
+	checkerDone := make(chan struct{})
+	go func() {
+		defer close(checkerDone)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				violations += len(pm.HealthCheck())
+				result.MaxOpenPositions = max(result.MaxOpenPositions, countOpen())
+			}
+		}
+	}()
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(duration) * time.Second)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			userID := fmt.Sprintf("load_user_%d", g)
+
+			for time.Now().Before(deadline) {
+				symbol := symbols[rng.Intn(len(symbols))]
+				// ±2% around 50000, 2x leverage never gets liquidated
+				price := 50000 * (0.98 + rng.Float64()*0.04)
+				size := float64(1+rng.Intn(100)) / 1000
+
+				var err error
+				pos, getErr := pm.GetPosition(userID, symbol, position.LONG)
+				open := getErr == nil && pos.Snapshot().Status == position.PositionNormal
+
+				switch op := rng.Intn(10); {
+				case !open || op < 3:
+					_, err = pm.OpenPosition(common.ISOLATED, userID, symbol, position.LONG, price, size, 2)
+				case op < 6:
+					_, err = pm.UpdateMarkPrices(symbol, price)
+				case op < 9:
+					_, _, err = pm.ReducePosition(userID, symbol, position.LONG, price, min(size, pos.Snapshot().Size))
+				default:
+					_, _, err = pm.ClosePosition(userID, symbol, position.LONG, price)
+				}
+
+				atomic.AddInt64(&result.TotalOperations, 1)
+				if err != nil {
+					atomic.AddInt64(&result.ErrorCount, 1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stop)
+	<-checkerDone
+
+	violations += len(pm.HealthCheck())
+	result.DataRaceDetected = violations > 0
+	result.FinalPositionCount = countOpen()
+	result.Throughput = float64(result.TotalOperations) / elapsed.Seconds()
+	return result
+}
@@ -2,7 +2,11 @@ package version
 
 import (
 	"fmt"
+	"frizo/futures_engine/internal/version/versionpb"
 	"runtime"
+	"strings"
+
+	"golang.org/x/mod/semver"
 )
 
 // Build information. Populated at build-time via ldflags.
@@ -14,6 +18,10 @@ var (
 	GoVersion = runtime.Version()
 )
 
+// SnapshotSchema layout version of the engine state snapshot files, bumped on every incompatible change.
+// a snapshot of another schema is refused on load.
+const SnapshotSchema = 1
+
 // BuildInfo contains all the build-time information.
 type BuildInfo struct {
 	Version   string `json:"version"`
@@ -47,4 +55,50 @@ func Short() string {
 		return fmt.Sprintf("%s (%s)", Version, GitCommit[:7])
 	}
 	return Version
-}
\ No newline at end of file
+}
+
+// IsCompatibleWith reports whether two nodes can run side by side: same major version.
+// a build without a semantic version (e.g. "dev") is only compatible with the identical version.
+func (b BuildInfo) IsCompatibleWith(other BuildInfo) bool {
+	v, otherV := canonical(b.Version), canonical(other.Version)
+	if v == "" || otherV == "" {
+		return b.Version == other.Version
+	}
+	return semver.Major(v) == semver.Major(otherV)
+}
+
+// Compare returns -1, 0 or +1 ordering by semantic version. builds without a
+// semantic version sort before any release and are equal to each other.
+func (b BuildInfo) Compare(other BuildInfo) int {
+	return semver.Compare(canonical(b.Version), canonical(other.Version))
+}
+
+// AsProto the build info as exchanged over gRPC.
+func (b BuildInfo) AsProto() *versionpb.BuildInfo {
+	return &versionpb.BuildInfo{
+		Version:   b.Version,
+		BuildTime: b.BuildTime,
+		GitCommit: b.GitCommit,
+		GitBranch: b.GitBranch,
+		GoVersion: b.GoVersion,
+	}
+}
+
+// FromProto build info of a peer received over gRPC, nil is the zero BuildInfo.
+func FromProto(pb *versionpb.BuildInfo) BuildInfo {
+	return BuildInfo{
+		Version:   pb.GetVersion(),
+		BuildTime: pb.GetBuildTime(),
+		GitCommit: pb.GetGitCommit(),
+		GitBranch: pb.GetGitBranch(),
+		GoVersion: pb.GetGoVersion(),
+	}
+}
+
+// canonical semver form of version, "v" prefix optional. "" if not a semantic version.
+func canonical(version string) string {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return semver.Canonical(version)
+}
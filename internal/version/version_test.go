@@ -0,0 +1,48 @@
+package version
+
+import (
+	"frizo/futures_engine/internal/version/versionpb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInfoCompatibility(t *testing.T) {
+	build := func(version string) BuildInfo {
+		return BuildInfo{Version: version}
+	}
+
+	tests := []struct {
+		name       string
+		a, b       string
+		compatible bool
+		compare    int
+	}{
+		{"dev builds", "dev", "dev", true, 0},
+		{"dev and release", "dev", "v1.2.0", false, -1},
+		{"same version", "v1.2.3", "1.2.3", true, 0},
+		{"compatible minor", "v1.2.0", "v1.3.1", true, -1},
+		{"compatible patch", "v1.2.10", "v1.2.9", true, 1},
+		{"prerelease", "v1.3.0-rc.1", "v1.3.0", true, -1},
+		{"incompatible major", "v2.0.0", "v1.9.9", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := build(tt.a), build(tt.b)
+			assert.Equal(t, tt.compatible, a.IsCompatibleWith(b))
+			assert.Equal(t, tt.compatible, b.IsCompatibleWith(a))
+			assert.Equal(t, tt.compare, a.Compare(b))
+			assert.Equal(t, -tt.compare, b.Compare(a))
+		})
+	}
+}
+
+func TestBuildInfoProto(t *testing.T) {
+	build := BuildInfo{Version: "v1.2.3", BuildTime: "2024-05-01T12:00:00Z", GitCommit: "0123456789abcdef",
+		GitBranch: "main", GoVersion: "go1.23.9"}
+	pb := build.AsProto()
+	assert.Equal(t, "v1.2.3", pb.GetVersion())
+	assert.Equal(t, "0123456789abcdef", pb.GetGitCommit())
+	assert.Equal(t, build, FromProto(pb))
+	assert.Equal(t, BuildInfo{}, FromProto((*versionpb.BuildInfo)(nil)))
+}
@@ -0,0 +1,120 @@
+package wal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/orderbook"
+	"frizo/futures_engine/internal/position"
+	"sort"
+)
+
+// hashedAccount the fields of an account a replay rebuilds, its update time left out
+type hashedAccount struct {
+	UserID         string               `json:"user_id"`
+	Status         margin.AccountStatus `json:"status"`
+	Balance        float64              `json:"balance"`
+	FrozenBalance  float64              `json:"frozen_balance"`
+	PositionMargin float64              `json:"position_margin"`
+	OrderMargin    float64              `json:"order_margin"`
+	RealizedPnL    float64              `json:"realized_pnl"`
+	AccruedRebates float64              `json:"accrued_rebates"`
+}
+
+// hashedPosition the fields of an open position a replay rebuilds: its id and times are regenerated
+type hashedPosition struct {
+	UserID           string                  `json:"user_id"`
+	Symbol           string                  `json:"symbol"`
+	Side             position.PositionSide   `json:"side"`
+	Status           position.PositionStatus `json:"status"`
+	Size             float64                 `json:"size"`
+	EntryPrice       float64                 `json:"entry_price"`
+	LiquidationPrice float64                 `json:"liquidation_price"`
+	InitialMargin    float64                 `json:"initial_margin"`
+	Leverage         int16                   `json:"leverage"`
+	MarginMode       common.MarginMode       `json:"margin_mode"`
+	RealizedPnL      float64                 `json:"realized_pnl"`
+	TradingFees      float64                 `json:"trading_fees"`
+}
+
+// hashedState what StateHash covers
+type hashedState struct {
+	Sequence       uint64                   `json:"sequence"`
+	Books          []orderbook.BookSnapshot `json:"books"`
+	OrderSequences map[string]uint64        `json:"order_sequences"`
+	Accounts       []hashedAccount          `json:"accounts"`
+	Reservations   map[string]float64       `json:"reservations"` // order id -> amount
+	InsuranceFund  float64                  `json:"insurance_fund"`
+	Positions      []hashedPosition         `json:"positions"` // by user, symbol, side, entry price, size
+	MarkPrices     map[string]float64       `json:"mark_prices"`
+}
+
+// StateHash (狀態雜湊) sha256 of the state the log rebuilds, taken between two commands: the last sequence,
+// the books (order ids and times are logged), the order event sequences, the accounts, reservations and
+// insurance fund balance, the open positions and the mark prices. position ids and the times a replay
+// regenerates are left out, so a node replaying the log hashes the same as the node that wrote it
+func (r *Recorder) StateHash() (string, error) {
+	// maps are encoded with sorted keys, floats exactly
+	data, err := json.Marshal(r.hashedState())
+	if err != nil {
+		return "", fmt.Errorf("encode state: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashedState the state of StateHash, in a canonical order
+func (r *Recorder) hashedState() hashedState {
+	var state hashedState
+	r.Quiesce(func(sequence uint64) {
+		trading := r.server.ExportState()
+		balances := r.engine.MarginSystem().ExportState()
+		positions := r.engine.PositionManager().ExportState()
+		state = hashedState{
+			Sequence:       sequence,
+			Books:          trading.Books,
+			OrderSequences: trading.OrderSequences,
+			Accounts:       make([]hashedAccount, 0, len(balances.Accounts)),
+			Reservations:   make(map[string]float64, len(balances.Reservations)),
+			InsuranceFund:  balances.InsuranceFund.Balance,
+			Positions:      make([]hashedPosition, 0, len(positions.Positions)),
+			MarkPrices:     positions.MarkPrices,
+		}
+		for _, account := range balances.Accounts {
+			state.Accounts = append(state.Accounts, hashedAccount{UserID: account.UserID, Status: account.Status,
+				Balance: account.Balance, FrozenBalance: account.FrozenBalance, PositionMargin: account.PositionMargin,
+				OrderMargin: account.OrderMargin, RealizedPnL: account.RealizedPnL, AccruedRebates: account.AccruedRebates})
+		}
+		for _, reservation := range balances.Reservations {
+			state.Reservations[reservation.OrderID] = reservation.Amount
+		}
+		for _, pos := range positions.Positions {
+			state.Positions = append(state.Positions, hashedPosition{UserID: pos.UserID, Symbol: pos.Symbol,
+				Side: pos.Side, Status: pos.Status, Size: pos.Size, EntryPrice: pos.EntryPrice,
+				LiquidationPrice: pos.LiquidationPrice, InitialMargin: pos.InitialMargin, Leverage: pos.Leverage,
+				MarginMode: pos.MarginMode, RealizedPnL: pos.RealizedPnL, TradingFees: pos.TradingFees})
+		}
+	})
+	sort.Slice(state.Accounts, func(i, j int) bool { return state.Accounts[i].UserID < state.Accounts[j].UserID })
+	sort.Slice(state.Positions, func(i, j int) bool {
+		a, b := state.Positions[i], state.Positions[j]
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		if a.Side != b.Side {
+			return a.Side < b.Side
+		}
+		if a.EntryPrice != b.EntryPrice { // several isolated positions on one side
+			return a.EntryPrice < b.EntryPrice
+		}
+		return a.Size < b.Size
+	})
+
+	return state
+}
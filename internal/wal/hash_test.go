@@ -0,0 +1,69 @@
+package wal
+
+import (
+	"context"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/engine"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayStateHash(t *testing.T) {
+	commands := uint64(10_000)
+	if testing.Short() {
+		commands = 1_000
+	}
+	dir := t.TempDir()
+	reference, _ := startNode(t, dir)
+	empty, err := reference.recorder.StateHash()
+	require.NoError(t, err)
+
+	for _, userID := range append(append([]string{}, traders...), holders...) {
+		_, err = reference.recorder.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, reference.recorder.Deposit(userID, 1_000_000))
+	}
+	for seed := int64(7); reference.log.LastSequence() < commands; seed += 10 {
+		workload(reference, 100, seed) // failing orders are not logged, each round logs about 340 commands
+	}
+	hash, err := reference.recorder.StateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, empty, hash)
+	again, err := reference.recorder.StateHash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, again, "stable while idle")
+
+	// a fresh engine driven by a copy of the log alone
+	walDir := filepath.Join(t.TempDir(), "wal")
+	require.NoError(t, os.CopyFS(walDir, os.DirFS(filepath.Join(dir, "wal"))))
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: symbols})
+	require.NoError(t, err)
+	server := api.NewServer(e, "", nil, nil)
+	ctx := context.Background()
+	require.NoError(t, e.Start(ctx))
+	log, err := Open(walDir, nil)
+	require.NoError(t, err)
+	recorder := NewRecorder(log, e, server)
+	t.Cleanup(func() {
+		_ = server.Shutdown(ctx)
+		_ = log.Close()
+		_ = e.Close()
+	})
+	replayed, err := recorder.Replay(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, reference.log.LastSequence(), uint64(replayed))
+
+	replayedHash, err := recorder.StateHash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, replayedHash)
+
+	// and any later command changes it
+	require.NoError(t, recorder.Deposit("u0", 1))
+	changed, err := recorder.StateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+}
@@ -0,0 +1,484 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"frizo/futures_engine/internal/logger"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSyncInterval longest wait of an appended entry for the fsync of its batch when Config.SyncInterval is 0
+	DefaultSyncInterval = 2 * time.Millisecond
+	// DefaultSegmentSize size after which the log moves to a new segment file when Config.SegmentSize is 0
+	DefaultSegmentSize = 64 << 20
+
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+	headerSize    = 8 // payload length, crc32 of the payload
+)
+
+var (
+	// ErrCorrupt a record before the tail of the log is damaged, the log can not be trusted past it
+	ErrCorrupt = errors.New("write-ahead log corrupt")
+	// ErrClosed the log was closed
+	ErrClosed = errors.New("write-ahead log closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Config nil means the defaults
+type Config struct {
+	SyncInterval time.Duration // 0 means DefaultSyncInterval
+	SegmentSize  int64         // 0 means DefaultSegmentSize
+}
+
+// Entry one logged command
+type Entry struct {
+	Sequence uint64          `json:"sequence"`
+	Type     string          `json:"type"`
+	Time     time.Time       `json:"time"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Log (預寫日誌) append-only sequence of entries in segment files named by their first sequence. every record
+// is framed with its length and a crc32, appends are buffered and fsynced in batches: WaitDurable returns once
+// the batch of an entry reached the disk
+type Log struct {
+	dir    string
+	config Config
+
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64  // bytes of the active segment
+	last    uint64 // last appended sequence
+	durable uint64 // last fsynced sequence
+	err     error  // first write / sync failure, the log refuses appends after it
+	closed  bool
+	mu      sync.Mutex
+	synced  *sync.Cond // broadcast after every sync
+
+	pending chan struct{} // an append waits for its sync
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Open the log of dir, created if missing. a torn record at the very end (a crash mid-write) is truncated,
+// damage anywhere else is ErrCorrupt
+func Open(dir string, config *Config) (*Log, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create wal directory: %w", err)
+	}
+	l := &Log{
+		dir:     dir,
+		config:  *config,
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	l.synced = sync.NewCond(&l.mu)
+	if l.config.SyncInterval <= 0 {
+		l.config.SyncInterval = DefaultSyncInterval
+	}
+	if l.config.SegmentSize <= 0 {
+		l.config.SegmentSize = DefaultSegmentSize
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		if err = l.openSegment(1); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = l.recover(segments); err != nil {
+			return nil, err
+		}
+	}
+	l.durable = l.last
+
+	go l.run()
+	return l, nil
+}
+
+// recover check every segment, truncate a torn tail of the last one and continue appending to it
+func (l *Log) recover(segments []segment) error {
+	next := segments[0].first
+	for i, seg := range segments {
+		if seg.first < next {
+			return fmt.Errorf("%w: %s starts at %d, before %d", ErrCorrupt, seg.name, seg.first, next)
+		}
+		valid, last, err := scanSegment(filepath.Join(l.dir, seg.name), seg.first, nil)
+		tail := i == len(segments)-1
+		if err != nil && (!tail || !errors.Is(err, errTorn)) {
+			return err
+		}
+		if err != nil {
+			logger.Default().Warn("write-ahead log: torn final record truncated", "segment", seg.name, "offset", valid)
+		}
+		if last > 0 {
+			next = last + 1
+			l.last = last
+		} else {
+			l.last = seg.first - 1
+		}
+		if !tail {
+			continue
+		}
+
+		file, err := os.OpenFile(filepath.Join(l.dir, seg.name), os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		if err = file.Truncate(valid); err == nil {
+			_, err = file.Seek(valid, io.SeekStart)
+		}
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		l.file, l.writer, l.size = file, bufio.NewWriter(file), valid
+	}
+	return nil
+}
+
+// Append buffer the entry with the next sequence and return it. the entry is durable once WaitDurable
+// returns for it
+func (l *Log) Append(entryType string, payload any) (uint64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("encode %s entry: %w", entryType, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	if l.size >= l.config.SegmentSize {
+		if err = l.rotate(); err != nil {
+			l.err = err
+			return 0, err
+		}
+	}
+
+	record, err := json.Marshal(Entry{Sequence: l.last + 1, Type: entryType, Time: time.Now(), Data: data})
+	if err != nil {
+		return 0, err
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(record, crcTable))
+	if _, err = l.writer.Write(header[:]); err == nil {
+		_, err = l.writer.Write(record)
+	}
+	if err != nil {
+		l.err = fmt.Errorf("append to write-ahead log: %w", err)
+		return 0, l.err
+	}
+	l.size += int64(headerSize + len(record))
+	l.last++
+
+	select {
+	case l.pending <- struct{}{}:
+	default:
+	}
+	return l.last, nil
+}
+
+// WaitDurable block until the entry of sequence is fsynced
+func (l *Log) WaitDurable(sequence uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.durable < sequence && l.err == nil && !l.closed {
+		l.synced.Wait()
+	}
+	if l.durable >= sequence {
+		return nil
+	}
+	if l.err != nil {
+		return l.err
+	}
+	return ErrClosed
+}
+
+// Sync flush and fsync every appended entry now
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sync()
+}
+
+// LastSequence sequence of the last appended entry, 0 for an empty log
+func (l *Log) LastSequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// SkipTo continue the sequence after sequence when the log is behind it, e.g. a snapshot newer than the
+// log: later entries must sort after the snapshot
+func (l *Log) SkipTo(sequence uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if sequence <= l.last {
+		return nil
+	}
+	if err := l.sync(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.last, l.durable = sequence, sequence
+	return l.openSegment(sequence + 1)
+}
+
+// Replay call fn with every entry after sequence in order, reading the segments on disk. ErrCorrupt when
+// the entries right after it were pruned
+func (l *Log) Replay(after uint64, fn func(Entry) error) error {
+	if err := l.Sync(); err != nil {
+		return err
+	}
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	next := after + 1
+	for i, seg := range segments {
+		if i+1 < len(segments) && segments[i+1].first <= next {
+			continue // every entry at or before after
+		}
+		if _, _, err = scanSegment(filepath.Join(l.dir, seg.name), seg.first, func(entry Entry) error {
+			if entry.Sequence < next {
+				return nil
+			}
+			if entry.Sequence > next {
+				return fmt.Errorf("%w: entries %d to %d missing", ErrCorrupt, next, entry.Sequence-1)
+			}
+			next++
+			return fn(entry)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune remove the segments whose entries are all at or before sequence, the active segment stays
+func (l *Log) Prune(sequence uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i+1].first > sequence+1 {
+			break
+		}
+		if err = os.Remove(filepath.Join(l.dir, segments[i].name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close sync the pending entries and close the active segment
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	err := l.sync()
+	l.closed = true
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.synced.Broadcast()
+	l.mu.Unlock()
+
+	close(l.done)
+	<-l.stopped
+	return err
+}
+
+// run group commit: the first append of a batch waits SyncInterval for the others, then one fsync covers them all
+func (l *Log) run() {
+	defer close(l.stopped)
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.pending:
+		}
+		select {
+		case <-l.done:
+			return
+		case <-time.After(l.config.SyncInterval):
+		}
+
+		l.mu.Lock()
+		if !l.closed {
+			_ = l.sync()
+		}
+		l.mu.Unlock()
+	}
+}
+
+// sync l.mu held
+func (l *Log) sync() error {
+	if l.err != nil {
+		return l.err
+	}
+	if l.durable == l.last {
+		return nil
+	}
+	err := l.writer.Flush()
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.err = fmt.Errorf("sync write-ahead log: %w", err)
+		l.synced.Broadcast()
+		return l.err
+	}
+	l.durable = l.last
+	l.synced.Broadcast()
+	return nil
+}
+
+// rotate sync and close the active segment, then start the next one. l.mu held
+func (l *Log) rotate() error {
+	if err := l.sync(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return l.openSegment(l.last + 1)
+}
+
+// openSegment create the segment starting at first as the active one. l.mu held or not running yet
+func (l *Log) openSegment(first uint64) error {
+	file, err := os.OpenFile(filepath.Join(l.dir, segmentName(first)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file, l.writer, l.size = file, bufio.NewWriter(file), 0
+	if d, err := os.Open(l.dir); err == nil {
+		_ = d.Sync() // persist the new directory entry, best effort
+		_ = d.Close()
+	}
+	return nil
+}
+
+// ========================================================
+
+// errTorn the segment ends in the middle of a record
+var errTorn = errors.New("torn record")
+
+type segment struct {
+	name  string
+	first uint64
+}
+
+func segmentName(first uint64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, first, segmentSuffix)
+}
+
+// listSegments segment files of dir by first sequence
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil || first == 0 {
+			continue
+		}
+		segments = append(segments, segment{name: name, first: first})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].first < segments[j].first })
+	return segments, nil
+}
+
+// scanSegment read the records of a segment, calling fn (if any) for each. return the offset after the
+// last valid record and its sequence (0 for none). errTorn when the file ends in an incomplete or damaged
+// last record, ErrCorrupt for damage followed by more data or a sequence gap
+func scanSegment(path string, first uint64, fn func(Entry) error) (int64, uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	name := filepath.Base(path)
+	var offset int64
+	var last uint64
+	expected := first
+	for int(offset) < len(data) {
+		rest := data[offset:]
+		if len(rest) < headerSize {
+			return offset, last, fmt.Errorf("%s at %d: %w", name, offset, errTorn)
+		}
+		length := int(binary.LittleEndian.Uint32(rest[0:4]))
+		if length > len(rest)-headerSize {
+			return offset, last, fmt.Errorf("%s at %d: %w", name, offset, errTorn)
+		}
+		record := rest[headerSize : headerSize+length]
+		end := headerSize+length == len(rest)
+		if crc32.Checksum(record, crcTable) != binary.LittleEndian.Uint32(rest[4:8]) {
+			if end {
+				return offset, last, fmt.Errorf("%s at %d: %w", name, offset, errTorn)
+			}
+			return offset, last, fmt.Errorf("%w: %s checksum mismatch at %d", ErrCorrupt, name, offset)
+		}
+		var entry Entry
+		if err = json.Unmarshal(record, &entry); err != nil {
+			return offset, last, fmt.Errorf("%w: %s at %d: %v", ErrCorrupt, name, offset, err)
+		}
+		if entry.Sequence != expected {
+			return offset, last, fmt.Errorf("%w: %s has sequence %d, expected %d", ErrCorrupt, name, entry.Sequence, expected)
+		}
+		if fn != nil {
+			if err = fn(entry); err != nil {
+				return offset, last, err
+			}
+		}
+		offset += int64(headerSize + length)
+		last = entry.Sequence
+		expected++
+	}
+	return offset, last, nil
+}
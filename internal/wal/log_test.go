@@ -0,0 +1,172 @@
+package wal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPayload struct {
+	N int `json:"n"`
+}
+
+func appendN(t *testing.T, log *Log, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		sequence, err := log.Append("test", testPayload{N: i})
+		require.NoError(t, err)
+		require.NoError(t, log.WaitDurable(sequence))
+	}
+}
+
+func replayAll(t *testing.T, log *Log, after uint64) []Entry {
+	t.Helper()
+	var entries []Entry
+	require.NoError(t, log.Replay(after, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	}))
+	return entries
+}
+
+func TestAppendBatchesAndReopens(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				sequence, err := log.Append("test", testPayload{N: i})
+				assert.NoError(t, err)
+				assert.NoError(t, log.WaitDurable(sequence))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(400), log.LastSequence())
+	require.NoError(t, log.Close())
+	_, err = log.Append("test", testPayload{})
+	assert.ErrorIs(t, err, ErrClosed)
+
+	log, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer log.Close()
+	assert.Equal(t, uint64(400), log.LastSequence())
+	entries := replayAll(t, log, 390)
+	require.Len(t, entries, 10)
+	assert.Equal(t, uint64(391), entries[0].Sequence)
+	assert.Equal(t, "test", entries[0].Type)
+
+	sequence, err := log.Append("test", testPayload{N: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(401), sequence)
+}
+
+func TestOpenTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, nil)
+	require.NoError(t, err)
+	appendN(t, log, 5)
+	require.NoError(t, log.Close())
+
+	path := filepath.Join(dir, segmentName(1))
+	valid, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	cases := []struct {
+		data []byte
+		want uint64
+	}{
+		{append(append([]byte{}, valid...), 200, 0, 0, 0, 1, 2), 5}, // header cut short
+		{valid[:len(valid)-3], 4},                                   // payload cut short, the record is dropped
+	}
+	for _, c := range cases {
+		require.NoError(t, os.WriteFile(path, c.data, 0o644))
+
+		log, err = Open(dir, nil)
+		require.NoError(t, err, "torn tail is not fatal")
+		assert.Equal(t, c.want, log.LastSequence())
+		sequence, err := log.Append("test", testPayload{N: 9})
+		require.NoError(t, err)
+		require.NoError(t, log.WaitDurable(sequence))
+		assert.Len(t, replayAll(t, log, 0), int(c.want)+1, "appends continue after the truncated tail")
+		require.NoError(t, log.Close())
+		require.NoError(t, os.WriteFile(path, valid, 0o644))
+	}
+}
+
+func TestOpenRefusesCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, nil)
+	require.NoError(t, err)
+	appendN(t, log, 3)
+	require.NoError(t, log.Close())
+
+	path := filepath.Join(dir, segmentName(1))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[headerSize+2] ^= 0xff // inside the first record, more records follow
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	_, err = Open(dir, nil)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestSegmentsRotateAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, &Config{SegmentSize: 256})
+	require.NoError(t, err)
+	defer log.Close()
+	appendN(t, log, 20)
+
+	segments, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 2)
+
+	require.NoError(t, log.Prune(segments[2].first-1))
+	left, err := listSegments(dir)
+	require.NoError(t, err)
+	assert.Equal(t, segments[2:], left, "segments covered by the sequence removed")
+	assert.Len(t, replayAll(t, log, segments[2].first-1), 20-int(segments[2].first)+1)
+
+	err = log.Replay(0, func(Entry) error { return nil })
+	assert.ErrorIs(t, err, ErrCorrupt, "pruned entries can not be replayed")
+
+	require.NoError(t, log.Prune(log.LastSequence()))
+	left, err = listSegments(dir)
+	require.NoError(t, err)
+	assert.Len(t, left, 1, "the active segment stays")
+}
+
+func TestSkipTo(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir, nil)
+	require.NoError(t, err)
+	appendN(t, log, 2)
+
+	require.NoError(t, log.SkipTo(1), "already past")
+	assert.Equal(t, uint64(2), log.LastSequence())
+	require.NoError(t, log.SkipTo(10))
+	sequence, err := log.Append("test", testPayload{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), sequence)
+	require.NoError(t, log.Close())
+
+	log, err = Open(dir, nil)
+	require.NoError(t, err)
+	defer log.Close()
+	assert.Equal(t, uint64(11), log.LastSequence())
+	entries := replayAll(t, log, 10)
+	require.Len(t, entries, 1)
+	var payload testPayload
+	require.NoError(t, json.Unmarshal(entries[0].Data, &payload))
+}
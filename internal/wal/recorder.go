@@ -0,0 +1,266 @@
+package wal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/liquidation"
+	"frizo/futures_engine/internal/logger"
+	"frizo/futures_engine/internal/margin"
+	"frizo/futures_engine/internal/position"
+	"sync"
+)
+
+// command types of the engine calls, see api.CommandPlaceOrder for the orders
+const (
+	CommandCreateAccount = "create_account"
+	CommandDeposit       = "deposit"
+	CommandWithdraw      = "withdraw"
+	CommandOpenPosition  = "open_position"
+	CommandClosePosition = "close_position"
+	CommandMarkPrice     = "mark_price"
+	CommandFunding       = "funding"
+)
+
+type accountCommand struct {
+	UserID string  `json:"user_id"`
+	Amount float64 `json:"amount,omitempty"`
+}
+
+type positionCommand struct {
+	MarginMode common.MarginMode     `json:"margin_mode"`
+	UserID     string                `json:"user_id"`
+	Symbol     string                `json:"symbol"`
+	Side       position.PositionSide `json:"side"`
+	Price      float64               `json:"price"`
+	Size       float64               `json:"size,omitempty"`
+	Leverage   uint                  `json:"leverage,omitempty"`
+}
+
+type markPriceCommand struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+}
+
+type fundingCommand struct {
+	Symbol string  `json:"symbol"`
+	Rate   float64 `json:"rate"`
+}
+
+// Recorder (指令記錄器) routes every state-changing call through the log: append, apply, then wait for
+// the fsync of the batch before returning. one command is appended and applied at a time, so replaying
+// the log in sequence order rebuilds the same state. the server's orders are recorded once NewRecorder
+// returns; accounts, positions, mark prices and funding must be changed through the Recorder's methods,
+// a call straight to the engine is lost on a crash
+type Recorder struct {
+	log    *Log
+	engine *engine.FuturesEngine
+	server *api.Server
+	mu     sync.Mutex // one command appended and applied at a time
+}
+
+// NewRecorder recorder of e and server, set as the command log of server
+func NewRecorder(log *Log, e *engine.FuturesEngine, server *api.Server) *Recorder {
+	r := &Recorder{log: log, engine: e, server: server}
+	server.SetCommandLog(r)
+	return r
+}
+
+// Record implements api.CommandLog
+func (r *Recorder) Record(command string, payload any, apply func()) error {
+	r.mu.Lock()
+	sequence, err := r.log.Append(command, payload)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	apply()
+	r.mu.Unlock()
+
+	// acknowledged once durable, the state may already show it
+	return r.log.WaitDurable(sequence)
+}
+
+func (r *Recorder) CreateAccount(userID string) (*margin.MarginAccount, error) {
+	var account *margin.MarginAccount
+	var applyErr error
+	if err := r.Record(CommandCreateAccount, accountCommand{UserID: userID}, func() {
+		account, applyErr = r.engine.MarginSystem().CreateAccount(userID)
+	}); err != nil {
+		return nil, err
+	}
+	return account, applyErr
+}
+
+func (r *Recorder) Deposit(userID string, amount float64) error {
+	var applyErr error
+	if err := r.Record(CommandDeposit, accountCommand{UserID: userID, Amount: amount}, func() {
+		applyErr = r.engine.MarginSystem().Deposit(userID, amount)
+	}); err != nil {
+		return err
+	}
+	return applyErr
+}
+
+func (r *Recorder) Withdraw(userID string, amount float64) error {
+	var applyErr error
+	if err := r.Record(CommandWithdraw, accountCommand{UserID: userID, Amount: amount}, func() {
+		applyErr = r.engine.MarginSystem().Withdraw(userID, amount)
+	}); err != nil {
+		return err
+	}
+	return applyErr
+}
+
+func (r *Recorder) OpenPosition(ctx context.Context, marginMode common.MarginMode, userID, symbol string, side position.PositionSide, price, size float64, leverage uint) (*position.Position, error) {
+	command := positionCommand{MarginMode: marginMode, UserID: userID, Symbol: symbol, Side: side,
+		Price: price, Size: size, Leverage: leverage}
+	var pos *position.Position
+	var applyErr error
+	if err := r.Record(CommandOpenPosition, command, func() {
+		pos, applyErr = r.openPosition(ctx, command)
+	}); err != nil {
+		return nil, err
+	}
+	return pos, applyErr
+}
+
+func (r *Recorder) ClosePosition(ctx context.Context, userID, symbol string, side position.PositionSide, price float64) (*position.Position, float64, error) {
+	command := positionCommand{UserID: userID, Symbol: symbol, Side: side, Price: price}
+	var pos *position.Position
+	var pnl float64
+	var applyErr error
+	if err := r.Record(CommandClosePosition, command, func() {
+		pos, pnl, applyErr = r.engine.ClosePosition(ctx, userID, symbol, side, price)
+	}); err != nil {
+		return nil, 0, err
+	}
+	return pos, pnl, applyErr
+}
+
+func (r *Recorder) UpdateMarkPrice(ctx context.Context, symbol string, price float64) ([]liquidation.LiquidationResult, error) {
+	var results []liquidation.LiquidationResult
+	var applyErr error
+	if err := r.Record(CommandMarkPrice, markPriceCommand{Symbol: symbol, Price: price}, func() {
+		results, applyErr = r.engine.UpdateMarkPrice(ctx, symbol, price)
+	}); err != nil {
+		return nil, err
+	}
+	return results, applyErr
+}
+
+// SettleFunding implements funding.Settler, give the Recorder to the funding scheduler
+func (r *Recorder) SettleFunding(symbol string, rate float64) (margin.FundingSettlement, error) {
+	var settlement margin.FundingSettlement
+	var applyErr error
+	if err := r.Record(CommandFunding, fundingCommand{Symbol: symbol, Rate: rate}, func() {
+		settlement, applyErr = r.engine.MarginSystem().SettleFunding(symbol, rate)
+	}); err != nil {
+		return margin.FundingSettlement{}, err
+	}
+	return settlement, applyErr
+}
+
+// Quiesce run fn between two commands, with the sequence of the last one applied and the log synced,
+// then inside api.Server.Quiesce: a snapshot taken by fn covers exactly the entries up to sequence.
+// implements snapshot.CommandLog
+func (r *Recorder) Quiesce(fn func(sequence uint64)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.log.Sync(); err != nil {
+		logger.Default().Warn("write-ahead log sync before snapshot failed", "error", err)
+	}
+	sequence := r.log.LastSequence()
+	r.server.Quiesce(func() { fn(sequence) })
+}
+
+// Prune drop the log segments covered by the snapshot of sequence. implements snapshot.CommandLog
+func (r *Recorder) Prune(sequence uint64) error {
+	return r.log.Prune(sequence)
+}
+
+// Replay apply every entry after sequence (that of the restored snapshot, 0 for none) and return how
+// many. call once the engine started and before taking traffic. a command that failed when recorded
+// fails the same way again, only an entry that can not be decoded stops the replay
+func (r *Recorder) Replay(ctx context.Context, after uint64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.log.SkipTo(after); err != nil {
+		return 0, err
+	}
+	replayed := 0
+	err := r.log.Replay(after, func(entry Entry) error {
+		if err := r.apply(ctx, entry); err != nil {
+			return fmt.Errorf("replay entry %d (%s): %w", entry.Sequence, entry.Type, err)
+		}
+		replayed++
+		return nil
+	})
+	return replayed, err
+}
+
+// apply the command of entry without logging it, its own failure ignored
+func (r *Recorder) apply(ctx context.Context, entry Entry) error {
+	ms := r.engine.MarginSystem()
+	var err error
+	switch entry.Type {
+	case api.CommandPlaceOrder:
+		var command api.PlaceOrderCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _ = r.server.ApplyPlaceOrder(ctx, command)
+		}
+	case api.CommandCancelOrder:
+		var command api.CancelOrderCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _ = r.server.ApplyCancelOrder(command)
+		}
+	case CommandCreateAccount:
+		var command accountCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _ = ms.CreateAccount(command.UserID)
+		}
+	case CommandDeposit:
+		var command accountCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_ = ms.Deposit(command.UserID, command.Amount)
+		}
+	case CommandWithdraw:
+		var command accountCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_ = ms.Withdraw(command.UserID, command.Amount)
+		}
+	case CommandOpenPosition:
+		var command positionCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _ = r.openPosition(ctx, command)
+		}
+	case CommandClosePosition:
+		var command positionCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _, _ = r.engine.ClosePosition(ctx, command.UserID, command.Symbol, command.Side, command.Price)
+		}
+	case CommandMarkPrice:
+		var command markPriceCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _ = r.engine.UpdateMarkPrice(ctx, command.Symbol, command.Price)
+		}
+	case CommandFunding:
+		var command fundingCommand
+		if err = json.Unmarshal(entry.Data, &command); err == nil {
+			_, _ = ms.SettleFunding(command.Symbol, command.Rate)
+		}
+	default:
+		return fmt.Errorf("unknown command type %q", entry.Type)
+	}
+	return err
+}
+
+func (r *Recorder) openPosition(ctx context.Context, command positionCommand) (*position.Position, error) {
+	return r.engine.OpenPosition(ctx, command.MarginMode, command.UserID, command.Symbol, command.Side,
+		command.Price, command.Size, command.Leverage)
+}
@@ -0,0 +1,207 @@
+package wal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"frizo/futures_engine/internal/api"
+	"frizo/futures_engine/internal/common"
+	"frizo/futures_engine/internal/engine"
+	"frizo/futures_engine/internal/position"
+	"frizo/futures_engine/internal/snapshot"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	symbols = []string{"BTCUSDT", "ETHUSDT"}
+	prices  = map[string]float64{"BTCUSDT": 50000, "ETHUSDT": 3000}
+	traders = []string{"u0", "u1", "u2"} // order traffic
+	holders = []string{"h0", "h1"}       // positions opened through the recorder
+)
+
+// node one engine process: engine, API server, snapshots and the recorder over its log
+type node struct {
+	engine    *engine.FuturesEngine
+	server    *api.Server
+	snapshots *snapshot.SnapshotManager
+	log       *Log
+	recorder  *Recorder
+}
+
+// startNode start a node on dir the way main does: restore the newest snapshot, start the engine, open
+// the log and replay what the snapshot does not cover. return the entries replayed
+func startNode(t *testing.T, dir string) (*node, int) {
+	e, err := engine.NewFuturesEngine(&engine.Config{Symbols: symbols})
+	require.NoError(t, err)
+	n := &node{engine: e, server: api.NewServer(e, "", nil, nil)}
+	n.snapshots, err = snapshot.NewSnapshotManager(e, n.server, &snapshot.Config{Dir: filepath.Join(dir, "snapshots"), Keep: 2})
+	require.NoError(t, err)
+
+	var after uint64
+	restored, _, err := snapshot.Load(filepath.Join(dir, "snapshots"))
+	if err == nil {
+		require.NoError(t, n.snapshots.Restore(restored))
+		after = restored.Sequence
+	} else {
+		require.ErrorIs(t, err, snapshot.ErrNoSnapshot)
+	}
+	ctx := context.Background()
+	require.NoError(t, e.Start(ctx))
+
+	n.log, err = Open(filepath.Join(dir, "wal"), &Config{SegmentSize: 16 << 10})
+	require.NoError(t, err)
+	n.recorder = NewRecorder(n.log, e, n.server)
+	n.snapshots.SetCommandLog(n.recorder)
+	replayed, err := n.recorder.Replay(ctx, after)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = n.server.Shutdown(ctx)
+		_ = n.log.Close()
+		_ = e.Close()
+	})
+	return n, replayed
+}
+
+// workload concurrent order traffic of the traders, and in one goroutine (account and position fields are
+// read unlocked by the margin checks) deposits, positions, mark prices and funding of the holders
+func workload(n *node, steps int, seed int64) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i, userID := range traders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed + int64(i)))
+			var resting []string
+			for step := 0; step < steps; step++ {
+				symbol := symbols[rng.Intn(len(symbols))]
+				if len(resting) > 0 && rng.Intn(3) == 0 {
+					j := rng.Intn(len(resting))
+					_, _ = n.server.CancelOrder(ctx, userID, resting[j])
+					resting = append(resting[:j], resting[j+1:]...)
+					continue
+				}
+				side := "buy"
+				if rng.Intn(2) == 0 {
+					side = "sell"
+				}
+				resp, err := n.server.PlaceOrder(ctx, api.PlaceOrderRequest{UserID: userID, Symbol: symbol, Side: side,
+					Price: math.Round(prices[symbol] * (1 + (rng.Float64()-0.5)/100)), Size: 0.1, Leverage: 10})
+				if err == nil && resp.Order.Size > 0 {
+					resting = append(resting, resp.Order.ID)
+				}
+			}
+		}()
+	}
+
+	rng := rand.New(rand.NewSource(seed - 1))
+	for step := 0; step < steps; step++ {
+		symbol := symbols[rng.Intn(len(symbols))]
+		price := math.Round(prices[symbol] * (1 + (rng.Float64()-0.5)/50))
+		i := rng.Intn(len(holders))
+		side := position.LONG
+		if i%2 == 1 {
+			side = position.SHORT // one-way mode: every holder keeps to one side
+		}
+		switch rng.Intn(6) {
+		case 0:
+			_, _ = n.recorder.UpdateMarkPrice(ctx, symbol, price)
+		case 1:
+			_, _ = n.recorder.SettleFunding(symbol, 0.0001)
+		case 2:
+			_ = n.recorder.Deposit(holders[i], 100)
+		case 3:
+			_, _, _ = n.recorder.ClosePosition(ctx, holders[i], symbol, side, price)
+		default:
+			_, _ = n.recorder.OpenPosition(ctx, common.ISOLATED, holders[i], symbol, side, price, 0.01, 10)
+		}
+	}
+	wg.Wait()
+}
+
+// state what must survive the crash. position ids and times are regenerated on replay, positions are
+// keyed by user, symbol and side
+type state struct {
+	balances  map[string][3]float64 // balance, order margin, realized PnL
+	positions map[string][3]float64 // size, entry price, initial margin
+	books     string                // JSON of the books, order ids and times included
+	orders    map[string]uint64     // order event sequences
+	sequence  uint64
+}
+
+func captureState(t *testing.T, n *node) state {
+	s := state{balances: make(map[string][3]float64), positions: make(map[string][3]float64)}
+	for _, account := range n.engine.MarginSystem().ExportState().Accounts {
+		s.balances[account.UserID] = [3]float64{account.Balance, account.OrderMargin, account.RealizedPnL}
+	}
+	for _, pos := range n.engine.PositionManager().ExportState().Positions {
+		s.positions[fmt.Sprintf("%s/%s/%d", pos.UserID, pos.Symbol, pos.Side)] = [3]float64{pos.Size, pos.EntryPrice, pos.InitialMargin}
+	}
+	trading := n.server.ExportState()
+	books, err := json.Marshal(trading.Books)
+	require.NoError(t, err)
+	s.books, s.orders, s.sequence = string(books), trading.OrderSequences, n.log.LastSequence()
+	return s
+}
+
+func TestRecoveryAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	reference, replayed := startNode(t, dir)
+	assert.Zero(t, replayed, "first start")
+
+	for _, userID := range append(append([]string{}, traders...), holders...) {
+		_, err := reference.recorder.CreateAccount(userID)
+		require.NoError(t, err)
+		require.NoError(t, reference.recorder.Deposit(userID, 1_000_000))
+	}
+	workload(reference, 200, 1)
+	_, err := reference.snapshots.Save()
+	require.NoError(t, err)
+	workload(reference, 200, 100) // the tail the snapshot does not cover
+
+	// killed mid-write: every acknowledged command is durable, the record being written is torn
+	want := captureState(t, reference)
+	require.NotEmpty(t, want.positions)
+	require.Greater(t, len(want.books), len("[]"))
+	segments, err := listSegments(filepath.Join(dir, "wal"))
+	require.NoError(t, err)
+	tail, err := os.OpenFile(filepath.Join(dir, "wal", segments[len(segments)-1].name), os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = tail.Write([]byte{120, 0, 0, 0, 9, 9, 9, 9, '{', '"'})
+	require.NoError(t, err)
+	require.NoError(t, tail.Close())
+
+	restarted, replayed := startNode(t, dir)
+	assert.Greater(t, replayed, 0, "the tail after the snapshot replayed")
+	assert.Less(t, uint64(replayed), want.sequence, "the snapshot covers the head")
+	got := captureState(t, restarted)
+
+	assert.Equal(t, want.sequence, got.sequence)
+	require.Equal(t, len(want.balances), len(got.balances))
+	for userID, balance := range want.balances {
+		for i := range balance {
+			assert.InDelta(t, balance[i], got.balances[userID][i], 1e-6, "%s field %d", userID, i)
+		}
+	}
+	require.Equal(t, len(want.positions), len(got.positions))
+	for key, pos := range want.positions {
+		for i := range pos {
+			assert.InDelta(t, pos[i], got.positions[key][i], 1e-6, "%s field %d", key, i)
+		}
+	}
+	assert.JSONEq(t, want.books, got.books)
+	assert.Equal(t, want.orders, got.orders)
+
+	// the restarted node keeps logging after the replayed entries
+	require.NoError(t, restarted.recorder.Deposit("u0", 1))
+	assert.Equal(t, want.sequence+1, restarted.log.LastSequence())
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Debounce returns a wrapper that calls fn with the latest argument once no call came in for d.
+// every call within d restarts the timer. fn runs on its own goroutine. Safe for concurrent use.
+func Debounce[T any](fn func(T), d time.Duration) func(T) {
+	var (
+		mu    sync.Mutex
+		timer *time.Timer
+		last  T
+	)
+	return func(arg T) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		last = arg
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(d, func() {
+			mu.Lock()
+			arg := last
+			mu.Unlock()
+			fn(arg)
+		})
+	}
+}
+
+// Throttle returns a wrapper that calls fn right away, then drops every call for d.
+// fn runs on the caller's goroutine. Safe for concurrent use.
+func Throttle[T any](fn func(T), d time.Duration) func(T) {
+	var (
+		mu   sync.Mutex
+		next time.Time // calls before it are dropped
+	)
+	return func(arg T) {
+		mu.Lock()
+		now := time.Now()
+		if now.Before(next) {
+			mu.Unlock()
+			return
+		}
+		next = now.Add(d)
+		mu.Unlock()
+
+		fn(arg)
+	}
+}
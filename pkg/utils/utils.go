@@ -84,4 +84,28 @@ func Filter[T any](slice []T, predicate func(T) bool) []T {
 		}
 	}
 	return result
-}
\ No newline at end of file
+}
+
+// Must returns v, panics if err is not nil.
+// Only for main and init functions, never in business logic.
+func Must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// MustNoError panics if err is not nil. Only for main and init functions.
+func MustNoError(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// MustWithMessage like Must, the panic value is an error wrapping err with msg.
+func MustWithMessage[T any](v T, err error, msg string) T {
+	if err != nil {
+		panic(fmt.Errorf("%s: %w", msg, err))
+	}
+	return v
+}
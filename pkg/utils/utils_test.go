@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestStringPtr(t *testing.T) {
@@ -128,4 +133,105 @@ func TestFilter(t *testing.T) {
 			t.Errorf("Filter() result[%d] = %v, want %v", i, v, expected[i])
 		}
 	}
-}
\ No newline at end of file
+}
+
+// recoverPanic runs fn and returns the recovered panic value
+func recoverPanic(fn func()) (recovered any) {
+	defer func() {
+		recovered = recover()
+	}()
+	fn()
+	return nil
+}
+
+func TestMust(t *testing.T) {
+	if v := Must(42, nil); v != 42 {
+		t.Errorf("Must() = %v, want %v", v, 42)
+	}
+
+	errBoom := errors.New("boom")
+	recovered := recoverPanic(func() { Must(0, errBoom) })
+	if recovered != errBoom {
+		t.Errorf("Must() panic = %v, want %v", recovered, errBoom)
+	}
+}
+
+func TestMustNoError(t *testing.T) {
+	if recovered := recoverPanic(func() { MustNoError(nil) }); recovered != nil {
+		t.Errorf("MustNoError(nil) panicked: %v", recovered)
+	}
+
+	if recovered := recoverPanic(func() { MustNoError(errors.New("boom")) }); recovered == nil {
+		t.Error("MustNoError() did not panic on error")
+	}
+}
+
+func TestMustWithMessage(t *testing.T) {
+	if v := MustWithMessage("ok", nil, "load config"); v != "ok" {
+		t.Errorf("MustWithMessage() = %v, want %v", v, "ok")
+	}
+
+	errBoom := errors.New("boom")
+	recovered := recoverPanic(func() { MustWithMessage(0, errBoom, "load config") })
+
+	err, ok := recovered.(error)
+	if !ok {
+		t.Fatalf("MustWithMessage() panic value %v is not an error", recovered)
+	}
+	if !strings.Contains(err.Error(), "load config") {
+		t.Errorf("MustWithMessage() panic = %q, want it to contain %q", err.Error(), "load config")
+	}
+	if !errors.Is(err, errBoom) {
+		t.Errorf("MustWithMessage() panic does not wrap the original error")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	var mu sync.Mutex
+	var calls []int
+	debounced := Debounce(func(v int) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, v)
+	}, 50*time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		debounced(i)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Skipf("calls took %s, longer than the 10ms burst", elapsed)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 1 {
+		t.Fatalf("Debounce() fired %d times, want 1", len(calls))
+	}
+	if calls[0] != 99 {
+		t.Errorf("Debounce() fired with %d, want the latest 99", calls[0])
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	var calls atomic.Int32
+	throttled := Throttle(func(int) { calls.Add(1) }, 50*time.Millisecond)
+
+	throttled(1)
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("Throttle() first call fired %d times, want immediately once", got)
+	}
+	for i := 0; i < 10; i++ {
+		throttled(i)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Throttle() fired %d times within d, want 1", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	throttled(2)
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Throttle() fired %d times after d, want 2", got)
+	}
+}
@@ -0,0 +1,347 @@
+// Package websocket is a minimal RFC 6455 implementation on top of net/http: server upgrade,
+// client dial, text/binary messages and ping/pong/close control frames. No extensions, no subprotocols.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// DefaultReadLimit is the largest message ReadMessage accepts unless SetReadLimit changed it.
+const DefaultReadLimit = 1 << 20
+
+// closeWriteWait bounds the close frame written by Close.
+const closeWriteWait = time.Second
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by ReadMessage after a close frame and by every call after Close.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is one websocket connection. ReadMessage must be called from a single goroutine,
+// the write methods are safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // frames written by a client are masked, frames read by one are not
+
+	readLimit   int64
+	pongHandler func(data []byte)
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, br: br, client: client, readLimit: DefaultReadLimit}
+}
+
+// Upgrade answers the websocket handshake of r and takes the connection over from the HTTP server.
+// On failure an HTTP error has already been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "websocket: method not allowed", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket: method not GET")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "websocket: bad handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: bad handshake")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket: hijacking not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response does not implement http.Hijacker")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err = conn.Write([]byte(response)); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: write handshake: %w", err)
+	}
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// Dial opens a client connection to a ws:// URL.
+func Dial(ctx context.Context, rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-WebSocket-Key":     {key},
+		"Sec-WebSocket-Version": {"13"},
+	}}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: write handshake: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: read handshake: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: handshake refused: %s", resp.Status)
+	}
+	return newConn(conn, br, true), nil
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts, larger messages fail the read.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPongHandler sets the function called by ReadMessage for every pong, e.g. to extend the read deadline.
+func (c *Conn) SetPongHandler(handler func(data []byte)) {
+	c.pongHandler = handler
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and pongs handed to the
+// pong handler on the way. A close frame from the peer is echoed and ErrClosed returned.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err = c.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			_ = c.Close()
+			return 0, nil, ErrClosed
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, errors.New("websocket: new message inside a fragmented one")
+			}
+			messageType = opcode
+		case 0: // continuation of a fragmented message
+			if messageType == 0 {
+				return 0, nil, errors.New("websocket: continuation frame without a message")
+			}
+		default:
+			return 0, nil, fmt.Errorf("websocket: unexpected opcode %d", opcode)
+		}
+
+		if int64(len(data)+len(payload)) > c.readLimit {
+			return 0, nil, fmt.Errorf("websocket: message exceeds read limit %d", c.readLimit)
+		}
+		data = append(data, payload...)
+		if fin {
+			return messageType, data, nil
+		}
+	}
+}
+
+// WriteMessage writes data as one frame of messageType.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(messageType, data)
+}
+
+// Close sends a normal closure frame and closes the connection. The frame is skipped when a write is
+// in flight, closing the connection unblocks that write. Safe to call more than once.
+func (c *Conn) Close() error {
+	err := ErrClosed
+	c.closeOnce.Do(func() {
+		if c.writeMu.TryLock() {
+			_ = c.conn.SetWriteDeadline(time.Now().Add(closeWriteWait))
+			_ = c.writeFrame(CloseMessage, []byte{0x03, 0xe8}) // 1000 normal closure
+			c.writeMu.Unlock()
+		}
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// ========================================================
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, errors.New("websocket: bad frame masking")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+	if length > c.readLimit {
+		return false, 0, nil, fmt.Errorf("websocket: message exceeds read limit %d", c.readLimit)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writeMu held
+func (c *Conn) writeFrame(opcode int, data []byte) error {
+	frame := make([]byte, 0, len(data)+14)
+	frame = append(frame, 0x80|byte(opcode))
+
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(data) < 126:
+		frame = append(frame, maskBit|byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(data)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(data)))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, data...)
+		maskBytes(mask, frame[start:])
+	} else {
+		frame = append(frame, data...)
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer echoes every message back, then closes after a "bye" message
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "bye" {
+				return
+			}
+			if err = conn.WriteMessage(messageType, data); err != nil {
+				t.Errorf("WriteMessage() error = %v", err)
+				return
+			}
+		}
+	}))
+}
+
+func dial(t *testing.T, server *httptest.Server) *Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestEcho(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	conn := dial(t, server)
+	defer conn.Close()
+
+	// every payload length encoding: 7 bit, 16 bit and 64 bit
+	for _, size := range []int{0, 10, 125, 126, 200, 65535, 70000} {
+		payload := bytes.Repeat([]byte{'x'}, size)
+		if err := conn.WriteMessage(BinaryMessage, payload); err != nil {
+			t.Fatalf("WriteMessage(%d) error = %v", size, err)
+		}
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage(%d) error = %v", size, err)
+		}
+		if messageType != BinaryMessage || !bytes.Equal(data, payload) {
+			t.Errorf("ReadMessage(%d) = type %d, %d bytes", size, messageType, len(data))
+		}
+	}
+}
+
+func TestPingPongAndClose(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	conn := dial(t, server)
+	defer conn.Close()
+
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) { pongs <- string(data) })
+	if err := conn.WriteMessage(PingMessage, []byte("keepalive")); err != nil {
+		t.Fatalf("WriteMessage(ping) error = %v", err)
+	}
+	if err := conn.WriteMessage(TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Fatalf("ReadMessage() = %q, %v", data, err)
+	}
+	if got := <-pongs; got != "keepalive" {
+		t.Errorf("pong = %q, want keepalive", got)
+	}
+
+	// the server closes: the close frame ends the read
+	if err := conn.WriteMessage(TextMessage, []byte("bye")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); !errors.Is(err, ErrClosed) {
+		t.Errorf("ReadMessage() after close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	if _, err = Dial(context.Background(), server.URL); err == nil {
+		t.Error("Dial(http://) want error")
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	conn := dial(t, server)
+	defer conn.Close()
+
+	conn.SetReadLimit(16)
+	if err := conn.WriteMessage(TextMessage, bytes.Repeat([]byte{'x'}, 17)); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil || !strings.Contains(err.Error(), "read limit") {
+		t.Errorf("ReadMessage() error = %v, want read limit error", err)
+	}
+}